@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newAccessLogger returns the slog logger used for access logs in the given
+// format: "json" or "combined" (Apache combined log format). It returns nil
+// for "off".
+func newAccessLogger(format string, w io.Writer) (*slog.Logger, error) {
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	case "combined":
+		return slog.New(&combinedHandler{w: w}), nil
+	case "off", "none", "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown access log format %q (want json, combined or off)", format)
+}
+
+// accessLog records one line per request, with the client's address as
+// -trusted-proxies give it. When logAssets is false, successful hits on
+// static files are skipped to keep the log readable.
+func accessLog(logger *slog.Logger, logAssets bool, proxies trustedProxies, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := sw.statusCode()
+		if !logAssets && status < 400 && isAssetPath(r.URL.Path) {
+			return
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("remote_ip", proxies.clientIP(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.RequestURI()),
+			slog.String("proto", r.Proto),
+			slog.Int("status", status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("referer", r.Referer()),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("request_id", r.Header.Get(requestIDHeader)),
+		)
+	})
+}
+
+// isAssetPath reports whether the path names a static file rather than a
+// page or API route.
+func isAssetPath(p string) bool {
+	ext := path.Ext(p)
+	return ext != "" && ext != ".html"
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusWriter captures the status code and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && !informational(code) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the wrapped writer's fast path for large files.
+func (w *statusWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, src)
+	w.bytes += n
+	return n, err
+}
+
+// informational reports whether code is a 1xx status, such as 103 Early
+// Hints, that precedes the real one. 101 Switching Protocols is final.
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// combinedHandler is a slog.Handler that renders access log records in the
+// Apache combined format so existing log tooling can parse them. The
+// request ID follows, quoted, where combined log parsers ignore it.
+type combinedHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (h *combinedHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *combinedHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *combinedHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *combinedHandler) Handle(_ context.Context, rec slog.Record) error {
+	f := map[string]slog.Value{}
+	rec.Attrs(func(a slog.Attr) bool {
+		f[a.Key] = a.Value
+		return true
+	})
+	str := func(key string) string {
+		if v, ok := f[key]; ok && v.String() != "" {
+			return v.String()
+		}
+		return "-"
+	}
+	bytes := str("bytes")
+	if bytes == "0" {
+		bytes = "-"
+	}
+
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %s %s %q %q %q\n",
+		str("remote_ip"),
+		rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		str("method"), escapeQuotes(str("path")), str("proto"),
+		str("status"), bytes,
+		str("referer"), str("user_agent"), str("request_id"),
+	)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line)
+	return err
+}
+
+func escapeQuotes(s string) string {
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
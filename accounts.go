@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Password limits. bcrypt ignores everything past 72 bytes, so longer
+// passwords are refused rather than silently truncated.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+	bcryptCost        = 11
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,16}$`)
+
+// dummyHash is compared against when a username doesn't exist, so login
+// takes as long for unknown users as for wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+	return h
+})
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accountsAPI serves /api/auth: username/password accounts with session
+// cookies or bearer tokens.
+type accountsAPI struct {
+	db       *storage.DB
+	sessions *sessionCache
+	filter   *contentFilter
+	proxies  trustedProxies // to audit failed logins by address
+}
+
+func (a *accountsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/auth/register", a.signup)
+	mux.HandleFunc("POST /api/auth/login", a.login)
+	mux.HandleFunc("POST /api/auth/logout", a.logout)
+	mux.HandleFunc("GET /api/auth/me", a.me)
+}
+
+func (a *accountsAPI) signup(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := decodeJSON(w, r, maxJSONBody, &c); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	c.Username = strings.TrimSpace(c.Username)
+	if !usernamePattern.MatchString(c.Username) {
+		writeError(w, http.StatusUnprocessableEntity, "username must be 3-16 letters, digits, _ or -")
+		return
+	}
+	if !a.filter.allow(w, r, "username", moderation.Name, c.Username) {
+		return
+	}
+	if len(c.Password) < minPasswordLength || len(c.Password) > maxPasswordLength {
+		writeError(w, http.StatusUnprocessableEntity, "password must be 8-72 bytes")
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcryptCost)
+	if err != nil {
+		a.fail(w, "hash password", err)
+		return
+	}
+	p, err := a.db.CreatePlayer(r.Context(), strings.ToLower(c.Username), c.Username, string(hash))
+	if errors.Is(err, storage.ErrUsernameTaken) {
+		writeError(w, http.StatusConflict, "username is taken")
+		return
+	}
+	if err != nil {
+		a.fail(w, "create player", err)
+		return
+	}
+	auditAccount(r, a.db, playerAudit(p.ID, "account.register", "password"))
+	a.signedIn(w, r, http.StatusCreated, p)
+}
+
+func (a *accountsAPI) login(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if err := decodeJSON(w, r, maxJSONBody, &c); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	p, hash, err := a.db.PlayerByUsername(r.Context(), strings.ToLower(strings.TrimSpace(c.Username)))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		a.fail(w, "get player", err)
+		return
+	}
+	failed := storage.AuditEntry{Actor: addressActor(a.proxies, r), Action: "account.login_failed"}
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(c.Password))
+		failed.Detail = "unknown username"
+		auditAccount(r, a.db, failed)
+		writeError(w, http.StatusUnauthorized, "wrong username or password")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(c.Password)) != nil {
+		failed.TargetKind, failed.TargetID, failed.Detail = "player", strconv.FormatInt(p.ID, 10), "wrong password"
+		auditAccount(r, a.db, failed)
+		writeError(w, http.StatusUnauthorized, "wrong username or password")
+		return
+	}
+	auditAccount(r, a.db, playerAudit(p.ID, "account.login", "password"))
+	a.signedIn(w, r, http.StatusOK, p)
+}
+
+// signedIn starts a session and responds with the player and token.
+func (a *accountsAPI) signedIn(w http.ResponseWriter, r *http.Request, status int, p storage.Player) {
+	token, expires, err := startSession(w, r, a.db, a.proxies, p)
+	if err != nil {
+		a.fail(w, "create session", err)
+		return
+	}
+	writeJSON(w, status, map[string]any{
+		"player":     p,
+		"token":      token,
+		"expires_at": expires.UTC(),
+	})
+}
+
+func (a *accountsAPI) logout(w http.ResponseWriter, r *http.Request) {
+	if token := sessionToken(r); token != "" {
+		hash := hashToken(token)
+		if err := a.db.DeleteSession(r.Context(), hash); err != nil {
+			a.fail(w, "delete session", err)
+			return
+		}
+		if err := a.sessions.forget(r.Context(), hash); err != nil {
+			a.fail(w, "forget session", err)
+			return
+		}
+		if p := currentPlayer(r); p != nil {
+			auditAccount(r, a.db, playerAudit(p.ID, "account.logout", ""))
+		}
+	}
+	clearSessionCookie(w, r, a.proxies)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *accountsAPI) me(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// auditAccount records a security-relevant account event in the audit
+// log. The request is answered either way, so a failure is only logged.
+func auditAccount(r *http.Request, db *storage.DB, e storage.AuditEntry) {
+	if err := db.RecordAudit(r.Context(), e); err != nil {
+		log.Printf("accounts: audit %s: %v", e.Action, err)
+	}
+}
+
+// playerAudit is an entry for something a player did to their own account.
+// Like privacy requests, it names them only by ID, which outlives the
+// account.
+func playerAudit(playerID int64, action, detail string) storage.AuditEntry {
+	id := strconv.FormatInt(playerID, 10)
+	return storage.AuditEntry{Actor: "player:" + id, Action: action, TargetKind: "player", TargetID: id, Detail: detail}
+}
+
+// addressActor names the client r came from as the actor of an audit
+// entry, hashed like the quotas' addresses.
+func addressActor(proxies trustedProxies, r *http.Request) string {
+	return "ip:" + hashToken(proxies.clientIP(r))
+}
+
+func (a *accountsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("accounts: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/achievement"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maxEventsPerReport caps a single POST /api/achievements/events batch.
+const maxEventsPerReport = 100
+
+// achievementJSON is an achievement as the trophy screen sees it. Percent
+// is only set in listings and the player fields only for signed-in
+// requests.
+type achievementJSON struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Goal        int64      `json:"goal"`
+	Hidden      bool       `json:"hidden,omitempty"`
+	Percent     *float64   `json:"percent,omitempty"`
+	Unlocked    *bool      `json:"unlocked,omitempty"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+	Progress    *int64     `json:"progress,omitempty"`
+}
+
+func newAchievementJSON(a achievement.Achievement) achievementJSON {
+	return achievementJSON{ID: a.ID, Name: a.Name, Description: a.Description, Goal: a.Goal, Hidden: a.Hidden}
+}
+
+// achievementsAPI serves /api/achievements.
+type achievementsAPI struct {
+	db *storage.DB
+}
+
+func (a *achievementsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/achievements", a.list)
+	mux.HandleFunc("POST /api/achievements/events", a.report)
+}
+
+// list returns every achievement with the share of players who have it
+// and, for a signed-in player, their own progress. Hidden achievements the
+// player hasn't unlocked have their name and description withheld.
+func (a *achievementsAPI) list(w http.ResponseWriter, r *http.Request) {
+	counts, players, err := a.db.AchievementCounts(r.Context())
+	if err != nil {
+		a.fail(w, "count achievements", err)
+		return
+	}
+	p := currentPlayer(r)
+	var unlocked map[string]time.Time
+	var stats map[string]int64
+	if p != nil {
+		if unlocked, err = a.db.PlayerAchievements(r.Context(), p.ID); err != nil {
+			a.fail(w, "get unlocks", err)
+			return
+		}
+		if stats, err = a.db.PlayerStats(r.Context(), p.ID); err != nil {
+			a.fail(w, "get stats", err)
+			return
+		}
+	}
+
+	out := make([]achievementJSON, 0, len(achievement.All))
+	for _, def := range achievement.All {
+		j := newAchievementJSON(def)
+		var percent float64
+		if players > 0 {
+			percent = math.Round(float64(counts[def.ID])/float64(players)*1000) / 10
+		}
+		j.Percent = &percent
+		at, has := unlocked[def.ID]
+		if p != nil {
+			progress := min(stats[def.Stat], def.Goal)
+			j.Unlocked, j.Progress = &has, &progress
+			if has {
+				j.UnlockedAt = &at
+			}
+		}
+		if def.Hidden && !has {
+			j.Name, j.Description = "???", "Hidden achievement."
+		}
+		out = append(out, j)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"achievements": out, "players": players})
+}
+
+// report records game events for the signed-in player and returns the
+// achievements they unlocked.
+func (a *achievementsAPI) report(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Events []achievement.Event `json:"events"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(body.Events) == 0 || len(body.Events) > maxEventsPerReport {
+		writeError(w, http.StatusUnprocessableEntity, "events must contain 1-100 events")
+		return
+	}
+	updates, err := achievement.Apply(body.Events)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	stats, err := a.db.UpdateStats(r.Context(), p.ID, updates, achievement.IsMax)
+	if err != nil {
+		a.fail(w, "update stats", err)
+		return
+	}
+	ids, err := a.db.UnlockAchievements(r.Context(), p.ID, achievement.Reached(stats))
+	if err != nil {
+		a.fail(w, "unlock achievements", err)
+		return
+	}
+	unlocked := make([]achievementJSON, 0, len(ids))
+	for _, def := range achievement.All {
+		for _, id := range ids {
+			if id == def.ID {
+				unlocked = append(unlocked, newAchievementJSON(def))
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"unlocked": unlocked, "stats": stats})
+}
+
+func (a *achievementsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("achievements: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/matchmaking"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// adminTokenHeader carries the shared admin token for scripts; the
+// dashboard keeps it in a cookie instead. Admins can also just sign in
+// with an account listed in -admins.
+const adminTokenHeader = "X-Admin-Token"
+
+// maxReasonLength caps moderation and report reasons.
+const maxReasonLength = 500
+
+// adminHandler is an admin endpoint. actor names who is acting, for the
+// audit log: "token" or "player:<username>".
+type adminHandler func(w http.ResponseWriter, r *http.Request, actor string)
+
+// adminAPI serves /api/admin: moderation of scores, levels, bans and the
+// report queue. Every change is written to the audit log.
+type adminAPI struct {
+	db     *storage.DB
+	token  string
+	admins []string // usernames
+
+	// For the dashboard's live stats.
+	hub   *lobby.Hub
+	queue *matchmaking.Queue
+
+	events      eventStore // nil with analytics off
+	announce    *announcer // nil without -announce-webhook
+	maintenance *maintenance
+	cdn         *cdnPurger     // nil without -cdn-purge
+	canary      *canaryRollout // nil without -canary
+	reloads     *reloader
+	proxies     trustedProxies // for client addresses and the dashboard cookie
+}
+
+func (a *adminAPI) register(mux *http.ServeMux) {
+	a.handle(mux, "GET /api/admin/stats", a.stats)
+	a.handle(mux, "GET /api/admin/scores", a.recentScores)
+	a.handle(mux, "GET /api/admin/scores/{id}", a.score)
+	a.handle(mux, "DELETE /api/admin/scores/{id}", a.deleteScore)
+	a.handle(mux, "POST /api/admin/scores/{id}/flag", a.flagScore(true))
+	a.handle(mux, "POST /api/admin/scores/{id}/unflag", a.flagScore(false))
+	a.handle(mux, "GET /api/admin/levels", a.unpublishedLevels)
+	a.handle(mux, "POST /api/admin/levels/import", a.importLevels)
+	a.handle(mux, "GET /api/admin/levels/{id}", a.level)
+	a.handle(mux, "POST /api/admin/levels/{id}/unpublish", a.publishLevel(false))
+	a.handle(mux, "POST /api/admin/levels/{id}/publish", a.publishLevel(true))
+	a.handle(mux, "POST /api/admin/levels/{id}/feature", a.featureLevel(true))
+	a.handle(mux, "POST /api/admin/levels/{id}/unfeature", a.featureLevel(false))
+	a.handle(mux, "GET /api/admin/links/{code}", a.shortLink)
+	a.handle(mux, "POST /api/admin/links/{code}/disable", a.disableShortLink)
+	a.handle(mux, "GET /api/admin/reports", a.reports)
+	a.handle(mux, "POST /api/admin/reports/{kind}/{id}/dismiss", a.dismiss)
+	a.handle(mux, "GET /api/admin/bans", a.bans)
+	a.handle(mux, "POST /api/admin/bans", a.ban)
+	a.handle(mux, "DELETE /api/admin/bans/{kind}/{value}", a.unban)
+	a.handle(mux, "GET /api/admin/chat", a.chatMessages)
+	a.handle(mux, "DELETE /api/admin/chat/{id}", a.deleteChatMessage)
+	a.handle(mux, "GET /api/admin/chat/mutes", a.chatMutes)
+	a.handle(mux, "POST /api/admin/chat/mutes", a.muteChat)
+	a.handle(mux, "DELETE /api/admin/chat/mutes/{id}", a.unmuteChat)
+	a.handle(mux, "GET /api/admin/shop", a.shopItems)
+	a.handle(mux, "PUT /api/admin/shop/{id}", a.putShopItem)
+	a.handle(mux, "DELETE /api/admin/shop/{id}", a.retireShopItem)
+	a.handle(mux, "GET /api/admin/players/{id}/wallet", a.playerWallet)
+	a.handle(mux, "GET /api/admin/players/{id}/wallet/transactions", a.playerTransactions)
+	a.handle(mux, "POST /api/admin/players/{id}/wallet/grant", a.adjustWallet(storage.TransactionGrant))
+	a.handle(mux, "POST /api/admin/players/{id}/wallet/revoke", a.adjustWallet(storage.TransactionRevoke))
+	a.handle(mux, "GET /api/admin/audit", a.audit)
+	a.handle(mux, "POST /api/admin/seasons", a.startSeason)
+	a.handle(mux, "POST /api/admin/tournaments", a.createTournament)
+	a.handle(mux, "DELETE /api/admin/tournaments/{id}", a.deleteTournament)
+	a.handle(mux, "GET /api/admin/inbox", a.inboxMessages)
+	a.handle(mux, "POST /api/admin/inbox", a.postInboxMessage)
+	a.handle(mux, "DELETE /api/admin/inbox/{id}", a.deleteInboxMessage)
+	a.handle(mux, "GET /api/admin/config", a.config)
+	a.handle(mux, "PUT /api/admin/config", a.setConfig)
+	a.handle(mux, "GET /api/admin/config/versions", a.configVersions)
+	a.handle(mux, "POST /api/admin/config/rollback", a.rollbackConfig)
+	a.handle(mux, "GET /api/admin/experiments", a.experiments)
+	a.handle(mux, "POST /api/admin/experiments", a.createExperiment)
+	a.handle(mux, "GET /api/admin/experiments/{name}", a.experiment)
+	a.handle(mux, "POST /api/admin/experiments/{name}/stop", a.stopExperiment)
+	a.handle(mux, "DELETE /api/admin/experiments/{name}", a.deleteExperiment)
+	if a.events != nil {
+		a.handle(mux, "GET /api/admin/analytics", a.analyticsSummary)
+	}
+	if a.cdn != nil {
+		a.handle(mux, "POST /api/admin/cdn/purge", a.purgeCDN)
+	}
+	a.handle(mux, "GET /api/admin/maintenance", a.showMaintenance)
+	a.handle(mux, "PUT /api/admin/maintenance", a.setMaintenance)
+	a.handle(mux, "DELETE /api/admin/maintenance", a.resetMaintenance)
+	if a.reloads != nil {
+		a.handle(mux, "POST /api/admin/reload", a.reloadConfig)
+	}
+	if a.canary != nil {
+		a.handle(mux, "GET /api/admin/canary", a.showCanary)
+		a.handle(mux, "PUT /api/admin/canary", a.setCanary)
+		a.handle(mux, "DELETE /api/admin/canary", a.resetCanary)
+	}
+	a.registerDashboard(mux)
+}
+
+// handle registers h behind the admin check.
+func (a *adminAPI) handle(mux *http.ServeMux, pattern string, h adminHandler) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := a.actor(r)
+		if !ok {
+			if currentPlayer(r) != nil || r.Header.Get(adminTokenHeader) != "" || hasCookie(r, adminCookie) {
+				writeError(w, http.StatusForbidden, "admin access required")
+			} else {
+				writeError(w, http.StatusUnauthorized, "admin token or sign-in required")
+			}
+			return
+		}
+		h(w, r, actor)
+	})
+}
+
+func (a *adminAPI) actor(r *http.Request) (string, bool) {
+	t := r.Header.Get(adminTokenHeader)
+	if c, err := r.Cookie(adminCookie); t == "" && err == nil {
+		t = c.Value
+	}
+	if t != "" {
+		return "token", a.token != "" && subtle.ConstantTimeCompare([]byte(t), []byte(a.token)) == 1
+	}
+	if p := currentPlayer(r); p != nil && slices.Contains(a.admins, p.Username) {
+		return "player:" + p.Username, true
+	}
+	return "", false
+}
+
+// stats is the dashboard's overview: request counters since start, live
+// multiplayer numbers and database totals. The dashboard polls it and
+// derives rates from successive totals.
+func (a *adminAPI) stats(w http.ResponseWriter, r *http.Request, _ string) {
+	counts, err := a.db.ModerationCounts(r.Context())
+	if err != nil {
+		a.fail(w, "count", err)
+		return
+	}
+	requests := map[string]float64{"total": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0}
+	for key, n := range httpRequests.snapshot() {
+		labels := splitKey(key) // route, code
+		requests["total"] += n
+		if len(labels) == 2 && len(labels[1]) == 3 {
+			requests[labels[1][:1]+"xx"] += n
+		}
+	}
+	var inFlight float64
+	for _, n := range httpInFlight.snapshot() {
+		inFlight += n
+	}
+	rooms, inRooms, connected := a.hub.Stats()
+	waiting, err := a.queue.Len(r.Context())
+	if err != nil {
+		a.fail(w, "matchmaking", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"started_at":  processStart.UTC(),
+		"uptime":      int64(time.Since(processStart).Seconds()),
+		"requests":    requests,
+		"in_flight":   inFlight,
+		"goroutines":  runtime.NumGoroutine(),
+		"lobby":       map[string]int{"connections": connected, "rooms": rooms, "in_rooms": inRooms, "spectators": a.hub.Spectators()},
+		"matchmaking": waiting,
+		"database":    counts,
+	})
+}
+
+// recentScores lists the latest submissions, flagged ones included, or
+// only flagged ones with ?flagged=true.
+func (a *adminAPI) recentScores(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f := storage.ScoreFilter{IncludeFlagged: true, Flagged: r.URL.Query().Get("flagged") == "true"}
+	scores, err := a.db.RecentScores(r.Context(), f, limit)
+	if err != nil {
+		a.fail(w, "recent scores", err)
+		return
+	}
+	out := make([]adminScore, 0, len(scores))
+	for _, s := range scores {
+		out = append(out, adminScore{s, s.StatusReason, s.PlayerTokenHash})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"scores": out})
+}
+
+// adminScore is a score with the fields only moderators see.
+type adminScore struct {
+	storage.Score
+	StatusReason    string `json:"status_reason,omitempty"`
+	PlayerTokenHash string `json:"player_token_hash,omitempty"`
+}
+
+func (a *adminAPI) score(w http.ResponseWriter, r *http.Request, _ string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s, err := a.db.Score(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "score not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get score", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminScore{s, s.StatusReason, s.PlayerTokenHash})
+}
+
+func (a *adminAPI) deleteScore(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	before, err := a.db.Score(r.Context(), id)
+	if !a.act(w, r, err, "score not found") {
+		return
+	}
+	if !a.act(w, r, a.db.DeleteScore(r.Context(), id), "score not found") {
+		return
+	}
+	a.resolved(w, r, id, storage.AuditEntry{Actor: actor, Action: "score.delete", TargetKind: storage.ReportScore, Detail: reason, Before: auditState(before)})
+}
+
+func (a *adminAPI) flagScore(flagged bool) adminHandler {
+	action := "score.unflag"
+	if flagged {
+		action = "score.flag"
+	}
+	return func(w http.ResponseWriter, r *http.Request, actor string) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		reason, ok := readReason(w, r)
+		if !ok {
+			return
+		}
+		s, err := a.db.Score(r.Context(), id)
+		if !a.act(w, r, err, "score not found") {
+			return
+		}
+		if !a.act(w, r, a.db.SetScoreFlagged(r.Context(), id, flagged), "score not found") {
+			return
+		}
+		e := storage.AuditEntry{
+			Actor: actor, Action: action, TargetKind: storage.ReportScore, TargetID: strconv.FormatInt(id, 10), Detail: reason,
+			Before: auditState(map[string]bool{"flagged": s.Flagged}),
+			After:  auditState(map[string]bool{"flagged": flagged}),
+		}
+		if flagged {
+			a.resolved(w, r, id, e)
+		} else {
+			a.done(w, r, e)
+		}
+	}
+}
+
+// adminLevel is a level with the fields only moderators see.
+type adminLevel struct {
+	storage.Level
+	PlayerTokenHash string `json:"player_token_hash,omitempty"`
+}
+
+func (a *adminAPI) level(w http.ResponseWriter, r *http.Request, _ string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	l, err := a.db.GetLevel(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "level not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get level", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminLevel{l, l.PlayerTokenHash})
+}
+
+// unpublishedLevels lists the levels moderators have hidden, newest first.
+func (a *adminAPI) unpublishedLevels(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	levels, total, err := a.db.ListLevels(r.Context(), storage.LevelQuery{Sort: storage.SortNewest, Limit: limit, Unpublished: true})
+	if err != nil {
+		a.fail(w, "list levels", err)
+		return
+	}
+	out := make([]adminLevel, 0, len(levels))
+	for _, l := range levels {
+		out = append(out, adminLevel{l, l.PlayerTokenHash})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"levels": out, "total": total})
+}
+
+// importLevels adds the levels of a classic level pack, sent as the body,
+// and reports on each.
+func (a *adminAPI) importLevels(w http.ResponseWriter, r *http.Request, actor string) {
+	q := r.URL.Query()
+	opt := levelImport{format: level.Format(q.Get("format")), pack: q.Get("pack"), author: q.Get("author"), dryRun: q.Get("dry_run") == "true"}
+	if opt.pack == "" {
+		opt.pack = "Classic"
+	}
+	if opt.author == "" {
+		opt.author = "Classic"
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("level pack must be at most %d bytes", maxImportBody))
+		return
+	}
+	results, err := importLevels(r.Context(), a.db, data, opt)
+	if results == nil && err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	imported := 0
+	for _, res := range results {
+		if res.Error == "" {
+			imported++
+		}
+	}
+	if !opt.dryRun && imported > 0 {
+		detail := fmt.Sprintf("%d levels from %s", imported, opt.pack)
+		if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "level.import", TargetKind: storage.ReportLevel, Detail: detail}); err != nil {
+			a.fail(w, "record audit", err)
+			return
+		}
+		log.Printf("🛡️  %s: level.import %s", actor, detail)
+	}
+	if err != nil {
+		a.fail(w, "import levels", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"levels": results, "imported": imported, "failed": len(results) - imported, "dry_run": opt.dryRun})
+}
+
+func (a *adminAPI) publishLevel(published bool) adminHandler {
+	action := "level.unpublish"
+	if published {
+		action = "level.publish"
+	}
+	return func(w http.ResponseWriter, r *http.Request, actor string) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		reason, ok := readReason(w, r)
+		if !ok {
+			return
+		}
+		l, err := a.db.GetLevel(r.Context(), id)
+		if !a.act(w, r, err, "level not found") {
+			return
+		}
+		if !a.act(w, r, a.db.SetLevelPublished(r.Context(), id, published), "level not found") {
+			return
+		}
+		e := storage.AuditEntry{
+			Actor: actor, Action: action, TargetKind: storage.ReportLevel, TargetID: strconv.FormatInt(id, 10), Detail: reason,
+			Before: auditState(map[string]bool{"unpublished": l.Unpublished}),
+			After:  auditState(map[string]bool{"unpublished": !published}),
+		}
+		if !published {
+			a.resolved(w, r, id, e)
+		} else {
+			a.done(w, r, e)
+		}
+	}
+}
+
+// featureLevel features a published level, announcing it the first time,
+// or stops featuring it.
+func (a *adminAPI) featureLevel(featured bool) adminHandler {
+	action := "level.unfeature"
+	if featured {
+		action = "level.feature"
+	}
+	return func(w http.ResponseWriter, r *http.Request, actor string) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		reason, ok := readReason(w, r)
+		if !ok {
+			return
+		}
+		changed, err := a.db.SetLevelFeatured(r.Context(), id, featured)
+		if !a.act(w, r, err, "level not found") {
+			return
+		}
+		// SetLevelFeatured reports whether it changed anything, which says
+		// what the level was before.
+		was := featured != changed
+		if changed && featured && a.announce != nil {
+			l, err := a.db.GetLevel(r.Context(), id)
+			if err != nil {
+				a.fail(w, "get level", err)
+				return
+			}
+			a.announce.level(l)
+		}
+		a.done(w, r, storage.AuditEntry{
+			Actor: actor, Action: action, TargetKind: storage.ReportLevel, TargetID: strconv.FormatInt(id, 10), Detail: reason,
+			Before: auditState(map[string]bool{"featured": was}),
+			After:  auditState(map[string]bool{"featured": featured}),
+		})
+	}
+}
+
+// shortLink returns a short link, disabled or not, with its level.
+func (a *adminAPI) shortLink(w http.ResponseWriter, r *http.Request, _ string) {
+	s, err := a.db.ShortLink(r.Context(), strings.ToLower(r.PathValue("code")))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "link not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get short link", err)
+		return
+	}
+	l, err := a.db.GetLevel(r.Context(), s.LevelID)
+	if err != nil {
+		a.fail(w, "get level", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		storage.ShortLink
+		Level adminLevel `json:"level"`
+	}{s, adminLevel{l, l.PlayerTokenHash}})
+}
+
+// disableShortLink stops an abusive code from opening its level. The code
+// is never handed out again; the level gets a new one the next time it is
+// fetched.
+func (a *adminAPI) disableShortLink(w http.ResponseWriter, r *http.Request, actor string) {
+	code := strings.ToLower(r.PathValue("code"))
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.DisableShortLink(r.Context(), code, actor), "link not found or already disabled") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "link.disable", TargetKind: "link", TargetID: code, Detail: reason})
+}
+
+// reports returns the moderation queue: reported scores and levels with
+// open reports, most reported first, each with the reported item.
+func (a *adminAPI) reports(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 20, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	items, total, err := a.db.ReportQueue(r.Context(), limit, offset)
+	if err != nil {
+		a.fail(w, "report queue", err)
+		return
+	}
+	type queued struct {
+		storage.ReportedItem
+		Target any `json:"target"`
+	}
+	out := make([]queued, 0, len(items))
+	for _, it := range items {
+		q := queued{ReportedItem: it}
+		var err error
+		switch it.Kind {
+		case storage.ReportScore:
+			var s storage.Score
+			if s, err = a.db.Score(r.Context(), it.TargetID); err == nil {
+				q.Target = adminScore{s, s.StatusReason, s.PlayerTokenHash}
+			}
+		case storage.ReportLevel:
+			var l storage.Level
+			if l, err = a.db.GetLevel(r.Context(), it.TargetID); err == nil {
+				l.Tiles = nil
+				q.Target = adminLevel{l, l.PlayerTokenHash}
+			}
+		}
+		// A target deleted since it was reported shows with a null target.
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			a.fail(w, "get reported item", err)
+			return
+		}
+		out = append(out, q)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":  out,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// dismiss closes a target's open reports without acting on it.
+func (a *adminAPI) dismiss(w http.ResponseWriter, r *http.Request, actor string) {
+	kind := r.PathValue("kind")
+	if kind != storage.ReportScore && kind != storage.ReportLevel {
+		writeError(w, http.StatusBadRequest, "kind must be score or level")
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	a.resolved(w, r, id, storage.AuditEntry{Actor: actor, Action: "reports.dismiss", TargetKind: kind, Detail: reason})
+}
+
+func (a *adminAPI) bans(w http.ResponseWriter, r *http.Request, _ string) {
+	bans, err := a.db.Bans(r.Context())
+	if err != nil {
+		a.fail(w, "list bans", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bans": bans})
+}
+
+// ban blocks an account or a player token from submitting anything. The
+// token can be given as the raw X-Player-Token or as the hash shown on
+// scores and levels.
+func (a *adminAPI) ban(w http.ResponseWriter, r *http.Request, actor string) {
+	var req struct {
+		PlayerID        int64  `json:"player_id"`
+		PlayerToken     string `json:"player_token"`
+		PlayerTokenHash string `json:"player_token_hash"`
+		Reason          string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := checkText("reason", req.Reason, maxReasonLength, false); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	b := storage.Ban{Reason: req.Reason, CreatedBy: actor}
+	switch {
+	case req.PlayerID > 0:
+		b.Kind, b.Value = storage.BanPlayer, strconv.FormatInt(req.PlayerID, 10)
+	case req.PlayerToken != "":
+		b.Kind, b.Value = storage.BanToken, hashToken(req.PlayerToken)
+	case len(req.PlayerTokenHash) == 64:
+		b.Kind, b.Value = storage.BanToken, strings.ToLower(req.PlayerTokenHash)
+	default:
+		writeError(w, http.StatusUnprocessableEntity, "one of player_id, player_token or player_token_hash is required")
+		return
+	}
+	b, err := a.db.CreateBan(r.Context(), b)
+	if err != nil {
+		a.fail(w, "create ban", err)
+		return
+	}
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "ban.create", TargetKind: b.Kind, TargetID: b.Value, Detail: b.Reason, After: auditState(b)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: ban.create %s %s", actor, b.Kind, b.Value)
+	writeJSON(w, http.StatusCreated, b)
+}
+
+func (a *adminAPI) unban(w http.ResponseWriter, r *http.Request, actor string) {
+	kind, value := r.PathValue("kind"), r.PathValue("value")
+	bans, err := a.db.Bans(r.Context())
+	if err != nil {
+		a.fail(w, "list bans", err)
+		return
+	}
+	var before json.RawMessage
+	if i := slices.IndexFunc(bans, func(b storage.Ban) bool { return b.Kind == kind && b.Value == value }); i >= 0 {
+		before = auditState(bans[i])
+	}
+	if !a.act(w, r, a.db.DeleteBan(r.Context(), kind, value), "ban not found") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "ban.delete", TargetKind: kind, TargetID: value, Before: before})
+}
+
+func (a *adminAPI) audit(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	query := storage.AuditQuery{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetKind: q.Get("target_kind"),
+		TargetID:   q.Get("target_id"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+	if query.Since, err = queryTime(r, "since"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Until, err = queryTime(r, "until"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, total, err := a.db.AuditLog(r.Context(), query)
+	if err != nil {
+		a.fail(w, "audit log", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// queryTime reads an optional RFC 3339 time from the query string.
+func queryTime(r *http.Request, key string) (time.Time, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time such as 2026-01-02T15:04:05Z", key)
+	}
+	return t, nil
+}
+
+// auditState encodes v as the before or after payload of an audit entry.
+func auditState(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("admin: audit state: %v", err)
+		return nil
+	}
+	return b
+}
+
+// act reports whether a moderation write succeeded, writing the error
+// response if not.
+func (a *adminAPI) act(w http.ResponseWriter, r *http.Request, err error, notFound string) bool {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, notFound)
+		return false
+	case err != nil:
+		a.fail(w, "moderate", err)
+		return false
+	}
+	return true
+}
+
+// resolved closes the open reports of e's target, id, then audits e.
+func (a *adminAPI) resolved(w http.ResponseWriter, r *http.Request, id int64, e storage.AuditEntry) {
+	if _, err := a.db.ResolveReports(r.Context(), e.TargetKind, id); err != nil {
+		a.fail(w, "resolve reports", err)
+		return
+	}
+	e.TargetID = strconv.FormatInt(id, 10)
+	a.done(w, r, e)
+}
+
+// done records e in the audit log and responds 204.
+func (a *adminAPI) done(w http.ResponseWriter, r *http.Request, e storage.AuditEntry) {
+	if err := a.db.RecordAudit(r.Context(), e); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: %s %s %s", e.Actor, e.Action, e.TargetKind, e.TargetID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("admin: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// readReason reads an optional {"reason": "..."} body.
+func readReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.ContentLength == 0 {
+		return "", true
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return "", false
+	}
+	if err := checkText("reason", body.Reason, maxReasonLength, false); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return "", false
+	}
+	return body.Reason, true
+}
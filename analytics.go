@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/analytics"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maxEventsPerBatch caps a single POST /api/events batch.
+const maxEventsPerBatch = 100
+
+var analyticsEvents = defaultRegistry.counter("loderunner_analytics_events_total",
+	"Analytics events accepted, by type.", "type")
+
+// eventStore is where POST /api/events writes: the database, or an NDJSON
+// file for piping into other tools.
+type eventStore interface {
+	record(ctx context.Context, events []analytics.Event) error
+	summary(ctx context.Context, since, until time.Time) (analytics.Summary, error)
+	Close() error
+	String() string
+}
+
+// openEventStore opens the store -analytics names. It returns nil when
+// analytics are off, or when they go to the database and there is none.
+func openEventStore(cfg config, db *storage.DB) (eventStore, error) {
+	switch {
+	case cfg.Analytics == "off":
+		return nil, nil
+	case cfg.Analytics == "db":
+		if db == nil {
+			return nil, nil
+		}
+		return dbEvents{db}, nil
+	default:
+		path := strings.TrimPrefix(cfg.Analytics, "file:")
+		f, err := analytics.OpenFile(path, int64(cfg.AnalyticsRotateMB)<<20, cfg.AnalyticsKeep)
+		if err != nil {
+			return nil, fmt.Errorf("analytics: %w", err)
+		}
+		return fileEvents{f, path}, nil
+	}
+}
+
+type dbEvents struct {
+	db *storage.DB
+}
+
+func (s dbEvents) record(ctx context.Context, events []analytics.Event) error {
+	rows := make([]storage.AnalyticsEvent, 0, len(events))
+	for _, e := range events {
+		rows = append(rows, storage.AnalyticsEvent{
+			Type: e.Type, Session: e.Session, Level: e.Level, Difficulty: e.Difficulty,
+			DurationMS: e.DurationMS, Cause: e.Cause, Bucket: e.Bucket, CreatedAt: e.Time,
+		})
+	}
+	return s.db.RecordEvents(ctx, rows)
+}
+
+func (s dbEvents) summary(ctx context.Context, since, until time.Time) (analytics.Summary, error) {
+	t := analytics.NewTally(since, until)
+	err := s.db.EachEvent(ctx, since, until, func(e storage.AnalyticsEvent) error {
+		t.Add(analytics.Event{
+			Type: e.Type, Session: e.Session, Level: e.Level, Difficulty: e.Difficulty,
+			DurationMS: e.DurationMS, Cause: e.Cause, Bucket: e.Bucket, Time: e.CreatedAt,
+		})
+		return nil
+	})
+	return t.Summary(), err
+}
+
+// Close leaves the database to its owner.
+func (s dbEvents) Close() error   { return nil }
+func (s dbEvents) String() string { return "the database" }
+
+type fileEvents struct {
+	*analytics.File
+	path string
+}
+
+func (s fileEvents) record(_ context.Context, events []analytics.Event) error {
+	return s.Write(events)
+}
+
+func (s fileEvents) summary(_ context.Context, since, until time.Time) (analytics.Summary, error) {
+	return s.Summary(since, until)
+}
+
+func (s fileEvents) String() string { return s.path }
+
+// eventsAPI serves POST /api/events, the game's own analytics.
+type eventsAPI struct {
+	store eventStore
+}
+
+func (a *eventsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/events", a.ingest)
+}
+
+// ingest records a batch of events. Clients send one every so often and
+// when the page is hidden, with fetch or navigator.sendBeacon.
+func (a *eventsAPI) ingest(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Events []analytics.Event `json:"events"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(body.Events) == 0 || len(body.Events) > maxEventsPerBatch {
+		writeError(w, http.StatusUnprocessableEntity, "events must contain 1-100 events")
+		return
+	}
+	events, err := analytics.Clean(body.Events, time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err := a.store.record(r.Context(), events); err != nil {
+		log.Printf("analytics: record: %v (request %s)", err, requestID(w))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	for _, e := range events {
+		analyticsEvents.inc(e.Type)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// maxAnalyticsDays bounds how far back a summary may reach.
+const maxAnalyticsDays = 90
+
+// analyticsSummary summarizes the last ?days=N days of events (default 7).
+func (a *adminAPI) analyticsSummary(w http.ResponseWriter, r *http.Request, _ string) {
+	days, err := queryInt(r, "days", 7, 1, maxAnalyticsDays)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	until := time.Now().UTC()
+	since := until.AddDate(0, 0, -days)
+	s, err := a.events.summary(r.Context(), since, until)
+	if err != nil {
+		a.fail(w, "analytics summary", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
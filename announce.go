@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	// announceQueue is how many announcements may wait per webhook before
+	// new ones are dropped.
+	announceQueue = 64
+
+	// A webhook gets announceAttempts tries, waiting announceBackoff,
+	// then twice that and so on, but at most announceMaxWait even when
+	// the server asks for longer.
+	announceAttempts = 5
+	announceBackoff  = 2 * time.Second
+	announceMaxWait  = time.Minute
+)
+
+var announcementsSent = defaultRegistry.counter("loderunner_announcements_total",
+	"Webhook announcements, by kind and result.", "kind", "result")
+
+// announcement is a message for the community's chat channels, formatted
+// for each webhook's service.
+type announcement struct {
+	kind   string // record or level
+	title  string
+	text   string
+	url    string // optional
+	fields [][2]string
+	time   time.Time
+}
+
+// announceHook is one -announce-webhook with its own queue, so a slow
+// or failing service doesn't hold up the others.
+type announceHook struct {
+	url     string
+	discord bool
+	queue   chan announcement
+}
+
+func (h *announceHook) String() string {
+	if u, err := url.Parse(h.url); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+// announcer posts new world records and featured levels to Discord and
+// Slack webhooks in the background. The same player's records, or the same
+// level, are only announced once per dedupe window, so a player improving
+// on their own record again and again doesn't flood the channel.
+type announcer struct {
+	hooks     []*announceHook
+	client    *http.Client
+	publicURL string
+	dedupe    time.Duration
+
+	mu   sync.Mutex
+	sent map[string]time.Time // dedupe key to when it was announced
+
+	workers sync.WaitGroup
+}
+
+// newAnnouncer returns nil when no -announce-webhook is set.
+func newAnnouncer(cfg config) *announcer {
+	if len(cfg.AnnounceWebhooks) == 0 {
+		return nil
+	}
+	a := &announcer{
+		client:    &http.Client{Timeout: 15 * time.Second},
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		dedupe:    cfg.AnnounceDedupe,
+		sent:      map[string]time.Time{},
+	}
+	for _, u := range cfg.AnnounceWebhooks {
+		a.hooks = append(a.hooks, &announceHook{url: u, discord: isDiscordWebhook(u), queue: make(chan announcement, announceQueue)})
+	}
+	return a
+}
+
+// isDiscordWebhook tells Discord's webhooks apart. Everything else gets
+// Slack's format, which Mattermost, Rocket.Chat and others accept too.
+func isDiscordWebhook(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	discord := host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+	// Discord's /slack endpoint takes Slack's format.
+	return discord && !strings.HasSuffix(u.Path, "/slack")
+}
+
+func (a *announcer) start() {
+	for _, h := range a.hooks {
+		a.workers.Add(1)
+		go func() {
+			defer a.workers.Done()
+			for m := range h.queue {
+				a.deliver(h, m)
+			}
+		}()
+	}
+}
+
+// close sends what is still queued, waiting until ctx is done.
+func (a *announcer) close(ctx context.Context) {
+	for _, h := range a.hooks {
+		close(h.queue)
+	}
+	done := make(chan struct{})
+	go func() {
+		a.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// announce queues m for every webhook unless key was announced within the
+// dedupe window.
+func (a *announcer) announce(key string, m announcement) {
+	now := time.Now()
+	a.mu.Lock()
+	for k, at := range a.sent {
+		if now.Sub(at) >= a.dedupe {
+			delete(a.sent, k)
+		}
+	}
+	_, recent := a.sent[key]
+	if !recent && a.dedupe > 0 {
+		a.sent[key] = now
+	}
+	a.mu.Unlock()
+	if recent {
+		announcementsSent.inc(m.kind, "duplicate")
+		return
+	}
+	m.time = now.UTC()
+	for _, h := range a.hooks {
+		select {
+		case h.queue <- m:
+		default:
+			log.Printf("⚠️  Announcement queue for %s full, dropping a %s announcement", h, m.kind)
+		}
+	}
+}
+
+// record announces s, the new best score of all.
+func (a *announcer) record(s storage.Score) {
+	key := scoreOwner(s)
+	if key == "" {
+		key = "name:" + s.Player
+	}
+	m := announcement{
+		kind:  "record",
+		title: "🏆 New world record",
+		text:  fmt.Sprintf("%s scored %d points on level %d.", s.Player, s.Score, s.Level),
+		fields: [][2]string{
+			{"Score", strconv.FormatInt(s.Score, 10)},
+			{"Level", strconv.Itoa(s.Level)},
+			{"Time", formatRunTime(s.Time)},
+		},
+	}
+	if s.Difficulty != "" {
+		m.fields = append(m.fields, [2]string{"Difficulty", s.Difficulty})
+	}
+	if a.publicURL != "" {
+		m.url = a.publicURL + "/"
+		if s.Seed != "" {
+			m.url += "?" + url.Values{"seed": {s.Seed}, "diff": {s.Difficulty}}.Encode()
+		}
+	}
+	a.announce("record:"+key, m)
+}
+
+// level announces l, newly featured.
+func (a *announcer) level(l storage.Level) {
+	m := announcement{
+		kind:  "level",
+		title: "⭐ Featured level: " + l.Title,
+		text:  "A community level by " + l.Author + ".",
+		fields: [][2]string{
+			{"Gold", strconv.Itoa(l.Gold)},
+			{"Guards", strconv.Itoa(l.Guards)},
+		},
+	}
+	if l.Description != "" {
+		m.text += "\n" + l.Description
+	}
+	if a.publicURL != "" {
+		m.url = a.publicURL + "/"
+	}
+	a.announce("level:"+strconv.FormatInt(l.ID, 10), m)
+}
+
+// formatRunTime shows a run's milliseconds as m:ss.s.
+func formatRunTime(ms int64) string {
+	return fmt.Sprintf("%d:%04.1f", ms/60000, float64(ms%60000)/1000)
+}
+
+// deliver posts m to h, retrying with backoff when the service is down or
+// rate limits us.
+func (a *announcer) deliver(h *announceHook, m announcement) {
+	body, err := json.Marshal(announcePayload(h.discord, m))
+	if err != nil {
+		log.Printf("announce: %v", err)
+		return
+	}
+	wait := announceBackoff
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := a.post(h.url, body)
+		if err == nil {
+			announcementsSent.inc(m.kind, "ok")
+			return
+		}
+		if retryAfter < 0 || attempt == announceAttempts {
+			announcementsSent.inc(m.kind, "error")
+			log.Printf("announce: %s to %s: %v", m.kind, h, err)
+			return
+		}
+		time.Sleep(min(max(wait, retryAfter), announceMaxWait))
+		wait *= 2
+	}
+}
+
+// post sends one request. On failure, retryAfter is how long the service
+// asked us to wait, 0 for the usual backoff, or negative when retrying
+// can't help.
+func (a *announcer) post(hook string, body []byte) (retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// Discord sends fractional seconds.
+		secs, _ := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		return time.Duration(secs * float64(time.Second)), err
+	case resp.StatusCode >= 500:
+		return 0, err
+	}
+	return -1, err
+}
+
+// announcePayload builds Discord's or Slack's JSON for m.
+func announcePayload(discord bool, m announcement) any {
+	if discord {
+		type field struct {
+			Name   string `json:"name"`
+			Value  string `json:"value"`
+			Inline bool   `json:"inline"`
+		}
+		embed := map[string]any{
+			"title":       m.title,
+			"description": m.text,
+			"color":       0xffd700,
+			"timestamp":   m.time.Format(time.RFC3339),
+		}
+		if m.url != "" {
+			embed["url"] = m.url
+		}
+		fields := make([]field, 0, len(m.fields))
+		for _, f := range m.fields {
+			fields = append(fields, field{f[0], f[1], true})
+		}
+		embed["fields"] = fields
+		return map[string]any{
+			"embeds": []any{embed},
+			// Player names mustn't ping @everyone.
+			"allowed_mentions": map[string]any{"parse": []string{}},
+		}
+	}
+
+	title := "*" + slackEscape(m.title) + "*"
+	if m.url != "" {
+		title = "*<" + m.url + "|" + slackEscape(m.title) + ">*"
+	}
+	section := map[string]any{
+		"type": "section",
+		"text": map[string]string{"type": "mrkdwn", "text": title + "\n" + slackEscape(m.text)},
+	}
+	if len(m.fields) > 0 {
+		var fields []map[string]string
+		for _, f := range m.fields {
+			fields = append(fields, map[string]string{"type": "mrkdwn", "text": "*" + f[0] + "*\n" + slackEscape(f[1])})
+		}
+		section["fields"] = fields
+	}
+	return map[string]any{
+		"text":   slackEscape(m.title + ": " + m.text),
+		"blocks": []any{section},
+	}
+}
+
+// slackEscape keeps player-chosen text from forming links or mentions
+// like <!channel>.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxJSONBody caps API request bodies unless an endpoint says otherwise.
+const maxJSONBody = 16 << 10
+
+// apiError is the JSON body of every failed API response.
+type apiError struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeJSON encodes v as the response body. API responses default to
+// no-store; handlers that want caching set Cache-Control first.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg, RequestID: requestID(w)})
+}
+
+// bodyError is a request body that couldn't be read as JSON: too large,
+// with 413, or empty or malformed, with 400.
+type bodyError struct {
+	status int
+	msg    string
+}
+
+func (e *bodyError) Error() string { return e.msg }
+
+// readError turns an error reading a body of at most limit bytes into a
+// bodyError.
+func readError(err error, limit int64) error {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		return &bodyError{http.StatusRequestEntityTooLarge, fmt.Sprintf("request body larger than %d bytes", limit)}
+	case errors.Is(err, io.EOF):
+		return &bodyError{http.StatusBadRequest, "request body is empty"}
+	}
+	return &bodyError{http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err)}
+}
+
+// writeBodyError responds to an error from decodeJSON or decodeFields: with
+// its status for a body that couldn't be read, or 422 for one that failed a
+// check as it was read.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var bodyErr *bodyError
+	if errors.As(err, &bodyErr) {
+		writeError(w, bodyErr.status, bodyErr.msg)
+		return
+	}
+	writeError(w, http.StatusUnprocessableEntity, err.Error())
+}
+
+// bodyDecoder returns a decoder for r's body, limited to limit bytes. A
+// body that declares a larger Content-Length is refused unread.
+func bodyDecoder(w http.ResponseWriter, r *http.Request, limit int64) (*json.Decoder, error) {
+	if r.ContentLength > limit {
+		return nil, readError(&http.MaxBytesError{Limit: limit}, limit)
+	}
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)), nil
+}
+
+// decodeJSON reads a single JSON object of at most limit bytes into dst.
+// The returned error is safe to show to the client.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, dst any) error {
+	dec, err := bodyDecoder(w, r, limit)
+	if err != nil {
+		return err
+	}
+	if err := dec.Decode(dst); err != nil {
+		return readError(err, limit)
+	}
+	if dec.More() {
+		return &bodyError{http.StatusBadRequest, "request body must contain a single JSON object"}
+	}
+	return nil
+}
+
+// fieldDecoder reads the value of one member of the object decodeFields
+// is reading.
+type fieldDecoder struct {
+	dec   *json.Decoder
+	name  string
+	limit int64
+}
+
+// decode reads the whole value into v.
+func (d *fieldDecoder) decode(v any) error {
+	if err := d.dec.Decode(v); err != nil {
+		return readError(err, d.limit)
+	}
+	return nil
+}
+
+// each reads an array, calling fn to decode each element in turn. null
+// reads as an empty array.
+func (d *fieldDecoder) each(fn func() error) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return readError(err, d.limit)
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return &bodyError{http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s must be an array", d.name)}
+	}
+	for d.dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	_, err = d.dec.Token()
+	if err != nil {
+		return readError(err, d.limit)
+	}
+	return nil
+}
+
+// decodeFields reads a single JSON object of at most limit bytes a member
+// at a time, handing each to the function fields has for its name, which
+// decodes the value and can check it there and then. An upload is refused
+// at its first problem rather than once all of it has been read. Names
+// match case-insensitively, as decodeJSON matches them, and members
+// without a function are skipped. Errors from the functions come back as
+// they are, for writeBodyError to send with 422.
+func decodeFields(w http.ResponseWriter, r *http.Request, limit int64, fields map[string]func(*fieldDecoder) error) error {
+	dec, err := bodyDecoder(w, r, limit)
+	if err != nil {
+		return err
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return readError(err, limit)
+	}
+	if tok != json.Delim('{') {
+		return &bodyError{http.StatusBadRequest, "request body must be a JSON object"}
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return readError(err, limit)
+		}
+		name, _ := tok.(string)
+		d := &fieldDecoder{dec: dec, name: name, limit: limit}
+		field, ok := fields[strings.ToLower(name)]
+		if !ok {
+			field = func(d *fieldDecoder) error { return d.decode(new(json.RawMessage)) }
+		}
+		if err := field(d); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return readError(err, limit)
+	}
+	if dec.More() {
+		return &bodyError{http.StatusBadRequest, "request body must contain a single JSON object"}
+	}
+	return nil
+}
+
+// queryInt parses an integer query parameter, returning def when absent.
+func queryInt(r *http.Request, key string, def, min, max int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", key, min, max)
+	}
+	return n, nil
+}
+
+// pathID parses the {id} path wildcard, writing a 400 if it isn't a
+// positive integer.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// apiNotFound keeps unknown /api paths from falling through to the SPA.
+func apiNotFound(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusNotFound, "no such endpoint")
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// playerTokenHeader carries an opaque, client-generated identifier used to
+// enforce one-per-player actions such as level votes. It is not
+// authentication; clients create one on first launch and keep it.
+const playerTokenHeader = "X-Player-Token"
+
+// playerToken returns the hashed player token, writing a 400 if it is
+// missing or malformed.
+func playerToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	t := r.Header.Get(playerTokenHeader)
+	if len(t) < 16 || len(t) > 128 {
+		writeError(w, http.StatusBadRequest, playerTokenHeader+" header must be 16-128 characters")
+		return "", false
+	}
+	return hashToken(t), true
+}
+
+// optionalPlayerToken returns the hashed player token if the request has
+// a well-formed one, or "" otherwise. Submissions record it so moderators
+// can ban the client that sent them.
+func optionalPlayerToken(r *http.Request) string {
+	t := r.Header.Get(playerTokenHeader)
+	if len(t) < 16 || len(t) > 128 {
+		return ""
+	}
+	return hashToken(t)
+}
+
+// hashToken is how bearer-style tokens are stored: a lookup key that can't
+// be turned back into a usable token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/apidocs"
+)
+
+// docsAssets are the viewer's files under /api/docs/.
+var docsAssets = []string{"docs.css", "docs.js"}
+
+// docsAPI serves the game API's OpenAPI description at
+// /api/docs/openapi.json and a page that renders it at /api/docs/, for
+// client developers. The description is generated from the handlers (see
+// internal/apidocs), so it lists routes that need features this server
+// may not have configured.
+type docsAPI struct{}
+
+func (a *docsAPI) register(mux *http.ServeMux) {
+	files := apidocs.FS()
+	sum := sha256.Sum256(apidocs.Spec())
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	mux.HandleFunc("GET /api/docs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/api/docs/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("GET /api/docs/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFileFS(w, r, files, "index.html")
+	})
+	mux.HandleFunc("GET /api/docs/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Type", "application/json")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("ETag", etag)
+		http.ServeContent(w, r, "openapi.json", time.Time{}, bytes.NewReader(apidocs.Spec()))
+	})
+	mux.HandleFunc("GET /api/docs/{file}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("file")
+		if !slices.Contains(docsAssets, name) {
+			apiNotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFileFS(w, r, files, name)
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	assetCacheLookups = defaultRegistry.counter("loderunner_asset_cache_lookups_total",
+		"In-memory asset cache lookups by result.", "result")
+	assetCacheEvictions = defaultRegistry.counter("loderunner_asset_cache_evictions_total",
+		"Assets evicted from the in-memory cache to make room.")
+)
+
+// missingEntrySize is what remembering a missing file costs against the
+// cache size; most of these are .br and .gz siblings that weren't built.
+const missingEntrySize = 128
+
+// assetCache is an fs.FS that keeps recently served files from dist/ in
+// memory, evicting the least recently used once it holds max bytes. Files
+// over an eighth of max, like the soundtrack, are always read from disk so
+// one of them can't flush everything else. Entries are dropped when
+// fsnotify reports a change, so there is no stat per request either.
+type assetCache struct {
+	fsys fs.FS
+	dir  string
+	max  int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cachedAsset, most recently used first
+	entries map[string]*list.Element
+}
+
+// cachedAsset is a file's contents, or a remembered fs.ErrNotExist when
+// info is nil.
+type cachedAsset struct {
+	name string
+	data []byte
+	info fs.FileInfo
+}
+
+func (a *cachedAsset) cost() int64 {
+	if a.info == nil {
+		return missingEntrySize
+	}
+	return int64(len(a.data)) + int64(len(a.name))
+}
+
+func newAssetCache(dir string, max int64) *assetCache {
+	c := &assetCache{
+		fsys:    os.DirFS(dir),
+		dir:     dir,
+		max:     max,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+	defaultRegistry.gaugeFunc("loderunner_asset_cache_bytes", "Bytes held by the in-memory asset cache.", func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(c.size)
+	})
+	return c
+}
+
+// Open serves name from memory, loading it on a miss.
+func (c *assetCache) Open(name string) (fs.File, error) {
+	if a, ok := c.lookup(name); ok {
+		assetCacheLookups.inc("hit")
+		return a.open(name)
+	}
+	assetCacheLookups.inc("miss")
+
+	f, err := c.fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.store(&cachedAsset{name: name})
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() > c.max/8 {
+		return f, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	a := &cachedAsset{name: name, data: data, info: fi}
+	c.store(a)
+	return a.open(name)
+}
+
+// Stat answers from memory for cached files, which is most of what the
+// static handler asks about.
+func (c *assetCache) Stat(name string) (fs.FileInfo, error) {
+	if a, ok := c.lookup(name); ok {
+		if a.info == nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		return a.info, nil
+	}
+	return fs.Stat(c.fsys, name)
+}
+
+func (a *cachedAsset) open(name string) (fs.File, error) {
+	if a.info == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &cachedFile{Reader: bytes.NewReader(a.data), info: a.info}, nil
+}
+
+func (c *assetCache) lookup(name string) (*cachedAsset, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cachedAsset), true
+}
+
+func (c *assetCache) store(a *cachedAsset) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[a.name]; ok {
+		c.remove(el)
+	}
+	c.entries[a.name] = c.lru.PushFront(a)
+	c.size += a.cost()
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+		assetCacheEvictions.inc()
+	}
+}
+
+func (c *assetCache) remove(el *list.Element) {
+	a := c.lru.Remove(el).(*cachedAsset)
+	delete(c.entries, a.name)
+	c.size -= a.cost()
+}
+
+// invalidate drops name and, if it was a directory, everything below it.
+func (c *assetCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if n := el.Value.(*cachedAsset).name; n == name || strings.HasPrefix(n, name+"/") {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// watch invalidates entries as files change until ctx is done.
+func (c *assetCache) watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := addWatchTree(w, c.dir); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					addWatchTree(w, ev.Name)
+				}
+			}
+			if rel, err := filepath.Rel(c.dir, ev.Name); err == nil {
+				c.invalidate(filepath.ToSlash(rel))
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("asset cache: %v", err)
+		}
+	}
+}
+
+// cachedFile is an open file served from memory. Its Seek lets
+// http.ServeContent answer range requests.
+type cachedFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *cachedFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *cachedFile) Close() error               { return nil }
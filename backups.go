@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/jgbrwn/loderunner2099/internal/cron"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+var (
+	backupRuns = defaultRegistry.counter("loderunner_backups_total",
+		"Scheduled database snapshots, by result.", "result")
+	backupLast = defaultRegistry.gauge("loderunner_backup_last_success_timestamp_seconds",
+		"Unix time of the last snapshot written to -backup.")
+)
+
+// backupTimeLayout is the UTC time in a snapshot's name, which sorts the
+// names oldest first.
+const backupTimeLayout = "20060102T150405Z"
+
+// backupFetchTimeout bounds downloading a snapshot to restore.
+const backupFetchTimeout = 30 * time.Minute
+
+// backups snapshots a SQLite database into a -backup store on a schedule
+// and deletes all but the newest keep snapshots. Snapshots are named after
+// the database file and the time they were taken, as in
+// loderunner2099-20261014T030000Z.db, so several databases can share a
+// store and nothing else in it is taken for one.
+type backups struct {
+	db       *storage.DB
+	store    backupStore
+	prefix   string
+	schedule *cron.Schedule
+	keep     int
+}
+
+func newBackups(db *storage.DB, cfg config) (*backups, error) {
+	store, err := openBackupStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := cron.Parse(cfg.BackupSchedule)
+	if err != nil {
+		return nil, err
+	}
+	return &backups{db: db, store: store, prefix: backupPrefix(cfg.DBPath), schedule: schedule, keep: cfg.BackupKeep}, nil
+}
+
+// backupPrefix starts the names of dbPath's snapshots.
+func backupPrefix(dbPath string) string {
+	base := filepath.Base(dbPath)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+}
+
+// snapshotTime returns when the snapshot called name was taken, or false
+// if name isn't one of prefix's snapshots.
+func snapshotTime(prefix, name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, ".db")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, stamp)
+	return t, err == nil
+}
+
+// snapshots lists prefix's snapshots in store, oldest first.
+func snapshots(ctx context.Context, store backupStore, prefix string) ([]string, error) {
+	names, err := store.list(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		_, ok := snapshotTime(prefix, name)
+		return !ok
+	})
+	slices.Sort(names)
+	return names, nil
+}
+
+// run takes a snapshot each time the schedule fires until ctx is done.
+// The schedule counts from the newest snapshot in the store, so one missed
+// while the server was down is taken as soon as it starts again.
+func (b *backups) run(ctx context.Context) {
+	failed := false
+	for {
+		wait := time.Minute
+		names, err := snapshots(ctx, b.store, b.prefix)
+		switch {
+		case err != nil:
+			log.Printf("backups: listing %s: %v", b.store, err)
+		case len(names) == 0:
+			wait = 0
+		default:
+			last, _ := snapshotTime(b.prefix, names[len(names)-1])
+			wait = time.Until(b.schedule.Next(last))
+		}
+		if failed {
+			wait = max(wait, time.Minute)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if err != nil {
+			continue
+		}
+		name, size, err := b.backup(ctx)
+		failed = err != nil
+		if err != nil {
+			backupRuns.inc("error")
+			log.Printf("backups: %v", err)
+			continue
+		}
+		backupRuns.inc("ok")
+		backupLast.set(float64(time.Now().Unix()))
+		log.Printf("💾 Backed up the database to %s (%d bytes)", b.store.location(name), size)
+		b.prune(ctx)
+	}
+}
+
+// backup writes a snapshot to the store and returns its name and size.
+func (b *backups) backup(ctx context.Context) (string, int64, error) {
+	name := b.prefix + time.Now().UTC().Format(backupTimeLayout) + ".db"
+	tmp := filepath.Join(b.store.tempDir(), "."+name+".tmp")
+	os.Remove(tmp) // left behind by a crash; VACUUM INTO won't overwrite it
+	defer os.Remove(tmp)
+	if err := b.db.Snapshot(ctx, tmp); err != nil {
+		return "", 0, fmt.Errorf("snapshot: %w", err)
+	}
+	fi, err := os.Stat(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := b.store.put(ctx, name, tmp); err != nil {
+		return "", 0, fmt.Errorf("writing %s: %w", b.store.location(name), err)
+	}
+	return name, fi.Size(), nil
+}
+
+// prune deletes all but the newest keep snapshots.
+func (b *backups) prune(ctx context.Context) {
+	if b.keep == 0 {
+		return
+	}
+	names, err := snapshots(ctx, b.store, b.prefix)
+	if err != nil {
+		log.Printf("backups: listing %s: %v", b.store, err)
+		return
+	}
+	for _, name := range names[:max(len(names)-b.keep, 0)] {
+		if err := b.store.remove(ctx, name); err != nil {
+			log.Printf("backups: deleting %s: %v", b.store.location(name), err)
+			continue
+		}
+		log.Printf("💾 Deleted old backup %s", b.store.location(name))
+	}
+}
+
+// backupStore is where snapshots are kept: a directory or a bucket.
+type backupStore interface {
+	fmt.Stringer
+	// tempDir is where to write a snapshot before put.
+	tempDir() string
+	// put stores the file at path as name, moving or copying it.
+	put(ctx context.Context, name, path string) error
+	// list returns the names in the store starting with prefix.
+	list(ctx context.Context, prefix string) ([]string, error)
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	remove(ctx context.Context, name string) error
+	// location returns where name is, for the logs.
+	location(name string) string
+}
+
+// openBackupStore opens cfg.Backup, creating it if it is a directory.
+func openBackupStore(cfg config) (backupStore, error) {
+	if !strings.Contains(cfg.Backup, "://") {
+		if err := os.MkdirAll(cfg.Backup, 0o700); err != nil {
+			return nil, err
+		}
+		return dirBackups(cfg.Backup), nil
+	}
+	u, err := url.Parse(cfg.Backup)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newBucketClient(u, cfg.BackupEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	b := &bucketBackups{client: client, bucket: u.Host, name: cfg.Backup}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		b.prefix = p + "/"
+	}
+	return b, nil
+}
+
+// dirBackups keeps snapshots in a directory. Writing them there first lets
+// put rename them into place, so a snapshot is never seen half-written.
+type dirBackups string
+
+func (d dirBackups) String() string              { return string(d) }
+func (d dirBackups) tempDir() string             { return string(d) }
+func (d dirBackups) location(name string) string { return filepath.Join(string(d), name) }
+
+func (d dirBackups) put(_ context.Context, name, path string) error {
+	return os.Rename(path, d.location(name))
+}
+
+func (d dirBackups) list(_ context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d dirBackups) open(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.location(name))
+}
+
+func (d dirBackups) remove(_ context.Context, name string) error {
+	return os.Remove(d.location(name))
+}
+
+// bucketBackups keeps snapshots in an S3 or Cloud Storage bucket.
+type bucketBackups struct {
+	client *minio.Client
+	bucket string
+	prefix string // with a trailing slash unless empty
+	name   string // s3://bucket/prefix, for the logs
+}
+
+func (b *bucketBackups) String() string  { return b.name }
+func (b *bucketBackups) tempDir() string { return os.TempDir() }
+func (b *bucketBackups) location(name string) string {
+	return strings.TrimSuffix(b.name, "/") + "/" + name
+}
+
+func (b *bucketBackups) put(ctx context.Context, name, path string) error {
+	_, err := b.client.FPutObject(ctx, b.bucket, b.prefix+name, path, minio.PutObjectOptions{ContentType: "application/vnd.sqlite3"})
+	return err
+}
+
+func (b *bucketBackups) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for obj := range b.client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: b.prefix + prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		names = append(names, strings.TrimPrefix(obj.Key, b.prefix))
+	}
+	return names, nil
+}
+
+func (b *bucketBackups) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := b.client.GetObject(ctx, b.bucket, b.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.location(name), err)
+	}
+	// GetObject doesn't fail for a missing snapshot until it is read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("%s: %w", b.location(name), err)
+	}
+	return obj, nil
+}
+
+func (b *bucketBackups) remove(ctx context.Context, name string) error {
+	return b.client.RemoveObject(ctx, b.bucket, b.prefix+name, minio.RemoveObjectOptions{})
+}
+
+// runRestore replaces the -db file with a snapshot, given as the name of
+// one in -backup or the path of a file. Without one, it lists the
+// snapshots in -backup. The database it replaces is kept beside it, with
+// .pre-restore added to its name, in case the wrong snapshot was picked.
+// Stop the server first; it migrates the restored database when it starts.
+func runRestore() {
+	cfg := loadConfig()
+	if cfg.DBPath == "" || storage.IsPostgresURL(cfg.DBPath) {
+		log.Fatal("restore: needs a SQLite database (-db); restore PostgreSQL with pg_restore")
+	}
+	ctx := context.Background()
+	prefix := backupPrefix(cfg.DBPath)
+	switch flag.NArg() {
+	case 0:
+		if cfg.Backup == "" {
+			log.Fatal("restore: no snapshot given, and no -backup to list them from")
+		}
+		store, err := openBackupStore(cfg)
+		if err != nil {
+			log.Fatalf("restore: %v", err)
+		}
+		names, err := snapshots(ctx, store, prefix)
+		if err != nil {
+			log.Fatalf("restore: listing %s: %v", store, err)
+		}
+		if len(names) == 0 {
+			fmt.Printf("no snapshots of %s in %s\n", cfg.DBPath, store)
+			return
+		}
+		for _, name := range names {
+			t, _ := snapshotTime(prefix, name)
+			fmt.Printf("%s  %s\n", name, t.Local().Format(time.DateTime))
+		}
+		return
+	case 1:
+	default:
+		log.Fatal("restore: give one snapshot")
+	}
+
+	src := flag.Arg(0)
+	kept, err := restoreSnapshot(ctx, cfg, src)
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+	log.Printf("🗄️  Restored %s from %s; the database it replaced is %s", cfg.DBPath, src, kept)
+}
+
+// restoreSnapshot checks src and moves it into place, returning where the
+// database it replaced was moved to.
+func restoreSnapshot(ctx context.Context, cfg config, src string) (string, error) {
+	tmp := cfg.DBPath + ".restore"
+	removeDBFiles(tmp)
+	defer removeDBFiles(tmp)
+	if err := fetchSnapshot(ctx, cfg, src, tmp); err != nil {
+		return "", err
+	}
+	db, err := storage.Open(tmp, cfg.DBDriver)
+	if err != nil {
+		return "", err
+	}
+	err = db.IntegrityCheck(ctx)
+	db.Close()
+	if err != nil {
+		return "", fmt.Errorf("%s is not a usable database: %w", src, err)
+	}
+
+	kept := cfg.DBPath + ".pre-restore"
+	removeDBFiles(kept)
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(cfg.DBPath+suffix, kept+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return kept, os.Rename(tmp, cfg.DBPath)
+}
+
+// fetchSnapshot copies src, a file or else a snapshot in cfg.Backup, to
+// dst.
+func fetchSnapshot(ctx context.Context, cfg config, src, dst string) error {
+	var r io.ReadCloser
+	f, err := os.Open(src)
+	switch {
+	case err == nil:
+		r = f
+	case errors.Is(err, os.ErrNotExist) && cfg.Backup != "" && !strings.ContainsAny(src, `/\`):
+		store, err := openBackupStore(cfg)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, backupFetchTimeout)
+		defer cancel()
+		if r, err = store.open(ctx, src); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	defer r.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeDBFiles deletes a SQLite database at path and its sidecar files.
+func removeDBFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
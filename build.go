@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// buildInputs are the files and directories a change to which makes dist/
+// stale.
+var buildInputs = []string{"package.json", "package-lock.json", "index.html", "tsconfig.json", "vite.config.ts", "src", "public"}
+
+// ensureBuild runs npm run build when dist/ is missing or, with stale set,
+// older than its sources, so the server can be started in one step. Output
+// goes straight to the terminal.
+func ensureBuild(distDir string, stale bool) error {
+	built, err := os.Stat(filepath.Join(distDir, "index.html"))
+	switch {
+	case os.IsNotExist(err):
+		log.Printf("🔨 %s not found, running npm run build", distDir)
+	case err != nil:
+		return err
+	case !stale:
+		return nil
+	default:
+		changed, newest := newestInput(buildInputs)
+		if !newest.After(built.ModTime()) {
+			return nil
+		}
+		log.Printf("🔨 %s changed since the last build, running npm run build", changed)
+	}
+
+	start := time.Now()
+	cmd := exec.Command("npm", "run", "build")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("npm run build: %w", err)
+	}
+	log.Printf("🔨 Build finished in %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// newestInput returns the most recently modified file among paths,
+// descending into directories. Missing paths are skipped.
+func newestInput(paths []string) (name string, newest time.Time) {
+	for _, root := range paths {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && d.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			if fi, err := d.Info(); err == nil && !d.IsDir() && fi.ModTime().After(newest) {
+				name, newest = p, fi.ModTime()
+			}
+			return nil
+		})
+	}
+	return name, newest
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheRules reproduce the original hardcoded behaviour and apply
+// after any operator-supplied rules.
+var defaultCacheRules = []string{
+	// HTML: no cache - always fetch latest
+	"index.html, *.html -> no-cache, no-store, must-revalidate",
+	// JS/CSS with hashes: cache for 1 year
+	"*.js, *.css -> immutable 1y",
+	// Images: cache for 1 week
+	"*.png, *.jpg, *.gif, *.webp, *.svg, *.ico -> 1w",
+	// Fonts: cache for 1 year
+	"*.woff, *.woff2, *.ttf, *.eot -> immutable 1y",
+	// Other files: cache for 1 hour
+	"* -> 1h",
+}
+
+// cacheRule maps a set of glob patterns to a Cache-Control value.
+type cacheRule struct {
+	patterns []string
+	value    string
+}
+
+// surrogateRule tags the files its patterns match with keys a CDN can
+// purge by.
+type surrogateRule struct {
+	patterns []string
+	keys     []string
+}
+
+// defaultHashedPattern recognises content-hashed file names: a hex digest of
+// 8+ characters (webpack, esbuild) or Rollup's 8-character base64url hash as
+// emitted by Vite, e.g. index-a1b2c3d4.js or index-BxK9_q2Z.js.
+const defaultHashedPattern = `[.-]([0-9a-f]{8,}|[0-9A-Za-z_-]{8})\.[0-9A-Za-z]+$`
+
+// cachePolicy picks the Cache-Control header for a file. Rules are checked
+// in order and the first match wins. Immutable caching is only honoured for
+// hashed file names; anything else that matches an immutable rule gets
+// unhashed instead, since its contents can change under the same URL.
+type cachePolicy struct {
+	mu        sync.RWMutex // a reload replaces the rest
+	rules     []cacheRule
+	surrogate []surrogateRule
+	hashed    *regexp.Regexp
+	unhashed  string
+}
+
+// newCachePolicy builds the policy from -cache-rule flags, then the policy
+// file, then the defaults, and adds the -surrogate-key rules.
+func newCachePolicy(cfg config) (*cachePolicy, error) {
+	lines := append([]string(nil), cfg.CacheRules...)
+	if cfg.CachePolicyFile != "" {
+		fileLines, err := readRuleFile(cfg.CachePolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fileLines...)
+	}
+	lines = append(lines, defaultCacheRules...)
+
+	hashed, err := regexp.Compile(cfg.HashedPattern)
+	if err != nil {
+		return nil, fmt.Errorf("-hashed-pattern: %w", err)
+	}
+	p := &cachePolicy{
+		hashed:   hashed,
+		unhashed: fmt.Sprintf("public, max-age=%d, must-revalidate", int(cfg.UnhashedMaxAge/time.Second)),
+	}
+	for _, line := range lines {
+		rule, err := parseCacheRule(line)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, rule)
+	}
+	for _, line := range cfg.SurrogateKeys {
+		rule, err := parseSurrogateRule(line)
+		if err != nil {
+			return nil, err
+		}
+		p.surrogate = append(p.surrogate, rule)
+	}
+	return p, nil
+}
+
+func readRuleFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := parseCacheRule(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// parseCacheRule parses "pattern[, pattern...] -> directive". The directive
+// is either a literal Cache-Control value (anything containing '=' or ',')
+// or shorthand built from: a duration (30s, 10m, 1h, 1d, 1w, 1y),
+// immutable, no-cache, no-store, private, revalidate, and cdn: and stale:
+// followed by a duration for s-maxage and stale-while-revalidate.
+func parseCacheRule(line string) (cacheRule, error) {
+	lhs, rhs, ok := strings.Cut(line, "->")
+	if !ok {
+		return cacheRule{}, fmt.Errorf("cache rule %q: want \"pattern -> directive\"", line)
+	}
+
+	patterns, err := parsePatterns(lhs)
+	if err != nil {
+		return cacheRule{}, fmt.Errorf("cache rule %q: %w", line, err)
+	}
+	value, err := cacheDirective(strings.TrimSpace(rhs))
+	if err != nil {
+		return cacheRule{}, fmt.Errorf("cache rule %q: %w", line, err)
+	}
+	return cacheRule{patterns: patterns, value: value}, nil
+}
+
+// parsePatterns parses the comma-separated globs on the left of a rule.
+func parsePatterns(lhs string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(lhs, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("no patterns")
+	}
+	return patterns, nil
+}
+
+// parseSurrogateRule parses "pattern[, pattern...] -> key [key...]".
+func parseSurrogateRule(line string) (surrogateRule, error) {
+	lhs, rhs, ok := strings.Cut(line, "->")
+	if !ok {
+		return surrogateRule{}, fmt.Errorf("surrogate key rule %q: want \"pattern -> key\"", line)
+	}
+	patterns, err := parsePatterns(lhs)
+	if err != nil {
+		return surrogateRule{}, fmt.Errorf("surrogate key rule %q: %w", line, err)
+	}
+	keys := strings.Fields(rhs)
+	if len(keys) == 0 {
+		return surrogateRule{}, fmt.Errorf("surrogate key rule %q: no keys", line)
+	}
+	for _, k := range keys {
+		if strings.ContainsAny(k, ",\"") {
+			return surrogateRule{}, fmt.Errorf("surrogate key rule %q: bad key %q", line, k)
+		}
+	}
+	return surrogateRule{patterns: patterns, keys: keys}, nil
+}
+
+func cacheDirective(s string) (string, error) {
+	if strings.ContainsAny(s, "=,") {
+		return s, nil
+	}
+
+	scope, maxAge := "public", -1
+	var immutable, revalidate bool
+	var cdn []string
+	for _, tok := range strings.Fields(s) {
+		switch tok {
+		case "no-cache", "no-store":
+			return tok, nil
+		case "immutable":
+			immutable = true
+		case "private":
+			scope = "private"
+		case "revalidate":
+			revalidate = true
+		default:
+			if name, d, ok := strings.Cut(tok, ":"); ok && cdnDirectives[name] != "" {
+				secs, err := parseCacheDuration(d)
+				if err != nil {
+					return "", err
+				}
+				cdn = append(cdn, cdnDirectives[name]+"="+strconv.Itoa(secs))
+				continue
+			}
+			secs, err := parseCacheDuration(tok)
+			if err != nil {
+				return "", err
+			}
+			maxAge = secs
+		}
+	}
+	if maxAge < 0 {
+		return "", fmt.Errorf("directive %q needs a max-age duration", s)
+	}
+
+	parts := []string{scope, "max-age=" + strconv.Itoa(maxAge)}
+	if revalidate {
+		parts = append(parts, "must-revalidate")
+	}
+	if immutable {
+		parts = append(parts, "immutable")
+	}
+	return strings.Join(append(parts, cdn...), ", "), nil
+}
+
+// cdnDirectives are the shorthand for what shared caches in front of the
+// server are told, as in "cdn:1d stale:1m".
+var cdnDirectives = map[string]string{
+	"cdn":   "s-maxage",
+	"stale": "stale-while-revalidate",
+}
+
+var cacheDurationUnits = map[byte]int{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+	'y': 365 * 24 * 60 * 60,
+}
+
+// parseCacheDuration converts "1y", "2w", "3600" etc. to seconds.
+func parseCacheDuration(s string) (int, error) {
+	if s == "" {
+		return 0, errors.New("empty duration")
+	}
+	num, mult := s, 1
+	if unit, ok := cacheDurationUnits[s[len(s)-1]]; ok {
+		num, mult = s[:len(s)-1], unit
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("unknown cache directive %q", s)
+	}
+	return n * mult, nil
+}
+
+// lookup returns the Cache-Control value for a file name relative to the
+// dist root.
+func (p *cachePolicy) lookup(name string) string {
+	for _, rule := range p.rules {
+		if matchesAny(rule.patterns, name) {
+			if strings.Contains(rule.value, "immutable") && !p.isHashed(path.Base(name)) {
+				return p.unhashed
+			}
+			return rule.value
+		}
+	}
+	return ""
+}
+
+// surrogateKeys returns the keys of every surrogate rule matching name.
+func (p *cachePolicy) surrogateKeys(name string) []string {
+	var keys []string
+	for _, rule := range p.surrogate {
+		if matchesAny(rule.patterns, name) {
+			for _, k := range rule.keys {
+				if !slices.Contains(keys, k) {
+					keys = append(keys, k)
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// matchesAny reports whether name matches one of patterns. Patterns
+// without a slash match the base name in any directory.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isHashed reports whether a file name carries a content hash. The captured
+// hash must contain a digit or capital letter so that plain words like
+// "settings" aren't mistaken for one.
+func (p *cachePolicy) isHashed(base string) bool {
+	m := p.hashed.FindStringSubmatch(base)
+	if m == nil {
+		return false
+	}
+	if len(m) < 2 {
+		return true
+	}
+	return strings.ContainsAny(m[1], "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
+
+// apply sets the caching headers for name. Uncacheable responses also get
+// the HTTP/1.0 Pragma and Expires equivalents. Surrogate keys go out both
+// as Fastly's space-separated Surrogate-Key and Cloudflare's
+// comma-separated Cache-Tag; each CDN strips its own.
+func (p *cachePolicy) apply(h http.Header, name string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if keys := p.surrogateKeys(name); len(keys) > 0 {
+		h.Set("Surrogate-Key", strings.Join(keys, " "))
+		h.Set("Cache-Tag", strings.Join(keys, ","))
+	}
+	value := p.lookup(name)
+	if value == "" {
+		return
+	}
+	h.Set("Cache-Control", value)
+	if strings.Contains(value, "no-cache") || strings.Contains(value, "no-store") {
+		h.Set("Pragma", "no-cache")
+		h.Set("Expires", "0")
+	}
+}
+
+// replace swaps in next's rules, for a reload. The handlers sharing p
+// apply them from their next response.
+func (p *cachePolicy) replace(next *cachePolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules, p.surrogate, p.hashed, p.unhashed = next.rules, next.surrogate, next.hashed, next.unhashed
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCacheRuleEmptyDuration(t *testing.T) {
+	for _, line := range []string{
+		"*.js -> cdn: 1h",
+		"*.js -> 1h cdn:",
+		"*.js -> 1h stale:",
+	} {
+		_, err := parseCacheRule(line)
+		if err == nil || !strings.Contains(err.Error(), "empty duration") {
+			t.Errorf("parseCacheRule(%q) = %v, want an empty duration error", line, err)
+		}
+	}
+}
+
+func TestParseCacheRuleCDN(t *testing.T) {
+	rule, err := parseCacheRule("*.js -> 1h cdn:1d stale:1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "public, max-age=3600, s-maxage=86400, stale-while-revalidate=60"; rule.value != want {
+		t.Errorf("value = %q, want %q", rule.value, want)
+	}
+}
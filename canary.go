@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// canaryCookie holds a visitor's rollout bucket, 0 to 99. Visitors get the
+// canary build while their bucket is below the percentage, so raising it
+// only ever adds visitors, and each keeps the build they were given.
+const (
+	canaryCookie    = "lr_canary"
+	canaryCookieAge = 30 * 24 * time.Hour
+)
+
+// canaryKey holds a percentage set with PUT /api/admin/canary. It overrides
+// -canary-percent until it is deleted, on every replica sharing -redis.
+const canaryKey = "canary:percent"
+
+// canaryRefresh is how often replicas read canaryKey for a percentage set
+// on another one.
+const canaryRefresh = 5 * time.Second
+
+var canaryResponses = defaultRegistry.counter("loderunner_canary_responses_total",
+	"Static responses by the build served: stable or canary.", "build")
+
+// buildHandler serves a build: a staticHandler, or a releaseSet serving
+// the current release.
+type buildHandler interface {
+	http.Handler
+	isFile(name string) bool
+
+	// served returns the files served now and their ETags.
+	served() (fs.FS, *etagManifest)
+}
+
+// canaryRollout serves the -canary build to a percentage of visitors and
+// the stable one to the rest.
+type canaryRollout struct {
+	stable, canary buildHandler
+	initial        int // -canary-percent
+	percent        atomic.Int32
+	store          ephemeral.Store
+	proxies        trustedProxies
+}
+
+func newCanaryRollout(cfg config, store ephemeral.Store, proxies trustedProxies, stable, canary buildHandler) *canaryRollout {
+	c := &canaryRollout{stable: stable, canary: canary, initial: cfg.CanaryPercent, store: store, proxies: proxies}
+	c.percent.Store(int32(cfg.CanaryPercent))
+	return c
+}
+
+// run keeps the percentage in step with canaryKey until ctx is done.
+func (c *canaryRollout) run(ctx context.Context) {
+	ticker := time.NewTicker(canaryRefresh)
+	defer ticker.Stop()
+	for {
+		if err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("canary: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *canaryRollout) refresh(ctx context.Context) error {
+	b, err := c.store.Get(ctx, canaryKey)
+	if err != nil {
+		return err
+	}
+	p := c.initial
+	if b != nil {
+		if p, err = strconv.Atoi(string(b)); err != nil || p < 0 || p > 100 {
+			return errors.New("bad percentage stored at " + canaryKey)
+		}
+	}
+	if old := c.percent.Swap(int32(p)); int(old) != p {
+		log.Printf("🐤 Canary now serves %d%% of visitors", p)
+	}
+	return nil
+}
+
+// set overrides the percentage, or with p < 0 goes back to -canary-percent.
+func (c *canaryRollout) set(ctx context.Context, p int) error {
+	var err error
+	if p < 0 {
+		err = c.store.Delete(ctx, canaryKey)
+	} else {
+		err = c.store.Set(ctx, canaryKey, []byte(strconv.Itoa(p)), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return c.refresh(ctx)
+}
+
+func (c *canaryRollout) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := fsName(r.URL.Path)
+	page := path.Ext(name) == "" || path.Ext(name) == ".html"
+	canary := c.bucket(w, r, page) < int(c.percent.Load())
+	h, other := c.stable, c.canary
+	if canary {
+		h, other = c.canary, c.stable
+	}
+	if !h.isFile(name) {
+		if path.Ext(name) == "" {
+			name = "index.html"
+		} else if other.isFile(name) {
+			// A page loaded before the visitor changed builds can still
+			// fetch its assets.
+			h, canary = other, !canary
+		}
+	}
+	if c.stable.isFile(name) && c.canary.isFile(name) {
+		// Shared caches must keep one copy per build.
+		w.Header().Add("Vary", "Cookie")
+	}
+	build := "stable"
+	if canary {
+		build = "canary"
+	}
+	canaryResponses.inc(build)
+	h.ServeHTTP(w, r)
+}
+
+// bucket returns the visitor's bucket. Pages assign one on the first
+// visit; other files leave caching to the CDN and go by the last bucket
+// until then. ?canary=on and ?canary=off on a page move the visitor to the
+// first or last bucket, so testers can opt in whenever the canary serves
+// anyone at all.
+func (c *canaryRollout) bucket(w http.ResponseWriter, r *http.Request, page bool) int {
+	b := -1
+	switch q := r.URL.Query().Get("canary"); {
+	case page && q == "on":
+		b = 0
+	case page && q == "off":
+		b = 99
+	default:
+		if ck, err := r.Cookie(canaryCookie); err == nil {
+			if n, err := strconv.Atoi(ck.Value); err == nil && n >= 0 && n < 100 {
+				return n
+			}
+		}
+		if !page {
+			return 99
+		}
+		b = rand.IntN(100)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     canaryCookie,
+		Value:    strconv.Itoa(b),
+		Path:     "/",
+		MaxAge:   int(canaryCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.proxies.scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return b
+}
+
+// showCanary is GET /api/admin/canary.
+func (a *adminAPI) showCanary(w http.ResponseWriter, r *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, map[string]int{"percent": int(a.canary.percent.Load()), "default": a.canary.initial})
+}
+
+// setCanary is PUT /api/admin/canary with {"percent": n}: 100 promotes the
+// canary to everyone and 0 rolls it back.
+func (a *adminAPI) setCanary(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Percent *int `json:"percent"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if body.Percent == nil || *body.Percent < 0 || *body.Percent > 100 {
+		writeError(w, http.StatusUnprocessableEntity, "percent must be 0 to 100")
+		return
+	}
+	before := a.canary.percent.Load()
+	if err := a.canary.set(r.Context(), *body.Percent); err != nil {
+		a.fail(w, "set canary", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{
+		Actor: actor, Action: "canary.set", TargetKind: "canary", TargetID: strconv.Itoa(*body.Percent),
+		Before: auditState(map[string]int{"percent": int(before)}), After: auditState(map[string]int{"percent": int(a.canary.percent.Load())}),
+	})
+}
+
+// resetCanary is DELETE /api/admin/canary, going back to -canary-percent.
+func (a *adminAPI) resetCanary(w http.ResponseWriter, r *http.Request, actor string) {
+	before := a.canary.percent.Load()
+	if err := a.canary.set(r.Context(), -1); err != nil {
+		a.fail(w, "reset canary", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{
+		Actor: actor, Action: "canary.reset", TargetKind: "canary", TargetID: strconv.Itoa(a.canary.initial),
+		Before: auditState(map[string]int{"percent": int(before)}), After: auditState(map[string]int{"percent": int(a.canary.percent.Load())}),
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// withCanonicalHost redirects requests for another hostname or scheme than
+// publicURL's, such as www.example.com or plain HTTP, to the same path
+// there, so search engines index one URL per page. Health probes and
+// metrics, which load balancers and scrapers send to whatever address
+// they were given, are answered where they arrive.
+func withCanonicalHost(publicURL string, proxies trustedProxies, next http.Handler) http.Handler {
+	canonical, _ := url.Parse(publicURL)
+	host := strings.ToLower(canonical.Host)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.EqualFold(r.Host, host) && proxies.scheme(r) == canonical.Scheme {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target := canonical.Scheme + "://" + host + r.URL.RequestURI()
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			// Keeps the method and body, which 301 needn't.
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, code)
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	cdnFastly     = "fastly"
+	cdnCloudflare = "cloudflare"
+)
+
+// cdnCheckInterval is how often the build is checked for a new one to purge
+// the CDN for.
+const cdnCheckInterval = time.Minute
+
+// cdnBuildKey holds the build the CDN was last purged for. Replicas sharing
+// -redis agree on it, so only the first to notice a new build purges.
+const cdnBuildKey = "cdn:build"
+
+const (
+	fastlyAPI     = "https://api.fastly.com"
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+)
+
+var cdnPurges = defaultRegistry.counter("loderunner_cdn_purges_total",
+	"CDN purges by result.", "result")
+
+// cdnPurger purges Fastly or Cloudflare when the build served changes, so
+// edge caches holding index.html and other unhashed files pick up a deploy
+// without waiting for them to expire. Builds are told apart by
+// index.html's ETag.
+type cdnPurger struct {
+	provider string
+	token    string
+	service  string
+	keys     []string // empty purges everything
+	client   *http.Client
+
+	store ephemeral.Store
+	dist  buildHandler
+}
+
+// newCDNPurger returns nil without -cdn-purge.
+func newCDNPurger(cfg config, store ephemeral.Store, dist buildHandler) *cdnPurger {
+	if cfg.CDNPurge == "" {
+		return nil
+	}
+	return &cdnPurger{
+		provider: cfg.CDNPurge,
+		token:    cfg.CDNPurgeToken,
+		service:  cfg.CDNPurgeService,
+		keys:     cfg.CDNPurgeKeys,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		store:    store,
+		dist:     dist,
+	}
+}
+
+// watch checks for a new build at startup and then every cdnCheckInterval
+// until ctx is done.
+func (p *cdnPurger) watch(ctx context.Context) {
+	ticker := time.NewTicker(cdnCheckInterval)
+	defer ticker.Stop()
+	for {
+		if build, purged, err := p.check(ctx, false); err != nil && ctx.Err() == nil {
+			log.Printf("cdn: %v", err)
+		} else if purged {
+			log.Printf("🧹 Purged %s for build %s", p.provider, build)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// build returns the ETag of the index.html served now.
+func (p *cdnPurger) build() (string, error) {
+	fsys, etags := p.dist.served()
+	fi, err := fs.Stat(fsys, "index.html")
+	if err != nil {
+		return "", err
+	}
+	etag := etags.get(fsys, "index.html", fi)
+	if etag == "" {
+		return "", errors.New("can't read index.html")
+	}
+	return strings.Trim(etag, `"`), nil
+}
+
+// check purges the CDN if the build has changed since it was last purged,
+// or regardless with force, and reports whether it did.
+func (p *cdnPurger) check(ctx context.Context, force bool) (build string, purged bool, err error) {
+	if build, err = p.build(); err != nil {
+		return "", false, err
+	}
+	var changed bool
+	err = p.store.Update(ctx, cdnBuildKey, 0, func(old []byte) ([]byte, error) {
+		changed = force || string(old) != build
+		return []byte(build), nil
+	})
+	if err != nil || !changed {
+		return build, false, err
+	}
+	if err := p.purge(ctx); err != nil {
+		cdnPurges.inc("error")
+		// Try again next time.
+		p.store.Delete(context.WithoutCancel(ctx), cdnBuildKey)
+		return build, false, err
+	}
+	cdnPurges.inc("ok")
+	return build, true, nil
+}
+
+func (p *cdnPurger) purge(ctx context.Context) error {
+	if p.provider == cdnFastly {
+		return p.purgeFastly(ctx)
+	}
+	return p.purgeCloudflare(ctx)
+}
+
+// purgeFastly soft-purges the -cdn-purge-key keys, so that Fastly may keep
+// serving the old copies while it revalidates, or purges the whole service.
+func (p *cdnPurger) purgeFastly(ctx context.Context) error {
+	u := fastlyAPI + "/service/" + url.PathEscape(p.service) + "/purge_all"
+	if len(p.keys) > 0 {
+		u = fastlyAPI + "/service/" + url.PathEscape(p.service) + "/purge"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", p.token)
+	req.Header.Set("Accept", "application/json")
+	if len(p.keys) > 0 {
+		req.Header.Set("Surrogate-Key", strings.Join(p.keys, " "))
+		req.Header.Set("Fastly-Soft-Purge", "1")
+	}
+	_, err = p.do(req)
+	return err
+}
+
+// purgeCloudflare purges the -cdn-purge-key tags or the whole zone.
+func (p *cdnPurger) purgeCloudflare(ctx context.Context) error {
+	body := map[string]any{"purge_everything": true}
+	if len(p.keys) > 0 {
+		body = map[string]any{"tags": p.keys}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := cloudflareAPI + "/zones/" + url.PathEscape(p.service) + "/purge_cache"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return fmt.Errorf("cloudflare: %w", err)
+	}
+	if !result.Success {
+		msgs := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			msgs[i] = e.Message
+		}
+		return fmt.Errorf("cloudflare: purge failed: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// do sends req and returns the response body, or an error for anything
+// but a 2xx.
+func (p *cdnPurger) do(req *http.Request) ([]byte, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s: %s", p.provider, resp.Status, bytes.TrimSpace(body[:min(len(body), 200)]))
+	}
+	return body, nil
+}
+
+// purgeCDN is POST /api/admin/cdn/purge, for deploy scripts that would
+// rather not wait for the next check. It purges if the build is new, or
+// with {"force": true} regardless.
+func (a *adminAPI) purgeCDN(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Force bool `json:"force"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+	build, purged, err := a.cdn.check(r.Context(), body.Force)
+	if err != nil {
+		log.Printf("cdn: %v", err)
+		writeError(w, http.StatusBadGateway, "purge failed")
+		return
+	}
+	if purged {
+		e := storage.AuditEntry{Actor: actor, Action: "cdn.purge", TargetKind: "build", TargetID: build, Detail: a.cdn.provider}
+		if err := a.db.RecordAudit(r.Context(), e); err != nil {
+			a.fail(w, "record audit", err)
+			return
+		}
+		log.Printf("🛡️  %s: %s %s %s", e.Actor, e.Action, e.TargetKind, e.TargetID)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"build": build, "purged": purged})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+)
+
+const (
+	challengePoW       = "pow"
+	challengeTurnstile = "turnstile"
+	challengeHCaptcha  = "hcaptcha"
+)
+
+// defaultChallengeRules are the soft limits past which a client must solve
+// a challenge, applied after any -challenge-rule ones. They sit well inside
+// the rate limits, so a player never meets one and a bot meets it long
+// before it is refused outright.
+var defaultChallengeRules = []string{
+	"POST /api/scores -> 60/h burst 20",
+	"POST /api/levels -> 10/h burst 5",
+	"POST /api/auth/register -> 5/h",
+}
+
+// challengeHeader carries the solution to a challenge.
+const challengeHeader = "X-Challenge-Response"
+
+// powTTL is how long a proof-of-work challenge can be solved for.
+const powTTL = 5 * time.Minute
+
+const (
+	turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	hcaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+)
+
+var challengeResults = defaultRegistry.counter("loderunner_challenges_total",
+	"Challenges issued to clients over a soft limit, and solutions passed and failed.", "result")
+
+// A challenger sets challenges and checks their solutions.
+type challenger interface {
+	// issue returns what a client needs to solve a new challenge.
+	issue(ctx context.Context) (map[string]any, error)
+	// verify reports whether response solves a challenge. An error means
+	// it couldn't tell.
+	verify(ctx context.Context, r *http.Request, response string) (bool, error)
+}
+
+// challengeGate keeps a token bucket per client IP and -challenge-rule,
+// like the rate limiter, and asks clients whose bucket is empty to solve a
+// challenge with each request until it refills.
+type challengeGate struct {
+	rules   []rateRule
+	proxies trustedProxies
+	store   ephemeral.Store
+	solver  challenger
+
+	failing atomic.Bool // the store was unreachable at the last request
+}
+
+// newChallengeGate returns nil without -challenge.
+func newChallengeGate(cfg config, proxies trustedProxies, store ephemeral.Store) (*challengeGate, error) {
+	if cfg.Challenge == "" {
+		return nil, nil
+	}
+	g := &challengeGate{proxies: proxies, store: store}
+	for _, line := range append(append([]string(nil), cfg.ChallengeRules...), defaultChallengeRules...) {
+		rule, err := parseRateRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("-challenge-rule: %w", err)
+		}
+		g.rules = append(g.rules, rule)
+	}
+	switch cfg.Challenge {
+	case challengePoW:
+		g.solver = &powChallenger{store: store, bits: cfg.ChallengeBits}
+	case challengeTurnstile, challengeHCaptcha:
+		verifyURL := turnstileVerifyURL
+		if cfg.Challenge == challengeHCaptcha {
+			verifyURL = hcaptchaVerifyURL
+		}
+		g.solver = &captchaChallenger{
+			provider:  cfg.Challenge,
+			verifyURL: verifyURL,
+			siteKey:   cfg.ChallengeSiteKey,
+			secret:    cfg.ChallengeSecret,
+			proxies:   proxies,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return g, nil
+}
+
+// over reports whether the request is past its rule's soft limit. Like the
+// rate limiter, it lets requests through while the store is unreachable.
+func (g *challengeGate) over(r *http.Request) bool {
+	for _, rr := range g.rules {
+		if !rr.matches(r) {
+			continue
+		}
+		if rr.rate == 0 {
+			return false
+		}
+		retry, err := g.store.Allow(r.Context(), "challenge:"+rr.name+":"+g.proxies.clientIP(r), rr.rate, rr.burst)
+		if err != nil {
+			if !g.failing.Swap(true) {
+				log.Printf("⚠️  Challenges suspended: %v", err)
+			}
+			return false
+		}
+		if g.failing.Swap(false) {
+			log.Printf("🧩 Challenges resumed")
+		}
+		return retry > 0
+	}
+	return false
+}
+
+// requireChallenges answers requests over a soft limit that don't carry a
+// solved challenge with 428 and a new one. A challenge that can't be set
+// or checked lets the request through.
+func requireChallenges(g *challengeGate, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.over(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg := "solve the challenge and try again"
+		if response := r.Header.Get(challengeHeader); response != "" {
+			ok, err := g.solver.verify(r.Context(), r, response)
+			if err != nil {
+				log.Printf("challenge: verify: %v (request %s)", err, requestID(w))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok {
+				challengeResults.inc("passed")
+				next.ServeHTTP(w, r)
+				return
+			}
+			challengeResults.inc("failed")
+			msg = "challenge failed; try this one"
+		}
+		c, err := g.solver.issue(r.Context())
+		if err != nil {
+			log.Printf("challenge: issue: %v (request %s)", err, requestID(w))
+			next.ServeHTTP(w, r)
+			return
+		}
+		challengeResults.inc("issued")
+		writeJSON(w, http.StatusPreconditionRequired, map[string]any{
+			"error":      msg,
+			"challenge":  c,
+			"request_id": requestID(w),
+		})
+	})
+}
+
+// powChallenger sets proof-of-work challenges: find a nonce such that the
+// SHA-256 of "token:nonce" starts with bits zero bits. It takes a browser
+// about a second at 20 bits, asks nothing of third parties, and each token
+// works once.
+type powChallenger struct {
+	store ephemeral.Store
+	bits  int
+}
+
+func (c *powChallenger) issue(ctx context.Context) (map[string]any, error) {
+	token := randomToken(16)
+	if err := c.store.Set(ctx, "challenge:pow:"+token, []byte{1}, powTTL); err != nil {
+		return nil, err
+	}
+	return map[string]any{"type": challengePoW, "token": token, "bits": c.bits}, nil
+}
+
+// verify checks a response of "token:nonce".
+func (c *powChallenger) verify(ctx context.Context, _ *http.Request, response string) (bool, error) {
+	token, _, ok := strings.Cut(response, ":")
+	if !ok || leadingZeroBits(sha256.Sum256([]byte(response))) < c.bits {
+		return false, nil
+	}
+	b, err := c.store.Pop(ctx, "challenge:pow:"+token)
+	return b != nil, err
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// captchaChallenger hands out a Cloudflare Turnstile or hCaptcha site key
+// for the game to show the widget with, and checks the widget's response
+// with the provider. Both providers take the same form and answer alike.
+type captchaChallenger struct {
+	provider  string
+	verifyURL string
+	siteKey   string
+	secret    string
+	proxies   trustedProxies
+	client    *http.Client
+}
+
+func (c *captchaChallenger) issue(context.Context) (map[string]any, error) {
+	return map[string]any{"type": c.provider, "site_key": c.siteKey}, nil
+}
+
+func (c *captchaChallenger) verify(ctx context.Context, r *http.Request, response string) (bool, error) {
+	form := url.Values{"secret": {c.secret}, "response": {response}, "remoteip": {c.proxies.clientIP(r)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("%s: %s", c.provider, resp.Status)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return false, fmt.Errorf("%s: %w", c.provider, err)
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxChatMessage = 200
+	chatHistory    = 50 // lines sent to a player joining a room
+	chatRetention  = 7 * 24 * time.Hour
+	maxChatMute    = 365 * 24 * 60 // minutes; leave them out to mute for good
+
+	// A player may send chatBurst lines at once, then one every
+	// 1/chatRate seconds.
+	chatRate  = 0.5
+	chatBurst = 5
+)
+
+var (
+	errChatSignIn      = errors.New("sign in to chat")
+	errChatMuted       = errors.New("you are muted")
+	errChatTooFast     = errors.New("you're chatting too fast; wait a moment")
+	errChatUnavailable = errors.New("chat is unavailable")
+)
+
+var chatMessages = defaultRegistry.counter("loderunner_chat_messages_total",
+	"Lobby chat lines, by outcome.", "outcome")
+
+// chat sends a line of chat to the player's room. Only signed-in players
+// can chat, so moderators can tell who said what, mute them and ban them;
+// the line is kept for chatRetention for players who join later and for
+// moderators to look back over.
+func (a *lobbyAPI) chat(ctx context.Context, p *lobby.Player, account *storage.Player, text string) error {
+	room := p.Room()
+	if room == nil {
+		return lobby.ErrNotInRoom
+	}
+	if a.db == nil || account == nil {
+		return errChatSignIn
+	}
+	if err := checkText("text", text, maxChatMessage, true); err != nil {
+		return err
+	}
+	// Like the rate limiter, let chat through if the store is down.
+	if retry, err := a.store.Allow(ctx, "chat:"+strconv.FormatInt(account.ID, 10), chatRate, chatBurst); err != nil {
+		log.Printf("chat: rate limit: %v", err)
+	} else if retry > 0 {
+		chatMessages.inc("limited")
+		return errChatTooFast
+	}
+	if _, err := a.db.ChatMuted(ctx, account.ID, time.Now()); err == nil {
+		chatMessages.inc("muted")
+		return errChatMuted
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("chat: mute lookup: %v", err)
+		return errChatUnavailable
+	}
+	if banned, err := a.db.Banned(ctx, account.ID, ""); err != nil {
+		log.Printf("chat: ban lookup: %v", err)
+		return errChatUnavailable
+	} else if banned {
+		chatMessages.inc("banned")
+		return errors.New("banned")
+	}
+	if !a.filter.check(ctx, moderation.Message, text) {
+		chatMessages.inc("filtered")
+		return errors.New("that message isn't allowed")
+	}
+	if _, err := a.db.AddChatMessage(ctx, storage.ChatMessage{Room: room.Code, PlayerID: account.ID, Name: p.Name, Text: text}); err != nil {
+		log.Printf("chat: add message: %v", err)
+		return errChatUnavailable
+	}
+	chatMessages.inc("sent")
+	return room.Chat(ctx, p, text)
+}
+
+// sendChatHistory sends a player who has just joined a room the chat
+// since it opened. Chat being unavailable doesn't stop them joining.
+func (a *lobbyAPI) sendChatHistory(ctx context.Context, p *lobby.Player, room *lobby.Room) {
+	if a.db == nil {
+		return
+	}
+	messages, err := a.db.ChatHistory(ctx, room.Code, room.Created, chatHistory)
+	if err != nil {
+		log.Printf("chat: history: %v", err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	lines := make([]lobby.ChatLine, len(messages))
+	for i, m := range messages {
+		lines[i] = lobby.ChatLine{Name: m.Name, Text: m.Text, Time: m.CreatedAt}
+	}
+	p.Send(lobby.Message{Type: "history", Room: room.Code, Chat: lines})
+}
+
+// pruneChat deletes chat older than chatRetention, and mutes that have
+// run out, every hour.
+func pruneChat(ctx context.Context, db *storage.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := db.PruneChat(ctx, time.Now().Add(-chatRetention)); err != nil {
+				log.Printf("chat: prune: %v", err)
+			} else if n > 0 {
+				log.Printf("💬 Pruned %d chat messages", n)
+			}
+		}
+	}
+}
+
+// chatMessages lists chat newest first, in one room with ?room= or from
+// one account with ?player_id=.
+func (a *adminAPI) chatMessages(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	playerID, err := queryInt(r, "player_id", 0, 0, math.MaxInt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	messages, total, err := a.db.ChatMessages(r.Context(), r.URL.Query().Get("room"), int64(playerID), limit, offset)
+	if err != nil {
+		a.fail(w, "chat messages", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"messages": messages,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// deleteChatMessage removes a line of chat from the history. Players who
+// already saw it still have it on screen.
+func (a *adminAPI) deleteChatMessage(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.DeleteChatMessage(r.Context(), id), "message not found") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "chat.delete", TargetKind: "message", TargetID: strconv.FormatInt(id, 10), Detail: reason})
+}
+
+func (a *adminAPI) chatMutes(w http.ResponseWriter, r *http.Request, _ string) {
+	mutes, err := a.db.ChatMutes(r.Context(), time.Now())
+	if err != nil {
+		a.fail(w, "chat mutes", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"mutes": mutes})
+}
+
+// muteChat stops an account chatting, for {"minutes": N} or, without
+// minutes, until unmuted. Muting a player again replaces their mute.
+func (a *adminAPI) muteChat(w http.ResponseWriter, r *http.Request, actor string) {
+	var req struct {
+		PlayerID int64  `json:"player_id"`
+		Minutes  int    `json:"minutes"`
+		Reason   string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	switch {
+	case req.PlayerID <= 0:
+		writeError(w, http.StatusUnprocessableEntity, "player_id is required")
+		return
+	case req.Minutes < 0 || req.Minutes > maxChatMute:
+		writeError(w, http.StatusUnprocessableEntity, "minutes must be 0-"+strconv.Itoa(maxChatMute))
+		return
+	}
+	if err := checkText("reason", req.Reason, maxReasonLength, false); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	m := storage.ChatMute{PlayerID: req.PlayerID, Reason: req.Reason, CreatedBy: actor}
+	if req.Minutes > 0 {
+		expires := time.Now().Add(time.Duration(req.Minutes) * time.Minute).UTC()
+		m.ExpiresAt = &expires
+	}
+	m, err := a.db.MuteChat(r.Context(), m)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "player not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "mute chat", err)
+		return
+	}
+	id := strconv.FormatInt(m.PlayerID, 10)
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "chat.mute", TargetKind: "player", TargetID: id, Detail: m.Reason, After: auditState(m)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: chat.mute player %s", actor, id)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (a *adminAPI) unmuteChat(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.UnmuteChat(r.Context(), id), "player isn't muted") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "chat.unmute", TargetKind: "player", TargetID: strconv.FormatInt(id, 10), Detail: reason})
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxClanName        = 32
+	maxClanDescription = 200
+	maxClanMembers     = 50
+	inviteCodeLength   = 8
+)
+
+// clanTagPattern is what a clan's tag, shown next to its members' names,
+// may be once upper-cased.
+var clanTagPattern = regexp.MustCompile(`^[A-Z0-9]{2,5}$`)
+
+// clanWeek returns the ISO week t falls in, like 2026-W03, and when it
+// starts and ends: Monday to Monday, UTC.
+func clanWeek(t time.Time) (week string, start, end time.Time) {
+	t = t.UTC()
+	year, n := t.ISOWeek()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	start = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	return fmt.Sprintf("%04d-W%02d", year, n), start, start.AddDate(0, 0, 7)
+}
+
+// parseClanWeek returns when the ISO week named like 2026-W03 starts.
+func parseClanWeek(s string) (time.Time, bool) {
+	var year, n int
+	if _, err := fmt.Sscanf(s, "%4d-W%2d", &year, &n); err != nil {
+		return time.Time{}, false
+	}
+	// 4 January is always in week 1.
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	start := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(n-1)*7)
+	if week, _, _ := clanWeek(start); week != s {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// rotateClans draws each week's clan-vs-clan pairs and settles the weeks
+// that are over, at once and then every hour until ctx is done.
+func rotateClans(ctx context.Context, db *storage.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		week, start, end := clanWeek(now)
+		if err := db.DrawClanWeek(ctx, week, start, end); err != nil {
+			log.Printf("clans: draw %s: %v", week, err)
+		}
+		if n, err := db.SettleClanWeeks(ctx, now); err != nil {
+			log.Printf("clans: settle: %v", err)
+		} else if n > 0 {
+			log.Printf("⚔️  Settled %d clan weeks", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// clansAPI serves /api/clans: creating and joining clans, their profiles,
+// the clan leaderboard and the weekly clan-vs-clan rotation.
+type clansAPI struct {
+	db     *storage.DB
+	filter *contentFilter
+}
+
+func (a *clansAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/clans", a.leaderboard)
+	mux.HandleFunc("POST /api/clans", a.create)
+	mux.HandleFunc("GET /api/clans/mine", a.mine)
+	mux.HandleFunc("GET /api/clans/rotation", a.rotation)
+	mux.HandleFunc("POST /api/clans/join", a.join)
+	mux.HandleFunc("POST /api/clans/leave", a.leave)
+	mux.HandleFunc("GET /api/clans/{id}", a.show)
+	mux.HandleFunc("POST /api/clans/{id}/invite", a.newInvite)
+	mux.HandleFunc("DELETE /api/clans/{id}/members/{player}", a.removeMember)
+}
+
+func (a *clansAPI) leaderboard(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 10, 1, 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1_000_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	season, err := querySeason(r, a.db)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	clans, total, err := a.db.ClanLeaderboard(r.Context(), storage.ClanBoard{Season: season}, limit, offset)
+	if err != nil {
+		a.fail(w, "clan leaderboard", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"clans": clans, "season": season, "total": total, "limit": limit, "offset": offset})
+}
+
+func (a *clansAPI) create(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name        string `json:"name"`
+		Tag         string `json:"tag"`
+		Description string `json:"description"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	c := storage.Clan{
+		Name:        strings.Join(strings.Fields(req.Name), " "),
+		Tag:         strings.ToUpper(strings.TrimSpace(req.Tag)),
+		Description: strings.TrimSpace(req.Description),
+	}
+	for _, err := range []error{
+		checkText("name", c.Name, maxClanName, true),
+		checkText("description", c.Description, maxClanDescription, false),
+	} {
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	if utf8.RuneCountInString(c.Name) < 3 {
+		writeError(w, http.StatusUnprocessableEntity, "name must be at least 3 characters")
+		return
+	}
+	if !clanTagPattern.MatchString(c.Tag) {
+		writeError(w, http.StatusUnprocessableEntity, "tag must be 2-5 letters or digits")
+		return
+	}
+	if !a.filter.allow(w, r, "name", moderation.Name, c.Name) ||
+		!a.filter.allow(w, r, "tag", moderation.Name, c.Tag) ||
+		!a.filter.allow(w, r, "description", moderation.Message, c.Description) {
+		return
+	}
+	var created storage.Clan
+	var err error
+	for range shortCodeAttempts {
+		c.InviteCode = randomCode(inviteCodeLength)
+		if created, err = a.db.CreateClan(r.Context(), c, p.ID); !errors.Is(err, storage.ErrInviteCodeTaken) {
+			break
+		}
+	}
+	switch {
+	case errors.Is(err, storage.ErrClanTaken):
+		writeError(w, http.StatusConflict, "clan name or tag already taken")
+	case errors.Is(err, storage.ErrInClan):
+		writeError(w, http.StatusConflict, "already in a clan")
+	case err != nil:
+		a.fail(w, "create clan", err)
+	default:
+		a.profile(w, r, created.ID, http.StatusCreated)
+	}
+}
+
+// mine is the signed-in player's clan.
+func (a *clansAPI) mine(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, _, err := a.db.PlayerClan(r.Context(), p.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not in a clan")
+		return
+	}
+	if err != nil {
+		a.fail(w, "player clan", err)
+		return
+	}
+	a.profile(w, r, id, http.StatusOK)
+}
+
+func (a *clansAPI) show(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	a.profile(w, r, id, http.StatusOK)
+}
+
+// profile writes the clan page: the clan with its all-time rank, its
+// members, this week's matchup if it has one, and for members the invite
+// code.
+func (a *clansAPI) profile(w http.ResponseWriter, r *http.Request, id int64, status int) {
+	ctx := r.Context()
+	c, err := a.db.GetClan(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "clan not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get clan", err)
+		return
+	}
+	if c.Rank, err = a.db.ClanRank(ctx, storage.ClanBoard{}, c.Score); err != nil {
+		a.fail(w, "rank clan", err)
+		return
+	}
+	members, err := a.db.ClanMembers(ctx, id)
+	if err != nil {
+		a.fail(w, "clan members", err)
+		return
+	}
+	week, err := a.week(ctx, time.Now())
+	if err != nil {
+		a.fail(w, "clan week", err)
+		return
+	}
+	var matchup *storage.ClanMatchup
+	for i, m := range week.Matchups {
+		if m.Clans[0] != nil && m.Clans[0].ID == id || m.Clans[1] != nil && m.Clans[1].ID == id {
+			matchup = &week.Matchups[i]
+		}
+	}
+	resp := map[string]any{"clan": c, "members": members, "week": week.Week, "matchup": matchup}
+	if p := currentPlayer(r); p != nil {
+		for _, m := range members {
+			if m.PlayerID == p.ID {
+				resp["invite_code"] = c.InviteCode
+			}
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+// week returns the rotation's week at t, drawing it if this is the first
+// time it's been asked for.
+func (a *clansAPI) week(ctx context.Context, t time.Time) (storage.ClanWeek, error) {
+	week, start, end := clanWeek(t)
+	if err := a.db.DrawClanWeek(ctx, week, start, end); err != nil {
+		return storage.ClanWeek{}, err
+	}
+	return a.db.GetClanWeek(ctx, week)
+}
+
+// rotation returns a week of clan-vs-clan matchups, this week's by
+// default.
+func (a *clansAPI) rotation(w http.ResponseWriter, r *http.Request) {
+	var week storage.ClanWeek
+	var err error
+	if v := r.URL.Query().Get("week"); v != "" {
+		if _, ok := parseClanWeek(v); !ok {
+			writeError(w, http.StatusBadRequest, "week must be an ISO week like 2026-W03")
+			return
+		}
+		week, err = a.db.GetClanWeek(r.Context(), v)
+	} else {
+		week, err = a.week(r.Context(), time.Now())
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no rotation that week")
+		return
+	}
+	if err != nil {
+		a.fail(w, "clan week", err)
+		return
+	}
+	if week.Settled {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	writeJSON(w, http.StatusOK, week)
+}
+
+func (a *clansAPI) join(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	id, err := a.db.JoinClan(r.Context(), strings.ToLower(strings.TrimSpace(req.Code)), p.ID, maxClanMembers)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "invite code not found")
+	case errors.Is(err, storage.ErrInClan):
+		writeError(w, http.StatusConflict, "already in a clan")
+	case errors.Is(err, storage.ErrClanFull):
+		writeError(w, http.StatusConflict, fmt.Sprintf("clan is full (%d members)", maxClanMembers))
+	case err != nil:
+		a.fail(w, "join clan", err)
+	default:
+		a.profile(w, r, id, http.StatusOK)
+	}
+}
+
+func (a *clansAPI) leave(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	switch err := a.db.LeaveClan(r.Context(), p.ID); {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not in a clan")
+	case err != nil:
+		a.fail(w, "leave clan", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// owner returns the {id} clan if the signed-in player owns it, writing an
+// error otherwise.
+func (a *clansAPI) owner(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return 0, false
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return 0, false
+	}
+	clanID, role, err := a.db.PlayerClan(r.Context(), p.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		a.fail(w, "player clan", err)
+		return 0, false
+	}
+	if clanID != id || role != storage.ClanRoleOwner {
+		writeError(w, http.StatusForbidden, "only the clan's owner can do that")
+		return 0, false
+	}
+	return id, true
+}
+
+// newInvite replaces the clan's invite code, so the old one stops working.
+func (a *clansAPI) newInvite(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	var code string
+	err := storage.ErrInviteCodeTaken
+	for i := 0; i < shortCodeAttempts && errors.Is(err, storage.ErrInviteCodeTaken); i++ {
+		code = randomCode(inviteCodeLength)
+		err = a.db.SetClanInviteCode(r.Context(), id, code)
+	}
+	if err != nil {
+		a.fail(w, "new invite code", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"invite_code": code})
+}
+
+func (a *clansAPI) removeMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	player, err := strconv.ParseInt(r.PathValue("player"), 10, 64)
+	if err != nil || player <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid player id")
+		return
+	}
+	switch err := a.db.RemoveClanMember(r.Context(), id, player); {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "no such member, or it's the owner")
+	case err != nil:
+		a.fail(w, "remove member", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *clansAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("clans: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipRanges is a set of addresses given as CIDR ranges.
+type ipRanges []netip.Prefix
+
+// parseIPRanges accepts CIDR ranges and bare addresses.
+func parseIPRanges(items []string) (ipRanges, error) {
+	var ranges ipRanges
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", item, err)
+			}
+			ranges = append(ranges, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		ranges = append(ranges, p.Masked())
+	}
+	return ranges, nil
+}
+
+func (ranges ipRanges) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range ranges {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies lists the reverse proxies whose forwarding headers are
+// believed. Requests from anywhere else are taken at face value, since any
+// client can send the headers.
+type trustedProxies struct {
+	ipRanges
+	header string // canonical -client-ip-header
+}
+
+func parseTrustedProxies(items []string, header string) (trustedProxies, error) {
+	ranges, err := parseIPRanges(items)
+	if err != nil {
+		return trustedProxies{}, fmt.Errorf("-trusted-proxies: %w", err)
+	}
+	if header = http.CanonicalHeaderKey(strings.TrimSpace(header)); header == "" {
+		return trustedProxies{}, errors.New("-client-ip-header must not be empty")
+	}
+	return trustedProxies{ranges, header}, nil
+}
+
+// proxied reports whether the request arrived through a trusted proxy, so
+// headers the proxy sets can be believed.
+func (t trustedProxies) proxied(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteIP(r))
+	return err == nil && t.contains(addr)
+}
+
+// scheme returns "https" for requests that came over TLS, to this server
+// or, as X-Forwarded-Proto or Forwarded say, to a trusted proxy in front.
+func (t trustedProxies) scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if t.proxied(r) {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if t.header == "Forwarded" {
+			proto = forwardedProto(r.Header.Get("Forwarded"))
+		}
+		if p, _, _ := strings.Cut(proto, ","); strings.EqualFold(strings.TrimSpace(p), "https") {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// clientIP returns the address of the client behind any trusted proxies.
+// X-Forwarded-For and Forwarded are read right to left, because each
+// proxy appends the address it saw; the first hop that isn't a trusted
+// proxy is the client. Any other header holds the client's address alone,
+// as CF-Connecting-IP does.
+func (t trustedProxies) clientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !t.contains(addr) {
+		return remote
+	}
+	var hops []string
+	switch t.header {
+	case "X-Forwarded-For":
+		hops = strings.Split(strings.Join(r.Header.Values(t.header), ","), ",")
+	case "Forwarded":
+		hops = forwardedFor(r.Header.Values(t.header))
+	default:
+		if hop, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(t.header))); err == nil {
+			addr = hop
+		}
+		return addr.Unmap().String()
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !t.contains(hop) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
+// forwardedFor returns the for= address of each element of RFC 7239
+// Forwarded headers, without quotes, brackets or ports. Elements without
+// one, or with an obfuscated one such as "unknown", give "", which stops
+// the walk in clientIP.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if !strings.EqualFold(key, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				if host, _, err := net.SplitHostPort(value); err == nil {
+					value = host
+				}
+				hop = strings.Trim(value, "[]")
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// forwardedProto returns the proto= of the first element of a Forwarded
+// header, the one the proxy nearest the client added.
+func forwardedProto(v string) string {
+	elem, _, _ := strings.Cut(v, ",")
+	for _, pair := range strings.Split(elem, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if strings.EqualFold(key, "proto") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
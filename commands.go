@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// commands are the server's subcommands. Without one it serves, as it
+// always has.
+var commands = map[string]func(){
+	"serve":   serve,
+	"version": runVersion,
+	"check":   runCheck,
+	"migrate": runMigrate,
+	"import":  runImport,
+	"restore": runRestore,
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [command] [flags]
+
+Commands:
+  serve     run the server (the default)
+  version   print build information
+  check     validate the configuration and dist/, then exit
+  migrate   bring the database schema up to date, then exit
+  import    add the levels of classic level packs: import [flags] FILE...
+  restore   replace the SQLite database with a snapshot: restore [flags] [SNAPSHOT]
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func runVersion() {
+	b := readBuildInfo()
+	fmt.Printf("loderunner2099 %s\n", b.Version)
+	if b.Commit != "" {
+		dirty := ""
+		if b.Modified {
+			dirty = " (modified)"
+		}
+		fmt.Printf("commit  %s%s\n", b.Commit, dirty)
+	}
+	if b.BuildTime != "" {
+		fmt.Printf("built   %s\n", b.BuildTime)
+	}
+	fmt.Printf("go      %s\n", b.GoVersion)
+	_, embedded := embeddedFS()
+	fmt.Printf("dist    embedded=%t\n", embedded)
+}
+
+// runCheck validates everything serve would refuse to start with, plus
+// the build itself, and exits non-zero if anything is wrong. It is meant
+// for CI and for deploy scripts before a restart.
+func runCheck() {
+	cfg := loadConfig()
+	var problems []string
+	fail := func(err error) { problems = append(problems, err.Error()) }
+
+	if err := cfg.validate(); err != nil {
+		fail(err)
+	}
+	if _, err := newCachePolicy(cfg); err != nil {
+		fail(err)
+	}
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies, cfg.ClientIPHeader)
+	if err != nil {
+		fail(err)
+	}
+	if _, err := newRateLimiter(cfg, proxies, nil); err != nil {
+		fail(err)
+	}
+	if _, err := newIPFilter(cfg, proxies); err != nil {
+		fail(err)
+	}
+	if _, err := newProxyRoutes(cfg, proxies); err != nil {
+		fail(err)
+	}
+	if _, err := newErrorReporter(cfg, readBuildInfo()); err != nil {
+		fail(err)
+	}
+	if !cfg.Dev {
+		checkBuild(cfg, true, fail)
+	}
+	if cfg.Canary != "" {
+		canary := cfg
+		canary.DistDir, canary.Origin = cfg.Canary, ""
+		checkBuild(canary, false, func(err error) { fail(fmt.Errorf("-canary: %w", err)) })
+	}
+	sites, _ := parseSites(cfg.Sites)
+	for _, site := range sites {
+		failSite := func(err error) { fail(fmt.Errorf("-site %s: %w", site.name(), err)) }
+		sc := site.config(cfg)
+		if _, err := newCachePolicy(sc); err != nil {
+			failSite(err)
+		}
+		checkBuild(sc, false, failSite)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "✗ %s\n", p)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("✓ configuration and dist/ look good")
+}
+
+// checkBuild checks the build cfg serves, which may be the embedded one if
+// embed is set.
+func checkBuild(cfg config, embed bool, fail func(error)) {
+	var fsys fs.FS
+	var embedded bool
+	if embed {
+		fsys, embedded = embeddedFS()
+	}
+	switch {
+	case cfg.Origin != "":
+		fsys = nil
+		if origin, err := listOrigin(cfg); err != nil {
+			fail(err)
+		} else {
+			fsys = origin
+		}
+	case !embedded:
+		fsys = distFS(cfg.DistDir)
+	}
+	if fsys != nil {
+		for _, err := range checkDist(fsys) {
+			fail(err)
+		}
+		if _, err := newPreloader(cfg, fsys); err != nil {
+			fail(fmt.Errorf("preload: %w", err))
+		}
+	}
+}
+
+// checkDist reports a missing index.html and any local file it references
+// that isn't in the build.
+func checkDist(fsys fs.FS) []error {
+	page, err := fs.ReadFile(fsys, "index.html")
+	if err != nil {
+		return []error{fmt.Errorf("dist: %w", err)}
+	}
+	var errs []error
+	for _, ref := range htmlReferences(string(page)) {
+		name := strings.TrimPrefix(path.Clean("/"+ref), "/")
+		if fi, err := fs.Stat(fsys, name); err != nil || !fi.Mode().IsRegular() {
+			errs = append(errs, fmt.Errorf("dist: index.html references %s, which isn't in the build", ref))
+		}
+	}
+	return errs
+}
+
+// htmlReferences returns the same-origin paths in src and href attributes,
+// without query strings or fragments.
+func htmlReferences(page string) []string {
+	var refs []string
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return refs
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, hasAttr := z.TagName()
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if string(key) != "src" && !(string(key) == "href" && string(tag) == "link") {
+				continue
+			}
+			u, err := url.Parse(string(val))
+			if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+				continue
+			}
+			refs = append(refs, u.Path)
+		}
+	}
+}
+
+// runMigrate applies pending migrations and exits, so a deploy can
+// migrate before the new server starts taking traffic.
+func runMigrate() {
+	cfg := loadConfig()
+	if cfg.DBPath == "" {
+		log.Fatal("migrate: no database configured (-db)")
+	}
+	db, err := storage.Open(cfg.DBPath, cfg.DBDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	applied, err := db.Migrate(context.Background())
+	for _, m := range applied {
+		log.Printf("🗄️  Applied migration %s", m.Name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(applied) == 0 {
+		log.Printf("🗄️  %s is up to date", storage.Redact(cfg.DBPath))
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response worth gzipping. Below this the
+// gzip header and footer eat most of the savings. Responses without a
+// Content-Length are held back until they reach it or end.
+const minCompressSize = 1024
+
+// gzipWriterPool recycles gzip writers between requests; allocating a new
+// one costs ~800KB of compressor state.
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+// compressibleTypes lists the media types that benefit from gzip. Images,
+// audio and fonts like woff2 are already compressed.
+var compressibleTypes = map[string]bool{
+	"text/html":                 true,
+	"text/css":                  true,
+	"text/plain":                true,
+	"text/javascript":           true,
+	"text/xml":                  true,
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return compressibleTypes[strings.TrimSpace(strings.ToLower(mediaType))]
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header
+// allows the given coding with a non-zero quality.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), coding) {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			v, err := strconv.ParseFloat(q, 64)
+			return err == nil && v > 0
+		}
+	}
+	return false
+}
+
+// gzipHandler compresses responses with compressible content types when the
+// client accepts gzip. Range requests pass through untouched since byte
+// offsets refer to the uncompressed representation; compressed responses
+// drop Accept-Ranges for the same reason, so clients only ask for ranges of
+// responses served as is.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsEncoding(r, "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter decides whether to compress when the status line is
+// written, based on the headers the wrapped handler has set by then. If
+// they don't give the length, it buffers up to minCompressSize of the body
+// first and sends a shorter body as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+
+	pending bool   // the status line waits for the body
+	code    int    // the status, while pending
+	buf     []byte // the body so far, while pending
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if informational(code) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	if !w.shouldCompress(code) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.Header().Get("Content-Length") == "" {
+		w.code, w.pending = code, true
+		return
+	}
+	w.startGzip(code)
+}
+
+// startGzip sends the status line for a compressed body.
+func (w *gzipResponseWriter) startGzip(code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", "gzip")
+	// The gzipped bytes differ from the identity representation, so a
+	// strong validator no longer applies. A weak one still lets
+	// If-None-Match revalidation produce 304s.
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.gz = gzipWriterPool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// commit sends the status line held back for the body, compressed or as
+// is, and the body buffered so far.
+func (w *gzipResponseWriter) commit(compress bool) error {
+	w.pending = false
+	if compress {
+		w.startGzip(w.code)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *gzipResponseWriter) shouldCompress(code int) bool {
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || !isCompressible(h.Get("Content-Type")) {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < minCompressSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= minCompressSize {
+			if err := w.commit(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom lets http.ServeContent hand large uncompressed files, such as
+// the soundtrack, straight to the connection (sendfile on Linux) instead of
+// copying them through Write.
+func (w *gzipResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			// Write sniffs the type from the first chunk.
+			return io.Copy(writerOnly{w}, src)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending {
+		return io.Copy(writerOnly{w}, src)
+	}
+	if w.gz != nil {
+		return io.Copy(w.gz, src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// writerOnly hides a writer's other methods so io.Copy uses Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// Close sends a body still held back as is, or flushes the gzip footer
+// and returns the writer to the pool.
+func (w *gzipResponseWriter) Close() {
+	if w.pending {
+		w.commit(false)
+	}
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	gzipWriterPool.Put(w.gz)
+	w.gz = nil
+}
+
+// Flush sends a body still held back compressed, since more is coming.
+func (w *gzipResponseWriter) Flush() {
+	if w.pending {
+		w.commit(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/cron"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+	"github.com/jgbrwn/loderunner2099/internal/webpush"
+)
+
+// config holds the server settings. Every flag defaults to the environment
+// variable named in its usage string, which is handier under systemd.
+type config struct {
+	ConfigFile            string
+	Host                  string
+	Listen                []string
+	SocketMode            string
+	Port                  string
+	DistDir               string
+	Origin                string
+	OriginEndpoint        string
+	OriginCache           string
+	OriginRefresh         time.Duration
+	Sites                 []string
+	Canary                string
+	Maintenance           bool
+	MaintenanceRetryAfter time.Duration
+	CanaryPercent         int
+	LogLevel              string
+	ShutdownTimeout       time.Duration
+	UpgradeTimeout        time.Duration
+	DBPath                string
+	DBDriver              string
+	Backup                string
+	BackupEndpoint        string
+	BackupSchedule        string
+	BackupKeep            int
+	RedisURL              string
+	VerifyWorkers         int
+	DailySecret           string
+
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+
+	SeasonSchedule string
+
+	ScoreSigning bool
+	PlayTokenTTL time.Duration
+
+	AnnounceWebhooks []string
+	AnnounceDedupe   time.Duration
+
+	Analytics         string
+	AnalyticsRotateMB int
+	AnalyticsKeep     int
+
+	ContentFilter   bool
+	Blocklist       string
+	Allowlist       string
+	ModerationURL   string
+	ModerationToken string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxConns          int
+	MaxBodyBytes      int
+
+	CachePolicyFile string
+	Preload         string
+	MemoryCacheMB   int
+	Precompress     bool
+	PrecompressDir  string
+	EarlyHints      bool
+	CacheRules      []string
+	HashedPattern   string
+	UnhashedMaxAge  time.Duration
+	SurrogateKeys   []string
+	CDNPurge        string
+	CDNPurgeToken   string
+	CDNPurgeService string
+	CDNPurgeKeys    []string
+
+	IsolatedPaths []string
+	COEP          string
+
+	CORSOrigins     []string
+	CORSMethods     []string
+	CORSCredentials bool
+	CORSMaxAge      time.Duration
+
+	SecurityHeaders bool
+	CSP             string
+	FrameAncestors  string
+	HSTSMaxAge      time.Duration
+
+	AccessLog string
+	LogAssets bool
+
+	Metrics     bool
+	MetricsAddr string
+	Debug       bool
+
+	GRPCAddr string
+
+	GraphQLMaxDepth      int
+	GraphQLMaxComplexity int
+
+	OTLPEndpoint     string
+	TraceSampleRatio float64
+
+	SentryDSN    string
+	ErrorWebhook string
+
+	TLSCert      string
+	TLSKey       string
+	RedirectAddr string
+	HTTP3        bool
+
+	ACME         bool
+	ACMEDomains  []string
+	ACMECacheDir string
+	ACMEEmail    string
+
+	STUNURLs   []string
+	TURNURLs   []string
+	TURNSecret string
+	TURNTTL    time.Duration
+
+	AdminToken string
+	Admins     []string
+
+	RateLimit      bool
+	RateLimitRules []string
+
+	QuotaLevelsPerDay  int
+	QuotaReplaysPerDay int
+	QuotaSavesPerDay   int
+	QuotaStorageMB     int
+
+	Challenge        string
+	ChallengeRules   []string
+	ChallengeBits    int
+	ChallengeSiteKey string
+	ChallengeSecret  string
+
+	TrustedProxies []string
+	ClientIPHeader string
+
+	Build      bool
+	BuildStale bool
+	Dev        bool
+	DevURL     string
+	LiveReload bool
+
+	Proxies          []string
+	ProxyTimeout     time.Duration
+	ProxyDialTimeout time.Duration
+
+	GatePassword string
+	GateUser     string
+	GateMode     string
+
+	AllowIPs       []string
+	DenyIPs        []string
+	CountryHeader  string
+	AllowCountries []string
+	DenyCountries  []string
+
+	PublicURL           string
+	CanonicalRedirect   bool
+	GitHubClientID      string
+	GitHubClientSecret  string
+	GoogleClientID      string
+	GoogleClientSecret  string
+	DiscordClientID     string
+	DiscordClientSecret string
+}
+
+// loadConfig reads the configuration from the command line, the
+// environment and -config, exiting if any of them is malformed.
+func loadConfig() config {
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// parseConfig defines the server's flags on flags and reads args, the
+// environment and -config with them. With -print-config it prints the
+// result and exits. A reload parses again into a fresh flag set.
+func parseConfig(flags *flag.FlagSet, args []string) (config, error) {
+	var cfg config
+	flags.StringVar(&cfg.ConfigFile, "config", os.Getenv("CONFIG_FILE"), "TOML file of flag-name = value settings, read again on SIGHUP; flags and environment variables override it (env CONFIG_FILE)")
+	printCfg := flags.Bool("print-config", false, "print the effective configuration and exit")
+	flags.StringVar(&cfg.Host, "host", os.Getenv("HOST"), "address to listen on; empty for all interfaces (env HOST)")
+	flags.StringVar(&cfg.Port, "port", envOr("PORT", "8000"), "port to listen on (env PORT)")
+	cfg.Listen = splitList(os.Getenv("LISTEN"))
+	flags.Var(listFlag{&cfg.Listen}, "listen", "address to listen on instead of -host and -port: host:port, unix:/path/to.sock or systemd, optionally followed by tls, plain or internal; repeatable (env LISTEN, comma-separated)")
+	flags.StringVar(&cfg.SocketMode, "socket-mode", envOr("SOCKET_MODE", "0660"), "permissions of the -listen unix: socket (env SOCKET_MODE)")
+	flags.StringVar(&cfg.DistDir, "dist", envOr("DIST_DIR", "./dist"), "directory of the built game, unless it is embedded (env DIST_DIR)")
+	flags.StringVar(&cfg.Origin, "origin", os.Getenv("ORIGIN"), "serve the built game from a bucket instead of -dist: s3://bucket/prefix or gs://bucket/prefix (env ORIGIN)")
+	flags.StringVar(&cfg.OriginEndpoint, "origin-endpoint", os.Getenv("ORIGIN_ENDPOINT"), "http or https URL of an S3-compatible store to use for -origin instead of AWS or Google Cloud Storage (env ORIGIN_ENDPOINT)")
+	flags.StringVar(&cfg.OriginCache, "origin-cache", envOr("ORIGIN_CACHE", defaultOriginCache()), "directory to keep downloaded -origin files in (env ORIGIN_CACHE)")
+	flags.DurationVar(&cfg.OriginRefresh, "origin-refresh", envDuration("ORIGIN_REFRESH", time.Minute), "how often to list -origin for new or changed files; 0 lists it only at startup (env ORIGIN_REFRESH)")
+	flags.StringVar(&cfg.Canary, "canary", os.Getenv("CANARY_DIR"), "directory of a new build to serve to -canary-percent of visitors (env CANARY_DIR)")
+	flags.IntVar(&cfg.CanaryPercent, "canary-percent", envInt("CANARY_PERCENT", 0), "percentage of visitors, 0 to 100, who get the -canary build; each keeps theirs across visits (env CANARY_PERCENT)")
+	flags.BoolVar(&cfg.Maintenance, "maintenance", envBool("MAINTENANCE", false), "start in maintenance mode, answering the game's pages and API with 503 and dist/maintenance.html (env MAINTENANCE)")
+	flags.DurationVar(&cfg.MaintenanceRetryAfter, "maintenance-retry-after", envDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute), "Retry-After sent in maintenance mode (env MAINTENANCE_RETRY_AFTER)")
+	cfg.Sites = splitLines(os.Getenv("SITES"))
+	flags.Var(listFlag{&cfg.Sites}, "site", "\"host[,host...] dist=DIR|origin=URL [cache-policy=FILE] [db=URL] [public-url=URL]\" serving another build to these hostnames; repeatable (env SITES, newline-separated)")
+	flags.StringVar(&cfg.LogLevel, "log-level", envOr("LOG_LEVEL", "info"), "least important log lines to show: info, warn or error (env LOG_LEVEL)")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", 30*time.Second), "how long to drain in-flight requests on SIGINT/SIGTERM (env SHUTDOWN_TIMEOUT)")
+	flags.DurationVar(&cfg.UpgradeTimeout, "upgrade-timeout", envDuration("UPGRADE_TIMEOUT", 15*time.Minute), "how long the old process keeps running matches in progress after handing over to a new binary on SIGUSR2 (env UPGRADE_TIMEOUT)")
+	flags.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", envDuration("READ_HEADER_TIMEOUT", 10*time.Second), "how long a client may take to send its request headers (env READ_HEADER_TIMEOUT)")
+	flags.DurationVar(&cfg.ReadTimeout, "read-timeout", envDuration("READ_TIMEOUT", 30*time.Second), "how long a client may take to send a whole request, body included (env READ_TIMEOUT)")
+	flags.DurationVar(&cfg.WriteTimeout, "write-timeout", envDuration("WRITE_TIMEOUT", 2*time.Minute), "how long a response may take to send; event streams and proxied routes are exempt (env WRITE_TIMEOUT)")
+	flags.DurationVar(&cfg.IdleTimeout, "idle-timeout", envDuration("IDLE_TIMEOUT", 2*time.Minute), "how long to keep an idle keep-alive connection open (env IDLE_TIMEOUT)")
+	flags.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", envInt("MAX_HEADER_BYTES", 64<<10), "largest request line and headers accepted (env MAX_HEADER_BYTES)")
+	flags.IntVar(&cfg.MaxConns, "max-conns", envInt("MAX_CONNS", 0), "open connections allowed per listener, beyond which clients wait; 0 for no limit (env MAX_CONNS)")
+	flags.IntVar(&cfg.MaxBodyBytes, "max-body-bytes", envInt("MAX_BODY_BYTES", 1<<20), "largest request body accepted by any endpoint (env MAX_BODY_BYTES)")
+	flags.StringVar(&cfg.CachePolicyFile, "cache-policy", os.Getenv("CACHE_POLICY"), "file of \"pattern -> directive\" Cache-Control rules (env CACHE_POLICY)")
+	flags.IntVar(&cfg.MemoryCacheMB, "memory-cache-mb", envInt("MEMORY_CACHE_MB", 0), "keep up to this many MB of recently served dist/ files in memory; 0 disables (env MEMORY_CACHE_MB)")
+	flags.BoolVar(&cfg.Precompress, "precompress", envBool("PRECOMPRESS", true), "compress text assets with brotli and gzip at startup; turn off for faster restarts in development (env PRECOMPRESS)")
+	flags.StringVar(&cfg.PrecompressDir, "precompress-dir", os.Getenv("PRECOMPRESS_DIR"), "directory to keep precompressed assets in across restarts; empty keeps them in memory (env PRECOMPRESS_DIR)")
+	flags.StringVar(&cfg.Preload, "preload", envOr("PRELOAD", "auto"), "Link: rel=preload headers on HTML pages: auto (from index.html), off, or a file listing \"path [type]\" per line (env PRELOAD)")
+	flags.BoolVar(&cfg.EarlyHints, "early-hints", envBool("EARLY_HINTS", false), "also send the preload links ahead of HTML pages as 103 Early Hints (env EARLY_HINTS)")
+	cfg.CacheRules = splitLines(os.Getenv("CACHE_RULES"))
+	flags.Var(listFlag{&cfg.CacheRules}, "cache-rule", "extra \"pattern -> directive\" cache rule, checked before the policy file; repeatable (env CACHE_RULES, newline-separated)")
+	flags.StringVar(&cfg.HashedPattern, "hashed-pattern", envOr("HASHED_PATTERN", defaultHashedPattern), "regexp matching content-hashed file names, the only ones cached as immutable (env HASHED_PATTERN)")
+	flags.DurationVar(&cfg.UnhashedMaxAge, "unhashed-max-age", envDuration("UNHASHED_MAX_AGE", 5*time.Minute), "max-age for unhashed files that match an immutable rule (env UNHASHED_MAX_AGE)")
+	cfg.SurrogateKeys = splitLines(os.Getenv("SURROGATE_KEYS"))
+	flags.Var(listFlag{&cfg.SurrogateKeys}, "surrogate-key", "\"pattern -> key [key...]\" rule tagging matching files for CDN purges, sent as Surrogate-Key and Cache-Tag; every matching rule applies; repeatable (env SURROGATE_KEYS, newline-separated)")
+	flags.StringVar(&cfg.CDNPurge, "cdn-purge", os.Getenv("CDN_PURGE"), "CDN to purge when a new build is served: fastly or cloudflare; empty disables (env CDN_PURGE)")
+	flags.StringVar(&cfg.CDNPurgeToken, "cdn-purge-token", os.Getenv("CDN_PURGE_TOKEN"), "API token for -cdn-purge (env CDN_PURGE_TOKEN)")
+	flags.StringVar(&cfg.CDNPurgeService, "cdn-purge-service", os.Getenv("CDN_PURGE_SERVICE"), "Fastly service ID or Cloudflare zone ID to purge (env CDN_PURGE_SERVICE)")
+	cfg.CDNPurgeKeys = splitList(os.Getenv("CDN_PURGE_KEYS"))
+	flags.Var(listFlag{&cfg.CDNPurgeKeys}, "cdn-purge-key", "surrogate key to purge on a new build instead of everything; repeatable (env CDN_PURGE_KEYS, comma-separated)")
+	isolated := flags.String("cross-origin-isolation", os.Getenv("CROSS_ORIGIN_ISOLATION"), "comma-separated path globs that get COOP/COEP headers for SharedArrayBuffer, e.g. \"*\" (env CROSS_ORIGIN_ISOLATION)")
+	flags.StringVar(&cfg.COEP, "coep", envOr("COEP", "require-corp"), "Cross-Origin-Embedder-Policy value: require-corp or credentialless (env COEP)")
+	corsOrigins := flags.String("cors-origins", os.Getenv("CORS_ORIGINS"), "comma-separated origins whose pages may call /api and /ws, e.g. https://*.itch.zone, or \"*\" (env CORS_ORIGINS)")
+	corsMethods := flags.String("cors-methods", envOr("CORS_METHODS", "GET,POST,PUT,PATCH,DELETE"), "comma-separated methods -cors-origins may use (env CORS_METHODS)")
+	flags.BoolVar(&cfg.CORSCredentials, "cors-credentials", envBool("CORS_CREDENTIALS", false), "let -cors-origins send cookies and read responses to requests with them (env CORS_CREDENTIALS)")
+	flags.DurationVar(&cfg.CORSMaxAge, "cors-max-age", envDuration("CORS_MAX_AGE", 10*time.Minute), "how long browsers may cache a preflight response (env CORS_MAX_AGE)")
+	flags.BoolVar(&cfg.SecurityHeaders, "security-headers", envBool("SECURITY_HEADERS", true), "send CSP, nosniff, Referrer-Policy and related headers (env SECURITY_HEADERS)")
+	flags.StringVar(&cfg.CSP, "csp", os.Getenv("CSP"), "Content-Security-Policy override, or \"off\" (env CSP)")
+	flags.StringVar(&cfg.FrameAncestors, "frame-ancestors", envOr("FRAME_ANCESTORS", "'self'"), "who may embed the game in a frame, as a CSP source list (env FRAME_ANCESTORS)")
+	flags.DurationVar(&cfg.HSTSMaxAge, "hsts-max-age", envDuration("HSTS_MAX_AGE", 365*24*time.Hour), "Strict-Transport-Security max-age when TLS is on; 0 disables (env HSTS_MAX_AGE)")
+	flags.StringVar(&cfg.AccessLog, "access-log", envOr("ACCESS_LOG", "combined"), "access log format: json, combined or off (env ACCESS_LOG)")
+	flags.BoolVar(&cfg.LogAssets, "log-assets", envBool("LOG_ASSETS", true), "include successful static asset hits in the access log (env LOG_ASSETS)")
+	flags.BoolVar(&cfg.Metrics, "metrics", envBool("METRICS", false), "expose Prometheus metrics at /metrics on the main listener (env METRICS)")
+	flags.StringVar(&cfg.MetricsAddr, "metrics-addr", os.Getenv("METRICS_ADDR"), "serve /metrics on a separate internal address instead, e.g. 127.0.0.1:9100 (env METRICS_ADDR)")
+	flags.StringVar(&cfg.GRPCAddr, "grpc-addr", os.Getenv("GRPC_ADDR"), "also serve the leaderboard, levels and saves over gRPC on this address, e.g. :9090; TLS if the main listener has it (env GRPC_ADDR)")
+	flags.IntVar(&cfg.GraphQLMaxDepth, "graphql-max-depth", envInt("GRAPHQL_MAX_DEPTH", 10), "deepest field nesting a /api/graphql query may use (env GRAPHQL_MAX_DEPTH)")
+	flags.IntVar(&cfg.GraphQLMaxComplexity, "graphql-max-complexity", envInt("GRAPHQL_MAX_COMPLEXITY", 5000), "most a /api/graphql query may cost, counting each field once per item its list limits allow (env GRAPHQL_MAX_COMPLEXITY)")
+	flags.BoolVar(&cfg.Debug, "debug", envBool("DEBUG", false), "serve pprof profiles and expvar under /debug/ on the internal listener or -metrics-addr (env DEBUG)")
+	flags.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export OpenTelemetry traces to this OTLP/HTTP collector, e.g. http://localhost:4318; empty disables tracing (env OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flags.Float64Var(&cfg.TraceSampleRatio, "trace-sample-ratio", envFloat("TRACE_SAMPLE_RATIO", 1), "fraction of requests to trace when the caller hasn't decided (env TRACE_SAMPLE_RATIO)")
+	flags.StringVar(&cfg.SentryDSN, "sentry-dsn", os.Getenv("SENTRY_DSN"), "report handler panics to this Sentry project (env SENTRY_DSN)")
+	flags.StringVar(&cfg.ErrorWebhook, "error-webhook", os.Getenv("ERROR_WEBHOOK"), "also POST handler panics as JSON to this URL (env ERROR_WEBHOOK)")
+	flags.StringVar(&cfg.DBPath, "db", envOr("DB_PATH", envOr("DATABASE_URL", "./loderunner2099.db")), "SQLite database file or postgres:// URL for the game API; empty disables the API (env DB_PATH or DATABASE_URL)")
+	flags.StringVar(&cfg.DBDriver, "db-driver", envOr("DB_DRIVER", storage.DefaultDriver), "SQLite driver: modernc, or mattn when built with -tags mattn; PostgreSQL always uses pgx (env DB_DRIVER)")
+	flags.StringVar(&cfg.Backup, "backup", os.Getenv("BACKUP"), "directory, s3://bucket/prefix or gs://bucket/prefix to write scheduled SQLite snapshots to; empty disables backups (env BACKUP)")
+	flags.StringVar(&cfg.BackupEndpoint, "backup-endpoint", os.Getenv("BACKUP_ENDPOINT"), "http or https URL of an S3-compatible store to use for -backup instead of AWS or Google Cloud Storage (env BACKUP_ENDPOINT)")
+	flags.StringVar(&cfg.BackupSchedule, "backup-schedule", envOr("BACKUP_SCHEDULE", "@daily"), "cron expression or macro such as @hourly when to snapshot the database to -backup, in UTC (env BACKUP_SCHEDULE)")
+	flags.IntVar(&cfg.BackupKeep, "backup-keep", envInt("BACKUP_KEEP", 7), "how many -backup snapshots to keep, deleting older ones; 0 keeps them all (env BACKUP_KEEP)")
+	flags.StringVar(&cfg.RedisURL, "redis", os.Getenv("REDIS_URL"), "Redis URL, redis://, rediss:// or unix://, through which instances share lobby rooms, the matchmaking queue, rate limits and session lookups; empty keeps them in memory (env REDIS_URL)")
+	flags.IntVar(&cfg.VerifyWorkers, "verify-workers", envInt("VERIFY_WORKERS", 2), "concurrent replay verification workers (env VERIFY_WORKERS)")
+	flags.StringVar(&cfg.DailySecret, "daily-secret", os.Getenv("DAILY_SECRET"), "key daily challenge seeds are derived from; generated and stored in the database when empty (env DAILY_SECRET)")
+	flags.StringVar(&cfg.VAPIDPrivateKey, "vapid-private-key", os.Getenv("VAPID_PRIVATE_KEY"), "base64url P-256 key Web Push messages are signed with; generated and stored in the database when empty (env VAPID_PRIVATE_KEY)")
+	flags.StringVar(&cfg.VAPIDSubject, "vapid-subject", os.Getenv("VAPID_SUBJECT"), "mailto: or https: contact sent to push services; defaults to -public-url (env VAPID_SUBJECT)")
+	flags.BoolVar(&cfg.ScoreSigning, "score-signing", envBool("SCORE_SIGNING", false), "require score submissions to carry a signed play token from POST /api/scores/tokens (env SCORE_SIGNING)")
+	flags.DurationVar(&cfg.PlayTokenTTL, "play-token-ttl", envDuration("PLAY_TOKEN_TTL", time.Hour), "how long a play token may be used for, so the longest run accepted with -score-signing (env PLAY_TOKEN_TTL)")
+	flags.StringVar(&cfg.SeasonSchedule, "season-schedule", os.Getenv("SEASON_SCHEDULE"), "cron expression or macro such as @monthly when leaderboard seasons roll over, in UTC; empty leaves it to moderators (env SEASON_SCHEDULE)")
+	cfg.AnnounceWebhooks = splitList(os.Getenv("ANNOUNCE_WEBHOOKS"))
+	flags.Var(listFlag{&cfg.AnnounceWebhooks}, "announce-webhook", "Discord or Slack incoming webhook URL that new world records and featured levels are posted to; repeatable (env ANNOUNCE_WEBHOOKS, comma-separated)")
+	flags.DurationVar(&cfg.AnnounceDedupe, "announce-dedupe", envDuration("ANNOUNCE_DEDUPE", 10*time.Minute), "don't announce the same player's record or the same level twice within this window (env ANNOUNCE_DEDUPE)")
+	flags.StringVar(&cfg.Analytics, "analytics", envOr("ANALYTICS", "db"), "where POST /api/events analytics go: db, file:/path/to/events.ndjson or off (env ANALYTICS)")
+	flags.IntVar(&cfg.AnalyticsRotateMB, "analytics-rotate-mb", envInt("ANALYTICS_ROTATE_MB", 100), "rotate the -analytics file once it reaches this many MB (env ANALYTICS_ROTATE_MB)")
+	flags.IntVar(&cfg.AnalyticsKeep, "analytics-keep", envInt("ANALYTICS_KEEP", 10), "rotated -analytics files to keep (env ANALYTICS_KEEP)")
+	flags.BoolVar(&cfg.ContentFilter, "content-filter", envBool("CONTENT_FILTER", true), "reject names, chat, and level and clan text with blocked words in them (env CONTENT_FILTER)")
+	flags.StringVar(&cfg.Blocklist, "blocklist", os.Getenv("BLOCKLIST"), "file of terms for -content-filter to block as well as the built-in ones, one per line (env BLOCKLIST)")
+	flags.StringVar(&cfg.Allowlist, "allowlist", os.Getenv("ALLOWLIST"), "file of words -content-filter never blocks, one per line (env ALLOWLIST)")
+	flags.StringVar(&cfg.ModerationURL, "moderation-url", os.Getenv("MODERATION_URL"), "moderation service -content-filter also asks about text the lists pass; see docs/API.md (env MODERATION_URL)")
+	flags.StringVar(&cfg.ModerationToken, "moderation-token", os.Getenv("MODERATION_TOKEN"), "bearer token for -moderation-url (env MODERATION_TOKEN)")
+	flags.StringVar(&cfg.TLSCert, "tls-cert", os.Getenv("TLS_CERT"), "TLS certificate file; enables HTTPS (env TLS_CERT)")
+	flags.StringVar(&cfg.TLSKey, "tls-key", os.Getenv("TLS_KEY"), "TLS private key file (env TLS_KEY)")
+	flags.StringVar(&cfg.RedirectAddr, "http-redirect", os.Getenv("HTTP_REDIRECT_ADDR"), "address of a plain HTTP listener that redirects to HTTPS, e.g. :80 (env HTTP_REDIRECT_ADDR)")
+	flags.BoolVar(&cfg.HTTP3, "http3", envBool("HTTP3", false), "also serve HTTP/3 over QUIC on the same UDP port and advertise it with Alt-Svc; needs TLS (env HTTP3)")
+	flags.BoolVar(&cfg.ACME, "acme", envBool("ACME", false), "obtain certificates automatically from Let's Encrypt (env ACME)")
+	domains := flags.String("domain", os.Getenv("ACME_DOMAIN"), "comma-separated domains to request certificates for (env ACME_DOMAIN)")
+	flags.StringVar(&cfg.ACMECacheDir, "acme-cache", envOr("ACME_CACHE_DIR", "./acme-cache"), "directory for cached ACME certificates (env ACME_CACHE_DIR)")
+	flags.StringVar(&cfg.ACMEEmail, "acme-email", os.Getenv("ACME_EMAIL"), "contact email for the ACME account (env ACME_EMAIL)")
+	stun := flags.String("stun", os.Getenv("STUN_URLS"), "comma-separated STUN server URLs offered to WebRTC clients, e.g. stun:stun.example.com:3478 (env STUN_URLS)")
+	turn := flags.String("turn", os.Getenv("TURN_URLS"), "comma-separated TURN server URLs, e.g. turn:turn.example.com:3478?transport=udp (env TURN_URLS)")
+	flags.StringVar(&cfg.TURNSecret, "turn-secret", os.Getenv("TURN_SECRET"), "shared secret for time-limited TURN credentials, coturn's static-auth-secret (env TURN_SECRET)")
+	flags.DurationVar(&cfg.TURNTTL, "turn-ttl", envDuration("TURN_TTL", time.Hour), "lifetime of issued TURN credentials (env TURN_TTL)")
+	flags.StringVar(&cfg.AdminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "secret for the moderation API, sent as X-Admin-Token (env ADMIN_TOKEN)")
+	admins := flags.String("admins", os.Getenv("ADMINS"), "comma-separated usernames of accounts that may use the moderation API (env ADMINS)")
+	flags.BoolVar(&cfg.RateLimit, "rate-limit", envBool("RATE_LIMIT", true), "limit requests per client IP with the default and -rate-limit-rule rules (env RATE_LIMIT)")
+	cfg.RateLimitRules = splitLines(os.Getenv("RATE_LIMIT_RULES"))
+	flags.Var(listFlag{&cfg.RateLimitRules}, "rate-limit-rule", "extra \"[METHOD] pattern -> N/unit [burst B]\" rate limit rule, checked before the defaults; repeatable (env RATE_LIMIT_RULES, newline-separated)")
+	flags.IntVar(&cfg.QuotaLevelsPerDay, "quota-levels-per-day", envInt("QUOTA_LEVELS_PER_DAY", 20), "levels each player or address may upload a UTC day; 0 for no limit (env QUOTA_LEVELS_PER_DAY)")
+	flags.IntVar(&cfg.QuotaReplaysPerDay, "quota-replays-per-day", envInt("QUOTA_REPLAYS_PER_DAY", 500), "replays each player or address may upload a UTC day; 0 for no limit (env QUOTA_REPLAYS_PER_DAY)")
+	flags.IntVar(&cfg.QuotaSavesPerDay, "quota-saves-per-day", envInt("QUOTA_SAVES_PER_DAY", 10), "new cloud saves each player or address may create a UTC day; 0 for no limit (env QUOTA_SAVES_PER_DAY)")
+	flags.IntVar(&cfg.QuotaStorageMB, "quota-storage-mb", envInt("QUOTA_STORAGE_MB", 50), "megabytes of levels, replays and saves each player or address may keep stored; 0 for no limit (env QUOTA_STORAGE_MB)")
+	flags.StringVar(&cfg.Challenge, "challenge", os.Getenv("CHALLENGE"), "make clients over the -challenge-rule soft limits solve a challenge: pow (proof of work), turnstile or hcaptcha; empty for none (env CHALLENGE)")
+	cfg.ChallengeRules = splitLines(os.Getenv("CHALLENGE_RULES"))
+	flags.Var(listFlag{&cfg.ChallengeRules}, "challenge-rule", "extra \"[METHOD] pattern -> N/unit [burst B]\" soft limit past which -challenge applies, checked before the defaults; repeatable (env CHALLENGE_RULES, newline-separated)")
+	flags.IntVar(&cfg.ChallengeBits, "challenge-bits", envInt("CHALLENGE_BITS", 20), "leading zero bits a -challenge pow solution's hash needs; each one doubles the work (env CHALLENGE_BITS)")
+	flags.StringVar(&cfg.ChallengeSiteKey, "challenge-site-key", os.Getenv("CHALLENGE_SITE_KEY"), "Turnstile or hCaptcha site key the game shows the widget with (env CHALLENGE_SITE_KEY)")
+	flags.StringVar(&cfg.ChallengeSecret, "challenge-secret", os.Getenv("CHALLENGE_SECRET"), "Turnstile or hCaptcha secret key responses are verified with (env CHALLENGE_SECRET)")
+	proxies := flags.String("trusted-proxies", os.Getenv("TRUSTED_PROXIES"), "comma-separated CIDRs or addresses of reverse proxies whose -client-ip-header is believed (env TRUSTED_PROXIES)")
+	flags.StringVar(&cfg.ClientIPHeader, "client-ip-header", envOr("CLIENT_IP_HEADER", "X-Forwarded-For"), "header trusted proxies give the client's address in: X-Forwarded-For, Forwarded, or one holding a single address such as CF-Connecting-IP (env CLIENT_IP_HEADER)")
+	flags.BoolVar(&cfg.Build, "build", envBool("BUILD", false), "run npm run build at startup when dist/ is missing (env BUILD)")
+	flags.BoolVar(&cfg.BuildStale, "build-stale", envBool("BUILD_STALE", false), "with -build, also rebuild when package.json, src/ or public/ are newer than dist/ (env BUILD_STALE)")
+	flags.BoolVar(&cfg.Dev, "dev", envBool("DEV", false), "development mode: proxy everything but the Go routes to the Vite dev server instead of serving dist/ (env DEV)")
+	flags.StringVar(&cfg.DevURL, "dev-url", envOr("DEV_URL", "http://localhost:8000"), "Vite dev server URL for -dev (env DEV_URL)")
+	flags.BoolVar(&cfg.LiveReload, "live-reload", envBool("LIVE_RELOAD", false), "watch dist/ and reload open pages when a new build lands (env LIVE_RELOAD)")
+	cfg.Proxies = splitList(os.Getenv("PROXY"))
+	flags.Var(listFlag{&cfg.Proxies}, "proxy", "forward a path prefix to an upstream server, e.g. /api=http://backend:9000; repeatable (env PROXY, comma-separated)")
+	flags.DurationVar(&cfg.ProxyTimeout, "proxy-timeout", envDuration("PROXY_TIMEOUT", 30*time.Second), "how long to wait for an upstream's response headers (env PROXY_TIMEOUT)")
+	flags.DurationVar(&cfg.ProxyDialTimeout, "proxy-dial-timeout", envDuration("PROXY_DIAL_TIMEOUT", 5*time.Second), "how long to wait for a connection to an upstream (env PROXY_DIAL_TIMEOUT)")
+	flags.StringVar(&cfg.GatePassword, "gate-password", os.Getenv("GATE_PASSWORD"), "shared password required for every route except /healthz, for hiding staging deploys (env GATE_PASSWORD)")
+	flags.StringVar(&cfg.GateUser, "gate-user", os.Getenv("GATE_USER"), "user name that goes with -gate-password; any name when empty (env GATE_USER)")
+	flags.StringVar(&cfg.GateMode, "gate-mode", envOr("GATE_MODE", gateForm), "how -gate-password is asked for: form (passcode page and cookie) or basic (HTTP Basic Auth) (env GATE_MODE)")
+	allowIPs := flags.String("allow-ips", os.Getenv("ALLOW_IPS"), "comma-separated CIDRs or addresses; when set, everyone else is refused (env ALLOW_IPS)")
+	denyIPs := flags.String("deny-ips", os.Getenv("DENY_IPS"), "comma-separated CIDRs or addresses to refuse (env DENY_IPS)")
+	flags.StringVar(&cfg.CountryHeader, "country-header", envOr("COUNTRY_HEADER", "CF-IPCountry"), "header a trusted proxy puts the client's country code in (env COUNTRY_HEADER)")
+	allowCountries := flags.String("allow-countries", os.Getenv("ALLOW_COUNTRIES"), "comma-separated country codes; when set, everyone else is refused (env ALLOW_COUNTRIES)")
+	denyCountries := flags.String("deny-countries", os.Getenv("DENY_COUNTRIES"), "comma-separated country codes to refuse (env DENY_COUNTRIES)")
+	flags.StringVar(&cfg.PublicURL, "public-url", os.Getenv("PUBLIC_URL"), "external base URL of the site, e.g. https://game.example.com; defaults to the request's host (env PUBLIC_URL)")
+	flags.BoolVar(&cfg.CanonicalRedirect, "canonical-redirect", envBool("CANONICAL_REDIRECT", false), "redirect requests for other hostnames or plain HTTP to -public-url's host and scheme (env CANONICAL_REDIRECT)")
+	flags.StringVar(&cfg.GitHubClientID, "github-client-id", os.Getenv("GITHUB_CLIENT_ID"), "GitHub OAuth app client ID; enables GitHub sign-in (env GITHUB_CLIENT_ID)")
+	flags.StringVar(&cfg.GitHubClientSecret, "github-client-secret", os.Getenv("GITHUB_CLIENT_SECRET"), "GitHub OAuth app client secret (env GITHUB_CLIENT_SECRET)")
+	flags.StringVar(&cfg.GoogleClientID, "google-client-id", os.Getenv("GOOGLE_CLIENT_ID"), "Google OAuth client ID; enables Google sign-in (env GOOGLE_CLIENT_ID)")
+	flags.StringVar(&cfg.GoogleClientSecret, "google-client-secret", os.Getenv("GOOGLE_CLIENT_SECRET"), "Google OAuth client secret (env GOOGLE_CLIENT_SECRET)")
+	flags.StringVar(&cfg.DiscordClientID, "discord-client-id", os.Getenv("DISCORD_CLIENT_ID"), "Discord application client ID; enables Discord sign-in (env DISCORD_CLIENT_ID)")
+	flags.StringVar(&cfg.DiscordClientSecret, "discord-client-secret", os.Getenv("DISCORD_CLIENT_SECRET"), "Discord application client secret (env DISCORD_CLIENT_SECRET)")
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+	fromFile, err := applyConfigFile(flags, cfg.ConfigFile)
+	if err != nil {
+		return config{}, err
+	}
+	if *printCfg {
+		printConfig(os.Stdout, flags, fromFile)
+		os.Exit(0)
+	}
+	if cfg.Dev && !portSet(flags, fromFile) {
+		// npm run dev has Vite on 8000, so keep out of its way.
+		cfg.Port = devPort
+	}
+
+	cfg.ACMEDomains = splitList(*domains)
+	cfg.IsolatedPaths = splitList(*isolated)
+	cfg.CORSOrigins = splitList(*corsOrigins)
+	cfg.CORSMethods = splitList(*corsMethods)
+	cfg.STUNURLs = splitList(*stun)
+	cfg.TURNURLs = splitList(*turn)
+	cfg.TrustedProxies = splitList(*proxies)
+	cfg.AllowIPs = splitList(*allowIPs)
+	cfg.DenyIPs = splitList(*denyIPs)
+	cfg.AllowCountries = splitList(*allowCountries)
+	cfg.DenyCountries = splitList(*denyCountries)
+	for _, name := range splitList(*admins) {
+		cfg.Admins = append(cfg.Admins, strings.ToLower(name))
+	}
+	if cfg.ACME && cfg.RedirectAddr == "" {
+		// HTTP-01 challenges always arrive on port 80.
+		cfg.RedirectAddr = ":80"
+	}
+	return cfg, nil
+}
+
+// devPort is the port -dev listens on without -port, beside Vite's 8000.
+const devPort = "8080"
+
+// portSet reports whether -port was given on the command line, in the
+// environment or in the -config file.
+func portSet(flags *flag.FlagSet, fromFile map[string]bool) bool {
+	set := fromFile["port"] || os.Getenv("PORT") != ""
+	flags.Visit(func(f *flag.Flag) { set = set || f.Name == "port" })
+	return set
+}
+
+// sameHostPort reports whether u points at the listen address addr. A
+// listener on every interface matches any local host name.
+func sameHostPort(addr string, u *url.URL) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false // unix:, systemd
+	}
+	urlPort := u.Port()
+	if urlPort == "" {
+		urlPort = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if port != urlPort {
+		return false
+	}
+	urlHost := u.Hostname()
+	if host == urlHost {
+		return true
+	}
+	local := urlHost == "localhost" || urlHost == "127.0.0.1" || urlHost == "::1"
+	return local && (host == "" || host == "0.0.0.0" || host == "::" || host == "localhost" || host == "127.0.0.1" || host == "::1")
+}
+
+func (c config) validate() error {
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return errors.New("-log-level must be info, warn or error")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("-tls-cert and -tls-key must be set together")
+	}
+	if c.ACME && c.TLSCert != "" {
+		return errors.New("-acme and -tls-cert are mutually exclusive")
+	}
+	if c.ACME && len(c.ACMEDomains) == 0 {
+		return errors.New("-acme requires at least one -domain")
+	}
+	if c.COEP != "require-corp" && c.COEP != "credentialless" {
+		return errors.New("-coep must be require-corp or credentialless")
+	}
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"-read-header-timeout", c.ReadHeaderTimeout},
+		{"-read-timeout", c.ReadTimeout},
+		{"-write-timeout", c.WriteTimeout},
+		{"-idle-timeout", c.IdleTimeout},
+		{"-upgrade-timeout", c.UpgradeTimeout},
+		{"-maintenance-retry-after", c.MaintenanceRetryAfter},
+	} {
+		if d.v <= 0 {
+			return fmt.Errorf("%s must be positive", d.name)
+		}
+	}
+	if c.MaxHeaderBytes < 1<<10 {
+		return errors.New("-max-header-bytes must be at least 1024")
+	}
+	if c.MaxConns < 0 {
+		return errors.New("-max-conns must not be negative")
+	}
+	if c.MaxBodyBytes < 1 {
+		return errors.New("-max-body-bytes must be at least 1")
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-otlp-endpoint %q must be an http:// or https:// URL", c.OTLPEndpoint)
+		}
+	}
+	if c.RedisURL != "" {
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss" && u.Scheme != "unix") {
+			return errors.New("-redis must be a redis://, rediss:// or unix:// URL")
+		}
+	}
+	if c.ErrorWebhook != "" {
+		if u, err := url.Parse(c.ErrorWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-error-webhook %q must be an http:// or https:// URL", c.ErrorWebhook)
+		}
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return errors.New("-trace-sample-ratio must be between 0 and 1")
+	}
+	if c.MemoryCacheMB < 0 {
+		return errors.New("-memory-cache-mb must not be negative")
+	}
+	if c.VerifyWorkers < 1 {
+		return errors.New("-verify-workers must be at least 1")
+	}
+	if c.GraphQLMaxDepth < 1 {
+		return errors.New("-graphql-max-depth must be at least 1")
+	}
+	if c.GraphQLMaxComplexity < 1 {
+		return errors.New("-graphql-max-complexity must be at least 1")
+	}
+	for _, q := range []struct {
+		name string
+		v    int
+	}{
+		{"-quota-levels-per-day", c.QuotaLevelsPerDay},
+		{"-quota-replays-per-day", c.QuotaReplaysPerDay},
+		{"-quota-saves-per-day", c.QuotaSavesPerDay},
+		{"-quota-storage-mb", c.QuotaStorageMB},
+	} {
+		if q.v < 0 {
+			return fmt.Errorf("%s must not be negative", q.name)
+		}
+	}
+	if c.VAPIDPrivateKey != "" {
+		if _, err := webpush.ParseVAPIDKey(c.VAPIDPrivateKey); err != nil {
+			return fmt.Errorf("-vapid-private-key: %w", err)
+		}
+	}
+	if c.VAPIDSubject != "" && !strings.HasPrefix(c.VAPIDSubject, "mailto:") && !strings.HasPrefix(c.VAPIDSubject, "https://") {
+		return errors.New("-vapid-subject must be a mailto: or https:// URL")
+	}
+	if c.PlayTokenTTL < time.Minute || c.PlayTokenTTL > maxRunTime {
+		return errors.New("-play-token-ttl must be between 1m and 24h")
+	}
+	if c.SeasonSchedule != "" {
+		if _, err := cron.Parse(c.SeasonSchedule); err != nil {
+			return fmt.Errorf("-season-schedule: %w", err)
+		}
+	}
+	for _, hook := range c.AnnounceWebhooks {
+		if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-announce-webhook %q must be an http:// or https:// URL", hook)
+		}
+	}
+	if c.AnnounceDedupe < 0 {
+		return errors.New("-announce-dedupe must not be negative")
+	}
+	if c.Analytics != "db" && c.Analytics != "off" && (!strings.HasPrefix(c.Analytics, "file:") || c.Analytics == "file:") {
+		return errors.New("-analytics must be db, off or file: followed by a path")
+	}
+	if c.AnalyticsRotateMB < 1 {
+		return errors.New("-analytics-rotate-mb must be at least 1")
+	}
+	if c.AnalyticsKeep < 0 {
+		return errors.New("-analytics-keep must not be negative")
+	}
+	if c.ModerationURL != "" {
+		if u, err := url.Parse(c.ModerationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-moderation-url %q must be an http:// or https:// URL", c.ModerationURL)
+		}
+	}
+	if len(c.TURNURLs) > 0 && c.TURNSecret == "" {
+		return errors.New("-turn requires -turn-secret")
+	}
+	for _, p := range []struct{ name, id, secret string }{
+		{"github", c.GitHubClientID, c.GitHubClientSecret},
+		{"google", c.GoogleClientID, c.GoogleClientSecret},
+		{"discord", c.DiscordClientID, c.DiscordClientSecret},
+	} {
+		if (p.id == "") != (p.secret == "") {
+			return fmt.Errorf("-%s-client-id and -%s-client-secret must be set together", p.name, p.name)
+		}
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 16 {
+		return errors.New("-admin-token must be at least 16 characters")
+	}
+	if c.Dev {
+		u, err := url.Parse(c.DevURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("-dev-url must be an http or https URL")
+		}
+		if specs, err := c.listeners(); err == nil {
+			for _, spec := range specs {
+				if sameHostPort(spec.addr, u) {
+					return fmt.Errorf("-dev-url %s is the server's own address %s; point it at Vite or pick another -port", c.DevURL, spec.addr)
+				}
+			}
+		}
+	}
+	if c.Dev && c.LiveReload {
+		return errors.New("-live-reload doesn't apply to -dev, where Vite reloads pages itself")
+	}
+	if c.Origin != "" {
+		if u, err := url.Parse(c.Origin); err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+			return errors.New("-origin must be an s3://bucket/prefix or gs://bucket/prefix URL")
+		}
+		if c.Dev {
+			return errors.New("-origin doesn't apply to -dev, which proxies to Vite")
+		}
+		if c.Build {
+			return errors.New("-build builds dist/ on disk, which -origin doesn't use")
+		}
+	}
+	if c.OriginEndpoint != "" {
+		if u, err := url.Parse(c.OriginEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return errors.New("-origin-endpoint must be an http or https URL without a path")
+		}
+	}
+	if c.OriginRefresh < 0 {
+		return errors.New("-origin-refresh must not be negative")
+	}
+	if c.CanaryPercent < 0 || c.CanaryPercent > 100 {
+		return errors.New("-canary-percent must be 0 to 100")
+	}
+	if c.Canary != "" && c.Dev {
+		return errors.New("-canary doesn't apply to -dev, which proxies to Vite")
+	}
+	sites, err := parseSites(c.Sites)
+	if err != nil {
+		return err
+	}
+	if len(sites) > 0 && c.Dev {
+		return errors.New("-site doesn't apply to -dev, which proxies every hostname to Vite")
+	}
+	for _, s := range sites {
+		if err := s.config(c).validate(); err != nil {
+			return fmt.Errorf("-site %s: %w", s.name(), err)
+		}
+	}
+	switch c.CDNPurge {
+	case "":
+	case cdnFastly, cdnCloudflare:
+		if c.CDNPurgeToken == "" || c.CDNPurgeService == "" {
+			return errors.New("-cdn-purge requires -cdn-purge-token and -cdn-purge-service")
+		}
+		if c.Dev {
+			return errors.New("-cdn-purge doesn't apply to -dev")
+		}
+	default:
+		return errors.New("-cdn-purge must be fastly or cloudflare")
+	}
+	if _, err := newCORSPolicy(c); err != nil {
+		return err
+	}
+	if c.CORSMaxAge < 0 {
+		return errors.New("-cors-max-age must not be negative")
+	}
+	switch c.Challenge {
+	case "":
+	case challengePoW:
+		if c.ChallengeBits < 8 || c.ChallengeBits > 32 {
+			return errors.New("-challenge-bits must be 8 to 32")
+		}
+	case challengeTurnstile, challengeHCaptcha:
+		if c.ChallengeSiteKey == "" || c.ChallengeSecret == "" {
+			return errors.New("-challenge " + c.Challenge + " requires -challenge-site-key and -challenge-secret")
+		}
+	default:
+		return errors.New("-challenge must be pow, turnstile or hcaptcha")
+	}
+	if c.GateMode != gateForm && c.GateMode != gateBasic {
+		return errors.New("-gate-mode must be form or basic")
+	}
+	if (len(c.AllowCountries) > 0 || len(c.DenyCountries) > 0) && len(c.TrustedProxies) == 0 {
+		return errors.New("-allow-countries and -deny-countries require -trusted-proxies")
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("-public-url must be an absolute http or https URL")
+		}
+	} else if c.CanonicalRedirect {
+		return errors.New("-canonical-redirect requires -public-url")
+	}
+	if c.RedirectAddr != "" && !c.tlsEnabled() {
+		return errors.New("-http-redirect requires TLS")
+	}
+	if c.HTTP3 && !c.tlsEnabled() {
+		return errors.New("-http3 requires TLS")
+	}
+	specs, err := c.listeners()
+	if err != nil {
+		return err
+	}
+	if c.HTTP3 && !slices.ContainsFunc(specs, func(s listenSpec) bool {
+		return s.tls && s.addr != "systemd" && !strings.HasPrefix(s.addr, "unix:")
+	}) {
+		return errors.New("-http3 needs a TLS listener on a TCP port")
+	}
+	if c.Debug && c.MetricsAddr == "" && !slices.ContainsFunc(specs, func(s listenSpec) bool { return s.internal }) {
+		return errors.New("-debug needs -metrics-addr or an internal -listen, so profiles aren't public")
+	}
+	if c.GRPCAddr != "" && c.DBPath == "" {
+		return errors.New("-grpc-addr needs -db")
+	}
+	if c.Backup != "" {
+		if c.DBPath == "" || storage.IsPostgresURL(c.DBPath) {
+			return errors.New("-backup needs a SQLite -db; back up PostgreSQL with pg_dump")
+		}
+		if strings.Contains(c.Backup, "://") {
+			if u, err := url.Parse(c.Backup); err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+				return errors.New("-backup must be a directory or an s3://bucket/prefix or gs://bucket/prefix URL")
+			}
+		}
+		if _, err := cron.Parse(c.BackupSchedule); err != nil {
+			return fmt.Errorf("-backup-schedule: %w", err)
+		}
+	}
+	if c.BackupEndpoint != "" {
+		if u, err := url.Parse(c.BackupEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return errors.New("-backup-endpoint must be an http or https URL without a path")
+		}
+	}
+	if c.BackupKeep < 0 {
+		return errors.New("-backup-keep must not be negative")
+	}
+	if _, err := c.socketMode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c config) socketMode() (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("-socket-mode %q must be octal permissions like 0660", c.SocketMode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+func (c config) tlsEnabled() bool {
+	return c.TLSCert != "" || c.ACME
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func splitLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// listFlag is a repeatable flag; every use appends a value.
+type listFlag struct {
+	values *[]string
+}
+
+func (l listFlag) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, "\n")
+}
+
+func (l listFlag) Set(v string) error {
+	*l.values = append(*l.values, v)
+	return nil
+}
+
+// envPattern finds the variable a flag defaults to in its usage string.
+var envPattern = regexp.MustCompile(`\(env ([A-Z0-9_]+)`)
+
+func flagEnv(f *flag.Flag) string {
+	if m := envPattern.FindStringSubmatch(f.Usage); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// applyConfigFile sets flags in fs from a TOML file whose keys are flag names:
+//
+//	port = "8080"
+//	db = "/var/lib/loderunner2099/game.db"
+//	rate-limit-rule = ["POST /api/levels -> 3/h"]
+//
+// The file is the weakest source: a flag given on the command line or an
+// environment variable it reads wins over it. It returns the names of the
+// flags it set.
+func applyConfigFile(fs *flag.FlagSet, path string) (map[string]bool, error) {
+	applied := map[string]bool{}
+	if path == "" {
+		return applied, nil
+	}
+	var settings map[string]any
+	if _, err := toml.DecodeFile(path, &settings); err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" || name == "print-config" {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if onCommandLine[name] || (flagEnv(f) != "" && os.Getenv(flagEnv(f)) != "") {
+			continue
+		}
+		values, err := settingValues(settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, name, err)
+		}
+		if _, repeatable := f.Value.(listFlag); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return nil, fmt.Errorf("config file %s: %s: %w", path, name, err)
+			}
+		}
+		applied[name] = true
+	}
+	return applied, nil
+}
+
+// settingValues flattens a TOML value to flag strings. Arrays become one
+// value per element.
+func settingValues(v any) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case bool, int64, float64:
+		return []string{fmt.Sprint(v)}, nil
+	case []any:
+		var out []string
+		for _, item := range v {
+			s, err := settingValues(item)
+			if err != nil || len(s) != 1 {
+				return nil, fmt.Errorf("arrays may only hold strings, numbers and booleans")
+			}
+			out = append(out, s[0])
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// printConfig writes every flag's effective value as a config file, noting
+// where each came from. Secrets are masked.
+func printConfig(w io.Writer, fs *flag.FlagSet, fromFile map[string]bool) {
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	fmt.Fprintln(w, "# Effective configuration")
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" {
+			return
+		}
+		source := "default"
+		switch env := flagEnv(f); {
+		case onCommandLine[f.Name]:
+			source = "flag"
+		case env != "" && os.Getenv(env) != "":
+			source = "env " + env
+		case fromFile[f.Name]:
+			source = "file"
+		}
+		fmt.Fprintf(w, "%s = %s # %s\n", f.Name, tomlValue(f), source)
+	})
+}
+
+func tomlValue(f *flag.Flag) string {
+	v := f.Value.String()
+	if secretFlag(f.Name) && v != "" {
+		return `"********"`
+	}
+	if l, ok := f.Value.(listFlag); ok {
+		quoted := []string{}
+		if l.values != nil {
+			for _, item := range *l.values {
+				quoted = append(quoted, strconv.Quote(item))
+			}
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	if g, ok := f.Value.(flag.Getter); ok {
+		switch g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return v
+		}
+	}
+	return strconv.Quote(v)
+}
+
+func secretFlag(name string) bool {
+	return strings.Contains(name, "secret") || strings.Contains(name, "token") || strings.Contains(name, "password")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+)
+
+var contentRejections = defaultRegistry.counter("loderunner_content_rejections_total",
+	"Text rejected by the content filter, by kind.", "kind")
+
+// contentFilter checks what players write for others to see: account,
+// score and lobby names, lobby chat, and level and clan text. A nil filter, with
+// -content-filter=false, allows everything.
+type contentFilter struct {
+	filter *moderation.Filter
+}
+
+func newContentFilter(cfg config) (*contentFilter, error) {
+	if !cfg.ContentFilter {
+		return nil, nil
+	}
+	blocked, err := readWordList("-blocklist", cfg.Blocklist)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := readWordList("-allowlist", cfg.Allowlist)
+	if err != nil {
+		return nil, err
+	}
+	var external moderation.Checker
+	if cfg.ModerationURL != "" {
+		external = &moderation.HTTPChecker{URL: cfg.ModerationURL, Token: cfg.ModerationToken}
+	}
+	return &contentFilter{filter: moderation.New(blocked, allowed, external)}, nil
+}
+
+func readWordList(flagName, path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", flagName, err)
+	}
+	defer f.Close()
+	return moderation.ParseList(f), nil
+}
+
+// check reports whether text of the kind is fit to show. If the
+// moderation service can't be asked, text the lists pass is allowed:
+// players shouldn't be locked out of naming things while it is down.
+func (c *contentFilter) check(ctx context.Context, kind, text string) bool {
+	if c == nil {
+		return true
+	}
+	ok, err := c.filter.Allowed(ctx, kind, text)
+	if err != nil {
+		log.Printf("content filter: %v", err)
+		return true
+	}
+	if !ok {
+		contentRejections.inc(kind)
+	}
+	return ok
+}
+
+// allow checks the text in field, responding 422 if it isn't fit to show.
+func (c *contentFilter) allow(w http.ResponseWriter, r *http.Request, field, kind, text string) bool {
+	if c.check(r.Context(), kind, text) {
+		return true
+	}
+	writeError(w, http.StatusUnprocessableEntity, field+" isn't allowed; try something else")
+	return false
+}
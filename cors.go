@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// corsExposed are the response headers pages on other origins may read.
+const corsExposed = "Retry-After, " + requestIDHeader + ", " + configSignatureHeader + ", " +
+	quotaLimitHeader + ", " + quotaRemainingHeader + ", " + quotaResetHeader + ", " + storageLimitHeader + ", " + storageUsedHeader
+
+// corsPolicy lets pages on other origins, such as the game embedded on
+// itch.io, call the game API and join the lobby. The admin API and static
+// files are left alone.
+type corsPolicy struct {
+	origins     []string // lowercase origin globs, or "*"
+	methods     string
+	credentials bool
+	maxAge      string // seconds
+}
+
+// newCORSPolicy returns nil when -cors-origins is empty.
+func newCORSPolicy(cfg config) (*corsPolicy, error) {
+	origins, err := parseCORSOrigins(cfg.CORSOrigins)
+	if err != nil || len(origins) == 0 {
+		return nil, err
+	}
+	if cfg.CORSCredentials && len(origins) == 1 && origins[0] == "*" {
+		return nil, errors.New("-cors-credentials can't go with -cors-origins \"*\"; list the origins")
+	}
+	var methods []string
+	for _, m := range cfg.CORSMethods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	return &corsPolicy{
+		origins:     origins,
+		methods:     strings.Join(methods, ", "),
+		credentials: cfg.CORSCredentials,
+		maxAge:      strconv.Itoa(int(cfg.CORSMaxAge.Seconds())),
+	}, nil
+}
+
+// parseCORSOrigins checks -cors-origins: "*", or origins such as
+// https://itch.io, where * stands for any run of characters but slashes,
+// as in https://*.itch.zone.
+func parseCORSOrigins(items []string) ([]string, error) {
+	var origins []string
+	for _, o := range items {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		if o == "*" {
+			if len(items) > 1 {
+				return nil, errors.New("-cors-origins: \"*\" must be the only origin")
+			}
+			return []string{o}, nil
+		}
+		scheme, host, ok := strings.Cut(o, "://")
+		if !ok || (scheme != "http" && scheme != "https") || host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("-cors-origins: %q isn't an origin like https://example.com", o)
+		}
+		if _, err := path.Match(o, ""); err != nil {
+			return nil, fmt.Errorf("-cors-origins %q: %w", o, err)
+		}
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+func (c *corsPolicy) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range c.origins {
+		if ok, _ := path.Match(pattern, origin); ok || pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// corsPath reports whether p is one of the routes cross-origin pages may
+// use.
+func corsPath(p string) bool {
+	return p == "/ws" || strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/api/admin/")
+}
+
+// withCORS answers preflight requests from allowed origins, and adds the
+// CORS headers to their other requests. Requests from anywhere else pass
+// through without them, so browsers keep their responses from the page.
+func withCORS(c *corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !corsPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		if c.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", c.methods)
+			if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				h.Set("Access-Control-Allow-Headers", req)
+			}
+			h.Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposed)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wsOrigins returns the origin patterns the lobby's WebSocket accepts
+// besides its own, as websocket.AcceptOptions wants them.
+func (c *corsPolicy) wsOrigins() []string {
+	if c == nil {
+		return nil
+	}
+	return c.origins
+}
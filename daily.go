@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	// dailyDateLayout names a daily challenge; days roll over at UTC
+	// midnight.
+	dailyDateLayout = "2006-01-02"
+
+	// dailyGrace keeps yesterday's challenge open for scores from runs
+	// that were still going at midnight.
+	dailyGrace = 15 * time.Minute
+
+	dailyDifficulty = "normal"
+)
+
+// seedAlphabet matches generateSeedCode in the client, which avoids
+// ambiguous characters.
+const seedAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// dailyChallenge derives each day's seed from the date and a server secret,
+// so seeds can't be predicted ahead of time but every server instance
+// agrees on them.
+type dailyChallenge struct {
+	secret []byte
+}
+
+// newDailyChallenge uses secret if set, otherwise a secret generated once
+// and kept in the database so seeds survive restarts.
+func newDailyChallenge(db *storage.DB, secret string) (*dailyChallenge, error) {
+	d := &dailyChallenge{secret: []byte(secret)}
+	if secret == "" {
+		var err error
+		if d.secret, err = db.Secret(context.Background(), "daily", 32); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// seed returns the 6-character seed code for a date.
+func (d *dailyChallenge) seed(date string) string {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte("daily:" + date))
+	n := binary.BigEndian.Uint64(mac.Sum(nil))
+	code := make([]byte, 6)
+	for i := range code {
+		code[i] = seedAlphabet[n%uint64(len(seedAlphabet))]
+		n /= uint64(len(seedAlphabet))
+	}
+	return string(code)
+}
+
+// bucket returns the daily challenge a run with seed and difficulty,
+// submitted now, belongs to, or "" if it isn't a daily run.
+func (d *dailyChallenge) bucket(seed, difficulty string) string {
+	if difficulty != dailyDifficulty {
+		return ""
+	}
+	now := time.Now().UTC()
+	for _, t := range []time.Time{now, now.Add(-dailyGrace)} {
+		if date := t.Format(dailyDateLayout); seed == d.seed(date) {
+			return date
+		}
+	}
+	return ""
+}
+
+// date parses the date query parameter, defaulting to today. Future dates
+// are refused so nobody can practice tomorrow's seed.
+func (d *dailyChallenge) date(r *http.Request) (time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	v := r.URL.Query().Get("date")
+	if v == "" {
+		return today, nil
+	}
+	t, err := time.Parse(dailyDateLayout, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date must be YYYY-MM-DD")
+	}
+	if t.After(today) {
+		return time.Time{}, fmt.Errorf("daily challenge for %s isn't out yet", v)
+	}
+	return t, nil
+}
+
+// dailyAPI serves /api/daily.
+type dailyAPI struct {
+	db    *storage.DB
+	daily *dailyChallenge
+}
+
+func (a *dailyAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/daily", a.show)
+	mux.HandleFunc("GET /api/daily/scores", a.scores)
+}
+
+// show returns a day's challenge. Today's is cacheable until it rolls
+// over.
+func (a *dailyAPI) show(w http.ResponseWriter, r *http.Request) {
+	day, err := a.daily.date(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	date := day.Format(dailyDateLayout)
+	resets := day.Add(24 * time.Hour)
+	if ttl := int(time.Until(resets).Seconds()); ttl > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", ttl))
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"date":       date,
+		"seed":       a.daily.seed(date),
+		"difficulty": dailyDifficulty,
+		"resets_at":  resets,
+	})
+}
+
+// scores returns a day's leaderboard. Submitting a run with the daily seed
+// through POST /api/scores is what puts it here.
+func (a *dailyAPI) scores(w http.ResponseWriter, r *http.Request) {
+	day, err := a.daily.date(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(r, "limit", 10, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f := storage.ScoreFilter{
+		Daily:    day.Format(dailyDateLayout),
+		Verified: r.URL.Query().Get("verified") == "true",
+	}
+	entries, total, err := a.db.TopScores(r.Context(), f, limit, offset)
+	if err != nil {
+		a.fail(w, "list daily scores", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"date":   f.Daily,
+		"scores": entries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (a *dailyAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("daily: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+
+	"github.com/jgbrwn/loderunner2099/internal/dashboard"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// adminCookie holds the admin token for dashboard users who sign in with
+// it rather than with an admin account. SameSite=Strict keeps other sites
+// from riding on it.
+const adminCookie = "lr_admin"
+
+// dashboardAssets are the files under /admin/ anyone may fetch; they hold
+// no data. The pages themselves go through the admin check.
+var dashboardAssets = []string{"admin.css", "admin.js", "login.js"}
+
+// registerDashboard serves the moderation dashboard at /admin/. Admins get
+// the dashboard, everyone else a sign-in page.
+func (a *adminAPI) registerDashboard(mux *http.ServeMux) {
+	files := dashboard.FS()
+	mux.HandleFunc("GET /admin/{$}", func(w http.ResponseWriter, r *http.Request) {
+		page := "login.html"
+		if _, ok := a.actor(r); ok {
+			page = "index.html"
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		http.ServeFileFS(w, r, files, page)
+	})
+	mux.HandleFunc("GET /admin/{file}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("file")
+		if !slices.Contains(dashboardAssets, name) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFileFS(w, r, files, name)
+	})
+	mux.HandleFunc("POST /admin/login", a.dashboardLogin)
+	mux.HandleFunc("POST /admin/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: adminCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: a.proxies.scheme(r) == "https", SameSite: http.SameSiteStrictMode})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// dashboardLogin checks the admin token and keeps it in a cookie for the
+// browser session.
+func (a *adminAPI) dashboardLogin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(body.Token), []byte(a.token)) != 1 {
+		auditAccount(r, a.db, storage.AuditEntry{Actor: addressActor(a.proxies, r), Action: "admin.login_failed", Detail: "dashboard"})
+		writeError(w, http.StatusUnauthorized, "wrong admin token")
+		return
+	}
+	auditAccount(r, a.db, storage.AuditEntry{Actor: "token", Action: "admin.login", Detail: "dashboard"})
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminCookie,
+		Value:    body.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.proxies.scheme(r) == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hasCookie(r *http.Request, name string) bool {
+	_, err := r.Cookie(name)
+	return err == nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+)
+
+// enableDebug turns on what -debug's endpoints report beyond the defaults.
+// Contention in the WebSocket relay shows up in the mutex and block
+// profiles, which are sampled lightly while it is on.
+func enableDebug(hub *lobby.Hub) {
+	runtime.SetMutexProfileFraction(100)
+	runtime.SetBlockProfileRate(10_000) // one event per 10µs spent blocked
+
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("lobby", expvar.Func(func() any {
+		rooms, inRooms, connected := hub.Stats()
+		return map[string]int{"connections": connected, "rooms": rooms, "in_rooms": inRooms, "spectators": hub.Spectators()}
+	}))
+}
+
+// registerDebug mounts the net/http/pprof profiles and expvar's
+// /debug/vars on an internal mux.
+func registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+}
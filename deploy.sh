@@ -8,7 +8,7 @@ echo "📦 Building game..."
 npm run build
 
 echo "🔨 Building server..."
-go build -o server server.go
+go build -o server .
 
 echo "🔄 Restarting service..."
 sudo systemctl restart loderunner2099
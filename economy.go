@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Coins for a verified run: runCoins, plus one per runCoinsPoints points,
+// up to maxRunCoins. A player earns at most dailyRunCoins a day from
+// runs, so replaying an easy level doesn't pay forever.
+const (
+	runCoins       = 10
+	runCoinsPoints = 1000
+	maxRunCoins    = 50
+	dailyRunCoins  = 500
+
+	maxShopItemName = 64
+	maxShopPrice    = 1_000_000
+	maxCoinGrant    = 1_000_000
+)
+
+// shopItemPattern is what item IDs and kinds look like; the game refers
+// to items by them.
+var shopItemPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var coinTransactions = defaultRegistry.counter("loderunner_coin_transactions_total",
+	"Coin transactions, by kind.", "kind")
+
+// economyAPI serves the shop and players' wallets. The server is the only
+// thing that moves coins: they are earned by runs whose replays verify,
+// spent on items here and given or taken by moderators, and every move is
+// a row in the player's ledger. Nothing the client sends says how many
+// coins it has or what it owns.
+type economyAPI struct {
+	db *storage.DB
+}
+
+func (a *economyAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/shop", a.items)
+	mux.HandleFunc("POST /api/shop/{id}/buy", a.buy)
+	mux.HandleFunc("GET /api/me/wallet", a.wallet)
+	mux.HandleFunc("GET /api/me/wallet/transactions", a.transactions)
+}
+
+func (a *economyAPI) items(w http.ResponseWriter, r *http.Request) {
+	items, err := a.db.ShopItems(r.Context(), false)
+	if err != nil {
+		a.fail(w, "shop items", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *economyAPI) buy(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	t, err := a.db.BuyItem(r.Context(), p.ID, r.PathValue("id"))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "item not for sale")
+	case errors.Is(err, storage.ErrOwned):
+		writeError(w, http.StatusConflict, "you already have that item")
+	case errors.Is(err, storage.ErrInsufficientCoins):
+		writeError(w, http.StatusConflict, "not enough coins")
+	case err != nil:
+		a.fail(w, "buy item", err)
+	default:
+		coinTransactions.inc(t.Kind)
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
+
+func (a *economyAPI) wallet(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	wallet, err := a.db.Wallet(r.Context(), p.ID)
+	if err != nil {
+		a.fail(w, "wallet", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wallet)
+}
+
+func (a *economyAPI) transactions(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	writeLedger(w, r, a.db, p.ID, a.fail)
+}
+
+func (a *economyAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("economy: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// writeLedger responds with one page of a player's transactions.
+func writeLedger(w http.ResponseWriter, r *http.Request, db *storage.DB, playerID int64, fail func(http.ResponseWriter, string, error)) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	transactions, total, err := db.CoinTransactions(r.Context(), playerID, limit, offset)
+	if err != nil {
+		fail(w, "transactions", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"transactions": transactions,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// payRun credits a verified score's account with coins for it. A score
+// pays once, however often it is verified.
+func payRun(ctx context.Context, db *storage.DB, s storage.Score) {
+	coins := min(runCoins+s.Score/runCoinsPoints, maxRunCoins)
+	t, err := db.PayRun(ctx, s.PlayerID, s.ID, coins, dailyRunCoins)
+	if errors.Is(err, storage.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("economy: pay score %d: %v", s.ID, err)
+		return
+	}
+	coinTransactions.inc(t.Kind)
+}
+
+// shopItems lists every item, retired ones included.
+func (a *adminAPI) shopItems(w http.ResponseWriter, r *http.Request, _ string) {
+	items, err := a.db.ShopItems(r.Context(), true)
+	if err != nil {
+		a.fail(w, "shop items", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// putShopItem adds an item to the shop or changes one, from a body like
+// {"name": "Gold Suit", "kind": "skin", "price": 500}. A retired item
+// goes back on sale. Players keep what they paid before a price change.
+func (a *adminAPI) putShopItem(w http.ResponseWriter, r *http.Request, actor string) {
+	id := r.PathValue("id")
+	var body struct {
+		Name  string `json:"name"`
+		Kind  string `json:"kind"`
+		Price int64  `json:"price"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	var err error
+	switch {
+	case !shopItemPattern.MatchString(id):
+		err = errors.New("item IDs must be 1-32 lowercase letters, digits, '_' or '-'")
+	case !shopItemPattern.MatchString(body.Kind):
+		err = errors.New("kind must be 1-32 lowercase letters, digits, '_' or '-'")
+	case body.Price < 0 || body.Price > maxShopPrice:
+		err = errors.New("price must be 0-" + strconv.Itoa(maxShopPrice))
+	default:
+		err = checkText("name", body.Name, maxShopItemName, true)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	before, err := a.shopItemState(r.Context(), id)
+	if err != nil {
+		a.fail(w, "shop items", err)
+		return
+	}
+	it, err := a.db.PutShopItem(r.Context(), storage.ShopItem{ID: id, Name: body.Name, Kind: body.Kind, Price: body.Price})
+	if err != nil {
+		a.fail(w, "put shop item", err)
+		return
+	}
+	detail := fmt.Sprintf("%s (%s) for %d coins", it.Name, it.Kind, it.Price)
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "shop.put", TargetKind: "item", TargetID: it.ID, Detail: detail, Before: before, After: auditState(it)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: shop.put item %s", actor, it.ID)
+	writeJSON(w, http.StatusOK, it)
+}
+
+// retireShopItem takes an item off sale. Players who have it keep it.
+func (a *adminAPI) retireShopItem(w http.ResponseWriter, r *http.Request, actor string) {
+	id := r.PathValue("id")
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	before, err := a.shopItemState(r.Context(), id)
+	if err != nil {
+		a.fail(w, "shop items", err)
+		return
+	}
+	if !a.act(w, r, a.db.RetireShopItem(r.Context(), id), "no item on sale by that ID") {
+		return
+	}
+	after, err := a.shopItemState(r.Context(), id)
+	if err != nil {
+		a.fail(w, "shop items", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "shop.retire", TargetKind: "item", TargetID: id, Detail: reason, Before: before, After: after})
+}
+
+// shopItemState returns the item with id, retired or not, for the audit
+// log, or nil if there is none.
+func (a *adminAPI) shopItemState(ctx context.Context, id string) (json.RawMessage, error) {
+	items, err := a.db.ShopItems(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if i := slices.IndexFunc(items, func(it storage.ShopItem) bool { return it.ID == id }); i >= 0 {
+		return auditState(items[i]), nil
+	}
+	return nil, nil
+}
+
+func (a *adminAPI) playerTransactions(w http.ResponseWriter, r *http.Request, _ string) {
+	if id, ok := pathID(w, r); ok {
+		writeLedger(w, r, a.db, id, a.fail)
+	}
+}
+
+func (a *adminAPI) playerWallet(w http.ResponseWriter, r *http.Request, _ string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	wallet, err := a.db.Wallet(r.Context(), id)
+	if err != nil {
+		a.fail(w, "wallet", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wallet)
+}
+
+// adjustWallet gives a player coins or an item, or takes them away, from
+// a body like {"coins": 100, "item": "gold-suit", "reason": "..."}.
+func (a *adminAPI) adjustWallet(kind string) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, actor string) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		var body struct {
+			Coins  int64  `json:"coins"`
+			Item   string `json:"item"`
+			Reason string `json:"reason"`
+		}
+		if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		var err error
+		switch {
+		case body.Coins < 0 || body.Coins > maxCoinGrant:
+			err = errors.New("coins must be 0-" + strconv.Itoa(maxCoinGrant))
+		case body.Coins == 0 && body.Item == "":
+			err = errors.New("coins or item is required")
+		default:
+			err = checkText("reason", body.Reason, maxReasonLength, false)
+		}
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		t, err := a.db.AdjustWallet(r.Context(), storage.CoinTransaction{
+			PlayerID: id, Kind: kind, Amount: body.Coins, Item: body.Item, Reason: body.Reason, CreatedBy: actor,
+		})
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			msg := "player or item not found"
+			if kind == storage.TransactionRevoke && body.Item != "" {
+				msg = "player not found, or they don't have that item"
+			}
+			writeError(w, http.StatusNotFound, msg)
+			return
+		case errors.Is(err, storage.ErrOwned):
+			writeError(w, http.StatusConflict, "the player already has that item")
+			return
+		case errors.Is(err, storage.ErrInsufficientCoins):
+			writeError(w, http.StatusConflict, "the player doesn't have that many coins")
+			return
+		case err != nil:
+			a.fail(w, "adjust wallet", err)
+			return
+		}
+		coinTransactions.inc(t.Kind)
+		var what []string
+		if t.Amount != 0 {
+			what = append(what, fmt.Sprintf("%+d coins", t.Amount))
+		}
+		if t.Item != "" {
+			what = append(what, "item "+t.Item)
+		}
+		detail := strings.Join(what, ", ")
+		if t.Reason != "" {
+			detail += ": " + t.Reason
+		}
+		playerID := strconv.FormatInt(id, 10)
+		if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "wallet." + kind, TargetKind: "player", TargetID: playerID, Detail: detail, After: auditState(t)}); err != nil {
+			a.fail(w, "record audit", err)
+			return
+		}
+		log.Printf("🛡️  %s: wallet.%s player %s", actor, kind, playerID)
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
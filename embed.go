@@ -0,0 +1,22 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// embeddedDist holds the production build when compiled with -tags embed,
+// so the server ships as a single self-contained binary.
+//
+//go:embed all:dist
+var embeddedDist embed.FS
+
+func embeddedFS() (fs.FS, bool) {
+	sub, err := fs.Sub(embeddedDist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return sub, true
+}
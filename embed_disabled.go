@@ -0,0 +1,11 @@
+//go:build !embed
+
+package main
+
+import "io/fs"
+
+// embeddedFS reports that no build is embedded; the server reads dist/ from
+// disk instead. Build with -tags embed to bundle it.
+func embeddedFS() (fs.FS, bool) {
+	return nil, false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+)
+
+// errorPages renders dist/<status>.html (404.html, 500.html, ...) when the
+// build provides one, so errors look like part of the game instead of Go's
+// plain-text defaults.
+type errorPages struct {
+	fsys fs.FS
+}
+
+// serve writes an error response for code. Error pages are never cached:
+// the next request may well succeed.
+func (p *errorPages) serve(w http.ResponseWriter, r *http.Request, code int) {
+	h := w.Header()
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Content-Encoding")
+	h.Set("Cache-Control", "no-cache")
+
+	page, err := fs.ReadFile(p.fsys, strconv.Itoa(code)+".html")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%d %s", code, http.StatusText(code)), code)
+		return
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(page)))
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		w.Write(page)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// etagManifest maps dist files to strong ETags derived from their content.
+// It is primed when the server starts and entries are recomputed whenever a
+// file's size or modification time changes, so a rebuild of dist/ is picked
+// up without a restart.
+type etagManifest struct {
+	mu      sync.RWMutex
+	entries map[string]etagEntry
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func newETagManifest() *etagManifest {
+	return &etagManifest{entries: map[string]etagEntry{}}
+}
+
+// prime hashes every regular file in fsys and returns how many it indexed.
+func (m *etagManifest) prime(fsys fs.FS) int {
+	n := 0
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil && m.get(fsys, name, fi) != "" {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// get returns the ETag for name, hashing the file if it has changed since
+// it was last seen. It returns "" if the file can't be read.
+func (m *etagManifest) get(fsys fs.FS, name string, fi fs.FileInfo) string {
+	m.mu.RLock()
+	e, ok := m.entries[name]
+	m.mu.RUnlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.etag
+	}
+
+	etag, err := hashFile(fsys, name)
+	if err != nil {
+		return ""
+	}
+	m.mu.Lock()
+	m.entries[name] = etagEntry{size: fi.Size(), modTime: fi.ModTime(), etag: etag}
+	m.mu.Unlock()
+	return etag
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`, nil
+}
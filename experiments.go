@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxExperimentVariants    = 10
+	maxExperimentDescription = 500
+)
+
+// experimentNamePattern is what experiment and variant names look like;
+// they appear as keys in /api/config.
+var experimentNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// experimentsAPI serves /api/experiments, where the game reports showing a
+// player their variant of an A/B experiment. Moderators define the
+// experiments through the moderation API, and /api/config tells each
+// client its variants.
+type experimentsAPI struct {
+	db *storage.DB
+}
+
+func (a *experimentsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/experiments/{name}/exposures", a.expose)
+}
+
+// expose records the client being shown its variant of a running
+// experiment. The variant is worked out again here rather than taken from
+// the client, so exposures can't be counted against the wrong one.
+func (a *experimentsAPI) expose(w http.ResponseWriter, r *http.Request) {
+	unit, ok := playerToken(w, r)
+	if !ok {
+		return
+	}
+	e, err := a.db.GetExperiment(r.Context(), r.PathValue("name"))
+	if errors.Is(err, storage.ErrNotFound) || err == nil && e.StoppedAt != nil {
+		writeError(w, http.StatusNotFound, "experiment not running")
+		return
+	}
+	if err != nil {
+		a.fail(w, "experiment", err)
+		return
+	}
+	if err := a.db.RecordExposure(r.Context(), e.ID, unit, assignVariant(e, unit), time.Now()); err != nil {
+		a.fail(w, "record exposure", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *experimentsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("experiments: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// assignVariant picks the variant of e for a client by its hashed player
+// token. The experiment's name and the token hash to a point from 0 to 99,
+// which lands in one variant's share of the weights, so a client keeps its
+// variant, and its variants in different experiments are independent.
+func assignVariant(e storage.Experiment, unit string) string {
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + unit))
+	point := int(binary.BigEndian.Uint64(sum[:8]) % 100)
+	for _, v := range e.Variants {
+		if point < v.Weight {
+			return v.Name
+		}
+		point -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+// assignVariants returns the client's variant of every running
+// experiment, keyed by experiment name, and notes it coming back for the
+// retention figures. It returns nil if none are running.
+func assignVariants(r *http.Request, db *storage.DB, unit string) (map[string]string, error) {
+	experiments, err := db.Experiments(r.Context(), true)
+	if err != nil || len(experiments) == 0 {
+		return nil, err
+	}
+	assigned := make(map[string]string, len(experiments))
+	for _, e := range experiments {
+		assigned[e.Name] = assignVariant(e, unit)
+	}
+	return assigned, db.SeeExposedUnit(r.Context(), unit, time.Now())
+}
+
+// experiments lists every experiment, stopped ones included.
+func (a *adminAPI) experiments(w http.ResponseWriter, r *http.Request, _ string) {
+	experiments, err := a.db.Experiments(r.Context(), false)
+	if err != nil {
+		a.fail(w, "experiments", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"experiments": experiments})
+}
+
+// experiment returns an experiment with how each variant has done.
+func (a *adminAPI) experiment(w http.ResponseWriter, r *http.Request, _ string) {
+	e, err := a.db.GetExperiment(r.Context(), r.PathValue("name"))
+	if !a.act(w, r, err, "experiment not found") {
+		return
+	}
+	results, err := a.db.ExperimentResults(r.Context(), e.ID)
+	if err != nil {
+		a.fail(w, "experiment results", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"experiment": e, "results": results})
+}
+
+// createExperiment starts an experiment from a body like
+// {"name": "tutorial", "variants": [{"name": "a", "weight": 50}, ...]}.
+// The weights are percentages of clients and must add up to 100. An
+// experiment's variants can't be changed once it has started, since that
+// would move clients between them; stop it and start another instead.
+func (a *adminAPI) createExperiment(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Variants    []storage.Variant `json:"variants"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	body.Name, body.Description = strings.TrimSpace(body.Name), strings.TrimSpace(body.Description)
+	if err := checkExperiment(body.Name, body.Description, body.Variants); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	e, err := a.db.CreateExperiment(r.Context(), storage.Experiment{
+		Name: body.Name, Description: body.Description, Variants: body.Variants, CreatedBy: actor,
+	})
+	if errors.Is(err, storage.ErrExperimentExists) {
+		writeError(w, http.StatusConflict, "an experiment with that name already exists")
+		return
+	}
+	if err != nil {
+		a.fail(w, "create experiment", err)
+		return
+	}
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "experiment.create", TargetKind: "experiment", TargetID: e.Name, Detail: e.Description, After: auditState(e)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: experiment.create experiment %s", actor, e.Name)
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func checkExperiment(name, description string, variants []storage.Variant) error {
+	if !experimentNamePattern.MatchString(name) {
+		return errors.New("name must be 1-32 lowercase letters, digits, '_' or '-'")
+	}
+	if err := checkText("description", description, maxExperimentDescription, false); err != nil {
+		return err
+	}
+	if len(variants) < 2 || len(variants) > maxExperimentVariants {
+		return errors.New("variants must have 2-" + strconv.Itoa(maxExperimentVariants) + " entries")
+	}
+	seen := make(map[string]bool, len(variants))
+	total := 0
+	for _, v := range variants {
+		switch {
+		case !experimentNamePattern.MatchString(v.Name):
+			return errors.New("variant names must be 1-32 lowercase letters, digits, '_' or '-'")
+		case seen[v.Name]:
+			return errors.New("duplicate variant " + v.Name)
+		case v.Weight < 1 || v.Weight > 100:
+			return errors.New("variant weights must be 1-100")
+		}
+		seen[v.Name] = true
+		total += v.Weight
+	}
+	if total != 100 {
+		return errors.New("variant weights must add up to 100")
+	}
+	return nil
+}
+
+// stopExperiment stops assigning an experiment's variants, keeping its
+// results.
+func (a *adminAPI) stopExperiment(w http.ResponseWriter, r *http.Request, actor string) {
+	name := r.PathValue("name")
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.StopExperiment(r.Context(), name), "no running experiment by that name") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "experiment.stop", TargetKind: "experiment", TargetID: name, Detail: reason})
+}
+
+// deleteExperiment removes an experiment and its results.
+func (a *adminAPI) deleteExperiment(w http.ResponseWriter, r *http.Request, actor string) {
+	name := r.PathValue("name")
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.DeleteExperiment(r.Context(), name), "experiment not found") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "experiment.delete", TargetKind: "experiment", TargetID: name, Detail: reason})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// feedEntries is how many entries each feed lists.
+const feedEntries = 50
+
+// feedMaxAge is how long feed readers and CDNs may cache a feed. Readers
+// that poll more often get a 304 while it's unchanged.
+const feedMaxAge = 10 * time.Minute
+
+// feedsAPI serves Atom feeds of newly published community levels, at
+// /feeds/levels.xml, and of broken world records, at /feeds/records.xml,
+// so players can follow them in a feed reader instead of polling the API.
+type feedsAPI struct {
+	db        *storage.DB
+	publicURL string
+}
+
+func (a *feedsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /feeds/levels.xml", a.levels)
+	mux.HandleFunc("GET /feeds/records.xml", a.records)
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Link      atomLink   `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomPerson `xml:"author"`
+	Summary   string     `xml:"summary"`
+}
+
+// atomTime formats t as Atom wants it.
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// levels is GET /feeds/levels.xml: the newest published levels, each
+// linking to its /levels/{id} page.
+func (a *feedsAPI) levels(w http.ResponseWriter, r *http.Request) {
+	levels, _, err := a.db.ListLevels(r.Context(), storage.LevelQuery{Sort: storage.SortNewest, Limit: feedEntries})
+	if err != nil {
+		log.Printf("feeds: list levels: %v (request %s)", err, requestID(w))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	base := siteBase(a.publicURL, r)
+	feed := newAtomFeed(base, "/feeds/levels.xml", "Lode Runner 2099: new levels", "Community levels as they're published.")
+	var updated time.Time
+	for _, l := range levels {
+		link := base + "/levels/" + strconv.FormatInt(l.ID, 10)
+		summary := fmt.Sprintf("Gold: %d. Guards: %d.", l.Gold, l.Guards)
+		if l.Description != "" {
+			summary = l.Description + "\n\n" + summary
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     l.Title,
+			ID:        link,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+			Published: atomTime(l.CreatedAt),
+			Updated:   atomTime(l.CreatedAt),
+			Author:    atomPerson{Name: l.Author},
+			Summary:   summary,
+		})
+		if l.CreatedAt.After(updated) {
+			updated = l.CreatedAt
+		}
+	}
+	serveFeed(w, r, feed, updated)
+}
+
+// records is GET /feeds/records.xml: the newest world records, each
+// linking to the score's share page.
+func (a *feedsAPI) records(w http.ResponseWriter, r *http.Request) {
+	scores, err := a.db.WorldRecords(r.Context(), feedEntries)
+	if err != nil {
+		log.Printf("feeds: world records: %v (request %s)", err, requestID(w))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	base := siteBase(a.publicURL, r)
+	feed := newAtomFeed(base, "/feeds/records.xml", "Lode Runner 2099: world records", "Every new best score on the global leaderboard.")
+	var updated time.Time
+	for _, s := range scores {
+		link := base + "/share/score/" + strconv.FormatInt(s.ID, 10)
+		summary := fmt.Sprintf("%s scored %d points on level %d in %s.", s.Player, s.Score, s.Level, formatRunTime(s.Time))
+		if s.Difficulty != "" {
+			summary += " Difficulty: " + s.Difficulty + "."
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     fmt.Sprintf("New world record: %s scored %d", s.Player, s.Score),
+			ID:        link,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+			Published: atomTime(s.CreatedAt),
+			Updated:   atomTime(s.CreatedAt),
+			Author:    atomPerson{Name: s.Player},
+			Summary:   summary,
+		})
+		if s.CreatedAt.After(updated) {
+			updated = s.CreatedAt
+		}
+	}
+	serveFeed(w, r, feed, updated)
+}
+
+// newAtomFeed returns an empty feed served at base+path.
+func newAtomFeed(base, path, title, subtitle string) atomFeed {
+	return atomFeed{
+		Title:    title,
+		Subtitle: subtitle,
+		ID:       base + path,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + path},
+			{Rel: "alternate", Type: "text/html", Href: base + "/"},
+		},
+		Author: atomPerson{Name: "Lode Runner 2099"},
+	}
+}
+
+// serveFeed writes feed, last changed at updated (zero when it's empty),
+// with an ETag so readers can revalidate it.
+func serveFeed(w http.ResponseWriter, r *http.Request, feed atomFeed, updated time.Time) {
+	if updated.IsZero() {
+		updated = processStart
+	}
+	feed.Updated = atomTime(updated)
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("feeds: encode: %v (request %s)", err, requestID(w))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(b.Bytes())
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedMaxAge.Seconds())))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	http.ServeContent(w, r, "", updated, bytes.NewReader(b.Bytes()))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxFriends       = 200
+	friendCodeLength = 8
+)
+
+// friendsAPI serves /api/friends: a signed-in player's friends, added by
+// friend code, their latest scores and how the player's bests compare
+// with each friend's.
+type friendsAPI struct {
+	db *storage.DB
+}
+
+func (a *friendsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/friends", a.list)
+	mux.HandleFunc("POST /api/friends", a.add)
+	mux.HandleFunc("GET /api/friends/code", a.code)
+	mux.HandleFunc("POST /api/friends/code", a.newCode)
+	mux.HandleFunc("GET /api/friends/scores", a.scores)
+	mux.HandleFunc("DELETE /api/friends/{id}", a.remove)
+	mux.HandleFunc("GET /api/friends/{id}/compare", a.compare)
+}
+
+func (a *friendsAPI) list(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	friends, err := a.db.Friends(r.Context(), p.ID)
+	if err != nil {
+		a.fail(w, "list friends", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"friends": friends})
+}
+
+// add makes the player with the friend code in a body like {"code": "..."}
+// a friend.
+func (a *friendsAPI) add(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	f, created, err := a.db.AddFriend(r.Context(), p.ID, strings.ToLower(strings.TrimSpace(body.Code)), maxFriends)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "friend code not found")
+	case errors.Is(err, storage.ErrOwnFriendCode):
+		writeError(w, http.StatusUnprocessableEntity, "that's your own friend code")
+	case errors.Is(err, storage.ErrTooManyFriends):
+		writeError(w, http.StatusConflict, "too many friends (at most 200)")
+	case err != nil:
+		a.fail(w, "add friend", err)
+	case created:
+		writeJSON(w, http.StatusCreated, f)
+	default:
+		writeJSON(w, http.StatusOK, f)
+	}
+}
+
+func (a *friendsAPI) remove(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	switch err := a.db.RemoveFriend(r.Context(), p.ID, id); {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not friends")
+	case err != nil:
+		a.fail(w, "remove friend", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// code returns the player's friend code, giving them one the first time.
+func (a *friendsAPI) code(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	code, err := a.db.FriendCode(r.Context(), p.ID)
+	if err == nil && code == "" {
+		code, err = a.setCode(r, p.ID, false)
+	}
+	if err != nil {
+		a.fail(w, "friend code", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"code": code})
+}
+
+// newCode replaces the player's friend code, so the old one stops
+// working. Existing friends stay friends.
+func (a *friendsAPI) newCode(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	code, err := a.setCode(r, p.ID, true)
+	if err != nil {
+		a.fail(w, "new friend code", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"code": code})
+}
+
+// setCode gives the player a random friend code, retrying on collisions.
+func (a *friendsAPI) setCode(r *http.Request, playerID int64, replace bool) (string, error) {
+	for range shortCodeAttempts {
+		code, err := a.db.SetFriendCode(r.Context(), playerID, randomCode(friendCodeLength), replace)
+		if !errors.Is(err, storage.ErrFriendCodeTaken) {
+			return code, err
+		}
+	}
+	return "", errors.New("no free friend code")
+}
+
+// filter reads the difficulty, seed and season query parameters the feed
+// and comparisons can be narrowed by, writing an error if one is bad.
+func (a *friendsAPI) filter(w http.ResponseWriter, r *http.Request) (storage.ScoreFilter, bool) {
+	season, err := querySeason(r, a.db)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return storage.ScoreFilter{}, false
+	}
+	f := storage.ScoreFilter{
+		Difficulty: strings.ToLower(r.URL.Query().Get("difficulty")),
+		Seed:       r.URL.Query().Get("seed"),
+		Season:     season,
+	}
+	if f.Difficulty != "" && !difficulties[f.Difficulty] {
+		writeError(w, http.StatusBadRequest, "difficulty must be easy, normal, hard or ninja")
+		return storage.ScoreFilter{}, false
+	}
+	return f, true
+}
+
+// scores returns the player's friends' latest scores, newest first.
+func (a *friendsAPI) scores(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	limit, err := queryInt(r, "limit", 20, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f, ok := a.filter(w, r)
+	if !ok {
+		return
+	}
+	scores, err := a.db.FriendScores(r.Context(), p.ID, f, limit)
+	if err != nil {
+		a.fail(w, "friend scores", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"scores": scores, "limit": limit})
+}
+
+// compare returns the player's and a friend's bests on every level either
+// has played.
+func (a *friendsAPI) compare(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	f, ok := a.filter(w, r)
+	if !ok {
+		return
+	}
+	friends, err := a.db.AreFriends(r.Context(), p.ID, id)
+	if err != nil {
+		a.fail(w, "check friend", err)
+		return
+	}
+	if !friends {
+		writeError(w, http.StatusNotFound, "not friends")
+		return
+	}
+	levels, err := a.db.CompareBests(r.Context(), p.ID, id, f)
+	if err != nil {
+		a.fail(w, "compare bests", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"friend_id": id, "levels": levels})
+}
+
+func (a *friendsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("friends: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Gate modes.
+const (
+	gateBasic = "basic" // HTTP Basic Auth
+	gateForm  = "form"  // passcode form that sets a cookie
+)
+
+const (
+	gateCookie    = "lr_gate"
+	gatePath      = "/_gate"
+	gateCookieAge = 30 * 24 * time.Hour
+)
+
+// gate hides a staging deploy behind a shared password. Health probes get
+// through so the load balancer still sees the instance.
+type gate struct {
+	mode     string
+	user     string
+	password string
+	cookie   string // expected cookie value, derived from the password
+	proxies  trustedProxies
+}
+
+func newGate(cfg config, proxies trustedProxies) *gate {
+	mac := hmac.New(sha256.New, []byte(cfg.GatePassword))
+	mac.Write([]byte(gateCookie))
+	return &gate{
+		mode:     cfg.GateMode,
+		user:     cfg.GateUser,
+		password: cfg.GatePassword,
+		cookie:   base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+		proxies:  proxies,
+	}
+}
+
+func (g *gate) check(user, password string) bool {
+	userOK := g.user == "" || subtle.ConstantTimeCompare([]byte(user), []byte(g.user)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(g.password)) == 1
+	return userOK && passOK
+}
+
+func (g *gate) admitted(r *http.Request) bool {
+	if g.mode == gateBasic {
+		user, password, ok := r.BasicAuth()
+		return ok && g.check(user, password)
+	}
+	c, err := r.Cookie(gateCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(g.cookie)) == 1
+}
+
+// withGate refuses everything but health probes until the client has
+// passed the gate. Nothing behind it should end up in search results, so
+// every response also says noindex.
+func withGate(g *gate, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		switch {
+		case r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || g.admitted(r):
+			next.ServeHTTP(w, r)
+		case g.mode == gateForm && r.URL.Path == gatePath && r.Method == http.MethodPost:
+			g.login(w, r)
+		case g.mode == gateBasic:
+			w.Header().Set("WWW-Authenticate", `Basic realm="Lode Runner 2099 preview", charset="UTF-8"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		case strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/ws" || r.Method != http.MethodGet:
+			writeError(w, http.StatusUnauthorized, "passcode required")
+		default:
+			g.form(w, r, http.StatusUnauthorized, false)
+		}
+	})
+}
+
+// login checks the passcode form and sends the browser back where it was
+// going.
+func (g *gate) login(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if !g.check(r.PostFormValue("user"), r.PostFormValue("passcode")) {
+		g.form(w, r, http.StatusUnauthorized, true)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     gateCookie,
+		Value:    g.cookie,
+		Path:     "/",
+		MaxAge:   int(gateCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   g.proxies.scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, localPath(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func (g *gate) form(w http.ResponseWriter, r *http.Request, code int, wrong bool) {
+	next := r.URL.RequestURI()
+	if r.URL.Path == gatePath {
+		next = r.PostFormValue("next")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	gatePage.Execute(w, map[string]any{"Next": localPath(next), "User": g.user != "", "Wrong": wrong})
+}
+
+var gatePage = template.Must(template.New("gate").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Lode Runner 2099 · Preview</title>
+<style>
+body { margin: 0; min-height: 100vh; display: grid; place-items: center; background: #0b0e1a; color: #e8ecff; font: 16px/1.4 system-ui, sans-serif; }
+form { display: grid; gap: .75rem; width: min(20rem, 90vw); }
+input, button { font: inherit; padding: .6rem .8rem; border-radius: .4rem; border: 1px solid #3a4270; background: #141a30; color: inherit; }
+button { background: #ffb400; color: #111; border: 0; font-weight: 600; cursor: pointer; }
+p.wrong { color: #ff6b6b; margin: 0; }
+</style>
+</head>
+<body>
+<form method="post" action="/_gate">
+<h1>Preview build</h1>
+{{if .Wrong}}<p class="wrong">That's not it. Try again.</p>{{end}}
+{{if .User}}<input name="user" placeholder="User" autocomplete="username" required>{{end}}
+<input name="passcode" type="password" placeholder="Passcode" autocomplete="current-password" autofocus required>
+<input type="hidden" name="next" value="{{.Next}}">
+<button>Enter</button>
+</form>
+</body>
+</html>
+`))
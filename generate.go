@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+	"github.com/jgbrwn/loderunner2099/internal/levelgen"
+)
+
+// generateAPI serves GET /api/generate: the level the game's generator
+// makes from a seed, so every client of a daily or endless run plays the
+// same one without generating and checking it itself. The same query
+// always gives the same level, so it needs no database and may be cached.
+type generateAPI struct{}
+
+func (a *generateAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/generate", a.show)
+}
+
+func (a *generateAPI) show(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	seed := strings.TrimSpace(q.Get("seed"))
+	diff := strings.ToLower(strings.TrimSpace(q.Get("difficulty")))
+	if diff == "" {
+		diff = "normal"
+	}
+	switch {
+	case seed == "":
+		writeError(w, http.StatusBadRequest, "seed is required")
+		return
+	case len(seed) > maxSeedLength:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("seed must be at most %d characters", maxSeedLength))
+		return
+	case !difficulties[diff]:
+		writeError(w, http.StatusBadRequest, "difficulty must be easy, normal, hard or ninja")
+		return
+	}
+	n, err := queryInt(r, "level", 1, 1, maxLevel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	l := levelgen.Generate(seed, diff, n)
+	// Empty lists as [], not null.
+	guards, assisted := append([]levelgen.Point{}, l.Guards...), append([]levelgen.Point{}, l.EnemyAssistedGold...)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"seed":                seed,
+		"difficulty":          diff,
+		"level":               n,
+		"width":               level.Width,
+		"height":              level.Height,
+		"tiles":               l.Rows(),
+		"player":              l.Player,
+		"guards":              guards,
+		"exit_ladders":        l.ExitLadders,
+		"enemy_assisted_gold": assisted,
+		"solvable":            l.Solvable,
+	})
+}
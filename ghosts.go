@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ghost"
+	"github.com/jgbrwn/loderunner2099/internal/replay"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// ghostMaxAge is how long a level's ghost may be cached. A new record
+// replaces it only once its replay is verified, so a few minutes' delay
+// doesn't matter.
+const ghostMaxAge = 5 * time.Minute
+
+// ghostsAPI serves GET /api/ghosts: the trace of the record holder's
+// player on a level of a seed, for clients to race. A score's replay is
+// traced the first time one of its levels is asked for, and every level
+// it reached is stored.
+type ghostsAPI struct {
+	db     *storage.DB
+	traces singleflight.Group // by score ID
+}
+
+func (a *ghostsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/ghosts", a.show)
+}
+
+// show returns the ghost of the best verified run of seed and difficulty
+// that got past the level.
+func (a *ghostsAPI) show(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	seed := strings.TrimSpace(q.Get("seed"))
+	diff := strings.ToLower(strings.TrimSpace(q.Get("difficulty")))
+	if diff == "" {
+		diff = "normal"
+	}
+	switch {
+	case seed == "":
+		writeError(w, http.StatusBadRequest, "seed is required")
+		return
+	case len(seed) > maxSeedLength:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("seed must be at most %d characters", maxSeedLength))
+		return
+	case !difficulties[diff]:
+		writeError(w, http.StatusBadRequest, "difficulty must be easy, normal, hard or ninja")
+		return
+	}
+	n, err := queryInt(r, "level", 1, 1, maxLevel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	top, _, err := a.db.TopScores(r.Context(), storage.ScoreFilter{Seed: seed, Difficulty: diff, MinLevel: n + 1, Verified: true}, 1, 0)
+	if err != nil {
+		a.fail(w, "find record", err)
+		return
+	}
+	if len(top) == 0 {
+		writeError(w, http.StatusNotFound, "no verified run has cleared this level")
+		return
+	}
+	entry := top[0]
+	g, err := a.ghost(r.Context(), entry, n)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "the record's replay doesn't reach this level")
+		return
+	}
+	if err != nil {
+		a.fail(w, "trace replay", err)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ghostMaxAge.Seconds())))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"score":   entry,
+		"level":   n,
+		"cleared": g.Cleared,
+		"ticks":   g.Ticks,
+		"format":  g.Format,
+		"trace":   g.Data,
+		"size":    len(g.Data),
+	})
+}
+
+// ghost returns the score's trace of level n, tracing its replay first if
+// that hasn't been done. It returns storage.ErrNotFound if the trace never
+// reached the level.
+func (a *ghostsAPI) ghost(ctx context.Context, entry storage.Score, n int) (storage.Ghost, error) {
+	g, err := a.db.Ghost(ctx, entry.ID, n)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return g, err
+	}
+	// Shared by every request waiting on the trace, so one giving up
+	// doesn't fail the others.
+	ctx = context.WithoutCancel(ctx)
+	_, err, _ = a.traces.Do(strconv.FormatInt(entry.ID, 10), func() (any, error) {
+		if traced, err := a.db.HasGhosts(ctx, entry.ID); err != nil || traced {
+			return nil, err
+		}
+		return nil, a.trace(ctx, entry)
+	})
+	if err != nil {
+		return storage.Ghost{}, err
+	}
+	return a.db.Ghost(ctx, entry.ID, n)
+}
+
+// trace plays the score's replay and stores the trace of each level.
+func (a *ghostsAPI) trace(ctx context.Context, entry storage.Score) error {
+	rp, err := a.db.GetReplay(ctx, entry.ID)
+	if err != nil {
+		return err
+	}
+	frames, err := replay.Decode(rp.Format, rp.Data, maxReplayInflated)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	levels := ghost.Trace(entry.Seed, entry.Difficulty, frames, rp.Ticks)
+	ghosts := make([]storage.Ghost, 0, len(levels))
+	for _, l := range levels {
+		ghosts = append(ghosts, storage.Ghost{
+			ScoreID: entry.ID,
+			Level:   l.Number,
+			Format:  ghost.Format1,
+			Cleared: l.Cleared,
+			Ticks:   l.Ticks,
+			Data:    ghost.Encode(l.Samples),
+		})
+	}
+	log.Printf("ghosts: traced score %d, %d levels in %s", entry.ID, len(levels), time.Since(start).Round(time.Millisecond))
+	return a.db.SaveGhosts(ctx, ghosts)
+}
+
+func (a *ghostsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("ghosts: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
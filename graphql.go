@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/graphql"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// graphQLAPI serves /api/graphql for the community site: levels, scores
+// and their creators to read, and rating and friending for signed-in
+// players. It reads the same storage as the JSON API, and refuses queries
+// deeper or costlier than -graphql-max-depth and -graphql-max-complexity
+// before running them.
+type graphQLAPI struct {
+	db     *storage.DB
+	schema *graphql.Schema
+	limits graphql.Limits
+}
+
+func newGraphQLAPI(db *storage.DB, cfg config) *graphQLAPI {
+	a := &graphQLAPI{db: db, limits: graphql.Limits{MaxDepth: cfg.GraphQLMaxDepth, MaxComplexity: cfg.GraphQLMaxComplexity}}
+	schema, err := graphql.NewSchema(a.types())
+	if err != nil {
+		panic(err)
+	}
+	a.schema = schema
+	return a
+}
+
+func (a *graphQLAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/graphql", a.get)
+	mux.HandleFunc("POST /api/graphql", a.post)
+}
+
+// gqlRequest is what resolvers share while a request runs: the signed-in
+// player and the accounts already looked up.
+type gqlRequest struct {
+	player  *storage.Player
+	players map[int64]*storage.Player
+}
+
+type gqlRequestKey struct{}
+
+func gqlRequestFrom(ctx context.Context) *gqlRequest {
+	return ctx.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// get runs a query from the query, operationName and variables
+// parameters. GET can't run mutations, so a link can't rate or befriend
+// anything on a player's behalf.
+func (a *graphQLAPI) get(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := graphql.Request{Query: q.Get("query"), OperationName: q.Get("operationName")}
+	if v := q.Get("variables"); v != "" {
+		if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+			writeError(w, http.StatusBadRequest, "variables must be a JSON object")
+			return
+		}
+	}
+	a.run(w, r, req)
+}
+
+// post runs a query or mutation from a JSON body. A cross-site form can't
+// send JSON, so another site can't use a player's session cookie here.
+func (a *graphQLAPI) post(w http.ResponseWriter, r *http.Request) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return
+	}
+	var req graphql.Request
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	a.run(w, r, req)
+}
+
+// run checks req against the schema and limits and runs it. Requests that
+// can't run get a 400 with the GraphQL error; resolver errors come back
+// with the data, as GraphQL clients expect.
+func (a *graphQLAPI) run(w http.ResponseWriter, r *http.Request, req graphql.Request) {
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+	op, gerr := a.schema.Prepare(req, a.limits)
+	if gerr != nil {
+		writeJSON(w, http.StatusBadRequest, &graphql.Response{Errors: []*graphql.Error{gerr}})
+		return
+	}
+	if op.Mutation() && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "mutations need a POST request")
+		return
+	}
+	ctx := context.WithValue(r.Context(), gqlRequestKey{}, &gqlRequest{player: currentPlayer(r), players: map[int64]*storage.Player{}})
+	writeJSON(w, http.StatusOK, op.Execute(ctx))
+}
+
+// fail logs an unexpected error and hides it from the client.
+func (a *graphQLAPI) fail(op string, err error) error {
+	log.Printf("graphql: %s: %v", op, err)
+	return errors.New("internal error")
+}
+
+// player returns the account with this ID, or nil if it's gone, looking
+// each up once per request.
+func (a *graphQLAPI) player(ctx context.Context, id int64) (any, error) {
+	if id == 0 {
+		return nil, nil
+	}
+	req := gqlRequestFrom(ctx)
+	p, ok := req.players[id]
+	if !ok {
+		found, err := a.db.GetPlayer(ctx, id)
+		switch {
+		case err == nil:
+			p = &found
+		case !errors.Is(err, storage.ErrNotFound):
+			return nil, a.fail("get player", err)
+		}
+		req.players[id] = p
+	}
+	if p == nil {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// gqlID parses an ID argument.
+func gqlID(args graphql.Args, name string) (int64, error) {
+	id, err := strconv.ParseInt(args.String(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return id, nil
+}
+
+// gqlPage checks a listing's limit and offset.
+func gqlPage(args graphql.Args) (int, int, error) {
+	limit, offset := args.Int("limit"), args.Int("offset")
+	if limit < 1 || limit > maxPageSize {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	if offset < 0 {
+		return 0, 0, errors.New("offset must not be negative")
+	}
+	return limit, offset, nil
+}
+
+// gqlField is a field read straight from its parent's Go value.
+func gqlField[T any](name string, t graphql.Type, description string, get func(T) any) *graphql.Field {
+	return &graphql.Field{
+		Name:        name,
+		Description: description,
+		Type:        t,
+		Resolve: func(_ context.Context, source any, _ graphql.Args) (any, error) {
+			return get(source.(T)), nil
+		},
+	}
+}
+
+// gqlEnum maps lower-case values held in storage to a GraphQL enum.
+func gqlEnum(name, description string, values ...string) *graphql.Enum {
+	e := &graphql.Enum{Name: name, Description: description}
+	for _, v := range values {
+		e.Values = append(e.Values, graphql.EnumValue{Name: strings.ToUpper(v)})
+	}
+	return e
+}
+
+// optionalString returns s, or nil when it's empty.
+func optionalString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var gqlTime = &graphql.Scalar{
+	Name:        "Time",
+	Description: "An RFC 3339 timestamp in UTC.",
+	Serialize: func(v any) (any, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("Time cannot represent %v", v)
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	},
+}
+
+// types builds the schema's query and mutation roots.
+func (a *graphQLAPI) types() (query, mutation *graphql.Object) {
+	nn := graphql.NewNonNull
+	levelSort := gqlEnum("LevelSort", "How levels are ordered.", "newest", "plays", "rating", "featured")
+	difficulty := gqlEnum("Difficulty", "A run's difficulty.", "easy", "normal", "hard", "ninja")
+	status := gqlEnum("ScoreStatus", "Whether a score's replay has been checked.",
+		storage.StatusUnverified, storage.StatusPending, storage.StatusVerified, storage.StatusRejected)
+
+	level := &graphql.Object{Name: "Level", Description: "A community level."}
+	levelPage := &graphql.Object{Name: "LevelPage", Description: "A page of levels."}
+	score := &graphql.Object{Name: "Score", Description: "A leaderboard entry."}
+	scorePage := &graphql.Object{Name: "ScorePage", Description: "A page of scores, best first."}
+	player := &graphql.Object{Name: "Player", Description: "A registered account."}
+	friend := &graphql.Object{Name: "Friend", Description: "A player on the signed-in player's friends list."}
+	rating := &graphql.Object{Name: "LevelRating", Description: "A level's rating after a vote."}
+
+	page := func(limit int) []*graphql.Arg {
+		return []*graphql.Arg{
+			{Name: "limit", Description: fmt.Sprintf("1 to %d.", maxPageSize), Type: graphql.Int, Default: limit},
+			{Name: "offset", Type: graphql.Int, Default: 0},
+		}
+	}
+	levelArgs := append([]*graphql.Arg{
+		{Name: "sort", Type: levelSort, Default: "NEWEST"},
+	}, page(20)...)
+	scoreArgs := append([]*graphql.Arg{
+		{Name: "difficulty", Type: difficulty},
+		{Name: "verified", Description: "Only scores with a verified replay.", Type: graphql.Boolean, Default: false},
+	}, page(10)...)
+
+	level.Fields = []*graphql.Field{
+		gqlField("id", nn(graphql.ID), "", func(l storage.Level) any { return l.ID }),
+		gqlField("title", nn(graphql.String), "", func(l storage.Level) any { return l.Title }),
+		gqlField("author", nn(graphql.String), "The name the creator gave.", func(l storage.Level) any { return l.Author }),
+		gqlField("description", nn(graphql.String), "", func(l storage.Level) any { return l.Description }),
+		{
+			Name:        "tiles",
+			Description: "The level's rows, top first, in the editor's tile legend.",
+			Type:        nn(graphql.NewList(nn(graphql.String))),
+			Cost:        10,
+			Resolve: func(ctx context.Context, source any, _ graphql.Args) (any, error) {
+				l := source.(storage.Level)
+				if len(l.Tiles) > 0 {
+					return l.Tiles, nil
+				}
+				full, err := a.db.GetLevel(ctx, l.ID)
+				if err != nil {
+					return nil, a.fail("get level", err)
+				}
+				return full.Tiles, nil
+			},
+		},
+		gqlField("gold", nn(graphql.Int), "", func(l storage.Level) any { return l.Gold }),
+		gqlField("guards", nn(graphql.Int), "", func(l storage.Level) any { return l.Guards }),
+		gqlField("plays", nn(graphql.Int), "", func(l storage.Level) any { return l.Plays }),
+		gqlField("rating", nn(graphql.Float), "Average stars, 0 when unrated.", func(l storage.Level) any { return l.Rating }),
+		gqlField("ratings", nn(graphql.Int), "Number of votes.", func(l storage.Level) any { return l.Ratings }),
+		gqlField("featured", nn(graphql.Boolean), "Picked by a moderator.", func(l storage.Level) any { return l.Featured }),
+		gqlField("createdAt", nn(gqlTime), "", func(l storage.Level) any { return l.CreatedAt }),
+		{
+			Name:        "creator",
+			Description: "The account that uploaded the level, if it was signed in.",
+			Type:        player,
+			Resolve: func(ctx context.Context, source any, _ graphql.Args) (any, error) {
+				return a.player(ctx, source.(storage.Level).PlayerID)
+			},
+		},
+	}
+	levelPage.Fields = []*graphql.Field{
+		gqlField("total", nn(graphql.Int), "Matches across every page.", func(p gqlLevelPage) any { return p.total }),
+		gqlField("items", nn(graphql.NewList(nn(level))), "", func(p gqlLevelPage) any { return p.levels }),
+	}
+
+	score.Fields = []*graphql.Field{
+		gqlField("id", nn(graphql.ID), "", func(s storage.Score) any { return s.ID }),
+		gqlField("rank", graphql.Int, "Position on the board it was listed from.", func(s storage.Score) any {
+			if s.Rank == 0 {
+				return nil
+			}
+			return s.Rank
+		}),
+		gqlField("player", nn(graphql.String), "The name the player gave.", func(s storage.Score) any { return s.Player }),
+		gqlField("level", nn(graphql.Int), "The classic level reached.", func(s storage.Score) any { return s.Level }),
+		gqlField("difficulty", difficulty, "", func(s storage.Score) any { return optionalString(strings.ToUpper(s.Difficulty)) }),
+		gqlField("seed", graphql.String, "", func(s storage.Score) any { return optionalString(s.Seed) }),
+		gqlField("score", nn(graphql.Int), "", func(s storage.Score) any { return s.Score }),
+		gqlField("time", nn(graphql.Int), "Run duration in milliseconds.", func(s storage.Score) any { return s.Time }),
+		gqlField("replay", nn(graphql.Boolean), "Whether a replay was uploaded.", func(s storage.Score) any { return s.HasReplay }),
+		gqlField("status", nn(status), "", func(s storage.Score) any { return strings.ToUpper(s.Status) }),
+		gqlField("daily", graphql.String, "The UTC date of the daily challenge the run belongs to.", func(s storage.Score) any { return optionalString(s.Daily) }),
+		gqlField("season", graphql.Int, "The ID of the season the score was submitted in.", func(s storage.Score) any {
+			if s.Season == 0 {
+				return nil
+			}
+			return s.Season
+		}),
+		gqlField("createdAt", nn(gqlTime), "", func(s storage.Score) any { return s.CreatedAt }),
+		{
+			Name:        "account",
+			Description: "The account that submitted the run, if it was signed in.",
+			Type:        player,
+			Resolve: func(ctx context.Context, source any, _ graphql.Args) (any, error) {
+				return a.player(ctx, source.(storage.Score).PlayerID)
+			},
+		},
+	}
+	scorePage.Fields = []*graphql.Field{
+		gqlField("total", nn(graphql.Int), "Matches across every page.", func(p gqlScorePage) any { return p.total }),
+		gqlField("items", nn(graphql.NewList(nn(score))), "", func(p gqlScorePage) any { return p.scores }),
+	}
+
+	player.Fields = []*graphql.Field{
+		gqlField("id", nn(graphql.ID), "", func(p *storage.Player) any { return p.ID }),
+		gqlField("displayName", nn(graphql.String), "", func(p *storage.Player) any { return p.DisplayName }),
+		{
+			Name:        "username",
+			Description: "The name the player signs in with; only shown to the player.",
+			Type:        graphql.String,
+			Resolve: func(ctx context.Context, source any, _ graphql.Args) (any, error) {
+				p, me := source.(*storage.Player), gqlRequestFrom(ctx).player
+				if me == nil || me.ID != p.ID {
+					return nil, nil
+				}
+				return p.Username, nil
+			},
+		},
+		gqlField("joinedAt", nn(gqlTime), "", func(p *storage.Player) any { return p.CreatedAt }),
+		{
+			Name:        "levels",
+			Description: "The levels the player uploaded while signed in.",
+			Type:        nn(levelPage),
+			Args:        levelArgs,
+			Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				q, err := a.levelQuery(args)
+				if err != nil {
+					return nil, err
+				}
+				q.PlayerID = source.(*storage.Player).ID
+				return a.levels(ctx, q)
+			},
+		},
+		{
+			Name:        "scores",
+			Description: "The player's best signed-in runs. They carry no rank.",
+			Type:        nn(scorePage),
+			Args:        scoreArgs,
+			Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				f, err := scoreFilter(0, args.String("difficulty"), args.Bool("verified"), 0)
+				if err != nil {
+					return nil, err
+				}
+				f.PlayerID = source.(*storage.Player).ID
+				p, err := a.scores(ctx, f, args)
+				for i := range p.scores {
+					p.scores[i].Rank = 0
+				}
+				return p, err
+			},
+		},
+	}
+
+	friend.Fields = []*graphql.Field{
+		gqlField("name", nn(graphql.String), "", func(f storage.Friend) any { return f.Name }),
+		gqlField("since", nn(gqlTime), "", func(f storage.Friend) any { return f.Since }),
+		{
+			Name: "player",
+			Type: player,
+			Resolve: func(ctx context.Context, source any, _ graphql.Args) (any, error) {
+				return a.player(ctx, source.(storage.Friend).PlayerID)
+			},
+		},
+	}
+	rating.Fields = []*graphql.Field{
+		gqlField("level", nn(level), "", func(r gqlRating) any { return r.level }),
+		gqlField("stars", nn(graphql.Int), "The vote just cast.", func(r gqlRating) any { return r.stars }),
+		gqlField("rating", nn(graphql.Float), "Average stars.", func(r gqlRating) any { return r.avg }),
+		gqlField("ratings", nn(graphql.Int), "Number of votes.", func(r gqlRating) any { return r.count }),
+	}
+
+	query = &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{
+			Name:        "levels",
+			Description: "Published levels.",
+			Type:        nn(levelPage),
+			Args: append([]*graphql.Arg{
+				{Name: "query", Description: "Title or author search.", Type: graphql.String},
+				{Name: "featured", Description: "Only levels moderators picked.", Type: graphql.Boolean, Default: false},
+			}, levelArgs...),
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				q, err := a.levelQuery(args)
+				if err != nil {
+					return nil, err
+				}
+				return a.levels(ctx, q)
+			},
+		},
+		{
+			Name:        "level",
+			Description: "A published level, without counting a play.",
+			Type:        level,
+			Args:        []*graphql.Arg{{Name: "id", Type: nn(graphql.ID)}},
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				id, err := gqlID(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				l, err := a.db.GetLevel(ctx, id)
+				if errors.Is(err, storage.ErrNotFound) || l.Unpublished {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, a.fail("get level", err)
+				}
+				return l, nil
+			},
+		},
+		{
+			Name:        "scores",
+			Description: "The leaderboard, best first.",
+			Type:        nn(scorePage),
+			Args: append([]*graphql.Arg{
+				{Name: "level", Description: "Only this classic level's scores.", Type: graphql.Int},
+				{Name: "season", Description: "Only this season's scores.", Type: graphql.Int},
+			}, scoreArgs...),
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				if l := args.Int("level"); l < 0 || l > maxLevel {
+					return nil, fmt.Errorf("level must be between 1 and %d", maxLevel)
+				}
+				f, err := scoreFilter(args.Int("level"), args.String("difficulty"), args.Bool("verified"), int64(args.Int("season")))
+				if err != nil {
+					return nil, err
+				}
+				return a.scores(ctx, f, args)
+			},
+		},
+		{
+			Name:        "score",
+			Description: "A score with its global rank.",
+			Type:        score,
+			Args:        []*graphql.Arg{{Name: "id", Type: nn(graphql.ID)}},
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				id, err := gqlID(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				s, err := rankedScore(ctx, a.db, id)
+				if errors.Is(err, storage.ErrNotFound) || s.Flagged {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, a.fail("get score", err)
+				}
+				return s, nil
+			},
+		},
+		{
+			Name:        "player",
+			Description: "An account, by its ID.",
+			Type:        player,
+			Args:        []*graphql.Arg{{Name: "id", Type: nn(graphql.ID)}},
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				id, err := gqlID(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return a.player(ctx, id)
+			},
+		},
+		{
+			Name:        "me",
+			Description: "The signed-in player, or null.",
+			Type:        player,
+			Resolve: func(ctx context.Context, _ any, _ graphql.Args) (any, error) {
+				if p := gqlRequestFrom(ctx).player; p != nil {
+					return p, nil
+				}
+				return nil, nil
+			},
+		},
+	}}
+
+	mutation = &graphql.Object{Name: "Mutation", Fields: []*graphql.Field{
+		{
+			Name:        "rateLevel",
+			Description: "Votes 1 to 5 stars for a level, once per account; voting again changes the vote.",
+			Type:        nn(rating),
+			Args:        []*graphql.Arg{{Name: "id", Type: nn(graphql.ID)}, {Name: "stars", Type: nn(graphql.Int)}},
+			Cost:        10,
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				p, err := gqlSignedIn(ctx)
+				if err != nil {
+					return nil, err
+				}
+				id, err := gqlID(args, "id")
+				if err != nil {
+					return nil, err
+				}
+				stars := args.Int("stars")
+				if stars < 1 || stars > 5 {
+					return nil, errors.New("stars must be between 1 and 5")
+				}
+				avg, count, err := a.db.RateLevel(ctx, id, hashToken("player:"+strconv.FormatInt(p.ID, 10)), stars)
+				if errors.Is(err, storage.ErrNotFound) {
+					return nil, errors.New("level not found")
+				}
+				if err != nil {
+					return nil, a.fail("rate level", err)
+				}
+				l, err := a.db.GetLevel(ctx, id)
+				if err != nil {
+					return nil, a.fail("get level", err)
+				}
+				return gqlRating{level: l, stars: stars, avg: avg, count: count}, nil
+			},
+		},
+		{
+			Name:        "addFriend",
+			Description: "Adds the player with this friend code to the signed-in player's friends.",
+			Type:        nn(friend),
+			Args:        []*graphql.Arg{{Name: "code", Type: nn(graphql.String)}},
+			Cost:        10,
+			Resolve: func(ctx context.Context, _ any, args graphql.Args) (any, error) {
+				p, err := gqlSignedIn(ctx)
+				if err != nil {
+					return nil, err
+				}
+				f, _, err := a.db.AddFriend(ctx, p.ID, strings.ToLower(strings.TrimSpace(args.String("code"))), maxFriends)
+				switch {
+				case errors.Is(err, storage.ErrNotFound):
+					return nil, errors.New("friend code not found")
+				case errors.Is(err, storage.ErrOwnFriendCode):
+					return nil, errors.New("that's your own friend code")
+				case errors.Is(err, storage.ErrTooManyFriends):
+					return nil, fmt.Errorf("too many friends (at most %d)", maxFriends)
+				case err != nil:
+					return nil, a.fail("add friend", err)
+				}
+				return f, nil
+			},
+		},
+	}}
+	return query, mutation
+}
+
+type gqlLevelPage struct {
+	levels []storage.Level
+	total  int
+}
+
+type gqlScorePage struct {
+	scores []storage.Score
+	total  int
+}
+
+type gqlRating struct {
+	level storage.Level
+	stars int
+	avg   float64
+	count int64
+}
+
+func gqlSignedIn(ctx context.Context) (*storage.Player, error) {
+	p := gqlRequestFrom(ctx).player
+	if p == nil {
+		return nil, errors.New("sign in required")
+	}
+	return p, nil
+}
+
+func (a *graphQLAPI) levelQuery(args graphql.Args) (storage.LevelQuery, error) {
+	limit, offset, err := gqlPage(args)
+	if err != nil {
+		return storage.LevelQuery{}, err
+	}
+	return levelQuery(args.String("query"), args.String("sort"), args.Bool("featured"), limit, offset)
+}
+
+func (a *graphQLAPI) levels(ctx context.Context, q storage.LevelQuery) (gqlLevelPage, error) {
+	levels, total, err := a.db.ListLevels(ctx, q)
+	if err != nil {
+		return gqlLevelPage{}, a.fail("list levels", err)
+	}
+	return gqlLevelPage{levels: levels, total: total}, nil
+}
+
+func (a *graphQLAPI) scores(ctx context.Context, f storage.ScoreFilter, args graphql.Args) (gqlScorePage, error) {
+	limit, offset, err := gqlPage(args)
+	if err != nil {
+		return gqlScorePage{}, err
+	}
+	scores, total, err := a.db.TopScores(ctx, f, limit, offset)
+	if err != nil {
+		return gqlScorePage{}, a.fail("list scores", err)
+	}
+	return gqlScorePage{scores: scores, total: total}, nil
+}
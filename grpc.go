@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jgbrwn/loderunner2099/internal/gamepb"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// grpcAPI serves the leaderboard, levels and cloud saves over gRPC on
+// -grpc-addr, for the desktop launcher and tooling that would rather have
+// a typed API. It checks and reads the same way as the JSON handlers, on
+// the default site's database. Submitting runs and uploading levels stay
+// JSON-only, behind the content filter, challenges and bans.
+type grpcAPI struct {
+	db          *storage.DB
+	limiter     func() *rateLimiter // the one in use, nil without -rate-limit
+	ipFilter    func() *ipFilter    // the one in use, nil without an address or country list
+	maintenance *maintenance
+	quotas      *quotas
+
+	gamepb.UnimplementedLeaderboardServer
+	gamepb.UnimplementedLevelsServer
+	gamepb.UnimplementedSavesServer
+}
+
+// newGRPCServer returns a gRPC server for api, over TLS with tlsConfig's
+// certificates if it isn't nil. Reflection is on, so grpcurl and the like
+// can find the services without game.proto.
+func newGRPCServer(api *grpcAPI, cfg config, tlsConfig *tls.Config) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(api.recover, api.admit, api.rateLimit),
+		grpc.MaxRecvMsgSize(maxSaveSize + 1<<10),
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		if !cfg.ACME {
+			cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		opts = append(opts, grpc.Creds(grpccreds.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	gamepb.RegisterLeaderboardServer(s, api)
+	gamepb.RegisterLevelsServer(s, api)
+	gamepb.RegisterSavesServer(s, api)
+	reflection.Register(s)
+	return s, nil
+}
+
+// grpcServer lets shutdown drain a gRPC server with the HTTP ones.
+type grpcServer struct {
+	*grpc.Server
+}
+
+func (s grpcServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// recover turns a panicking call into an Internal error, as the HTTP
+// middleware does for requests.
+func (a *grpcAPI) recover(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("grpc: panic in %s: %v\n%s", info.FullMethod, v, debug.Stack())
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// admit refuses calls the HTTP side would: from clients the IP filter
+// denies, and, in maintenance mode, all of them, saying when to retry.
+func (a *grpcAPI) admit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if f := a.ipFilter(); f != nil && !f.allowed(grpcHTTPRequest(ctx, info.FullMethod)) {
+		ipDenied.inc()
+		return nil, status.Error(codes.PermissionDenied, "access denied")
+	}
+	if s := a.maintenance.current(); s.Enabled {
+		msg := s.Message
+		if msg == "" {
+			msg = "down for maintenance"
+		}
+		return nil, status.Errorf(codes.Unavailable, "%s; retry in %s", msg, time.Duration(s.RetryAfter)*time.Second)
+	}
+	return handler(ctx, req)
+}
+
+// rateLimit charges calls to the same buckets as HTTP requests, as POSTs
+// to the method's path, such as /loderunner.v1.Saves/PutSave. The default
+// rules give every method the catch-all limit; -rate-limit-rule can set
+// others.
+func (a *grpcAPI) rateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	limiter := a.limiter()
+	if limiter == nil {
+		return handler(ctx, req)
+	}
+	rule, ok, retry := limiter.allow(grpcHTTPRequest(ctx, info.FullMethod))
+	if !ok {
+		rateLimited.inc(limiter.rules[rule].name)
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limited; retry in %s", retry.Round(time.Second))
+	}
+	return handler(ctx, req)
+}
+
+// grpcHTTPRequest returns a POST to path from the call's peer, with its
+// metadata as headers, for the HTTP side's per-client checks. A trusted
+// proxy's -client-ip-header and -country-header are believed as they are
+// over HTTP.
+func grpcHTTPRequest(ctx context.Context, path string) *http.Request {
+	r := (&http.Request{Method: http.MethodPost, URL: &url.URL{Path: path}, Header: http.Header{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") || strings.HasSuffix(k, "-bin") {
+			continue
+		}
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// allowUpload checks a save against the quotas, counting it against the
+// caller's address as gRPC callers don't sign in, and returns the subject
+// to store it under.
+func (a *grpcAPI) allowUpload(ctx context.Context, u quotaUpload) (string, error) {
+	subject := a.quotas.subject(grpcHTTPRequest(ctx, ""))
+	if err := a.quotas.check(ctx, subject, &u); err != nil {
+		return "", a.fail("check quota", err)
+	}
+	if code, msg := a.quotas.over(&u); code != 0 {
+		quotaExceeded.inc(u.kind)
+		return "", status.Error(codes.ResourceExhausted, msg)
+	}
+	return subject, nil
+}
+
+// fail logs an unexpected error and hides it from the caller.
+func (a *grpcAPI) fail(op string, err error) error {
+	log.Printf("grpc: %s: %v", op, err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// page checks a listing's limit and offset, defaulting the limit to def.
+func page(limit, offset int32, def int) (int, int, error) {
+	switch {
+	case limit == 0:
+		limit = int32(def)
+	case limit < 1 || limit > maxPageSize:
+		return 0, 0, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPageSize)
+	}
+	if offset < 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+	return int(limit), int(offset), nil
+}
+
+func (a *grpcAPI) ListScores(ctx context.Context, req *gamepb.ListScoresRequest) (*gamepb.ListScoresResponse, error) {
+	limit, offset, err := page(req.Limit, req.Offset, 10)
+	if err != nil {
+		return nil, err
+	}
+	if req.Level < 0 || req.Level > maxLevel {
+		return nil, status.Errorf(codes.InvalidArgument, "level must be between 1 and %d", maxLevel)
+	}
+	season := req.Season
+	if req.CurrentSeason {
+		s, err := a.db.CurrentSeason(ctx)
+		if err != nil {
+			return nil, a.fail("current season", err)
+		}
+		season = s.ID
+	}
+	f, err := scoreFilter(int(req.Level), req.Difficulty, req.Verified, season)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	entries, total, err := a.db.TopScores(ctx, f, limit, offset)
+	if err != nil {
+		return nil, a.fail("list scores", err)
+	}
+	resp := &gamepb.ListScoresResponse{Total: int64(total)}
+	for _, s := range entries {
+		resp.Scores = append(resp.Scores, scoreMessage(s))
+	}
+	return resp, nil
+}
+
+func (a *grpcAPI) GetScore(ctx context.Context, req *gamepb.GetScoreRequest) (*gamepb.Score, error) {
+	entry, err := rankedScore(ctx, a.db, req.Id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "score not found")
+	}
+	if err != nil {
+		return nil, a.fail("get score", err)
+	}
+	return scoreMessage(entry), nil
+}
+
+func (a *grpcAPI) ListLevels(ctx context.Context, req *gamepb.ListLevelsRequest) (*gamepb.ListLevelsResponse, error) {
+	limit, offset, err := page(req.Limit, req.Offset, 20)
+	if err != nil {
+		return nil, err
+	}
+	q, err := levelQuery(req.Query, req.Sort, req.Featured, limit, offset)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	levels, total, err := a.db.ListLevels(ctx, q)
+	if err != nil {
+		return nil, a.fail("list levels", err)
+	}
+	resp := &gamepb.ListLevelsResponse{Total: int64(total)}
+	for _, l := range levels {
+		resp.Levels = append(resp.Levels, levelMessage(l))
+	}
+	return resp, nil
+}
+
+func (a *grpcAPI) GetLevel(ctx context.Context, req *gamepb.GetLevelRequest) (*gamepb.Level, error) {
+	l, err := playLevel(ctx, a.db, req.Id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "level not found")
+	}
+	if err != nil {
+		return nil, a.fail("get level", err)
+	}
+	return levelMessage(l), nil
+}
+
+// CreateSave stores an anonymous save: gRPC callers don't sign in.
+func (a *grpcAPI) CreateSave(ctx context.Context, req *gamepb.CreateSaveRequest) (*gamepb.CreateSaveResponse, error) {
+	data, err := compressSave(req.Data)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	subject, err := a.allowUpload(ctx, quotaUpload{kind: storage.QuotaSaves, size: int64(len(data)), counted: true})
+	if err != nil {
+		return nil, err
+	}
+	token := randomToken(24)
+	save, err := a.db.CreateSave(ctx, hashToken(token), 0, subject, data, int64(len(req.Data)))
+	if err != nil {
+		return nil, a.fail("create save", err)
+	}
+	return &gamepb.CreateSaveResponse{Token: token, Version: save.Version, UpdatedAt: timestamppb.New(save.UpdatedAt)}, nil
+}
+
+func (a *grpcAPI) GetSave(ctx context.Context, req *gamepb.GetSaveRequest) (*gamepb.Save, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	save, err := a.db.GetSave(ctx, hashToken(req.Token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "save not found")
+	}
+	if err != nil {
+		return nil, a.fail("get save", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(save.Data))
+	if err != nil {
+		return nil, a.fail("decompress save", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, a.fail("decompress save", err)
+	}
+	return &gamepb.Save{Version: save.Version, Data: data, UpdatedAt: timestamppb.New(save.UpdatedAt)}, nil
+}
+
+func (a *grpcAPI) PutSave(ctx context.Context, req *gamepb.PutSaveRequest) (*gamepb.SaveVersion, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if req.IfVersion < 0 {
+		return nil, status.Error(codes.InvalidArgument, "if_version must not be negative")
+	}
+	data, err := compressSave(req.Data)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	up := quotaUpload{kind: storage.QuotaSaves, size: int64(len(data))}
+	if a.quotas.storage > 0 {
+		old, err := a.db.GetSave(ctx, hashToken(req.Token))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, a.fail("get save", err)
+		}
+		up.freed, up.owner = int64(len(old.Data)), old.QuotaSubject
+	}
+	subject, err := a.allowUpload(ctx, up)
+	if err != nil {
+		return nil, err
+	}
+	save, err := a.db.PutSave(ctx, hashToken(req.Token), subject, data, int64(len(req.Data)), req.IfVersion)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, status.Error(codes.NotFound, "save not found")
+	case errors.Is(err, storage.ErrVersionConflict):
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("save was changed on another device; it is at version %d", save.Version))
+	case err != nil:
+		return nil, a.fail("put save", err)
+	}
+	return &gamepb.SaveVersion{Version: save.Version, UpdatedAt: timestamppb.New(save.UpdatedAt)}, nil
+}
+
+func scoreMessage(s storage.Score) *gamepb.Score {
+	return &gamepb.Score{
+		Id:         s.ID,
+		Rank:       int32(s.Rank),
+		Player:     s.Player,
+		Level:      int32(s.Level),
+		Difficulty: s.Difficulty,
+		Seed:       s.Seed,
+		Score:      s.Score,
+		Time:       s.Time,
+		Replay:     s.HasReplay,
+		Status:     s.Status,
+		Daily:      s.Daily,
+		Season:     s.Season,
+		CreatedAt:  timestamppb.New(s.CreatedAt),
+	}
+}
+
+func levelMessage(l storage.Level) *gamepb.Level {
+	return &gamepb.Level{
+		Id:          l.ID,
+		Title:       l.Title,
+		Author:      l.Author,
+		Description: l.Description,
+		Tiles:       l.Tiles,
+		Gold:        int32(l.Gold),
+		Guards:      int32(l.Guards),
+		Plays:       l.Plays,
+		Rating:      l.Rating,
+		Ratings:     l.Ratings,
+		Featured:    l.Featured,
+		CreatedAt:   timestamppb.New(l.CreatedAt),
+	}
+}
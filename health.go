@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds how long a single /readyz probe may take.
+const readinessTimeout = 2 * time.Second
+
+// health serves the liveness and readiness probes. Subsystems with external
+// dependencies register a readiness check when they start.
+type health struct {
+	draining atomic.Bool
+
+	mu     sync.Mutex
+	checks []readinessCheck
+}
+
+type readinessCheck struct {
+	name string
+	fn   func(context.Context) error
+}
+
+func (h *health) addCheck(name string, fn func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, readinessCheck{name, fn})
+}
+
+// liveness reports that the process is up and serving HTTP.
+func (h *health) liveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// readiness runs every registered check and returns 503 if any fails or the
+// server is draining, so load balancers stop routing to it.
+func (h *health) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	h.mu.Lock()
+	checks := append([]readinessCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	if h.draining.Load() {
+		status = http.StatusServiceUnavailable
+		results["shutdown"] = "draining"
+	}
+	for _, c := range checks {
+		if err := c.fn(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[c.name] = err.Error()
+			continue
+		}
+		results[c.name] = "ok"
+	}
+
+	body := map[string]any{"status": "ok", "checks": results}
+	if status != http.StatusOK {
+		body["status"] = "unavailable"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// distReadable checks that the game's entry point can actually be read.
+func distReadable(fsys fs.FS) func(context.Context) error {
+	return func(context.Context) error {
+		f, err := fsys.Open("index.html")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := f.Read(make([]byte, 1)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("index.html is empty")
+			}
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server on the UDP side of srv's address
+// with the same handler, certificates and limits. HTTP/3 is TLS 1.3 only, so
+// tlsConfig's 1.2 cipher list doesn't apply.
+func newHTTP3Server(srv *http.Server, certFile, keyFile string) (*http3.Server, error) {
+	tc := srv.TLSConfig.Clone()
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return &http3.Server{
+		Addr:           srv.Addr,
+		Handler:        srv.Handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tc),
+		IdleTimeout:    srv.IdleTimeout,
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+	}, nil
+}
+
+// advertiseHTTP3 adds Alt-Svc to responses sent over TCP so browsers try
+// QUIC on their next request and fall back silently when UDP is blocked.
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maxImportBody bounds an uploaded level pack: a few hundred levels in
+// either format.
+const maxImportBody = 1 << 20
+
+// levelImport says how to title and credit a pack's levels.
+type levelImport struct {
+	format level.Format // detected if empty
+	pack   string       // titles levels the pack doesn't name: "Classic 001"
+	author string
+	dryRun bool // validate without storing
+}
+
+// importedLevel is the outcome for one level of a pack.
+type importedLevel struct {
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	ID    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importLevels converts a classic level pack and stores every level that
+// passes the same checks as an upload, reporting each one. The error is
+// for the pack as a whole, or the database; levels stored before it stay.
+func importLevels(ctx context.Context, db *storage.DB, data []byte, opt levelImport) ([]importedLevel, error) {
+	if opt.format == "" {
+		opt.format = level.DetectFormat(data)
+	}
+	pack, err := level.ReadPack(data, opt.format)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]importedLevel, 0, len(pack))
+	for _, pl := range pack {
+		up := levelUpload{Title: pl.Name, Author: opt.author, Width: level.Width, Height: level.Height, Tiles: pl.Rows}
+		if up.Title == "" {
+			up.Title = fmt.Sprintf("%s %03d", opt.pack, pl.Index)
+		}
+		res := importedLevel{Index: pl.Index, Title: up.Title}
+		g, err := up.validate()
+		if pl.Err != nil {
+			err = pl.Err
+		}
+		if err != nil {
+			res.Error = err.Error()
+			out = append(out, res)
+			continue
+		}
+		if !opt.dryRun {
+			l, err := db.CreateLevel(ctx, storage.Level{
+				Title:       up.Title,
+				Author:      up.Author,
+				Description: up.Description,
+				Tiles:       g.Rows(),
+				Gold:        g.Count(level.Gold),
+				Guards:      g.Count(level.Guard),
+			})
+			if err != nil {
+				return out, err
+			}
+			res.ID = l.ID
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// runImport adds the levels of classic level packs to the database,
+// reporting each level, and exits non-zero if any failed.
+func runImport() {
+	format := flag.String("format", "", "level pack format, text or dat; detected from each file if empty")
+	author := flag.String("author", "Classic", "author to credit the imported levels to")
+	pack := flag.String("pack", "", "name to title levels the pack doesn't name with, as \"NAME 001\"; defaults to the file name")
+	dryRun := flag.Bool("dry-run", false, "validate the levels without storing them")
+	cfg := loadConfig()
+	if flag.NArg() == 0 {
+		log.Fatal("import: no level packs given")
+	}
+	if cfg.DBPath == "" && !*dryRun {
+		log.Fatal("import: no database configured (-db)")
+	}
+	var db *storage.DB
+	if cfg.DBPath != "" {
+		var err error
+		if db, err = storage.Open(cfg.DBPath, cfg.DBDriver); err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Migrate(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := 0
+	for _, name := range flag.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opt := levelImport{format: level.Format(*format), pack: *pack, author: *author, dryRun: *dryRun}
+		if opt.pack == "" {
+			opt.pack = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		}
+		results, err := importLevels(context.Background(), db, data, opt)
+		for _, res := range results {
+			switch {
+			case res.Error != "":
+				failed++
+				fmt.Printf("✗ %s #%d %s: %s\n", name, res.Index, res.Title, res.Error)
+			case res.ID != 0:
+				fmt.Printf("✓ %s #%d %s: level %d\n", name, res.Index, res.Title, res.ID)
+			default:
+				fmt.Printf("✓ %s #%d %s\n", name, res.Index, res.Title)
+			}
+		}
+		if err != nil {
+			log.Fatalf("import: %s: %v", name, err)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
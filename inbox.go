@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxInboxTitle = 100
+	maxInboxBody  = 2000
+	maxRewardCode = 64
+)
+
+// inboxAPI serves /api/inbox: the signed-in player's messages from the
+// moderators, who post them through the moderation API.
+type inboxAPI struct {
+	db *storage.DB
+}
+
+func (a *inboxAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/inbox", a.list)
+	mux.HandleFunc("POST /api/inbox/read", a.readAll)
+	mux.HandleFunc("POST /api/inbox/{id}/read", a.read)
+}
+
+// list returns a page of the player's inbox, newest first, with the
+// number of unread messages; ?unread=true for only those.
+func (a *inboxAPI) list(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	limit, err := queryInt(r, "limit", 20, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1_000_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	messages, total, unread, err := a.db.Inbox(r.Context(), p.ID, time.Now(), unreadOnly, limit, offset)
+	if err != nil {
+		a.fail(w, "inbox", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"messages": messages, "unread": unread, "total": total, "limit": limit, "offset": offset})
+}
+
+func (a *inboxAPI) read(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	switch err := a.db.MarkInboxRead(r.Context(), p.ID, id, time.Now()); {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "message not found")
+	case err != nil:
+		a.fail(w, "mark read", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *inboxAPI) readAll(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.db.MarkInboxAllRead(r.Context(), p.ID, time.Now()); err != nil {
+		a.fail(w, "mark all read", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *inboxAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("inbox: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// inboxMessages lists every inbox message, expired ones included, with
+// how many players have read each.
+func (a *adminAPI) inboxMessages(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 50, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1_000_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	messages, total, err := a.db.InboxMessages(r.Context(), limit, offset)
+	if err != nil {
+		a.fail(w, "inbox messages", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"messages": messages, "total": total, "limit": limit, "offset": offset})
+}
+
+// postInboxMessage puts a message in everyone's inbox, or one player's,
+// from a body like {"title": "...", "body": "...", "reward_code": "..."}.
+func (a *adminAPI) postInboxMessage(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Title      string     `json:"title"`
+		Body       string     `json:"body"`
+		RewardCode string     `json:"reward_code"`
+		PlayerID   int64      `json:"player_id"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	body.Title, body.RewardCode = strings.TrimSpace(body.Title), strings.TrimSpace(body.RewardCode)
+	body.Body = strings.TrimSpace(strings.ReplaceAll(body.Body, "\r\n", "\n"))
+	for _, err := range []error{
+		checkText("title", body.Title, maxInboxTitle, true),
+		// The body may run to several paragraphs.
+		checkText("body", strings.ReplaceAll(body.Body, "\n", " "), maxInboxBody, true),
+		checkText("reward_code", body.RewardCode, maxRewardCode, false),
+	} {
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	switch {
+	case body.PlayerID < 0:
+		writeError(w, http.StatusUnprocessableEntity, "invalid player_id")
+		return
+	case body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()):
+		writeError(w, http.StatusUnprocessableEntity, "expires_at must be in the future")
+		return
+	}
+	if body.ExpiresAt != nil {
+		t := body.ExpiresAt.UTC()
+		body.ExpiresAt = &t
+	}
+	m, err := a.db.CreateInboxMessage(r.Context(), storage.InboxMessage{
+		Title: body.Title, Body: body.Body, RewardCode: body.RewardCode,
+		PlayerID: body.PlayerID, ExpiresAt: body.ExpiresAt, CreatedBy: actor,
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusUnprocessableEntity, "no such player")
+		return
+	}
+	if err != nil {
+		a.fail(w, "post inbox message", err)
+		return
+	}
+	id := strconv.FormatInt(m.ID, 10)
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "inbox.post", TargetKind: "message", TargetID: id, Detail: m.Title, After: auditState(m)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: inbox.post message %s", actor, id)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+// deleteInboxMessage takes a message out of every inbox.
+func (a *adminAPI) deleteInboxMessage(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.DeleteInboxMessage(r.Context(), id), "message not found") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "inbox.delete", TargetKind: "message", TargetID: strconv.FormatInt(id, 10), Detail: reason})
+}
@@ -0,0 +1,142 @@
+// Package achievement defines the game's achievements and turns the events
+// clients report into per-player stats that unlock them.
+//
+// Events are client-reported, so the rules only bound what a single report
+// can claim; achievements are for fun, not ranking.
+package achievement
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stats a player accumulates. Counters add up across events; maxima keep
+// the highest value seen.
+const (
+	StatGold          = "gold_collected"
+	StatGuardsTrapped = "guards_trapped"
+	StatLevelsCleared = "levels_cleared"
+	StatHighestLevel  = "highest_level"
+	StatNinjaClears   = "ninja_clears"
+	StatDeaths        = "deaths"
+	StatDailyClears   = "daily_clears"
+)
+
+// maxima are the stats that keep their highest value rather than a sum.
+var maxima = map[string]bool{StatHighestLevel: true}
+
+// IsMax reports whether stat keeps its highest value rather than a sum.
+func IsMax(stat string) bool {
+	return maxima[stat]
+}
+
+// Achievement is unlocked once Stat reaches Goal. Hidden achievements keep
+// their name and description secret until unlocked.
+type Achievement struct {
+	ID          string
+	Name        string
+	Description string
+	Stat        string
+	Goal        int64
+	Hidden      bool
+}
+
+// All is the registry, in the order the trophy screen shows them. IDs are
+// stored with unlocks, so never rename one.
+var All = []Achievement{
+	{ID: "first_gold", Name: "Prospector", Description: "Collect your first gold.", Stat: StatGold, Goal: 1},
+	{ID: "gold_100", Name: "Gold Rush", Description: "Collect 100 gold.", Stat: StatGold, Goal: 100},
+	{ID: "gold_1000", Name: "Bullion", Description: "Collect 1,000 gold.", Stat: StatGold, Goal: 1000},
+	{ID: "first_trap", Name: "Pitfall", Description: "Trap a guard in a dug brick.", Stat: StatGuardsTrapped, Goal: 1},
+	{ID: "trap_100", Name: "Hole Digger", Description: "Trap 100 guards.", Stat: StatGuardsTrapped, Goal: 100},
+	{ID: "first_clear", Name: "Way Out", Description: "Clear a level.", Stat: StatLevelsCleared, Goal: 1},
+	{ID: "clear_100", Name: "Veteran", Description: "Clear 100 levels.", Stat: StatLevelsCleared, Goal: 100},
+	{ID: "level_10", Name: "Going Deeper", Description: "Reach level 10.", Stat: StatHighestLevel, Goal: 10},
+	{ID: "level_50", Name: "Deep Runner", Description: "Reach level 50.", Stat: StatHighestLevel, Goal: 50},
+	{ID: "level_150", Name: "Bottom of the Mine", Description: "Reach level 150.", Stat: StatHighestLevel, Goal: 150},
+	{ID: "ninja", Name: "Shadow", Description: "Clear a level on ninja difficulty.", Stat: StatNinjaClears, Goal: 1},
+	{ID: "daily", Name: "Daily Grind", Description: "Finish a daily challenge.", Stat: StatDailyClears, Goal: 1},
+	{ID: "daily_30", Name: "Regular", Description: "Finish 30 daily challenges.", Stat: StatDailyClears, Goal: 30},
+	{ID: "deaths_100", Name: "Persistent", Description: "Die 100 times and keep going.", Stat: StatDeaths, Goal: 100, Hidden: true},
+}
+
+// Reached returns the IDs of every achievement whose goal stats meet.
+func Reached(stats map[string]int64) []string {
+	var ids []string
+	for _, a := range All {
+		if stats[a.Stat] >= a.Goal {
+			ids = append(ids, a.ID)
+		}
+	}
+	return ids
+}
+
+// Event is something that happened in a game. Count is used by gold,
+// guard_trapped and death (default 1); Level and Difficulty by
+// level_complete.
+type Event struct {
+	Type       string `json:"type"`
+	Count      int64  `json:"count"`
+	Level      int64  `json:"level"`
+	Difficulty string `json:"difficulty"`
+	Daily      bool   `json:"daily"`
+}
+
+// Limits on what one event may claim.
+const (
+	MaxCount = 500
+	MaxLevel = 9999
+)
+
+// Apply folds events into stat updates: deltas for counters and new
+// candidate values for maxima. It rejects the whole batch if any event is
+// invalid.
+func Apply(events []Event) (map[string]int64, error) {
+	updates := make(map[string]int64)
+	add := func(stat string, n int64) {
+		if IsMax(stat) {
+			updates[stat] = max(updates[stat], n)
+		} else {
+			updates[stat] += n
+		}
+	}
+	var problems []string
+	for i, e := range events {
+		count := e.Count
+		if count == 0 {
+			count = 1
+		}
+		if count < 0 || count > MaxCount {
+			problems = append(problems, fmt.Sprintf("event %d: count must be between 1 and %d", i, MaxCount))
+			continue
+		}
+		switch e.Type {
+		case "gold":
+			add(StatGold, count)
+		case "guard_trapped":
+			add(StatGuardsTrapped, count)
+		case "death":
+			add(StatDeaths, count)
+		case "level_complete":
+			if e.Level < 1 || e.Level > MaxLevel {
+				problems = append(problems, fmt.Sprintf("event %d: level must be between 1 and %d", i, MaxLevel))
+				continue
+			}
+			add(StatLevelsCleared, 1)
+			add(StatHighestLevel, e.Level)
+			if strings.EqualFold(e.Difficulty, "ninja") {
+				add(StatNinjaClears, 1)
+			}
+			if e.Daily {
+				add(StatDailyClears, 1)
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("event %d: unknown type %q", i, e.Type))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return updates, nil
+}
@@ -0,0 +1,240 @@
+// Package analytics validates the gameplay events clients report for the
+// game's own analytics and sums them up for the admin dashboard.
+//
+// Events are anonymous: a session is a random ID the client makes up per
+// page load, not a player.
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event types.
+const (
+	LevelStart    = "level_start"
+	LevelComplete = "level_complete"
+	DeathCause    = "death_cause"
+	FPSBucket     = "fps_bucket"
+)
+
+// Types lists the event types, in the order summaries show them.
+var Types = []string{LevelStart, LevelComplete, DeathCause, FPSBucket}
+
+// Causes are what a death_cause event may blame.
+var Causes = []string{"guard", "brick", "restart"}
+
+// Buckets are the frame rate ranges an fps_bucket event reports, so
+// clients don't send a sample per frame.
+var Buckets = []string{"0-19", "20-29", "30-44", "45-59", "60+"}
+
+// Difficulties are the game's difficulty settings.
+var Difficulties = []string{"easy", "normal", "hard", "ninja"}
+
+// Limits on what one event may claim.
+const (
+	MaxLevel      = 9999
+	MaxDuration   = 24 * time.Hour
+	MaxSessionLen = 64
+)
+
+// Event is one thing that happened in a game. Level is used by every type
+// but fps_bucket; DurationMS only by level_complete. Time is when the
+// server received it, not the client's clock.
+type Event struct {
+	Type       string    `json:"type"`
+	Session    string    `json:"session"`
+	Level      int64     `json:"level,omitempty"`
+	Difficulty string    `json:"difficulty,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Cause      string    `json:"cause,omitempty"`
+	Bucket     string    `json:"bucket,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Clean validates events and returns them stamped with at, keeping only
+// the fields their type uses. It rejects the whole batch if any event is
+// invalid.
+func Clean(events []Event, at time.Time) ([]Event, error) {
+	out := make([]Event, 0, len(events))
+	var problems []string
+	for i, e := range events {
+		c, err := clean(e)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("event %d: %v", i, err))
+			continue
+		}
+		c.Time = at
+		out = append(out, c)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return out, nil
+}
+
+func clean(e Event) (Event, error) {
+	if !slices.Contains(Types, e.Type) {
+		return Event{}, fmt.Errorf("unknown type %q", e.Type)
+	}
+	if !validSession(e.Session) {
+		return Event{}, fmt.Errorf("session must be 1-%d letters, digits, - or _", MaxSessionLen)
+	}
+	c := Event{Type: e.Type, Session: e.Session}
+	if e.Type != FPSBucket {
+		if e.Level < 1 || e.Level > MaxLevel {
+			return Event{}, fmt.Errorf("level must be between 1 and %d", MaxLevel)
+		}
+		if e.Difficulty != "" && !slices.Contains(Difficulties, e.Difficulty) {
+			return Event{}, fmt.Errorf("difficulty must be one of %s", strings.Join(Difficulties, ", "))
+		}
+		c.Level, c.Difficulty = e.Level, e.Difficulty
+	}
+	switch e.Type {
+	case LevelStart:
+	case LevelComplete:
+		if e.DurationMS < 1 || e.DurationMS > MaxDuration.Milliseconds() {
+			return Event{}, fmt.Errorf("duration_ms must be between 1 and %d", MaxDuration.Milliseconds())
+		}
+		c.DurationMS = e.DurationMS
+	case DeathCause:
+		if !slices.Contains(Causes, e.Cause) {
+			return Event{}, fmt.Errorf("cause must be one of %s", strings.Join(Causes, ", "))
+		}
+		c.Cause = e.Cause
+	case FPSBucket:
+		if !slices.Contains(Buckets, e.Bucket) {
+			return Event{}, fmt.Errorf("bucket must be one of %s", strings.Join(Buckets, ", "))
+		}
+		c.Bucket = e.Bucket
+	}
+	return c, nil
+}
+
+func validSession(s string) bool {
+	if s == "" || len(s) > MaxSessionLen {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary is what the dashboard shows for a period.
+type Summary struct {
+	Since    time.Time        `json:"since"`
+	Until    time.Time        `json:"until"`
+	Events   map[string]int64 `json:"events"` // by type
+	Sessions int64            `json:"sessions"`
+	Levels   []LevelSummary   `json:"levels"`
+	Deaths   map[string]int64 `json:"deaths"` // by cause
+	FPS      map[string]int64 `json:"fps"`    // by bucket
+}
+
+// LevelSummary is how one level fared. Only completions count towards
+// the average duration.
+type LevelSummary struct {
+	Level         int64 `json:"level"`
+	Starts        int64 `json:"starts"`
+	Completes     int64 `json:"completes"`
+	Deaths        int64 `json:"deaths"`
+	AvgDurationMS int64 `json:"avg_duration_ms"`
+}
+
+// Tally adds up events into a Summary. Every store summarizes through it,
+// so the numbers mean the same whichever one events were written to.
+type Tally struct {
+	since, until time.Time
+	events       map[string]int64
+	sessions     map[string]struct{}
+	levels       map[int64]*level
+	deaths       map[string]int64
+	fps          map[string]int64
+}
+
+type level struct {
+	LevelSummary
+	totalMS int64
+}
+
+// NewTally counts the events received in [since, until).
+func NewTally(since, until time.Time) *Tally {
+	t := &Tally{
+		since:    since,
+		until:    until,
+		events:   map[string]int64{},
+		sessions: map[string]struct{}{},
+		levels:   map[int64]*level{},
+		deaths:   map[string]int64{},
+		fps:      map[string]int64{},
+	}
+	// Zeros make an empty period look empty rather than broken.
+	for _, k := range Types {
+		t.events[k] = 0
+	}
+	for _, k := range Causes {
+		t.deaths[k] = 0
+	}
+	for _, k := range Buckets {
+		t.fps[k] = 0
+	}
+	return t
+}
+
+// Add counts e if it falls in the tally's period.
+func (t *Tally) Add(e Event) {
+	if e.Time.Before(t.since) || !e.Time.Before(t.until) {
+		return
+	}
+	t.events[e.Type]++
+	t.sessions[e.Session] = struct{}{}
+	switch e.Type {
+	case FPSBucket:
+		t.fps[e.Bucket]++
+		return
+	case DeathCause:
+		t.deaths[e.Cause]++
+	}
+	l := t.levels[e.Level]
+	if l == nil {
+		l = &level{LevelSummary: LevelSummary{Level: e.Level}}
+		t.levels[e.Level] = l
+	}
+	switch e.Type {
+	case LevelStart:
+		l.Starts++
+	case LevelComplete:
+		l.Completes++
+		l.totalMS += e.DurationMS
+	case DeathCause:
+		l.Deaths++
+	}
+}
+
+// Summary returns the totals so far, levels in order.
+func (t *Tally) Summary() Summary {
+	levels := make([]LevelSummary, 0, len(t.levels))
+	for _, l := range t.levels {
+		if l.Completes > 0 {
+			l.AvgDurationMS = l.totalMS / l.Completes
+		}
+		levels = append(levels, l.LevelSummary)
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Level < levels[j].Level })
+	return Summary{
+		Since:    t.since,
+		Until:    t.until,
+		Events:   t.events,
+		Sessions: int64(len(t.sessions)),
+		Levels:   levels,
+		Deaths:   t.deaths,
+		FPS:      t.fps,
+	}
+}
@@ -0,0 +1,151 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// File appends events to a newline-delimited JSON file. Once the file
+// reaches maxBytes it is renamed to path.1, the previous path.1 to path.2
+// and so on, keeping the newest keep rotated files.
+type File struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens path for appending, creating it if need be.
+func OpenFile(path string, maxBytes int64, keep int) (*File, error) {
+	f := &File{path: path, maxBytes: maxBytes, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends events as one write, so a batch is never split between
+// files or interleaved with another.
+func (f *File) Write(events []Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return errors.New("analytics file closed")
+	}
+	if f.size > 0 && f.size+int64(buf.Len()) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("rotate %s: %w", f.path, err)
+		}
+	}
+	n, err := f.f.Write(buf.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+	os.Remove(f.rotated(f.keep))
+	for i := f.keep - 1; i >= 1; i-- {
+		if err := os.Rename(f.rotated(i), f.rotated(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if f.keep > 0 {
+		if err := os.Rename(f.path, f.rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *File) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Summary reads the current and rotated files back, so it only covers as
+// far back as they go.
+func (f *File) Summary(since, until time.Time) (Summary, error) {
+	// Holding the lock keeps a rotation from moving files mid-read.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := NewTally(since, until)
+	for i := f.keep; i >= 0; i-- {
+		path := f.path
+		if i > 0 {
+			path = f.rotated(i)
+		}
+		if err := readEvents(path, t); err != nil {
+			return Summary{}, err
+		}
+	}
+	return t.Summary(), nil
+}
+
+func readEvents(path string, t *Tally) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		var e Event
+		// A line cut short by a crash is skipped, not fatal.
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			t.Add(e)
+		}
+	}
+	return sc.Err()
+}
+
+// Close closes the file; later writes fail.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
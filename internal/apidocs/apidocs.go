@@ -0,0 +1,33 @@
+// Package apidocs holds the game API's OpenAPI description and the page
+// that renders it. The server serves them at /api/docs/.
+//
+// openapi.json is generated from the handlers; regenerate it with go
+// generate after adding or changing an /api route.
+package apidocs
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:generate go run generate.go ../..
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed assets
+var assets embed.FS
+
+// Spec returns the OpenAPI 3 document.
+func Spec() []byte {
+	return spec
+}
+
+// FS returns the viewer: index.html and its script and styles.
+func FS() fs.FS {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,190 @@
+'use strict';
+
+// The API docs page renders openapi.json as a list of operations by tag,
+// each with its parameters, body and responses, and a form that sends the
+// request from this page with the browser's cookies.
+
+let spec = null;
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === 'onclick') node.addEventListener('click', v);
+    else if (k === 'onsubmit') node.addEventListener('submit', v);
+    else node.setAttribute(k, v);
+  }
+  for (const c of children) {
+    if (c !== null && c !== undefined) node.append(c instanceof Node ? c : String(c));
+  }
+  return node;
+}
+
+function resolve(schema) {
+  if (schema && schema.$ref) return spec.components.schemas[schema.$ref.split('/').pop()];
+  return schema || {};
+}
+
+// example turns a schema into a sample value, following references a
+// few levels deep.
+function example(schema, depth = 0) {
+  const name = schema && schema.$ref ? schema.$ref.split('/').pop() : null;
+  schema = resolve(schema);
+  if (schema.allOf) return example(schema.allOf[0], depth);
+  if (depth > 4) return name ? `<${name}>` : null;
+  switch (schema.type) {
+    case 'object': {
+      if (schema.properties) {
+        const out = {};
+        for (const [k, v] of Object.entries(schema.properties)) out[k] = example(v, depth + 1);
+        return out;
+      }
+      if (schema.additionalProperties) return { key: example(schema.additionalProperties, depth + 1) };
+      return {};
+    }
+    case 'array': return [example(schema.items, depth + 1)];
+    case 'integer': return schema.default ?? schema.minimum ?? 0;
+    case 'number': return 0;
+    case 'boolean': return false;
+    case 'string':
+      if (schema.format === 'date-time') return new Date(0).toISOString();
+      return 'string';
+    default: return null;
+  }
+}
+
+function pretty(v) {
+  return JSON.stringify(v, null, 2);
+}
+
+function parameters(op) {
+  const params = op.parameters || [];
+  if (params.length === 0) return null;
+  return el('div', {},
+    el('h3', {}, 'Parameters'),
+    el('table', {},
+      el('thead', {}, el('tr', {}, el('th', {}, 'Name'), el('th', {}, 'In'), el('th', {}, 'Type'))),
+      el('tbody', {}, ...params.map((p) => {
+        const s = p.schema || {};
+        const bits = [s.type || 'any'];
+        if (s.default !== undefined) bits.push(`default ${s.default}`);
+        if (s.minimum !== undefined && s.maximum !== undefined) bits.push(`${s.minimum}–${s.maximum}`);
+        return el('tr', {}, el('td', {}, el('code', {}, p.name), p.required ? ' *' : ''), el('td', {}, p.in), el('td', {}, bits.join(', ')));
+      }))));
+}
+
+function responses(op) {
+  const rows = Object.entries(op.responses).sort(([a], [b]) => a.localeCompare(b));
+  return el('div', {},
+    el('h3', {}, 'Responses'),
+    ...rows.map(([status, r]) => {
+      const media = r.content && r.content['application/json'];
+      const body = media && media.schema && !(media.schema.$ref || '').endsWith('/Error') ? el('pre', {}, pretty(example(media.schema))) : null;
+      return el('div', {}, el('p', {}, el('b', {}, status), ' ', r.description), body);
+    }));
+}
+
+// tryIt sends the request with the values filled in and shows the answer.
+function tryIt(method, path, op) {
+  const params = op.parameters || [];
+  const inputs = params.map((p) => [p, el('input', { name: p.name, placeholder: p.in })]);
+  const media = op.requestBody && op.requestBody.content['application/json'];
+  const body = media ? el('textarea', {}, pretty(example(media.schema))) : null;
+  const out = el('pre', { hidden: '' });
+  const status = el('span', { class: 'status' });
+  const form = el('form', {
+    class: 'try',
+    onsubmit: async (e) => {
+      e.preventDefault();
+      let url = path;
+      const query = new URLSearchParams();
+      const headers = {};
+      for (const [p, input] of inputs) {
+        if (input.value === '') continue;
+        if (p.in === 'path') url = url.replace(`{${p.name}}`, encodeURIComponent(input.value));
+        else if (p.in === 'query') query.set(p.name, input.value);
+        else if (p.in === 'header') headers[p.name] = input.value;
+      }
+      if ([...query].length > 0) url += `?${query}`;
+      const opts = { method: method.toUpperCase(), headers };
+      if (body) {
+        headers['Content-Type'] = 'application/json';
+        opts.body = body.value;
+      }
+      status.textContent = '…';
+      try {
+        const res = await fetch(url, opts);
+        const text = await res.text();
+        status.textContent = `${res.status} ${res.statusText}`;
+        status.classList.toggle('bad', !res.ok);
+        let shown = text;
+        try { shown = pretty(JSON.parse(text)); } catch { /* not JSON */ }
+        out.textContent = shown;
+        out.hidden = text === '';
+      } catch (err) {
+        status.textContent = err.message;
+        status.classList.add('bad');
+      }
+    },
+  },
+  el('h3', {}, 'Try it'),
+  ...inputs.map(([p, input]) => el('label', {}, el('code', {}, p.name), input)),
+  body,
+  el('div', {}, el('button', { type: 'submit' }, 'Send'), ' ', status),
+  out);
+  return form;
+}
+
+function operation(method, path, op) {
+  const media = op.requestBody && op.requestBody.content['application/json'];
+  const auth = (op.security || []).flatMap((s) => Object.keys(s));
+  const open = el('details', { 'data-search': `${method} ${path} ${op.summary || ''}`.toLowerCase() },
+    el('summary', {},
+      el('span', { class: `method ${method}` }, method.toUpperCase()),
+      el('code', {}, path),
+      el('span', { class: 'what' }, op.summary || '')));
+  open.addEventListener('toggle', () => {
+    if (!open.open || open.dataset.built) return;
+    open.dataset.built = '1';
+    open.append(el('div', { class: 'op' },
+      op.description ? el('p', {}, op.description) : null,
+      auth.length > 0 ? el('p', {}, 'Auth: ', ...auth.map((a) => el('span', { class: 'tag' }, a)), (op.security || []).some((s) => Object.keys(s).length === 0) ? ' (optional)' : '') : null,
+      parameters(op),
+      media ? el('div', {}, el('h3', {}, 'Body'), el('pre', {}, pretty(example(media.schema)))) : null,
+      responses(op),
+      tryIt(method, path, op)));
+  });
+  return open;
+}
+
+function render() {
+  const byTag = new Map();
+  for (const [path, item] of Object.entries(spec.paths)) {
+    for (const [method, op] of Object.entries(item)) {
+      const tag = (op.tags || ['other'])[0];
+      if (!byTag.has(tag)) byTag.set(tag, []);
+      byTag.get(tag).push(operation(method, path, op));
+    }
+  }
+  const nav = document.getElementById('tags');
+  const main = document.getElementById('ops');
+  main.replaceChildren();
+  for (const [tag, ops] of [...byTag].sort(([a], [b]) => a.localeCompare(b))) {
+    nav.append(el('a', { href: `#${tag}` }, tag));
+    main.append(el('h2', { id: tag }, tag), ...ops);
+  }
+}
+
+document.getElementById('filter').addEventListener('input', (e) => {
+  const q = e.target.value.trim().toLowerCase();
+  for (const d of document.querySelectorAll('details')) d.hidden = q !== '' && !d.dataset.search.includes(q);
+});
+
+fetch('/api/docs/openapi.json')
+  .then((res) => res.json())
+  .then((data) => {
+    spec = data;
+    render();
+  })
+  .catch((err) => {
+    document.getElementById('ops').replaceChildren(el('p', { class: 'empty' }, `Couldn't load the API description: ${err.message}`));
+  });
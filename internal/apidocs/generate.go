@@ -0,0 +1,828 @@
+//go:build ignore
+
+// Command generate writes openapi.json from the server's handlers. It
+// type-checks the main package, finds every /api route registered on a
+// ServeMux, and reads each handler for its path, query and header
+// parameters, its JSON request body and the statuses and values it writes.
+// The handler's doc comment becomes the operation's description.
+//
+//	go run generate.go ../..
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	log.SetFlags(0)
+	dir := "."
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	g, err := load(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g.findRoutes()
+	if len(g.ops) == 0 {
+		log.Fatal("no /api routes found")
+	}
+	out, err := json.MarshalIndent(g.spec(), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("openapi.json", append(out, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("openapi.json: %d operations, %d schemas", len(g.ops), len(g.schemas))
+}
+
+type generator struct {
+	fset  *token.FileSet
+	files []*ast.File
+	info  *types.Info
+	decls map[types.Object]*ast.FuncDecl
+
+	ops     []*operation
+	schemas map[string]any
+	named   map[*types.TypeName]string
+
+	// consts are the constant arguments of the calls being walked, such
+	// as the status a helper is told to write.
+	consts map[types.Object]int
+	// fn is the function being walked into, whose result a decodeFields
+	// call fills in.
+	fn *ast.FuncDecl
+}
+
+type operation struct {
+	method, path string
+	doc          string
+	admin        bool
+	player       string // "", "optional" or "required"
+	params       map[string]param
+	body         types.Type
+	rawBody      bool
+	rawResponse  bool               // written with Write rather than writeJSON
+	responses    map[int]types.Type // nil for a response without a body
+	errors       map[int]bool
+}
+
+type param struct {
+	in     string
+	schema map[string]any
+}
+
+// load parses and type-checks the package in dir, importing its
+// dependencies from the build cache.
+func load(dir string) (*generator, error) {
+	out, err := exec.Command("go", "list", "-f", `{{range .GoFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}`, dir).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+	g := &generator{
+		fset:    token.NewFileSet(),
+		decls:   map[types.Object]*ast.FuncDecl{},
+		schemas: map[string]any{},
+		named:   map[*types.TypeName]string{},
+		info: &types.Info{
+			Types:      map[ast.Expr]types.TypeAndValue{},
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		},
+	}
+	for _, name := range strings.Fields(string(out)) {
+		f, err := parser.ParseFile(g.fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		g.files = append(g.files, f)
+	}
+	out, err = exec.Command("go", "list", "-export", "-deps", "-f", "{{.ImportPath}}={{.Export}}", dir).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -export: %w", err)
+	}
+	exports := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, file, ok := strings.Cut(line, "="); ok && file != "" {
+			exports[path] = file
+		}
+	}
+	conf := types.Config{Importer: importer.ForCompiler(g.fset, "gc", func(path string) (io.ReadCloser, error) {
+		if file, ok := exports[path]; ok {
+			return os.Open(file)
+		}
+		return nil, fmt.Errorf("no export data for %s", path)
+	})}
+	if _, err := conf.Check("main", g.fset, g.files, g.info); err != nil {
+		return nil, err
+	}
+	for _, f := range g.files {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok {
+				g.decls[g.info.Defs[fd.Name]] = fd
+			}
+		}
+	}
+	return g, nil
+}
+
+var routePattern = regexp.MustCompile(`^([A-Z]+) (/api/\S*)$`)
+
+// findRoutes collects mux.HandleFunc and adminAPI.handle calls with a
+// constant /api pattern.
+func (g *generator) findRoutes() {
+	for _, f := range g.files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			var pattern, handler ast.Expr
+			admin := false
+			switch recv := g.info.TypeOf(sel.X); {
+			case (sel.Sel.Name == "HandleFunc" || sel.Sel.Name == "Handle") && isNamed(recv, "net/http", "ServeMux") && len(call.Args) == 2:
+				pattern, handler = call.Args[0], call.Args[1]
+			case sel.Sel.Name == "handle" && isNamed(recv, "main", "adminAPI") && len(call.Args) == 3:
+				pattern, handler, admin = call.Args[1], call.Args[2], true
+			default:
+				return true
+			}
+			s, ok := g.stringValue(pattern)
+			if !ok {
+				return true
+			}
+			m := routePattern.FindStringSubmatch(s)
+			if m == nil || strings.HasPrefix(m[2], "/api/docs") {
+				return true
+			}
+			op := &operation{
+				method:    m[1],
+				path:      strings.TrimSuffix(m[2], "{$}"),
+				admin:     admin,
+				params:    map[string]param{},
+				responses: map[int]types.Type{},
+				errors:    map[int]bool{},
+			}
+			for _, w := range regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`).FindAllStringSubmatch(op.path, -1) {
+				schema := map[string]any{"type": "string"}
+				if w[1] == "id" {
+					schema = map[string]any{"type": "integer", "format": "int64", "minimum": 1}
+				}
+				op.params["path:"+w[1]] = param{in: "path", schema: schema}
+			}
+			op.path = strings.ReplaceAll(op.path, "...}", "}")
+			if admin {
+				op.errors[401], op.errors[403] = true, true
+			}
+			g.handler(op, handler)
+			g.ops = append(g.ops, op)
+			return true
+		})
+	}
+	slices.SortStableFunc(g.ops, func(a, b *operation) int {
+		return strings.Compare(a.path, b.path)
+	})
+}
+
+// handler reads the handler expression of a route: a method value, a call
+// that returns the handler, or a function literal.
+func (g *generator) handler(op *operation, e ast.Expr) {
+	seen := map[ast.Node]bool{}
+	switch e := e.(type) {
+	case *ast.FuncLit:
+		g.walk(op, e.Body, seen, 0)
+	case *ast.CallExpr:
+		if fd := g.decl(e.Fun); fd != nil {
+			op.doc = docText(fd)
+			g.walk(op, fd.Body, seen, 0)
+		}
+	default:
+		if fd := g.decl(e); fd != nil {
+			op.doc = docText(fd)
+			g.walk(op, fd.Body, seen, 0)
+		}
+	}
+}
+
+// decl returns the declaration of the function or method e refers to.
+func (g *generator) decl(e ast.Expr) *ast.FuncDecl {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return g.decls[g.info.Uses[e]]
+	case *ast.SelectorExpr:
+		if s, ok := g.info.Selections[e]; ok {
+			return g.decls[s.Obj()]
+		}
+		return g.decls[g.info.Uses[e.Sel]]
+	}
+	return nil
+}
+
+// helpers are functions whose effect on the response is known, so the
+// walk doesn't look inside them.
+var helpers = map[string]bool{
+	"writeJSON": true, "writeError": true, "decodeJSON": true, "decodeFields": true,
+	"writeBodyError": true, "queryInt": true,
+	"fail": true, "requirePlayer": true, "currentPlayer": true, "pathID": true,
+}
+
+// walk notes what body reads and writes, following calls into the
+// package's own functions a few levels deep. seen holds the functions
+// being walked, so recursion stops.
+func (g *generator) walk(op *operation, body ast.Node, seen map[ast.Node]bool, depth int) {
+	if body == nil || seen[body] || depth > 3 {
+		return
+	}
+	seen[body] = true
+	defer delete(seen, body)
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		name := ""
+		switch fn := call.Fun.(type) {
+		case *ast.Ident:
+			name = fn.Name
+		case *ast.SelectorExpr:
+			name = fn.Sel.Name
+		}
+		fd := g.decl(call.Fun)
+		if fd == nil {
+			g.library(op, call, name)
+			return true
+		}
+		switch name {
+		case "writeJSON":
+			if status, ok := g.intValue(call.Args[1]); ok {
+				op.responses[status] = g.bodyType(call.Args[2])
+			}
+		case "writeError":
+			if status, ok := g.intValue(call.Args[1]); ok {
+				op.errors[status] = true
+			}
+		case "fail":
+			op.errors[500] = true
+		case "decodeJSON":
+			if u, ok := call.Args[3].(*ast.UnaryExpr); ok && u.Op == token.AND {
+				op.body = g.info.TypeOf(u.X)
+			}
+			op.errors[400] = true
+			op.errors[413] = true
+		case "decodeFields":
+			if g.fn != nil && g.fn.Type.Results != nil {
+				op.body = g.info.TypeOf(g.fn.Type.Results.List[0].Type)
+			}
+			op.errors[400] = true
+			op.errors[413] = true
+			op.errors[422] = true
+		case "queryInt":
+			key, _ := g.stringValue(call.Args[1])
+			schema := map[string]any{"type": "integer"}
+			if v, ok := g.intValue(call.Args[2]); ok {
+				schema["default"] = v
+			}
+			if v, ok := g.intValue(call.Args[3]); ok {
+				schema["minimum"] = v
+			}
+			if v, ok := g.intValue(call.Args[4]); ok {
+				schema["maximum"] = v
+			}
+			op.params["query:"+key] = param{in: "query", schema: schema}
+			op.errors[400] = true
+		case "pathID":
+			op.errors[400] = true
+		case "requirePlayer":
+			op.player = "required"
+			op.errors[401] = true
+		case "currentPlayer":
+			if op.player == "" {
+				op.player = "optional"
+			}
+		}
+		if !helpers[name] {
+			g.call(op, fd, call, seen, depth)
+		}
+		return true
+	})
+}
+
+// call walks fd's body with the constant integers it was called with.
+func (g *generator) call(op *operation, fd *ast.FuncDecl, call *ast.CallExpr, seen map[ast.Node]bool, depth int) {
+	consts := map[types.Object]int{}
+	i := 0
+	for _, field := range fd.Type.Params.List {
+		for _, name := range field.Names {
+			if i < len(call.Args) {
+				if v, ok := g.intValue(call.Args[i]); ok {
+					consts[g.info.Defs[name]] = v
+				}
+			}
+			i++
+		}
+		if len(field.Names) == 0 {
+			i++
+		}
+	}
+	outer, outerFn := g.consts, g.fn
+	g.consts, g.fn = consts, fd
+	g.walk(op, fd.Body, seen, depth+1)
+	g.consts, g.fn = outer, outerFn
+}
+
+// library notes calls into net/http and net/url that read the request or
+// write the response.
+func (g *generator) library(op *operation, call *ast.CallExpr, name string) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	recv := g.info.TypeOf(sel.X)
+	switch {
+	case name == "Get" && isNamed(recv, "net/url", "Values") && len(call.Args) == 1:
+		if key, ok := g.stringValue(call.Args[0]); ok {
+			if _, dup := op.params["query:"+key]; !dup {
+				op.params["query:"+key] = param{in: "query", schema: map[string]any{"type": "string"}}
+			}
+		}
+	case name == "Get" && isNamed(recv, "net/http", "Header") && len(call.Args) == 1 && isRequestHeader(sel.X):
+		if key, ok := g.stringValue(call.Args[0]); ok && documentedHeader(key) {
+			op.params["header:"+key] = param{in: "header", schema: map[string]any{"type": "string"}}
+		}
+	case name == "WriteHeader" && len(call.Args) == 1:
+		if status, ok := g.intValue(call.Args[0]); ok {
+			if _, dup := op.responses[status]; !dup {
+				op.responses[status] = nil
+			}
+		}
+	case name == "Write" && isNamed(recv, "net/http", "ResponseWriter"):
+		op.rawResponse = true
+	case name == "ReadAll" && len(call.Args) == 1 && mentionsBody(call.Args[0]):
+		op.rawBody = true
+	case name == "Redirect" && len(call.Args) == 4:
+		if status, ok := g.intValue(call.Args[3]); ok {
+			op.responses[status] = nil
+		}
+	}
+}
+
+// isRequestHeader reports whether e is r.Header rather than w.Header().
+func isRequestHeader(e ast.Expr) bool {
+	_, ok := e.(*ast.SelectorExpr)
+	return ok
+}
+
+func mentionsBody(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "Body" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// documentedHeader leaves out the headers every request may carry.
+func documentedHeader(h string) bool {
+	h = strings.ToLower(h)
+	switch {
+	case strings.HasPrefix(h, "x-forwarded"), h == "x-real-ip", h == "x-request-id", h == "x-admin-token":
+		return false
+	}
+	return strings.HasPrefix(h, "x-") || strings.HasPrefix(h, "if-")
+}
+
+// bodyType returns the type written by writeJSON, or for a map literal a
+// struct-like stand-in built from its keys.
+func (g *generator) bodyType(e ast.Expr) types.Type {
+	if lit, ok := e.(*ast.CompositeLit); ok {
+		if _, isMap := g.info.TypeOf(lit).Underlying().(*types.Map); isMap {
+			var fields []*types.Var
+			var tags []string
+			for _, elt := range lit.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				key, ok := g.stringValue(kv.Key)
+				if !ok {
+					return g.info.TypeOf(lit)
+				}
+				fields = append(fields, types.NewField(token.NoPos, nil, exportedName(key), g.info.TypeOf(kv.Value), false))
+				tags = append(tags, fmt.Sprintf(`json:%q`, key))
+			}
+			return types.NewStruct(fields, tags)
+		}
+	}
+	return g.info.TypeOf(e)
+}
+
+func exportedName(key string) string {
+	var b strings.Builder
+	b.WriteString("F")
+	for _, r := range key {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (g *generator) stringValue(e ast.Expr) (string, bool) {
+	tv, ok := g.info.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+func (g *generator) intValue(e ast.Expr) (int, bool) {
+	if id, ok := e.(*ast.Ident); ok {
+		if v, ok := g.consts[g.info.Uses[id]]; ok {
+			return v, true
+		}
+	}
+	tv, ok := g.info.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.Int {
+		return 0, false
+	}
+	n, ok := constant.Int64Val(tv.Value)
+	return int(n), ok
+}
+
+func isNamed(t types.Type, pkg, name string) bool {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	n, ok := t.(*types.Named)
+	return ok && n.Obj().Name() == name && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == pkg
+}
+
+// docText returns a handler's doc comment as prose, without the leading
+// identifier: "list returns the top scores" becomes "Returns the top
+// scores".
+func docText(fd *ast.FuncDecl) string {
+	text := strings.TrimSpace(fd.Doc.Text())
+	if text == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(text, fd.Name.Name+" "); ok {
+		rest = strings.TrimPrefix(rest, "is ")
+		text = strings.ToUpper(rest[:1]) + rest[1:]
+	}
+	var paras []string
+	for _, p := range strings.Split(text, "\n\n") {
+		paras = append(paras, strings.Join(strings.Fields(p), " "))
+	}
+	return strings.Join(paras, "\n\n")
+}
+
+// summary is the first sentence of a description.
+func summary(doc string) string {
+	doc, _, _ = strings.Cut(doc, "\n\n")
+	if i := strings.Index(doc, ". "); i >= 0 {
+		doc = doc[:i]
+	}
+	doc = strings.TrimSuffix(doc, ".")
+	for _, sep := range []string{": ", "; ", ", from a body like", " from a body like", " with a body like", " in a body like", " with {", " like {"} {
+		if i := strings.Index(doc, sep); i > 0 {
+			doc = doc[:i]
+		}
+	}
+	return doc
+}
+
+func (g *generator) spec() map[string]any {
+	paths := map[string]any{}
+	tags := map[string]bool{}
+	for _, op := range g.ops {
+		item, _ := paths[op.path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		tag := strings.SplitN(strings.TrimPrefix(op.path, "/api/"), "/", 2)[0]
+		tags[tag] = true
+		o := map[string]any{
+			"operationId": operationID(op.method, op.path),
+			"tags":        []string{tag},
+			"responses":   g.responses(op),
+		}
+		if op.doc != "" {
+			o["description"] = op.doc
+			// "getCanary is GET /api/admin/canary" says nothing the path
+			// doesn't.
+			if s := summary(op.doc); !strings.HasPrefix(s, op.method+" /") {
+				o["summary"] = s
+			}
+		}
+		if params := g.parameters(op); len(params) > 0 {
+			o["parameters"] = params
+		}
+		switch {
+		case op.body != nil:
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": g.schema(op.body)}},
+			}
+		case op.rawBody:
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": map[string]any{}}},
+			}
+		}
+		switch {
+		case op.admin:
+			o["security"] = []any{map[string]any{"adminToken": []string{}}, map[string]any{"session": []string{}}, map[string]any{"bearer": []string{}}}
+		case op.player == "required":
+			o["security"] = []any{map[string]any{"session": []string{}}, map[string]any{"bearer": []string{}}}
+		case op.player == "optional":
+			o["security"] = []any{map[string]any{}, map[string]any{"session": []string{}}, map[string]any{"bearer": []string{}}}
+		}
+		item[strings.ToLower(op.method)] = o
+	}
+	var tagList []any
+	for _, t := range slices.Sorted(mapKeys(tags)) {
+		tagList = append(tagList, map[string]any{"name": t})
+	}
+	g.schemas["Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"error", "request_id"},
+		"properties": map[string]any{
+			"error":      map[string]any{"type": "string", "description": "What went wrong, safe to show to the player."},
+			"request_id": map[string]any{"type": "string", "description": "Also sent as X-Request-ID; quote it when reporting a problem."},
+		},
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Lode Runner 2099 Game API",
+			"version":     "1",
+			"description": "The JSON API under /api, generated from the server's handlers by internal/apidocs/generate.go. Some endpoints only exist with the features that need them configured; see docs/API.md and DEPLOYMENT.md.",
+		},
+		"servers": []any{map[string]any{"url": "/"}},
+		"tags":    tagList,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"session":    map[string]any{"type": "apiKey", "in": "cookie", "name": "lr_session"},
+				"bearer":     map[string]any{"type": "http", "scheme": "bearer", "description": "The session token from /api/auth/login."},
+				"adminToken": map[string]any{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+			},
+		},
+	}
+}
+
+func mapKeys[V any](m map[string]V) func(func(string) bool) {
+	return func(yield func(string) bool) {
+		for k := range m {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// operationID names an operation after its method and path, such as
+// getScoresById for GET /api/scores/{id}.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "/api/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if w, ok := strings.CutPrefix(seg, "{"); ok {
+			b.WriteString("By")
+			seg = strings.TrimSuffix(w, "}")
+		}
+		for _, part := range strings.FieldsFunc(seg, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+func (g *generator) parameters(op *operation) []any {
+	var list []any
+	for _, key := range slices.Sorted(mapKeys(op.params)) {
+		p := op.params[key]
+		_, name, _ := strings.Cut(key, ":")
+		list = append(list, map[string]any{
+			"name":     name,
+			"in":       p.in,
+			"required": p.in == "path",
+			"schema":   p.schema,
+		})
+	}
+	return list
+}
+
+func (g *generator) responses(op *operation) map[string]any {
+	out := map[string]any{}
+	for status, t := range op.responses {
+		r := map[string]any{"description": statusText(status)}
+		if t != nil {
+			r["content"] = map[string]any{"application/json": map[string]any{"schema": g.schema(t)}}
+		}
+		out[strconv.Itoa(status)] = r
+	}
+	for status := range op.errors {
+		if _, ok := out[strconv.Itoa(status)]; ok {
+			continue
+		}
+		out[strconv.Itoa(status)] = map[string]any{
+			"description": statusText(status),
+			"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}},
+		}
+	}
+	if !slices.ContainsFunc(slices.Collect(mapKeys(out)), func(s string) bool { return s[0] == '2' }) {
+		r := map[string]any{"description": "OK"}
+		if op.rawResponse {
+			r["content"] = map[string]any{"application/json": map[string]any{"schema": map[string]any{}}}
+		}
+		out["200"] = r
+	}
+	return out
+}
+
+var statusTexts = map[int]string{
+	200: "OK", 201: "Created", 202: "Accepted", 204: "No Content", 302: "Found", 303: "See Other", 304: "Not Modified",
+	400: "Bad Request", 401: "Unauthorized", 403: "Forbidden", 404: "Not Found", 409: "Conflict",
+	410: "Gone", 412: "Precondition Failed", 413: "Payload Too Large", 415: "Unsupported Media Type",
+	422: "Unprocessable Entity", 428: "Precondition Required", 429: "Too Many Requests",
+	500: "Internal Server Error", 502: "Bad Gateway", 503: "Service Unavailable",
+}
+
+func statusText(status int) string {
+	if s, ok := statusTexts[status]; ok {
+		return s
+	}
+	return "Status " + strconv.Itoa(status)
+}
+
+// schema returns the JSON schema for t, as encoding/json would write it.
+// Named structs go into components and are referenced.
+func (g *generator) schema(t types.Type) map[string]any {
+	switch t := t.(type) {
+	case *types.Pointer:
+		s := g.schema(t.Elem())
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case *types.Alias:
+		return g.schema(types.Unalias(t))
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil {
+			switch obj.Pkg().Path() + "." + obj.Name() {
+			case "time.Time":
+				return map[string]any{"type": "string", "format": "date-time"}
+			case "time.Duration":
+				return map[string]any{"type": "integer", "format": "int64", "description": "Nanoseconds."}
+			case "encoding/json.RawMessage":
+				return map[string]any{}
+			}
+		}
+		if implementsMarshaler(t) {
+			return map[string]any{}
+		}
+		st, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			return g.schema(t.Underlying())
+		}
+		name, done := g.named[obj]
+		if !done {
+			name = g.componentName(obj)
+			g.named[obj] = name
+			g.schemas[name] = map[string]any{} // breaks cycles
+			g.schemas[name] = g.structSchema(st)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsBoolean != 0:
+			return map[string]any{"type": "boolean"}
+		case t.Info()&types.IsInteger != 0:
+			s := map[string]any{"type": "integer"}
+			if t.Kind() == types.Int64 || t.Kind() == types.Uint64 {
+				s["format"] = "int64"
+			}
+			return s
+		case t.Info()&types.IsFloat != 0:
+			return map[string]any{"type": "number"}
+		case t.Info()&types.IsString != 0:
+			return map[string]any{"type": "string"}
+		}
+		return map[string]any{}
+	case *types.Slice:
+		if b, ok := t.Elem().(*types.Basic); ok && b.Kind() == types.Byte {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case *types.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case *types.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case *types.Struct:
+		return g.structSchema(t)
+	}
+	return map[string]any{}
+}
+
+// componentName names a type's schema, prefixing its package's name if
+// another type has the name already. Error is the API's error body.
+func (g *generator) componentName(obj *types.TypeName) string {
+	name := obj.Name()
+	name = strings.ToUpper(name[:1]) + name[1:]
+	prefixed := strings.ToUpper(obj.Pkg().Name()[:1]) + obj.Pkg().Name()[1:] + name
+	if name == "Error" {
+		return prefixed
+	}
+	for other, taken := range g.named {
+		if taken == name && other != obj {
+			return prefixed
+		}
+	}
+	return name
+}
+
+func implementsMarshaler(t types.Type) bool {
+	for _, typ := range []types.Type{t, types.NewPointer(t)} {
+		ms := types.NewMethodSet(typ)
+		for i := range ms.Len() {
+			if ms.At(i).Obj().Name() == "MarshalJSON" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// structSchema follows encoding/json: exported fields by their json tag,
+// embedded structs flattened, "-" and unexported fields left out. Nothing
+// is marked required, as the handlers check request bodies themselves.
+func (g *generator) structSchema(st *types.Struct) map[string]any {
+	props := map[string]any{}
+	g.fields(st, props)
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func (g *generator) fields(st *types.Struct, props map[string]any) {
+	for i := range st.NumFields() {
+		f := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if f.Embedded() && name == "" {
+			ft := f.Type()
+			if p, ok := ft.(*types.Pointer); ok {
+				ft = p.Elem()
+			}
+			if inner, ok := ft.Underlying().(*types.Struct); ok {
+				g.fields(inner, props)
+				continue
+			}
+		}
+		if !f.Exported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name()
+		}
+		s := g.schema(f.Type())
+		if strings.Contains(opts, "string") {
+			s = map[string]any{"type": "string"}
+		}
+		props[name] = s
+	}
+}
@@ -0,0 +1,216 @@
+'use strict';
+
+// The dashboard is plain DOM code on top of /api/admin. The session or
+// admin cookie authenticates every request; stats refresh every few
+// seconds and the lists after each action.
+
+const REFRESH_MS = 5000;
+let previous = null; // last stats sample, for request rates
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers['Content-Type'] = 'application/json';
+    opts.body = JSON.stringify(body);
+  }
+  const res = await fetch(path, opts);
+  if (res.status === 401 || res.status === 403) {
+    location.reload(); // back to the sign-in page
+    throw new Error('signed out');
+  }
+  if (!res.ok) {
+    const data = await res.json().catch(() => ({}));
+    throw new Error(data.error || `${method} ${path}: ${res.status}`);
+  }
+  return res.status === 204 ? null : res.json();
+}
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === 'onclick') node.addEventListener('click', v);
+    else node.setAttribute(k, v);
+  }
+  for (const c of children) {
+    if (c !== null && c !== undefined) node.append(c instanceof Node ? c : String(c));
+  }
+  return node;
+}
+
+function toast(msg) {
+  const t = document.getElementById('toast');
+  t.textContent = msg;
+  t.classList.add('show');
+  setTimeout(() => t.classList.remove('show'), 2500);
+}
+
+function when(iso) {
+  return new Date(iso).toLocaleString();
+}
+
+function table(headers, rows) {
+  if (rows.length === 0) return el('p', { class: 'empty' }, 'Nothing here.');
+  return el('table', {},
+    el('thead', {}, el('tr', {}, ...headers.map((h) => el('th', {}, h)))),
+    el('tbody', {}, ...rows.map((cells) => el('tr', {}, ...cells.map((c) => el('td', {}, c))))));
+}
+
+// action asks for an optional reason, runs the request and refreshes.
+function action(label, method, path, { danger = false, confirmText = null } = {}) {
+  return el('button', {
+    class: danger ? 'danger' : '',
+    onclick: async () => {
+      if (confirmText && !confirm(confirmText)) return;
+      const reason = prompt(`${label}: reason (optional)`, '');
+      if (reason === null) return;
+      try {
+        await api(method, path, reason ? { reason } : undefined);
+        toast(`${label}: done`);
+        refreshLists();
+      } catch (err) {
+        toast(err.message);
+      }
+    },
+  }, label);
+}
+
+function banButton(hash) {
+  if (!hash) return null;
+  return el('button', {
+    class: 'danger',
+    onclick: async () => {
+      const reason = prompt('Ban this player token: reason (optional)', '');
+      if (reason === null) return;
+      try {
+        await api('POST', '/api/admin/bans', { player_token_hash: hash, reason });
+        toast('Banned');
+        refreshLists();
+      } catch (err) {
+        toast(err.message);
+      }
+    },
+  }, 'Ban sender');
+}
+
+function scoreActions(s) {
+  return el('span', {},
+    s.flagged
+      ? action('Unflag', 'POST', `/api/admin/scores/${s.id}/unflag`)
+      : action('Flag', 'POST', `/api/admin/scores/${s.id}/flag`),
+    action('Delete', 'DELETE', `/api/admin/scores/${s.id}`, { danger: true, confirmText: `Delete score #${s.id}?` }),
+    banButton(s.player_token_hash));
+}
+
+function scoreRow(s) {
+  return [
+    `#${s.id}`,
+    s.player,
+    el('span', { class: 'num' }, s.score.toLocaleString()),
+    `L${s.level}`,
+    el('span', { class: s.status === 'rejected' ? 'tag bad' : 'tag', title: s.status_reason || '' }, s.status),
+    when(s.created_at),
+    scoreActions(s),
+  ];
+}
+
+function levelActions(l) {
+  return el('span', {},
+    l.unpublished
+      ? action('Publish', 'POST', `/api/admin/levels/${l.id}/publish`)
+      : action('Unpublish', 'POST', `/api/admin/levels/${l.id}/unpublish`, { danger: true }),
+    l.unpublished ? null : l.featured
+      ? action('Unfeature', 'POST', `/api/admin/levels/${l.id}/unfeature`)
+      : action('Feature', 'POST', `/api/admin/levels/${l.id}/feature`),
+    banButton(l.player_token_hash));
+}
+
+function renderStats(s) {
+  let rate = '–';
+  if (previous) {
+    const secs = (Date.parse(s.sampled) - Date.parse(previous.sampled)) / 1000;
+    if (secs > 0) rate = ((s.requests.total - previous.requests.total) / secs).toFixed(1);
+  }
+  previous = s;
+  const errors = s.requests.total ? ((s.requests['5xx'] / s.requests.total) * 100).toFixed(2) + '%' : '0%';
+  const items = [
+    ['req/s', rate],
+    ['requests', s.requests.total.toLocaleString()],
+    ['5xx', errors],
+    ['in flight', s.in_flight],
+    ['ws connections', s.lobby.connections],
+    ['rooms', s.lobby.rooms],
+    ['matchmaking', s.matchmaking],
+    ['scores 24h', s.database.scores_last_day],
+    ['pending replays', s.database.pending_replays],
+    ['open reports', s.database.open_reports],
+    ['players', s.database.players],
+    ['uptime', `${Math.floor(s.uptime / 3600)}h ${Math.floor((s.uptime % 3600) / 60)}m`],
+  ];
+  document.getElementById('stats').replaceChildren(
+    ...items.map(([label, value]) => el('div', { class: 'stat' }, el('b', {}, value), el('span', {}, label))));
+  document.getElementById('updated').textContent = `updated ${new Date().toLocaleTimeString()}`;
+}
+
+async function refreshStats() {
+  try {
+    const s = await api('GET', '/api/admin/stats');
+    s.sampled = new Date().toISOString();
+    renderStats(s);
+  } catch (err) {
+    document.getElementById('updated').textContent = err.message;
+  }
+}
+
+async function refreshLists() {
+  const [reports, scores, flagged, levels, bans, audit] = await Promise.all([
+    api('GET', '/api/admin/reports'),
+    api('GET', '/api/admin/scores?limit=25'),
+    api('GET', '/api/admin/scores?flagged=true&limit=25'),
+    api('GET', '/api/admin/levels?limit=25'),
+    api('GET', '/api/admin/bans'),
+    api('GET', '/api/admin/audit?limit=25'),
+  ]);
+
+  document.getElementById('reports').replaceChildren(table(
+    ['Item', 'Reports', 'Reasons', 'Actions'],
+    reports.items.map((it) => {
+      const t = it.target;
+      let label = `${it.kind} #${it.id} (deleted)`;
+      let actions = el('span', {});
+      if (t && it.kind === 'score') {
+        label = `score #${t.id}: ${t.player} · ${t.score.toLocaleString()} on L${t.level}`;
+        actions = scoreActions(t);
+      } else if (t && it.kind === 'level') {
+        label = `level #${t.id}: “${t.title}” by ${t.author}`;
+        actions = levelActions(t);
+      }
+      actions.append(action('Dismiss', 'POST', `/api/admin/reports/${it.kind}/${it.id}/dismiss`));
+      return [label, it.reports, it.reasons.join(' · '), actions];
+    })));
+
+  document.getElementById('scores').replaceChildren(table(
+    ['ID', 'Player', 'Score', 'Level', 'Status', 'Submitted', ''], scores.scores.map(scoreRow)));
+  document.getElementById('flagged').replaceChildren(table(
+    ['ID', 'Player', 'Score', 'Level', 'Status', 'Submitted', ''], flagged.scores.map(scoreRow)));
+  document.getElementById('levels').replaceChildren(table(
+    ['ID', 'Title', 'Author', 'Created', ''],
+    levels.levels.map((l) => [`#${l.id}`, l.title, l.author, when(l.created_at), levelActions(l)])));
+  document.getElementById('bans').replaceChildren(table(
+    ['Kind', 'Value', 'Reason', 'By', ''],
+    bans.bans.map((b) => [b.kind, el('code', {}, b.value.slice(0, 16)), b.reason || '', b.created_by,
+      action('Lift', 'DELETE', `/api/admin/bans/${b.kind}/${encodeURIComponent(b.value)}`)])));
+  document.getElementById('audit').replaceChildren(table(
+    ['When', 'Who', 'Action', 'Target', 'Detail'],
+    audit.entries.map((e) => [when(e.created_at), e.actor, e.action,
+      e.target_kind ? `${e.target_kind} ${e.target_id.slice(0, 16)}` : '', e.detail || ''])));
+}
+
+document.getElementById('logout').addEventListener('click', async () => {
+  await fetch('/admin/logout', { method: 'POST' });
+  await fetch('/api/auth/logout', { method: 'POST' });
+  location.reload();
+});
+
+refreshStats();
+refreshLists().catch((err) => toast(err.message));
+setInterval(refreshStats, REFRESH_MS);
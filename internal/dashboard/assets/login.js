@@ -0,0 +1,38 @@
+'use strict';
+
+const error = document.getElementById('error');
+
+async function submit(form, url, body) {
+  error.textContent = '';
+  const res = await fetch(url, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+  if (!res.ok) {
+    const data = await res.json().catch(() => ({}));
+    error.textContent = data.error || `Sign-in failed (${res.status})`;
+    return;
+  }
+  // The server decides whether this account may moderate.
+  location.reload();
+}
+
+document.getElementById('account').addEventListener('submit', (e) => {
+  e.preventDefault();
+  const f = new FormData(e.target);
+  submit(e.target, '/api/auth/login', { username: f.get('username'), password: f.get('password') });
+});
+
+document.getElementById('token').addEventListener('submit', (e) => {
+  e.preventDefault();
+  submit(e.target, '/admin/login', { token: new FormData(e.target).get('token') });
+});
+
+// Landing here while signed in means the account isn't a moderator.
+fetch('/api/auth/me').then(async (res) => {
+  if (res.ok) {
+    const me = await res.json();
+    error.textContent = `Signed in as ${me.username}, which is not a moderator account.`;
+  }
+});
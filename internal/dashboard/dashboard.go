@@ -0,0 +1,22 @@
+// Package dashboard holds the moderation dashboard's static files. The
+// server serves them at /admin/ behind the admin check; the pages talk to
+// /api/admin with the browser's cookies.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// FS returns the dashboard files: index.html, login.html and their
+// scripts and styles.
+func FS() fs.FS {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
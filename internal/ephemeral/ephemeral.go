@@ -0,0 +1,74 @@
+// Package ephemeral keeps the short-lived state the multiplayer lobby,
+// matchmaking, rate limiting and session lookups share: values that expire,
+// token buckets and numbered broadcasts. A single server keeps it in
+// memory; instances behind a load balancer share a Redis server, so that
+// whichever one a request reaches sees the same rooms, queue and limits.
+//
+// Nothing here needs to survive Redis restarting. Games in progress lose
+// their rooms and the queue empties, but no scores or accounts, which live
+// in the database.
+package ephemeral
+
+import (
+	"context"
+	"time"
+)
+
+// Store is ephemeral state. A missing or expired key reads as nil.
+type Store interface {
+	// Allow takes a token from the bucket at key, which holds up to burst
+	// tokens and refills at rate per second. It returns zero when there
+	// was a token, and otherwise how long until there will be one.
+	Allow(ctx context.Context, key string, rate, burst float64) (retry time.Duration, err error)
+
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value at key for ttl, or with no expiry for zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Pop returns the value at key and deletes it, so that only one caller
+	// gets it.
+	Pop(ctx context.Context, key string) ([]byte, error)
+
+	Delete(ctx context.Context, key string) error
+
+	// Update replaces the value at key with what fn returns for the
+	// current one, atomically, and stores it for ttl. A nil result deletes
+	// the key and an error aborts, leaving it alone. fn may run more than
+	// once when another writer gets there first, and must not use the
+	// store itself.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error
+
+	// Publish sends msg to the subscribers of channel, numbering it: the
+	// messages on a channel are numbered from 1 in the order every
+	// subscriber receives them. A channel's count is forgotten a day after
+	// its last message.
+	Publish(ctx context.Context, channel string, msg []byte) (seq uint64, err error)
+
+	// Subscribe calls fn for each message published, by any instance, on
+	// a channel whose name starts with prefix, until ctx is done. Calls
+	// are made one at a time; fn must not publish. Messages published
+	// while a connection to Redis is being restored are lost.
+	Subscribe(ctx context.Context, prefix string, fn func(channel string, seq uint64, msg []byte)) error
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Open returns a Redis store for a redis://, rediss:// or unix:// URL, or
+// an in-memory store for an empty one.
+func Open(ctx context.Context, url string) (Store, error) {
+	if url == "" {
+		return NewMemory(), nil
+	}
+	return openRedis(ctx, url)
+}
+
+// counterTTL is how long Publish remembers a quiet channel's count.
+const counterTTL = 24 * time.Hour
+
+// bucketTTL is how long an untouched bucket takes to refill. After that
+// forgetting it changes nothing.
+func bucketTTL(rate, burst float64) time.Duration {
+	return time.Duration(burst / rate * float64(time.Second))
+}
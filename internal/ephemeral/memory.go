@@ -0,0 +1,243 @@
+package ephemeral
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often a Memory store drops what has expired.
+const sweepInterval = time.Minute
+
+// subscriberQueue is how many messages a subscriber may fall behind
+// before publishers wait for it.
+const subscriberQueue = 256
+
+// Memory is a Store for a single server.
+type Memory struct {
+	mu      sync.Mutex
+	values  map[string]value
+	buckets map[string]*bucket
+
+	// pubMu orders publishes, so subscribers see each channel's messages
+	// in sequence.
+	pubMu    sync.Mutex
+	counters map[string]*counter
+	subs     map[*subscriber]struct{}
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type value struct {
+	data    []byte
+	expires time.Time // zero for never
+}
+
+func (v value) live(now time.Time) bool { return v.expires.IsZero() || now.Before(v.expires) }
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when it will have refilled
+}
+
+type counter struct {
+	n    uint64
+	last time.Time
+}
+
+type subscriber struct {
+	prefix string
+	ch     chan delivery
+}
+
+type delivery struct {
+	channel string
+	seq     uint64
+	msg     []byte
+}
+
+// NewMemory returns an empty store, which sweeps out expired keys until
+// it is closed.
+func NewMemory() *Memory {
+	m := &Memory{
+		values:   map[string]value{},
+		buckets:  map[string]*bucket{},
+		counters: map[string]*counter{},
+		subs:     map[*subscriber]struct{}{},
+		stop:     make(chan struct{}),
+	}
+	go m.sweep()
+	return m
+}
+
+func (m *Memory) Allow(_ context.Context, key string, rate, burst float64) (time.Duration, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: burst, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	b.full = now.Add(bucketTTL(rate, burst))
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(key, time.Now()), nil
+}
+
+// get must hold m.mu.
+func (m *Memory) get(key string, now time.Time) []byte {
+	v, ok := m.values[key]
+	if !ok || !v.live(now) {
+		return nil
+	}
+	return v.data
+}
+
+func (m *Memory) Set(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, data, ttl, time.Now())
+	return nil
+}
+
+// set must hold m.mu.
+func (m *Memory) set(key string, data []byte, ttl time.Duration, now time.Time) {
+	v := value{data: append([]byte(nil), data...)}
+	if ttl > 0 {
+		v.expires = now.Add(ttl)
+	}
+	m.values[key] = v
+}
+
+func (m *Memory) Pop(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := m.get(key, time.Now())
+	delete(m.values, key)
+	return data, nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.values, key)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Update(_ context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	data, err := fn(m.get(key, now))
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		delete(m.values, key)
+		return nil
+	}
+	m.set(key, data, ttl, now)
+	return nil
+}
+
+func (m *Memory) Publish(ctx context.Context, channel string, msg []byte) (uint64, error) {
+	m.pubMu.Lock()
+	defer m.pubMu.Unlock()
+	c := m.counters[channel]
+	if c == nil {
+		c = &counter{}
+		m.counters[channel] = c
+	}
+	c.n++
+	c.last = time.Now()
+	d := delivery{channel: channel, seq: c.n, msg: append([]byte(nil), msg...)}
+	for s := range m.subs {
+		if !strings.HasPrefix(channel, s.prefix) {
+			continue
+		}
+		select {
+		case s.ch <- d:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return c.n, nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, prefix string, fn func(string, uint64, []byte)) error {
+	s := &subscriber{prefix: prefix, ch: make(chan delivery, subscriberQueue)}
+	m.pubMu.Lock()
+	m.subs[s] = struct{}{}
+	m.pubMu.Unlock()
+	defer func() {
+		m.pubMu.Lock()
+		delete(m.subs, s)
+		m.pubMu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.stop:
+			return nil
+		case d := <-s.ch:
+			fn(d.channel, d.seq, d.msg)
+		}
+	}
+}
+
+func (m *Memory) Ping(context.Context) error { return nil }
+
+// Close stops the sweeper and ends subscriptions.
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+// sweep drops expired values, refilled buckets and quiet channels' counts
+// every sweepInterval until m is closed.
+func (m *Memory) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for k, v := range m.values {
+				if !v.live(now) {
+					delete(m.values, k)
+				}
+			}
+			for k, b := range m.buckets {
+				if now.After(b.full) {
+					delete(m.buckets, k)
+				}
+			}
+			m.mu.Unlock()
+			m.pubMu.Lock()
+			for k, c := range m.counters {
+				if now.Sub(c.last) > counterTTL {
+					delete(m.counters, k)
+				}
+			}
+			m.pubMu.Unlock()
+		}
+	}
+}
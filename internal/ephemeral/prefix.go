@@ -0,0 +1,58 @@
+package ephemeral
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Prefix returns a view of s in which every key and channel name starts
+// with prefix, so that several sites on one server, or one Redis, don't see
+// each other's state. Subscribers see channel names without the prefix.
+// Closing the view leaves s open.
+func Prefix(s Store, prefix string) Store {
+	return &prefixed{s: s, prefix: prefix}
+}
+
+type prefixed struct {
+	s      Store
+	prefix string
+}
+
+func (p *prefixed) Allow(ctx context.Context, key string, rate, burst float64) (time.Duration, error) {
+	return p.s.Allow(ctx, p.prefix+key, rate, burst)
+}
+
+func (p *prefixed) Get(ctx context.Context, key string) ([]byte, error) {
+	return p.s.Get(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return p.s.Set(ctx, p.prefix+key, value, ttl)
+}
+
+func (p *prefixed) Pop(ctx context.Context, key string) ([]byte, error) {
+	return p.s.Pop(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Delete(ctx context.Context, key string) error {
+	return p.s.Delete(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	return p.s.Update(ctx, p.prefix+key, ttl, fn)
+}
+
+func (p *prefixed) Publish(ctx context.Context, channel string, msg []byte) (uint64, error) {
+	return p.s.Publish(ctx, p.prefix+channel, msg)
+}
+
+func (p *prefixed) Subscribe(ctx context.Context, prefix string, fn func(string, uint64, []byte)) error {
+	return p.s.Subscribe(ctx, p.prefix+prefix, func(channel string, seq uint64, msg []byte) {
+		fn(strings.TrimPrefix(channel, p.prefix), seq, msg)
+	})
+}
+
+func (p *prefixed) Ping(ctx context.Context) error { return p.s.Ping(ctx) }
+
+func (p *prefixed) Close() error { return nil }
@@ -0,0 +1,174 @@
+package ephemeral
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// updateAttempts bounds Update's retries when other writers keep winning.
+const updateAttempts = 20
+
+// allowScript is Memory.Allow run inside Redis, on Redis's clock, so that
+// instances whose clocks disagree still share one bucket. It returns the
+// wait in milliseconds.
+var allowScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]), tonumber(b[2])
+if tokens == nil or last == nil then
+	tokens, last = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return wait
+`)
+
+// publishScript numbers and publishes a message in one step, so that the
+// numbers go out in the order Redis delivers them.
+var publishScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PUBLISH', ARGV[1], seq .. ' ' .. ARGV[2])
+return seq
+`)
+
+type redisStore struct {
+	c *redis.Client
+}
+
+func openRedis(ctx context.Context, url string) (*redisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisStore{c: redis.NewClient(opts)}
+	if err := s.Ping(ctx); err != nil {
+		s.c.Close()
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return s, nil
+}
+
+func (s *redisStore) Allow(ctx context.Context, key string, rate, burst float64) (time.Duration, error) {
+	ms, err := allowScript.Run(ctx, s.c, []string{key}, rate, burst).Int64()
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := s.c.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.c.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *redisStore) Pop(ctx context.Context, key string) ([]byte, error) {
+	b, err := s.c.GetDel(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (s *redisStore) Delete(ctx context.Context, key string) error {
+	return s.c.Del(ctx, key).Err()
+}
+
+// Update is optimistic: it watches key, and starts over if anyone else
+// writes it before the new value is stored.
+func (s *redisStore) Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error {
+	txn := func(tx *redis.Tx) error {
+		old, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			old, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := fn(old)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			if data == nil {
+				p.Del(ctx, key)
+			} else {
+				p.Set(ctx, key, data, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+	for range updateAttempts {
+		err := s.c.Watch(ctx, txn, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("redis: %s changed %d times in a row", key, updateAttempts)
+}
+
+func (s *redisStore) Publish(ctx context.Context, channel string, msg []byte) (uint64, error) {
+	return publishScript.Run(ctx, s.c, []string{"seq:" + channel}, channel, msg, counterTTL.Milliseconds()).Uint64()
+}
+
+func (s *redisStore) Subscribe(ctx context.Context, prefix string, fn func(string, uint64, []byte)) error {
+	ps := s.c.PSubscribe(ctx, globEscape(prefix)+"*")
+	defer ps.Close()
+	if _, err := ps.Receive(ctx); err != nil {
+		return fmt.Errorf("redis: subscribe: %w", err)
+	}
+	ch := ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			seq, msg, _ := strings.Cut(m.Payload, " ")
+			n, _ := strconv.ParseUint(seq, 10, 64)
+			fn(m.Channel, n, []byte(msg))
+		}
+	}
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.c.Ping(ctx).Err()
+}
+
+func (s *redisStore) Close() error {
+	return s.c.Close()
+}
+
+// globEscape quotes the characters PSUBSCRIBE patterns treat specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,6 @@
+// Package gamepb is the gRPC API's messages and services, generated from
+// game.proto. Tooling outside this module generates its own client from
+// the same file.
+package gamepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative game.proto
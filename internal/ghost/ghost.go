@@ -0,0 +1,75 @@
+// Package ghost replays a recorded run's inputs to recover where the player
+// went on each level, so clients can race a "ghost" of the run.
+//
+// Replays hold only the player's inputs, so the run is played again on
+// the levels its seed generates, with the client's movement rules at its
+// fixed tick rate. Guards move at random in the client and aren't
+// recorded, so they are left out: the trace is the player's alone, and a
+// run that a guard ended early, or that stood on a trapped guard, drifts
+// from the real one from that point.
+package ghost
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+
+	"github.com/jgbrwn/loderunner2099/internal/replay"
+)
+
+// Sample is the player's cell at a tick, counted from the start of the
+// level. A trace has one when the player starts moving and one on
+// reaching each cell; clients move the ghost in a straight line between
+// them.
+type Sample struct {
+	Tick uint32
+	X, Y uint8
+}
+
+// Level is the trace of one level of a run: the last attempt at it, which
+// for a level the run got past is the one that cleared it. Ticks is how
+// long that attempt took to clear, or lasted.
+type Level struct {
+	Number  int
+	Cleared bool
+	Ticks   int64
+	Samples []Sample
+}
+
+// Format 1 is a gzip stream of 6-byte records: tick as little-endian
+// uint32, then x and y.
+const (
+	Format1     = 1
+	recordSize1 = 6
+)
+
+// Encode compresses samples in Format1.
+func Encode(samples []Sample) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	rec := make([]byte, recordSize1)
+	for _, s := range samples {
+		binary.LittleEndian.PutUint32(rec, s.Tick)
+		rec[4], rec[5] = s.X, s.Y
+		zw.Write(rec)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+// Trace plays frames, a replay of ticks ticks of a run of seed at the
+// difficulty from level 1, and returns each level it reached in order.
+func Trace(seed, difficulty string, frames []replay.Frame, ticks int64) []Level {
+	r := newRun(seed, difficulty)
+	next := 0
+	var input, held replay.Input
+	for tick := int64(0); tick < ticks && !r.over; tick++ {
+		for next < len(frames) && int64(frames[next].Tick) <= tick {
+			input = frames[next].Input
+			next++
+		}
+		r.step(input, input&^held)
+		held = input
+	}
+	return r.levels
+}
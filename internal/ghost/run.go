@@ -0,0 +1,365 @@
+package ghost
+
+import (
+	"github.com/jgbrwn/loderunner2099/internal/level"
+	"github.com/jgbrwn/loderunner2099/internal/levelgen"
+	"github.com/jgbrwn/loderunner2099/internal/replay"
+)
+
+// Client constants from src/config.ts, and its Player and GameScene.
+const (
+	tileSize   = 24    // pixels
+	baseSpeed  = 100.0 // pixels per second
+	fallSpeed  = 140.0
+	digFrames  = 12
+	holeMillis = 5000.0
+	tickMillis = 1000.0 / replay.TickRate
+
+	// Ticks between clearing a level and the next starting, and between
+	// dying and the level restarting.
+	winDelay   = 2 * replay.TickRate
+	deathDelay = 3 * replay.TickRate / 2
+)
+
+// dug is a dug brick, which has no character in the level legend.
+const dug level.Tile = '_'
+
+// Per difficulty, as DIFFICULTIES in src/config.ts: starting lives and how
+// long holes stay open.
+var (
+	startLives = map[string]int{"easy": 5, "normal": 7, "hard": 9, "ninja": 11}
+	holeTimes  = map[string]float64{"easy": 1.3, "normal": 1.0, "hard": 0.8, "ninja": 0.6}
+)
+
+// run is GameScene for one player and no guards.
+type run struct {
+	seed, difficulty string
+	lives, maxLives  int
+	holeTime         float64
+
+	number    int
+	generated *levelgen.Level // level number, as generated
+	wait      int             // ticks until the next attempt starts
+	a         *attempt        // nil while waiting
+	levels    []Level
+	over      bool
+}
+
+func newRun(seed, difficulty string) *run {
+	lives, ok := startLives[difficulty]
+	if !ok {
+		lives = 5
+	}
+	holeTime, ok := holeTimes[difficulty]
+	if !ok {
+		holeTime = 1
+	}
+	r := &run{seed: seed, difficulty: difficulty, lives: lives, maxLives: lives + 5, holeTime: holeTime}
+	r.startLevel(1)
+	return r
+}
+
+// startLevel begins level n.
+func (r *run) startLevel(n int) {
+	l := levelgen.Generate(r.seed, r.difficulty, n)
+	r.number, r.generated = n, &l
+	r.levels = append(r.levels, Level{Number: n})
+	r.restart()
+}
+
+// restart begins a new attempt at the current level, which replaces the
+// trace of the last.
+func (r *run) restart() {
+	r.a = newAttempt(r.generated, r.holeTime)
+	r.levels[len(r.levels)-1] = Level{Number: r.number, Samples: []Sample{{0, uint8(r.a.x), uint8(r.a.y)}}}
+}
+
+// step plays one tick with input held and pressed newly down.
+func (r *run) step(input, pressed replay.Input) {
+	if r.a == nil {
+		if r.wait--; r.wait <= 0 {
+			if r.levels[len(r.levels)-1].Cleared {
+				r.startLevel(r.number + 1)
+			} else {
+				r.restart()
+			}
+		}
+		return
+	}
+	a, cur := r.a, &r.levels[len(r.levels)-1]
+	x, y, moving := a.x, a.y, a.progress > 0
+	a.update(input, pressed)
+	a.tick++
+	if a.x != x || a.y != y || a.progress > 0 && !moving {
+		cur.Samples = append(cur.Samples, Sample{uint32(a.tick), uint8(a.x), uint8(a.y)})
+	}
+	cur.Ticks = a.tick
+
+	if a.updateHoles() {
+		r.a, r.wait = nil, deathDelay
+		if r.lives--; r.lives <= 0 {
+			r.over = true
+		}
+		return
+	}
+	if a.gold == 0 && a.y == 0 {
+		if t := a.grid.At(a.x, 0); t == level.ExitLadder || t == level.Ladder {
+			cur.Cleared = true
+			r.a, r.wait = nil, winDelay
+			r.lives = min(r.lives+1, r.maxLives)
+		}
+	}
+}
+
+// state is the player's, as PlayerState; only the states movement depends
+// on are told apart.
+type state int
+
+const (
+	idle state = iota
+	moving
+	falling
+	digging
+	dead
+)
+
+type hole struct {
+	x, y   int
+	timer  float64 // milliseconds until it fills
+	filled level.Tile
+}
+
+// attempt is one try at a level: the level as play changes it, and
+// Player.
+type attempt struct {
+	grid     level.Grid
+	exits    []levelgen.Point
+	gold     int
+	holes    []hole
+	holeTime float64
+	tick     int64
+
+	x, y, tx, ty int
+	state        state
+	progress     float64 // of the move to tx, ty
+	digTimer     float64
+	digX, digY   int
+	queuedDig    int
+}
+
+func newAttempt(l *levelgen.Level, holeTime float64) *attempt {
+	a := &attempt{grid: *l.Grid, exits: l.ExitLadders, gold: l.Grid.Count(level.Gold), holeTime: holeTime}
+	a.x, a.y = l.Player.X, l.Player.Y
+	a.tx, a.ty = a.x, a.y
+	return a
+}
+
+func (a *attempt) isSolid(x, y int) bool {
+	switch a.grid.At(x, y) {
+	case level.Brick, level.Solid, level.Trap:
+		return true
+	}
+	return false
+}
+
+func (a *attempt) isSupport(x, y int) bool {
+	switch a.grid.At(x, y) {
+	case level.Brick, level.Solid, level.Ladder, level.ExitLadder:
+		return true
+	}
+	return false
+}
+
+func (a *attempt) isClimbable(x, y int) bool {
+	t := a.grid.At(x, y)
+	return t == level.Ladder || t == level.ExitLadder
+}
+
+func (a *attempt) isBar(x, y int) bool { return a.grid.At(x, y) == level.Pole }
+
+func (a *attempt) canDig(x, y int) bool {
+	if t := a.grid.At(x, y); t != level.Brick && t != level.Trap {
+		return false
+	}
+	return y <= 0 || !a.isClimbable(x, y-1)
+}
+
+// update is Player.update for one tick.
+func (a *attempt) update(input, pressed replay.Input) {
+	if a.state == dead {
+		return
+	}
+	if a.progress == 0 {
+		a.collect()
+	}
+	const dt = tickMillis / 1000
+	if a.state == digging {
+		if a.digTimer -= tickMillis; a.digTimer <= 0 {
+			a.dig(a.digX, a.digY)
+			a.state = idle
+		}
+		return
+	}
+
+	if a.shouldFall() {
+		a.state = falling
+		a.queuedDig = 0
+	}
+	if a.state == falling {
+		if a.progress += fallSpeed * dt / tileSize; a.progress >= 1 {
+			a.progress = 0
+			a.y = a.ty
+			a.ty++
+			a.collect()
+			if !a.shouldFall() {
+				a.state = idle
+				a.ty = a.y
+			}
+		}
+		return
+	}
+
+	// A dig waits for the player to reach the next cell.
+	if pressed&replay.DigLeft != 0 {
+		a.queuedDig = -1
+	} else if pressed&replay.DigRight != 0 {
+		a.queuedDig = 1
+	}
+	if a.progress == 0 && a.queuedDig != 0 {
+		dir := a.queuedDig
+		a.queuedDig = 0
+		if a.tryDig(dir) {
+			return
+		}
+	}
+	if a.progress == 0 {
+		switch {
+		case input&replay.Left != 0:
+			a.tryMove(-1, 0)
+		case input&replay.Right != 0:
+			a.tryMove(1, 0)
+		case input&replay.Up != 0:
+			a.tryMove(0, -1)
+		case input&replay.Down != 0:
+			a.tryMove(0, 1)
+		}
+	}
+	if a.progress > 0 {
+		if a.progress += baseSpeed * dt / tileSize; a.progress >= 1 {
+			a.progress = 0
+			a.x, a.y = a.tx, a.ty
+			a.state = idle
+			a.collect()
+		}
+	}
+}
+
+func (a *attempt) shouldFall() bool {
+	return !a.isClimbable(a.x, a.y) && !a.isBar(a.x, a.y) && a.y+1 < level.Height && !a.isSupport(a.x, a.y+1)
+}
+
+func (a *attempt) tryMove(dx, dy int) {
+	nx, ny := a.x+dx, a.y+dy
+	if nx < 0 || nx >= level.Width || ny < 0 || ny >= level.Height || a.isSolid(nx, ny) {
+		return
+	}
+	onLadder, onBar := a.isClimbable(a.x, a.y), a.isBar(a.x, a.y)
+	switch {
+	case dy < 0 && !onLadder:
+		return
+	case dy > 0 && onBar:
+		// Let go of the bar.
+		a.state = falling
+		a.ty = ny
+		a.progress = 0.01
+		return
+	case dy > 0 && !onLadder && !a.isClimbable(nx, ny) && a.grid.At(nx, ny) != dug:
+		return
+	case dx != 0 && !onLadder && !onBar && !a.isSupport(a.x, a.y+1):
+		return
+	}
+	a.state = moving
+	a.tx, a.ty = nx, ny
+	a.progress = 0.01
+}
+
+func (a *attempt) tryDig(dir int) bool {
+	if !a.isClimbable(a.x, a.y) && !a.isSupport(a.x, a.y+1) {
+		return false
+	}
+	x, y := a.x+dir, a.y+1
+	if !a.canDig(x, y) {
+		return false
+	}
+	a.state = digging
+	a.digTimer = digFrames * (1000.0 / 60)
+	a.digX, a.digY = x, y
+	return true
+}
+
+func (a *attempt) dig(x, y int) {
+	if !a.canDig(x, y) {
+		return
+	}
+	a.holes = append(a.holes, hole{x, y, holeMillis * a.holeTime, a.grid.At(x, y)})
+	a.grid.Set(x, y, dug)
+}
+
+// updateHoles fills holes whose time is up, and reports whether one
+// filled on the player.
+func (a *attempt) updateHoles() (died bool) {
+	open := a.holes[:0]
+	for _, h := range a.holes {
+		if h.timer -= tickMillis; h.timer > 0 {
+			open = append(open, h)
+			continue
+		}
+		a.grid.Set(h.x, h.y, h.filled)
+		if a.x == h.x && a.y == h.y {
+			a.state = dead
+			died = true
+		}
+	}
+	a.holes = open
+	return died
+}
+
+// collect picks up gold where the player is, letting any above it fall,
+// and shows the exit ladder once the last is in.
+func (a *attempt) collect() {
+	if a.grid.At(a.x, a.y) != level.Gold {
+		return
+	}
+	a.grid.Set(a.x, a.y, level.Empty)
+	a.dropGold(a.x, a.y-1)
+	if a.gold--; a.gold == 0 {
+		for _, p := range a.exits {
+			a.grid.Set(p.X, p.Y, level.ExitLadder)
+		}
+	}
+}
+
+// dropGold lets gold at x, y fall to what's below it, and any stacked on
+// top of it after, as GameScene.checkFloatingGold.
+func (a *attempt) dropGold(x, y int) {
+	if y < 0 || y >= level.Height || a.grid.At(x, y) != level.Gold {
+		return
+	}
+	to := y
+	for cy := y + 1; cy < level.Height; cy++ {
+		if t := a.grid.At(x, cy); t == level.Brick || t == level.Solid || t == level.Trap ||
+			t == level.Ladder || t == level.ExitLadder || t == level.Gold {
+			to = cy - 1
+			break
+		}
+		if cy == level.Height-1 {
+			to = cy
+			break
+		}
+	}
+	if to != y {
+		a.grid.Set(x, y, level.Empty)
+		a.grid.Set(x, to, level.Gold)
+		a.dropGold(x, y-1)
+	}
+}
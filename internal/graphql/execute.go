@@ -0,0 +1,193 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// object is a response object, keeping its fields in query order.
+type object []objectField
+
+type objectField struct {
+	key   string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// executor runs an operation. Fields resolve one at a time, in order, so
+// mutations run serially as the spec requires and queries do the same.
+type executor struct {
+	*Operation
+	errors []*Error
+}
+
+// Execute runs the operation. Resolver errors become field errors in the
+// response, with the rest of the data intact where the schema allows.
+func (o *Operation) Execute(ctx context.Context) *Response {
+	e := &executor{Operation: o}
+	root := o.s.query
+	if o.Mutation() {
+		root = o.s.mutation
+	}
+	data, _ := e.selectionSet(ctx, root, nil, o.op.sel, nil)
+	resp := &Response{Data: data, Errors: e.errors}
+	if data == nil {
+		resp.Data = json.RawMessage("null")
+	}
+	return resp
+}
+
+func (e *executor) errorf(f *field, path []any, format string, args ...any) {
+	e.errors = append(e.errors, &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{f.loc},
+		Path:      append([]any(nil), path...),
+	})
+}
+
+// collect groups sel's fields by response key, in order, following
+// fragments and leaving out skipped selections.
+func (e *executor) collect(sel []selection, keys []string, groups map[string][]*field, seen map[string]bool) []string {
+	for _, s := range sel {
+		if e.skipped[s] {
+			continue
+		}
+		switch s := s.(type) {
+		case *field:
+			key := s.responseKey()
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], s)
+		case *fragmentSpread:
+			if seen[s.name] {
+				continue
+			}
+			seen[s.name] = true
+			keys = e.collect(e.doc.fragments[s.name].sel, keys, groups, seen)
+		case *inlineFragment:
+			keys = e.collect(s.sel, keys, groups, seen)
+		}
+	}
+	return keys
+}
+
+// selectionSet resolves sel on source, an object of type t. It returns
+// nil, with failed set, when a non-null field came back null.
+func (e *executor) selectionSet(ctx context.Context, t *Object, source any, sel []selection, path []any) (result object, failed bool) {
+	groups := map[string][]*field{}
+	keys := e.collect(sel, nil, groups, map[string]bool{})
+	result = make(object, 0, len(keys))
+	for _, key := range keys {
+		fields := groups[key]
+		f := fields[0]
+		fieldPath := append(path, key)
+		if f.name == "__typename" {
+			result = append(result, objectField{key, t.Name})
+			continue
+		}
+		def := e.s.field(t, f.name)
+		var val any
+		failed := false
+		if ctx.Err() != nil {
+			e.errorf(f, fieldPath, "%s", ctx.Err())
+			failed = true
+		} else if v, err := def.Resolve(ctx, source, e.args[f]); err != nil {
+			e.errorf(f, fieldPath, "%s", err)
+			failed = true
+		} else {
+			val, failed = e.complete(ctx, def.Type, fields, v, fieldPath)
+		}
+		if val == nil && isNonNull(def.Type) {
+			if !failed {
+				e.errorf(f, fieldPath, "Cannot return null for non-nullable field %s.%s.", t.Name, f.name)
+			}
+			return nil, true
+		}
+		result = append(result, objectField{key, val})
+	}
+	return result, false
+}
+
+// complete turns a resolved value into its response form. It reports
+// failed when the value is null because of an error already recorded.
+func (e *executor) complete(ctx context.Context, t Type, fields []*field, v any, path []any) (any, bool) {
+	if nn, ok := t.(*NonNull); ok {
+		t = nn.Of
+	}
+	if isNil(v) {
+		return nil, false
+	}
+	f := fields[0]
+	switch t := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.errorf(f, path, "Expected a list for field %q, got %T.", f.name, v)
+			return nil, true
+		}
+		out := make([]any, rv.Len())
+		for i := range out {
+			item, failed := e.complete(ctx, t.Of, fields, rv.Index(i).Interface(), append(path, i))
+			if item == nil && isNonNull(t.Of) {
+				if !failed {
+					e.errorf(f, append(path, i), "Cannot return null for a non-null item of field %q.", f.name)
+				}
+				return nil, true
+			}
+			out[i] = item
+		}
+		return out, false
+	case *Scalar:
+		out, err := t.Serialize(v)
+		if err != nil {
+			e.errorf(f, path, "%s", err)
+			return nil, true
+		}
+		return out, false
+	case *Enum:
+		name, ok := v.(string)
+		if !ok || !t.has(name) {
+			e.errorf(f, path, "%s has no value %v", t.Name, v)
+			return nil, true
+		}
+		return name, false
+	case *Object:
+		var sel []selection
+		for _, f := range fields {
+			sel = append(sel, f.sel...)
+		}
+		obj, failed := e.selectionSet(ctx, t, v, sel, path)
+		if failed {
+			return nil, true
+		}
+		return obj, false
+	}
+	e.errorf(f, path, "Field %q has an unknown type %s.", f.name, t)
+	return nil, true
+}
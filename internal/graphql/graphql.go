@@ -0,0 +1,490 @@
+// Package graphql is a small GraphQL server for the community API:
+// object, enum and scalar types with Go resolvers, queries and mutations
+// with variables, fragments and @skip/@include, introspection, and depth
+// and complexity limits checked before anything runs. There are no
+// interfaces, unions, input objects or subscriptions; the game's schema
+// doesn't need them.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// A Type is a *Scalar, *Enum, *Object, *List or *NonNull.
+type Type interface {
+	String() string
+	isType()
+}
+
+// A Scalar is a leaf value.
+type Scalar struct {
+	Name        string
+	Description string
+	// Serialize converts a resolved value to its JSON form.
+	Serialize func(v any) (any, error)
+	// Parse converts an argument or variable to the value resolvers get.
+	// It sees int64, float64, string and bool from literals, and the same
+	// or float64 from JSON variables. A nil Parse makes the scalar
+	// output-only.
+	Parse func(v any) (any, error)
+}
+
+// An Enum is a string from a fixed set. Resolvers return the value's name
+// and arguments get it as a string.
+type Enum struct {
+	Name        string
+	Description string
+	Values      []EnumValue
+}
+
+type EnumValue struct {
+	Name        string
+	Description string
+}
+
+// An Object has fields, each resolved from the object's Go value.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+
+	fields map[string]*Field
+}
+
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Arg
+	// Resolve returns the field's value given its parent's, which is nil
+	// for the root types. An error becomes a field error; its message is
+	// shown to the client.
+	Resolve func(ctx context.Context, source any, args Args) (any, error)
+	// Cost is what the field adds to a query's complexity, 1 if zero. A
+	// field with a limit argument multiplies its selections' cost by the
+	// limit.
+	Cost int
+}
+
+type Arg struct {
+	Name        string
+	Description string
+	Type        Type
+	// Default is the value, as Parse returns it, when the argument is
+	// left out; nil for none.
+	Default any
+}
+
+// Args are a field's arguments, coerced to their types. Arguments left out
+// without a default are missing.
+type Args map[string]any
+
+func (a Args) Int(name string) int {
+	n, _ := a[name].(int)
+	return n
+}
+
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// A List is a list of Of.
+type List struct {
+	Of Type
+}
+
+// A NonNull is an Of that is never null.
+type NonNull struct {
+	Of Type
+}
+
+func NewList(of Type) *List       { return &List{Of: of} }
+func NewNonNull(of Type) *NonNull { return &NonNull{Of: of} }
+
+func (t *Scalar) String() string  { return t.Name }
+func (t *Enum) String() string    { return t.Name }
+func (t *Object) String() string  { return t.Name }
+func (t *List) String() string    { return "[" + t.Of.String() + "]" }
+func (t *NonNull) String() string { return t.Of.String() + "!" }
+
+func (*Scalar) isType()  {}
+func (*Enum) isType()    {}
+func (*Object) isType()  {}
+func (*List) isType()    {}
+func (*NonNull) isType() {}
+
+// named returns the named type under any lists and non-nulls.
+func named(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *List:
+			t = w.Of
+		case *NonNull:
+			t = w.Of
+		default:
+			return t
+		}
+	}
+}
+
+func (t *Enum) has(name string) bool {
+	for _, v := range t.Values {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The built-in scalars.
+var (
+	Int = &Scalar{
+		Name:        "Int",
+		Description: "A signed 32-bit integer.",
+		Serialize: func(v any) (any, error) {
+			n, ok := toInt64(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %v", v)
+			}
+			return n, nil
+		},
+		Parse: func(v any) (any, error) {
+			n, ok := toInt64(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %s", inputString(v))
+			}
+			return int(n), nil
+		},
+	}
+	Float = &Scalar{
+		Name:        "Float",
+		Description: "A double-precision floating-point number.",
+		Serialize: func(v any) (any, error) {
+			f, ok := toFloat64(v)
+			if !ok || math.IsInf(f, 0) || math.IsNaN(f) {
+				return nil, fmt.Errorf("Float cannot represent %v", v)
+			}
+			return f, nil
+		},
+		Parse: func(v any) (any, error) {
+			f, ok := toFloat64(v)
+			if !ok {
+				return nil, fmt.Errorf("Float cannot represent %s", inputString(v))
+			}
+			return f, nil
+		},
+	}
+	String = &Scalar{
+		Name:        "String",
+		Description: "UTF-8 text.",
+		Serialize: func(v any) (any, error) {
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case fmt.Stringer:
+				return v.String(), nil
+			}
+			return nil, fmt.Errorf("String cannot represent %v", v)
+		},
+		Parse: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent %s", inputString(v))
+		},
+	}
+	Boolean = &Scalar{
+		Name:        "Boolean",
+		Description: "true or false.",
+		Serialize: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		},
+		Parse: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %s", inputString(v))
+		},
+	}
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "A unique identifier, serialised as a string.",
+		Serialize: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt64(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %v", v)
+		},
+		Parse: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt64(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", inputString(v))
+		},
+	}
+)
+
+func toInt64(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	n, ok := toInt64(v)
+	return float64(n), ok
+}
+
+// inputString formats an input value for an error message.
+func inputString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case enumValue:
+		return string(v)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+// A Schema is the types a server exposes, from its query and mutation
+// roots.
+type Schema struct {
+	query    *Object
+	mutation *Object // nil for none
+	types    map[string]Type
+	names    []string // sorted
+
+	schemaField, typeField *Field
+}
+
+// NewSchema checks the types reachable from query and mutation, which may
+// be nil, and returns a schema for them.
+func NewSchema(query, mutation *Object) (*Schema, error) {
+	s := &Schema{query: query, mutation: mutation, types: map[string]Type{}}
+	roots := []Type{query, schemaType, String, Boolean}
+	if mutation != nil {
+		roots = append(roots, mutation)
+	}
+	for _, t := range roots {
+		if err := s.add(t); err != nil {
+			return nil, err
+		}
+	}
+	for name := range s.types {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+
+	s.schemaField = &Field{
+		Name:        "__schema",
+		Description: "The schema's types and directives.",
+		Type:        NewNonNull(schemaType),
+		Resolve:     func(context.Context, any, Args) (any, error) { return s, nil },
+	}
+	s.typeField = &Field{
+		Name:        "__type",
+		Description: "The type with this name, if there is one.",
+		Type:        typeType,
+		Args:        []*Arg{{Name: "name", Type: NewNonNull(String)}},
+		Resolve: func(_ context.Context, _ any, args Args) (any, error) {
+			if t, ok := s.types[args.String("name")]; ok {
+				return t, nil
+			}
+			return nil, nil
+		},
+	}
+	return s, nil
+}
+
+// add records t and the types its fields use.
+func (s *Schema) add(t Type) error {
+	t = named(t)
+	var name string
+	switch t := t.(type) {
+	case *Scalar:
+		name = t.Name
+	case *Enum:
+		name = t.Name
+	case *Object:
+		name = t.Name
+	default:
+		return fmt.Errorf("graphql: %T is not a type", t)
+	}
+	if old, ok := s.types[name]; ok {
+		if old != t {
+			return fmt.Errorf("graphql: two types are named %s", name)
+		}
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("graphql: a %T has no name", t)
+	}
+	s.types[name] = t
+	obj, ok := t.(*Object)
+	if !ok {
+		return nil
+	}
+	obj.fields = make(map[string]*Field, len(obj.Fields))
+	for _, f := range obj.Fields {
+		if _, dup := obj.fields[f.Name]; dup || f.Name == "" {
+			return fmt.Errorf("graphql: %s has a duplicate or unnamed field %q", name, f.Name)
+		}
+		if f.Resolve == nil {
+			return fmt.Errorf("graphql: %s.%s has no resolver", name, f.Name)
+		}
+		obj.fields[f.Name] = f
+		if err := s.add(f.Type); err != nil {
+			return err
+		}
+		for _, a := range f.Args {
+			if !isInput(a.Type) {
+				return fmt.Errorf("graphql: %s.%s(%s:) is not an input type", name, f.Name, a.Name)
+			}
+			if err := s.add(a.Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isInput reports whether arguments and variables can have type t.
+func isInput(t Type) bool {
+	switch t := named(t).(type) {
+	case *Scalar:
+		return t.Parse != nil
+	case *Enum:
+		return true
+	}
+	return false
+}
+
+// field returns t's field with this name, including the meta-fields, or
+// nil.
+func (s *Schema) field(t *Object, name string) *Field {
+	if t == s.query {
+		switch name {
+		case "__schema":
+			return s.schemaField
+		case "__type":
+			return s.typeField
+		}
+	}
+	return t.fields[name]
+}
+
+// Request is a GraphQL request as clients post it.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is a GraphQL response. Data is missing if the request failed
+// before it ran.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// An Error is a GraphQL error, from parsing, validation or a resolver.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Limits bound the queries a schema runs. Zero means no limit.
+type Limits struct {
+	// MaxDepth is how deeply fields may nest. Introspection's ofType
+	// chains don't count.
+	MaxDepth int
+	// MaxComplexity is the most a query may cost: the sum of its fields'
+	// costs, multiplied through limit arguments. Introspection is free.
+	MaxComplexity int
+}
+
+// An Operation is a parsed and validated request, ready to run.
+type Operation struct {
+	s       *Schema
+	doc     *document
+	op      *operation
+	vars    map[string]any
+	args    map[*field]Args
+	skipped map[selection]bool
+	// Cost is the operation's complexity.
+	Cost int
+}
+
+// Prepare parses req and checks it against the schema and limits.
+func (s *Schema) Prepare(req Request, limits Limits) (*Operation, *Error) {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, asError(err)
+	}
+	return s.validate(doc, req, limits)
+}
+
+// Mutation reports whether the operation is a mutation.
+func (o *Operation) Mutation() bool {
+	return o.op.kind == "mutation"
+}
+
+func asError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error()}
+}
+
+// isNil reports whether a resolved value is null, including typed nils.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The introspection types, as the spec defines them, so GraphiQL and code
+// generators can read the schema. Their sources are *Schema, Type,
+// *Field, *Arg, EnumValue and *directiveDef.
+var (
+	schemaType     = &Object{Name: "__Schema", Description: "A GraphQL service's types and directives."}
+	typeType       = &Object{Name: "__Type", Description: "A type: a named type, or a list or non-null wrapper."}
+	fieldType      = &Object{Name: "__Field", Description: "A field of an object type."}
+	inputValueType = &Object{Name: "__InputValue", Description: "An argument."}
+	enumValueType  = &Object{Name: "__EnumValue", Description: "One of an enum's values."}
+	directiveType  = &Object{Name: "__Directive", Description: "A directive a query may use."}
+
+	typeKindType = &Enum{Name: "__TypeKind", Description: "What kind of type a __Type is.", Values: enumValues(
+		"SCALAR", "OBJECT", "INTERFACE", "UNION", "ENUM", "INPUT_OBJECT", "LIST", "NON_NULL")}
+	directiveLocationType = &Enum{Name: "__DirectiveLocation", Description: "Where a directive may appear.", Values: enumValues(
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT",
+		"VARIABLE_DEFINITION", "SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE",
+		"UNION", "ENUM", "ENUM_VALUE", "INPUT_OBJECT", "INPUT_FIELD_DEFINITION")}
+)
+
+func enumValues(names ...string) []EnumValue {
+	values := make([]EnumValue, len(names))
+	for i, name := range names {
+		values[i] = EnumValue{Name: name}
+	}
+	return values
+}
+
+type directiveDef struct {
+	name, description string
+	locations         []string
+	args              []*Arg
+}
+
+var directives = []*directiveDef{
+	{"include", "Includes the selection only if the argument is true.", []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, []*Arg{{Name: "if", Description: "Included when true.", Type: NewNonNull(Boolean)}}},
+	{"skip", "Leaves the selection out if the argument is true.", []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"}, []*Arg{{Name: "if", Description: "Skipped when true.", Type: NewNonNull(Boolean)}}},
+}
+
+// resolver adapts a function of a field's source.
+func resolver[T any](get func(T) any) func(context.Context, any, Args) (any, error) {
+	return func(_ context.Context, source any, _ Args) (any, error) {
+		return get(source.(T)), nil
+	}
+}
+
+// optional returns s, or nil when it's empty.
+func optional(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var includeDeprecated = []*Arg{{Name: "includeDeprecated", Type: Boolean, Default: false}}
+
+func init() {
+	schemaType.Fields = []*Field{
+		{Name: "description", Type: String, Resolve: resolver(func(*Schema) any { return nil })},
+		{Name: "types", Type: NewNonNull(NewList(NewNonNull(typeType))), Resolve: resolver(func(s *Schema) any {
+			types := make([]Type, len(s.names))
+			for i, name := range s.names {
+				types[i] = s.types[name]
+			}
+			return types
+		})},
+		{Name: "queryType", Type: NewNonNull(typeType), Resolve: resolver(func(s *Schema) any { return s.query })},
+		{Name: "mutationType", Type: typeType, Resolve: resolver(func(s *Schema) any {
+			if s.mutation == nil {
+				return nil
+			}
+			return s.mutation
+		})},
+		{Name: "subscriptionType", Type: typeType, Resolve: resolver(func(*Schema) any { return nil })},
+		{Name: "directives", Type: NewNonNull(NewList(NewNonNull(directiveType))), Resolve: resolver(func(*Schema) any { return directives })},
+	}
+
+	typeType.Fields = []*Field{
+		{Name: "kind", Type: NewNonNull(typeKindType), Resolve: resolver(func(t Type) any {
+			switch t.(type) {
+			case *Scalar:
+				return "SCALAR"
+			case *Enum:
+				return "ENUM"
+			case *Object:
+				return "OBJECT"
+			case *List:
+				return "LIST"
+			}
+			return "NON_NULL"
+		})},
+		{Name: "name", Type: String, Resolve: resolver(func(t Type) any {
+			switch t.(type) {
+			case *List, *NonNull:
+				return nil
+			}
+			return t.String()
+		})},
+		{Name: "description", Type: String, Resolve: resolver(func(t Type) any {
+			switch t := t.(type) {
+			case *Scalar:
+				return optional(t.Description)
+			case *Enum:
+				return optional(t.Description)
+			case *Object:
+				return optional(t.Description)
+			}
+			return nil
+		})},
+		{Name: "specifiedByURL", Type: String, Resolve: resolver(func(Type) any { return nil })},
+		{Name: "fields", Type: NewList(NewNonNull(fieldType)), Args: includeDeprecated, Resolve: resolver(func(t Type) any {
+			if obj, ok := t.(*Object); ok {
+				return obj.Fields
+			}
+			return nil
+		})},
+		{Name: "interfaces", Type: NewList(NewNonNull(typeType)), Resolve: resolver(func(t Type) any {
+			if _, ok := t.(*Object); ok {
+				return []Type{}
+			}
+			return nil
+		})},
+		{Name: "possibleTypes", Type: NewList(NewNonNull(typeType)), Resolve: resolver(func(Type) any { return nil })},
+		{Name: "enumValues", Type: NewList(NewNonNull(enumValueType)), Args: includeDeprecated, Resolve: resolver(func(t Type) any {
+			if e, ok := t.(*Enum); ok {
+				return e.Values
+			}
+			return nil
+		})},
+		{Name: "inputFields", Type: NewList(NewNonNull(inputValueType)), Args: includeDeprecated, Resolve: resolver(func(Type) any { return nil })},
+		{Name: "ofType", Type: typeType, Resolve: resolver(func(t Type) any {
+			switch t := t.(type) {
+			case *List:
+				return t.Of
+			case *NonNull:
+				return t.Of
+			}
+			return nil
+		})},
+	}
+
+	fieldType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: resolver(func(f *Field) any { return f.Name })},
+		{Name: "description", Type: String, Resolve: resolver(func(f *Field) any { return optional(f.Description) })},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: resolver(func(f *Field) any {
+			if f.Args == nil {
+				return []*Arg{}
+			}
+			return f.Args
+		})},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: resolver(func(f *Field) any { return f.Type })},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: resolver(func(*Field) any { return false })},
+		{Name: "deprecationReason", Type: String, Resolve: resolver(func(*Field) any { return nil })},
+	}
+
+	inputValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: resolver(func(a *Arg) any { return a.Name })},
+		{Name: "description", Type: String, Resolve: resolver(func(a *Arg) any { return optional(a.Description) })},
+		{Name: "type", Type: NewNonNull(typeType), Resolve: resolver(func(a *Arg) any { return a.Type })},
+		{Name: "defaultValue", Type: String, Resolve: resolver(func(a *Arg) any {
+			if a.Default == nil {
+				return nil
+			}
+			return printValue(a.Type, a.Default)
+		})},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: resolver(func(*Arg) any { return false })},
+		{Name: "deprecationReason", Type: String, Resolve: resolver(func(*Arg) any { return nil })},
+	}
+
+	enumValueType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: resolver(func(v EnumValue) any { return v.Name })},
+		{Name: "description", Type: String, Resolve: resolver(func(v EnumValue) any { return optional(v.Description) })},
+		{Name: "isDeprecated", Type: NewNonNull(Boolean), Resolve: resolver(func(EnumValue) any { return false })},
+		{Name: "deprecationReason", Type: String, Resolve: resolver(func(EnumValue) any { return nil })},
+	}
+
+	directiveType.Fields = []*Field{
+		{Name: "name", Type: NewNonNull(String), Resolve: resolver(func(d *directiveDef) any { return d.name })},
+		{Name: "description", Type: String, Resolve: resolver(func(d *directiveDef) any { return d.description })},
+		{Name: "locations", Type: NewNonNull(NewList(NewNonNull(directiveLocationType))), Resolve: resolver(func(d *directiveDef) any { return d.locations })},
+		{Name: "args", Type: NewNonNull(NewList(NewNonNull(inputValueType))), Args: includeDeprecated, Resolve: resolver(func(d *directiveDef) any { return d.args })},
+		{Name: "isRepeatable", Type: NewNonNull(Boolean), Resolve: resolver(func(*directiveDef) any { return false })},
+	}
+}
+
+// printValue writes an argument's default as a GraphQL literal.
+func printValue(t Type, v any) string {
+	if nn, ok := t.(*NonNull); ok {
+		t = nn.Of
+	}
+	switch t := t.(type) {
+	case *List:
+		items, _ := v.([]any)
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = printValue(t.Of, item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *Enum:
+		return fmt.Sprint(v)
+	}
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,452 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validator checks an operation against the schema: fields, arguments,
+// fragments and variables. It coerces every argument as it goes, so the
+// executor and the complexity count see the same values.
+type validator struct {
+	s       *Schema
+	doc     *document
+	vars    map[string]any
+	defined map[string]bool
+	args    map[*field]Args
+	skipped map[selection]bool
+	// spreading is the fragments being spread, to catch cycles; costs
+	// remembers the ones already walked.
+	spreading map[string]bool
+	costs     map[fragmentKey]walk
+}
+
+type fragmentKey struct {
+	name  string
+	intro bool
+}
+
+// walk is what a selection set adds up to.
+type walk struct {
+	cost, depth int
+}
+
+func errorAt(loc Location, format string, args ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+func (s *Schema) validate(doc *document, req Request, limits Limits) (*Operation, *Error) {
+	op, verr := pickOperation(doc, req.OperationName)
+	if verr != nil {
+		return nil, verr
+	}
+	root := s.query
+	switch op.kind {
+	case "mutation":
+		if s.mutation == nil {
+			return nil, errorAt(op.loc, "The schema has no mutations.")
+		}
+		root = s.mutation
+	case "subscription":
+		return nil, errorAt(op.loc, "Subscriptions aren't supported.")
+	}
+
+	v := &validator{
+		s:         s,
+		doc:       doc,
+		vars:      map[string]any{},
+		defined:   map[string]bool{},
+		args:      map[*field]Args{},
+		skipped:   map[selection]bool{},
+		spreading: map[string]bool{},
+		costs:     map[fragmentKey]walk{},
+	}
+	if err := v.variables(op, req.Variables); err != nil {
+		return nil, err
+	}
+	if _, err := v.skip(op.directives); err != nil {
+		return nil, err
+	}
+	w, err := v.selections(root, op.sel, false)
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxDepth > 0 && w.depth > limits.MaxDepth {
+		return nil, errorAt(op.loc, "Query is too deep: it nests %d levels and at most %d are allowed.", w.depth, limits.MaxDepth)
+	}
+	if limits.MaxComplexity > 0 && w.cost > limits.MaxComplexity {
+		return nil, errorAt(op.loc, "Query is too complex: it costs %d and at most %d is allowed.", w.cost, limits.MaxComplexity)
+	}
+	return &Operation{s: s, doc: doc, op: op, vars: v.vars, args: v.args, skipped: v.skipped, Cost: w.cost}, nil
+}
+
+func pickOperation(doc *document, name string) (*operation, *Error) {
+	names := map[string]bool{}
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			return nil, errorAt(op.loc, "An anonymous operation must be the only operation in the document.")
+		}
+		if names[op.name] {
+			return nil, errorAt(op.loc, "There can be only one operation named %q.", op.name)
+		}
+		names[op.name] = true
+	}
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, &Error{Message: "Must provide operationName if the query contains multiple operations."}
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+// variables coerces the request's variables to their declared types.
+func (v *validator) variables(op *operation, given map[string]any) *Error {
+	for _, d := range op.vars {
+		if v.defined[d.name] {
+			return errorAt(d.loc, "There can be only one variable named \"$%s\".", d.name)
+		}
+		v.defined[d.name] = true
+		t, err := v.inputType(d.typ)
+		if err != nil {
+			return errorAt(d.loc, "%s", err)
+		}
+		raw, ok := given[d.name]
+		switch {
+		case ok:
+			val, err := coerceInput(t, raw, false)
+			if err != nil {
+				return errorAt(d.loc, "Variable \"$%s\" got an invalid value: %s.", d.name, err)
+			}
+			v.vars[d.name] = val
+		case d.def != nil:
+			val, err := v.literal(t, d.def)
+			if err != nil {
+				return errorAt(d.loc, "Variable \"$%s\" has an invalid default: %s.", d.name, err)
+			}
+			v.vars[d.name] = val
+		case d.typ.nonNull:
+			return errorAt(d.loc, "Variable \"$%s\" of required type %q was not provided.", d.name, d.typ.String())
+		}
+	}
+	return nil
+}
+
+func (v *validator) inputType(ref typeRef) (Type, error) {
+	var t Type
+	if ref.elem != nil {
+		elem, err := v.inputType(*ref.elem)
+		if err != nil {
+			return nil, err
+		}
+		t = NewList(elem)
+	} else {
+		named, ok := v.s.types[ref.name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown type %q.", ref.name)
+		}
+		if !isInput(named) {
+			return nil, fmt.Errorf("Type %q can't be used for a variable.", ref.name)
+		}
+		t = named
+	}
+	if ref.nonNull {
+		t = NewNonNull(t)
+	}
+	return t, nil
+}
+
+// skip evaluates @skip and @include.
+func (v *validator) skip(ds []*directive) (bool, *Error) {
+	skip := false
+	for _, d := range ds {
+		if d.name != "skip" && d.name != "include" {
+			return false, errorAt(d.loc, "Unknown directive \"@%s\".", d.name)
+		}
+		args, err := v.arguments(directiveArgs, d.args, d.loc, "@"+d.name)
+		if err != nil {
+			return false, err
+		}
+		if args.Bool("if") == (d.name == "skip") {
+			skip = true
+		}
+	}
+	return skip, nil
+}
+
+// selections validates sel on t and adds up its cost and depth. Inside
+// introspection nothing costs anything.
+func (v *validator) selections(t *Object, sel []selection, intro bool) (walk, *Error) {
+	var total walk
+	add := func(w walk) {
+		total.cost += w.cost
+		total.depth = max(total.depth, w.depth)
+	}
+	for _, s := range sel {
+		var ds []*directive
+		switch s := s.(type) {
+		case *field:
+			ds = s.directives
+		case *fragmentSpread:
+			ds = s.directives
+		case *inlineFragment:
+			ds = s.directives
+		}
+		skip, err := v.skip(ds)
+		if err != nil {
+			return walk{}, err
+		}
+		if skip {
+			v.skipped[s] = true
+		}
+
+		switch s := s.(type) {
+		case *field:
+			w, err := v.field(t, s, intro)
+			if err != nil {
+				return walk{}, err
+			}
+			add(w)
+		case *fragmentSpread:
+			f, ok := v.doc.fragments[s.name]
+			if !ok {
+				return walk{}, errorAt(s.loc, "Unknown fragment %q.", s.name)
+			}
+			if err := v.typeCondition(t, f.typeCond, f.loc); err != nil {
+				return walk{}, err
+			}
+			key := fragmentKey{s.name, intro}
+			w, done := v.costs[key]
+			if !done {
+				if v.spreading[s.name] {
+					return walk{}, errorAt(s.loc, "Cannot spread fragment %q within itself.", s.name)
+				}
+				v.spreading[s.name] = true
+				if w, err = v.selections(t, f.sel, intro); err != nil {
+					return walk{}, err
+				}
+				delete(v.spreading, s.name)
+				v.costs[key] = w
+			}
+			add(w)
+		case *inlineFragment:
+			if s.typeCond != "" {
+				if err := v.typeCondition(t, s.typeCond, s.loc); err != nil {
+					return walk{}, err
+				}
+			}
+			w, err := v.selections(t, s.sel, intro)
+			if err != nil {
+				return walk{}, err
+			}
+			add(w)
+		}
+	}
+	return total, nil
+}
+
+func (v *validator) typeCondition(t *Object, cond string, loc Location) *Error {
+	if _, ok := v.s.types[cond]; !ok {
+		return errorAt(loc, "Unknown type %q.", cond)
+	}
+	if cond != t.Name {
+		return errorAt(loc, "Fragment on %q can't be spread on %q.", cond, t.Name)
+	}
+	return nil
+}
+
+func (v *validator) field(t *Object, f *field, intro bool) (walk, *Error) {
+	if f.name == "__typename" {
+		if f.args != nil || f.sel != nil {
+			return walk{}, errorAt(f.loc, "Field \"__typename\" takes no arguments or selections.")
+		}
+		return walk{depth: 1}, nil
+	}
+	def := v.s.field(t, f.name)
+	if def == nil {
+		return walk{}, errorAt(f.loc, "Cannot query field %q on type %q.", f.name, t.Name)
+	}
+	args, err := v.arguments(def.Args, f.args, f.loc, f.name)
+	if err != nil {
+		return walk{}, err
+	}
+	v.args[f] = args
+
+	intro = intro || strings.HasPrefix(f.name, "__")
+	w := walk{cost: max(def.Cost, 1), depth: 1}
+	if intro {
+		w.cost = 0
+		if f.name == "ofType" {
+			w.depth = 0
+		}
+	}
+	obj, isObject := named(def.Type).(*Object)
+	switch {
+	case isObject && f.sel == nil:
+		return walk{}, errorAt(f.loc, "Field %q of type %q must have a selection of subfields.", f.name, def.Type)
+	case !isObject && f.sel != nil:
+		return walk{}, errorAt(f.loc, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+	case isObject:
+		sub, err := v.selections(obj, f.sel, intro)
+		if err != nil {
+			return walk{}, err
+		}
+		if !intro {
+			w.cost += max(args.Int("limit"), 1) * sub.cost
+		}
+		w.depth += sub.depth
+	}
+	return w, nil
+}
+
+// arguments coerces the arguments given to a field or directive.
+func (v *validator) arguments(defs []*Arg, given []*argument, loc Location, what string) (Args, *Error) {
+	args := Args{}
+	for _, a := range given {
+		var def *Arg
+		for _, d := range defs {
+			if d.Name == a.name {
+				def = d
+			}
+		}
+		if def == nil {
+			return nil, errorAt(a.loc, "Unknown argument %q on %q.", a.name, what)
+		}
+		if _, dup := args[a.name]; dup {
+			return nil, errorAt(a.loc, "There can be only one argument named %q.", a.name)
+		}
+		if name, ok := a.val.(variable); ok {
+			if !v.defined[string(name)] {
+				return nil, errorAt(a.loc, "Variable \"$%s\" is not defined.", name)
+			}
+			if _, given := v.vars[string(name)]; !given {
+				continue // as if left out
+			}
+		}
+		val, err := v.literal(def.Type, a.val)
+		if err != nil {
+			return nil, errorAt(a.loc, "Argument %q on %q has an invalid value: %s.", a.name, what, err)
+		}
+		args[a.name] = val
+	}
+	for _, d := range defs {
+		if _, ok := args[d.Name]; ok {
+			continue
+		}
+		switch {
+		case d.Default != nil:
+			args[d.Name] = d.Default
+		case isNonNull(d.Type):
+			return nil, errorAt(loc, "Argument %q of type %q is required on %q.", d.Name, d.Type, what)
+		}
+	}
+	return args, nil
+}
+
+func isNonNull(t Type) bool {
+	_, ok := t.(*NonNull)
+	return ok
+}
+
+// literal coerces a value from the query to t, substituting variables.
+func (v *validator) literal(t Type, val value) (any, error) {
+	if name, ok := val.(variable); ok {
+		if !v.defined[string(name)] {
+			return nil, fmt.Errorf("variable \"$%s\" is not defined", name)
+		}
+		return coerceInput(t, v.vars[string(name)], false)
+	}
+	if nn, ok := t.(*NonNull); ok {
+		if val == nil {
+			return nil, fmt.Errorf("expected a non-null %s", nn.Of)
+		}
+		return v.literal(nn.Of, val)
+	}
+	if val == nil {
+		return nil, nil
+	}
+	if list, ok := t.(*List); ok {
+		items, ok := val.([]value)
+		if !ok {
+			item, err := v.literal(list.Of, val)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if out[i], err = v.literal(list.Of, item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return coerceInput(t, val, true)
+}
+
+// coerceInput coerces a variable's JSON value, or a literal leaf, to t.
+// Enums take bare names as literals and strings as variables.
+func coerceInput(t Type, val any, literal bool) (any, error) {
+	switch t := t.(type) {
+	case *NonNull:
+		if val == nil {
+			return nil, fmt.Errorf("expected a non-null %s", t.Of)
+		}
+		return coerceInput(t.Of, val, literal)
+	case *List:
+		if val == nil {
+			return nil, nil
+		}
+		items, ok := val.([]any)
+		if !ok {
+			item, err := coerceInput(t.Of, val, literal)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if out[i], err = coerceInput(t.Of, item, literal); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	if val == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *Scalar:
+		if _, ok := val.(enumValue); ok {
+			return nil, fmt.Errorf("%s cannot represent %s", t.Name, inputString(val))
+		}
+		return t.Parse(val)
+	case *Enum:
+		var name string
+		switch val := val.(type) {
+		case enumValue:
+			name = string(val)
+		case string:
+			if !literal {
+				name = val
+			}
+		}
+		if !t.has(name) {
+			return nil, fmt.Errorf("%s has no value %s", t.Name, inputString(val))
+		}
+		return name, nil
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// directiveArgs are @skip's and @include's arguments.
+var directiveArgs = []*Arg{{Name: "if", Type: NewNonNull(Boolean)}}
@@ -0,0 +1,143 @@
+package level
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is the layout of a classic level pack.
+type Format string
+
+const (
+	// FormatText is levels written with the tile legend, Height rows
+	// each. A line starting with ';' is a comment; the last one before a
+	// level names it. Blank lines between levels are ignored; inside one
+	// they are empty rows.
+	FormatText Format = "text"
+
+	// FormatDAT is the binary layout the Apple II games store their
+	// levels in, which disk image tools extract the original 150 and
+	// Championship packs as: a 256-byte record per level (224-byte
+	// records are accepted too), two tiles per byte, low nibble first,
+	// row by row from the top. A record of all zeros is an unused slot.
+	FormatDAT Format = "dat"
+)
+
+// datTiles maps FormatDAT tile codes to tiles.
+var datTiles = [...]Tile{Empty, Brick, Solid, Ladder, Pole, Trap, ExitLadder, Gold, Guard, Player}
+
+// datLevelSize is the bytes of a FormatDAT record that hold tiles.
+const datLevelSize = Width * Height / 2
+
+// PackLevel is one level of a pack: its position in the pack from 1, the
+// name the pack gives it, if any, and its rows. Err is why it couldn't be
+// read; the pack's other levels still can be.
+type PackLevel struct {
+	Index int
+	Name  string
+	Rows  []string
+	Err   error
+}
+
+// DetectFormat guesses data's format: text unless it has bytes no text
+// file would.
+func DetectFormat(data []byte) Format {
+	if !utf8.Valid(data) || bytes.ContainsFunc(data, func(r rune) bool { return r < ' ' && r != '\n' && r != '\r' && r != '\t' }) {
+		return FormatDAT
+	}
+	return FormatText
+}
+
+// ReadPack splits a level pack into its levels. It fails only if data
+// isn't a pack of that format at all.
+func ReadPack(data []byte, f Format) ([]PackLevel, error) {
+	var levels []PackLevel
+	var err error
+	switch f {
+	case FormatText:
+		levels, err = readText(data)
+	case FormatDAT:
+		levels, err = readDAT(data)
+	default:
+		return nil, fmt.Errorf("unknown level format %q", f)
+	}
+	if err == nil && len(levels) == 0 {
+		err = errors.New("no levels found")
+	}
+	return levels, err
+}
+
+func readText(data []byte) ([]PackLevel, error) {
+	var levels []PackLevel
+	var name string
+	var cur *PackLevel
+	end := func() {
+		if cur != nil && len(cur.Rows) < Height {
+			cur.Err = fmt.Errorf("level has %d rows, want %d", len(cur.Rows), Height)
+		}
+		cur = nil
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, ";"):
+			end()
+			name = strings.TrimSpace(line[1:])
+		case cur == nil && strings.TrimSpace(line) == "":
+			// Every playable level's top row has its way out in it, so a
+			// blank line can't start one.
+		default:
+			if cur == nil {
+				levels = append(levels, PackLevel{Index: len(levels) + 1, Name: name})
+				cur, name = &levels[len(levels)-1], ""
+			}
+			cur.Rows = append(cur.Rows, strings.ReplaceAll(line, "\t", " "))
+			if len(cur.Rows) == Height {
+				cur = nil
+			}
+		}
+	}
+	end()
+	return levels, sc.Err()
+}
+
+func readDAT(data []byte) ([]PackLevel, error) {
+	size := 256
+	if len(data)%size != 0 {
+		size = datLevelSize
+	}
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("%d bytes isn't a whole number of 256- or %d-byte level records", len(data), datLevelSize)
+	}
+	var levels []PackLevel
+	for i := 0; i*size < len(data); i++ {
+		record := data[i*size : i*size+datLevelSize]
+		if !bytes.ContainsFunc(record, func(r rune) bool { return r != 0 }) {
+			continue
+		}
+		l := PackLevel{Index: i + 1}
+		row := make([]byte, 0, Width)
+		for n := range Width * Height {
+			code := record[n/2] & 0x0f
+			if n%2 == 1 {
+				code = record[n/2] >> 4
+			}
+			if int(code) >= len(datTiles) {
+				l.Err = fmt.Errorf("row %d col %d: unknown tile code %d", n/Width+1, n%Width+1, code)
+				break
+			}
+			row = append(row, byte(datTiles[code]))
+			if len(row) == Width {
+				l.Rows = append(l.Rows, string(row))
+				row = row[:0]
+			}
+		}
+		levels = append(levels, l)
+	}
+	return levels, nil
+}
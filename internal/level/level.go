@@ -0,0 +1,188 @@
+// Package level models Lode Runner 2099 level grids: the tile legend shared
+// with the client's level editor, parsing from text rows, and the structural
+// rules every playable level must satisfy.
+package level
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Grid dimensions, matching CONFIG.GRID_WIDTH/GRID_HEIGHT in the client.
+const (
+	Width  = 28
+	Height = 16
+)
+
+// MaxGuards matches CONFIG.MAX_ENEMIES in the client.
+const MaxGuards = 5
+
+// Tile is one cell of a level, written with the classic Lode Runner text
+// legend.
+type Tile byte
+
+const (
+	Empty      Tile = ' '
+	Brick      Tile = '#' // diggable
+	Solid      Tile = '@' // indestructible
+	Ladder     Tile = 'H'
+	Pole       Tile = '-' // hand-over-hand bar
+	Trap       Tile = 'X' // looks like brick, falls through
+	ExitLadder Tile = 'S' // appears once all gold is collected
+	Gold       Tile = '$'
+	Guard      Tile = '0'
+	Player     Tile = '&'
+)
+
+var legend = map[Tile]string{
+	Empty:      "empty",
+	Brick:      "brick",
+	Solid:      "solid brick",
+	Ladder:     "ladder",
+	Pole:       "pole",
+	Trap:       "trap brick",
+	ExitLadder: "exit ladder",
+	Gold:       "gold",
+	Guard:      "guard",
+	Player:     "player",
+}
+
+// Legend returns the tile characters and their meaning.
+func Legend() map[string]string {
+	out := make(map[string]string, len(legend))
+	for t, name := range legend {
+		out[string(t)] = name
+	}
+	return out
+}
+
+// Grid is a Width x Height level layout.
+type Grid struct {
+	cells [Height][Width]Tile
+}
+
+// Parse reads a grid from text rows. Rows shorter than Width are padded
+// with empty tiles, since editors often strip trailing spaces.
+func Parse(rows []string) (*Grid, error) {
+	if len(rows) != Height {
+		return nil, fmt.Errorf("level must have %d rows, got %d", Height, len(rows))
+	}
+	g := &Grid{}
+	for y, row := range rows {
+		if err := g.SetRow(y, row); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// SetRow reads row y, counting from 0, as Parse does, so a grid can be
+// checked a row at a time as it arrives.
+func (g *Grid) SetRow(y int, row string) error {
+	if y < 0 || y >= Height {
+		return fmt.Errorf("level must have %d rows, got more", Height)
+	}
+	if len(row) > Width {
+		return fmt.Errorf("row %d is %d tiles wide, max %d", y+1, len(row), Width)
+	}
+	for x := 0; x < Width; x++ {
+		t := Empty
+		if x < len(row) {
+			t = Tile(row[x])
+		}
+		if _, ok := legend[t]; !ok {
+			return fmt.Errorf("row %d col %d: unknown tile %q", y+1, x+1, row[x])
+		}
+		g.cells[y][x] = t
+	}
+	return nil
+}
+
+// At returns the tile at x, y. Out-of-bounds cells read as Solid, as in
+// the client.
+func (g *Grid) At(x, y int) Tile {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return Solid
+	}
+	return g.cells[y][x]
+}
+
+// Set replaces the tile at x, y; out-of-bounds writes are ignored.
+func (g *Grid) Set(x, y int, t Tile) {
+	if x >= 0 && x < Width && y >= 0 && y < Height {
+		g.cells[y][x] = t
+	}
+}
+
+// Count returns how many cells hold t.
+func (g *Grid) Count(t Tile) int {
+	n := 0
+	for y := range g.cells {
+		for _, c := range g.cells[y] {
+			if c == t {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Find returns the coordinates of every cell holding t, row by row.
+func (g *Grid) Find(t Tile) [][2]int {
+	var out [][2]int
+	for y := range g.cells {
+		for x, c := range g.cells[y] {
+			if c == t {
+				out = append(out, [2]int{x, y})
+			}
+		}
+	}
+	return out
+}
+
+// Rows returns the grid as text rows, always Width characters wide.
+func (g *Grid) Rows() []string {
+	rows := make([]string, Height)
+	for y := range g.cells {
+		rows[y] = string(g.cells[y][:])
+	}
+	return rows
+}
+
+// String returns the rows joined by newlines.
+func (g *Grid) String() string {
+	return strings.Join(g.Rows(), "\n")
+}
+
+// Validate checks the structural rules of a playable level: exactly one
+// player start, at least one piece of gold, at most MaxGuards guards and a
+// way out through the top row.
+func (g *Grid) Validate() error {
+	var problems []string
+	if n := g.Count(Player); n != 1 {
+		problems = append(problems, fmt.Sprintf("level needs exactly one player start, has %d", n))
+	}
+	if g.Count(Gold) == 0 {
+		problems = append(problems, "level needs at least one gold")
+	}
+	if n := g.Count(Guard); n > MaxGuards {
+		problems = append(problems, fmt.Sprintf("level has %d guards, max %d", n, MaxGuards))
+	}
+	if !g.hasExit() {
+		problems = append(problems, "level needs a ladder or exit ladder reaching the top row")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (g *Grid) hasExit() bool {
+	for x := 0; x < Width; x++ {
+		if t := g.At(x, 0); t == Ladder || t == ExitLadder {
+			return true
+		}
+	}
+	return false
+}
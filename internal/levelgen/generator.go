@@ -0,0 +1,773 @@
+package levelgen
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+)
+
+// generator is LevelGenerator. The steps, and the order they draw random
+// numbers in, must stay as in the client, or seeds stop agreeing.
+type generator struct {
+	rng    *random
+	d      difficulty
+	key    string // difficulty name
+	number int    // level number, from 1
+}
+
+// Attempts before settling, as in the client.
+const (
+	maxAttempts = 500
+	maxRetries  = 200
+)
+
+// exitHiddenRows are the top rows, where only the exit ladder goes.
+const exitHiddenRows = 4
+
+// generate returns a solvable level, or failing that the best candidate or
+// the fallback, with the checker's result for it.
+func (g *generator) generate() (*tileMap, result) {
+	c := &checker{maxAssistedGold: g.d.maxAssistedGold}
+	var best *tileMap
+	var bestResult result
+	bestScore := -1.0
+	try := func() (*tileMap, result, bool) {
+		m := g.candidate()
+		res := c.check(m)
+		if res.solvable {
+			return m, res, true
+		}
+		if res.score > bestScore {
+			best, bestResult, bestScore = m, res, res.score
+		}
+		return nil, result{}, false
+	}
+
+	for range maxAttempts {
+		if m, res, ok := try(); ok {
+			return m, res
+		}
+	}
+	if best != nil && bestScore >= 0.99 {
+		return best, bestResult
+	}
+	// Fresh draws for the retries.
+	for range maxRetries {
+		g.rng.next()
+		g.rng.next()
+		if m, res, ok := try(); ok {
+			return m, res
+		}
+	}
+	if best != nil && bestScore >= 0.95 {
+		return best, bestResult
+	}
+	m := fallback()
+	res := c.check(m)
+	res.enemyAssisted = nil
+	return m, res
+}
+
+func (g *generator) candidate() *tileMap {
+	m := newTileMap()
+	g.createGround(m)
+	g.createMainSpine(m)
+	g.createConnectedPlatforms(m)
+	g.addExtraLadders(m)
+	g.createPoles(m)
+	g.addHardBricks(m)
+	g.addTrapBricks(m)
+	g.ensureLadderAccessibility(m)
+	g.placePlayer(m)
+	g.placeGold(m)
+	g.placeEnemies(m)
+	g.addExitLadders(m)
+	return m
+}
+
+func (g *generator) createGround(m *tileMap) {
+	for x := range width {
+		m.Set(x, height-1, level.Brick)
+	}
+}
+
+// createMainSpine runs a ladder from the bottom to row 4, zigzagging at
+// higher complexity.
+func (g *generator) createMainSpine(m *tileMap) {
+	spineX := g.rng.intn(int(width*0.25), int(width*0.75))
+	zigzag := g.d.complexity > 0.5 && g.rng.next() < g.d.complexity
+	interval := 999
+	if zigzag {
+		interval = g.rng.intn(3, 5)
+	}
+	for y := exitHiddenRows; y < height-1; y++ {
+		m.Set(spineX, y, level.Ladder)
+		if zigzag && y > 0 && y%interval == 0 && y < height-3 {
+			shift := g.rng.intn(2, 5)
+			if g.rng.next() < 0.5 {
+				shift = -shift
+			}
+			newX := max(2, min(width-3, spineX+shift))
+			for x := min(spineX, newX); x <= max(spineX, newX); x++ {
+				if m.get(x, y) == level.Empty {
+					m.Set(x, y, level.Ladder)
+				}
+			}
+			spineX = newX
+		}
+	}
+}
+
+func (g *generator) createConnectedPlatforms(m *tileMap) {
+	rows := []int{height - 4, height - 7, height - 10, 3, height - 13}
+	n := int(math.Floor(1 + g.d.complexity*4))
+	var previous []int
+	for _, row := range rows[:min(n, len(rows))] {
+		previous = g.createPlatformWithLadders(m, row, previous)
+	}
+}
+
+// createPlatformWithLadders lays the row's platform segments, with ladders
+// down from some of them and from one near a ladder of the row before, and
+// returns the ladders' columns.
+func (g *generator) createPlatformWithLadders(m *tileMap, row int, connectFrom []int) []int {
+	var ladders []int
+	minSegments := int(math.Floor(2 + g.d.complexity))
+	maxSegments := int(math.Floor(3 + g.d.complexity*3))
+	segments := g.rng.intn(minSegments, maxSegments+1)
+	segmentWidth := width / segments
+
+	for seg := range segments {
+		startX := seg*segmentWidth + g.rng.intn(0, 3)
+		endX := min(startX+g.rng.intn(4, segmentWidth), width-1)
+		if seg > 0 && startX < seg*segmentWidth+2 {
+			continue
+		}
+		for x := startX; x <= endX; x++ {
+			m.Set(x, row, level.Brick)
+		}
+		if g.rng.next() < g.d.ladderDensity {
+			ladderX := startX + g.rng.intn(1, max(2, endX-startX-1))
+			if ladderX >= startX && ladderX <= endX {
+				g.extendLadderDown(m, ladderX, row)
+				ladders = append(ladders, ladderX)
+			}
+		}
+	}
+
+	if len(connectFrom) > 0 {
+		targetX := pick(g.rng, connectFrom)
+		// The client breaks out of the offsets only, so every distance
+		// gets its try.
+		for dx := range 5 {
+			for _, offset := range []int{dx, -dx} {
+				x := targetX + offset
+				if x >= 0 && x < width && m.get(x, row) == level.Brick {
+					g.extendLadderDown(m, x, row)
+					if !slices.Contains(ladders, x) {
+						ladders = append(ladders, x)
+					}
+					break
+				}
+			}
+		}
+	}
+	return ladders
+}
+
+// extendLadderDown runs a ladder from fromRow down to the next surface,
+// replacing the brick it lands on unless that is the ground.
+func (g *generator) extendLadderDown(m *tileMap, x, fromRow int) {
+	for y := fromRow; y < height; y++ {
+		t := m.get(x, y)
+		if t == level.Solid {
+			break
+		}
+		if t == level.Brick || t == level.Trap {
+			if y < height-1 {
+				m.Set(x, y, level.Ladder)
+			}
+			break
+		}
+		m.Set(x, y, level.Ladder)
+	}
+}
+
+func (g *generator) addExtraLadders(m *tileMap) {
+	n := int(math.Floor(g.d.ladderDensity * 10))
+	for range n {
+		x := g.rng.intn(2, width-2)
+		for y := 2; y < height-2; y++ {
+			if m.get(x, y) == level.Brick && m.get(x, y-1) == level.Empty {
+				g.extendLadderDown(m, x, y-1)
+				break
+			}
+		}
+	}
+}
+
+type span struct {
+	y, startX, endX int
+}
+
+// createPoles bridges gaps between platforms at the same height, and
+// sometimes reaches from a ladder to a platform nearby.
+func (g *generator) createPoles(m *tileMap) {
+	var platforms []span
+	for y := 1; y < height-1; y++ {
+		start := -1
+		for x := range width {
+			walkable := g.isWalkableSurface(m, x, y)
+			if walkable && start == -1 {
+				start = x
+			} else if !walkable && start != -1 {
+				platforms = append(platforms, span{y - 1, start, x - 1})
+				start = -1
+			}
+		}
+		if start != -1 {
+			platforms = append(platforms, span{y - 1, start, width - 1})
+		}
+	}
+
+	var poles []span
+	for i := range platforms {
+		for j := i + 1; j < len(platforms); j++ {
+			p1, p2 := platforms[i], platforms[j]
+			if p1.y != p2.y {
+				continue
+			}
+			left, right := p2, p1
+			if p1.endX < p2.startX {
+				left, right = p1, p2
+			}
+			gapStart, gapEnd := left.endX+1, right.startX-1
+			if n := gapEnd - gapStart + 1; n < 3 || n > 15 {
+				continue
+			}
+			clear := true
+			for x := gapStart; x <= gapEnd; x++ {
+				if t := m.get(x, p1.y); t != level.Empty && t != level.Gold {
+					clear = false
+					break
+				}
+			}
+			if !clear {
+				continue
+			}
+			// Pointless over solid ground: you could walk.
+			drop := false
+			for x := gapStart; x <= gapEnd; x++ {
+				if b := m.get(x, p1.y+1); b != level.Brick && b != level.Solid && b != level.Trap {
+					drop = true
+					break
+				}
+			}
+			if !drop {
+				continue
+			}
+			overlaps := false
+			for _, e := range poles {
+				if e.y == p1.y && !(gapEnd < e.startX || gapStart > e.endX) {
+					overlaps = true
+					break
+				}
+			}
+			if overlaps {
+				continue
+			}
+			if g.rng.chance(0.5 + g.d.complexity*0.3) {
+				for x := left.endX; x <= right.startX; x++ {
+					if m.get(x, p1.y) == level.Empty {
+						m.Set(x, p1.y, level.Pole)
+					}
+				}
+				poles = append(poles, span{p1.y, left.endX, right.startX})
+			}
+		}
+	}
+
+	for y := 2; y < height-3; y++ {
+		for x := 2; x < width-2; x++ {
+			if m.get(x, y) != level.Ladder {
+				continue
+			}
+			for _, dir := range []int{-1, 1} {
+				targetX := -1
+				for dx := 1; dx <= 8; dx++ {
+					cx := x + dir*dx
+					if cx < 0 || cx >= width {
+						break
+					}
+					if t := m.get(cx, y); t != level.Empty && t != level.Pole {
+						break
+					}
+					if b := m.get(cx, y+1); b == level.Brick || b == level.Solid || b == level.Ladder {
+						targetX = cx
+						break
+					}
+				}
+				if targetX == -1 || abs(targetX-x) < 3 {
+					continue
+				}
+				needsPole := false
+				for cx := min(x, targetX); cx <= max(x, targetX); cx++ {
+					if b := m.get(cx, y+1); b != level.Brick && b != level.Solid && b != level.Ladder {
+						needsPole = true
+						break
+					}
+				}
+				if needsPole && g.rng.chance(0.35) {
+					for fx := min(x, targetX); fx <= max(x, targetX); fx++ {
+						if m.get(fx, y) == level.Empty {
+							m.Set(fx, y, level.Pole)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// isWalkableSurface reports whether the cell can be stood on from above.
+func (g *generator) isWalkableSurface(m *tileMap, x, y int) bool {
+	if y >= height {
+		return false
+	}
+	switch m.get(x, y) {
+	case level.Brick, level.Solid, level.Trap, level.Ladder:
+		return true
+	}
+	return false
+}
+
+func (g *generator) addHardBricks(m *tileMap) {
+	for y := 1; y < height-1; y++ {
+		for x := range width {
+			if m.get(x, y) == level.Brick && g.rng.chance(0.05) {
+				m.Set(x, y, level.Solid)
+			}
+		}
+	}
+}
+
+func (g *generator) addTrapBricks(m *tileMap) {
+	for y := 1; y < height-1; y++ {
+		for x := range width {
+			if m.get(x, y) == level.Brick && g.rng.chance(g.d.trapBrickChance) {
+				if m.get(x, y+1) == level.Ladder {
+					continue
+				}
+				m.Set(x, y, level.Trap)
+			}
+		}
+	}
+}
+
+// ensureLadderAccessibility clears bricks that cap a ladder no one could
+// step off sideways.
+func (g *generator) ensureLadderAccessibility(m *tileMap) {
+	for x := range width {
+		for y := 1; y < height-1; y++ {
+			if !m.isClimbable(x, y) {
+				continue
+			}
+			if above := m.get(x, y-1); above == level.Brick || above == level.Trap {
+				leftClear := x > 0 && !m.isSolid(x-1, y)
+				rightClear := x < width-1 && !m.isSolid(x+1, y)
+				if !leftClear && !rightClear {
+					m.Set(x, y-1, level.Empty)
+				}
+			}
+			top := y
+			for top > 0 && m.isClimbable(x, top-1) {
+				top--
+			}
+			if top == 0 {
+				continue
+			}
+			if t := m.get(x, top-1); t == level.Brick || t == level.Solid || t == level.Trap {
+				left := x > 0 && !m.isSolid(x-1, top) && m.isSupport(x-1, top+1)
+				right := x < width-1 && !m.isSolid(x+1, top) && m.isSupport(x+1, top+1)
+				if !left && !right {
+					m.Set(x, top-1, level.Empty)
+				}
+			}
+		}
+	}
+}
+
+func (g *generator) placePlayer(m *tileMap) {
+	groundY := height - 2
+	for range 20 {
+		x := g.rng.intn(2, width-2)
+		if t := m.get(x, groundY); t == level.Empty || t == level.Ladder {
+			m.player = Point{x, groundY}
+			return
+		}
+	}
+	for x := 1; x < width-1; x++ {
+		if !m.isSolid(x, groundY) {
+			m.player = Point{x, groundY}
+			return
+		}
+	}
+	m.player = Point{2, groundY}
+}
+
+// placeGold spreads gold over the player's reachable spots, favouring the
+// upper rows, plus on hard and ninja perhaps some only a guard can reach.
+func (g *generator) placeGold(m *tileMap) {
+	count := g.rng.intn(g.d.gold[0], g.d.gold[1]+1)
+	reachable := g.playerReachable(m)
+	var guardReachable *cells
+	if g.d.maxAssistedGold > 0 {
+		guardReachable = flood(guardSpawns(m), func(p Point) []Point { return g.guardNeighbors(m, p) })
+	}
+
+	groundY := height - 2
+	var upper, middle, lower, ground, guardOnly []Point
+	for y := 1; y < height-1; y++ {
+		for x := range width {
+			if !g.isValidGoldSpot(m, x, y) {
+				continue
+			}
+			p := Point{x, y}
+			switch {
+			case reachable.has(p) && y <= 5:
+				upper = append(upper, p)
+			case reachable.has(p) && y <= 10:
+				middle = append(middle, p)
+			case reachable.has(p) && y < groundY:
+				lower = append(lower, p)
+			case reachable.has(p):
+				ground = append(ground, p)
+			case guardReachable != nil && guardReachable.has(p):
+				guardOnly = append(guardOnly, p)
+			}
+		}
+	}
+	shuffle(g.rng, upper)
+	shuffle(g.rng, middle)
+	shuffle(g.rng, lower)
+	shuffle(g.rng, ground)
+	shuffle(g.rng, guardOnly)
+	spots := slices.Concat(upper, middle, lower, ground)
+
+	assisted := 0
+	if g.d.maxAssistedGold > 0 && len(guardOnly) > 0 {
+		chance, most := 0.4, 1
+		if g.key == "ninja" {
+			chance = 0.6
+		}
+		if g.rng.next() < chance {
+			if g.key == "ninja" {
+				most = g.rng.intn(1, 3)
+			}
+			assisted = min(g.d.maxAssistedGold, len(guardOnly), most)
+		}
+	}
+
+	target := count - assisted
+	var placed []Point
+	groundGold, maxGroundGold := 0, max(2, count/5)
+	put := func(p Point) {
+		m.Set(p.X, p.Y, level.Gold)
+		m.gold = append(m.gold, p)
+		placed = append(placed, p)
+	}
+	for _, p := range spots {
+		if len(placed) >= target {
+			break
+		}
+		if p.Y >= groundY && groundGold >= maxGroundGold {
+			continue
+		}
+		tooClose := slices.ContainsFunc(placed, func(q Point) bool { return abs(q.X-p.X) < 3 && abs(q.Y-p.Y) < 2 })
+		if !tooClose {
+			put(p)
+			if p.Y >= groundY {
+				groundGold++
+			}
+		}
+	}
+	// Then closer together if there weren't enough.
+	for _, p := range spots {
+		if len(placed) >= target {
+			break
+		}
+		if slices.Contains(placed, p) || p.Y >= groundY && groundGold >= maxGroundGold {
+			continue
+		}
+		put(p)
+		if p.Y >= groundY {
+			groundGold++
+		}
+	}
+	for i := 0; i < assisted && i < len(guardOnly); i++ {
+		p := guardOnly[i]
+		if !slices.ContainsFunc(placed, func(q Point) bool { return abs(q.X-p.X)+abs(q.Y-p.Y) < 3 }) {
+			put(p)
+		}
+	}
+}
+
+// guardSpawns are where guards start and respawn: the open cells of the
+// top five rows, and their starts.
+func guardSpawns(m *tileMap) []Point {
+	var spawns []Point
+	for y := range 5 {
+		for x := range width {
+			switch m.get(x, y) {
+			case level.Ladder, level.ExitLadder, level.Empty, level.Pole:
+				spawns = append(spawns, Point{x, y})
+			}
+		}
+	}
+	return append(spawns, m.guards...)
+}
+
+// guardNeighbors is where a guard can go from p without digging, by the
+// generator's quick estimate.
+func (g *generator) guardNeighbors(m *tileMap, p Point) []Point {
+	var out []Point
+	x, y := p.X, p.Y
+	onLadder := m.isClimbable(x, y)
+	if onLadder || m.isBar(x, y) || m.isSupport(x, y+1) || y+1 >= height {
+		for _, dx := range []int{-1, 1} {
+			if nx := x + dx; nx >= 0 && nx < width && !m.isSolid(nx, y) {
+				out = append(out, Point{nx, g.fall(m, nx, y)})
+			}
+		}
+	}
+	if onLadder && y > 0 && !m.isSolid(x, y-1) {
+		out = append(out, Point{x, y - 1})
+	}
+	if (onLadder || m.isClimbable(x, y+1)) && y < height-1 && !m.isSolid(x, y+1) {
+		out = append(out, Point{x, y + 1})
+	}
+	return out
+}
+
+// playerReachable is the generator's quick estimate of where the player
+// can go, digging included and the exit ladder left out.
+func (g *generator) playerReachable(m *tileMap) *cells {
+	return flood([]Point{m.player}, func(p Point) []Point {
+		out := g.guardNeighbors(m, p)
+		x, y := p.X, p.Y
+		if m.isClimbable(x, y) || m.isBar(x, y) || m.isSupport(x, y+1) || y+1 >= height {
+			for _, dx := range []int{-1, 1} {
+				if m.canDig(x+dx, y+1) {
+					out = append(out, Point{x + dx, y + 1})
+				}
+			}
+		}
+		return out
+	})
+}
+
+// fall returns the row something dropping from x, y lands on.
+func (g *generator) fall(m *tileMap, x, y int) int {
+	for y < height-1 {
+		if m.isClimbable(x, y) || m.isBar(x, y) || m.isSupport(x, y+1) {
+			break
+		}
+		y++
+	}
+	return y
+}
+
+func (g *generator) isValidGoldSpot(m *tileMap, x, y int) bool {
+	if m.get(x, y) != level.Empty {
+		return false
+	}
+	below := m.get(x, y+1)
+	if below != level.Brick && below != level.Solid && below != level.Ladder && below != level.Trap {
+		return false
+	}
+	if below == level.Ladder {
+		return true
+	}
+	approach := x > 0 && !m.isSolid(x-1, y) || x < width-1 && !m.isSolid(x+1, y)
+	leftSupport := x > 0 && (m.isSupport(x-1, y+1) || m.isClimbable(x-1, y))
+	rightSupport := x < width-1 && (m.isSupport(x+1, y+1) || m.isClimbable(x+1, y))
+	if approach && (leftSupport || rightSupport) {
+		return true
+	}
+	// Or dropped onto from a pole or ladder above.
+	for cy := y - 1; cy >= 0; cy-- {
+		if t := m.get(x, cy); t == level.Pole || t == level.Ladder {
+			return true
+		}
+		if m.isSolid(x, cy) {
+			break
+		}
+	}
+	return false
+}
+
+// placeEnemies places the guards away from the player, one more for every
+// five levels up to the difficulty's cap.
+func (g *generator) placeEnemies(m *tileMap) {
+	bonus := int(math.Floor(float64(g.number-1) / 5))
+	minEnemies := min(g.d.enemies[0]+bonus, g.d.maxEnemies-1)
+	maxEnemies := min(g.d.enemies[1]+bonus, g.d.maxEnemies)
+	count := g.rng.intn(minEnemies, maxEnemies+1)
+
+	var spots []Point
+	for y := 0; y < height-1; y++ {
+		for x := range width {
+			if g.isValidEnemySpot(m, x, y) && abs(x-m.player.X)+abs(y-m.player.Y) > 8 {
+				spots = append(spots, Point{x, y})
+			}
+		}
+	}
+	shuffle(g.rng, spots)
+	for _, p := range spots {
+		if len(m.guards) >= count {
+			break
+		}
+		if slices.ContainsFunc(m.guards, func(e Point) bool { return abs(e.X-p.X)+abs(e.Y-p.Y) < 3 }) {
+			continue
+		}
+		m.guards = append(m.guards, p)
+	}
+}
+
+func (g *generator) isValidEnemySpot(m *tileMap, x, y int) bool {
+	if t := m.get(x, y); t != level.Empty && t != level.Ladder {
+		return false
+	}
+	switch m.get(x, y+1) {
+	case level.Brick, level.Solid, level.Ladder:
+		return true
+	}
+	return false
+}
+
+// addExitLadders clears the top rows of ladders and picks the column the
+// hidden exit ladder rises from: one of the longest ladders reaching just
+// below them, or failing that a clear column near the middle.
+func (g *generator) addExitLadders(m *tileMap) {
+	for y := range exitHiddenRows {
+		for x := range width {
+			if m.get(x, y) == level.Ladder {
+				m.Set(x, y, level.Empty)
+			}
+		}
+	}
+	pathClear := func(x int) bool {
+		for y := range exitHiddenRows {
+			switch m.get(x, y) {
+			case level.Pole, level.Brick, level.Solid, level.Trap:
+				return false
+			}
+		}
+		return true
+	}
+
+	type candidate struct{ x, topY, length int }
+	var candidates []candidate
+	for x := 2; x < width-2; x++ {
+		if !pathClear(x) {
+			continue
+		}
+		topY, length := -1, 0
+		for y := exitHiddenRows; y < height; y++ {
+			if m.get(x, y) == level.Ladder {
+				if topY == -1 {
+					topY = y
+				}
+				length++
+			} else if topY != -1 {
+				break
+			}
+		}
+		if topY != -1 && topY <= exitHiddenRows+2 && length >= 4 {
+			candidates = append(candidates, candidate{x, topY, length})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].length != candidates[j].length {
+			return candidates[i].length > candidates[j].length
+		}
+		return candidates[i].topY < candidates[j].topY
+	})
+
+	var exitX int
+	if len(candidates) > 0 {
+		exitX = candidates[g.rng.intn(0, min(3, len(candidates)))].x
+	} else {
+		exitX = width / 2
+		// As in the client, each distance is measured from the column the
+		// last found, breaking out of the offsets only.
+		for dx := range width / 2 {
+			for _, offset := range []int{dx, -dx} {
+				testX := exitX + offset
+				if testX < 2 || testX >= width-2 {
+					continue
+				}
+				if pathClear(testX) {
+					exitX = testX
+					break
+				}
+			}
+		}
+		for y := range exitHiddenRows {
+			if m.get(exitX, y) == level.Pole {
+				m.Set(exitX, y, level.Empty)
+			}
+		}
+		for y := exitHiddenRows; y < exitHiddenRows+4; y++ {
+			m.Set(exitX, y, level.Ladder)
+		}
+	}
+	for y := range exitHiddenRows {
+		m.exits = append(m.exits, Point{exitX, y})
+	}
+}
+
+// fallback is the client's fixed level for when nothing else will do.
+func fallback() *tileMap {
+	m := newTileMap()
+	for x := range width {
+		m.Set(x, height-1, level.Brick)
+	}
+	for x := 3; x < 18; x++ {
+		m.Set(x, 10, level.Brick)
+	}
+	for x := 10; x < 25; x++ {
+		m.Set(x, 6, level.Brick)
+	}
+	for y := 10; y < height-1; y++ {
+		m.Set(8, y, level.Ladder)
+	}
+	for y := 6; y < 10; y++ {
+		m.Set(14, y, level.Ladder)
+	}
+	for y := 4; y < 6; y++ {
+		m.Set(20, y, level.Ladder)
+	}
+	for y := range exitHiddenRows {
+		m.exits = append(m.exits, Point{20, y})
+	}
+	for x := 18; x < 24; x++ {
+		m.Set(x, 10, level.Pole)
+	}
+	m.player = Point{3, height - 2}
+	for _, p := range []Point{{5, 9}, {10, 9}, {15, 9}, {12, 5}, {18, 5}, {22, 5}} {
+		m.Set(p.X, p.Y, level.Gold)
+		m.gold = append(m.gold, p)
+	}
+	m.guards = []Point{{22, height - 2}, {16, 9}}
+	return m
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
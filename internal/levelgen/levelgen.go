@@ -0,0 +1,192 @@
+// Package levelgen generates levels from a seed the way the game does. It
+// is a port of the client's generator and solvability checker
+// (src/level/LevelGenerator.ts and SolvabilityChecker.ts): with the same
+// seed, difficulty and level number it draws the same random numbers in the
+// same order, so it produces the level the game would, and the server can
+// hand it to clients instead.
+package levelgen
+
+import (
+	"strconv"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+)
+
+// Point is a cell of the grid.
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Level is a generated level. Grid holds the terrain and gold; the player,
+// the guards and the exit ladder, which appears once the gold is in, are
+// kept apart, as the game keeps them, because a player or guard can start
+// on a ladder.
+type Level struct {
+	Grid        *level.Grid
+	Player      Point
+	Guards      []Point
+	ExitLadders []Point
+
+	// EnemyAssistedGold is gold the player can't reach but a guard can
+	// carry out; the game marks it.
+	EnemyAssistedGold []Point
+
+	// Solvable is false for the rare level that is the closest of many
+	// attempts, or the fixed fallback, rather than one that passed the
+	// check.
+	Solvable bool
+}
+
+// Rows returns the level in the text legend, as an uploaded level: the grid
+// with the exit ladder in empty cells and the player and guards over
+// whatever they start on.
+func (l Level) Rows() []string {
+	g := *l.Grid
+	for _, p := range l.ExitLadders {
+		if g.At(p.X, p.Y) == level.Empty {
+			g.Set(p.X, p.Y, level.ExitLadder)
+		}
+	}
+	for _, p := range l.Guards {
+		g.Set(p.X, p.Y, level.Guard)
+	}
+	g.Set(l.Player.X, l.Player.Y, level.Player)
+	return g.Rows()
+}
+
+// difficulty mirrors DIFFICULTIES in src/config.ts, keeping the settings
+// the generator uses.
+type difficulty struct {
+	enemies         [2]int // min, max
+	gold            [2]int
+	complexity      float64
+	ladderDensity   float64
+	trapBrickChance float64
+	maxEnemies      int // cap as levels add guards
+	maxAssistedGold int // gold only a guard can reach
+}
+
+var difficulties = map[string]difficulty{
+	"easy":   {enemies: [2]int{1, 2}, gold: [2]int{5, 8}, complexity: 0.4, ladderDensity: 0.8, trapBrickChance: 0.05, maxEnemies: 4},
+	"normal": {enemies: [2]int{2, 3}, gold: [2]int{8, 12}, complexity: 0.6, ladderDensity: 0.5, trapBrickChance: 0.1, maxEnemies: 5},
+	"hard":   {enemies: [2]int{3, 4}, gold: [2]int{12, 16}, complexity: 0.8, ladderDensity: 0.35, trapBrickChance: 0.15, maxEnemies: 6, maxAssistedGold: 1},
+	"ninja":  {enemies: [2]int{4, 5}, gold: [2]int{16, 20}, complexity: 1.0, ladderDensity: 0.25, trapBrickChance: 0.2, maxEnemies: 7, maxAssistedGold: 2},
+}
+
+// Generate returns level number n, from 1, of seed at the difficulty. The
+// game generates each level of a run from the run's seed code as
+// "CODE-L<n>"; Generate does the same, so seed is the code. An unknown
+// difficulty is normal, as in the game.
+func Generate(seed, diff string, n int) Level {
+	d, ok := difficulties[diff]
+	if !ok {
+		d = difficulties["normal"]
+	}
+	g := &generator{rng: newRandom(seed + "-L" + strconv.Itoa(n)), d: d, key: diff, number: n}
+	m, res := g.generate()
+	return Level{
+		Grid:              &m.Grid,
+		Player:            m.player,
+		Guards:            m.guards,
+		ExitLadders:       m.exits,
+		EnemyAssistedGold: res.enemyAssisted,
+		Solvable:          res.solvable,
+	}
+}
+
+// tileMap is the generator's working level, src/level/TileMap.ts without
+// the parts only play needs.
+type tileMap struct {
+	level.Grid
+	player Point
+	guards []Point
+	gold   []Point
+	exits  []Point
+}
+
+const (
+	width  = level.Width
+	height = level.Height
+)
+
+// newTileMap returns an empty map.
+func newTileMap() *tileMap {
+	m := &tileMap{}
+	for y := range height {
+		for x := range width {
+			m.Set(x, y, level.Empty)
+		}
+	}
+	return m
+}
+
+func (m *tileMap) get(x, y int) level.Tile { return m.At(x, y) }
+
+func (m *tileMap) isSolid(x, y int) bool {
+	switch m.get(x, y) {
+	case level.Brick, level.Solid, level.Trap:
+		return true
+	}
+	return false
+}
+
+func (m *tileMap) isSupport(x, y int) bool {
+	switch m.get(x, y) {
+	case level.Brick, level.Solid, level.Ladder, level.ExitLadder:
+		return true
+	}
+	return false
+}
+
+func (m *tileMap) isClimbable(x, y int) bool {
+	t := m.get(x, y)
+	return t == level.Ladder || t == level.ExitLadder
+}
+
+func (m *tileMap) isBar(x, y int) bool { return m.get(x, y) == level.Pole }
+
+// canDig reports whether a brick can be dug; not one with a ladder on it.
+func (m *tileMap) canDig(x, y int) bool {
+	if t := m.get(x, y); t != level.Brick && t != level.Trap {
+		return false
+	}
+	return y <= 0 || !m.isClimbable(x, y-1)
+}
+
+// cells is a set of grid cells.
+type cells [height][width]bool
+
+func (c *cells) has(p Point) bool {
+	return p.X >= 0 && p.X < width && p.Y >= 0 && p.Y < height && c[p.Y][p.X]
+}
+
+// add adds p and reports whether it is new.
+func (c *cells) add(p Point) bool {
+	if c.has(p) {
+		return false
+	}
+	c[p.Y][p.X] = true
+	return true
+}
+
+// flood returns the cells reachable from starts through neighbors.
+func flood(starts []Point, neighbors func(Point) []Point) *cells {
+	seen := &cells{}
+	var queue []Point
+	for _, p := range starts {
+		if seen.add(p) {
+			queue = append(queue, p)
+		}
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, n := range neighbors(p) {
+			if seen.add(n) {
+				queue = append(queue, n)
+			}
+		}
+	}
+	return seen
+}
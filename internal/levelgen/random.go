@@ -0,0 +1,56 @@
+package levelgen
+
+import (
+	"math"
+	"unicode/utf16"
+)
+
+// random is the client's seeded generator (src/utils/SeededRandom.ts),
+// Mulberry32 seeded with a hash of the seed string. The state is a float64
+// because the client keeps it in a JavaScript number; adding to it the same
+// way keeps every draw identical to the client's.
+type random struct {
+	state float64
+}
+
+func newRandom(seed string) *random {
+	var hash int32
+	for _, c := range utf16.Encode([]rune(seed)) {
+		hash = hash<<5 - hash + int32(c)
+	}
+	h := float64(hash)
+	if h < 0 {
+		h = -h
+	}
+	return &random{state: h}
+}
+
+// next returns a number in [0, 1).
+func (r *random) next() float64 {
+	r.state += 0x6D2B79F5
+	t := uint32(uint64(r.state))
+	t = (t ^ t>>15) * (t | 1)
+	t ^= t + (t^t>>7)*(t|61)
+	return float64(t^t>>14) / 4294967296
+}
+
+// intn returns an integer in [min, max).
+func (r *random) intn(min, max int) int {
+	return int(math.Floor(r.next()*float64(max-min))) + min
+}
+
+// chance reports true with probability p.
+func (r *random) chance(p float64) bool {
+	return r.next() < p
+}
+
+func pick[T any](r *random, s []T) T {
+	return s[r.intn(0, len(s))]
+}
+
+func shuffle[T any](r *random, s []T) {
+	for i := len(s) - 1; i > 0; i-- {
+		j := r.intn(0, i+1)
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,158 @@
+package levelgen
+
+// checker is SolvabilityChecker: whether the player can collect every piece
+// of gold, directly or from a guard carrying it out, and then reach the exit.
+type checker struct {
+	maxAssistedGold int
+
+	exits        cells
+	includeExits bool // exit ladders count only once the gold is in
+}
+
+// result is a check's outcome. score is the share of gold collectable,
+// or 0 if the exit can't be reached; the generator keeps the best-scoring
+// candidate in case none is solvable.
+type result struct {
+	solvable      bool
+	score         float64
+	enemyAssisted []Point
+}
+
+func (c *checker) check(m *tileMap) result {
+	c.exits = cells{}
+	for _, p := range m.exits {
+		c.exits.add(p)
+	}
+	if len(m.gold) == 0 || len(m.exits) == 0 {
+		return result{}
+	}
+
+	c.includeExits = false
+	player := flood([]Point{m.player}, func(p Point) []Point { return c.playerNeighbors(m, p) })
+	guards := flood(guardSpawns(m), func(p Point) []Point { return c.guardNeighbors(m, p) })
+
+	collectable := 0
+	var assisted []Point
+	for _, p := range m.gold {
+		switch {
+		case player.has(p):
+			collectable++
+		case guards.has(p) && c.canGuardDeliver(m, p, player):
+			collectable++
+			assisted = append(assisted, p)
+		}
+	}
+
+	c.includeExits = true
+	withExit := flood([]Point{m.player}, func(p Point) []Point { return c.playerNeighbors(m, p) })
+	exitReachable := false
+	for _, p := range m.exits {
+		if withExit.has(p) || withExit.has(Point{p.X, 0}) {
+			exitReachable = true
+			break
+		}
+	}
+
+	res := result{enemyAssisted: assisted}
+	if exitReachable {
+		res.score = float64(collectable) / float64(len(m.gold))
+	}
+	res.solvable = exitReachable && collectable == len(m.gold) && len(assisted) <= c.maxAssistedGold
+	return res
+}
+
+// canGuardDeliver reports whether a guard who picks up the gold at p can
+// carry it somewhere the player can reach: at least two such cells, so that
+// one narrow meeting point doesn't count.
+func (c *checker) canGuardDeliver(m *tileMap, p Point, player *cells) bool {
+	from := flood([]Point{p}, func(p Point) []Point { return c.guardNeighbors(m, p) })
+	n := 0
+	for y := range height {
+		for x := range width {
+			if from[y][x] && player[y][x] {
+				if n++; n >= 2 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c *checker) isClimbable(m *tileMap, x, y int) bool {
+	return m.isClimbable(x, y) || c.includeExits && c.exits.has(Point{x, y})
+}
+
+func (c *checker) hasSupport(m *tileMap, x, y int) bool {
+	return c.isClimbable(m, x, y) || m.isBar(x, y) || y+1 >= height ||
+		c.includeExits && c.exits.has(Point{x, y + 1}) || m.isSupport(x, y+1)
+}
+
+// canEnter reports whether x, y is in the level and not brick.
+func canEnter(m *tileMap, x, y int) bool {
+	return x >= 0 && x < width && y >= 0 && y < height && !m.isSolid(x, y)
+}
+
+// playerNeighbors is where the player can go from p: walking and falling,
+// climbing, and digging down beside them.
+func (c *checker) playerNeighbors(m *tileMap, p Point) []Point {
+	var out []Point
+	x, y := p.X, p.Y
+	onLadder := c.isClimbable(m, x, y)
+	canMove := onLadder || m.isBar(x, y) || c.hasSupport(m, x, y)
+	fall := func(x, y int) {
+		for y < height-1 && !c.isClimbable(m, x, y) && !m.isBar(x, y) && !m.isSupport(x, y+1) && !m.isSolid(x, y+1) {
+			y++
+		}
+		out = append(out, Point{x, y})
+	}
+	if canMove {
+		for _, dx := range []int{-1, 1} {
+			if canEnter(m, x+dx, y) {
+				fall(x+dx, y)
+			}
+		}
+	}
+	if onLadder && canEnter(m, x, y-1) {
+		out = append(out, Point{x, y - 1})
+	}
+	if (onLadder || c.isClimbable(m, x, y+1)) && canEnter(m, x, y+1) {
+		out = append(out, Point{x, y + 1})
+	}
+	if canMove {
+		for _, dx := range []int{-1, 1} {
+			if m.canDig(x+dx, y+1) {
+				fall(x+dx, y+1)
+			}
+		}
+	}
+	return out
+}
+
+// guardNeighbors is where a guard can go from p: as the player, but
+// without digging and never by the exit ladder.
+func (c *checker) guardNeighbors(m *tileMap, p Point) []Point {
+	var out []Point
+	x, y := p.X, p.Y
+	onLadder := m.isClimbable(x, y)
+	if onLadder || m.isBar(x, y) || y+1 >= height || m.isSupport(x, y+1) {
+		for _, dx := range []int{-1, 1} {
+			nx := x + dx
+			if !canEnter(m, nx, y) {
+				continue
+			}
+			ny := y
+			for ny < height-1 && !m.isClimbable(nx, ny) && !m.isBar(nx, ny) && !m.isSupport(nx, ny+1) && !m.isSolid(nx, ny+1) {
+				ny++
+			}
+			out = append(out, Point{nx, ny})
+		}
+	}
+	if onLadder && canEnter(m, x, y-1) {
+		out = append(out, Point{x, y - 1})
+	}
+	if (onLadder || m.isClimbable(x, y+1)) && canEnter(m, x, y+1) {
+		out = append(out, Point{x, y + 1})
+	}
+	return out
+}
@@ -0,0 +1,580 @@
+// Package lobby manages multiplayer rooms: join codes, membership,
+// presence, ordered message relay and broadcasts to spectators. It knows
+// nothing about game rules or the transport; callers feed it players and
+// drain each player's outbox. Rooms are kept in a State that several
+// instances can share, each relaying messages to the members connected
+// to it.
+package lobby
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxPlayers is the room capacity.
+const MaxPlayers = 4
+
+// codeAlphabet avoids ambiguous characters, like the client's seed codes.
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength   = 5
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomFull     = errors.New("room is full")
+	ErrInRoom       = errors.New("already in a room")
+	ErrNotInRoom    = errors.New("not in a room")
+	ErrNoSuchPlayer = errors.New("no such player in room")
+	ErrBadToken     = errors.New("room token is invalid or expired")
+
+	// ErrUnavailable wraps the errors of State.
+	ErrUnavailable = errors.New("lobby is unavailable")
+)
+
+// Message is what players receive. Seq increases by one for every message
+// a room sends, so all members observe the same order.
+type Message struct {
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Room    string          `json:"room,omitempty"`
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Player  *PlayerInfo     `json:"player,omitempty"`
+	Players []PlayerInfo    `json:"players,omitempty"`
+	Host    string          `json:"host,omitempty"`
+	Text    string          `json:"text,omitempty"`
+	Chat    []ChatLine      `json:"chat,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// ChatLine is a line of earlier chat, sent to a player joining a room.
+type ChatLine struct {
+	Name string    `json:"name"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// PlayerInfo is a player as other members see them.
+type PlayerInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Player is one connected client. Its outbox is bounded; a player who
+// can't keep up is kicked instead of stalling the room.
+type Player struct {
+	ID   string
+	Name string
+
+	outbox   chan []byte
+	kicked   chan struct{}
+	kickOnce sync.Once
+	reason   string
+
+	mu   sync.Mutex
+	room *Room
+}
+
+// NewPlayer returns a player whose outbox holds up to queue messages.
+func NewPlayer(id, name string, queue int) *Player {
+	return &Player{ID: id, Name: name, outbox: make(chan []byte, queue), kicked: make(chan struct{})}
+}
+
+// Outbox yields encoded messages for the player, in order.
+func (p *Player) Outbox() <-chan []byte { return p.outbox }
+
+// Kicked is closed when the server drops the player; Reason says why.
+func (p *Player) Kicked() <-chan struct{} { return p.kicked }
+
+// Reason explains a kick.
+func (p *Player) Reason() string {
+	<-p.kicked
+	return p.reason
+}
+
+// Kick disconnects the player; only the first reason is kept.
+func (p *Player) Kick(reason string) {
+	p.kickOnce.Do(func() {
+		p.reason = reason
+		close(p.kicked)
+	})
+}
+
+// Room returns the player's current room, or nil.
+func (p *Player) Room() *Room {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.room
+}
+
+func (p *Player) info() PlayerInfo { return PlayerInfo{ID: p.ID, Name: p.Name} }
+
+// Send queues a message addressed to the player alone, outside any room
+// sequence, such as a welcome or an error reply.
+func (p *Player) Send(m Message) {
+	b, _ := json.Marshal(m)
+	p.send(b)
+}
+
+// send queues an encoded message without blocking.
+func (p *Player) send(b []byte) {
+	select {
+	case p.outbox <- b:
+	default:
+		p.Kick("too slow")
+	}
+}
+
+// State is where hubs keep rooms and relay messages through. Hubs on
+// every instance of a deployment share one, so that players connected to
+// different instances can meet in the same room.
+type State interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Pop(ctx context.Context, key string) ([]byte, error)
+	Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error
+	Publish(ctx context.Context, channel string, msg []byte) (uint64, error)
+	Subscribe(ctx context.Context, prefix string, fn func(channel string, seq uint64, msg []byte)) error
+}
+
+// roomTTL is how long a room is kept after its membership last changed,
+// in case the instances its players were on went away without them
+// leaving.
+const roomTTL = 24 * time.Hour
+
+const (
+	roomPrefix  = "lobby:room:"
+	tokenPrefix = "lobby:token:"
+	relayPrefix = "lobby:relay:"
+)
+
+var errCodeTaken = errors.New("lobby: code taken")
+
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// record is a room as kept in State.
+type record struct {
+	Created       time.Time    `json:"created"`
+	Members       []PlayerInfo `json:"members"` // in order of arrival; the first is host
+	Reserved      int          `json:"reserved,omitempty"`
+	ReservedUntil time.Time    `json:"reserved_until,omitzero"`
+}
+
+// reserved counts the seats held by unexpired join tokens.
+func (rec *record) reserved(now time.Time) int {
+	if now.Before(rec.ReservedUntil) {
+		return rec.Reserved
+	}
+	return 0
+}
+
+// open reports whether the room still exists: a record outliving its
+// members and reservations is only waiting to expire.
+func (rec *record) open(now time.Time) bool {
+	return len(rec.Members) > 0 || rec.reserved(now) > 0
+}
+
+func (rec *record) host() string {
+	if len(rec.Members) == 0 {
+		return ""
+	}
+	return rec.Members[0].ID
+}
+
+func (rec *record) has(id string) bool {
+	for _, m := range rec.Members {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// envelope is a room message on its way to every instance.
+type envelope struct {
+	Message Message `json:"m"`
+	Skip    string  `json:"skip,omitempty"` // the member who doesn't get it
+}
+
+// Room is a group of up to MaxPlayers players, some of them perhaps on
+// other instances.
+type Room struct {
+	Code    string
+	Created time.Time
+
+	hub     *Hub
+	players []*Player // on this instance; guarded by hub.mu
+}
+
+// Snapshot returns the members, host first.
+func (r *Room) Snapshot(ctx context.Context) (players []PlayerInfo, host string, err error) {
+	rec, err := r.hub.load(ctx, r.Code)
+	if err != nil || rec == nil {
+		return nil, "", err
+	}
+	return rec.Members, rec.host(), nil
+}
+
+// Relay sends data from a member to everyone else in the room, or only to
+// the member with ID to.
+func (r *Room) Relay(ctx context.Context, from *Player, to, typ string, data json.RawMessage) error {
+	if from.Room() != r {
+		return ErrNotInRoom
+	}
+	m := Message{Type: typ, From: from.ID, To: to, Data: data}
+	if to == "" {
+		return r.hub.publish(ctx, r.Code, m, from.ID)
+	}
+	rec, err := r.hub.load(ctx, r.Code)
+	if err != nil {
+		return err
+	}
+	if rec == nil || !rec.has(to) {
+		return ErrNoSuchPlayer
+	}
+	return r.hub.publish(ctx, r.Code, m, "")
+}
+
+// Chat sends a line of chat from a member to the whole room, the sender
+// included, so everyone sees it in the same order.
+func (r *Room) Chat(ctx context.Context, from *Player, text string) error {
+	if from.Room() != r {
+		return ErrNotInRoom
+	}
+	info := from.info()
+	return r.hub.publish(ctx, r.Code, Message{Type: "chat", From: from.ID, Player: &info, Text: text}, "")
+}
+
+// Hub owns this instance's connected players and the rooms they are in.
+// Rooms themselves live in State.
+type Hub struct {
+	state State
+
+	mu       sync.Mutex
+	rooms    map[string]*Room // with players on this instance
+	conns    map[*Player]struct{}
+	players  int                   // in rooms
+	live     map[string]*broadcast // rooms broadcasting or watched
+	watchers int
+}
+
+// NewHub returns a hub keeping rooms in state. Run must be running for
+// its players to receive room messages.
+func NewHub(state State) *Hub {
+	return &Hub{state: state, rooms: map[string]*Room{}, conns: map[*Player]struct{}{}, live: map[string]*broadcast{}}
+}
+
+// Run delivers room messages and broadcasts, from this instance and
+// others, to the players and spectators here until ctx is done.
+func (h *Hub) Run(ctx context.Context) error {
+	go h.sweep(ctx)
+	return h.state.Subscribe(ctx, "lobby:", h.route)
+}
+
+// deliver hands a message published on a room's channel to the room's
+// members on this instance. Run's single goroutine is what keeps each
+// member's outbox in sequence order.
+func (h *Hub) deliver(channel string, seq uint64, b []byte) {
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return
+	}
+	m := env.Message
+	m.Seq, m.Room = seq, strings.TrimPrefix(channel, relayPrefix)
+	b, _ = json.Marshal(m)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.rooms[m.Room]
+	if r == nil {
+		return
+	}
+	for _, p := range r.players {
+		if p.ID != env.Skip && (m.To == "" || m.To == p.ID) {
+			p.send(b)
+		}
+	}
+}
+
+// publish sends m to the room's members, except skip.
+func (h *Hub) publish(ctx context.Context, code string, m Message, skip string) error {
+	b, _ := json.Marshal(envelope{Message: m, Skip: skip})
+	_, err := h.state.Publish(ctx, relayPrefix+code, b)
+	return unavailable(err)
+}
+
+// load returns the room's record, or nil if it has closed.
+func (h *Hub) load(ctx context.Context, code string) (*record, error) {
+	b, err := h.state.Get(ctx, roomPrefix+code)
+	if err != nil || b == nil {
+		return nil, unavailable(err)
+	}
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, unavailable(err)
+	}
+	if !rec.open(time.Now()) {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+// update replaces the room's record with what fn returns, which may be
+// nil to close it. fn gets nil for a room that doesn't exist.
+func (h *Hub) update(ctx context.Context, code string, ttl time.Duration, fn func(rec *record, now time.Time) (*record, error)) (*record, error) {
+	var rec *record
+	var fnErr error
+	err := h.state.Update(ctx, roomPrefix+code, ttl, func(b []byte) ([]byte, error) {
+		now := time.Now()
+		rec = nil
+		if b != nil {
+			rec = new(record)
+			if err := json.Unmarshal(b, rec); err != nil {
+				return nil, err
+			}
+			if !rec.open(now) {
+				rec = nil
+			}
+		}
+		if rec, fnErr = fn(rec, now); fnErr != nil || rec == nil {
+			return nil, fnErr
+		}
+		return json.Marshal(rec)
+	})
+	if err != nil && err == fnErr {
+		return nil, err
+	}
+	return rec, unavailable(err)
+}
+
+// Connect registers a newly connected player.
+func (h *Hub) Connect(p *Player) {
+	h.mu.Lock()
+	h.conns[p] = struct{}{}
+	h.mu.Unlock()
+}
+
+// Disconnect removes p from its room and from the hub.
+func (h *Hub) Disconnect(ctx context.Context, p *Player) error {
+	err := h.Leave(ctx, p)
+	h.mu.Lock()
+	delete(h.conns, p)
+	h.mu.Unlock()
+	return err
+}
+
+// Create opens a new room with p as host.
+func (h *Hub) Create(ctx context.Context, p *Player) (*Room, error) {
+	if p.Room() != nil {
+		return nil, ErrInRoom
+	}
+	for {
+		code := newCode()
+		rec, err := h.update(ctx, code, roomTTL, func(rec *record, now time.Time) (*record, error) {
+			if rec != nil {
+				return nil, errCodeTaken
+			}
+			return &record{Created: now, Members: []PlayerInfo{p.info()}}, nil
+		})
+		if errors.Is(err, errCodeTaken) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return h.enter(ctx, code, rec, p)
+	}
+}
+
+// Join adds p to the room with the given code.
+func (h *Hub) Join(ctx context.Context, code string, p *Player) (*Room, error) {
+	if p.Room() != nil {
+		return nil, ErrInRoom
+	}
+	rec, err := h.update(ctx, code, roomTTL, func(rec *record, now time.Time) (*record, error) {
+		if rec == nil {
+			return nil, ErrRoomNotFound
+		}
+		if len(rec.Members)+rec.reserved(now) >= MaxPlayers {
+			return nil, ErrRoomFull
+		}
+		rec.Members = append(rec.Members, p.info())
+		return rec, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.enter(ctx, code, rec, p)
+}
+
+// Reserve opens an empty room and returns one single-use join token per
+// seat, for handing to players matched elsewhere. Unused tokens lapse
+// after ttl, and the room closes if nobody arrived.
+func (h *Hub) Reserve(ctx context.Context, seats int, ttl time.Duration) (code string, tokens []string, err error) {
+	for {
+		code = newCode()
+		_, err = h.update(ctx, code, ttl, func(rec *record, now time.Time) (*record, error) {
+			if rec != nil {
+				return nil, errCodeTaken
+			}
+			return &record{Created: now, Members: []PlayerInfo{}, Reserved: seats, ReservedUntil: now.Add(ttl)}, nil
+		})
+		if !errors.Is(err, errCodeTaken) {
+			break
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	for range seats {
+		t := make([]byte, 18)
+		rand.Read(t)
+		token := base64.RawURLEncoding.EncodeToString(t)
+		if err := h.state.Set(ctx, tokenPrefix+token, []byte(code), ttl); err != nil {
+			return "", nil, unavailable(err)
+		}
+		tokens = append(tokens, token)
+	}
+	return code, tokens, nil
+}
+
+// JoinToken adds p to the room a Reserve token belongs to, using up the
+// token.
+func (h *Hub) JoinToken(ctx context.Context, token string, p *Player) (*Room, error) {
+	if p.Room() != nil {
+		return nil, ErrInRoom
+	}
+	b, err := h.state.Pop(ctx, tokenPrefix+token)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	if b == nil {
+		return nil, ErrBadToken
+	}
+	code := string(b)
+	rec, err := h.update(ctx, code, roomTTL, func(rec *record, now time.Time) (*record, error) {
+		if rec == nil {
+			return nil, ErrBadToken
+		}
+		rec.Reserved = max(0, rec.Reserved-1)
+		rec.Members = append(rec.Members, p.info())
+		return rec, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.enter(ctx, code, rec, p)
+}
+
+// enter seats p in the room on this instance once rec, the room's record,
+// lists them, and announces them to the room.
+func (h *Hub) enter(ctx context.Context, code string, rec *record, p *Player) (*Room, error) {
+	h.mu.Lock()
+	r := h.rooms[code]
+	if r == nil {
+		r = &Room{Code: code, Created: rec.Created, hub: h}
+		h.rooms[code] = r
+	}
+	r.players = append(r.players, p)
+	p.mu.Lock()
+	p.room = r
+	p.mu.Unlock()
+	h.players++
+	h.mu.Unlock()
+
+	info := p.info()
+	return r, h.publish(ctx, code, Message{Type: "joined", Player: &info, Players: rec.Members, Host: rec.host()}, "")
+}
+
+// Leave removes p from its room, handing host to the next member and
+// closing the room when it empties. It is a no-op outside a room.
+func (h *Hub) Leave(ctx context.Context, p *Player) error {
+	h.mu.Lock()
+	r := p.Room()
+	if r == nil {
+		h.mu.Unlock()
+		return nil
+	}
+	p.mu.Lock()
+	p.room = nil
+	p.mu.Unlock()
+	h.players--
+	r.players = slices.DeleteFunc(r.players, func(q *Player) bool { return q == p })
+	if len(r.players) == 0 {
+		delete(h.rooms, r.Code)
+	}
+	h.mu.Unlock()
+
+	rec, err := h.update(ctx, r.Code, roomTTL, func(rec *record, now time.Time) (*record, error) {
+		if rec == nil {
+			return nil, nil
+		}
+		rec.Members = slices.DeleteFunc(rec.Members, func(m PlayerInfo) bool { return m.ID == p.ID })
+		if !rec.open(now) {
+			return nil, nil
+		}
+		return rec, nil
+	})
+	if err == nil && rec == nil {
+		return h.endBroadcast(ctx, r.Code)
+	}
+	if err != nil || len(rec.Members) == 0 {
+		return err
+	}
+	info := p.info()
+	return h.publish(ctx, r.Code, Message{Type: "left", Player: &info, Players: rec.Members, Host: rec.host()}, "")
+}
+
+// Stats reports the rooms with players on this instance, those players
+// and the players connected to it.
+func (h *Hub) Stats() (rooms, inRooms, connected int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rooms), h.players, len(h.conns)
+}
+
+// KickAll disconnects every connected player, e.g. on shutdown.
+func (h *Hub) KickAll(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p := range h.conns {
+		p.Kick(reason)
+	}
+}
+
+// KickIdle disconnects the players who aren't in a room, leaving matches
+// in progress alone.
+func (h *Hub) KickIdle(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for p := range h.conns {
+		if p.Room() == nil {
+			p.Kick(reason)
+		}
+	}
+}
+
+// newCode returns a random join code, which may be taken.
+func newCode() string {
+	b := make([]byte, codeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b)
+}
@@ -0,0 +1,225 @@
+package lobby
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A room's members can broadcast their match for spectators, who watch it
+// without joining. Broadcasts go through State like room messages, and
+// every hub keeps the last CatchUp of each room's, so a spectator
+// arriving on any instance starts from a few seconds back rather than
+// from nothing.
+
+// CatchUp is how much of a broadcast a new spectator is sent, up to
+// CatchUpMessages messages. A room is listed as live while it has
+// broadcast within CatchUp.
+const (
+	CatchUp         = 5 * time.Second
+	CatchUpMessages = 256
+	catchUpBytes    = 1 << 20
+)
+
+const watchPrefix = "lobby:watch:"
+
+// broadcast is a room's recent broadcasts as this hub saw them, and the
+// spectators here watching it.
+type broadcast struct {
+	recent   []sent // oldest first
+	bytes    int
+	last     time.Time
+	watchers []*Player
+}
+
+type sent struct {
+	at time.Time
+	b  []byte
+}
+
+// trim drops what is older than CatchUp, or over the buffer's limits.
+func (bc *broadcast) trim(now time.Time) {
+	n := 0
+	for n < len(bc.recent) && (now.Sub(bc.recent[n].at) > CatchUp || len(bc.recent)-n > CatchUpMessages || bc.bytes > catchUpBytes) {
+		bc.bytes -= len(bc.recent[n].b)
+		n++
+	}
+	bc.recent = slices.Delete(bc.recent, 0, n)
+}
+
+// LiveMatch is a room broadcasting its match.
+type LiveMatch struct {
+	Room    string       `json:"room"`
+	Players []PlayerInfo `json:"players"`
+	Host    string       `json:"host"`
+	Started time.Time    `json:"started"`
+}
+
+// Broadcast sends data from a member to the room's spectators.
+func (r *Room) Broadcast(ctx context.Context, from *Player, data json.RawMessage) error {
+	if from.Room() != r {
+		return ErrNotInRoom
+	}
+	b, _ := json.Marshal(Message{Type: "state", From: from.ID, Data: data})
+	_, err := r.hub.state.Publish(ctx, watchPrefix+r.Code, b)
+	return unavailable(err)
+}
+
+// deliverWatch buffers a broadcast and hands it to the room's spectators
+// on this instance. An "ended" message sees them off.
+func (h *Hub) deliverWatch(code string, seq uint64, b []byte) {
+	var m Message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return
+	}
+	m.Seq, m.Room = seq, code
+	b, _ = json.Marshal(m)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bc := h.live[code]
+	if m.Type == "ended" {
+		if bc != nil {
+			for _, p := range bc.watchers {
+				p.send(b)
+				p.Kick("match ended")
+			}
+			h.watchers -= len(bc.watchers)
+			delete(h.live, code)
+		}
+		return
+	}
+	if bc == nil {
+		bc = &broadcast{}
+		h.live[code] = bc
+	}
+	now := time.Now()
+	bc.recent = append(bc.recent, sent{at: now, b: b})
+	bc.bytes += len(b)
+	bc.last = now
+	bc.trim(now)
+	for _, p := range bc.watchers {
+		p.send(b)
+	}
+}
+
+// endBroadcast tells a closed room's spectators it has ended.
+func (h *Hub) endBroadcast(ctx context.Context, code string) error {
+	b, _ := json.Marshal(Message{Type: "ended"})
+	_, err := h.state.Publish(ctx, watchPrefix+code, b)
+	return unavailable(err)
+}
+
+// Watch makes p a spectator of the room with the given code. p is sent a
+// "watching" message with the members, then the last CatchUp of the
+// broadcast, then the broadcast as it happens. A room can be watched
+// before it starts broadcasting.
+func (h *Hub) Watch(ctx context.Context, code string, p *Player) error {
+	rec, err := h.load(ctx, code)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return ErrRoomNotFound
+	}
+	b, _ := json.Marshal(Message{Type: "watching", Room: code, Players: rec.Members, Host: rec.host()})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bc := h.live[code]
+	if bc == nil {
+		bc = &broadcast{}
+		h.live[code] = bc
+	}
+	p.send(b)
+	bc.trim(time.Now())
+	for _, s := range bc.recent {
+		p.send(s.b)
+	}
+	bc.watchers = append(bc.watchers, p)
+	h.watchers++
+	return nil
+}
+
+// Unwatch stops p watching the room with the given code.
+func (h *Hub) Unwatch(code string, p *Player) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bc := h.live[code]
+	if bc == nil {
+		return
+	}
+	if i := slices.Index(bc.watchers, p); i >= 0 {
+		bc.watchers = slices.Delete(bc.watchers, i, i+1)
+		h.watchers--
+	}
+}
+
+// Live returns the rooms that have broadcast within CatchUp, oldest
+// first.
+func (h *Hub) Live(ctx context.Context) ([]LiveMatch, error) {
+	now := time.Now()
+	var codes []string
+	h.mu.Lock()
+	for code, bc := range h.live {
+		if now.Sub(bc.last) <= CatchUp {
+			codes = append(codes, code)
+		}
+	}
+	h.mu.Unlock()
+
+	matches := []LiveMatch{}
+	for _, code := range codes {
+		rec, err := h.load(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil && len(rec.Members) > 0 {
+			matches = append(matches, LiveMatch{Room: code, Players: rec.Members, Host: rec.host(), Started: rec.Created})
+		}
+	}
+	slices.SortFunc(matches, func(a, b LiveMatch) int { return a.Started.Compare(b.Started) })
+	return matches, nil
+}
+
+// Spectators reports the spectators connected to this instance.
+func (h *Hub) Spectators() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.watchers
+}
+
+// sweep forgets the broadcasts of rooms nobody here is watching once they
+// go quiet, until ctx is done.
+func (h *Hub) sweep(ctx context.Context) {
+	ticker := time.NewTicker(CatchUp)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.mu.Lock()
+			for code, bc := range h.live {
+				bc.trim(now)
+				if len(bc.watchers) == 0 && now.Sub(bc.last) > CatchUp {
+					delete(h.live, code)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// route hands a message from State to the relay or the broadcast it was
+// published on.
+func (h *Hub) route(channel string, seq uint64, b []byte) {
+	switch {
+	case strings.HasPrefix(channel, relayPrefix):
+		h.deliver(channel, seq, b)
+	case strings.HasPrefix(channel, watchPrefix):
+		h.deliverWatch(strings.TrimPrefix(channel, watchPrefix), seq, b)
+	}
+}
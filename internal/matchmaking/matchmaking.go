@@ -0,0 +1,379 @@
+// Package matchmaking pairs queued players of similar skill in the same
+// region. Each ticket's rating tolerance widens the longer it waits, so
+// nobody waits forever for a perfect match.
+package matchmaking
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Tuning. The tolerance starts at baseTolerance rating points and grows by
+// widenStep every widenEvery, up to maxTolerance.
+const (
+	baseTolerance = 100
+	widenStep     = 50
+	widenEvery    = 5 * time.Second
+	maxTolerance  = 1000
+
+	tickInterval  = time.Second
+	abandonAfter  = 30 * time.Second // without a status poll
+	keepMatched   = 2 * time.Minute  // for clients to collect their room
+	defaultWait   = 30 * time.Second // estimate before any matches
+	waitSmoothing = 0.2              // weight of the newest wait in the estimate
+
+	// seenEvery is how stale a ticket's last poll may get before a status
+	// poll records a new one. Polls in between only read the queue.
+	seenEvery = abandonAfter / 3
+)
+
+// queueKey is where the queue is kept in State.
+const queueKey = "matchmaking:queue"
+
+// ErrNoTicket means the ticket is unknown, cancelled or expired.
+var ErrNoTicket = errors.New("matchmaking: no such ticket")
+
+// Ticket states.
+const (
+	Waiting = "waiting"
+	Matched = "matched"
+)
+
+// RoomFunc reserves a room for matched players and returns its code and one
+// join token per seat.
+type RoomFunc func(ctx context.Context, seats int) (code string, tokens []string, err error)
+
+// State is where a queue is kept. Queues on every instance of a
+// deployment share one, and are then the same queue; each of them pairs
+// tickets, one at a time.
+type State interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Update(ctx context.Context, key string, ttl time.Duration, fn func([]byte) ([]byte, error)) error
+}
+
+// Status is a ticket as its owner sees it.
+type Status struct {
+	Ticket        string
+	State         string
+	Position      int // among waiting tickets in the same region, from 1
+	Waited        time.Duration
+	EstimatedWait time.Duration
+	Tolerance     int // current rating window, ±
+	Room          string
+	RoomToken     string
+	Opponent      *Opponent
+}
+
+// Opponent is who a ticket was matched with.
+type Opponent struct {
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+}
+
+type ticket struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Rating   int       `json:"rating"`
+	Region   string    `json:"region"`
+	Enqueued time.Time `json:"enqueued"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// A paired ticket has MatchedAt and Opponent, and is matched once its
+	// room is reserved.
+	MatchedAt time.Time `json:"matched_at,omitzero"`
+	Opponent  *Opponent `json:"opponent,omitempty"`
+	Room      string    `json:"room,omitempty"`
+	RoomToken string    `json:"room_token,omitempty"`
+}
+
+func (t *ticket) tolerance(now time.Time) int {
+	return min(maxTolerance, baseTolerance+widenStep*int(now.Sub(t.Enqueued)/widenEvery))
+}
+
+func (t *ticket) waiting() bool { return t.MatchedAt.IsZero() }
+
+// queue is the state shared through State.
+type queue struct {
+	Tickets []*ticket                `json:"tickets"`  // oldest first
+	AvgWait map[string]time.Duration `json:"avg_wait"` // by region
+}
+
+func (s *queue) find(id string) *ticket {
+	for _, t := range s.Tickets {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *queue) remove(id string) {
+	s.Tickets = slices.DeleteFunc(s.Tickets, func(t *ticket) bool { return t.ID == id })
+}
+
+// Queue holds waiting and recently matched tickets.
+type Queue struct {
+	state   State
+	newRoom RoomFunc
+}
+
+// New returns a queue kept in state that reserves rooms with newRoom.
+func New(state State, newRoom RoomFunc) *Queue {
+	return &Queue{state: state, newRoom: newRoom}
+}
+
+func (q *Queue) load(ctx context.Context) (*queue, error) {
+	b, err := q.state.Get(ctx, queueKey)
+	if err != nil {
+		return nil, err
+	}
+	return decode(b)
+}
+
+// update applies fn to the queue atomically. fn may run more than once.
+func (q *Queue) update(ctx context.Context, fn func(s *queue) error) error {
+	return q.state.Update(ctx, queueKey, 0, func(b []byte) ([]byte, error) {
+		s, err := decode(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(s); err != nil {
+			return nil, err
+		}
+		return json.Marshal(s)
+	})
+}
+
+func decode(b []byte) (*queue, error) {
+	s := &queue{}
+	if b != nil {
+		if err := json.Unmarshal(b, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.AvgWait == nil {
+		s.AvgWait = map[string]time.Duration{}
+	}
+	return s, nil
+}
+
+// Enqueue adds a player and returns their ticket's status.
+func (q *Queue) Enqueue(ctx context.Context, name string, rating int, region string) (Status, error) {
+	b := make([]byte, 18)
+	rand.Read(b)
+	now := time.Now()
+	t := &ticket{
+		ID:       base64.RawURLEncoding.EncodeToString(b),
+		Name:     name,
+		Rating:   rating,
+		Region:   region,
+		Enqueued: now,
+		LastSeen: now,
+	}
+	var st Status
+	err := q.update(ctx, func(s *queue) error {
+		s.Tickets = append(s.Tickets, t)
+		st = s.status(t, now)
+		return nil
+	})
+	return st, err
+}
+
+// Status reports a ticket and marks its owner as still present.
+func (q *Queue) Status(ctx context.Context, id string) (Status, error) {
+	s, err := q.load(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	now := time.Now()
+	t := s.find(id)
+	if t == nil {
+		return Status{}, ErrNoTicket
+	}
+	if now.Sub(t.LastSeen) < seenEvery {
+		return s.status(t, now), nil
+	}
+	var st Status
+	err = q.update(ctx, func(s *queue) error {
+		t := s.find(id)
+		if t == nil {
+			return ErrNoTicket
+		}
+		t.LastSeen = now
+		st = s.status(t, now)
+		return nil
+	})
+	return st, err
+}
+
+// Cancel removes a waiting ticket. Cancelling a matched ticket only
+// forgets it; the opponent keeps their room.
+func (q *Queue) Cancel(ctx context.Context, id string) error {
+	return q.update(ctx, func(s *queue) error {
+		if s.find(id) == nil {
+			return ErrNoTicket
+		}
+		s.remove(id)
+		return nil
+	})
+}
+
+// Len returns the number of waiting tickets.
+func (q *Queue) Len(ctx context.Context) (int, error) {
+	s, err := q.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, t := range s.Tickets {
+		if t.waiting() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Run pairs tickets and expires stale ones until ctx is done. When a tick
+// fails, say for want of a shared State, the next one tries again; only
+// the first failure in a row goes to report.
+func (q *Queue) Run(ctx context.Context, report func(error)) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := q.tick(ctx, now)
+			if err != nil && !failing && ctx.Err() == nil {
+				report(err)
+			}
+			failing = err != nil
+		}
+	}
+}
+
+// tick expires and pairs tickets, then reserves the pairs' rooms. That
+// happens after the queue is stored, for fear of reserving rooms for
+// pairings another instance's tick overtook.
+func (q *Queue) tick(ctx context.Context, now time.Time) error {
+	var pairs [][2]string
+	err := q.update(ctx, func(s *queue) error {
+		pairs = nil
+		s.Tickets = slices.DeleteFunc(s.Tickets, func(t *ticket) bool {
+			if t.waiting() {
+				return now.Sub(t.LastSeen) > abandonAfter
+			}
+			return now.Sub(t.MatchedAt) > keepMatched
+		})
+
+		// Oldest first: the longest-waiting ticket takes the closest
+		// compatible rating among those behind it.
+		for i, a := range s.Tickets {
+			if !a.waiting() {
+				continue
+			}
+			var best *ticket
+			bestDiff := 0
+			for _, b := range s.Tickets[i+1:] {
+				if !b.waiting() || a.Region != b.Region {
+					continue
+				}
+				diff := abs(a.Rating - b.Rating)
+				if diff > min(a.tolerance(now), b.tolerance(now)) {
+					continue
+				}
+				if best == nil || diff < bestDiff {
+					best, bestDiff = b, diff
+				}
+			}
+			if best != nil {
+				s.pair(a, best, now)
+				pairs = append(pairs, [2]string{a.ID, best.ID})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		code, tokens, roomErr := q.newRoom(ctx, 2)
+		err := q.update(ctx, func(s *queue) error {
+			for i, id := range pair {
+				t := s.find(id)
+				switch {
+				case t == nil:
+				case roomErr != nil:
+					// Back in the queue, to be paired again.
+					t.MatchedAt, t.Opponent = time.Time{}, nil
+				default:
+					t.Room, t.RoomToken = code, tokens[i]
+				}
+			}
+			return nil
+		})
+		if err := cmp.Or(roomErr, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pair matches a with b. Their states show it once they have a room.
+func (s *queue) pair(a, b *ticket, now time.Time) {
+	for _, t := range []*ticket{a, b} {
+		t.MatchedAt = now
+		s.recordWait(t.Region, now.Sub(t.Enqueued))
+	}
+	a.Opponent = &Opponent{Name: b.Name, Rating: b.Rating}
+	b.Opponent = &Opponent{Name: a.Name, Rating: a.Rating}
+}
+
+// recordWait folds a completed wait into the region's moving average.
+func (s *queue) recordWait(region string, d time.Duration) {
+	avg, ok := s.AvgWait[region]
+	if !ok {
+		s.AvgWait[region] = d
+		return
+	}
+	s.AvgWait[region] = time.Duration(float64(avg)*(1-waitSmoothing) + float64(d)*waitSmoothing)
+}
+
+func (s *queue) status(t *ticket, now time.Time) Status {
+	st := Status{Ticket: t.ID, Waited: now.Sub(t.Enqueued)}
+	if t.Room != "" {
+		st.State, st.Room, st.RoomToken, st.Opponent = Matched, t.Room, t.RoomToken, t.Opponent
+		return st
+	}
+	st.State = Waiting
+	st.Tolerance = t.tolerance(now)
+	for _, w := range s.Tickets {
+		if w.Region == t.Region && (w.waiting() || w == t) {
+			st.Position++
+		}
+		if w == t {
+			break
+		}
+	}
+	avg, ok := s.AvgWait[t.Region]
+	if !ok {
+		avg = defaultWait
+	}
+	st.EstimatedWait = max(0, avg-st.Waited).Round(time.Second)
+	return st
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPChecker asks a moderation service over HTTP. It posts
+// {"kind": "name", "text": "..."} to URL, with Token as a bearer token if
+// set, and expects {"flagged": true} back for text to reject. That's
+// simple enough for a small adapter in front of any commercial service.
+type HTTPChecker struct {
+	URL    string
+	Token  string
+	Client *http.Client // nil for a client with a 3 second timeout
+}
+
+var defaultClient = &http.Client{Timeout: 3 * time.Second}
+
+// Check implements Checker.
+func (c *HTTPChecker) Check(ctx context.Context, kind, text string) (bool, error) {
+	body, _ := json.Marshal(map[string]string{"kind": kind, "text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+		return false, fmt.Errorf("moderation service: %s", resp.Status)
+	}
+	var v struct {
+		Flagged *bool `json:"flagged"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&v); err != nil {
+		return false, fmt.Errorf("moderation service: %w", err)
+	}
+	if v.Flagged == nil {
+		return false, errors.New("moderation service: response has no flagged field")
+	}
+	return !*v.Flagged, nil
+}
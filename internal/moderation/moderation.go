@@ -0,0 +1,218 @@
+// Package moderation decides whether text players write, such as names,
+// level titles and chat, is fit to show other players.
+//
+// Text is checked against a block list after normalising away the usual
+// ways round one: accents, look-alike letters from other scripts,
+// full-width and other compatibility forms, digits for letters, invisible
+// characters, and letters spaced out or repeated. Words on an allow list
+// are never blocked, for the innocent words a blocked term turns up in.
+// An external moderation service can be consulted as well.
+package moderation
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Kinds of text, for services that judge them differently.
+const (
+	Name    = "name"
+	Title   = "title"
+	Message = "message"
+)
+
+//go:embed blocklist.txt
+var defaultBlocklist string
+
+//go:embed allowlist.txt
+var defaultAllowlist string
+
+// Checker is an external moderation service.
+type Checker interface {
+	// Check reports whether text of the given kind is acceptable.
+	Check(ctx context.Context, kind, text string) (bool, error)
+}
+
+// Filter checks text against its lists, then its Checker if it has one.
+type Filter struct {
+	blocked  []term
+	allowed  map[string]bool
+	external Checker
+}
+
+// term is a block list entry: a normalised word, matching words it starts
+// or ends as too if the entry had a * there.
+type term struct {
+	word           string
+	prefix, suffix bool // other letters may come before or after it
+}
+
+// New returns a filter with the built-in lists plus the given extra
+// terms and allowed words, in the list format described in ParseList.
+// external may be nil.
+func New(blocked, allowed []string, external Checker) *Filter {
+	f := &Filter{allowed: map[string]bool{}, external: external}
+	for _, t := range append(ParseList(strings.NewReader(defaultBlocklist)), blocked...) {
+		t := term{word: t}
+		t.prefix = strings.HasPrefix(t.word, "*")
+		t.suffix = strings.HasSuffix(t.word, "*")
+		if words := words(strings.Trim(t.word, "*")); len(words) == 1 {
+			t.word = words[0]
+			f.blocked = append(f.blocked, t)
+		}
+	}
+	for _, w := range append(ParseList(strings.NewReader(defaultAllowlist)), allowed...) {
+		for _, w := range words(w) {
+			f.allowed[w] = true
+		}
+	}
+	return f
+}
+
+// ParseList reads a word list: one entry per line, with blank lines and
+// lines starting with # ignored. In a block list, a * at the start or
+// end of an entry lets other letters come before or after it, so
+// "*fish" blocks "catfish" as well as "fish".
+func ParseList(r io.Reader) []string {
+	var list []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			list = append(list, line)
+		}
+	}
+	return list
+}
+
+// Allowed reports whether text is acceptable. An error means the
+// external service couldn't be asked; the lists passed it.
+func (f *Filter) Allowed(ctx context.Context, kind, text string) (bool, error) {
+	if f.Blocked(text) {
+		return false, nil
+	}
+	if f.external == nil || strings.TrimSpace(text) == "" {
+		return true, nil
+	}
+	return f.external.Check(ctx, kind, text)
+}
+
+// Blocked reports whether text contains a blocked term.
+func (f *Filter) Blocked(text string) bool {
+	for _, w := range words(text) {
+		if f.allowed[w] {
+			continue
+		}
+		for _, t := range f.blocked {
+			if t.matches(w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches reports whether w is t, or for a wildcard term starts, ends or
+// contains it. Letters repeated for emphasis or to get past the list
+// still match, but "asss" collapsing to "as" doesn't make "as" blocked.
+func (t term) matches(w string) bool {
+	if !t.prefix && !t.suffix {
+		return w == t.word || len(w) > len(t.word) && squeeze(w) == squeeze(t.word)
+	}
+	if t.fits(w) {
+		return true
+	}
+	return squeeze(t.word) == t.word && t.fits(squeeze(w))
+}
+
+// fits matches a wildcard term against w as it is.
+func (t term) fits(w string) bool {
+	switch {
+	case t.prefix && t.suffix:
+		return strings.Contains(w, t.word)
+	case t.prefix:
+		return strings.HasSuffix(w, t.word)
+	}
+	return strings.HasPrefix(w, t.word)
+}
+
+// words splits normalised text into words, joining up runs of single
+// letters so "f u n" is the word "fun".
+func words(text string) []string {
+	var words []string
+	var spelt strings.Builder
+	for _, w := range strings.FieldsFunc(Normalize(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if len([]rune(w)) == 1 {
+			spelt.WriteString(w)
+			continue
+		}
+		if spelt.Len() > 0 {
+			words = append(words, spelt.String())
+			spelt.Reset()
+		}
+		words = append(words, w)
+	}
+	if spelt.Len() > 0 {
+		words = append(words, spelt.String())
+	}
+	return words
+}
+
+// Normalize folds text to the form lists are matched in: lower case,
+// compatibility forms and accents gone, look-alike letters and digits
+// mapped to the Latin letters they pass for, and invisible characters
+// removed.
+func Normalize(text string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Cf, r):
+			continue
+		}
+		r = unicode.ToLower(r)
+		if l, ok := lookalikes[r]; ok {
+			r = l
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// squeeze collapses runs of the same letter to one.
+func squeeze(w string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range w {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// lookalikes maps characters that pass for Latin letters to them:
+// Cyrillic, Greek and Armenian homoglyphs, and the digits and symbols
+// written for letters.
+var lookalikes = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'з': 'e', 'і': 'i', 'ї': 'i', 'ј': 'j',
+	'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y',
+	'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ъ': 'b', 'ь': 'b', 'п': 'n',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w', 'γ': 'y', 'μ': 'u',
+	// Armenian
+	'ս': 'u', 'օ': 'o', 'ո': 'n', 'հ': 'h', 'ց': 'g', 'ք': 'f', 'զ': 'q',
+	// Digits and symbols
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '€': 'e', '£': 'l', 'ß': 's',
+	// Latin letters NFKD leaves alone
+	'ı': 'i', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h', 'ŀ': 'l',
+}
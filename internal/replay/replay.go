@@ -0,0 +1,192 @@
+// Package replay decodes recorded input streams and checks them against
+// the score they claim, so implausible leaderboard entries can be rejected
+// without trusting the client.
+//
+// The checks are sanity bounds derived from the client's scoring rules, not
+// a full re-simulation; they catch forged and edited submissions, not a
+// player who is simply very good.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// TickRate is the fixed simulation rate the client records at.
+const TickRate = 60
+
+// Input is a bitmask of the controls held during a tick.
+type Input uint8
+
+const (
+	Left Input = 1 << iota
+	Right
+	Up
+	Down
+	DigLeft
+	DigRight
+
+	validInputs = Left | Right | Up | Down | DigLeft | DigRight
+)
+
+// Frame is an input change: from Tick onward the player holds Input.
+type Frame struct {
+	Tick  uint32
+	Input Input
+}
+
+// Format 1 is a gzip stream of 5-byte records: tick as little-endian
+// uint32, then the input bitmask.
+const (
+	Format1     = 1
+	recordSize1 = 5
+)
+
+// Decode parses a stored replay. maxInflated bounds the decompressed size.
+func Decode(format int, data []byte, maxInflated int64) ([]Frame, error) {
+	var frames []Frame
+	err := scan(format, data, maxInflated, func(f Frame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// Validate checks that data is a well-formed replay without keeping its
+// frames, so an upload can be refused before it is stored.
+func Validate(format int, data []byte, maxInflated int64) error {
+	return scan(format, data, maxInflated, func(Frame) error { return nil })
+}
+
+// scan inflates data a record at a time, calling fn with each frame, and
+// stops at the first error, so a compression bomb is cut off at
+// maxInflated bytes instead of being read into memory.
+func scan(format int, data []byte, maxInflated int64, fn func(Frame) error) error {
+	if format != Format1 {
+		return fmt.Errorf("unsupported replay format %d", format)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("replay is not gzip: %w", err)
+	}
+	br := bufio.NewReader(io.LimitReader(zr, maxInflated+1))
+	var rec [recordSize1]byte
+	var n int64
+	for {
+		k, err := io.ReadFull(br, rec[:])
+		n += int64(k)
+		if n > maxInflated {
+			return fmt.Errorf("replay inflates past %d bytes", maxInflated)
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			return fmt.Errorf("replay length %d is not a multiple of %d", n, recordSize1)
+		case err != nil:
+			return fmt.Errorf("replay is corrupt: %w", err)
+		}
+		if err := fn(Frame{Tick: binary.LittleEndian.Uint32(rec[:]), Input: Input(rec[4])}); err != nil {
+			return err
+		}
+	}
+}
+
+// Run is what a score claims about the run a replay records.
+type Run struct {
+	Level      int           // level reached
+	Difficulty string        // "" when unknown
+	Score      int64         // final score
+	Time       time.Duration // reported run time
+	Ticks      int64         // tick count declared with the replay
+}
+
+// Scoring constants mirrored from the client's GameScene.
+const (
+	goldPoints      = 100
+	trapPoints      = 50
+	levelBonusBase  = 500
+	levelBonusStep  = 100
+	maxTrapsPerSec  = 2               // a dig takes 12 frames; this is generous
+	minLevelTime    = 2 * time.Second // fastest plausible clear
+	timeSlack       = 2 * time.Second
+	timeSlackFactor = 0.10
+)
+
+// maxGold is the most gold a level can hold per difficulty.
+var maxGold = map[string]int{"easy": 8, "normal": 12, "hard": 16, "ninja": 20, "": 20}
+
+// MaxScore is an upper bound on the score reachable by clearing every
+// level before level, with all gold collected, and trapping guards as fast
+// as digging allows for the whole run.
+func MaxScore(level int, difficulty string, d time.Duration) int64 {
+	gold, ok := maxGold[difficulty]
+	if !ok {
+		gold = maxGold[""]
+	}
+	var bound int64
+	for l := 1; l <= level; l++ {
+		bound += int64(gold * goldPoints)
+		if l < level {
+			bound += int64(levelBonusBase + l*levelBonusStep)
+		}
+	}
+	bound += int64(d.Seconds()*maxTrapsPerSec+1) * trapPoints
+	return bound
+}
+
+// Check reports every way frames are inconsistent with run, joined into one
+// error. A nil error means the replay is plausible.
+func Check(run Run, frames []Frame) error {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if run.Ticks <= 0 {
+		fail("replay declares no ticks")
+	}
+	for i, f := range frames {
+		if f.Input&^validInputs != 0 {
+			fail("frame %d has unknown input bits %#x", i, uint8(f.Input&^validInputs))
+			break
+		}
+		if i > 0 && f.Tick <= frames[i-1].Tick {
+			fail("tick counts go backwards at frame %d (%d after %d)", i, f.Tick, frames[i-1].Tick)
+			break
+		}
+		if int64(f.Tick) > run.Ticks {
+			fail("frame %d at tick %d is past the declared %d ticks", i, f.Tick, run.Ticks)
+			break
+		}
+	}
+
+	recorded := time.Duration(run.Ticks) * time.Second / TickRate
+	slack := max(timeSlack, time.Duration(float64(recorded)*timeSlackFactor))
+	if diff := run.Time - recorded; diff > slack || -diff > slack {
+		fail("reported time %s doesn't match %d ticks (%s)", run.Time, run.Ticks, recorded)
+	}
+	if fastest := time.Duration(run.Level-1) * minLevelTime; recorded < fastest {
+		fail("reaching level %d takes at least %s, replay is %s", run.Level, fastest, recorded)
+	}
+	if run.Level > 1 && len(frames) == 0 {
+		fail("replay has no input but clears levels")
+	}
+	if bound := MaxScore(run.Level, run.Difficulty, recorded); run.Score > bound {
+		fail("score %d exceeds the maximum %d for level %d in %s", run.Score, bound, run.Level, recorded)
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Package sharecard renders the 1200x630 images that Discord, Twitter and
+// other sites show when a link to a run is posted: the player's name and
+// score next to a level thumbnail, in the game's colors and a pixel font.
+// Generated levels only exist in the client, so the thumbnail is one of
+// the layouts embedded in templates/, picked by level number.
+package sharecard
+
+import (
+	"embed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+)
+
+// Width and Height are the size OpenGraph and Twitter cards expect.
+const (
+	Width  = 1200
+	Height = 630
+)
+
+// tileSize is the thumbnail's tile size in image pixels.
+const tileSize = 20
+
+// Colors of the Cyber Blue theme, matching src/config.ts.
+var (
+	background = color.RGBA{0x0a, 0x0a, 0x1a, 0xff}
+	scanline   = color.RGBA{0x0e, 0x0e, 0x24, 0xff}
+	brick      = color.RGBA{0x2a, 0x3a, 0x6a, 0xff}
+	mortar     = color.RGBA{0x1a, 0x22, 0x44, 0xff}
+	brickHard  = color.RGBA{0x1a, 0x2a, 0x4a, 0xff}
+	cyan       = color.RGBA{0x00, 0xff, 0xff, 0xff}
+	dimCyan    = color.RGBA{0x00, 0x66, 0x66, 0xff}
+	pole       = color.RGBA{0x66, 0x66, 0xaa, 0xff}
+	gold       = color.RGBA{0xff, 0x00, 0xff, 0xff}
+	player     = color.RGBA{0x00, 0xff, 0x88, 0xff}
+	enemy      = color.RGBA{0xff, 0x33, 0x44, 0xff}
+)
+
+//go:embed templates/*.txt
+var templateFiles embed.FS
+
+// thumbnails are the parsed templates, in file name order.
+var thumbnails = loadThumbnails()
+
+func loadThumbnails() []*level.Grid {
+	entries, err := templateFiles.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+	var grids []*level.Grid
+	for _, e := range entries {
+		b, err := templateFiles.ReadFile(path.Join("templates", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		g, err := level.Parse(strings.Split(strings.TrimRight(string(b), "\n"), "\n"))
+		if err != nil {
+			panic("sharecard: " + e.Name() + ": " + err.Error())
+		}
+		grids = append(grids, g)
+	}
+	return grids
+}
+
+// Card is what a share image shows.
+type Card struct {
+	Player  string
+	Score   int64
+	Level   int      // picks the thumbnail
+	Details []string // short lines under the score, such as "LEVEL 3  HARD"
+	Footer  string   // where to play, such as the site's hostname
+}
+
+// Render writes c as a PNG.
+func Render(w io.Writer, c Card) error {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	fill(img, img.Bounds(), background)
+	for y := 0; y < Height; y += 4 {
+		fill(img, image.Rect(0, y, Width, y+1), scanline)
+	}
+	frame(img, image.Rect(20, 20, Width-20, Height-20), 4, dimCyan)
+
+	text(img, 60, 56, 6, cyan, "LODE RUNNER 2099")
+
+	thumb := thumbnails[(max(c.Level, 1)-1)%len(thumbnails)]
+	origin := image.Pt(60, 150)
+	frame(img, image.Rect(origin.X-8, origin.Y-8, origin.X+level.Width*tileSize+8, origin.Y+level.Height*tileSize+8), 4, cyan)
+	for y := 0; y < level.Height; y++ {
+		for x := 0; x < level.Width; x++ {
+			tile(img, origin.Add(image.Pt(x*tileSize, y*tileSize)), thumb.At(x, y))
+		}
+	}
+
+	const column, columnWidth = 660, 480
+	name := strings.ToUpper(c.Player)
+	text(img, column, 160, fit(name, columnWidth, 8), player, name)
+	text(img, column, 250, 3, pole, "SCORE")
+	score := strconv.FormatInt(c.Score, 10)
+	text(img, column, 280, fit(score, columnWidth, 9), gold, score)
+	for i, line := range c.Details {
+		line = strings.ToUpper(line)
+		text(img, column, 380+i*50, fit(line, columnWidth, 4), cyan, line)
+	}
+	if c.Footer != "" {
+		footer := strings.ToUpper(c.Footer)
+		text(img, 60, Height-70, fit(footer, Width-120, 3), pole, footer)
+	}
+	return (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(w, img)
+}
+
+// fit returns the largest scale, up to most, at which s fits in width.
+func fit(s string, width, most int) int {
+	n := max(len([]rune(s)), 1)
+	return max(min(most, width/(n*(glyphWidth+1))), 1)
+}
+
+// text draws s with its top left corner at x, y, each font pixel scale
+// image pixels wide.
+func text(img *image.RGBA, x, y, scale int, c color.Color, s string) {
+	for _, r := range s {
+		g := glyph(r)
+		for gy, row := range g {
+			for gx := 0; gx < glyphWidth; gx++ {
+				if row[gx] != ' ' {
+					px, py := x+gx*scale, y+gy*scale
+					fill(img, image.Rect(px, py, px+scale, py+scale), c)
+				}
+			}
+		}
+		x += (glyphWidth + 1) * scale
+	}
+}
+
+// tile draws t with its top left corner at p.
+func tile(img *image.RGBA, p image.Point, t level.Tile) {
+	at := func(x0, y0, x1, y1 int) image.Rectangle {
+		return image.Rect(p.X+x0, p.Y+y0, p.X+x1, p.Y+y1)
+	}
+	switch t {
+	case level.Brick, level.Trap:
+		fill(img, at(0, 0, tileSize, tileSize), brick)
+		fill(img, at(0, 9, tileSize, 11), mortar)
+		fill(img, at(9, 0, 11, 9), mortar)
+		fill(img, at(0, 11, 2, tileSize), mortar)
+	case level.Solid:
+		fill(img, at(0, 0, tileSize, tileSize), brickHard)
+		frame(img, at(0, 0, tileSize, tileSize), 2, brick)
+	case level.Ladder, level.ExitLadder:
+		c := cyan
+		if t == level.ExitLadder {
+			// Hidden until the gold is in.
+			c = dimCyan
+		}
+		fill(img, at(3, 0, 6, tileSize), c)
+		fill(img, at(14, 0, 17, tileSize), c)
+		for y := 3; y < tileSize; y += 6 {
+			fill(img, at(6, y, 14, y+2), c)
+		}
+	case level.Pole:
+		fill(img, at(0, 3, tileSize, 6), pole)
+	case level.Gold:
+		fill(img, at(5, 10, 15, 18), gold)
+		fill(img, at(7, 8, 13, 10), gold)
+	case level.Guard, level.Player:
+		c := player
+		if t == level.Guard {
+			c = enemy
+		}
+		fill(img, at(7, 1, 13, 7), c)
+		fill(img, at(5, 7, 15, 14), c)
+		fill(img, at(5, 14, 8, tileSize), c)
+		fill(img, at(12, 14, 15, tileSize), c)
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// frame draws a border of the given thickness just inside r.
+func frame(img *image.RGBA, r image.Rectangle, thickness int, c color.Color) {
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+thickness), c)
+	fill(img, image.Rect(r.Min.X, r.Max.Y-thickness, r.Max.X, r.Max.Y), c)
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+thickness, r.Max.Y), c)
+	fill(img, image.Rect(r.Max.X-thickness, r.Min.Y, r.Max.X, r.Max.Y), c)
+}
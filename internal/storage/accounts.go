@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrUsernameTaken is returned when registering an existing username.
+var ErrUsernameTaken = errors.New("storage: username taken")
+
+// Player is a registered account.
+type Player struct {
+	ID          int64     `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const playerColumns = "id, username, display_name, created_at"
+
+// CreatePlayer registers an account. username must already be
+// canonicalized; passwordHash may be empty for OAuth-only accounts.
+func (db *DB) CreatePlayer(ctx context.Context, username, displayName, passwordHash string) (Player, error) {
+	p := Player{Username: username, DisplayName: displayName, CreatedAt: time.Now().UTC()}
+	err := db.sql.QueryRowContext(ctx, `
+		INSERT INTO players (username, display_name, password_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		username, displayName, nullString(passwordHash), p.CreatedAt.UnixMilli(),
+	).Scan(&p.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, ErrUsernameTaken
+	}
+	return p, err
+}
+
+// PlayerByUsername returns the account and its password hash, which is
+// empty if the account has no password.
+func (db *DB) PlayerByUsername(ctx context.Context, username string) (Player, string, error) {
+	var hash sql.NullString
+	row := db.sql.QueryRowContext(ctx, "SELECT "+playerColumns+", password_hash FROM players WHERE username = ?", username)
+	p, err := scanPlayer(row, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, "", ErrNotFound
+	}
+	return p, hash.String, err
+}
+
+// GetPlayer returns the account with this ID.
+func (db *DB) GetPlayer(ctx context.Context, id int64) (Player, error) {
+	row := db.sql.QueryRowContext(ctx, "SELECT "+playerColumns+" FROM players WHERE id = ?", id)
+	p, err := scanPlayer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, ErrNotFound
+	}
+	return p, err
+}
+
+// CreateSession stores a login session for playerID.
+func (db *DB) CreateSession(ctx context.Context, tokenHash string, playerID int64, expires time.Time) error {
+	_, err := db.sql.ExecContext(ctx,
+		"INSERT INTO sessions (token_hash, player_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		tokenHash, playerID, time.Now().UnixMilli(), expires.UnixMilli())
+	return err
+}
+
+// SessionPlayer returns the player an unexpired session belongs to.
+func (db *DB) SessionPlayer(ctx context.Context, tokenHash string) (Player, error) {
+	row := db.sql.QueryRowContext(ctx, `
+		SELECT p.id, p.username, p.display_name, p.created_at
+		FROM sessions s JOIN players p ON p.id = s.player_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`,
+		tokenHash, time.Now().UnixMilli())
+	p, err := scanPlayer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, ErrNotFound
+	}
+	return p, err
+}
+
+// DeleteSession ends a session.
+func (db *DB) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := db.sql.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions past their expiry and reports how
+// many there were.
+func (db *DB) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	res, err := db.sql.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= ?", time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanPlayer(row rowScanner, extra ...any) (Player, error) {
+	var p Player
+	var created int64
+	err := row.Scan(append([]any{&p.ID, &p.Username, &p.DisplayName, &created}, extra...)...)
+	p.CreatedAt = time.UnixMilli(created).UTC()
+	return p, err
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UpdateStats applies stat updates for playerID and returns all of the
+// player's stats afterwards. Stats in maxima keep the larger of the stored
+// and given value; the others are incremented by it.
+func (db *DB) UpdateStats(ctx context.Context, playerID int64, updates map[string]int64, maxima func(stat string) bool) (map[string]int64, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for stat, v := range updates {
+		q := `
+			INSERT INTO player_stats (player_id, stat, value) VALUES (?, ?, ?)
+			ON CONFLICT (player_id, stat) DO UPDATE SET value = player_stats.value + excluded.value`
+		if maxima(stat) {
+			q = `
+			INSERT INTO player_stats (player_id, stat, value) VALUES (?, ?, ?)
+			ON CONFLICT (player_id, stat) DO UPDATE SET value = CASE WHEN excluded.value > player_stats.value THEN excluded.value ELSE player_stats.value END`
+		}
+		if _, err := tx.ExecContext(ctx, q, playerID, stat, v); err != nil {
+			return nil, err
+		}
+	}
+	stats, err := queryStats(ctx, tx, playerID)
+	if err != nil {
+		return nil, err
+	}
+	return stats, tx.Commit()
+}
+
+// PlayerStats returns the player's stats.
+func (db *DB) PlayerStats(ctx context.Context, playerID int64) (map[string]int64, error) {
+	return queryStats(ctx, db.sql, playerID)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryStats(ctx context.Context, q querier, playerID int64) (map[string]int64, error) {
+	rows, err := q.QueryContext(ctx, "SELECT stat, value FROM player_stats WHERE player_id = ?", playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make(map[string]int64)
+	for rows.Next() {
+		var stat string
+		var v int64
+		if err := rows.Scan(&stat, &v); err != nil {
+			return nil, err
+		}
+		stats[stat] = v
+	}
+	return stats, rows.Err()
+}
+
+// UnlockAchievements records unlocks for playerID and returns the IDs that
+// weren't already unlocked.
+func (db *DB) UnlockAchievements(ctx context.Context, playerID int64, ids []string) ([]string, error) {
+	var unlocked []string
+	now := time.Now().UnixMilli()
+	for _, id := range ids {
+		res, err := db.sql.ExecContext(ctx, `
+			INSERT INTO player_achievements (player_id, achievement, unlocked_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			playerID, id, now)
+		if err != nil {
+			return unlocked, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			unlocked = append(unlocked, id)
+		}
+	}
+	return unlocked, nil
+}
+
+// PlayerAchievements returns when the player unlocked each of their
+// achievements.
+func (db *DB) PlayerAchievements(ctx context.Context, playerID int64) (map[string]time.Time, error) {
+	rows, err := db.sql.QueryContext(ctx,
+		"SELECT achievement, unlocked_at FROM player_achievements WHERE player_id = ?", playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var at int64
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		out[id] = time.UnixMilli(at).UTC()
+	}
+	return out, rows.Err()
+}
+
+// AchievementCounts returns how many players have unlocked each
+// achievement, and how many players there are in total.
+func (db *DB) AchievementCounts(ctx context.Context) (map[string]int64, int64, error) {
+	var players int64
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM players").Scan(&players); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.sql.QueryContext(ctx,
+		"SELECT achievement, COUNT(*) FROM player_achievements GROUP BY achievement")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, 0, err
+		}
+		counts[id] = n
+	}
+	return counts, players, rows.Err()
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoSnapshots is returned by Snapshot for a PostgreSQL database, which
+// is backed up with its own tools.
+var ErrNoSnapshots = errors.New("storage: snapshots need a SQLite database; back up PostgreSQL with pg_dump")
+
+// Snapshot writes a consistent copy of the database to path, which must
+// not exist, with VACUUM INTO. The database stays usable meanwhile, and
+// the copy is compacted and needs no -wal file beside it.
+func (db *DB) Snapshot(ctx context.Context, path string) error {
+	if db.sql.dialect != sqlite {
+		return ErrNoSnapshots
+	}
+	_, err := db.sql.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
+}
+
+// IntegrityCheck runs SQLite's integrity check, returning an error naming
+// the first problem it finds.
+func (db *DB) IntegrityCheck(ctx context.Context) error {
+	if db.sql.dialect != sqlite {
+		return ErrNoSnapshots
+	}
+	var result string
+	if err := db.sql.QueryRowContext(ctx, `PRAGMA integrity_check(1)`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("storage: integrity check: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ChatMessage is a line of lobby chat. Name is what the sender was called
+// in the room; PlayerID, the account that sent it, is only shown to
+// moderators.
+type ChatMessage struct {
+	ID        int64     `json:"id"`
+	Room      string    `json:"room"`
+	PlayerID  int64     `json:"player_id,omitempty"`
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatMute stops a player chatting until ExpiresAt, or for good if it is
+// nil.
+type ChatMute struct {
+	PlayerID  int64      `json:"player_id"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+const chatColumns = "id, room, player_id, name, text, created_at"
+
+// AddChatMessage stores a line of chat.
+func (db *DB) AddChatMessage(ctx context.Context, m ChatMessage) (ChatMessage, error) {
+	m.CreatedAt = time.Now().UTC()
+	err := db.sql.QueryRowContext(ctx, `
+		INSERT INTO chat_messages (room, player_id, name, text, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id`,
+		m.Room, m.PlayerID, m.Name, m.Text, m.CreatedAt.UnixMilli(),
+	).Scan(&m.ID)
+	return m, err
+}
+
+// ChatHistory returns the last limit lines of a room's chat since it
+// opened, oldest first.
+func (db *DB) ChatHistory(ctx context.Context, room string, since time.Time, limit int) ([]ChatMessage, error) {
+	messages := []ChatMessage{}
+	err := db.each(ctx, func(r rowScanner) error {
+		m, err := scanChatMessage(r)
+		messages = append(messages, m)
+		return err
+	}, "SELECT "+chatColumns+" FROM chat_messages WHERE room = ? AND created_at >= ? ORDER BY id DESC LIMIT ?",
+		room, since.UnixMilli(), limit)
+	slices.Reverse(messages)
+	return messages, err
+}
+
+// ChatMessages returns one page of chat, newest first, in one room or
+// from one player if room or playerID is set, and how many lines match.
+func (db *DB) ChatMessages(ctx context.Context, room string, playerID int64, limit, offset int) ([]ChatMessage, int, error) {
+	where, args := " WHERE 1 = 1", []any{}
+	if room != "" {
+		where += " AND room = ?"
+		args = append(args, room)
+	}
+	if playerID != 0 {
+		where += " AND player_id = ?"
+		args = append(args, playerID)
+	}
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM chat_messages"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	messages := []ChatMessage{}
+	err := db.each(ctx, func(r rowScanner) error {
+		m, err := scanChatMessage(r)
+		messages = append(messages, m)
+		return err
+	}, "SELECT "+chatColumns+" FROM chat_messages"+where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	return messages, total, err
+}
+
+// DeleteChatMessage removes a line of chat.
+func (db *DB) DeleteChatMessage(ctx context.Context, id int64) error {
+	return execOne(ctx, db, "DELETE FROM chat_messages WHERE id = ?", id)
+}
+
+// PruneChat deletes chat older than before and mutes that have run out,
+// and reports how many lines went.
+func (db *DB) PruneChat(ctx context.Context, before time.Time) (int64, error) {
+	if _, err := db.sql.ExecContext(ctx, "DELETE FROM chat_mutes WHERE expires_at <= ?", time.Now().UnixMilli()); err != nil {
+		return 0, err
+	}
+	res, err := db.sql.ExecContext(ctx, "DELETE FROM chat_messages WHERE created_at < ?", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MuteChat mutes a player, replacing any mute they already have. A player
+// who doesn't exist returns ErrNotFound.
+func (db *DB) MuteChat(ctx context.Context, m ChatMute) (ChatMute, error) {
+	m.CreatedAt = time.Now().UTC()
+	var expires sql.NullInt64
+	if m.ExpiresAt != nil {
+		expires = sql.NullInt64{Int64: m.ExpiresAt.UnixMilli(), Valid: true}
+	}
+	var exists bool
+	if err := db.sql.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM players WHERE id = ?)", m.PlayerID).Scan(&exists); err != nil {
+		return ChatMute{}, err
+	}
+	if !exists {
+		return ChatMute{}, ErrNotFound
+	}
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO chat_mutes (player_id, reason, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (player_id) DO UPDATE
+		SET reason = excluded.reason, created_by = excluded.created_by,
+			created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		m.PlayerID, m.Reason, m.CreatedBy, m.CreatedAt.UnixMilli(), expires)
+	return m, err
+}
+
+// UnmuteChat lifts a player's mute.
+func (db *DB) UnmuteChat(ctx context.Context, playerID int64) error {
+	return execOne(ctx, db, "DELETE FROM chat_mutes WHERE player_id = ?", playerID)
+}
+
+// ChatMuted returns the player's mute at now, or ErrNotFound if they
+// aren't muted.
+func (db *DB) ChatMuted(ctx context.Context, playerID int64, now time.Time) (ChatMute, error) {
+	m, err := scanChatMute(db.sql.QueryRowContext(ctx, `
+		SELECT player_id, reason, created_by, created_at, expires_at FROM chat_mutes
+		WHERE player_id = ? AND (expires_at IS NULL OR expires_at > ?)`, playerID, now.UnixMilli()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChatMute{}, ErrNotFound
+	}
+	return m, err
+}
+
+// ChatMutes returns the mutes in force at now, newest first.
+func (db *DB) ChatMutes(ctx context.Context, now time.Time) ([]ChatMute, error) {
+	mutes := []ChatMute{}
+	err := db.each(ctx, func(r rowScanner) error {
+		m, err := scanChatMute(r)
+		mutes = append(mutes, m)
+		return err
+	}, `
+		SELECT player_id, reason, created_by, created_at, expires_at FROM chat_mutes
+		WHERE expires_at IS NULL OR expires_at > ?
+		ORDER BY created_at DESC`, now.UnixMilli())
+	return mutes, err
+}
+
+func scanChatMessage(row rowScanner) (ChatMessage, error) {
+	var m ChatMessage
+	var created int64
+	err := row.Scan(&m.ID, &m.Room, &m.PlayerID, &m.Name, &m.Text, &created)
+	m.CreatedAt = time.UnixMilli(created).UTC()
+	return m, err
+}
+
+func scanChatMute(row rowScanner) (ChatMute, error) {
+	var m ChatMute
+	var created int64
+	var expires sql.NullInt64
+	if err := row.Scan(&m.PlayerID, &m.Reason, &m.CreatedBy, &created, &expires); err != nil {
+		return ChatMute{}, err
+	}
+	m.CreatedAt = time.UnixMilli(created).UTC()
+	if expires.Valid {
+		t := time.UnixMilli(expires.Int64).UTC()
+		m.ExpiresAt = &t
+	}
+	return m, nil
+}
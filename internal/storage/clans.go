@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrClanTaken is returned when creating a clan whose name or tag is
+	// already used.
+	ErrClanTaken = errors.New("storage: clan name or tag taken")
+	// ErrInClan means the player already belongs to a clan.
+	ErrInClan = errors.New("storage: player already in a clan")
+	// ErrClanFull means the clan has as many members as it may.
+	ErrClanFull = errors.New("storage: clan full")
+	// ErrInviteCodeTaken means a new invite code collided with another
+	// clan's; try another.
+	ErrInviteCodeTaken = errors.New("storage: invite code taken")
+)
+
+// Clan roles. The owner can replace the invite code and remove members;
+// when they leave, the longest-standing member takes over.
+const (
+	ClanRoleOwner  = "owner"
+	ClanRoleMember = "member"
+)
+
+// Clan is a team of players. Score is the sum of its members' best scores
+// on the board it was listed from; Rank is its place there.
+type Clan struct {
+	ID          int64     `json:"id"`
+	Rank        int       `json:"rank,omitempty"`
+	Name        string    `json:"name"`
+	Tag         string    `json:"tag"`
+	Description string    `json:"description"`
+	Members     int       `json:"members"`
+	Score       int64     `json:"score"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// InviteCode is shown to members only.
+	InviteCode string `json:"-"`
+}
+
+// ClanMember is a member of a clan with their best score.
+type ClanMember struct {
+	PlayerID int64     `json:"player_id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Best     int64     `json:"best"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ClanBoard selects which scores count towards clan totals: those of one
+// season if Season is set, and those submitted in [From, To) if set.
+// Scores hidden by a moderator or with a rejected replay never count.
+type ClanBoard struct {
+	Season   int64
+	From, To time.Time
+}
+
+// totals returns a query of each clan's total, the sum of its members'
+// best scores on the board, as rows of clan_id and score.
+func (b ClanBoard) totals() (string, []any) {
+	conds := []string{"s.flagged = 0", "s.status <> ?"}
+	args := []any{StatusRejected}
+	if b.Season > 0 {
+		conds = append(conds, "s.season = ?")
+		args = append(args, b.Season)
+	}
+	if !b.From.IsZero() {
+		conds = append(conds, "s.created_at >= ?", "s.created_at < ?")
+		args = append(args, b.From.UnixMilli(), b.To.UnixMilli())
+	}
+	return `SELECT clan_id, CAST(SUM(best) AS BIGINT) AS score FROM (
+			SELECT m.clan_id, m.player_id, MAX(s.score) AS best
+			FROM clan_members m JOIN scores s ON s.player_id = m.player_id
+			WHERE ` + strings.Join(conds, " AND ") + `
+			GROUP BY m.clan_id, m.player_id
+		) best GROUP BY clan_id`, args
+}
+
+const clanColumns = "c.id, c.name, c.tag, c.description, c.invite_code, c.created_at, " +
+	"(SELECT COUNT(*) FROM clan_members WHERE clan_id = c.id), COALESCE(t.score, 0)"
+
+// CreateClan creates a clan with owner as its first member. Names are
+// unique regardless of case, and tags exactly.
+func (db *DB) CreateClan(ctx context.Context, c Clan, ownerID int64) (Clan, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return Clan{}, err
+	}
+	defer tx.Rollback()
+	c.CreatedAt = time.Now().UTC()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO clans (name, name_key, tag, description, invite_code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		c.Name, strings.ToLower(c.Name), c.Tag, c.Description, c.InviteCode, c.CreatedAt.UnixMilli(),
+	).Scan(&c.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		var taken bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM clans WHERE name_key = ? OR tag = ?)",
+			strings.ToLower(c.Name), c.Tag).Scan(&taken); err != nil {
+			return Clan{}, err
+		}
+		if taken {
+			return Clan{}, ErrClanTaken
+		}
+		return Clan{}, ErrInviteCodeTaken
+	}
+	if err != nil {
+		return Clan{}, err
+	}
+	if err := addClanMember(ctx, tx, c.ID, ownerID, ClanRoleOwner); err != nil {
+		return Clan{}, err
+	}
+	c.Members = 1
+	return c, tx.Commit()
+}
+
+// addClanMember puts a player in a clan, returning ErrInClan if they
+// already are in one.
+func addClanMember(ctx context.Context, tx *tx, clanID, playerID int64, role string) error {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO clan_members (player_id, clan_id, role, joined_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		playerID, clanID, role, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInClan
+	}
+	return nil
+}
+
+// GetClan returns a clan with its all-time total.
+func (db *DB) GetClan(ctx context.Context, id int64) (Clan, error) {
+	totals, args := ClanBoard{}.totals()
+	c, err := scanClan(db.sql.QueryRowContext(ctx, "SELECT "+clanColumns+" FROM clans c LEFT JOIN ("+totals+") t ON t.clan_id = c.id WHERE c.id = ?",
+		append(args, id)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Clan{}, ErrNotFound
+	}
+	return c, err
+}
+
+// PlayerClan returns the ID of the clan a player belongs to and their role
+// in it.
+func (db *DB) PlayerClan(ctx context.Context, playerID int64) (clanID int64, role string, err error) {
+	err = db.sql.QueryRowContext(ctx, "SELECT clan_id, role FROM clan_members WHERE player_id = ?", playerID).Scan(&clanID, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", ErrNotFound
+	}
+	return clanID, role, err
+}
+
+// ClanMembers lists a clan's members, best all-time score first.
+func (db *DB) ClanMembers(ctx context.Context, clanID int64) ([]ClanMember, error) {
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT m.player_id, p.display_name, m.role, m.joined_at,
+			COALESCE((SELECT MAX(score) FROM scores WHERE player_id = m.player_id AND flagged = 0 AND status <> ?), 0) AS best
+		FROM clan_members m JOIN players p ON p.id = m.player_id
+		WHERE m.clan_id = ?
+		ORDER BY best DESC, m.joined_at ASC, m.player_id ASC`, StatusRejected, clanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []ClanMember{}
+	for rows.Next() {
+		var m ClanMember
+		var joined int64
+		if err := rows.Scan(&m.PlayerID, &m.Name, &m.Role, &joined, &m.Best); err != nil {
+			return nil, err
+		}
+		m.JoinedAt = time.UnixMilli(joined).UTC()
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// JoinClan adds a player to the clan with the invite code, if it has fewer
+// than maxMembers.
+func (db *DB) JoinClan(ctx context.Context, code string, playerID int64, maxMembers int) (int64, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var id int64
+	var members int
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, (SELECT COUNT(*) FROM clan_members WHERE clan_id = clans.id) FROM clans WHERE invite_code = ?", code,
+	).Scan(&id, &members)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if members >= maxMembers {
+		return 0, ErrClanFull
+	}
+	if err := addClanMember(ctx, tx, id, playerID, ClanRoleMember); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// LeaveClan takes a player out of their clan. A clan left empty is
+// deleted.
+func (db *DB) LeaveClan(ctx context.Context, playerID int64) error {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := leaveClan(ctx, tx, playerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// leaveClan takes a player out of their clan, handing an owner's role to
+// the longest-standing member, or deleting the clan if no one is left.
+func leaveClan(ctx context.Context, tx *tx, playerID int64) error {
+	var clanID int64
+	var role string
+	err := tx.QueryRowContext(ctx, "SELECT clan_id, role FROM clan_members WHERE player_id = ?", playerID).Scan(&clanID, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM clan_members WHERE player_id = ?", playerID); err != nil {
+		return err
+	}
+	if role != ClanRoleOwner {
+		return nil
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE clan_members SET role = ? WHERE player_id = (
+			SELECT player_id FROM clan_members WHERE clan_id = ? ORDER BY joined_at ASC, player_id ASC LIMIT 1)`,
+		ClanRoleOwner, clanID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM clans WHERE id = ?", clanID)
+	}
+	return err
+}
+
+// RemoveClanMember removes a member, not the owner, from a clan.
+func (db *DB) RemoveClanMember(ctx context.Context, clanID, playerID int64) error {
+	return execOne(ctx, db, "DELETE FROM clan_members WHERE clan_id = ? AND player_id = ? AND role <> ?", clanID, playerID, ClanRoleOwner)
+}
+
+// SetClanInviteCode replaces a clan's invite code, so the old one stops
+// working.
+func (db *DB) SetClanInviteCode(ctx context.Context, clanID int64, code string) error {
+	res, err := db.sql.ExecContext(ctx, `
+		UPDATE clans SET invite_code = ? WHERE id = ?
+			AND NOT EXISTS (SELECT 1 FROM clans WHERE invite_code = ?)`, code, clanID, code)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	if _, err := db.GetClan(ctx, clanID); err != nil {
+		return err
+	}
+	return ErrInviteCodeTaken
+}
+
+// ClanLeaderboard lists clans by their total on board, best first. total
+// is the number of clans.
+func (db *DB) ClanLeaderboard(ctx context.Context, board ClanBoard, limit, offset int) ([]Clan, int, error) {
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM clans").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	totals, args := board.totals()
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+clanColumns+" FROM clans c LEFT JOIN ("+totals+`) t ON t.clan_id = c.id
+		ORDER BY COALESCE(t.score, 0) DESC, c.id ASC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	clans := []Clan{}
+	for rows.Next() {
+		c, err := scanClan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		c.Rank = offset + len(clans) + 1
+		clans = append(clans, c)
+	}
+	return clans, total, rows.Err()
+}
+
+// ClanRank returns the 1-based position of a clan with the given total on
+// board.
+func (db *DB) ClanRank(ctx context.Context, board ClanBoard, score int64) (int, error) {
+	totals, args := board.totals()
+	var better int
+	err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+totals+") t WHERE t.score > ?", append(args, score)...).Scan(&better)
+	return better + 1, err
+}
+
+// ClanWeek is a week of the clan-vs-clan rotation. Week is its ISO week,
+// like 2026-W03.
+type ClanWeek struct {
+	Week     string        `json:"week"`
+	StartsAt time.Time     `json:"starts_at"`
+	EndsAt   time.Time     `json:"ends_at"`
+	Settled  bool          `json:"settled"`
+	Matchups []ClanMatchup `json:"matchups"`
+}
+
+// ClanMatchup pairs two clans for a week; each scores the sum of its
+// members' best runs that week. The second clan is nil for a bye, and
+// either is nil if it has since been disbanded. WinnerID is set once the
+// week is settled; a tie has none.
+type ClanMatchup struct {
+	Slot     int             `json:"slot"`
+	Clans    [2]*MatchupClan `json:"clans"`
+	WinnerID int64           `json:"winner_id,omitempty"`
+}
+
+// MatchupClan is one side of a matchup.
+type MatchupClan struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Tag   string `json:"tag"`
+	Score int64  `json:"score"`
+}
+
+// DrawClanWeek pairs clans with members at random for the week running
+// from start to end, unless it already has been. The draw is seeded by
+// the week, but which clans exist depends on when it happens, so it is
+// stored.
+func (db *DB) DrawClanWeek(ctx context.Context, week string, start, end time.Time) error {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "INSERT INTO clan_weeks (week, starts_at, ends_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		week, start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM clans WHERE EXISTS (SELECT 1 FROM clan_members WHERE clan_id = clans.id) ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(week))
+	rng := rand.New(rand.NewPCG(h.Sum64(), 0))
+	rng.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	for slot := 0; slot*2 < len(ids); slot++ {
+		a, b := ids[2*slot], int64(0)
+		if 2*slot+1 < len(ids) {
+			b = ids[2*slot+1]
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO clan_matchups (week, slot, clan_a, clan_b) VALUES (?, ?, ?, ?)",
+			week, slot, a, nullInt64(b)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SettleClanWeeks records the scores and winners of every week that had
+// ended by now. A clan's score is its members' at the time.
+func (db *DB) SettleClanWeeks(ctx context.Context, now time.Time) (int, error) {
+	settled := 0
+	for {
+		w, err := db.unsettledWeek(ctx, now)
+		if errors.Is(err, sql.ErrNoRows) {
+			return settled, nil
+		}
+		if err != nil {
+			return settled, err
+		}
+		if err := db.settleClanWeek(ctx, w); err != nil {
+			return settled, err
+		}
+		settled++
+	}
+}
+
+func (db *DB) unsettledWeek(ctx context.Context, now time.Time) (ClanWeek, error) {
+	var w ClanWeek
+	var start, end int64
+	err := db.sql.QueryRowContext(ctx,
+		"SELECT week, starts_at, ends_at FROM clan_weeks WHERE settled = 0 AND ends_at <= ? ORDER BY starts_at LIMIT 1", now.UnixMilli(),
+	).Scan(&w.Week, &start, &end)
+	w.StartsAt, w.EndsAt = time.UnixMilli(start).UTC(), time.UnixMilli(end).UTC()
+	return w, err
+}
+
+func (db *DB) settleClanWeek(ctx context.Context, w ClanWeek) error {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Claim the week, in case another instance is settling it too.
+	res, err := tx.ExecContext(ctx, "UPDATE clan_weeks SET settled = 1 WHERE week = ? AND settled = 0", w.Week)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	matchups, err := clanMatchups(ctx, tx, w)
+	if err != nil {
+		return err
+	}
+	for _, m := range matchups {
+		var scores [2]sql.NullInt64
+		for i, c := range m.Clans {
+			if c != nil {
+				scores[i] = sql.NullInt64{Int64: c.Score, Valid: true}
+			}
+		}
+		a, b := m.Clans[0], m.Clans[1]
+		var winner int64
+		switch {
+		case a != nil && (b == nil || a.Score > b.Score):
+			winner = a.ID
+		case b != nil && (a == nil || b.Score > a.Score):
+			winner = b.ID
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE clan_matchups SET score_a = ?, score_b = ?, winner_id = ? WHERE week = ? AND slot = ?",
+			scores[0], scores[1], nullInt64(winner), w.Week, m.Slot); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetClanWeek returns a week of the rotation with its matchups. Until it
+// is settled, the scores are live.
+func (db *DB) GetClanWeek(ctx context.Context, week string) (ClanWeek, error) {
+	w := ClanWeek{Week: week}
+	var start, end int64
+	err := db.sql.QueryRowContext(ctx, "SELECT starts_at, ends_at, settled FROM clan_weeks WHERE week = ?", week).Scan(&start, &end, &w.Settled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ClanWeek{}, ErrNotFound
+	}
+	if err != nil {
+		return ClanWeek{}, err
+	}
+	w.StartsAt, w.EndsAt = time.UnixMilli(start).UTC(), time.UnixMilli(end).UTC()
+	w.Matchups, err = clanMatchups(ctx, db.sql, w)
+	return w, err
+}
+
+// clanMatchups returns a week's matchups, with the recorded scores of a
+// settled week and otherwise the scores as they stand.
+func clanMatchups(ctx context.Context, q querier, w ClanWeek) ([]ClanMatchup, error) {
+	scores, joins, args := "m.score_a, m.score_b", "", []any{}
+	if !w.Settled {
+		totals, targs := ClanBoard{From: w.StartsAt, To: w.EndsAt}.totals()
+		scores = "COALESCE(ta.score, 0), COALESCE(tb.score, 0)"
+		joins = "LEFT JOIN (" + totals + ") ta ON ta.clan_id = m.clan_a LEFT JOIN (" + totals + ") tb ON tb.clan_id = m.clan_b"
+		args = append(append(args, targs...), targs...)
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT m.slot, m.winner_id, a.id, a.name, a.tag, b.id, b.name, b.tag, `+scores+`
+		FROM clan_matchups m
+		LEFT JOIN clans a ON a.id = m.clan_a
+		LEFT JOIN clans b ON b.id = m.clan_b
+		`+joins+`
+		WHERE m.week = ?
+		ORDER BY m.slot`, append(args, w.Week)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matchups := []ClanMatchup{}
+	for rows.Next() {
+		var m ClanMatchup
+		var winner sql.NullInt64
+		var ids, scores [2]sql.NullInt64
+		var names, tags [2]sql.NullString
+		if err := rows.Scan(&m.Slot, &winner, &ids[0], &names[0], &tags[0], &ids[1], &names[1], &tags[1], &scores[0], &scores[1]); err != nil {
+			return nil, err
+		}
+		m.WinnerID = winner.Int64
+		for i := range m.Clans {
+			if ids[i].Valid {
+				m.Clans[i] = &MatchupClan{ID: ids[i].Int64, Name: names[i].String, Tag: tags[i].String, Score: scores[i].Int64}
+			}
+		}
+		matchups = append(matchups, m)
+	}
+	return matchups, rows.Err()
+}
+
+func scanClan(row rowScanner) (Clan, error) {
+	var c Clan
+	var created int64
+	err := row.Scan(&c.ID, &c.Name, &c.Tag, &c.Description, &c.InviteCode, &created, &c.Members, &c.Score)
+	c.CreatedAt = time.UnixMilli(created).UTC()
+	return c, err
+}
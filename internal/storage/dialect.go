@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+)
+
+// dialect is what differs between the databases a Driver talks to.
+type dialect struct {
+	// name is the directory under migrations/ with the dialect's schema.
+	name string
+
+	// numbered is set for $1, $2 placeholders instead of ?. Such
+	// databases also won't store a bool in the integer columns flags
+	// live in, so bools are sent as 0 or 1, which is what SQLite does.
+	numbered bool
+
+	// migrationLock, if set, runs first in every migration's transaction
+	// so replicas starting together apply each migration once.
+	migrationLock string
+
+	system attribute.KeyValue // for traces
+}
+
+var (
+	sqlite = &dialect{
+		name:   "sqlite",
+		system: semconv.DBSystemNameSQLite,
+	}
+	postgres = &dialect{
+		name:          "postgres",
+		numbered:      true,
+		migrationLock: "SELECT pg_advisory_xact_lock(2099)",
+		system:        semconv.DBSystemNamePostgreSQL,
+	}
+)
+
+// rebind rewrites a query written with ? placeholders, and its args, for
+// d. A ? inside a quoted string is left alone.
+func (d *dialect) rebind(query string, args []any) (string, []any) {
+	if !d.numbered {
+		return query, args
+	}
+	if strings.IndexByte(query, '?') >= 0 {
+		var b strings.Builder
+		b.Grow(len(query) + 8)
+		n, quoted := 0, false
+		for i := 0; i < len(query); i++ {
+			switch c := query[i]; {
+			case c == '\'':
+				quoted = !quoted
+				b.WriteByte(c)
+			case c == '?' && !quoted:
+				n++
+				b.WriteByte('$')
+				b.WriteString(strconv.Itoa(n))
+			default:
+				b.WriteByte(c)
+			}
+		}
+		query = b.String()
+	}
+	copied := false
+	for i, a := range args {
+		v, ok := a.(bool)
+		if !ok {
+			continue
+		}
+		if !copied {
+			// The caller may reuse its slice.
+			args, copied = append([]any(nil), args...), true
+		}
+		if args[i] = int64(0); v {
+			args[i] = int64(1)
+		}
+	}
+	return query, args
+}
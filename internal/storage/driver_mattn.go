@@ -0,0 +1,28 @@
+//go:build mattn
+
+package storage
+
+import (
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type mattn struct{}
+
+func (mattn) Name() string { return "sqlite3" }
+
+func (mattn) DSN(path string) string {
+	q := url.Values{}
+	q.Set("_journal_mode", "WAL")
+	q.Set("_busy_timeout", "5000")
+	q.Set("_foreign_keys", "1")
+	q.Set("_synchronous", "NORMAL")
+	return "file:" + path + "?" + q.Encode()
+}
+
+func (mattn) dialect() *dialect { return sqlite }
+
+func init() {
+	registerDriver("mattn", mattn{})
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+type modernc struct{}
+
+func (modernc) Name() string { return "sqlite" }
+
+func (modernc) DSN(path string) string {
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	return "file:" + path + "?" + q.Encode()
+}
+
+func (modernc) dialect() *dialect { return sqlite }
+
+func init() {
+	registerDriver("modernc", modernc{})
+}
@@ -0,0 +1,17 @@
+package storage
+
+import (
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type pgx struct{}
+
+func (pgx) Name() string { return "pgx" }
+
+func (pgx) DSN(db string) string { return db }
+
+func (pgx) dialect() *dialect { return postgres }
+
+func init() {
+	registerDriver("pgx", pgx{})
+}
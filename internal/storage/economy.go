@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of coin transaction.
+const (
+	TransactionRun      = "run"      // coins earned by a verified score
+	TransactionPurchase = "purchase" // coins spent on an item
+	TransactionGrant    = "grant"    // coins or an item given by a moderator
+	TransactionRevoke   = "revoke"   // coins or an item taken away by one
+)
+
+var (
+	ErrInsufficientCoins = errors.New("storage: not enough coins")
+	ErrOwned             = errors.New("storage: item already unlocked")
+)
+
+// ShopItem is a cosmetic unlock. Kind is a label for the game to group
+// items by, such as "skin".
+type ShopItem struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Kind      string     `json:"kind"`
+	Price     int64      `json:"price"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// CoinTransaction is an entry in a player's ledger. Amount is signed;
+// Balance is the player's coins after it.
+type CoinTransaction struct {
+	ID        int64     `json:"id"`
+	PlayerID  int64     `json:"-"`
+	Kind      string    `json:"kind"`
+	Amount    int64     `json:"amount"`
+	Balance   int64     `json:"balance"`
+	Item      string    `json:"item,omitempty"`
+	ScoreID   int64     `json:"score_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Unlock is an item a player has.
+type Unlock struct {
+	Item          string    `json:"item"`
+	TransactionID int64     `json:"transaction_id"`
+	UnlockedAt    time.Time `json:"unlocked_at"`
+}
+
+// Wallet is a player's coins and unlocks.
+type Wallet struct {
+	Coins   int64    `json:"coins"`
+	Unlocks []Unlock `json:"unlocks"`
+}
+
+const (
+	shopItemColumns    = "id, name, kind, price, created_at, retired_at"
+	transactionColumns = "id, player_id, kind, amount, balance, item, score_id, reason, created_by, created_at"
+)
+
+// ShopItems returns the items on sale, or with retired every item, by
+// price then name.
+func (db *DB) ShopItems(ctx context.Context, retired bool) ([]ShopItem, error) {
+	query := "SELECT " + shopItemColumns + " FROM shop_items"
+	if !retired {
+		query += " WHERE retired_at IS NULL"
+	}
+	items := []ShopItem{}
+	err := db.each(ctx, func(r rowScanner) error {
+		it, err := scanShopItem(r)
+		items = append(items, it)
+		return err
+	}, query+" ORDER BY price, name")
+	return items, err
+}
+
+// PutShopItem adds an item or replaces one's name, kind and price, and
+// puts a retired item back on sale.
+func (db *DB) PutShopItem(ctx context.Context, it ShopItem) (ShopItem, error) {
+	return scanShopItem(db.sql.QueryRowContext(ctx, `
+		INSERT INTO shop_items (id, name, kind, price, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET name = excluded.name, kind = excluded.kind, price = excluded.price, retired_at = NULL
+		RETURNING `+shopItemColumns,
+		it.ID, it.Name, it.Kind, it.Price, time.Now().UnixMilli()))
+}
+
+// RetireShopItem takes an item off sale.
+func (db *DB) RetireShopItem(ctx context.Context, id string) error {
+	return execOne(ctx, db, "UPDATE shop_items SET retired_at = ? WHERE id = ? AND retired_at IS NULL", time.Now().UnixMilli(), id)
+}
+
+// Wallet returns a player's coins and unlocks, oldest unlock first.
+func (db *DB) Wallet(ctx context.Context, playerID int64) (Wallet, error) {
+	w := Wallet{Unlocks: []Unlock{}}
+	err := db.sql.QueryRowContext(ctx, "SELECT coins FROM wallets WHERE player_id = ?", playerID).Scan(&w.Coins)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Wallet{}, err
+	}
+	err = db.each(ctx, func(r rowScanner) error {
+		var u Unlock
+		var at int64
+		err := r.Scan(&u.Item, &u.TransactionID, &at)
+		u.UnlockedAt = time.UnixMilli(at).UTC()
+		w.Unlocks = append(w.Unlocks, u)
+		return err
+	}, "SELECT item, transaction_id, unlocked_at FROM unlocks WHERE player_id = ? ORDER BY unlocked_at, item", playerID)
+	return w, err
+}
+
+// CoinTransactions returns one page of a player's ledger, newest first,
+// and how many entries it has.
+func (db *DB) CoinTransactions(ctx context.Context, playerID int64, limit, offset int) ([]CoinTransaction, int, error) {
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM coin_transactions WHERE player_id = ?", playerID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	transactions := []CoinTransaction{}
+	err := db.each(ctx, func(r rowScanner) error {
+		t, err := scanCoinTransaction(r)
+		transactions = append(transactions, t)
+		return err
+	}, "SELECT "+transactionColumns+" FROM coin_transactions WHERE player_id = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		playerID, limit, offset)
+	return transactions, total, err
+}
+
+// PayRun credits a player with amount coins for a verified score, unless
+// the score has paid already. Runs pay at most dailyCap coins a player
+// each UTC day; PayRun pays what is left of that and returns the
+// transaction, or ErrNotFound if nothing was paid.
+func (db *DB) PayRun(ctx context.Context, playerID, scoreID, amount, dailyCap int64) (CoinTransaction, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return CoinTransaction{}, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	var earned int64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(CAST(SUM(amount) AS BIGINT), 0) FROM coin_transactions
+		WHERE player_id = ? AND kind = ? AND created_at >= ?`,
+		playerID, TransactionRun, now.Truncate(24*time.Hour).UnixMilli(),
+	).Scan(&earned); err != nil {
+		return CoinTransaction{}, err
+	}
+	amount = min(amount, dailyCap-earned)
+	if amount <= 0 {
+		return CoinTransaction{}, ErrNotFound
+	}
+	t := CoinTransaction{PlayerID: playerID, Kind: TransactionRun, Amount: amount, ScoreID: scoreID, CreatedAt: now}
+	if t.Balance, err = addCoins(ctx, tx, playerID, amount); err != nil {
+		return CoinTransaction{}, err
+	}
+	if err := recordTransaction(ctx, tx, &t); errors.Is(err, sql.ErrNoRows) {
+		return CoinTransaction{}, ErrNotFound // paid already
+	} else if err != nil {
+		return CoinTransaction{}, err
+	}
+	return t, tx.Commit()
+}
+
+// BuyItem spends a player's coins on an item on sale. It returns
+// ErrNotFound for an item that isn't, ErrOwned if they have it already
+// and ErrInsufficientCoins if they can't afford it.
+func (db *DB) BuyItem(ctx context.Context, playerID int64, itemID string) (CoinTransaction, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return CoinTransaction{}, err
+	}
+	defer tx.Rollback()
+	var price int64
+	err = tx.QueryRowContext(ctx, "SELECT price FROM shop_items WHERE id = ? AND retired_at IS NULL", itemID).Scan(&price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CoinTransaction{}, ErrNotFound
+	}
+	if err != nil {
+		return CoinTransaction{}, err
+	}
+	if err := checkNotOwned(ctx, tx, playerID, itemID); err != nil {
+		return CoinTransaction{}, err
+	}
+	t := CoinTransaction{PlayerID: playerID, Kind: TransactionPurchase, Amount: -price, Item: itemID, CreatedAt: time.Now().UTC()}
+	if t.Balance, err = addCoins(ctx, tx, playerID, -price); err != nil {
+		return CoinTransaction{}, err
+	}
+	if err := recordTransaction(ctx, tx, &t); err != nil {
+		return CoinTransaction{}, err
+	}
+	if err := unlock(ctx, tx, t); err != nil {
+		return CoinTransaction{}, err
+	}
+	return t, tx.Commit()
+}
+
+// AdjustWallet records a moderator's grant or revocation: t.Kind is
+// TransactionGrant or TransactionRevoke, t.Amount the coins given or
+// taken away, as a positive number, and t.Item, if set, the item. It
+// returns ErrNotFound for a player or item that doesn't exist, or for
+// revoking an item the player doesn't have, ErrOwned for granting one
+// they have, and ErrInsufficientCoins for taking more coins than they
+// have.
+func (db *DB) AdjustWallet(ctx context.Context, t CoinTransaction) (CoinTransaction, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return CoinTransaction{}, err
+	}
+	defer tx.Rollback()
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM players WHERE id = ?)", t.PlayerID).Scan(&exists); err != nil {
+		return CoinTransaction{}, err
+	}
+	if !exists {
+		return CoinTransaction{}, ErrNotFound
+	}
+	if t.Item != "" {
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM shop_items WHERE id = ?)", t.Item).Scan(&exists); err != nil {
+			return CoinTransaction{}, err
+		}
+		if !exists {
+			return CoinTransaction{}, ErrNotFound
+		}
+	}
+	if t.Kind == TransactionRevoke {
+		t.Amount = -t.Amount
+	}
+	t.CreatedAt = time.Now().UTC()
+	if t.Balance, err = addCoins(ctx, tx, t.PlayerID, t.Amount); err != nil {
+		return CoinTransaction{}, err
+	}
+	if t.Item != "" && t.Kind == TransactionGrant {
+		if err := checkNotOwned(ctx, tx, t.PlayerID, t.Item); err != nil {
+			return CoinTransaction{}, err
+		}
+	}
+	if err := recordTransaction(ctx, tx, &t); err != nil {
+		return CoinTransaction{}, err
+	}
+	switch {
+	case t.Item == "":
+	case t.Kind == TransactionGrant:
+		if err := unlock(ctx, tx, t); err != nil {
+			return CoinTransaction{}, err
+		}
+	default:
+		res, err := tx.ExecContext(ctx, "DELETE FROM unlocks WHERE player_id = ? AND item = ?", t.PlayerID, t.Item)
+		if err != nil {
+			return CoinTransaction{}, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return CoinTransaction{}, ErrNotFound
+		}
+	}
+	return t, tx.Commit()
+}
+
+// addCoins adds amount, which may be negative, to a player's coins and
+// returns their new balance, or ErrInsufficientCoins if it would go below
+// zero.
+func addCoins(ctx context.Context, tx *tx, playerID, amount int64) (int64, error) {
+	var balance int64
+	var err error
+	if amount >= 0 {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO wallets (player_id, coins) VALUES (?, ?)
+			ON CONFLICT (player_id) DO UPDATE SET coins = wallets.coins + excluded.coins
+			RETURNING coins`, playerID, amount).Scan(&balance)
+	} else {
+		err = tx.QueryRowContext(ctx, `
+			UPDATE wallets SET coins = coins + ? WHERE player_id = ? AND coins + ? >= 0
+			RETURNING coins`, amount, playerID, amount).Scan(&balance)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInsufficientCoins
+	}
+	return balance, err
+}
+
+// recordTransaction appends t to the ledger, setting its ID. A run whose
+// score has paid already returns sql.ErrNoRows.
+func recordTransaction(ctx context.Context, tx *tx, t *CoinTransaction) error {
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO coin_transactions (player_id, kind, amount, balance, item, score_id, reason, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		t.PlayerID, t.Kind, t.Amount, t.Balance, t.Item, nullInt64(t.ScoreID), t.Reason, t.CreatedBy, t.CreatedAt.UnixMilli(),
+	).Scan(&t.ID)
+}
+
+func checkNotOwned(ctx context.Context, tx *tx, playerID int64, item string) error {
+	var owned bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM unlocks WHERE player_id = ? AND item = ?)", playerID, item).Scan(&owned); err != nil {
+		return err
+	}
+	if owned {
+		return ErrOwned
+	}
+	return nil
+}
+
+func unlock(ctx context.Context, tx *tx, t CoinTransaction) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO unlocks (player_id, item, transaction_id, unlocked_at) VALUES (?, ?, ?, ?)",
+		t.PlayerID, t.Item, t.ID, t.CreatedAt.UnixMilli())
+	return err
+}
+
+func scanShopItem(row rowScanner) (ShopItem, error) {
+	var it ShopItem
+	var created int64
+	var retired sql.NullInt64
+	if err := row.Scan(&it.ID, &it.Name, &it.Kind, &it.Price, &created, &retired); err != nil {
+		return ShopItem{}, err
+	}
+	it.CreatedAt = time.UnixMilli(created).UTC()
+	if retired.Valid {
+		t := time.UnixMilli(retired.Int64).UTC()
+		it.RetiredAt = &t
+	}
+	return it, nil
+}
+
+func scanCoinTransaction(row rowScanner) (CoinTransaction, error) {
+	var t CoinTransaction
+	var scoreID sql.NullInt64
+	var created int64
+	err := row.Scan(&t.ID, &t.PlayerID, &t.Kind, &t.Amount, &t.Balance, &t.Item, &scoreID, &t.Reason, &t.CreatedBy, &created)
+	t.ScoreID = scoreID.Int64
+	t.CreatedAt = time.UnixMilli(created).UTC()
+	return t, err
+}
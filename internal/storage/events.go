@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// AnalyticsEvent is a gameplay event kept for analytics. Fields its type
+// doesn't use are zero.
+type AnalyticsEvent struct {
+	Type       string
+	Session    string
+	Level      int64
+	Difficulty string
+	DurationMS int64
+	Cause      string
+	Bucket     string
+	CreatedAt  time.Time
+}
+
+// RecordEvents stores a batch of events in one transaction.
+func (db *DB) RecordEvents(ctx context.Context, events []AnalyticsEvent) error {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range events {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO events (type, session, level, difficulty, duration_ms, cause, bucket, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.Type, e.Session, e.Level, e.Difficulty, e.DurationMS, e.Cause, e.Bucket, e.CreatedAt.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// EachEvent calls fn with every event created in [since, until), oldest
+// first, stopping at the first error.
+func (db *DB) EachEvent(ctx context.Context, since, until time.Time, fn func(AnalyticsEvent) error) error {
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT type, session, level, difficulty, duration_ms, cause, bucket, created_at
+		FROM events WHERE created_at >= ? AND created_at < ? ORDER BY created_at`,
+		since.UnixMilli(), until.UnixMilli())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AnalyticsEvent
+		var created int64
+		if err := rows.Scan(&e.Type, &e.Session, &e.Level, &e.Difficulty, &e.DurationMS, &e.Cause, &e.Bucket, &created); err != nil {
+			return err
+		}
+		e.CreatedAt = time.UnixMilli(created).UTC()
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrExperimentExists is returned when creating an experiment under a name
+// already used, running or stopped.
+var ErrExperimentExists = errors.New("storage: experiment already exists")
+
+// Experiment is an A/B experiment: a set of variants each client is split
+// between by weight. StoppedAt is set once it no longer assigns anyone.
+type Experiment struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Variants    []Variant  `json:"variants"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StoppedAt   *time.Time `json:"stopped_at,omitempty"`
+}
+
+// Variant is one arm of an experiment, given Weight percent of clients.
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// VariantResult is how a variant did: how many clients were exposed to
+// it, how many exposures they had, and how many came back to the game a
+// day or more, and a week or more, after their first.
+type VariantResult struct {
+	Variant    string `json:"variant"`
+	Units      int    `json:"units"`
+	Exposures  int64  `json:"exposures"`
+	Retained1d int    `json:"retained_1d"`
+	Retained7d int    `json:"retained_7d"`
+}
+
+const experimentColumns = "id, name, description, variants, created_by, created_at, stopped_at"
+
+// CreateExperiment starts an experiment.
+func (db *DB) CreateExperiment(ctx context.Context, e Experiment) (Experiment, error) {
+	variants, err := json.Marshal(e.Variants)
+	if err != nil {
+		return Experiment{}, err
+	}
+	e.CreatedAt = time.Now().UTC()
+	err = db.sql.QueryRowContext(ctx, `
+		INSERT INTO experiments (name, description, variants, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		e.Name, e.Description, string(variants), e.CreatedBy, e.CreatedAt.UnixMilli(),
+	).Scan(&e.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Experiment{}, ErrExperimentExists
+	}
+	return e, err
+}
+
+// GetExperiment returns an experiment by name.
+func (db *DB) GetExperiment(ctx context.Context, name string) (Experiment, error) {
+	e, err := scanExperiment(db.sql.QueryRowContext(ctx, "SELECT "+experimentColumns+" FROM experiments WHERE name = ?", name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Experiment{}, ErrNotFound
+	}
+	return e, err
+}
+
+// Experiments returns every experiment, newest first, or only the running
+// ones if runningOnly is set.
+func (db *DB) Experiments(ctx context.Context, runningOnly bool) ([]Experiment, error) {
+	query := "SELECT " + experimentColumns + " FROM experiments"
+	if runningOnly {
+		query += " WHERE stopped_at IS NULL"
+	}
+	experiments := []Experiment{}
+	err := db.each(ctx, func(r rowScanner) error {
+		e, err := scanExperiment(r)
+		experiments = append(experiments, e)
+		return err
+	}, query+" ORDER BY id DESC")
+	return experiments, err
+}
+
+// StopExperiment stops a running experiment. A stopped or missing one
+// returns ErrNotFound.
+func (db *DB) StopExperiment(ctx context.Context, name string) error {
+	return execOne(ctx, db, "UPDATE experiments SET stopped_at = ? WHERE name = ? AND stopped_at IS NULL",
+		time.Now().UnixMilli(), name)
+}
+
+// DeleteExperiment removes an experiment and its exposures.
+func (db *DB) DeleteExperiment(ctx context.Context, name string) error {
+	return execOne(ctx, db, "DELETE FROM experiments WHERE name = ?", name)
+}
+
+// RecordExposure counts a client, by its hashed player token, being shown
+// its variant of an experiment.
+func (db *DB) RecordExposure(ctx context.Context, experimentID int64, unit, variant string, now time.Time) error {
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO experiment_exposures (experiment_id, unit, variant, exposures, exposed_at, last_seen_at)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT (experiment_id, unit) DO UPDATE
+		SET exposures = experiment_exposures.exposures + 1, last_seen_at = excluded.last_seen_at`,
+		experimentID, unit, variant, now.UnixMilli(), now.UnixMilli())
+	return err
+}
+
+// SeeExposedUnit notes the client coming back to the game at now, for each
+// running experiment it has been exposed to.
+func (db *DB) SeeExposedUnit(ctx context.Context, unit string, now time.Time) error {
+	_, err := db.sql.ExecContext(ctx, `
+		UPDATE experiment_exposures SET last_seen_at = ?
+		WHERE unit = ? AND experiment_id IN (SELECT id FROM experiments WHERE stopped_at IS NULL)`,
+		now.UnixMilli(), unit)
+	return err
+}
+
+// ExperimentResults returns how each variant of an experiment has done so
+// far, in variant name order. Variants nobody has been exposed to are
+// left out.
+func (db *DB) ExperimentResults(ctx context.Context, experimentID int64) ([]VariantResult, error) {
+	results := []VariantResult{}
+	err := db.each(ctx, func(r rowScanner) error {
+		var v VariantResult
+		err := r.Scan(&v.Variant, &v.Units, &v.Exposures, &v.Retained1d, &v.Retained7d)
+		results = append(results, v)
+		return err
+	}, `
+		SELECT variant, COUNT(*), CAST(SUM(exposures) AS BIGINT),
+			COUNT(CASE WHEN last_seen_at - exposed_at >= ? THEN 1 END),
+			COUNT(CASE WHEN last_seen_at - exposed_at >= ? THEN 1 END)
+		FROM experiment_exposures
+		WHERE experiment_id = ?
+		GROUP BY variant
+		ORDER BY variant`,
+		(24 * time.Hour).Milliseconds(), (7 * 24 * time.Hour).Milliseconds(), experimentID)
+	return results, err
+}
+
+func scanExperiment(row rowScanner) (Experiment, error) {
+	var e Experiment
+	var variants string
+	var created int64
+	var stopped sql.NullInt64
+	if err := row.Scan(&e.ID, &e.Name, &e.Description, &variants, &e.CreatedBy, &created, &stopped); err != nil {
+		return Experiment{}, err
+	}
+	e.CreatedAt = time.UnixMilli(created).UTC()
+	if stopped.Valid {
+		t := time.UnixMilli(stopped.Int64).UTC()
+		e.StoppedAt = &t
+	}
+	return e, json.Unmarshal([]byte(variants), &e.Variants)
+}
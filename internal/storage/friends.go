@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrFriendCodeTaken means a new friend code collided with another
+	// player's; try another.
+	ErrFriendCodeTaken = errors.New("storage: friend code taken")
+	// ErrOwnFriendCode is returned when a player enters their own code.
+	ErrOwnFriendCode = errors.New("storage: own friend code")
+	// ErrTooManyFriends means one of the players has as many friends as
+	// they may.
+	ErrTooManyFriends = errors.New("storage: too many friends")
+)
+
+// Friend is one of a player's friends.
+type Friend struct {
+	PlayerID int64     `json:"player_id"`
+	Name     string    `json:"name"`
+	Since    time.Time `json:"since"`
+}
+
+// FriendCode returns the player's friend code, which is empty if they
+// haven't been given one.
+func (db *DB) FriendCode(ctx context.Context, playerID int64) (string, error) {
+	var code sql.NullString
+	err := db.sql.QueryRowContext(ctx, "SELECT friend_code FROM players WHERE id = ?", playerID).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return code.String, err
+}
+
+// SetFriendCode gives the player code as their friend code, replacing any
+// they had if replace is set and otherwise keeping it. It returns the
+// player's code, or ErrFriendCodeTaken if another player has this one.
+func (db *DB) SetFriendCode(ctx context.Context, playerID int64, code string, replace bool) (string, error) {
+	q := "UPDATE players SET friend_code = ? WHERE id = ? AND NOT EXISTS (SELECT 1 FROM players WHERE friend_code = ?)"
+	if !replace {
+		q += " AND friend_code IS NULL"
+	}
+	res, err := db.sql.ExecContext(ctx, q, code, playerID, code)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return code, nil
+	}
+	current, err := db.FriendCode(ctx, playerID)
+	switch {
+	case err != nil:
+		return "", err
+	case current != "" && !replace:
+		return current, nil
+	default:
+		return "", ErrFriendCodeTaken
+	}
+}
+
+// AddFriend makes the player whose friend code is code and playerID
+// friends, unless either already has maxFriends. created is false if they
+// already were.
+func (db *DB) AddFriend(ctx context.Context, playerID int64, code string, maxFriends int) (f Friend, created bool, err error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return Friend{}, false, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, "SELECT id, display_name FROM players WHERE friend_code = ?", code).Scan(&f.PlayerID, &f.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Friend{}, false, ErrNotFound
+	}
+	if err != nil {
+		return Friend{}, false, err
+	}
+	if f.PlayerID == playerID {
+		return Friend{}, false, ErrOwnFriendCode
+	}
+	var since int64
+	err = tx.QueryRowContext(ctx, "SELECT created_at FROM friends WHERE player_id = ? AND friend_id = ?", playerID, f.PlayerID).Scan(&since)
+	if err == nil {
+		f.Since = time.UnixMilli(since).UTC()
+		return f, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Friend{}, false, err
+	}
+	var most int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(n), 0) FROM (
+			SELECT COUNT(*) AS n FROM friends WHERE player_id IN (?, ?) GROUP BY player_id
+		) counts`, playerID, f.PlayerID).Scan(&most); err != nil {
+		return Friend{}, false, err
+	}
+	if most >= maxFriends {
+		return Friend{}, false, ErrTooManyFriends
+	}
+	f.Since = time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO friends (player_id, friend_id, created_at) VALUES (?, ?, ?), (?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		playerID, f.PlayerID, f.Since.UnixMilli(), f.PlayerID, playerID, f.Since.UnixMilli()); err != nil {
+		return Friend{}, false, err
+	}
+	return f, true, tx.Commit()
+}
+
+// RemoveFriend ends a friendship, for both players.
+func (db *DB) RemoveFriend(ctx context.Context, playerID, friendID int64) error {
+	return execOne(ctx, db, `
+		DELETE FROM friends
+		WHERE (player_id = ? AND friend_id = ?) OR (player_id = ? AND friend_id = ?)`,
+		playerID, friendID, friendID, playerID)
+}
+
+// Friends lists a player's friends by name.
+func (db *DB) Friends(ctx context.Context, playerID int64) ([]Friend, error) {
+	friends := []Friend{}
+	err := db.each(ctx, func(r rowScanner) error {
+		var f Friend
+		var since int64
+		err := r.Scan(&f.PlayerID, &f.Name, &since)
+		f.Since = time.UnixMilli(since).UTC()
+		friends = append(friends, f)
+		return err
+	}, `
+		SELECT p.id, p.display_name, f.created_at
+		FROM friends f JOIN players p ON p.id = f.friend_id
+		WHERE f.player_id = ?
+		ORDER BY p.username`, playerID)
+	return friends, err
+}
+
+// AreFriends reports whether two players are friends.
+func (db *DB) AreFriends(ctx context.Context, playerID, friendID int64) (bool, error) {
+	var ok bool
+	err := db.sql.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM friends WHERE player_id = ? AND friend_id = ?)", playerID, friendID).Scan(&ok)
+	return ok, err
+}
+
+// FriendScores returns the latest scores of a player's friends matching
+// f, newest first.
+func (db *DB) FriendScores(ctx context.Context, playerID int64, f ScoreFilter, limit int) ([]Score, error) {
+	where, args := f.where()
+	if where == "" {
+		where = " WHERE 1 = 1"
+	}
+	scores := []Score{}
+	err := db.each(ctx, func(r rowScanner) error {
+		s, err := scanScore(r)
+		scores = append(scores, s)
+		return err
+	}, "SELECT "+scoreColumns+" FROM scores"+where+`
+		AND player_id IN (SELECT friend_id FROM friends WHERE player_id = ?)
+		ORDER BY id DESC LIMIT ?`, append(args, playerID, limit)...)
+	return scores, err
+}
+
+// LevelBest is a player's highest score and fastest time on a level, which
+// may be from different runs.
+type LevelBest struct {
+	Score int64 `json:"score"`
+	Time  int64 `json:"time"`
+}
+
+// HeadToHead compares two players' bests on a level. Either is nil if that
+// player hasn't played it.
+type HeadToHead struct {
+	Level  int        `json:"level"`
+	Player *LevelBest `json:"you"`
+	Friend *LevelBest `json:"friend"`
+}
+
+// CompareBests returns, for every level either player has a score on
+// matching f, both players' bests there, by level. Scores with a rejected
+// replay don't count.
+func (db *DB) CompareBests(ctx context.Context, playerID, friendID int64, f ScoreFilter) ([]HeadToHead, error) {
+	where, args := f.where()
+	if where == "" {
+		where = " WHERE 1 = 1"
+	}
+	levels := []HeadToHead{}
+	err := db.each(ctx, func(r rowScanner) error {
+		var h HeadToHead
+		var score, fastest, friendScore, friendFastest sql.NullInt64
+		if err := r.Scan(&h.Level, &score, &fastest, &friendScore, &friendFastest); err != nil {
+			return err
+		}
+		if score.Valid {
+			h.Player = &LevelBest{Score: score.Int64, Time: fastest.Int64}
+		}
+		if friendScore.Valid {
+			h.Friend = &LevelBest{Score: friendScore.Int64, Time: friendFastest.Int64}
+		}
+		levels = append(levels, h)
+		return nil
+	}, `
+		SELECT level,
+			MAX(CASE WHEN player_id = ? THEN score END), MIN(CASE WHEN player_id = ? THEN time_ms END),
+			MAX(CASE WHEN player_id = ? THEN score END), MIN(CASE WHEN player_id = ? THEN time_ms END)
+		FROM scores`+where+` AND status <> ? AND player_id IN (?, ?)
+		GROUP BY level
+		ORDER BY level`,
+		append(append([]any{playerID, playerID, friendID, friendID}, args...), StatusRejected, playerID, friendID)...)
+	return levels, err
+}
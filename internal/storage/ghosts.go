@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Ghost is the trace of a run's player on one level, recovered from its
+// replay. Data is encoded as package ghost's Format.
+type Ghost struct {
+	ScoreID   int64
+	Level     int
+	Format    int
+	Cleared   bool
+	Ticks     int64
+	Data      []byte
+	CreatedAt time.Time
+}
+
+// SaveGhosts stores a run's traces. Levels already stored, by a trace run
+// at the same time, are left as they are.
+func (db *DB) SaveGhosts(ctx context.Context, ghosts []Ghost) error {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+	for _, g := range ghosts {
+		cleared := 0
+		if g.Cleared {
+			cleared = 1
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO ghosts (score_id, level, format, cleared, ticks, data, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			g.ScoreID, g.Level, g.Format, cleared, g.Ticks, g.Data, now,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Ghost returns a score's trace of a level.
+func (db *DB) Ghost(ctx context.Context, scoreID int64, level int) (Ghost, error) {
+	g := Ghost{ScoreID: scoreID, Level: level}
+	var cleared int
+	var created int64
+	err := db.sql.QueryRowContext(ctx,
+		"SELECT format, cleared, ticks, data, created_at FROM ghosts WHERE score_id = ? AND level = ?", scoreID, level,
+	).Scan(&g.Format, &cleared, &g.Ticks, &g.Data, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Ghost{}, ErrNotFound
+	}
+	g.Cleared = cleared != 0
+	g.CreatedAt = time.UnixMilli(created).UTC()
+	return g, err
+}
+
+// HasGhosts reports whether a score's replay has been traced.
+func (db *DB) HasGhosts(ctx context.Context, scoreID int64) (bool, error) {
+	var ok bool
+	err := db.sql.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM ghosts WHERE score_id = ?)", scoreID).Scan(&ok)
+	return ok, err
+}
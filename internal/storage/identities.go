@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrIdentityLinked is returned when linking a provider account that
+// already belongs to a different player.
+var ErrIdentityLinked = errors.New("storage: identity linked to another player")
+
+// PlayerByIdentity returns the player a provider account is linked to.
+func (db *DB) PlayerByIdentity(ctx context.Context, provider, subject string) (Player, error) {
+	row := db.sql.QueryRowContext(ctx, `
+		SELECT p.id, p.username, p.display_name, p.created_at
+		FROM player_identities i JOIN players p ON p.id = i.player_id
+		WHERE i.provider = ? AND i.subject = ?`,
+		provider, subject)
+	p, err := scanPlayer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Player{}, ErrNotFound
+	}
+	return p, err
+}
+
+// LinkIdentity links a provider account to playerID. Linking it again to
+// the same player is a no-op.
+func (db *DB) LinkIdentity(ctx context.Context, provider, subject string, playerID int64) error {
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO player_identities (provider, subject, player_id, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		provider, subject, playerID, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var owner int64
+	err = db.sql.QueryRowContext(ctx,
+		"SELECT player_id FROM player_identities WHERE provider = ? AND subject = ?",
+		provider, subject).Scan(&owner)
+	if err != nil {
+		return err
+	}
+	if owner != playerID {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+// CreateIdentityPlayer registers a password-less account linked to a
+// provider account. It takes the first of usernames that is free and
+// returns ErrUsernameTaken if none is.
+func (db *DB) CreateIdentityPlayer(ctx context.Context, provider, subject string, usernames []string, displayName string) (Player, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return Player{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	p := Player{DisplayName: displayName, CreatedAt: now}
+	for _, name := range usernames {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO players (username, display_name, created_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING
+			RETURNING id`,
+			name, displayName, now.UnixMilli(),
+		).Scan(&p.ID)
+		if err == nil {
+			p.Username = name
+			break
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Player{}, err
+		}
+	}
+	if p.ID == 0 {
+		return Player{}, ErrUsernameTaken
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO player_identities (provider, subject, player_id, created_at)
+		VALUES (?, ?, ?, ?)`,
+		provider, subject, p.ID, now.UnixMilli(),
+	); err != nil {
+		return Player{}, err
+	}
+	return p, tx.Commit()
+}
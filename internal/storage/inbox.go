@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// InboxMessage is a message from the moderators in players' inboxes.
+// PlayerID is the player it is for, or 0 if it is for everyone. Read is
+// set for a player's own inbox; CreatedBy and Reads, how many players have
+// read it, only for moderators.
+type InboxMessage struct {
+	ID         int64      `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	RewardCode string     `json:"reward_code,omitempty"`
+	PlayerID   int64      `json:"player_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Read       *bool      `json:"read,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Reads      int        `json:"reads,omitempty"`
+}
+
+const inboxColumns = "m.id, m.title, m.body, m.reward_code, m.player_id, m.created_at, m.expires_at"
+
+// inboxVisible matches the messages in a player's inbox at a time, given
+// as the player's ID and then the time.
+const inboxVisible = "(m.player_id IS NULL OR m.player_id = ?) AND (m.expires_at IS NULL OR m.expires_at > ?)"
+
+// CreateInboxMessage posts m. A message for a player who doesn't exist
+// returns ErrNotFound.
+func (db *DB) CreateInboxMessage(ctx context.Context, m InboxMessage) (InboxMessage, error) {
+	m.CreatedAt = time.Now().UTC()
+	var expires sql.NullInt64
+	if m.ExpiresAt != nil {
+		expires = sql.NullInt64{Int64: m.ExpiresAt.UnixMilli(), Valid: true}
+	}
+	if m.PlayerID != 0 {
+		var exists bool
+		if err := db.sql.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM players WHERE id = ?)", m.PlayerID).Scan(&exists); err != nil {
+			return InboxMessage{}, err
+		}
+		if !exists {
+			return InboxMessage{}, ErrNotFound
+		}
+	}
+	err := db.sql.QueryRowContext(ctx, `
+		INSERT INTO inbox_messages (title, body, reward_code, player_id, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		m.Title, m.Body, m.RewardCode, nullInt64(m.PlayerID), m.CreatedBy, m.CreatedAt.UnixMilli(), expires,
+	).Scan(&m.ID)
+	return m, err
+}
+
+// Inbox returns one page of a player's inbox at now, newest first, only
+// unread messages if unreadOnly is set. total is the number of messages
+// on the pages and unread the number of unread ones.
+func (db *DB) Inbox(ctx context.Context, playerID int64, now time.Time, unreadOnly bool, limit, offset int) (messages []InboxMessage, total, unread int, err error) {
+	isRead := "EXISTS (SELECT 1 FROM inbox_reads r WHERE r.message_id = m.id AND r.player_id = ?)"
+	if err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) - COUNT(r.message_id)
+		FROM inbox_messages m
+		LEFT JOIN inbox_reads r ON r.message_id = m.id AND r.player_id = ?
+		WHERE `+inboxVisible, playerID, playerID, now.UnixMilli()).Scan(&total, &unread); err != nil {
+		return nil, 0, 0, err
+	}
+	where := inboxVisible
+	args := []any{playerID, playerID, now.UnixMilli()}
+	if unreadOnly {
+		where += " AND NOT " + isRead
+		args = append(args, playerID)
+		total = unread
+	}
+	messages = []InboxMessage{}
+	err = db.each(ctx, func(r rowScanner) error {
+		var read bool
+		m, err := scanInboxMessage(r, &read)
+		m.Read = &read
+		messages = append(messages, m)
+		return err
+	}, "SELECT "+inboxColumns+", "+isRead+" FROM inbox_messages m WHERE "+where+`
+		ORDER BY m.id DESC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	return messages, total, unread, err
+}
+
+// MarkInboxRead marks a message in the player's inbox as read. A message
+// not in it returns ErrNotFound.
+func (db *DB) MarkInboxRead(ctx context.Context, playerID, messageID int64, now time.Time) error {
+	var visible bool
+	if err := db.sql.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM inbox_messages m WHERE m.id = ? AND "+inboxVisible+")",
+		messageID, playerID, now.UnixMilli()).Scan(&visible); err != nil {
+		return err
+	}
+	if !visible {
+		return ErrNotFound
+	}
+	_, err := db.sql.ExecContext(ctx,
+		"INSERT INTO inbox_reads (message_id, player_id, read_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		messageID, playerID, now.UnixMilli())
+	return err
+}
+
+// MarkInboxAllRead marks every message in the player's inbox as read, and
+// reports how many weren't.
+func (db *DB) MarkInboxAllRead(ctx context.Context, playerID int64, now time.Time) (int64, error) {
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO inbox_reads (message_id, player_id, read_at)
+		SELECT m.id, ?, ? FROM inbox_messages m WHERE `+inboxVisible+`
+		ON CONFLICT DO NOTHING`,
+		playerID, now.UnixMilli(), playerID, now.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// InboxMessages returns one page of every message, expired or not, newest
+// first, with who posted it and how many have read it, for moderators.
+func (db *DB) InboxMessages(ctx context.Context, limit, offset int) ([]InboxMessage, int, error) {
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM inbox_messages").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	messages := []InboxMessage{}
+	err := db.each(ctx, func(r rowScanner) error {
+		var by string
+		var reads int
+		m, err := scanInboxMessage(r, &by, &reads)
+		m.CreatedBy, m.Reads = by, reads
+		messages = append(messages, m)
+		return err
+	}, "SELECT "+inboxColumns+`, m.created_by, (SELECT COUNT(*) FROM inbox_reads r WHERE r.message_id = m.id)
+		FROM inbox_messages m
+		ORDER BY m.id DESC
+		LIMIT ? OFFSET ?`, limit, offset)
+	return messages, total, err
+}
+
+// DeleteInboxMessage removes a message from every inbox.
+func (db *DB) DeleteInboxMessage(ctx context.Context, id int64) error {
+	return execOne(ctx, db, "DELETE FROM inbox_messages WHERE id = ?", id)
+}
+
+func scanInboxMessage(row rowScanner, extra ...any) (InboxMessage, error) {
+	var m InboxMessage
+	var created int64
+	var playerID, expires sql.NullInt64
+	err := row.Scan(append([]any{&m.ID, &m.Title, &m.Body, &m.RewardCode, &playerID, &created, &expires}, extra...)...)
+	m.PlayerID = playerID.Int64
+	m.CreatedAt = time.UnixMilli(created).UTC()
+	if expires.Valid {
+		t := time.UnixMilli(expires.Int64).UTC()
+		m.ExpiresAt = &t
+	}
+	return m, err
+}
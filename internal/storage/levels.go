@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// Level is a community level. Tiles is empty in listings.
+type Level struct {
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	PlayerID    int64     `json:"player_id,omitempty"` // account, if signed in
+	Description string    `json:"description,omitempty"`
+	Tiles       []string  `json:"tiles,omitempty"`
+	Gold        int       `json:"gold"`
+	Guards      int       `json:"guards"`
+	Plays       int64     `json:"plays"`
+	Rating      float64   `json:"rating"`                // average stars, 0 when unrated
+	Ratings     int64     `json:"ratings"`               // number of votes
+	Unpublished bool      `json:"unpublished,omitempty"` // hidden by a moderator
+	Featured    bool      `json:"featured,omitempty"`    // picked by a moderator
+	CreatedAt   time.Time `json:"created_at"`
+
+	// PlayerTokenHash identifies the client that uploaded the level, for
+	// bans.
+	PlayerTokenHash string `json:"-"`
+
+	// QuotaSubject, set on insert, is who the level counts against for
+	// upload quotas.
+	QuotaSubject string `json:"-"`
+}
+
+// LevelSort orders level listings.
+type LevelSort string
+
+const (
+	SortNewest    LevelSort = "newest"
+	SortMostPlays LevelSort = "plays"
+	SortTopRated  LevelSort = "rating"
+	SortFeatured  LevelSort = "featured"
+)
+
+var levelOrder = map[LevelSort]string{
+	SortNewest:    "created_at DESC, id DESC",
+	SortMostPlays: "plays DESC, created_at DESC, id DESC",
+	SortFeatured:  "featured_at DESC NULLS LAST, id DESC",
+	SortTopRated:  "CASE WHEN rating_count = 0 THEN 0 ELSE rating_total * 1.0 / rating_count END DESC, rating_count DESC, id DESC",
+}
+
+// ValidLevelSort reports whether s is a supported sort order.
+func ValidLevelSort(s LevelSort) bool {
+	_, ok := levelOrder[s]
+	return ok
+}
+
+// LevelQuery selects a page of levels. Search matches title or author.
+// Unpublished selects the levels moderators have hidden instead of the
+// published ones, and Featured only the featured ones.
+type LevelQuery struct {
+	Search      string
+	Sort        LevelSort
+	Limit       int
+	Offset      int
+	Unpublished bool
+	Featured    bool
+	PlayerID    int64 // only this account's levels, if set
+}
+
+const levelSummaryColumns = "id, title, author, player_id, description, gold, guards, plays, rating_total, rating_count, created_at, " +
+	"published, player_token_hash, featured_at"
+
+// CreateLevel stores l and returns it with its ID and timestamp set.
+func (db *DB) CreateLevel(ctx context.Context, l Level) (Level, error) {
+	l.CreatedAt = time.Now().UTC()
+	err := db.sql.QueryRowContext(ctx, `
+		INSERT INTO levels (title, author, player_id, description, tiles, gold, guards, created_at, player_token_hash, quota_subject)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		l.Title, l.Author, nullInt64(l.PlayerID), l.Description, strings.Join(l.Tiles, "\n"), l.Gold, l.Guards, l.CreatedAt.UnixMilli(), nullString(l.PlayerTokenHash),
+		nullString(l.QuotaSubject),
+	).Scan(&l.ID)
+	return l, err
+}
+
+// GetLevel returns the level with its tiles, published or not.
+func (db *DB) GetLevel(ctx context.Context, id int64) (Level, error) {
+	var tiles string
+	row := db.sql.QueryRowContext(ctx, "SELECT "+levelSummaryColumns+", tiles FROM levels WHERE id = ?", id)
+	l, err := scanLevel(row, &tiles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Level{}, ErrNotFound
+	}
+	if err != nil {
+		return Level{}, err
+	}
+	l.Tiles = strings.Split(tiles, "\n")
+	return l, nil
+}
+
+// ListLevels returns one page of level summaries and the total number of
+// matches.
+func (db *DB) ListLevels(ctx context.Context, q LevelQuery) ([]Level, int, error) {
+	order, ok := levelOrder[q.Sort]
+	if !ok {
+		order = levelOrder[SortNewest]
+	}
+	where, args := " WHERE published = ?", []any{!q.Unpublished}
+	if q.Search != "" {
+		where += " AND (LOWER(title) LIKE ? ESCAPE '\\' OR LOWER(author) LIKE ? ESCAPE '\\')"
+		pattern := "%" + escapeLike(strings.ToLower(q.Search)) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if q.Featured {
+		where += " AND featured_at IS NOT NULL"
+	}
+	if q.PlayerID != 0 {
+		where += " AND player_id = ?"
+		args = append(args, q.PlayerID)
+	}
+
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM levels"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.sql.QueryContext(ctx,
+		"SELECT "+levelSummaryColumns+" FROM levels"+where+" ORDER BY "+order+" LIMIT ? OFFSET ?",
+		append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	levels := []Level{}
+	for rows.Next() {
+		l, err := scanLevel(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		levels = append(levels, l)
+	}
+	return levels, total, rows.Err()
+}
+
+// RecordPlay counts one play of the level. Unpublished levels are not
+// found.
+func (db *DB) RecordPlay(ctx context.Context, id int64) error {
+	return execOne(ctx, db, "UPDATE levels SET plays = plays + 1 WHERE id = ? AND published = 1", id)
+}
+
+// SetLevelPublished hides a level from players, or restores it.
+func (db *DB) SetLevelPublished(ctx context.Context, id int64, published bool) error {
+	return execOne(ctx, db, "UPDATE levels SET published = ? WHERE id = ?", published, id)
+}
+
+// SetLevelFeatured features a published level, or stops featuring it, and
+// reports whether that changed anything. Unpublished levels are not found
+// when featuring.
+func (db *DB) SetLevelFeatured(ctx context.Context, id int64, featured bool) (bool, error) {
+	if !featured {
+		res, err := db.sql.ExecContext(ctx, "UPDATE levels SET featured_at = NULL WHERE id = ? AND featured_at IS NOT NULL", id)
+		if err != nil {
+			return false, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return n == 1, err
+		}
+		return false, levelExists(ctx, db, "id = ?", id)
+	}
+	res, err := db.sql.ExecContext(ctx,
+		"UPDATE levels SET featured_at = ? WHERE id = ? AND published = 1 AND featured_at IS NULL",
+		time.Now().UnixMilli(), id)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, err
+	}
+	return false, levelExists(ctx, db, "id = ? AND published = 1", id)
+}
+
+// levelExists returns ErrNotFound unless a level matches where.
+func levelExists(ctx context.Context, db *DB, where string, args ...any) error {
+	var one int
+	err := db.sql.QueryRowContext(ctx, "SELECT 1 FROM levels WHERE "+where, args...).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RateLevel records voter's stars for the level, replacing any earlier vote
+// by the same voter, and returns the level's new average and vote count.
+func (db *DB) RateLevel(ctx context.Context, id int64, voterHash string, stars int) (avg float64, count int64, err error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM levels WHERE id = ? AND published = 1", id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO level_ratings (level_id, voter_hash, stars, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (level_id, voter_hash) DO UPDATE SET stars = excluded.stars`,
+		id, voterHash, stars, time.Now().UnixMilli(),
+	); err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE levels SET
+			rating_total = (SELECT COALESCE(SUM(stars), 0) FROM level_ratings WHERE level_id = levels.id),
+			rating_count = (SELECT COUNT(*) FROM level_ratings WHERE level_id = levels.id)
+		WHERE id = ?
+		RETURNING rating_total, rating_count`, id,
+	).Scan(&total, &count)
+	if err != nil {
+		return 0, 0, err
+	}
+	return averageRating(total, count), count, tx.Commit()
+}
+
+func averageRating(total, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return math.Round(float64(total)/float64(count)*100) / 100
+}
+
+func scanLevel(row rowScanner, extra ...any) (Level, error) {
+	var l Level
+	var total, created int64
+	var playerID sql.NullInt64
+	var tokenHash sql.NullString
+	var published bool
+	var featured sql.NullInt64
+	dest := append([]any{&l.ID, &l.Title, &l.Author, &playerID, &l.Description, &l.Gold, &l.Guards,
+		&l.Plays, &total, &l.Ratings, &created, &published, &tokenHash, &featured}, extra...)
+	err := row.Scan(dest...)
+	l.PlayerID, l.PlayerTokenHash, l.Unpublished, l.Featured = playerID.Int64, tokenHash.String, !published, featured.Valid
+	l.Rating = averageRating(total, l.Ratings)
+	l.CreatedAt = time.UnixMilli(created).UTC()
+	return l, err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
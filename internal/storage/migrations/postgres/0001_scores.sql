@@ -0,0 +1,14 @@
+-- Leaderboard entries.
+CREATE TABLE scores (
+	id         BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	player     TEXT    NOT NULL,
+	level      INTEGER NOT NULL,
+	difficulty TEXT    NOT NULL DEFAULT '',
+	seed       TEXT    NOT NULL DEFAULT '',
+	score      BIGINT  NOT NULL,
+	time_ms    BIGINT  NOT NULL,
+	created_at BIGINT  NOT NULL
+);
+CREATE UNIQUE INDEX scores_dedupe ON scores (player, level, difficulty, seed, score, time_ms);
+CREATE INDEX scores_rank ON scores (score DESC, time_ms ASC);
+CREATE INDEX scores_level_rank ON scores (level, score DESC, time_ms ASC);
@@ -0,0 +1,10 @@
+-- Cloud saves keyed by the SHA-256 of an opaque client token. data holds
+-- the gzip-compressed JSON save; version increments on every write.
+CREATE TABLE saves (
+	token_hash TEXT   PRIMARY KEY,
+	version    BIGINT NOT NULL,
+	data       BYTEA  NOT NULL,
+	size       BIGINT NOT NULL,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL
+);
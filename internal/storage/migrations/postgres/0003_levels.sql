@@ -0,0 +1,18 @@
+-- Community levels. tiles holds the grid as newline-joined text rows using
+-- the level package legend. plays and the rating totals are denormalized
+-- so listings can sort on them cheaply.
+CREATE TABLE levels (
+	id           BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	title        TEXT    NOT NULL,
+	author       TEXT    NOT NULL,
+	description  TEXT    NOT NULL DEFAULT '',
+	tiles        TEXT    NOT NULL,
+	gold         INTEGER NOT NULL,
+	guards       INTEGER NOT NULL,
+	plays        BIGINT  NOT NULL DEFAULT 0,
+	rating_total BIGINT  NOT NULL DEFAULT 0,
+	rating_count BIGINT  NOT NULL DEFAULT 0,
+	created_at   BIGINT  NOT NULL
+);
+CREATE INDEX levels_newest ON levels (created_at DESC);
+CREATE INDEX levels_plays ON levels (plays DESC);
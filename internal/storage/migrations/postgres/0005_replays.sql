@@ -0,0 +1,13 @@
+-- Input replays for leaderboard runs. scores.replay_token_hash authorizes
+-- the one upload allowed per score; the token is returned only when the
+-- score is first created.
+ALTER TABLE scores ADD COLUMN replay_token_hash TEXT;
+
+CREATE TABLE replays (
+	score_id   BIGINT  PRIMARY KEY REFERENCES scores (id) ON DELETE CASCADE,
+	format     INTEGER NOT NULL,
+	ticks      BIGINT  NOT NULL,
+	data       BYTEA   NOT NULL,
+	size       INTEGER NOT NULL,
+	created_at BIGINT  NOT NULL
+);
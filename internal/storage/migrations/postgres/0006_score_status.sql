@@ -0,0 +1,5 @@
+-- Replay verification state. Scores start unverified; uploading a replay
+-- queues them as pending until a verifier marks them verified or rejected.
+ALTER TABLE scores ADD COLUMN status TEXT NOT NULL DEFAULT 'unverified';
+ALTER TABLE scores ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX scores_status ON scores (status);
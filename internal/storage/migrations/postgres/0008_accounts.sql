@@ -0,0 +1,24 @@
+-- Player accounts and login sessions. username is stored lowercased for
+-- uniqueness; display_name keeps the player's chosen capitalization.
+-- password_hash is NULL for accounts that only sign in through OAuth.
+CREATE TABLE players (
+	id            BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	username      TEXT   NOT NULL UNIQUE,
+	display_name  TEXT   NOT NULL,
+	password_hash TEXT,
+	created_at    BIGINT NOT NULL
+);
+
+CREATE TABLE sessions (
+	token_hash TEXT   PRIMARY KEY,
+	player_id  BIGINT NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX sessions_player ON sessions (player_id);
+
+-- Ownership. Rows created before accounts, or anonymously, stay NULL.
+ALTER TABLE scores ADD COLUMN player_id BIGINT REFERENCES players (id) ON DELETE SET NULL;
+ALTER TABLE levels ADD COLUMN player_id BIGINT REFERENCES players (id) ON DELETE SET NULL;
+ALTER TABLE saves ADD COLUMN player_id BIGINT REFERENCES players (id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX saves_player ON saves (player_id) WHERE player_id IS NOT NULL;
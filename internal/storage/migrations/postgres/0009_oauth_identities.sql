@@ -0,0 +1,10 @@
+-- Accounts at OAuth providers linked to players. subject is the provider's
+-- stable user ID, never the (changeable) login name.
+CREATE TABLE player_identities (
+	provider   TEXT   NOT NULL,
+	subject    TEXT   NOT NULL,
+	player_id  BIGINT NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (provider, subject)
+);
+CREATE INDEX player_identities_player ON player_identities (player_id);
@@ -0,0 +1,16 @@
+-- Progress towards achievements and the achievements each player has
+-- unlocked. Achievement definitions live in code (internal/achievement).
+CREATE TABLE player_stats (
+	player_id BIGINT NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	stat      TEXT   NOT NULL,
+	value     BIGINT NOT NULL,
+	PRIMARY KEY (player_id, stat)
+);
+
+CREATE TABLE player_achievements (
+	player_id   BIGINT NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	achievement TEXT   NOT NULL,
+	unlocked_at BIGINT NOT NULL,
+	PRIMARY KEY (player_id, achievement)
+);
+CREATE INDEX player_achievements_achievement ON player_achievements (achievement);
@@ -0,0 +1,14 @@
+-- Anonymous gameplay events for first-party analytics. Columns a type
+-- doesn't use are left at their defaults.
+CREATE TABLE events (
+	id          BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	type        TEXT    NOT NULL,
+	session     TEXT    NOT NULL,
+	level       INTEGER NOT NULL DEFAULT 0,
+	difficulty  TEXT    NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	cause       TEXT    NOT NULL DEFAULT '',
+	bucket      TEXT    NOT NULL DEFAULT '',
+	created_at  BIGINT  NOT NULL
+);
+CREATE INDEX events_created ON events (created_at);
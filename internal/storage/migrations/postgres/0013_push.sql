@@ -0,0 +1,25 @@
+-- Web Push subscriptions. owner is "player:<id>" for an account or
+-- "token:<hash>" for a player token; preferences apply to all of an
+-- owner's subscriptions and default to on.
+CREATE TABLE push_subscriptions (
+	endpoint   TEXT   PRIMARY KEY,
+	p256dh     TEXT   NOT NULL,
+	auth       TEXT   NOT NULL,
+	owner      TEXT   NOT NULL,
+	created_at BIGINT NOT NULL
+);
+CREATE INDEX push_subscriptions_owner ON push_subscriptions (owner);
+
+CREATE TABLE push_preferences (
+	owner      TEXT    PRIMARY KEY,
+	daily      INTEGER NOT NULL DEFAULT 1,
+	records    INTEGER NOT NULL DEFAULT 1,
+	updated_at BIGINT  NOT NULL
+);
+
+-- One row per daily challenge announced, so restarts and several
+-- instances don't announce it twice.
+CREATE TABLE push_daily (
+	date    TEXT   PRIMARY KEY,
+	sent_at BIGINT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Levels moderators feature. featured_at is when, NULL for the rest.
+ALTER TABLE levels ADD COLUMN featured_at BIGINT;
+CREATE INDEX levels_featured ON levels (featured_at DESC) WHERE featured_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- The nonce of the signed play token a score was submitted with, so each
+-- token counts once.
+ALTER TABLE scores ADD COLUMN play_nonce TEXT;
+CREATE UNIQUE INDEX scores_play_nonce ON scores (play_nonce) WHERE play_nonce IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Short codes for sharing levels as /l/{code}. A moderator can disable an
+-- abusive code; it stays taken so it never comes back, and the level gets
+-- a new one. Each level has at most one code in use.
+CREATE TABLE short_links (
+	code        TEXT   PRIMARY KEY,
+	level_id    BIGINT NOT NULL REFERENCES levels (id) ON DELETE CASCADE,
+	created_at  BIGINT NOT NULL,
+	disabled_at BIGINT,
+	disabled_by TEXT
+);
+CREATE UNIQUE INDEX short_links_level ON short_links (level_id) WHERE disabled_at IS NULL;
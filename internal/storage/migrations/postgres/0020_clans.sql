@@ -0,0 +1,43 @@
+-- Clans: teams of players, each in at most one. name_key is the name
+-- lowercased, for uniqueness. Members join with the invite code, which
+-- the owner can replace.
+CREATE TABLE clans (
+	id          BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name        TEXT    NOT NULL,
+	name_key    TEXT    NOT NULL UNIQUE,
+	tag         TEXT    NOT NULL UNIQUE,
+	description TEXT    NOT NULL DEFAULT '',
+	invite_code TEXT    NOT NULL UNIQUE,
+	created_at  BIGINT  NOT NULL
+);
+
+CREATE TABLE clan_members (
+	player_id BIGINT  PRIMARY KEY REFERENCES players (id) ON DELETE CASCADE,
+	clan_id   BIGINT  NOT NULL REFERENCES clans (id) ON DELETE CASCADE,
+	role      TEXT    NOT NULL,
+	joined_at BIGINT  NOT NULL
+);
+CREATE INDEX clan_members_clan ON clan_members (clan_id);
+
+-- The weekly clan-vs-clan rotation. Each week's pairs are drawn once, the
+-- first time it is asked for; clan_b is NULL for a bye. Scores and the
+-- winner are recorded when the week is over.
+CREATE TABLE clan_weeks (
+	week      TEXT    PRIMARY KEY,
+	starts_at BIGINT  NOT NULL,
+	ends_at   BIGINT  NOT NULL,
+	settled   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE clan_matchups (
+	week      TEXT    NOT NULL REFERENCES clan_weeks (week) ON DELETE CASCADE,
+	slot      INTEGER NOT NULL,
+	clan_a    BIGINT  REFERENCES clans (id) ON DELETE SET NULL,
+	clan_b    BIGINT  REFERENCES clans (id) ON DELETE SET NULL,
+	score_a   BIGINT,
+	score_b   BIGINT,
+	winner_id BIGINT  REFERENCES clans (id) ON DELETE SET NULL,
+	PRIMARY KEY (week, slot)
+);
+CREATE INDEX clan_matchups_a ON clan_matchups (clan_a);
+CREATE INDEX clan_matchups_b ON clan_matchups (clan_b);
@@ -0,0 +1,13 @@
+-- Friends. A player shares their friend code, given out the first time
+-- they ask for it, and whoever enters it becomes their friend. Friendship
+-- is mutual and stored both ways round.
+ALTER TABLE players ADD COLUMN friend_code TEXT;
+CREATE UNIQUE INDEX players_friend_code ON players (friend_code) WHERE friend_code IS NOT NULL;
+
+CREATE TABLE friends (
+	player_id  BIGINT  NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	friend_id  BIGINT  NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	created_at BIGINT  NOT NULL,
+	PRIMARY KEY (player_id, friend_id)
+);
+CREATE INDEX friends_friend ON friends (friend_id);
@@ -0,0 +1,11 @@
+-- Remote config: feature flags and gameplay tuning the game fetches when
+-- it starts. Every edit is a new version and the highest is live. A
+-- rollback copies an older version's values into a new one, noting which.
+CREATE TABLE config_versions (
+	version       BIGINT  PRIMARY KEY,
+	config_values TEXT    NOT NULL,
+	note          TEXT    NOT NULL DEFAULT '',
+	restored_from BIGINT,
+	created_by    TEXT    NOT NULL,
+	created_at    BIGINT  NOT NULL
+);
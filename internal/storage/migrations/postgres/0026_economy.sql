@@ -0,0 +1,46 @@
+-- Cosmetic unlocks players can buy with coins. id is what the game knows
+-- an item by. A retired item can't be bought any more, but players who
+-- have it keep it.
+CREATE TABLE shop_items (
+	id         TEXT    PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	kind       TEXT    NOT NULL,
+	price      BIGINT  NOT NULL,
+	created_at BIGINT  NOT NULL,
+	retired_at BIGINT
+);
+
+-- Every change to a player's coins and unlocks. Rows are only ever added;
+-- they go with the account. amount is signed, and balance is the
+-- player's coins after it. kind is run, purchase, grant or revoke. A run
+-- names the verified score it paid for, which can only pay once; there
+-- is no foreign key so deleting the score doesn't rewrite the ledger.
+CREATE TABLE coin_transactions (
+	id         BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	player_id  BIGINT  NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	kind       TEXT    NOT NULL,
+	amount     BIGINT  NOT NULL,
+	balance    BIGINT  NOT NULL,
+	item       TEXT    NOT NULL DEFAULT '',
+	score_id   BIGINT  UNIQUE,
+	reason     TEXT    NOT NULL DEFAULT '',
+	created_by TEXT    NOT NULL DEFAULT '',
+	created_at BIGINT  NOT NULL
+);
+CREATE INDEX coin_transactions_player ON coin_transactions (player_id, created_at);
+
+-- Each player's coins, kept in step with the ledger so that spending can
+-- check and take them in one statement.
+CREATE TABLE wallets (
+	player_id BIGINT  PRIMARY KEY REFERENCES players (id) ON DELETE CASCADE,
+	coins     BIGINT  NOT NULL
+);
+
+-- The items each player has, and the transaction that gave them.
+CREATE TABLE unlocks (
+	player_id      BIGINT  NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	item           TEXT    NOT NULL REFERENCES shop_items (id),
+	transaction_id BIGINT  NOT NULL REFERENCES coin_transactions (id) ON DELETE CASCADE,
+	unlocked_at    BIGINT  NOT NULL,
+	PRIMARY KEY (player_id, item)
+);
@@ -0,0 +1,11 @@
+-- Save codes carry a save from one device to another without an account.
+-- code_hash is the SHA-256 of the code, like saves' token_hash, and data
+-- is the gzip-compressed save. A code is deleted when it is redeemed.
+CREATE TABLE save_codes (
+	code_hash  TEXT   PRIMARY KEY,
+	data       BYTEA  NOT NULL,
+	size       BIGINT NOT NULL,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+CREATE INDEX save_codes_expires ON save_codes (expires_at);
@@ -0,0 +1,2 @@
+-- Lists a creator's levels, for the GraphQL API's Player.levels.
+CREATE INDEX levels_player ON levels (player_id, created_at DESC);
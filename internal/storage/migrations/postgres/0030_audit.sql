@@ -0,0 +1,16 @@
+-- What an audited action changed, as JSON: the target as it was before and
+-- as it was left. Either is NULL when there was nothing to record, as for
+-- a sign-in. Entries are append-only: the trigger refuses to change or
+-- delete them.
+ALTER TABLE audit_log ADD COLUMN before TEXT;
+ALTER TABLE audit_log ADD COLUMN after TEXT;
+CREATE INDEX audit_log_actor ON audit_log (actor, id);
+CREATE INDEX audit_log_action ON audit_log (action, id);
+CREATE INDEX audit_log_target ON audit_log (target_kind, target_id, id);
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
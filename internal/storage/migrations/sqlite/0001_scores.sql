@@ -0,0 +1,15 @@
+-- Leaderboard entries. IF NOT EXISTS because databases created before the
+-- migration system already have this table.
+CREATE TABLE IF NOT EXISTS scores (
+	id         INTEGER PRIMARY KEY,
+	player     TEXT    NOT NULL,
+	level      INTEGER NOT NULL,
+	difficulty TEXT    NOT NULL DEFAULT '',
+	seed       TEXT    NOT NULL DEFAULT '',
+	score      BIGINT  NOT NULL,
+	time_ms    BIGINT  NOT NULL,
+	created_at BIGINT  NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS scores_dedupe ON scores (player, level, difficulty, seed, score, time_ms);
+CREATE INDEX IF NOT EXISTS scores_rank ON scores (score DESC, time_ms ASC);
+CREATE INDEX IF NOT EXISTS scores_level_rank ON scores (level, score DESC, time_ms ASC);
@@ -0,0 +1,9 @@
+-- One star rating per level per player token; voting again replaces the
+-- earlier vote. levels.rating_total/rating_count are kept in step.
+CREATE TABLE level_ratings (
+	level_id   INTEGER NOT NULL REFERENCES levels (id) ON DELETE CASCADE,
+	voter_hash TEXT    NOT NULL,
+	stars      INTEGER NOT NULL CHECK (stars BETWEEN 1 AND 5),
+	created_at BIGINT  NOT NULL,
+	PRIMARY KEY (level_id, voter_hash)
+);
@@ -0,0 +1,12 @@
+-- Server-generated secrets that must survive restarts, such as the key
+-- daily challenge seeds are derived from.
+CREATE TABLE secrets (
+	name       TEXT   PRIMARY KEY,
+	value      BLOB   NOT NULL,
+	created_at BIGINT NOT NULL
+);
+
+-- daily is the UTC date (YYYY-MM-DD) of the daily challenge a score was
+-- played in, or NULL for regular runs.
+ALTER TABLE scores ADD COLUMN daily TEXT;
+CREATE INDEX scores_daily_rank ON scores (daily, score DESC, time_ms ASC);
@@ -0,0 +1,42 @@
+-- Moderation. Flagged scores stay in the database but drop off the
+-- leaderboards; unpublished levels disappear from listings. The player
+-- token hash of a submission lets moderators ban whoever sent it.
+ALTER TABLE scores ADD COLUMN flagged INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scores ADD COLUMN player_token_hash TEXT;
+ALTER TABLE levels ADD COLUMN published INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE levels ADD COLUMN player_token_hash TEXT;
+
+-- kind is "player" (value is a player ID) or "token" (a player token hash).
+CREATE TABLE bans (
+	kind       TEXT   NOT NULL,
+	value      TEXT   NOT NULL,
+	reason     TEXT   NOT NULL DEFAULT '',
+	created_by TEXT   NOT NULL,
+	created_at BIGINT NOT NULL,
+	PRIMARY KEY (kind, value)
+);
+
+-- Player reports of scores and levels. One open report per reporter and
+-- target; resolved_at is set when a moderator acts on or dismisses it.
+CREATE TABLE reports (
+	id            INTEGER PRIMARY KEY,
+	kind          TEXT    NOT NULL,
+	target_id     INTEGER NOT NULL,
+	reporter_hash TEXT    NOT NULL,
+	reason        TEXT    NOT NULL DEFAULT '',
+	created_at    BIGINT  NOT NULL,
+	resolved_at   BIGINT
+);
+CREATE UNIQUE INDEX reports_open ON reports (kind, target_id, reporter_hash) WHERE resolved_at IS NULL;
+CREATE INDEX reports_target ON reports (kind, target_id);
+
+CREATE TABLE audit_log (
+	id          INTEGER PRIMARY KEY,
+	actor       TEXT    NOT NULL,
+	action      TEXT    NOT NULL,
+	target_kind TEXT    NOT NULL DEFAULT '',
+	target_id   TEXT    NOT NULL DEFAULT '',
+	detail      TEXT    NOT NULL DEFAULT '',
+	created_at  BIGINT  NOT NULL
+);
+CREATE INDEX audit_log_created ON audit_log (created_at DESC);
@@ -0,0 +1,14 @@
+-- Leaderboard seasons. The current season is the one without ended_at, and
+-- each score records the season it was submitted in. The first season
+-- holds every score so far.
+CREATE TABLE seasons (
+	id         INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	started_at BIGINT  NOT NULL,
+	ended_at   BIGINT
+);
+INSERT INTO seasons (id, name, started_at)
+	VALUES (1, 'Season 1', COALESCE((SELECT MIN(created_at) FROM scores), CAST(strftime('%s', 'now') AS INTEGER) * 1000));
+
+ALTER TABLE scores ADD COLUMN season INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX scores_season_rank ON scores (season, score DESC, time_ms ASC);
@@ -0,0 +1,16 @@
+-- Ghost traces recovered from replays, one per level a run reached, kept
+-- so each replay is traced once. They are derived from the replay and can
+-- be dropped at any time.
+CREATE TABLE ghosts (
+	score_id   INTEGER NOT NULL REFERENCES scores (id) ON DELETE CASCADE,
+	level      INTEGER NOT NULL,
+	format     INTEGER NOT NULL,
+	cleared    INTEGER NOT NULL,
+	ticks      BIGINT  NOT NULL,
+	data       BLOB    NOT NULL,
+	created_at BIGINT  NOT NULL,
+	PRIMARY KEY (score_id, level)
+);
+
+-- Record holders for a seed's levels.
+CREATE INDEX scores_seed_rank ON scores (seed, difficulty, score DESC, time_ms ASC);
@@ -0,0 +1,50 @@
+-- Tournaments: a qualifying window in which entrants' best scores on the
+-- tournament's seed rank them, then a single-elimination bracket of the
+-- best qualifiers, a round at a time. stage is qualifying, finals or
+-- finished, and round the finals round being played.
+CREATE TABLE tournaments (
+	id                 INTEGER PRIMARY KEY,
+	name               TEXT    NOT NULL,
+	seed               TEXT    NOT NULL,
+	difficulty         TEXT    NOT NULL,
+	format             TEXT    NOT NULL,
+	bracket_size       INTEGER NOT NULL,
+	round_ms           BIGINT  NOT NULL,
+	starts_at          BIGINT  NOT NULL,
+	qualifying_ends_at BIGINT  NOT NULL,
+	stage              TEXT    NOT NULL DEFAULT 'qualifying',
+	round              INTEGER NOT NULL DEFAULT 0,
+	winner_id          INTEGER REFERENCES players (id) ON DELETE SET NULL,
+	created_by         TEXT    NOT NULL,
+	created_at         BIGINT  NOT NULL
+);
+CREATE INDEX tournaments_stage ON tournaments (stage);
+
+-- bracket_seed is the entrant's place in qualifying, set for those who
+-- made the bracket when qualifying ends.
+CREATE TABLE tournament_entries (
+	tournament_id INTEGER NOT NULL REFERENCES tournaments (id) ON DELETE CASCADE,
+	player_id     INTEGER NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	registered_at BIGINT  NOT NULL,
+	bracket_seed  INTEGER,
+	PRIMARY KEY (tournament_id, player_id)
+);
+CREATE INDEX tournament_entries_player ON tournament_entries (player_id);
+
+-- A finals match. player2_id is NULL for a bye. The scores are each
+-- player's best in the round, recorded with the winner when it ends.
+CREATE TABLE tournament_matches (
+	tournament_id INTEGER NOT NULL REFERENCES tournaments (id) ON DELETE CASCADE,
+	round         INTEGER NOT NULL,
+	slot          INTEGER NOT NULL,
+	player1_id    INTEGER REFERENCES players (id) ON DELETE SET NULL,
+	player2_id    INTEGER REFERENCES players (id) ON DELETE SET NULL,
+	score1        BIGINT,
+	time1         BIGINT,
+	score2        BIGINT,
+	time2         BIGINT,
+	winner_id     INTEGER REFERENCES players (id) ON DELETE SET NULL,
+	PRIMARY KEY (tournament_id, round, slot)
+);
+
+CREATE INDEX scores_player_created ON scores (player_id, created_at);
@@ -0,0 +1,22 @@
+-- Inbox messages from moderators: announcements, and compensation with a
+-- reward code to redeem. player_id is the one player a message is for, or
+-- NULL for everyone. inbox_reads records who has read what.
+CREATE TABLE inbox_messages (
+	id          INTEGER PRIMARY KEY,
+	title       TEXT    NOT NULL,
+	body        TEXT    NOT NULL,
+	reward_code TEXT    NOT NULL DEFAULT '',
+	player_id   INTEGER REFERENCES players (id) ON DELETE CASCADE,
+	created_by  TEXT    NOT NULL,
+	created_at  BIGINT  NOT NULL,
+	expires_at  BIGINT
+);
+CREATE INDEX inbox_messages_player ON inbox_messages (player_id);
+
+CREATE TABLE inbox_reads (
+	message_id INTEGER NOT NULL REFERENCES inbox_messages (id) ON DELETE CASCADE,
+	player_id  INTEGER NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	read_at    BIGINT  NOT NULL,
+	PRIMARY KEY (message_id, player_id)
+);
+CREATE INDEX inbox_reads_player ON inbox_reads (player_id);
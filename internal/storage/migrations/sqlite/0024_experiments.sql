@@ -0,0 +1,27 @@
+-- A/B experiments. A client, known by its hashed player token, lands in
+-- the same variant of an experiment every time. variants is a JSON array
+-- of {"name", "weight"} with weights that add up to 100. A stopped
+-- experiment keeps its exposures for analysis.
+CREATE TABLE experiments (
+	id          INTEGER PRIMARY KEY,
+	name        TEXT    NOT NULL UNIQUE,
+	description TEXT    NOT NULL DEFAULT '',
+	variants    TEXT    NOT NULL,
+	created_by  TEXT    NOT NULL,
+	created_at  BIGINT  NOT NULL,
+	stopped_at  BIGINT
+);
+
+-- A client's exposure to an experiment: when it was first shown its
+-- variant, how many times since, and when it last fetched the config,
+-- for telling which variant brings players back.
+CREATE TABLE experiment_exposures (
+	experiment_id INTEGER NOT NULL REFERENCES experiments (id) ON DELETE CASCADE,
+	unit          TEXT    NOT NULL,
+	variant       TEXT    NOT NULL,
+	exposures     INTEGER NOT NULL,
+	exposed_at    BIGINT  NOT NULL,
+	last_seen_at  BIGINT  NOT NULL,
+	PRIMARY KEY (experiment_id, unit)
+);
+CREATE INDEX experiment_exposures_unit ON experiment_exposures (unit);
@@ -0,0 +1,24 @@
+-- Lobby chat, kept for a while for players who join a room late and for
+-- moderators. room is the lobby room's code. Codes are reused once a room
+-- closes, so a room's history is read from when it opened.
+CREATE TABLE chat_messages (
+	id         INTEGER PRIMARY KEY,
+	room       TEXT    NOT NULL,
+	player_id  INTEGER NOT NULL REFERENCES players (id) ON DELETE CASCADE,
+	name       TEXT    NOT NULL,
+	text       TEXT    NOT NULL,
+	created_at BIGINT  NOT NULL
+);
+CREATE INDEX chat_messages_room ON chat_messages (room, created_at);
+CREATE INDEX chat_messages_player ON chat_messages (player_id, created_at);
+CREATE INDEX chat_messages_created ON chat_messages (created_at);
+
+-- Players a moderator has stopped from chatting, until expires_at, or for
+-- good if it is NULL.
+CREATE TABLE chat_mutes (
+	player_id  INTEGER PRIMARY KEY REFERENCES players (id) ON DELETE CASCADE,
+	reason     TEXT    NOT NULL DEFAULT '',
+	created_by TEXT    NOT NULL,
+	created_at BIGINT  NOT NULL,
+	expires_at BIGINT
+);
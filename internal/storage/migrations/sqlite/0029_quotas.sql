@@ -0,0 +1,9 @@
+-- Who each upload counts against for quotas: "player:<id>" for a signed-in
+-- player, otherwise "ip:" and the SHA-256 of the client's address. Uploads
+-- from before quotas have none and count against nobody.
+ALTER TABLE levels ADD COLUMN quota_subject TEXT;
+ALTER TABLE replays ADD COLUMN quota_subject TEXT;
+ALTER TABLE saves ADD COLUMN quota_subject TEXT;
+CREATE INDEX levels_quota ON levels (quota_subject, created_at);
+CREATE INDEX replays_quota ON replays (quota_subject, created_at);
+CREATE INDEX saves_quota ON saves (quota_subject, created_at);
@@ -0,0 +1,17 @@
+-- What an audited action changed, as JSON: the target as it was before and
+-- as it was left. Either is NULL when there was nothing to record, as for
+-- a sign-in. Entries are append-only: the triggers refuse to change or
+-- delete them.
+ALTER TABLE audit_log ADD COLUMN before TEXT;
+ALTER TABLE audit_log ADD COLUMN after TEXT;
+CREATE INDEX audit_log_actor ON audit_log (actor, id);
+CREATE INDEX audit_log_action ON audit_log (action, id);
+CREATE INDEX audit_log_target ON audit_log (target_kind, target_id, id);
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
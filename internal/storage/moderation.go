@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ban kinds.
+const (
+	BanPlayer = "player" // value is a player ID
+	BanToken  = "token"  // value is a player token hash
+)
+
+// Ban blocks a player or client from writing to the API.
+type Ban struct {
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateBan stores b, replacing any existing ban on the same target.
+func (db *DB) CreateBan(ctx context.Context, b Ban) (Ban, error) {
+	b.CreatedAt = time.Now().UTC()
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO bans (kind, value, reason, created_by, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (kind, value) DO UPDATE SET
+			reason = excluded.reason, created_by = excluded.created_by, created_at = excluded.created_at`,
+		b.Kind, b.Value, b.Reason, b.CreatedBy, b.CreatedAt.UnixMilli())
+	return b, err
+}
+
+// DeleteBan lifts a ban.
+func (db *DB) DeleteBan(ctx context.Context, kind, value string) error {
+	return execOne(ctx, db, "DELETE FROM bans WHERE kind = ? AND value = ?", kind, value)
+}
+
+// Bans lists every ban, newest first.
+func (db *DB) Bans(ctx context.Context) ([]Ban, error) {
+	rows, err := db.sql.QueryContext(ctx,
+		"SELECT kind, value, reason, created_by, created_at FROM bans ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := []Ban{}
+	for rows.Next() {
+		var b Ban
+		var created int64
+		if err := rows.Scan(&b.Kind, &b.Value, &b.Reason, &b.CreatedBy, &created); err != nil {
+			return nil, err
+		}
+		b.CreatedAt = time.UnixMilli(created).UTC()
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// Banned reports whether playerID or tokenHash is banned. Zero values are
+// ignored.
+func (db *DB) Banned(ctx context.Context, playerID int64, tokenHash string) (bool, error) {
+	if playerID == 0 && tokenHash == "" {
+		return false, nil
+	}
+	var n int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM bans
+		WHERE (kind = ? AND value = ?) OR (kind = ? AND value = ?)`,
+		BanPlayer, strconv.FormatInt(playerID, 10), BanToken, tokenHash,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// Report kinds.
+const (
+	ReportScore = "score"
+	ReportLevel = "level"
+)
+
+// CreateReport records a player's report of a score or level. Reporting
+// the same target again while the first report is open is a no-op.
+func (db *DB) CreateReport(ctx context.Context, kind string, targetID int64, reporterHash, reason string) error {
+	table := map[string]string{ReportScore: "scores", ReportLevel: "levels"}[kind]
+	if table == "" {
+		return errors.New("storage: unknown report kind " + kind)
+	}
+	var exists int
+	err := db.sql.QueryRowContext(ctx, "SELECT 1 FROM "+table+" WHERE id = ?", targetID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.sql.ExecContext(ctx, `
+		INSERT INTO reports (kind, target_id, reporter_hash, reason, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		kind, targetID, reporterHash, reason, time.Now().UnixMilli())
+	return err
+}
+
+// ReportedItem is an entry in the moderation queue: every open report
+// about one score or level.
+type ReportedItem struct {
+	Kind          string    `json:"kind"`
+	TargetID      int64     `json:"id"`
+	Reports       int64     `json:"reports"`
+	Reasons       []string  `json:"reasons"`
+	FirstReported time.Time `json:"first_reported"`
+	LastReported  time.Time `json:"last_reported"`
+}
+
+// ReportQueue returns a page of reported items with open reports, most
+// reported first, and the total number of such items.
+func (db *DB) ReportQueue(ctx context.Context, limit, offset int) ([]ReportedItem, int, error) {
+	var total int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT 1 FROM reports WHERE resolved_at IS NULL GROUP BY kind, target_id
+		) open`).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT kind, target_id, COUNT(*), MIN(created_at), MAX(created_at)
+		FROM reports WHERE resolved_at IS NULL
+		GROUP BY kind, target_id
+		ORDER BY COUNT(*) DESC, MIN(created_at) ASC
+		LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	items := []ReportedItem{}
+	for rows.Next() {
+		var it ReportedItem
+		var first, last int64
+		if err := rows.Scan(&it.Kind, &it.TargetID, &it.Reports, &first, &last); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		it.FirstReported, it.LastReported = time.UnixMilli(first).UTC(), time.UnixMilli(last).UTC()
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		if items[i].Reasons, err = db.reportReasons(ctx, items[i].Kind, items[i].TargetID); err != nil {
+			return nil, 0, err
+		}
+	}
+	return items, total, nil
+}
+
+// reportReasons returns the non-empty reasons of a target's open reports,
+// newest first.
+func (db *DB) reportReasons(ctx context.Context, kind string, targetID int64) ([]string, error) {
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT reason FROM reports
+		WHERE kind = ? AND target_id = ? AND resolved_at IS NULL AND reason <> ''
+		ORDER BY created_at DESC LIMIT 20`, kind, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reasons := []string{}
+	for rows.Next() {
+		var r string
+		if err := rows.Scan(&r); err != nil {
+			return nil, err
+		}
+		reasons = append(reasons, r)
+	}
+	return reasons, rows.Err()
+}
+
+// ResolveReports closes every open report about a target and reports how
+// many there were.
+func (db *DB) ResolveReports(ctx context.Context, kind string, targetID int64) (int64, error) {
+	res, err := db.sql.ExecContext(ctx,
+		"UPDATE reports SET resolved_at = ? WHERE kind = ? AND target_id = ? AND resolved_at IS NULL",
+		time.Now().UnixMilli(), kind, targetID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AuditEntry records a privileged action or a security-relevant account
+// event. Before and After hold the target as JSON as it was and as the
+// action left it, where there is one to record.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetKind string          `json:"target_kind,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Detail     string          `json:"detail,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// RecordAudit appends e to the audit log. Entries can't be changed or
+// deleted afterwards.
+func (db *DB) RecordAudit(ctx context.Context, e AuditEntry) error {
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO audit_log (actor, action, target_kind, target_id, detail, before, after, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Actor, e.Action, e.TargetKind, e.TargetID, e.Detail, nullJSON(e.Before), nullJSON(e.After), time.Now().UnixMilli())
+	return err
+}
+
+// nullJSON stores an empty payload as NULL.
+func nullJSON(b json.RawMessage) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// AuditQuery filters the audit log. Empty fields match every entry;
+// Action ending in a dot matches every action starting with it, so
+// "account." matches both account.login and account.logout.
+type AuditQuery struct {
+	Actor      string
+	Action     string
+	TargetKind string
+	TargetID   string
+	Since      time.Time // inclusive; zero for no bound
+	Until      time.Time // exclusive; zero for no bound
+	Limit      int
+	Offset     int
+}
+
+// AuditLog returns a page of the entries matching q, newest first, and
+// how many match in all.
+func (db *DB) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, int, error) {
+	var where []string
+	var args []any
+	if q.Actor != "" {
+		where, args = append(where, "actor = ?"), append(args, q.Actor)
+	}
+	if prefix, ok := strings.CutSuffix(q.Action, "."); ok {
+		// Actions are lower-case words and dots, so there's nothing to
+		// escape in the pattern.
+		where, args = append(where, "action LIKE ?"), append(args, prefix+".%")
+	} else if q.Action != "" {
+		where, args = append(where, "action = ?"), append(args, q.Action)
+	}
+	if q.TargetKind != "" {
+		where, args = append(where, "target_kind = ?"), append(args, q.TargetKind)
+	}
+	if q.TargetID != "" {
+		where, args = append(where, "target_id = ?"), append(args, q.TargetID)
+	}
+	if !q.Since.IsZero() {
+		where, args = append(where, "created_at >= ?"), append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where, args = append(where, "created_at < ?"), append(args, q.Until.UnixMilli())
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT id, actor, action, target_kind, target_id, detail, before, after, created_at
+		FROM audit_log`+cond+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after sql.NullString
+		var created int64
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.TargetKind, &e.TargetID, &e.Detail, &before, &after, &created); err != nil {
+			return nil, 0, err
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		e.CreatedAt = time.UnixMilli(created).UTC()
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// ModerationCounts summarizes the database for the admin dashboard.
+type ModerationCounts struct {
+	Players        int64 `json:"players"`
+	Levels         int64 `json:"levels"`
+	Scores         int64 `json:"scores"`
+	ScoresLastDay  int64 `json:"scores_last_day"`
+	PendingReplays int64 `json:"pending_replays"`
+	FlaggedScores  int64 `json:"flagged_scores"`
+	OpenReports    int64 `json:"open_reports"`
+}
+
+// ModerationCounts returns current totals.
+func (db *DB) ModerationCounts(ctx context.Context) (ModerationCounts, error) {
+	var c ModerationCounts
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM players),
+			(SELECT COUNT(*) FROM levels),
+			(SELECT COUNT(*) FROM scores),
+			(SELECT COUNT(*) FROM scores WHERE created_at > ?),
+			(SELECT COUNT(*) FROM scores WHERE status = ?),
+			(SELECT COUNT(*) FROM scores WHERE flagged = 1),
+			(SELECT COUNT(*) FROM reports WHERE resolved_at IS NULL)`,
+		time.Now().Add(-24*time.Hour).UnixMilli(), StatusPending,
+	).Scan(&c.Players, &c.Levels, &c.Scores, &c.ScoresLastDay, &c.PendingReplays, &c.FlaggedScores, &c.OpenReports)
+	return c, err
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeletedPlayer replaces the names on an erased player's scores and
+// levels.
+const DeletedPlayer = "[deleted]"
+
+// PlayerExport is everything stored about a player, for GET /api/me/export.
+type PlayerExport struct {
+	Player       Player               `json:"player"`
+	Identities   []IdentityExport     `json:"identities"`
+	Sessions     []SessionExport      `json:"sessions"`
+	Scores       []Score              `json:"scores"`
+	Levels       []Level              `json:"levels"`
+	Stats        map[string]int64     `json:"stats"`
+	Achievements map[string]time.Time `json:"achievements"`
+	Friends      []Friend             `json:"friends"`
+	Chat         []ChatMessage        `json:"chat"`
+	Wallet       WalletExport         `json:"wallet"`
+	Push         PushExport           `json:"push"`
+
+	// Save is the player's cloud save, if they have one. It is stored
+	// compressed.
+	Save *Save `json:"-"`
+}
+
+// IdentityExport is a linked sign-in provider account.
+type IdentityExport struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SessionExport is a signed-in session, without its token.
+type SessionExport struct {
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WalletExport is the player's coins, unlocks and whole ledger, oldest
+// transaction first.
+type WalletExport struct {
+	Wallet
+	Transactions []CoinTransaction `json:"transactions"`
+}
+
+// PushExport is the player's Web Push subscriptions and preferences.
+type PushExport struct {
+	Endpoints   []string        `json:"endpoints"`
+	Preferences PushPreferences `json:"preferences"`
+}
+
+// Erasure counts the scores and levels ErasePlayer tombstoned and the
+// saves it deleted.
+type Erasure struct {
+	Scores int64 `json:"scores"`
+	Levels int64 `json:"levels"`
+	Saves  int64 `json:"saves"`
+
+	// Sessions are the token hashes of the sessions it ended.
+	Sessions []string `json:"-"`
+}
+
+// ExportPlayer collects everything stored about the player with the
+// given ID.
+func (db *DB) ExportPlayer(ctx context.Context, id int64) (PlayerExport, error) {
+	e := PlayerExport{
+		Identities: []IdentityExport{},
+		Sessions:   []SessionExport{},
+		Scores:     []Score{},
+		Levels:     []Level{},
+		Chat:       []ChatMessage{},
+		Wallet:     WalletExport{Transactions: []CoinTransaction{}},
+		Push:       PushExport{Endpoints: []string{}},
+	}
+	row := db.sql.QueryRowContext(ctx, "SELECT id, username, display_name, created_at FROM players WHERE id = ?", id)
+	p, err := scanPlayer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PlayerExport{}, ErrNotFound
+	}
+	if err != nil {
+		return PlayerExport{}, err
+	}
+	e.Player = p
+
+	if err := db.each(ctx, func(r rowScanner) error {
+		var i IdentityExport
+		var created int64
+		err := r.Scan(&i.Provider, &i.Subject, &created)
+		i.CreatedAt = time.UnixMilli(created).UTC()
+		e.Identities = append(e.Identities, i)
+		return err
+	}, "SELECT provider, subject, created_at FROM player_identities WHERE player_id = ? ORDER BY created_at", id); err != nil {
+		return e, err
+	}
+	if err := db.each(ctx, func(r rowScanner) error {
+		var s SessionExport
+		var created, expires int64
+		err := r.Scan(&created, &expires)
+		s.CreatedAt, s.ExpiresAt = time.UnixMilli(created).UTC(), time.UnixMilli(expires).UTC()
+		e.Sessions = append(e.Sessions, s)
+		return err
+	}, "SELECT created_at, expires_at FROM sessions WHERE player_id = ? ORDER BY created_at", id); err != nil {
+		return e, err
+	}
+	if err := db.each(ctx, func(r rowScanner) error {
+		s, err := scanScore(r)
+		e.Scores = append(e.Scores, s)
+		return err
+	}, "SELECT "+scoreColumns+" FROM scores WHERE player_id = ? ORDER BY id", id); err != nil {
+		return e, err
+	}
+	if err := db.each(ctx, func(r rowScanner) error {
+		var tiles string
+		l, err := scanLevel(r, &tiles)
+		l.Tiles = strings.Split(tiles, "\n")
+		e.Levels = append(e.Levels, l)
+		return err
+	}, "SELECT "+levelSummaryColumns+", tiles FROM levels WHERE player_id = ? ORDER BY id", id); err != nil {
+		return e, err
+	}
+
+	if e.Stats, err = db.PlayerStats(ctx, id); err != nil {
+		return e, err
+	}
+	if e.Achievements, err = db.PlayerAchievements(ctx, id); err != nil {
+		return e, err
+	}
+	if e.Friends, err = db.Friends(ctx, id); err != nil {
+		return e, err
+	}
+	if err := db.each(ctx, func(r rowScanner) error {
+		m, err := scanChatMessage(r)
+		e.Chat = append(e.Chat, m)
+		return err
+	}, "SELECT "+chatColumns+" FROM chat_messages WHERE player_id = ? ORDER BY id", id); err != nil {
+		return e, err
+	}
+	if e.Wallet.Wallet, err = db.Wallet(ctx, id); err != nil {
+		return e, err
+	}
+	if err := db.each(ctx, func(r rowScanner) error {
+		t, err := scanCoinTransaction(r)
+		e.Wallet.Transactions = append(e.Wallet.Transactions, t)
+		return err
+	}, "SELECT "+transactionColumns+" FROM coin_transactions WHERE player_id = ? ORDER BY id", id); err != nil {
+		return e, err
+	}
+
+	owner := "player:" + strconv.FormatInt(id, 10)
+	if err := db.each(ctx, func(r rowScanner) error {
+		var endpoint string
+		err := r.Scan(&endpoint)
+		e.Push.Endpoints = append(e.Push.Endpoints, endpoint)
+		return err
+	}, "SELECT endpoint FROM push_subscriptions WHERE owner = ? ORDER BY created_at", owner); err != nil {
+		return e, err
+	}
+	if e.Push.Preferences, err = db.PushPreferences(ctx, owner); err != nil {
+		return e, err
+	}
+
+	tokenHash, err := db.PlayerSaveToken(ctx, id)
+	switch {
+	case err == nil:
+		s, err := db.GetSave(ctx, tokenHash)
+		if err != nil {
+			return e, err
+		}
+		e.Save = &s
+	case !errors.Is(err, ErrNotFound):
+		return e, err
+	}
+	return e, nil
+}
+
+// each calls scan for every row query returns.
+func (db *DB) each(ctx context.Context, scan func(rowScanner) error, query string, args ...any) error {
+	rows, err := db.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ErasePlayer deletes the player with the given ID, with their sessions,
+// linked identities, stats, achievements, cloud save and push
+// subscriptions. Their scores and levels stay, so leaderboards and other
+// players' favorite levels don't change, but under DeletedPlayer's name
+// and no longer tied to them.
+func (db *DB) ErasePlayer(ctx context.Context, id int64) (Erasure, error) {
+	var e Erasure
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer tx.Rollback()
+
+	// A run identical to one already tombstoned would collide with it,
+	// and the leaderboard can do without the copy.
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM scores WHERE player_id = ? AND EXISTS (
+			SELECT 1 FROM scores d WHERE d.player = ? AND d.level = scores.level AND d.difficulty = scores.difficulty
+			AND d.seed = scores.seed AND d.score = scores.score AND d.time_ms = scores.time_ms)`,
+		id, DeletedPlayer)
+	if err != nil {
+		return e, err
+	}
+	dropped, err := res.RowsAffected()
+	if err != nil {
+		return e, err
+	}
+	steps := []struct {
+		n     *int64
+		query string
+		args  []any
+	}{
+		{&e.Scores, "UPDATE scores SET player = ?, player_id = NULL, player_token_hash = NULL, replay_token_hash = NULL WHERE player_id = ?", []any{DeletedPlayer, id}},
+		{&e.Levels, "UPDATE levels SET author = ?, player_id = NULL, player_token_hash = NULL WHERE player_id = ?", []any{DeletedPlayer, id}},
+		{&e.Saves, "DELETE FROM saves WHERE player_id = ?", []any{id}},
+		{nil, "DELETE FROM push_subscriptions WHERE owner = ?", []any{"player:" + strconv.FormatInt(id, 10)}},
+		{nil, "DELETE FROM push_preferences WHERE owner = ?", []any{"player:" + strconv.FormatInt(id, 10)}},
+		{nil, "UPDATE levels SET quota_subject = NULL WHERE quota_subject = ?", []any{"player:" + strconv.FormatInt(id, 10)}},
+		{nil, "UPDATE replays SET quota_subject = NULL WHERE quota_subject = ?", []any{"player:" + strconv.FormatInt(id, 10)}},
+		{nil, "UPDATE saves SET quota_subject = NULL WHERE quota_subject = ?", []any{"player:" + strconv.FormatInt(id, 10)}},
+	}
+	for _, s := range steps {
+		res, err := tx.ExecContext(ctx, s.query, s.args...)
+		if err != nil {
+			return e, err
+		}
+		if s.n != nil {
+			if *s.n, err = res.RowsAffected(); err != nil {
+				return e, err
+			}
+		}
+	}
+	e.Scores += dropped
+	rows, err := tx.QueryContext(ctx, "SELECT token_hash FROM sessions WHERE player_id = ?", id)
+	if err != nil {
+		return e, err
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return e, err
+		}
+		e.Sessions = append(e.Sessions, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return e, err
+	}
+	// Hand their clan on, or disband it, before the membership cascades.
+	if err := leaveClan(ctx, tx, id); err != nil && !errors.Is(err, ErrNotFound) {
+		return e, err
+	}
+	// Sessions, identities, stats, achievements, friendships, inbox
+	// messages and reads, chat and mutes, coins, unlocks and their ledger,
+	// and clan and tournament entries cascade.
+	res, err = tx.ExecContext(ctx, "DELETE FROM players WHERE id = ?", id)
+	if err != nil {
+		return e, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return e, err
+	} else if n == 0 {
+		return e, ErrNotFound
+	}
+	return e, tx.Commit()
+}
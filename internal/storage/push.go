@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PushSubscription is a browser's Web Push subscription. Owner is
+// "player:<id>" or "token:<player token hash>".
+type PushSubscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+	Owner    string
+}
+
+// PushPreferences are an owner's notification choices.
+type PushPreferences struct {
+	Daily   bool `json:"daily"`
+	Records bool `json:"records"`
+}
+
+// SavePushSubscription stores s. A browser resubscribing keeps its
+// endpoint, which then moves to the new owner and keys.
+func (db *DB) SavePushSubscription(ctx context.Context, s PushSubscription) error {
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO push_subscriptions (endpoint, p256dh, auth, owner, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (endpoint) DO UPDATE SET p256dh = excluded.p256dh, auth = excluded.auth, owner = excluded.owner`,
+		s.Endpoint, s.P256dh, s.Auth, s.Owner, time.Now().UnixMilli())
+	return err
+}
+
+// DeletePushSubscription removes owner's subscription at endpoint.
+func (db *DB) DeletePushSubscription(ctx context.Context, endpoint, owner string) error {
+	return execOne(ctx, db, "DELETE FROM push_subscriptions WHERE endpoint = ? AND owner = ?", endpoint, owner)
+}
+
+// DropPushEndpoint removes the subscription at endpoint, whoever owns it,
+// once its push service has forgotten it.
+func (db *DB) DropPushEndpoint(ctx context.Context, endpoint string) error {
+	_, err := db.sql.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE endpoint = ?", endpoint)
+	return err
+}
+
+// PushSubscriptions returns owner's subscriptions if their preferences
+// allow the kind of notification, "daily" or "records".
+func (db *DB) PushSubscriptions(ctx context.Context, owner, kind string) ([]PushSubscription, error) {
+	return db.pushSubscriptions(ctx, "s.owner = ? AND "+pushAllowed(kind), owner)
+}
+
+// DailyPushSubscriptions returns every subscription whose owner wants the
+// daily challenge announced.
+func (db *DB) DailyPushSubscriptions(ctx context.Context) ([]PushSubscription, error) {
+	return db.pushSubscriptions(ctx, pushAllowed("daily"))
+}
+
+func pushAllowed(kind string) string {
+	column := "daily"
+	if kind == "records" {
+		column = "records"
+	}
+	return "COALESCE(p." + column + ", 1) = 1"
+}
+
+func (db *DB) pushSubscriptions(ctx context.Context, where string, args ...any) ([]PushSubscription, error) {
+	rows, err := db.sql.QueryContext(ctx, `
+		SELECT s.endpoint, s.p256dh, s.auth, s.owner
+		FROM push_subscriptions s LEFT JOIN push_preferences p ON p.owner = s.owner
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []PushSubscription
+	for rows.Next() {
+		var s PushSubscription
+		if err := rows.Scan(&s.Endpoint, &s.P256dh, &s.Auth, &s.Owner); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// PushPreferences returns owner's preferences, all on if never set.
+func (db *DB) PushPreferences(ctx context.Context, owner string) (PushPreferences, error) {
+	p := PushPreferences{Daily: true, Records: true}
+	err := db.sql.QueryRowContext(ctx, "SELECT daily, records FROM push_preferences WHERE owner = ?", owner).Scan(&p.Daily, &p.Records)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return PushPreferences{}, err
+	}
+	return p, nil
+}
+
+// SetPushPreferences replaces owner's preferences.
+func (db *DB) SetPushPreferences(ctx context.Context, owner string, p PushPreferences) error {
+	_, err := db.sql.ExecContext(ctx, `
+		INSERT INTO push_preferences (owner, daily, records, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (owner) DO UPDATE SET daily = excluded.daily, records = excluded.records, updated_at = excluded.updated_at`,
+		owner, p.Daily, p.Records, time.Now().UnixMilli())
+	return err
+}
+
+// ClaimDailyPush reports whether the caller is the first to announce
+// date's challenge, and so should send it.
+func (db *DB) ClaimDailyPush(ctx context.Context, date string) (bool, error) {
+	res, err := db.sql.ExecContext(ctx,
+		"INSERT INTO push_daily (date, sent_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		date, time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Kinds of upload that quotas count, named after their tables. Levels,
+// replays and saves each store who they count against in quota_subject.
+const (
+	QuotaLevels  = "levels"
+	QuotaReplays = "replays"
+	QuotaSaves   = "saves"
+)
+
+// QuotaUsage is what one client has uploaded.
+type QuotaUsage struct {
+	Uploads int64 // of one kind, since a given time
+	Bytes   int64 // stored, of every kind
+}
+
+// QuotaUsage returns how many uploads of kind subject has made since
+// since, and how many bytes its levels, replays and saves take up. Levels
+// count their title, description and tiles, and replays and saves their
+// data as stored, compressed.
+func (db *DB) QuotaUsage(ctx context.Context, subject, kind string, since time.Time) (QuotaUsage, error) {
+	var u QuotaUsage
+	switch kind {
+	case QuotaLevels, QuotaReplays, QuotaSaves:
+	default:
+		return u, fmt.Errorf("storage: unknown quota kind %q", kind)
+	}
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM `+kind+` WHERE quota_subject = ? AND created_at >= ?),
+			(SELECT COALESCE(SUM(LENGTH(title) + LENGTH(description) + LENGTH(tiles)), 0) FROM levels WHERE quota_subject = ?) +
+			(SELECT COALESCE(SUM(size), 0) FROM replays WHERE quota_subject = ?) +
+			(SELECT COALESCE(SUM(LENGTH(data)), 0) FROM saves WHERE quota_subject = ?)`,
+		subject, since.UnixMilli(), subject, subject, subject,
+	).Scan(&u.Uploads, &u.Bytes)
+	return u, err
+}
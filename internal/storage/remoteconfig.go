@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ConfigVersion is one version of the remote config. Values is a JSON
+// object of flags and parameters. RestoredFrom is the version a rollback
+// copied them from.
+type ConfigVersion struct {
+	Version      int64           `json:"version"`
+	Values       json.RawMessage `json:"values"`
+	Note         string          `json:"note,omitempty"`
+	RestoredFrom int64           `json:"restored_from,omitempty"`
+	CreatedBy    string          `json:"created_by"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+const configColumns = "version, config_values, note, restored_from, created_by, created_at"
+
+// CurrentConfig returns the live config, the latest version, or
+// ErrNotFound if there has never been one.
+func (db *DB) CurrentConfig(ctx context.Context) (ConfigVersion, error) {
+	c, err := scanConfigVersion(db.sql.QueryRowContext(ctx, "SELECT "+configColumns+" FROM config_versions ORDER BY version DESC LIMIT 1"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ConfigVersion{}, ErrNotFound
+	}
+	return c, err
+}
+
+// GetConfigVersion returns one version of the config.
+func (db *DB) GetConfigVersion(ctx context.Context, version int64) (ConfigVersion, error) {
+	c, err := scanConfigVersion(db.sql.QueryRowContext(ctx, "SELECT "+configColumns+" FROM config_versions WHERE version = ?", version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ConfigVersion{}, ErrNotFound
+	}
+	return c, err
+}
+
+// ConfigVersions returns one page of the config's history, newest first,
+// and how many versions there are.
+func (db *DB) ConfigVersions(ctx context.Context, limit, offset int) ([]ConfigVersion, int, error) {
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM config_versions").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	versions := []ConfigVersion{}
+	err := db.each(ctx, func(r rowScanner) error {
+		c, err := scanConfigVersion(r)
+		versions = append(versions, c)
+		return err
+	}, "SELECT "+configColumns+" FROM config_versions ORDER BY version DESC LIMIT ? OFFSET ?", limit, offset)
+	return versions, total, err
+}
+
+// SaveConfig stores c as the new live config. c.Version must be one more
+// than the current version; if another edit has taken it since, it
+// returns ErrVersionConflict.
+func (db *DB) SaveConfig(ctx context.Context, c ConfigVersion) (ConfigVersion, error) {
+	c.CreatedAt = time.Now().UTC()
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO config_versions (version, config_values, note, restored_from, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		c.Version, string(c.Values), c.Note, nullInt64(c.RestoredFrom), c.CreatedBy, c.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ConfigVersion{}, ErrVersionConflict
+	}
+	return c, nil
+}
+
+func scanConfigVersion(row rowScanner) (ConfigVersion, error) {
+	var c ConfigVersion
+	var values string
+	var restored sql.NullInt64
+	var created int64
+	err := row.Scan(&c.Version, &values, &c.Note, &restored, &c.CreatedBy, &created)
+	c.Values = json.RawMessage(values)
+	c.RestoredFrom = restored.Int64
+	c.CreatedAt = time.UnixMilli(created).UTC()
+	return c, err
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrReplayExists is returned when a score already has a replay.
+var ErrReplayExists = errors.New("storage: replay already exists")
+
+// Replay is a recorded input stream for a score. Data is the client's
+// compressed encoding, stored as uploaded.
+type Replay struct {
+	ScoreID   int64
+	Format    int
+	Ticks     int64
+	Data      []byte
+	Size      int
+	CreatedAt time.Time
+
+	// QuotaSubject, set on insert, is who the replay counts against for
+	// upload quotas.
+	QuotaSubject string
+}
+
+// CreateReplay attaches rp to its score if tokenHash matches the score's
+// replay token, and queues the score for verification. It returns
+// ErrNotFound for an unknown score or wrong token and ErrReplayExists if a
+// replay was already uploaded.
+func (db *DB) CreateReplay(ctx context.Context, tokenHash string, rp Replay) (Replay, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return Replay{}, err
+	}
+	defer tx.Rollback()
+
+	var stored sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT replay_token_hash FROM scores WHERE id = ?", rp.ScoreID).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (!stored.Valid || stored.String != tokenHash)) {
+		return Replay{}, ErrNotFound
+	}
+	if err != nil {
+		return Replay{}, err
+	}
+
+	rp.CreatedAt = time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO replays (score_id, format, ticks, data, size, created_at, quota_subject)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		rp.ScoreID, rp.Format, rp.Ticks, rp.Data, len(rp.Data), rp.CreatedAt.UnixMilli(), nullString(rp.QuotaSubject),
+	)
+	if err != nil {
+		return Replay{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Replay{}, ErrReplayExists
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE scores SET status = ? WHERE id = ?", StatusPending, rp.ScoreID); err != nil {
+		return Replay{}, err
+	}
+	rp.Size = len(rp.Data)
+	return rp, tx.Commit()
+}
+
+// GetReplay returns the replay for a score.
+func (db *DB) GetReplay(ctx context.Context, scoreID int64) (Replay, error) {
+	rp := Replay{ScoreID: scoreID}
+	var created int64
+	err := db.sql.QueryRowContext(ctx,
+		"SELECT format, ticks, data, size, created_at FROM replays WHERE score_id = ?", scoreID,
+	).Scan(&rp.Format, &rp.Ticks, &rp.Data, &rp.Size, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Replay{}, ErrNotFound
+	}
+	rp.CreatedAt = time.UnixMilli(created).UTC()
+	return rp, err
+}
+
+// nullString maps "" to SQL NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullInt64 maps 0 to SQL NULL, for optional foreign keys.
+func nullInt64(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrVersionConflict is returned when a conditional write targets a save
+// or config version that is no longer current.
+var ErrVersionConflict = errors.New("storage: version conflict")
+
+// Save is a stored cloud save. Data is gzip-compressed JSON and Size its
+// uncompressed length.
+type Save struct {
+	Version   int64
+	Data      []byte
+	Size      int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// QuotaSubject is who the save counts against for upload quotas: whoever
+	// wrote it last.
+	QuotaSubject string
+}
+
+// ErrPlayerHasSave is returned when linking a second save to an account.
+var ErrPlayerHasSave = errors.New("storage: player already has a save")
+
+// CreateSave stores the first version of a save under tokenHash, owned by
+// playerID if it is non-zero and counting against quotaSubject.
+func (db *DB) CreateSave(ctx context.Context, tokenHash string, playerID int64, quotaSubject string, data []byte, size int64) (Save, error) {
+	now := time.Now()
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO saves (token_hash, player_id, version, data, size, created_at, updated_at, quota_subject)
+		VALUES (?, ?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		tokenHash, nullInt64(playerID), data, size, now.UnixMilli(), now.UnixMilli(), nullString(quotaSubject))
+	if err != nil {
+		return Save{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Save{}, ErrPlayerHasSave
+	}
+	return Save{Version: 1, Data: data, Size: size, CreatedAt: now, UpdatedAt: now, QuotaSubject: quotaSubject}, nil
+}
+
+// GetSave returns the current save for tokenHash.
+func (db *DB) GetSave(ctx context.Context, tokenHash string) (Save, error) {
+	var s Save
+	var created, updated int64
+	var subject sql.NullString
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT version, data, size, created_at, updated_at, quota_subject
+		FROM saves WHERE token_hash = ?`, tokenHash,
+	).Scan(&s.Version, &s.Data, &s.Size, &created, &updated, &subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Save{}, ErrNotFound
+	}
+	s.CreatedAt = time.UnixMilli(created)
+	s.UpdatedAt = time.UnixMilli(updated)
+	s.QuotaSubject = subject.String
+	return s, err
+}
+
+// PutSave replaces the save for tokenHash, now counting against
+// quotaSubject, and bumps its version. If ifVersion is non-zero the write
+// only succeeds when it matches the current version; otherwise the last
+// write wins. On conflict it returns the current save along with
+// ErrVersionConflict.
+func (db *DB) PutSave(ctx context.Context, tokenHash, quotaSubject string, data []byte, size, ifVersion int64) (Save, error) {
+	now := time.Now()
+	var version int64
+	err := db.sql.QueryRowContext(ctx, `
+		UPDATE saves SET version = version + 1, data = ?, size = ?, updated_at = ?, quota_subject = ?
+		WHERE token_hash = ? AND (? = 0 OR version = ?)
+		RETURNING version`,
+		data, size, now.UnixMilli(), nullString(quotaSubject), tokenHash, ifVersion, ifVersion,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		current, err := db.GetSave(ctx, tokenHash)
+		if err != nil {
+			return Save{}, err
+		}
+		return current, ErrVersionConflict
+	}
+	if err != nil {
+		return Save{}, err
+	}
+	return db.GetSave(ctx, tokenHash)
+}
+
+// PlayerSaveToken returns the token hash of the save owned by playerID.
+func (db *DB) PlayerSaveToken(ctx context.Context, playerID int64) (string, error) {
+	var tokenHash string
+	err := db.sql.QueryRowContext(ctx, "SELECT token_hash FROM saves WHERE player_id = ?", playerID).Scan(&tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return tokenHash, err
+}
+
+// LinkSave gives an anonymous save to playerID, unless the player already
+// owns one. Saves that already have an owner are left alone.
+func (db *DB) LinkSave(ctx context.Context, tokenHash string, playerID int64) error {
+	_, err := db.sql.ExecContext(ctx, `
+		UPDATE saves SET player_id = ?
+		WHERE token_hash = ? AND player_id IS NULL
+		AND NOT EXISTS (SELECT 1 FROM saves WHERE player_id = ?)`,
+		playerID, tokenHash, playerID)
+	return err
+}
+
+// ErrSaveCodeTaken is returned when a new save code is already in use.
+var ErrSaveCodeTaken = errors.New("storage: save code taken")
+
+// CreateSaveCode stores a copy of a save under codeHash until expires. It
+// returns ErrSaveCodeTaken if the code is in use.
+func (db *DB) CreateSaveCode(ctx context.Context, codeHash string, data []byte, size int64, expires time.Time) error {
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO save_codes (code_hash, data, size, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		codeHash, data, size, time.Now().UnixMilli(), expires.UnixMilli())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSaveCodeTaken
+	}
+	return nil
+}
+
+// RedeemSaveCode returns the save stored under codeHash and deletes it, so
+// a code works once. Expired codes are not found.
+func (db *DB) RedeemSaveCode(ctx context.Context, codeHash string) (Save, error) {
+	var s Save
+	var created int64
+	err := db.sql.QueryRowContext(ctx, `
+		DELETE FROM save_codes WHERE code_hash = ? AND expires_at > ?
+		RETURNING data, size, created_at`,
+		codeHash, time.Now().UnixMilli(),
+	).Scan(&s.Data, &s.Size, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Save{}, ErrNotFound
+	}
+	s.CreatedAt = time.UnixMilli(created)
+	s.UpdatedAt = s.CreatedAt
+	return s, err
+}
+
+// DeleteExpiredSaveCodes removes save codes past their expiry and reports
+// how many there were.
+func (db *DB) DeleteExpiredSaveCodes(ctx context.Context) (int64, error) {
+	res, err := db.sql.ExecContext(ctx, "DELETE FROM save_codes WHERE expires_at <= ?", time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Score is a leaderboard entry. Time is the run duration in milliseconds.
+type Score struct {
+	ID         int64     `json:"id"`
+	Rank       int       `json:"rank,omitempty"`
+	DailyRank  int       `json:"daily_rank,omitempty"`
+	SeasonRank int       `json:"season_rank,omitempty"`
+	Player     string    `json:"player"`
+	PlayerID   int64     `json:"player_id,omitempty"` // account, if signed in
+	Level      int       `json:"level"`
+	Difficulty string    `json:"difficulty,omitempty"`
+	Seed       string    `json:"seed,omitempty"`
+	Score      int64     `json:"score"`
+	Time       int64     `json:"time"`
+	HasReplay  bool      `json:"replay"`
+	Status     string    `json:"status"`
+	Flagged    bool      `json:"flagged,omitempty"` // hidden by a moderator
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Daily is the UTC date of the daily challenge the run belongs to.
+	Daily string `json:"daily,omitempty"`
+
+	// Season is the ID of the season the score was submitted in.
+	Season int64 `json:"season"`
+
+	// ReplayTokenHash, when set on insert, authorizes a later replay
+	// upload for this score.
+	ReplayTokenHash string `json:"-"`
+
+	// PlayNonce, when set on insert, is the nonce of the play token the
+	// score was signed with. A nonce is only accepted once.
+	PlayNonce string `json:"-"`
+
+	// PlayerTokenHash identifies the client that submitted the score, for
+	// bans. StatusReason explains a rejection. Both are for moderators.
+	PlayerTokenHash string `json:"-"`
+	StatusReason    string `json:"-"`
+}
+
+// ErrPlayTokenUsed means another score was already submitted with the
+// same play token.
+var ErrPlayTokenUsed = errors.New("storage: play token already used")
+
+// Score verification states.
+const (
+	StatusUnverified = "unverified" // no replay
+	StatusPending    = "pending"    // replay queued for verification
+	StatusVerified   = "verified"
+	StatusRejected   = "rejected"
+)
+
+// ScoreFilter narrows a leaderboard query; zero values match everything.
+type ScoreFilter struct {
+	Level      int
+	MinLevel   int // only runs that reached at least this level
+	Difficulty string
+	Seed       string
+	Verified   bool   // only verified scores
+	Daily      string // only this daily challenge's bucket
+	Season     int64  // only this season's scores
+	PlayerID   int64  // only this account's scores
+
+	// IncludeFlagged also matches scores hidden by moderators; Flagged
+	// matches only those.
+	IncludeFlagged bool
+	Flagged        bool
+}
+
+func (f ScoreFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	if f.Level > 0 {
+		conds = append(conds, "level = ?")
+		args = append(args, f.Level)
+	}
+	if f.MinLevel > 0 {
+		conds = append(conds, "level >= ?")
+		args = append(args, f.MinLevel)
+	}
+	if f.Difficulty != "" {
+		conds = append(conds, "difficulty = ?")
+		args = append(args, f.Difficulty)
+	}
+	if f.Seed != "" {
+		conds = append(conds, "seed = ?")
+		args = append(args, f.Seed)
+	}
+	if f.Daily != "" {
+		conds = append(conds, "daily = ?")
+		args = append(args, f.Daily)
+	}
+	if f.Season > 0 {
+		conds = append(conds, "season = ?")
+		args = append(args, f.Season)
+	}
+	if f.PlayerID > 0 {
+		conds = append(conds, "player_id = ?")
+		args = append(args, f.PlayerID)
+	}
+	if f.Verified {
+		conds = append(conds, "status = ?")
+		args = append(args, StatusVerified)
+	}
+	switch {
+	case f.Flagged:
+		conds = append(conds, "flagged = 1")
+	case !f.IncludeFlagged:
+		conds = append(conds, "flagged = 0")
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+const scoreColumns = "id, player, level, difficulty, seed, score, time_ms, created_at, status, daily, player_id, " +
+	"flagged, status_reason, player_token_hash, season, " +
+	"EXISTS (SELECT 1 FROM replays WHERE replays.score_id = scores.id)"
+
+// InsertScore stores s in the current season. An identical resubmission
+// (same player, run and result) returns the existing row with
+// created=false, so client retries don't duplicate leaderboard entries. A
+// different run with a PlayNonce already used returns ErrPlayTokenUsed.
+func (db *DB) InsertScore(ctx context.Context, s Score) (stored Score, created bool, err error) {
+	var id int64
+	err = db.sql.QueryRowContext(ctx, `
+		INSERT INTO scores (player, level, difficulty, seed, score, time_ms, created_at, replay_token_hash, daily, player_id, player_token_hash, play_nonce, season)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT id FROM seasons WHERE ended_at IS NULL))
+		ON CONFLICT DO NOTHING
+		RETURNING id`,
+		s.Player, s.Level, s.Difficulty, s.Seed, s.Score, s.Time, time.Now().UnixMilli(), nullString(s.ReplayTokenHash), nullString(s.Daily), nullInt64(s.PlayerID), nullString(s.PlayerTokenHash), nullString(s.PlayNonce),
+	).Scan(&id)
+	switch {
+	case err == nil:
+		created = true
+	case errors.Is(err, sql.ErrNoRows):
+		err = db.sql.QueryRowContext(ctx, `
+			SELECT id FROM scores
+			WHERE player = ? AND level = ? AND difficulty = ? AND seed = ? AND score = ? AND time_ms = ?`,
+			s.Player, s.Level, s.Difficulty, s.Seed, s.Score, s.Time,
+		).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) && s.PlayNonce != "" {
+			return Score{}, false, ErrPlayTokenUsed
+		}
+		if err != nil {
+			return Score{}, false, err
+		}
+	default:
+		return Score{}, false, err
+	}
+
+	stored, err = db.Score(ctx, id)
+	return stored, created, err
+}
+
+// Score returns the entry with the given ID.
+func (db *DB) Score(ctx context.Context, id int64) (Score, error) {
+	row := db.sql.QueryRowContext(ctx, "SELECT "+scoreColumns+" FROM scores WHERE id = ?", id)
+	s, err := scanScore(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Score{}, ErrNotFound
+	}
+	return s, err
+}
+
+// ScoreRank returns the 1-based global position of s: higher score first,
+// then faster time.
+func (db *DB) ScoreRank(ctx context.Context, s Score) (int, error) {
+	var better int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM scores
+		WHERE flagged = 0 AND (score > ? OR (score = ? AND time_ms < ?))`,
+		s.Score, s.Score, s.Time,
+	).Scan(&better)
+	return better + 1, err
+}
+
+// LevelRank returns the position of s on its level's leaderboard.
+func (db *DB) LevelRank(ctx context.Context, s Score) (int, error) {
+	var better int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM scores
+		WHERE level = ? AND flagged = 0 AND (score > ? OR (score = ? AND time_ms < ?))`,
+		s.Level, s.Score, s.Score, s.Time,
+	).Scan(&better)
+	return better + 1, err
+}
+
+// DailyRank returns the position of s within its daily challenge bucket.
+func (db *DB) DailyRank(ctx context.Context, s Score) (int, error) {
+	var better int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM scores
+		WHERE daily = ? AND flagged = 0 AND (score > ? OR (score = ? AND time_ms < ?))`,
+		s.Daily, s.Score, s.Score, s.Time,
+	).Scan(&better)
+	return better + 1, err
+}
+
+// SeasonRank returns the position of s within its season.
+func (db *DB) SeasonRank(ctx context.Context, s Score) (int, error) {
+	var better int
+	err := db.sql.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM scores
+		WHERE season = ? AND flagged = 0 AND (score > ? OR (score = ? AND time_ms < ?))`,
+		s.Season, s.Score, s.Score, s.Time,
+	).Scan(&better)
+	return better + 1, err
+}
+
+// TopScores returns one page of the leaderboard, with Rank filled in, and
+// the total number of matching entries.
+func (db *DB) TopScores(ctx context.Context, f ScoreFilter, limit, offset int) ([]Score, int, error) {
+	where, args := f.where()
+
+	var total int
+	if err := db.sql.QueryRowContext(ctx, "SELECT COUNT(*) FROM scores"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+scoreColumns+" FROM scores"+where+`
+		ORDER BY score DESC, time_ms ASC, id ASC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	scores := []Score{}
+	for rows.Next() {
+		s, err := scanScore(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		s.Rank = offset + len(scores) + 1
+		scores = append(scores, s)
+	}
+	return scores, total, rows.Err()
+}
+
+// RecentScores returns the latest submissions matching f, newest first,
+// without ranks.
+func (db *DB) RecentScores(ctx context.Context, f ScoreFilter, limit int) ([]Score, error) {
+	where, args := f.where()
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+scoreColumns+" FROM scores"+where+" ORDER BY id DESC LIMIT ?",
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scores := []Score{}
+	for rows.Next() {
+		s, err := scanScore(rows)
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, s)
+	}
+	return scores, rows.Err()
+}
+
+// WorldRecords returns the scores that were the best of all, as ScoreRank
+// ranks them, when they were submitted, newest first. Scores hidden by
+// moderators or with a rejected replay neither count nor are listed.
+func (db *DB) WorldRecords(ctx context.Context, limit int) ([]Score, error) {
+	scores := []Score{}
+	err := db.each(ctx, func(r rowScanner) error {
+		s, err := scanScore(r)
+		scores = append(scores, s)
+		return err
+	}, "SELECT "+scoreColumns+` FROM scores
+		WHERE flagged = 0 AND status <> ? AND NOT EXISTS (
+			SELECT 1 FROM scores earlier
+			WHERE earlier.id < scores.id AND earlier.flagged = 0 AND earlier.status <> ?
+				AND (earlier.score > scores.score OR (earlier.score = scores.score AND earlier.time_ms <= scores.time_ms)))
+		ORDER BY id DESC LIMIT ?`, StatusRejected, StatusRejected, limit)
+	return scores, err
+}
+
+func scanScore(row rowScanner) (Score, error) {
+	var s Score
+	var created int64
+	var daily, tokenHash sql.NullString
+	var playerID sql.NullInt64
+	err := row.Scan(&s.ID, &s.Player, &s.Level, &s.Difficulty, &s.Seed, &s.Score, &s.Time, &created, &s.Status, &daily, &playerID,
+		&s.Flagged, &s.StatusReason, &tokenHash, &s.Season, &s.HasReplay)
+	s.Daily, s.PlayerID, s.PlayerTokenHash = daily.String, playerID.Int64, tokenHash.String
+	s.CreatedAt = time.UnixMilli(created).UTC()
+	return s, err
+}
+
+// SetScoreStatus records the verification outcome for a score. reason
+// explains a rejection and is kept for moderators.
+func (db *DB) SetScoreStatus(ctx context.Context, id int64, status, reason string) error {
+	_, err := db.sql.ExecContext(ctx,
+		"UPDATE scores SET status = ?, status_reason = ? WHERE id = ?", status, reason, id)
+	return err
+}
+
+// SetScoreFlagged hides a score from the leaderboards, or shows it again.
+func (db *DB) SetScoreFlagged(ctx context.Context, id int64, flagged bool) error {
+	return execOne(ctx, db, "UPDATE scores SET flagged = ? WHERE id = ?", flagged, id)
+}
+
+// DeleteScore removes a score and its replay.
+func (db *DB) DeleteScore(ctx context.Context, id int64) error {
+	return execOne(ctx, db, "DELETE FROM scores WHERE id = ?", id)
+}
+
+// PendingScores returns up to limit IDs of scores awaiting verification,
+// in ID order starting after afterID.
+func (db *DB) PendingScores(ctx context.Context, afterID int64, limit int) ([]int64, error) {
+	rows, err := db.sql.QueryContext(ctx,
+		"SELECT id FROM scores WHERE status = ? AND id > ? ORDER BY id LIMIT ?", StatusPending, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
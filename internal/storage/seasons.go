@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrSeasonEnded means the season was no longer current, because another
+// instance or a moderator started the next one first.
+var ErrSeasonEnded = errors.New("storage: season already ended")
+
+// Season is a leaderboard period. EndedAt is nil for the current season;
+// ended seasons are archives no new scores go into.
+type Season struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+const seasonColumns = "id, name, started_at, ended_at"
+
+// CurrentSeason returns the season scores are going into.
+func (db *DB) CurrentSeason(ctx context.Context) (Season, error) {
+	return scanSeason(db.sql.QueryRowContext(ctx, "SELECT "+seasonColumns+" FROM seasons WHERE ended_at IS NULL"))
+}
+
+// GetSeason returns the season with the given ID.
+func (db *DB) GetSeason(ctx context.Context, id int64) (Season, error) {
+	s, err := scanSeason(db.sql.QueryRowContext(ctx, "SELECT "+seasonColumns+" FROM seasons WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Season{}, ErrNotFound
+	}
+	return s, err
+}
+
+// Seasons lists every season, newest first.
+func (db *DB) Seasons(ctx context.Context) ([]Season, error) {
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+seasonColumns+" FROM seasons ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seasons := []Season{}
+	for rows.Next() {
+		s, err := scanSeason(rows)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	return seasons, rows.Err()
+}
+
+// StartSeason ends season from, which must be current, and starts the next
+// one. An empty name becomes "Season N".
+func (db *DB) StartSeason(ctx context.Context, from int64, name string) (Season, error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return Season{}, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, "UPDATE seasons SET ended_at = ? WHERE id = ? AND ended_at IS NULL", now.UnixMilli(), from)
+	if err != nil {
+		return Season{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Season{}, err
+	} else if n == 0 {
+		return Season{}, ErrSeasonEnded
+	}
+	s := Season{Name: name, StartedAt: now}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO seasons (name, started_at) VALUES (?, ?)
+		RETURNING id`, name, now.UnixMilli()).Scan(&s.ID)
+	if err != nil {
+		return Season{}, err
+	}
+	if s.Name == "" {
+		s.Name = "Season " + strconv.FormatInt(s.ID, 10)
+		if _, err := tx.ExecContext(ctx, "UPDATE seasons SET name = ? WHERE id = ?", s.Name, s.ID); err != nil {
+			return Season{}, err
+		}
+	}
+	return s, tx.Commit()
+}
+
+func scanSeason(row rowScanner) (Season, error) {
+	var s Season
+	var started int64
+	var ended sql.NullInt64
+	err := row.Scan(&s.ID, &s.Name, &started, &ended)
+	s.StartedAt = time.UnixMilli(started).UTC()
+	if ended.Valid {
+		t := time.UnixMilli(ended.Int64).UTC()
+		s.EndedAt = &t
+	}
+	return s, err
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+)
+
+// Secret returns the named server secret, generating and storing size
+// random bytes the first time it is asked for.
+func (db *DB) Secret(ctx context.Context, name string, size int) ([]byte, error) {
+	value := make([]byte, size)
+	rand.Read(value)
+	if _, err := db.sql.ExecContext(ctx,
+		"INSERT INTO secrets (name, value, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		name, value, time.Now().UnixMilli(),
+	); err != nil {
+		return nil, err
+	}
+	err := db.sql.QueryRowContext(ctx, "SELECT value FROM secrets WHERE name = ?", name).Scan(&value)
+	return value, err
+}
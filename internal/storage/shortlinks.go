@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrShortLinkTaken is returned when a new short link's code is already
+// in use, or was once, or its level already has a code.
+var ErrShortLinkTaken = errors.New("storage: short link taken")
+
+// ShortLink is a short code that opens a level. DisabledAt is nil until a
+// moderator disables the code.
+type ShortLink struct {
+	Code       string     `json:"code"`
+	LevelID    int64      `json:"level_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	DisabledBy string     `json:"disabled_by,omitempty"`
+}
+
+const shortLinkColumns = "code, level_id, created_at, disabled_at, disabled_by"
+
+// CreateShortLink gives the level code. It returns ErrShortLinkTaken if
+// another link has the code, or the level already has one in use.
+func (db *DB) CreateShortLink(ctx context.Context, code string, levelID int64) (ShortLink, error) {
+	s := ShortLink{Code: code, LevelID: levelID, CreatedAt: time.Now().UTC()}
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO short_links (code, level_id, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		code, levelID, s.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return ShortLink{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ShortLink{}, ErrShortLinkTaken
+	}
+	return s, nil
+}
+
+// ShortLink returns the link with the given code, disabled or not.
+func (db *DB) ShortLink(ctx context.Context, code string) (ShortLink, error) {
+	s, err := scanShortLink(db.sql.QueryRowContext(ctx, "SELECT "+shortLinkColumns+" FROM short_links WHERE code = ?", code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShortLink{}, ErrNotFound
+	}
+	return s, err
+}
+
+// LevelShortLink returns the level's link in use.
+func (db *DB) LevelShortLink(ctx context.Context, levelID int64) (ShortLink, error) {
+	s, err := scanShortLink(db.sql.QueryRowContext(ctx,
+		"SELECT "+shortLinkColumns+" FROM short_links WHERE level_id = ? AND disabled_at IS NULL", levelID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShortLink{}, ErrNotFound
+	}
+	return s, err
+}
+
+// DisableShortLink stops code from opening its level for good. It returns
+// ErrNotFound for an unknown or already disabled code.
+func (db *DB) DisableShortLink(ctx context.Context, code, by string) error {
+	return execOne(ctx, db, "UPDATE short_links SET disabled_at = ?, disabled_by = ? WHERE code = ? AND disabled_at IS NULL",
+		time.Now().UnixMilli(), by, code)
+}
+
+func scanShortLink(row rowScanner) (ShortLink, error) {
+	var s ShortLink
+	var created int64
+	var disabled sql.NullInt64
+	var by sql.NullString
+	err := row.Scan(&s.Code, &s.LevelID, &created, &disabled, &by)
+	s.CreatedAt = time.UnixMilli(created).UTC()
+	if disabled.Valid {
+		t := time.UnixMilli(disabled.Int64).UTC()
+		s.DisabledAt = &t
+	}
+	s.DisabledBy = by.String
+	return s, err
+}
@@ -0,0 +1,136 @@
+// Package storage is the server's persistence layer: a SQLite or
+// PostgreSQL database whose schema is managed by the versioned migrations
+// embedded from migrations/<dialect>/. Game features (leaderboards, saves,
+// levels) add their tables through new migration files, one per dialect
+// with the same version, and their queries as methods on DB.
+//
+// Queries are written once, with ? placeholders and integer 0/1 for
+// booleans, in the SQL both databases accept.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned when a lookup matches no row.
+var ErrNotFound = errors.New("storage: not found")
+
+// Driver adapts a database/sql driver. The pure-Go modernc SQLite driver
+// and pgx for PostgreSQL are always available; building with -tags mattn
+// adds the cgo mattn SQLite driver.
+type Driver interface {
+	// Name is the name the driver registered with database/sql.
+	Name() string
+	// DSN returns the data source name for the -db value: a SQLite file
+	// path, given WAL journaling, a busy timeout and foreign keys, or a
+	// PostgreSQL URL.
+	DSN(db string) string
+
+	dialect() *dialect
+}
+
+// DefaultDriver is the driver used when none is configured.
+const DefaultDriver = "modernc"
+
+var drivers = map[string]Driver{}
+
+func registerDriver(key string, d Driver) {
+	drivers[key] = d
+}
+
+// Drivers lists the available driver names.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsPostgresURL reports whether db names a PostgreSQL database rather than
+// a SQLite file.
+func IsPostgresURL(db string) bool {
+	return strings.HasPrefix(db, "postgres://") || strings.HasPrefix(db, "postgresql://")
+}
+
+// DB is a handle to the game database.
+type DB struct {
+	sql conn
+}
+
+// Open opens the database db, a SQLite file (created if necessary) opened
+// with the named driver or a postgres:// URL, which always uses pgx. Call
+// Migrate before using it.
+func Open(db, driver string) (*DB, error) {
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	if IsPostgresURL(db) {
+		driver = "pgx"
+	}
+	d, ok := drivers[driver]
+	if !ok || (d.dialect() == postgres) != IsPostgresURL(db) {
+		return nil, fmt.Errorf("storage: unknown driver %q (available: %s)", driver, strings.Join(sqliteDrivers(), ", "))
+	}
+	sqlDB, err := sql.Open(d.Name(), d.DSN(db))
+	if err != nil {
+		return nil, err
+	}
+	return &DB{sql: conn{DB: sqlDB, dialect: d.dialect()}}, nil
+}
+
+// sqliteDrivers lists the drivers -db-driver may name.
+func sqliteDrivers() []string {
+	var names []string
+	for _, name := range Drivers() {
+		if drivers[name].dialect() == sqlite {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Redact returns db fit for logs, without a PostgreSQL URL's password.
+func Redact(db string) string {
+	if !IsPostgresURL(db) {
+		return db
+	}
+	if u, err := url.Parse(db); err == nil {
+		return u.Redacted()
+	}
+	return "postgres"
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return db.sql.Close()
+}
+
+// Ping verifies the database is reachable.
+func (db *DB) Ping(ctx context.Context) error {
+	return db.sql.PingContext(ctx)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// execOne runs a statement that should affect exactly one row, returning
+// ErrNotFound if it affected none.
+func execOne(ctx context.Context, db *DB, query string, args ...any) error {
+	res, err := db.sql.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,621 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// ErrRegistrationClosed means a tournament's qualifying window is over, so
+// entrants can no longer join or withdraw.
+var ErrRegistrationClosed = errors.New("storage: tournament registration closed")
+
+// SingleElimination is the only tournament format: each finals match sends
+// its winner on to the next round and its loser home.
+const SingleElimination = "single_elimination"
+
+// Tournament stages. A tournament is qualifying until its qualifying
+// window ends, though entrants' scores only count from StartsAt.
+const (
+	StageQualifying = "qualifying"
+	StageFinals     = "finals"
+	StageFinished   = "finished"
+)
+
+// Tournament is a competition on a seed's levels at a difficulty. Entrants
+// qualify with their best score between StartsAt and QualifyingEndsAt;
+// the best BracketSize of them then play a bracket, a round every
+// RoundHours, each match won by the better score in the round.
+type Tournament struct {
+	ID               int64     `json:"id"`
+	Name             string    `json:"name"`
+	Seed             string    `json:"seed"`
+	Difficulty       string    `json:"difficulty"`
+	Format           string    `json:"format"`
+	BracketSize      int       `json:"bracket_size"`
+	RoundHours       int       `json:"round_hours"`
+	StartsAt         time.Time `json:"starts_at"`
+	QualifyingEndsAt time.Time `json:"qualifying_ends_at"`
+	Stage            string    `json:"stage"`
+	Round            int       `json:"round,omitempty"` // finals round being played, or the last
+	WinnerID         int64     `json:"winner_id,omitempty"`
+	Entrants         int       `json:"entrants"`
+	CreatedBy        string    `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// RoundWindow returns when finals round n (from 1) is played.
+func (t Tournament) RoundWindow(n int) (start, end time.Time) {
+	length := time.Duration(t.RoundHours) * time.Hour
+	start = t.QualifyingEndsAt.Add(time.Duration(n-1) * length)
+	return start, start.Add(length)
+}
+
+// TournamentResult is a player's best run in a qualifying window or a
+// finals round. Time is in milliseconds.
+type TournamentResult struct {
+	Score int64 `json:"score"`
+	Time  int64 `json:"time"`
+}
+
+// better reports whether r beats o: the higher score, then the faster run.
+// No result loses to any.
+func (r *TournamentResult) better(o *TournamentResult) bool {
+	switch {
+	case r == nil:
+		return false
+	case o == nil:
+		return true
+	case r.Score != o.Score:
+		return r.Score > o.Score
+	}
+	return r.Time < o.Time
+}
+
+// Entrant is a player registered for a tournament, with their standing in
+// qualifying. BracketSeed is set once qualifying ends if they made the
+// bracket.
+type Entrant struct {
+	Rank         int               `json:"rank"`
+	PlayerID     int64             `json:"player_id"`
+	Name         string            `json:"name"`
+	BracketSeed  int               `json:"bracket_seed,omitempty"`
+	Best         *TournamentResult `json:"best"`
+	RegisteredAt time.Time         `json:"registered_at"`
+}
+
+// MatchPlayer is one side of a finals match. Result is their best run in
+// the round so far, and final once the round is over.
+type MatchPlayer struct {
+	PlayerID    int64             `json:"player_id"`
+	Name        string            `json:"name"`
+	BracketSeed int               `json:"bracket_seed"`
+	Result      *TournamentResult `json:"result"`
+}
+
+// Match is a finals match. The second player is nil for a bye, which the
+// first wins outright; either may also be nil if their account was
+// deleted.
+type Match struct {
+	Round    int             `json:"round"`
+	Slot     int             `json:"slot"`
+	Players  [2]*MatchPlayer `json:"players"`
+	WinnerID int64           `json:"winner_id,omitempty"`
+}
+
+const tournamentColumns = "id, name, seed, difficulty, format, bracket_size, round_ms, starts_at, qualifying_ends_at, " +
+	"stage, round, winner_id, created_by, created_at, " +
+	"(SELECT COUNT(*) FROM tournament_entries WHERE tournament_id = tournaments.id)"
+
+// CreateTournament stores a new tournament, which starts out qualifying.
+func (db *DB) CreateTournament(ctx context.Context, t Tournament) (Tournament, error) {
+	t.Stage, t.Round, t.WinnerID, t.Entrants = StageQualifying, 0, 0, 0
+	t.CreatedAt = time.Now().UTC()
+	err := db.sql.QueryRowContext(ctx, `
+		INSERT INTO tournaments (name, seed, difficulty, format, bracket_size, round_ms, starts_at, qualifying_ends_at, stage, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		t.Name, t.Seed, t.Difficulty, t.Format, t.BracketSize, (time.Duration(t.RoundHours) * time.Hour).Milliseconds(),
+		t.StartsAt.UnixMilli(), t.QualifyingEndsAt.UnixMilli(), t.Stage, t.CreatedBy, t.CreatedAt.UnixMilli(),
+	).Scan(&t.ID)
+	return t, err
+}
+
+// GetTournament returns the tournament with the given ID.
+func (db *DB) GetTournament(ctx context.Context, id int64) (Tournament, error) {
+	t, err := scanTournament(db.sql.QueryRowContext(ctx, "SELECT "+tournamentColumns+" FROM tournaments WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Tournament{}, ErrNotFound
+	}
+	return t, err
+}
+
+// Tournaments lists every tournament, latest starting first.
+func (db *DB) Tournaments(ctx context.Context) ([]Tournament, error) {
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+tournamentColumns+" FROM tournaments ORDER BY starts_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tournaments := []Tournament{}
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, t)
+	}
+	return tournaments, rows.Err()
+}
+
+// DeleteTournament removes a tournament with its entrants and bracket.
+func (db *DB) DeleteTournament(ctx context.Context, id int64) error {
+	return execOne(ctx, db, "DELETE FROM tournaments WHERE id = ?", id)
+}
+
+// EnterTournament registers a player for a tournament that is still
+// qualifying. created is false if they already were.
+func (db *DB) EnterTournament(ctx context.Context, id, playerID int64) (created bool, err error) {
+	now := time.Now().UnixMilli()
+	res, err := db.sql.ExecContext(ctx, `
+		INSERT INTO tournament_entries (tournament_id, player_id, registered_at)
+		SELECT id, ?, ? FROM tournaments WHERE id = ? AND stage = ? AND qualifying_ends_at > ?
+		ON CONFLICT DO NOTHING`,
+		playerID, now, id, StageQualifying, now)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	// Already registered, closed or missing.
+	if entered, err := db.TournamentEntered(ctx, id, playerID); err != nil || entered {
+		return false, err
+	}
+	return false, db.registrationClosed(ctx, id)
+}
+
+// WithdrawTournament takes a player out of a tournament that is still
+// qualifying. It returns ErrNotFound if they weren't registered.
+func (db *DB) WithdrawTournament(ctx context.Context, id, playerID int64) error {
+	now := time.Now().UnixMilli()
+	res, err := db.sql.ExecContext(ctx, `
+		DELETE FROM tournament_entries
+		WHERE tournament_id = ? AND player_id = ?
+			AND EXISTS (SELECT 1 FROM tournaments WHERE id = ? AND stage = ? AND qualifying_ends_at > ?)`,
+		id, playerID, id, StageQualifying, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	if err := db.registrationClosed(ctx, id); err != nil {
+		return err
+	}
+	return ErrNotFound
+}
+
+// registrationClosed returns ErrNotFound if the tournament doesn't exist,
+// ErrRegistrationClosed if it is past qualifying, or nil.
+func (db *DB) registrationClosed(ctx context.Context, id int64) error {
+	t, err := db.GetTournament(ctx, id)
+	if err != nil {
+		return err
+	}
+	if t.Stage != StageQualifying || !time.Now().Before(t.QualifyingEndsAt) {
+		return ErrRegistrationClosed
+	}
+	return nil
+}
+
+// TournamentEntered reports whether a player is registered for a
+// tournament.
+func (db *DB) TournamentEntered(ctx context.Context, id, playerID int64) (bool, error) {
+	var ok bool
+	err := db.sql.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM tournament_entries WHERE tournament_id = ? AND player_id = ?)", id, playerID,
+	).Scan(&ok)
+	return ok, err
+}
+
+// Standings returns a tournament's entrants ranked by their best score in
+// qualifying so far; those without one come last, in the order they
+// registered. total is the number of entrants.
+func (db *DB) Standings(ctx context.Context, t Tournament, limit, offset int) (entrants []Entrant, total int, err error) {
+	all, err := standings(ctx, db.sql, t)
+	if err != nil {
+		return nil, 0, err
+	}
+	total = len(all)
+	all = all[min(offset, total):]
+	return all[:min(limit, len(all))], total, nil
+}
+
+func standings(ctx context.Context, q querier, t Tournament) ([]Entrant, error) {
+	best, err := bestResults(ctx, q, t, t.StartsAt, t.QualifyingEndsAt)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT e.player_id, p.display_name, e.bracket_seed, e.registered_at
+		FROM tournament_entries e JOIN players p ON p.id = e.player_id
+		WHERE e.tournament_id = ?`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entrants := []Entrant{}
+	for rows.Next() {
+		var e Entrant
+		var seed sql.NullInt64
+		var registered int64
+		if err := rows.Scan(&e.PlayerID, &e.Name, &seed, &registered); err != nil {
+			return nil, err
+		}
+		e.BracketSeed = int(seed.Int64)
+		e.RegisteredAt = time.UnixMilli(registered).UTC()
+		e.Best = best[e.PlayerID]
+		entrants = append(entrants, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entrants, func(a, b Entrant) int {
+		switch {
+		case a.Best.better(b.Best):
+			return -1
+		case b.Best.better(a.Best):
+			return 1
+		}
+		return cmp.Or(a.RegisteredAt.Compare(b.RegisteredAt), cmp.Compare(a.PlayerID, b.PlayerID))
+	})
+	for i := range entrants {
+		entrants[i].Rank = i + 1
+	}
+	return entrants, nil
+}
+
+// bestResults returns each entrant's best run on the tournament's seed and
+// difficulty submitted in [from, to). Scores hidden by a moderator or with
+// a rejected replay don't count.
+func bestResults(ctx context.Context, q querier, t Tournament, from, to time.Time) (map[int64]*TournamentResult, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT player_id, score, time_ms FROM (
+			SELECT player_id, score, time_ms,
+				ROW_NUMBER() OVER (PARTITION BY player_id ORDER BY score DESC, time_ms ASC, id ASC) AS n
+			FROM scores
+			WHERE player_id IN (SELECT player_id FROM tournament_entries WHERE tournament_id = ?)
+				AND seed = ? AND difficulty = ? AND created_at >= ? AND created_at < ?
+				AND flagged = 0 AND status <> ?
+		) best WHERE n = 1`,
+		t.ID, t.Seed, t.Difficulty, from.UnixMilli(), to.UnixMilli(), StatusRejected)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	best := make(map[int64]*TournamentResult)
+	for rows.Next() {
+		var id int64
+		var r TournamentResult
+		if err := rows.Scan(&id, &r.Score, &r.Time); err != nil {
+			return nil, err
+		}
+		best[id] = &r
+	}
+	return best, rows.Err()
+}
+
+// Bracket returns a tournament's finals matches so far by round and slot,
+// with results in the round being played as they stand.
+func (db *DB) Bracket(ctx context.Context, t Tournament) ([]Match, error) {
+	matches, err := queryMatches(ctx, db.sql, t.ID, 0)
+	if err != nil || t.Stage != StageFinals {
+		return matches, err
+	}
+	start, end := t.RoundWindow(t.Round)
+	best, err := bestResults(ctx, db.sql, t, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		if m.Round != t.Round || m.WinnerID != 0 {
+			continue
+		}
+		for _, p := range m.Players {
+			if p != nil {
+				p.Result = best[p.PlayerID]
+			}
+		}
+	}
+	return matches, nil
+}
+
+// queryMatches returns a tournament's matches in round, or every round if
+// round is 0.
+func queryMatches(ctx context.Context, q querier, id int64, round int) ([]Match, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT m.round, m.slot, m.winner_id,
+			m.player1_id, p1.display_name, e1.bracket_seed, m.score1, m.time1,
+			m.player2_id, p2.display_name, e2.bracket_seed, m.score2, m.time2
+		FROM tournament_matches m
+		LEFT JOIN players p1 ON p1.id = m.player1_id
+		LEFT JOIN tournament_entries e1 ON e1.tournament_id = m.tournament_id AND e1.player_id = m.player1_id
+		LEFT JOIN players p2 ON p2.id = m.player2_id
+		LEFT JOIN tournament_entries e2 ON e2.tournament_id = m.tournament_id AND e2.player_id = m.player2_id
+		WHERE m.tournament_id = ? AND (? = 0 OR m.round = ?)
+		ORDER BY m.round, m.slot`, id, round, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := []Match{}
+	for rows.Next() {
+		var m Match
+		var winner sql.NullInt64
+		var ids, seeds, scores, times [2]sql.NullInt64
+		var names [2]sql.NullString
+		if err := rows.Scan(&m.Round, &m.Slot, &winner,
+			&ids[0], &names[0], &seeds[0], &scores[0], &times[0],
+			&ids[1], &names[1], &seeds[1], &scores[1], &times[1]); err != nil {
+			return nil, err
+		}
+		m.WinnerID = winner.Int64
+		for i := range m.Players {
+			if !ids[i].Valid {
+				continue
+			}
+			p := &MatchPlayer{PlayerID: ids[i].Int64, Name: names[i].String, BracketSeed: int(seeds[i].Int64)}
+			if scores[i].Valid {
+				p.Result = &TournamentResult{Score: scores[i].Int64, Time: times[i].Int64}
+			}
+			m.Players[i] = p
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// AdvanceTournaments draws the bracket of each tournament whose qualifying
+// is over and settles each finals round that has ended, as of now. It
+// returns the tournaments it moved on, as they now are. Another instance
+// may advance the same tournaments at the same time; each step is taken
+// once.
+func (db *DB) AdvanceTournaments(ctx context.Context, now time.Time) ([]Tournament, error) {
+	var advanced []Tournament
+	for {
+		due, err := db.dueTournaments(ctx, now)
+		if err != nil || len(due) == 0 {
+			return advanced, err
+		}
+		for _, t := range due {
+			t, ok, err := db.advance(ctx, t)
+			if err != nil {
+				return advanced, err
+			}
+			if ok {
+				advanced = append(advanced, t)
+			}
+		}
+	}
+}
+
+// dueTournaments lists those with qualifying or a finals round over.
+func (db *DB) dueTournaments(ctx context.Context, now time.Time) ([]Tournament, error) {
+	rows, err := db.sql.QueryContext(ctx, "SELECT "+tournamentColumns+` FROM tournaments
+		WHERE (stage = ? AND qualifying_ends_at <= ?) OR (stage = ? AND qualifying_ends_at + round * round_ms <= ?)`,
+		StageQualifying, now.UnixMilli(), StageFinals, now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []Tournament
+	for rows.Next() {
+		t, err := scanTournament(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, t)
+	}
+	return due, rows.Err()
+}
+
+// advance takes t's next step. ok is false if another instance took it
+// first.
+func (db *DB) advance(ctx context.Context, t Tournament) (_ Tournament, ok bool, err error) {
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return t, false, err
+	}
+	defer tx.Rollback()
+	// Claim the step before reading anything it depends on.
+	res, err := tx.ExecContext(ctx, "UPDATE tournaments SET round = round + 1 WHERE id = ? AND stage = ? AND round = ?", t.ID, t.Stage, t.Round)
+	if err != nil {
+		return t, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return t, false, err
+	}
+	if t.Stage == StageQualifying {
+		err = drawBracket(ctx, tx, &t)
+	} else {
+		err = settleRound(ctx, tx, &t)
+	}
+	if err != nil {
+		return t, false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tournaments SET stage = ?, round = ?, winner_id = ? WHERE id = ?",
+		t.Stage, t.Round, nullInt64(t.WinnerID), t.ID); err != nil {
+		return t, false, err
+	}
+	return t, true, tx.Commit()
+}
+
+// drawBracket seeds the best qualifiers into round 1, with byes for the
+// top seeds if there are fewer than a full bracket. With fewer than two
+// qualifiers there are no finals: the one, if any, wins.
+func drawBracket(ctx context.Context, tx *tx, t *Tournament) error {
+	entrants, err := standings(ctx, tx, *t)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for n < len(entrants) && n < t.BracketSize && entrants[n].Best != nil {
+		n++
+	}
+	qualified := entrants[:n]
+	if n < 2 {
+		t.Stage, t.Round = StageFinished, 0
+		if n == 1 {
+			t.WinnerID = qualified[0].PlayerID
+		}
+		return nil
+	}
+	for i, e := range qualified {
+		if _, err := tx.ExecContext(ctx, "UPDATE tournament_entries SET bracket_seed = ? WHERE tournament_id = ? AND player_id = ?",
+			i+1, t.ID, e.PlayerID); err != nil {
+			return err
+		}
+	}
+	size := 2
+	for size < n {
+		size *= 2
+	}
+	order := bracketOrder(size)
+	for slot := range size / 2 {
+		// The first seed of a pair is always the better, and is never a
+		// bye.
+		p1, p2, winner := qualified[order[2*slot]-1].PlayerID, int64(0), int64(0)
+		if s := order[2*slot+1]; s <= n {
+			p2 = qualified[s-1].PlayerID
+		} else {
+			winner = p1
+		}
+		if err := insertMatch(ctx, tx, t.ID, 1, slot, p1, p2, winner); err != nil {
+			return err
+		}
+	}
+	t.Stage, t.Round = StageFinals, 1
+	return nil
+}
+
+// bracketOrder returns the seeds of a bracket of size players in slot
+// order, so that 1 and 2 can only meet in the final: 1, 8, 4, 5, 2, 7, 3,
+// 6 for eight.
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for n := 2; n <= size; n *= 2 {
+		next := make([]int, 0, n)
+		for _, s := range order {
+			next = append(next, s, n+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+// settleRound decides the matches of the round that has ended on each
+// player's best run in it, and pairs the winners for the next round, or
+// crowns the winner of the final. A tie on score and time goes to the
+// better seed.
+func settleRound(ctx context.Context, tx *tx, t *Tournament) error {
+	matches, err := queryMatches(ctx, tx, t.ID, t.Round)
+	if err != nil {
+		return err
+	}
+	start, end := t.RoundWindow(t.Round)
+	best, err := bestResults(ctx, tx, *t, start, end)
+	if err != nil {
+		return err
+	}
+	winners := make([]int64, len(matches))
+	for i, m := range matches {
+		if m.WinnerID != 0 {
+			winners[i] = m.WinnerID
+			continue
+		}
+		var results [2]*TournamentResult
+		for j, p := range m.Players {
+			if p != nil {
+				results[j] = best[p.PlayerID]
+			}
+		}
+		switch a, b := m.Players[0], m.Players[1]; {
+		case a == nil && b == nil:
+		case b == nil || results[0].better(results[1]):
+			winners[i] = a.PlayerID
+		case a == nil || results[1].better(results[0]):
+			winners[i] = b.PlayerID
+		case a.BracketSeed <= b.BracketSeed:
+			winners[i] = a.PlayerID
+		default:
+			winners[i] = b.PlayerID
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE tournament_matches SET score1 = ?, time1 = ?, score2 = ?, time2 = ?, winner_id = ?
+			WHERE tournament_id = ? AND round = ? AND slot = ?`,
+			resultScore(results[0]), resultTime(results[0]), resultScore(results[1]), resultTime(results[1]), nullInt64(winners[i]),
+			t.ID, m.Round, m.Slot); err != nil {
+			return err
+		}
+	}
+	if len(matches) <= 1 {
+		t.Stage = StageFinished
+		if len(winners) == 1 {
+			t.WinnerID = winners[0]
+		}
+		return nil
+	}
+	t.Round++
+	for slot := range len(winners) / 2 {
+		p1, p2, winner := winners[2*slot], winners[2*slot+1], int64(0)
+		// A side left empty by a deleted account is a walkover.
+		if p1 == 0 || p2 == 0 {
+			winner = max(p1, p2)
+			p1, p2 = winner, 0
+		}
+		if err := insertMatch(ctx, tx, t.ID, t.Round, slot, p1, p2, winner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertMatch(ctx context.Context, tx *tx, id int64, round, slot int, p1, p2, winner int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO tournament_matches (tournament_id, round, slot, player1_id, player2_id, winner_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id, round, slot, nullInt64(p1), nullInt64(p2), nullInt64(winner))
+	return err
+}
+
+func resultScore(r *TournamentResult) sql.NullInt64 {
+	if r == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: r.Score, Valid: true}
+}
+
+func resultTime(r *TournamentResult) sql.NullInt64 {
+	if r == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: r.Time, Valid: true}
+}
+
+func scanTournament(row rowScanner) (Tournament, error) {
+	var t Tournament
+	var roundMillis, starts, qualifyingEnds, created int64
+	var winner sql.NullInt64
+	err := row.Scan(&t.ID, &t.Name, &t.Seed, &t.Difficulty, &t.Format, &t.BracketSize, &roundMillis, &starts, &qualifyingEnds,
+		&t.Stage, &t.Round, &winner, &t.CreatedBy, &created, &t.Entrants)
+	t.RoundHours = int(time.Duration(roundMillis) * time.Millisecond / time.Hour)
+	t.StartsAt = time.UnixMilli(starts).UTC()
+	t.QualifyingEndsAt = time.UnixMilli(qualifyingEnds).UTC()
+	t.WinnerID = winner.Int64
+	t.CreatedAt = time.UnixMilli(created).UTC()
+	return t, err
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/jgbrwn/loderunner2099/internal/storage")
+
+// conn is the database with a span around every statement run on behalf
+// of a traced request. Time spent waiting out another writer's lock, up to
+// SQLite's busy timeout, shows up in the statement's span. Queries are
+// rewritten for the database's dialect on the way.
+type conn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = c.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, c.dialect, query)
+	defer span.End()
+	res, err := c.DB.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+	return res, err
+}
+
+func (c conn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = c.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, c.dialect, query)
+	defer span.End()
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+func (c conn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = c.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, c.dialect, query)
+	defer span.End()
+	row := c.DB.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+	return row
+}
+
+func (c conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tx, error) {
+	t, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, ctx: ctx, dialect: c.dialect}, nil
+}
+
+// tx traces the statements of a transaction, and its commit.
+type tx struct {
+	*sql.Tx
+	ctx     context.Context
+	dialect *dialect
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query, args = t.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, t.dialect, query)
+	defer span.End()
+	res, err := t.Tx.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+	return res, err
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query, args = t.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, t.dialect, query)
+	defer span.End()
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query, args = t.dialect.rebind(query, args)
+	ctx, span := startQuery(ctx, t.dialect, query)
+	defer span.End()
+	row := t.Tx.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+	return row
+}
+
+func (t *tx) Commit() error {
+	_, span := startQuery(t.ctx, t.dialect, "COMMIT")
+	defer span.End()
+	err := t.Tx.Commit()
+	endQuery(span, err)
+	return err
+}
+
+// startQuery starts a client span named after the statement's operation,
+// like SELECT or INSERT. Queries outside a traced request, such as
+// background jobs, don't start traces of their own.
+func startQuery(ctx context.Context, d *dialect, query string) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return ctx, parent
+	}
+	query = strings.TrimSpace(query)
+	op := query
+	if i := strings.IndexFunc(query, unicode.IsSpace); i > 0 {
+		op = query[:i]
+	}
+	op = strings.ToUpper(op)
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		d.system,
+		semconv.DBOperationName(op),
+		semconv.DBQueryText(query),
+	))
+}
+
+func endQuery(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,268 @@
+// Package webpush sends Web Push messages: payloads encrypted for the
+// browser (RFC 8291) and signed with the server's VAPID key (RFC 8292), so
+// any browser's push service accepts them without an account.
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrGone means the push service no longer knows the subscription, which
+// happens when the user revokes permission or the browser drops it. Stop
+// sending to it.
+var ErrGone = errors.New("webpush: subscription expired or unsubscribed")
+
+// b64 is the encoding browsers use for keys.
+var b64 = base64.RawURLEncoding
+
+// Subscription is what the browser's PushManager.subscribe returns.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Validate checks that s looks like a real subscription, so bad ones are
+// refused when stored rather than failing every send.
+func (s Subscription) Validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("endpoint must be an https URL")
+	}
+	if _, err := s.keys(); err != nil {
+		return err
+	}
+	return nil
+}
+
+type clientKeys struct {
+	pub  *ecdh.PublicKey
+	auth []byte
+}
+
+func (s Subscription) keys() (clientKeys, error) {
+	raw, err := decodeKey(s.Keys.P256dh)
+	if err != nil {
+		return clientKeys{}, errors.New("keys.p256dh must be base64url")
+	}
+	pub, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return clientKeys{}, errors.New("keys.p256dh is not a P-256 public key")
+	}
+	auth, err := decodeKey(s.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return clientKeys{}, errors.New("keys.auth must be 16 bytes of base64url")
+	}
+	return clientKeys{pub, auth}, nil
+}
+
+// decodeKey accepts base64url with or without padding, as browsers differ.
+func decodeKey(s string) ([]byte, error) {
+	return b64.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VAPIDKey identifies the server to push services.
+type VAPIDKey struct {
+	private *ecdsa.PrivateKey
+}
+
+// ParseVAPIDKey reads a raw 32-byte P-256 private key, base64url-encoded
+// like the keys web-push tools generate.
+func ParseVAPIDKey(s string) (*VAPIDKey, error) {
+	raw, err := decodeKey(s)
+	if err != nil {
+		return nil, errors.New("VAPID private key must be base64url")
+	}
+	return NewVAPIDKey(raw)
+}
+
+// NewVAPIDKey uses raw as the private key's scalar.
+func NewVAPIDKey(raw []byte) (*VAPIDKey, error) {
+	k, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("VAPID private key: %w", err)
+	}
+	return &VAPIDKey{k}, nil
+}
+
+// PublicKey is the applicationServerKey browsers subscribe with.
+func (k *VAPIDKey) PublicKey() string {
+	raw, _ := k.private.PublicKey.Bytes()
+	return b64.EncodeToString(raw)
+}
+
+// token returns the signed JWT for push services at audience.
+func (k *VAPIDKey) token(audience, subject string, exp time.Time) (string, error) {
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims := map[string]any{"aud": audience, "exp": exp.Unix()}
+	if subject != "" {
+		claims["sub"] = subject
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + b64.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS wants r and s as fixed-size big-endian integers, not ASN.1.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + b64.EncodeToString(sig), nil
+}
+
+// Options for one message.
+type Options struct {
+	// Subject is a mailto: or https: contact for the push service's
+	// operators. Some services, Apple's among them, require it.
+	Subject string
+
+	// TTL is how long the push service keeps the message for a browser
+	// that is offline. Zero means deliver now or not at all.
+	TTL time.Duration
+
+	// Urgency is very-low, low, normal or high; empty means normal.
+	Urgency string
+
+	// Topic replaces an undelivered earlier message with the same topic.
+	Topic string
+}
+
+// recordSize is the record size advertised in the header; a message is a
+// single record, so it only bounds the payload.
+const recordSize = 4096
+
+// MaxPayload is the largest payload push services must accept.
+const MaxPayload = 3993
+
+// Send encrypts payload for sub and posts it to sub's push service.
+func (k *VAPIDKey) Send(ctx context.Context, client *http.Client, sub Subscription, payload []byte, opts Options) error {
+	if len(payload) > MaxPayload {
+		return fmt.Errorf("webpush: payload of %d bytes is over %d", len(payload), MaxPayload)
+	}
+	keys, err := sub.keys()
+	if err != nil {
+		return err
+	}
+	body, err := encrypt(keys, payload)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+	jwt, err := k.token(u.Scheme+"://"+u.Host, opts.Subject, time.Now().Add(12*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(opts.TTL.Seconds())))
+	req.Header.Set("Authorization", "vapid t="+jwt+", k="+k.PublicKey())
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webpush: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// encrypt builds an aes128gcm body (RFC 8188) with the keys derived as in
+// RFC 8291, from a fresh key pair and salt per message.
+func encrypt(keys clientKeys, payload []byte) ([]byte, error) {
+	local, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := local.ECDH(keys.pub)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	uaPub, asPub := keys.pub.Bytes(), local.PublicKey().Bytes()
+	prk, err := hkdf.Extract(sha256.New, shared, keys.auth)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prk, "WebPush: info\x00"+string(uaPub)+string(asPub), 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err = hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 2 marks the last (and only) record.
+	plain := append(append([]byte{}, payload...), 2)
+
+	body := make([]byte, 0, 16+4+1+len(asPub)+len(plain)+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(asPub)))
+	body = append(body, asPub...)
+	return gcm.Seal(body, nonce, plain, nil), nil
+}
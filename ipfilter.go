@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var ipDenied = defaultRegistry.counter("loderunner_ip_denied_total",
+	"Requests refused by the IP filter.")
+
+// verdict is an ipDecider's answer about a client.
+type verdict int
+
+const (
+	abstain verdict = iota // no opinion; ask the next decider
+	allow                  // let the client in without asking further
+	deny
+)
+
+// ipDecider is the extension point of the IP filter. Deciders are asked in
+// order and the first that doesn't abstain settles the request. To block by
+// GeoIP database, implement decide with a MaxMind reader looking up ip and
+// add the decider in newIPFilter.
+type ipDecider interface {
+	decide(r *http.Request, ip netip.Addr) verdict
+}
+
+// ipDeciderFunc adapts a plain function to ipDecider.
+type ipDeciderFunc func(r *http.Request, ip netip.Addr) verdict
+
+func (f ipDeciderFunc) decide(r *http.Request, ip netip.Addr) verdict { return f(r, ip) }
+
+// denyRanges refuses addresses in its ranges.
+type denyRanges ipRanges
+
+func (d denyRanges) decide(_ *http.Request, ip netip.Addr) verdict {
+	if ipRanges(d).contains(ip) {
+		return deny
+	}
+	return abstain
+}
+
+// allowRanges admits addresses in its ranges and refuses everyone else.
+type allowRanges ipRanges
+
+func (a allowRanges) decide(_ *http.Request, ip netip.Addr) verdict {
+	if ipRanges(a).contains(ip) {
+		return allow
+	}
+	return deny
+}
+
+// countryHeader decides by the country code a trusted proxy or CDN puts in
+// a header, such as Cloudflare's CF-IPCountry. Requests that didn't come
+// through a trusted proxy are left to the other deciders.
+type countryHeader struct {
+	header  string
+	proxies trustedProxies
+	allowed map[string]bool // when set, every other country is refused
+	denied  map[string]bool
+}
+
+func (c *countryHeader) decide(r *http.Request, _ netip.Addr) verdict {
+	if !c.proxies.proxied(r) {
+		return abstain
+	}
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(c.header)))
+	switch {
+	case c.denied[country]:
+		return deny
+	case len(c.allowed) > 0 && !c.allowed[country]:
+		return deny
+	}
+	return abstain
+}
+
+// ipFilter refuses clients by address or country. Health probes always get
+// through, since load balancers rarely sit on an allowed network.
+type ipFilter struct {
+	proxies  trustedProxies
+	deciders []ipDecider
+}
+
+// newIPFilter returns nil when no list is configured. Deny ranges are
+// checked first, then countries, then allow ranges.
+func newIPFilter(cfg config, proxies trustedProxies) (*ipFilter, error) {
+	f := &ipFilter{proxies: proxies}
+	if len(cfg.DenyIPs) > 0 {
+		ranges, err := parseIPRanges(cfg.DenyIPs)
+		if err != nil {
+			return nil, fmt.Errorf("-deny-ips: %w", err)
+		}
+		f.deciders = append(f.deciders, denyRanges(ranges))
+	}
+	if len(cfg.AllowCountries) > 0 || len(cfg.DenyCountries) > 0 {
+		f.deciders = append(f.deciders, &countryHeader{
+			header:  cfg.CountryHeader,
+			proxies: proxies,
+			allowed: countrySet(cfg.AllowCountries),
+			denied:  countrySet(cfg.DenyCountries),
+		})
+	}
+	if len(cfg.AllowIPs) > 0 {
+		ranges, err := parseIPRanges(cfg.AllowIPs)
+		if err != nil {
+			return nil, fmt.Errorf("-allow-ips: %w", err)
+		}
+		f.deciders = append(f.deciders, allowRanges(ranges))
+	}
+	if len(f.deciders) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func countrySet(codes []string) map[string]bool {
+	set := map[string]bool{}
+	for _, c := range codes {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+func (f *ipFilter) allowed(r *http.Request) bool {
+	ip, err := netip.ParseAddr(f.proxies.clientIP(r))
+	if err != nil {
+		return false
+	}
+	for _, d := range f.deciders {
+		switch d.decide(r, ip) {
+		case allow:
+			return true
+		case deny:
+			return false
+		}
+	}
+	return true
+}
+
+// filterIPs refuses requests the filter doesn't allow with 403.
+func filterIPs(f *ipFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || f.allowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ipDenied.inc()
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeError(w, http.StatusForbidden, "access denied")
+			return
+		}
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import "net/http"
+
+// crossOriginIsolation adds COOP/COEP headers to matching paths so the page
+// is cross-origin isolated and can use SharedArrayBuffer, which the
+// multithreaded WASM audio engine needs. Every subresource must then be
+// same-origin or opt in via CORS/CORP, so it's off unless paths are set.
+func crossOriginIsolation(paths pathPatterns, coep string, next http.Handler) http.Handler {
+	if len(paths) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if paths.match(r.URL.Path) {
+			w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+			w.Header().Set("Cross-Origin-Embedder-Policy", coep)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
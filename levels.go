@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jgbrwn/loderunner2099/internal/level"
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Limits for uploaded levels.
+const (
+	maxLevelTitle       = 40
+	maxLevelDescription = 280
+	maxLevelSearch      = 64
+	maxLevelBody        = 32 << 10
+)
+
+// levelUpload is the level editor's JSON export.
+type levelUpload struct {
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Description string   `json:"description"`
+	Width       int      `json:"width"`
+	Height      int      `json:"height"`
+	Tiles       []string `json:"tiles"`
+}
+
+// validate trims the upload, checks it against the level schema and
+// returns the parsed grid.
+func (u *levelUpload) validate() (*level.Grid, error) {
+	u.Title = strings.TrimSpace(u.Title)
+	u.Author = strings.TrimSpace(u.Author)
+	u.Description = strings.TrimSpace(u.Description)
+
+	if err := checkText("title", u.Title, maxLevelTitle, true); err != nil {
+		return nil, err
+	}
+	if err := checkText("author", u.Author, maxPlayerName, true); err != nil {
+		return nil, err
+	}
+	if utf8.RuneCountInString(u.Description) > maxLevelDescription {
+		return nil, fmt.Errorf("description must be at most %d characters", maxLevelDescription)
+	}
+	if u.Width != level.Width || u.Height != level.Height {
+		return nil, fmt.Errorf("grid must be %dx%d, got %dx%d", level.Width, level.Height, u.Width, u.Height)
+	}
+	g, err := level.Parse(u.Tiles)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// decodeLevelUpload reads an upload as it arrives, checking the title and
+// description as each is read and the grid a row at a time, so one with a
+// bad row, or too many, is refused without reading the rest. validate
+// still checks the whole upload.
+func decodeLevelUpload(w http.ResponseWriter, r *http.Request) (levelUpload, error) {
+	var up levelUpload
+	var g level.Grid
+	text := func(field string, dst *string, max int) func(*fieldDecoder) error {
+		return func(d *fieldDecoder) error {
+			if err := d.decode(dst); err != nil {
+				return err
+			}
+			if utf8.RuneCountInString(strings.TrimSpace(*dst)) > max {
+				return fmt.Errorf("%s must be at most %d characters", field, max)
+			}
+			return nil
+		}
+	}
+	err := decodeFields(w, r, maxLevelBody, map[string]func(*fieldDecoder) error{
+		"title":       text("title", &up.Title, maxLevelTitle),
+		"author":      func(d *fieldDecoder) error { return d.decode(&up.Author) },
+		"description": text("description", &up.Description, maxLevelDescription),
+		"width":       func(d *fieldDecoder) error { return d.decode(&up.Width) },
+		"height":      func(d *fieldDecoder) error { return d.decode(&up.Height) },
+		"tiles": func(d *fieldDecoder) error {
+			up.Tiles = nil
+			return d.each(func() error {
+				var row string
+				if err := d.decode(&row); err != nil {
+					return err
+				}
+				if err := g.SetRow(len(up.Tiles), row); err != nil {
+					return err
+				}
+				up.Tiles = append(up.Tiles, row)
+				return nil
+			})
+		},
+	})
+	return up, err
+}
+
+// checkText validates a single-line user-supplied string.
+func checkText(field, s string, max int, required bool) error {
+	switch n := utf8.RuneCountInString(s); {
+	case n == 0 && required:
+		return fmt.Errorf("%s is required", field)
+	case n > max:
+		return fmt.Errorf("%s must be at most %d characters", field, max)
+	case strings.IndexFunc(s, unicode.IsControl) >= 0:
+		return fmt.Errorf("%s contains control characters", field)
+	}
+	return nil
+}
+
+// levelsAPI serves /api/levels.
+type levelsAPI struct {
+	db        *storage.DB
+	publicURL string
+	filter    *contentFilter
+	quotas    *quotas
+}
+
+// sharedLevel is a level with the short link to share it by.
+type sharedLevel struct {
+	storage.Level
+	ShortURL string `json:"short_url,omitempty"`
+}
+
+// shared adds l's short link, leaving it out if that fails: the level
+// matters more than the link.
+func (a *levelsAPI) shared(w http.ResponseWriter, r *http.Request, l storage.Level) sharedLevel {
+	code, err := levelShortCode(r.Context(), a.db, l.ID)
+	if err != nil {
+		log.Printf("levels: short link for %d: %v (request %s)", l.ID, err, requestID(w))
+		return sharedLevel{Level: l}
+	}
+	return sharedLevel{l, siteBase(a.publicURL, r) + "/l/" + code}
+}
+
+func (a *levelsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/levels", a.upload)
+	mux.HandleFunc("GET /api/levels", a.list)
+	mux.HandleFunc("GET /api/levels/legend", a.legend)
+	mux.HandleFunc("GET /api/levels/{id}", a.show)
+	mux.HandleFunc("POST /api/levels/{id}/rate", a.rate)
+}
+
+func (a *levelsAPI) upload(w http.ResponseWriter, r *http.Request) {
+	up, err := decodeLevelUpload(w, r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	player := currentPlayer(r)
+	if player != nil {
+		up.Author = player.DisplayName
+	}
+	g, err := up.validate()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if !a.filter.allow(w, r, "title", moderation.Title, up.Title) ||
+		!a.filter.allow(w, r, "description", moderation.Message, up.Description) ||
+		player == nil && !a.filter.allow(w, r, "author", moderation.Name, up.Author) {
+		return
+	}
+
+	l := storage.Level{
+		Title:       up.Title,
+		Author:      up.Author,
+		Description: up.Description,
+		Tiles:       g.Rows(),
+		Gold:        g.Count(level.Gold),
+		Guards:      g.Count(level.Guard),
+
+		PlayerTokenHash: optionalPlayerToken(r),
+	}
+	if player != nil {
+		l.PlayerID = player.ID
+	}
+	size := int64(len(l.Title) + len(l.Description) + len(strings.Join(l.Tiles, "\n")))
+	subject, ok := a.quotas.allow(w, r, quotaUpload{kind: storage.QuotaLevels, size: size, counted: true})
+	if !ok {
+		return
+	}
+	l.QuotaSubject = subject
+	l, err = a.db.CreateLevel(r.Context(), l)
+	if err != nil {
+		a.fail(w, "create level", err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/levels/%d", l.ID))
+	writeJSON(w, http.StatusCreated, a.shared(w, r, l))
+}
+
+// list returns level summaries (no tiles), optionally filtered by a
+// title/author search and sorted by newest, plays or rating.
+func (a *levelsAPI) list(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 20, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q, err := levelQuery(r.URL.Query().Get("q"), r.URL.Query().Get("sort"), r.URL.Query().Get("featured") == "true", limit, offset)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	levels, total, err := a.db.ListLevels(r.Context(), q)
+	if err != nil {
+		a.fail(w, "list levels", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"levels": levels,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"sort":   q.Sort,
+	})
+}
+
+// levelQuery checks a level listing's search and sort, as the JSON and
+// gRPC APIs take them.
+func levelQuery(search, sort string, featured bool, limit, offset int) (storage.LevelQuery, error) {
+	q := storage.LevelQuery{
+		Search:   strings.TrimSpace(search),
+		Sort:     storage.LevelSort(strings.ToLower(sort)),
+		Limit:    limit,
+		Offset:   offset,
+		Featured: featured,
+	}
+	if q.Sort == "" {
+		q.Sort = storage.SortNewest
+	}
+	if !storage.ValidLevelSort(q.Sort) {
+		return q, errors.New("sort must be newest, plays, rating or featured")
+	}
+	if utf8.RuneCountInString(q.Search) > maxLevelSearch {
+		return q, fmt.Errorf("q must be at most %d characters", maxLevelSearch)
+	}
+	return q, nil
+}
+
+func (a *levelsAPI) show(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	l, err := playLevel(r.Context(), a.db, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "level not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get level", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.shared(w, r, l))
+}
+
+// playLevel returns a level to play, counting the play. Every fetch is a
+// play: clients only load a level to play it.
+func playLevel(ctx context.Context, db *storage.DB, id int64) (storage.Level, error) {
+	if err := db.RecordPlay(ctx, id); err != nil {
+		return storage.Level{}, err
+	}
+	return db.GetLevel(ctx, id)
+}
+
+// rate records a 1-5 star vote. Each player token gets one vote per level;
+// voting again changes it.
+func (a *levelsAPI) rate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	voter, ok := playerToken(w, r)
+	if !ok {
+		return
+	}
+	var vote struct {
+		Stars int `json:"stars"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &vote); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if vote.Stars < 1 || vote.Stars > 5 {
+		writeError(w, http.StatusUnprocessableEntity, "stars must be between 1 and 5")
+		return
+	}
+
+	avg, count, err := a.db.RateLevel(r.Context(), id, voter, vote.Stars)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "level not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "rate level", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":      id,
+		"stars":   vote.Stars,
+		"rating":  avg,
+		"ratings": count,
+	})
+}
+
+// legend describes the upload schema so the editor can check exports
+// before sending them.
+func (a *levelsAPI) legend(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"width":      level.Width,
+		"height":     level.Height,
+		"max_guards": level.MaxGuards,
+		"tiles":      level.Legend(),
+	})
+}
+
+func (a *levelsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("levels: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor systemd passes, after stdin,
+// stdout and stderr.
+const listenFdsStart = 3
+
+// listenSpec is one -listen value: an address followed by options.
+//
+//	:8000
+//	:8443 tls
+//	127.0.0.1:9000 internal
+//	unix:/run/loderunner2099.sock plain
+type listenSpec struct {
+	addr     string // host:port, unix:/path or systemd
+	tls      bool
+	internal bool // also serves metrics, admin and -debug, which public listeners hide
+}
+
+func parseListenSpec(s string, tlsDefault bool) (listenSpec, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return listenSpec{}, errors.New("-listen: empty address")
+	}
+	spec := listenSpec{addr: fields[0], tls: tlsDefault}
+	for _, opt := range fields[1:] {
+		switch opt {
+		case "tls":
+			spec.tls = true
+		case "plain":
+			spec.tls = false
+		case "internal":
+			spec.internal = true
+		default:
+			return listenSpec{}, fmt.Errorf("-listen %q: unknown option %q, want tls, plain or internal", s, opt)
+		}
+	}
+	return spec, nil
+}
+
+// listeners returns the addresses to serve on: every -listen, or -host and
+// -port when there are none, unless systemd has passed a socket (to this
+// process or the one it took over from).
+func (c config) listeners() ([]listenSpec, error) {
+	if len(c.Listen) == 0 {
+		addr := net.JoinHostPort(c.Host, c.Port)
+		if os.Getenv("LISTEN_FDS") != "" || inheritedSystemd() {
+			addr = "systemd"
+		}
+		return []listenSpec{{addr: addr, tls: c.tlsEnabled()}}, nil
+	}
+	var specs []listenSpec
+	systemd := 0
+	for _, s := range c.Listen {
+		spec, err := parseListenSpec(s, c.tlsEnabled())
+		if err != nil {
+			return nil, err
+		}
+		if spec.tls && !c.tlsEnabled() {
+			return nil, fmt.Errorf("-listen %q: tls needs -tls-cert and -tls-key, or -acme", s)
+		}
+		if spec.addr == "systemd" {
+			systemd++
+		}
+		specs = append(specs, spec)
+	}
+	if systemd > 1 {
+		return nil, errors.New("-listen: systemd may only be given once")
+	}
+	return specs, nil
+}
+
+// listen opens a listener: a TCP address, "unix:/path" for a socket a
+// local reverse proxy connects to, or "systemd" for a socket systemd
+// opened for us.
+func listen(addr string, socketMode fs.FileMode) (net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemdListener()
+	case strings.HasPrefix(addr, "unix:"):
+		return unixListener(strings.TrimPrefix(addr, "unix:"), socketMode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func unixListener(path string, mode fs.FileMode) (net.Listener, error) {
+	// A socket left behind by a crash makes Listen fail; a live server
+	// still answering on it is a configuration mistake.
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("listen: %s exists and isn't a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("listen: %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return localListener{ln}, nil
+}
+
+// systemdListener takes over the socket from systemd socket activation
+// (sd_listen_fds). Only one socket is supported.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("listen: no socket passed by systemd (LISTEN_PID doesn't match)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("listen: no socket passed by systemd (LISTEN_FDS)")
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("listen: systemd passed %d sockets, want 1", n)
+	}
+	// Children, like npm run build, must not think the socket is theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("listen: systemd socket: %w", err)
+	}
+	if ln.Addr().Network() == "unix" {
+		return localListener{ln}, nil
+	}
+	return ln, nil
+}
+
+// localListener accepts Unix socket connections and reports their peer as
+// 127.0.0.1. Only a local process can connect, and a loopback address lets
+// -trusted-proxies, rate limits and the access log treat the reverse proxy
+// like one on TCP.
+type localListener struct {
+	net.Listener
+}
+
+func (l localListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return localConn{c}, nil
+}
+
+type localConn struct {
+	net.Conn
+}
+
+var loopbackAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+func (c localConn) RemoteAddr() net.Addr { return loopbackAddr }
+
+// ReadFrom keeps sendfile working on the wrapped socket.
+func (c localConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// listenDescription names the listener for the startup log.
+func listenDescription(ln net.Listener, scheme string) string {
+	addr := ln.Addr()
+	if addr.Network() == "unix" {
+		return "unix:" + addr.String()
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP.IsUnspecified() {
+		return fmt.Sprintf("%s://localhost:%d", scheme, tcp.Port)
+	}
+	return scheme + "://" + addr.String()
+}
+
+// internalPaths are only served on internal listeners once there is one.
+var internalPaths = pathPatterns{"/metrics", "/admin", "/admin/*", "/api/admin/*", "/debug/*"}
+
+// hideInternal answers 404 for internalPaths, as if they didn't exist.
+func hideInternal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if internalPaths.match(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
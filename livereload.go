@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/fsnotify/fsnotify"
+)
+
+// liveReloadSettle is how long dist/ must stay quiet before a build counts
+// as finished. Vite writes dozens of files and browsers shouldn't reload
+// halfway through.
+const liveReloadSettle = 300 * time.Millisecond
+
+// liveReloadTag is added to every HTML page while live reload is on.
+var liveReloadTag = []byte(`<script src="/_livereload.js"></script>`)
+
+// liveReloadScript reconnects after the server goes away and reloads
+// then, too, since a restart usually means a new build.
+const liveReloadScript = `(() => {
+  let lost = false;
+  const connect = () => {
+    const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/_livereload');
+    ws.onopen = () => { if (lost) location.reload(); };
+    ws.onmessage = (e) => { if (e.data === 'reload') location.reload(); };
+    ws.onclose = () => { lost = true; setTimeout(connect, 1000); };
+  };
+  connect();
+})();
+`
+
+// liveReload watches dist/ and tells connected browsers to reload when a
+// new build lands.
+type liveReload struct {
+	dir string
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced after each build
+}
+
+func newLiveReload(dir string) *liveReload {
+	return &liveReload{dir: dir, changed: make(chan struct{})}
+}
+
+func (l *liveReload) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /_livereload", l.serve)
+	mux.HandleFunc("GET /_livereload.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, liveReloadScript)
+	})
+}
+
+// next returns a channel that is closed at the next build.
+func (l *liveReload) next() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *liveReload) broadcast() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// watch runs until ctx is done. Directories created by the build are
+// watched as they appear.
+func (l *liveReload) watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := addWatchTree(w, l.dir); err != nil {
+		return err
+	}
+
+	settle := time.NewTimer(liveReloadSettle)
+	settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					addWatchTree(w, ev.Name)
+				}
+			}
+			settle.Reset(liveReloadSettle)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("livereload: %v", err)
+		case <-settle.C:
+			log.Printf("🔄 %s changed, reloading browsers", l.dir)
+			l.broadcast()
+		}
+	}
+}
+
+// addWatchTree watches root and every directory below it.
+func addWatchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return w.Add(p)
+	})
+}
+
+// serve holds a WebSocket open until the next build, then sends "reload".
+func (l *liveReload) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return // Accept has already written the error response
+	}
+	defer conn.CloseNow()
+	ctx := conn.CloseRead(r.Context())
+	select {
+	case <-l.next():
+		conn.Write(ctx, websocket.MessageText, []byte("reload"))
+		conn.Close(websocket.StatusNormalClosure, "")
+	case <-ctx.Done():
+	}
+}
+
+// injectLiveReload adds the live reload script to an HTML page, just
+// before </body> when there is one.
+func injectLiveReload(page []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, liveReloadTag...)
+	}
+	out := make([]byte, 0, len(page)+len(liveReloadTag))
+	out = append(out, page[:i]...)
+	out = append(out, liveReloadTag...)
+	return append(out, page[i:]...)
+}
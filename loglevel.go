@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Log levels for -log-level, quietest last.
+var logLevels = map[string]int{"info": 0, "warn": 1, "error": 2}
+
+// levelWriter drops log lines below a level. It goes by the server's
+// conventions: status lines start with an emoji, warnings with ⚠️, and
+// everything else ("area: op: err") reports an error.
+type levelWriter struct {
+	out   io.Writer
+	level int
+}
+
+// setLogLevel routes the standard logger through a levelWriter.
+func setLogLevel(out io.Writer, level string) {
+	log.SetFlags(0)
+	log.SetOutput(&levelWriter{out: out, level: logLevels[level]})
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	if lineLevel(string(p)) < w.level {
+		return len(p), nil
+	}
+	_, err := io.WriteString(w.out, time.Now().Format("2006/01/02 15:04:05 ")+string(p))
+	return len(p), err
+}
+
+func lineLevel(line string) int {
+	if strings.HasPrefix(line, "⚠️") {
+		return logLevels["warn"]
+	}
+	if r, _ := utf8.DecodeRuneInString(line); r >= 0x2000 {
+		return logLevels["info"]
+	}
+	return logLevels["error"]
+}
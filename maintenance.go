@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maintenanceKey holds maintenance mode as set with PUT
+// /api/admin/maintenance. It overrides -maintenance until it is deleted, on
+// every replica sharing -redis.
+const maintenanceKey = "maintenance"
+
+// maintenanceRefresh is how often replicas read maintenanceKey for a change
+// made on another one.
+const maintenanceRefresh = 5 * time.Second
+
+// maintenancePage is served in place of the game's pages, from the build
+// if it has one.
+const maintenancePage = "maintenance.html"
+
+type maintenanceState struct {
+	Enabled    bool   `json:"enabled"`
+	RetryAfter int    `json:"retry_after"` // seconds
+	Message    string `json:"message,omitempty"`
+}
+
+// maintenance switches every site to answering 503 while the backend is
+// down for a migration or the like.
+type maintenance struct {
+	initial maintenanceState // from -maintenance
+	state   atomic.Pointer[maintenanceState]
+	store   ephemeral.Store
+}
+
+func newMaintenance(cfg config, store ephemeral.Store) *maintenance {
+	m := &maintenance{
+		initial: maintenanceState{Enabled: cfg.Maintenance, RetryAfter: int(cfg.MaintenanceRetryAfter.Seconds())},
+		store:   store,
+	}
+	m.state.Store(&m.initial)
+	defaultRegistry.gaugeFunc("loderunner_maintenance", "1 while in maintenance mode.", func() float64 {
+		if m.current().Enabled {
+			return 1
+		}
+		return 0
+	})
+	return m
+}
+
+func (m *maintenance) current() maintenanceState { return *m.state.Load() }
+
+// run keeps the state in step with maintenanceKey until ctx is done.
+func (m *maintenance) run(ctx context.Context) {
+	ticker := time.NewTicker(maintenanceRefresh)
+	defer ticker.Stop()
+	for {
+		if err := m.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("maintenance: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *maintenance) refresh(ctx context.Context) error {
+	b, err := m.store.Get(ctx, maintenanceKey)
+	if err != nil {
+		return err
+	}
+	s := m.initial
+	if b != nil {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	if old := m.state.Swap(&s); old.Enabled != s.Enabled {
+		if s.Enabled {
+			log.Printf("🔧 Maintenance mode on")
+		} else {
+			log.Printf("🔧 Maintenance mode off")
+		}
+	}
+	return nil
+}
+
+// set overrides the state, or with s nil goes back to -maintenance.
+func (m *maintenance) set(ctx context.Context, s *maintenanceState) error {
+	var err error
+	if s == nil {
+		err = m.store.Delete(ctx, maintenanceKey)
+	} else {
+		b, _ := json.Marshal(s)
+		err = m.store.Set(ctx, maintenanceKey, b, 0)
+	}
+	if err != nil {
+		return err
+	}
+	return m.refresh(ctx)
+}
+
+// withMaintenance answers 503 with Retry-After for the game's pages and
+// API while in maintenance mode. Health probes, metrics, the admin
+// dashboard and API, and the build's other files still get through, so the
+// maintenance page can use the game's images and fonts. dist, nil in -dev,
+// may provide maintenance.html.
+func withMaintenance(m *maintenance, dist buildHandler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.current()
+		if !s.Enabled || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Retry-After", strconv.Itoa(s.RetryAfter))
+		h.Set("Cache-Control", "no-store")
+		msg := s.Message
+		if msg == "" {
+			msg = "down for maintenance"
+		}
+		p := r.URL.Path
+		if strings.HasPrefix(p, "/api/") || p == "/ws" || strings.HasPrefix(p, "/auth/") || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusServiceUnavailable, msg)
+			return
+		}
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		if dist != nil {
+			fsys, _ := dist.served()
+			if page, err := fs.ReadFile(fsys, maintenancePage); err == nil {
+				h.Set("Content-Length", strconv.Itoa(len(page)))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				if r.Method != http.MethodHead {
+					w.Write(page)
+				}
+				return
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		maintenanceTemplate.Execute(w, map[string]any{"Message": s.Message, "RetryAfter": s.RetryAfter})
+	})
+}
+
+// maintenanceExempt reports whether p is served as usual in maintenance
+// mode.
+func maintenanceExempt(p string) bool {
+	switch {
+	case p == "/healthz" || p == "/readyz" || p == "/metrics":
+		return true
+	case p == "/admin" || strings.HasPrefix(p, "/admin/") || strings.HasPrefix(p, "/api/admin/") || strings.HasPrefix(p, "/debug/"):
+		return true
+	case strings.HasPrefix(p, "/api/") || p == "/ws" || strings.HasPrefix(p, "/auth/"):
+		return false
+	}
+	ext := path.Ext(p)
+	return ext != "" && ext != ".html"
+}
+
+var maintenanceTemplate = template.Must(template.New("maintenance").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+{{if .RetryAfter}}<meta http-equiv="refresh" content="{{.RetryAfter}}">{{end}}
+<title>Lode Runner 2099 · Maintenance</title>
+<style>
+body { margin: 0; min-height: 100vh; display: grid; place-items: center; background: #0b0e1a; color: #e8ecff; font: 16px/1.4 system-ui, sans-serif; text-align: center; }
+main { width: min(28rem, 90vw); }
+</style>
+</head>
+<body>
+<main>
+<h1>Back soon</h1>
+<p>{{if .Message}}{{.Message}}{{else}}Lode Runner 2099 is down for maintenance. Your scores and saves are safe.{{end}}</p>
+</main>
+</body>
+</html>
+`))
+
+// showMaintenance is GET /api/admin/maintenance.
+func (a *adminAPI) showMaintenance(w http.ResponseWriter, r *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, a.maintenance.current())
+}
+
+// setMaintenance is PUT /api/admin/maintenance with {"enabled": true}, and
+// optionally "retry_after" in seconds and a "message" for players.
+func (a *adminAPI) setMaintenance(w http.ResponseWriter, r *http.Request, actor string) {
+	body := a.maintenance.initial
+	body.Message = ""
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	body.Message = strings.TrimSpace(body.Message)
+	if body.RetryAfter < 0 || len(body.Message) > maxReasonLength {
+		writeError(w, http.StatusUnprocessableEntity, "retry_after must not be negative and message must be at most 500 bytes")
+		return
+	}
+	before := a.maintenance.current()
+	if err := a.maintenance.set(r.Context(), &body); err != nil {
+		a.fail(w, "set maintenance", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{
+		Actor: actor, Action: "maintenance.set", TargetKind: "maintenance", TargetID: strconv.FormatBool(body.Enabled), Detail: body.Message,
+		Before: auditState(before), After: auditState(a.maintenance.current()),
+	})
+}
+
+// resetMaintenance is DELETE /api/admin/maintenance, going back to
+// -maintenance.
+func (a *adminAPI) resetMaintenance(w http.ResponseWriter, r *http.Request, actor string) {
+	before := a.maintenance.current()
+	if err := a.maintenance.set(r.Context(), nil); err != nil {
+		a.fail(w, "reset maintenance", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{
+		Actor: actor, Action: "maintenance.reset", TargetKind: "maintenance", TargetID: strconv.FormatBool(a.maintenance.initial.Enabled),
+		Before: auditState(before), After: auditState(a.maintenance.current()),
+	})
+}
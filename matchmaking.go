@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/matchmaking"
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+)
+
+const (
+	maxRating = 5000
+
+	// matchRoomTTL is how long matched players have to connect to /ws and
+	// redeem their room token.
+	matchRoomTTL = 2 * time.Minute
+
+	// matchPollInterval is the status polling interval suggested to
+	// clients; a ticket that isn't polled for 30s is dropped.
+	matchPollInterval = 2 * time.Second
+)
+
+var regionPattern = regexp.MustCompile(`^[a-z0-9-]{1,16}$`)
+
+type matchRequest struct {
+	Name   string `json:"name"`
+	Rating *int   `json:"rating"`
+	Region string `json:"region"`
+}
+
+func (m *matchRequest) normalize() error {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Region = strings.ToLower(strings.TrimSpace(m.Region))
+	if err := checkText("name", m.Name, maxPlayerName, true); err != nil {
+		return err
+	}
+	if m.Rating == nil || *m.Rating < 0 || *m.Rating > maxRating {
+		return errors.New("rating must be between 0 and 5000")
+	}
+	if m.Region == "" {
+		m.Region = "global"
+	}
+	if !regionPattern.MatchString(m.Region) {
+		return errors.New("region must be 1-16 lowercase letters, digits or dashes")
+	}
+	return nil
+}
+
+// matchmakingAPI serves /api/matchmaking. Clients enqueue, poll their
+// ticket until it is matched, then join the lobby room with the room token.
+type matchmakingAPI struct {
+	queue  *matchmaking.Queue
+	filter *contentFilter
+}
+
+func newMatchmakingAPI(store ephemeral.Store, hub *lobby.Hub, filter *contentFilter) *matchmakingAPI {
+	return &matchmakingAPI{filter: filter, queue: matchmaking.New(store, func(ctx context.Context, seats int) (string, []string, error) {
+		return hub.Reserve(ctx, seats, matchRoomTTL)
+	})}
+}
+
+func (a *matchmakingAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/matchmaking", a.enqueue)
+	mux.HandleFunc("GET /api/matchmaking/{ticket}", a.status)
+	mux.HandleFunc("DELETE /api/matchmaking/{ticket}", a.cancel)
+}
+
+func (a *matchmakingAPI) enqueue(w http.ResponseWriter, r *http.Request) {
+	var req matchRequest
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := req.normalize(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if !a.filter.allow(w, r, "name", moderation.Name, req.Name) {
+		return
+	}
+	st, err := a.queue.Enqueue(r.Context(), req.Name, *req.Rating, req.Region)
+	if err != nil {
+		a.fail(w, "enqueue", err)
+		return
+	}
+	writeMatchStatus(w, http.StatusAccepted, st)
+}
+
+func (a *matchmakingAPI) status(w http.ResponseWriter, r *http.Request) {
+	st, err := a.queue.Status(r.Context(), r.PathValue("ticket"))
+	if errors.Is(err, matchmaking.ErrNoTicket) {
+		writeError(w, http.StatusNotFound, "ticket not found or expired")
+		return
+	}
+	if err != nil {
+		a.fail(w, "status", err)
+		return
+	}
+	writeMatchStatus(w, http.StatusOK, st)
+}
+
+func (a *matchmakingAPI) cancel(w http.ResponseWriter, r *http.Request) {
+	err := a.queue.Cancel(r.Context(), r.PathValue("ticket"))
+	if errors.Is(err, matchmaking.ErrNoTicket) {
+		writeError(w, http.StatusNotFound, "ticket not found or expired")
+		return
+	}
+	if err != nil {
+		a.fail(w, "cancel", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *matchmakingAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("matchmaking: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+func writeMatchStatus(w http.ResponseWriter, code int, st matchmaking.Status) {
+	body := map[string]any{
+		"ticket": st.Ticket,
+		"state":  st.State,
+		"waited": int(st.Waited.Seconds()),
+	}
+	if st.State == matchmaking.Matched {
+		body["room"] = st.Room
+		body["room_token"] = st.RoomToken
+		body["opponent"] = st.Opponent
+	} else {
+		body["position"] = st.Position
+		body["estimated_wait"] = int(st.EstimatedWait.Seconds())
+		body["tolerance"] = st.Tolerance
+		body["poll_after"] = int(matchPollInterval.Seconds())
+	}
+	writeJSON(w, code, body)
+}
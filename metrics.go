@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal Prometheus registry. The server only needs counters, gauges and
+// histograms in the text exposition format, which doesn't justify pulling in
+// client_golang and its dependency tree.
+
+var defaultRegistry = &registry{}
+
+var (
+	httpRequests = defaultRegistry.counter("loderunner_http_requests_total",
+		"HTTP requests by route and status code.", "route", "code")
+	httpDuration = defaultRegistry.histogram("loderunner_http_request_duration_seconds",
+		"HTTP request latency by route.", defaultBuckets, "route")
+	httpInFlight = defaultRegistry.gauge("loderunner_http_requests_in_flight",
+		"HTTP requests currently being served.")
+	httpBytes = defaultRegistry.counter("loderunner_http_response_bytes_total",
+		"Response body bytes written to clients, after compression.", "route")
+	httpCachePolicy = defaultRegistry.counter("loderunner_http_cache_policy_total",
+		"Responses by Cache-Control category.", "policy")
+)
+
+var processStart = time.Now()
+
+func init() {
+	defaultRegistry.gaugeFunc("loderunner_process_start_time_seconds",
+		"Unix time the server started.", func() float64 { return float64(processStart.Unix()) })
+	defaultRegistry.gaugeFunc("loderunner_goroutines",
+		"Number of running goroutines.", func() float64 { return float64(runtime.NumGoroutine()) })
+}
+
+var defaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func (r *registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// ServeHTTP writes all registered metrics in the Prometheus text format.
+func (r *registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.writeTo(w)
+	}
+}
+
+// metricVec holds one value per label combination.
+type metricVec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *registry) counter(name, help string, labels ...string) *metricVec {
+	return r.newVec(name, help, "counter", labels)
+}
+
+func (r *registry) gauge(name, help string, labels ...string) *metricVec {
+	return r.newVec(name, help, "gauge", labels)
+}
+
+func (r *registry) newVec(name, help, kind string, labels []string) *metricVec {
+	v := &metricVec{name: name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	r.register(v)
+	return v
+}
+
+func (v *metricVec) inc(labelValues ...string) { v.add(1, labelValues...) }
+
+func (v *metricVec) add(delta float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	v.values[key] += delta
+	v.mu.Unlock()
+}
+
+func (v *metricVec) set(val float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	v.values[key] = val
+	v.mu.Unlock()
+}
+
+// snapshot returns the current value of every series, keyed by its label
+// values.
+func (v *metricVec) snapshot() map[string]float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make(map[string]float64, len(v.values))
+	for key, val := range v.values {
+		out[key] = val
+	}
+	return out
+}
+
+func (v *metricVec) writeTo(w io.Writer) {
+	writeHeader(w, v.name, v.help, v.kind)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, splitKey(key), "", ""), formatFloat(v.values[key]))
+	}
+}
+
+// gaugeFunc is a gauge whose value is computed at scrape time.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (r *registry) gaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *registry) histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+func (h *histogramVec) observe(val float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if val <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += val
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s, values := h.series[key], splitKey(key)
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(le)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values, "", ""), s.count)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+const labelSep = "\xff"
+
+func labelKey(values []string) string { return strings.Join(values, labelSep) }
+
+func splitKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, labelSep)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		if i < len(values) {
+			pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// instrument records request metrics. Routes are labelled by the ServeMux
+// pattern that handles them rather than the raw path, which keeps label
+// cardinality bounded no matter what URLs clients send.
+func instrument(mux router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(mux, r)
+		start := time.Now()
+		httpInFlight.add(1)
+		defer httpInFlight.add(-1)
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		httpRequests.inc(route, strconv.Itoa(sw.statusCode()))
+		httpDuration.observe(time.Since(start).Seconds(), route)
+		httpBytes.add(float64(sw.bytes), route)
+		httpCachePolicy.inc(cacheCategory(w.Header().Get("Cache-Control")))
+	})
+}
+
+// router is an http.ServeMux, or anything else that can say which pattern
+// a request matches.
+type router interface {
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+func routeLabel(mux router, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	switch pattern {
+	case "":
+		return "unmatched"
+	case "/":
+		return "static"
+	}
+	return pattern
+}
+
+// cacheCategory buckets a Cache-Control value into a handful of policies.
+func cacheCategory(cc string) string {
+	switch {
+	case cc == "":
+		return "none"
+	case strings.Contains(cc, "no-store"), strings.Contains(cc, "no-cache"):
+		return "no-cache"
+	case strings.Contains(cc, "immutable"):
+		return "immutable"
+	case strings.Contains(cc, "max-age"):
+		return "max-age"
+	}
+	return "other"
+}
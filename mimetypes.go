@@ -0,0 +1,45 @@
+package main
+
+import "mime"
+
+// gameContentTypes pins the content types of extensions the game ships.
+// Many platforms' mime.types files lack or disagree on these, and a wrong
+// type breaks things outright: WebAssembly.instantiateStreaming rejects
+// anything but application/wasm, and module scripts need a JavaScript type.
+var gameContentTypes = map[string]string{
+	".html":        "text/html; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".data":        "application/octet-stream",
+	".glb":         "model/gltf-binary",
+	".gltf":        "model/gltf+json",
+	".svg":         "image/svg+xml",
+	".png":         "image/png",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".ico":         "image/x-icon",
+	".ogg":         "audio/ogg",
+	".oga":         "audio/ogg",
+	".mp3":         "audio/mpeg",
+	".wav":         "audio/wav",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain; charset=utf-8",
+	".xml":         "application/xml",
+}
+
+// Registering the table with the mime package overrides whatever the OS
+// provides, for http.ServeContent and our own lookups alike.
+func init() {
+	for ext, ctype := range gameContentTypes {
+		if err := mime.AddExtensionType(ext, ctype); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// oauthCookie holds the state, PKCE verifier and return path between the
+// redirect to the provider and the callback.
+const (
+	oauthCookie    = "lr_oauth"
+	oauthCookieTTL = 10 * time.Minute
+	oauthTimeout   = 10 * time.Second
+)
+
+// oauthIdentity is who the provider says the player is.
+type oauthIdentity struct {
+	Subject string // stable provider user ID
+	Login   string // suggested username
+	Name    string // suggested display name
+}
+
+// oauthProvider is an OAuth2 provider players can sign in with.
+type oauthProvider struct {
+	config   oauth2.Config
+	userURL  string
+	identity func(body []byte) (oauthIdentity, error)
+}
+
+// newOAuthProviders returns the providers that have client credentials
+// configured, keyed by the name used in /auth/{provider}.
+func newOAuthProviders(cfg config) map[string]*oauthProvider {
+	providers := make(map[string]*oauthProvider)
+	if cfg.GitHubClientID != "" {
+		providers["github"] = &oauthProvider{
+			config: oauth2.Config{
+				ClientID:     cfg.GitHubClientID,
+				ClientSecret: cfg.GitHubClientSecret,
+				Endpoint:     endpoints.GitHub,
+				Scopes:       []string{"read:user"},
+			},
+			userURL: "https://api.github.com/user",
+			identity: func(body []byte) (oauthIdentity, error) {
+				var u struct {
+					ID    int64  `json:"id"`
+					Login string `json:"login"`
+					Name  string `json:"name"`
+				}
+				err := json.Unmarshal(body, &u)
+				return oauthIdentity{Subject: strconv.FormatInt(u.ID, 10), Login: u.Login, Name: u.Name}, err
+			},
+		}
+	}
+	if cfg.GoogleClientID != "" {
+		providers["google"] = &oauthProvider{
+			config: oauth2.Config{
+				ClientID:     cfg.GoogleClientID,
+				ClientSecret: cfg.GoogleClientSecret,
+				Endpoint:     endpoints.Google,
+				Scopes:       []string{"openid", "profile"},
+			},
+			userURL: "https://openidconnect.googleapis.com/v1/userinfo",
+			identity: func(body []byte) (oauthIdentity, error) {
+				var u struct {
+					Sub       string `json:"sub"`
+					Name      string `json:"name"`
+					GivenName string `json:"given_name"`
+				}
+				err := json.Unmarshal(body, &u)
+				return oauthIdentity{Subject: u.Sub, Login: u.GivenName, Name: u.Name}, err
+			},
+		}
+	}
+	if cfg.DiscordClientID != "" {
+		providers["discord"] = &oauthProvider{
+			config: oauth2.Config{
+				ClientID:     cfg.DiscordClientID,
+				ClientSecret: cfg.DiscordClientSecret,
+				Endpoint:     endpoints.Discord,
+				Scopes:       []string{"identify"},
+			},
+			userURL: "https://discord.com/api/users/@me",
+			identity: func(body []byte) (oauthIdentity, error) {
+				var u struct {
+					ID         string `json:"id"`
+					Username   string `json:"username"`
+					GlobalName string `json:"global_name"`
+				}
+				err := json.Unmarshal(body, &u)
+				return oauthIdentity{Subject: u.ID, Login: u.Username, Name: u.GlobalName}, err
+			},
+		}
+	}
+	return providers
+}
+
+// oauthAPI serves the browser-facing OAuth2 flows under /auth/ and the
+// provider list under /api/auth. Signing in creates an account on first
+// use; a player who is already signed in links the provider to their
+// account instead.
+type oauthAPI struct {
+	db        *storage.DB
+	providers map[string]*oauthProvider
+	publicURL string
+	proxies   trustedProxies
+}
+
+func (a *oauthAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/auth/providers", a.list)
+	mux.HandleFunc("GET /auth/{provider}", a.start)
+	mux.HandleFunc("GET /auth/{provider}/callback", a.callback)
+}
+
+func (a *oauthAPI) list(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(a.providers))
+	for name := range a.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	writeJSON(w, http.StatusOK, map[string]any{"providers": names})
+}
+
+// start redirects to the provider's consent page. ?return= is the local
+// path to come back to afterwards.
+func (a *oauthAPI) start(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	p, ok := a.providers[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	state := randomToken(24)
+	verifier := oauth2.GenerateVerifier()
+	http.SetCookie(w, &http.Cookie{
+		Name: oauthCookie,
+		Value: url.Values{
+			"state":    {state},
+			"verifier": {verifier},
+			"return":   {localPath(r.URL.Query().Get("return"))},
+		}.Encode(),
+		Path:     "/auth/",
+		MaxAge:   int(oauthCookieTTL / time.Second),
+		HttpOnly: true,
+		Secure:   a.proxies.scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	cfg := a.configFor(r, name, p)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, cfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// callback completes the flow and sends the player back to the game. On
+// failure the return path gets an auth_error query parameter the client
+// can show: denied, state, provider, linked or internal.
+func (a *oauthAPI) callback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	p, ok := a.providers[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c, err := r.Cookie(oauthCookie)
+	if err != nil {
+		a.finish(w, r, "/", "state")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthCookie, Path: "/auth/", MaxAge: -1, HttpOnly: true, Secure: a.proxies.scheme(r) == "https"})
+	saved, _ := url.ParseQuery(c.Value)
+	returnTo := localPath(saved.Get("return"))
+	q := r.URL.Query()
+	if q.Get("error") != "" {
+		a.finish(w, r, returnTo, "denied")
+		return
+	}
+	state := saved.Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		a.finish(w, r, returnTo, "state")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oauthTimeout)
+	defer cancel()
+	id, err := a.fetchIdentity(ctx, a.configFor(r, name, p), p, q.Get("code"), saved.Get("verifier"))
+	if err != nil {
+		log.Printf("oauth: %s: %v", name, err)
+		a.finish(w, r, returnTo, "provider")
+		return
+	}
+
+	if current := currentPlayer(r); current != nil {
+		err := a.db.LinkIdentity(r.Context(), name, id.Subject, current.ID)
+		switch {
+		case errors.Is(err, storage.ErrIdentityLinked):
+			a.finish(w, r, returnTo, "linked")
+		case err != nil:
+			log.Printf("oauth: link identity: %v", err)
+			a.finish(w, r, returnTo, "internal")
+		default:
+			auditAccount(r, a.db, playerAudit(current.ID, "account.link", name))
+			a.finish(w, r, returnTo, "")
+		}
+		return
+	}
+	action := "account.login"
+	player, err := a.db.PlayerByIdentity(r.Context(), name, id.Subject)
+	if errors.Is(err, storage.ErrNotFound) {
+		action = "account.register"
+		player, err = a.db.CreateIdentityPlayer(r.Context(), name, id.Subject, usernameCandidates(id.Login), displayName(id))
+	}
+	if err == nil {
+		_, _, err = startSession(w, r, a.db, a.proxies, player)
+	}
+	if err != nil {
+		log.Printf("oauth: sign in: %v", err)
+		a.finish(w, r, returnTo, "internal")
+		return
+	}
+	auditAccount(r, a.db, playerAudit(player.ID, action, name))
+	a.finish(w, r, returnTo, "")
+}
+
+// fetchIdentity exchanges the authorization code and asks the provider who
+// the player is.
+func (a *oauthAPI) fetchIdentity(ctx context.Context, cfg *oauth2.Config, p *oauthProvider, code, verifier string) (oauthIdentity, error) {
+	tok, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return oauthIdentity{}, fmt.Errorf("exchange code: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.userURL, nil)
+	if err != nil {
+		return oauthIdentity{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := cfg.Client(ctx, tok).Do(req)
+	if err != nil {
+		return oauthIdentity{}, fmt.Errorf("get user: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return oauthIdentity{}, fmt.Errorf("get user: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return oauthIdentity{}, fmt.Errorf("get user: %s", resp.Status)
+	}
+	id, err := p.identity(body)
+	if err == nil && id.Subject == "" {
+		err = errors.New("no user ID in response")
+	}
+	return id, err
+}
+
+// configFor returns the provider config with the callback URL for this
+// server, which must match the one registered with the provider.
+func (a *oauthAPI) configFor(r *http.Request, name string, p *oauthProvider) *oauth2.Config {
+	base := a.publicURL
+	if base == "" {
+		base = a.proxies.scheme(r) + "://" + r.Host
+	}
+	cfg := p.config
+	cfg.RedirectURL = strings.TrimSuffix(base, "/") + "/auth/" + name + "/callback"
+	return &cfg
+}
+
+func (a *oauthAPI) finish(w http.ResponseWriter, r *http.Request, returnTo, authError string) {
+	if authError != "" {
+		u, _ := url.Parse(returnTo)
+		q := u.Query()
+		q.Set("auth_error", authError)
+		u.RawQuery = q.Encode()
+		returnTo = u.String()
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// localPath returns p if it is a path on this site, otherwise "/", so the
+// return parameter can't be used as an open redirect.
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	if u, err := url.Parse(p); err != nil || u.Host != "" || u.Scheme != "" {
+		return "/"
+	}
+	return p
+}
+
+// usernameCandidates derives usernames from a provider login: the login
+// itself if it fits the username rules, then a few with random suffixes.
+func usernameCandidates(login string) []string {
+	base := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-') {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, login)
+	if len(base) < 3 {
+		base = "player"
+	}
+	base = base[:min(len(base), 16)]
+	short := base[:min(len(base), 11)]
+	names := []string{base}
+	for range 5 {
+		names = append(names, fmt.Sprintf("%s-%04d", short, rand.IntN(10000)))
+	}
+	return names
+}
+
+// displayName picks a display name that passes the same rules as score
+// names, so signed-in players can always submit scores.
+func displayName(id oauthIdentity) string {
+	for _, name := range []string{id.Name, id.Login} {
+		name = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, name))
+		if runes := []rune(name); len(runes) > 0 {
+			return string(runes[:min(len(runes), maxPlayerName)])
+		}
+	}
+	return "Player"
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	s3creds "github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/sync/singleflight"
+)
+
+var originDownloads = defaultRegistry.counter("loderunner_origin_downloads_total",
+	"Files downloaded from the -origin bucket into the local cache, by result.", "result")
+
+// originFetchTimeout bounds a single download from the bucket.
+const originFetchTimeout = 5 * time.Minute
+
+// originTempAge is how old a partial download must be before a refresh
+// cleans it up; younger ones may still be in progress.
+const originTempAge = time.Hour
+
+// originFS is an fs.FS over a bucket prefix holding the built game. The
+// listing is kept in memory and refreshed periodically, so Stat and
+// directory walks never touch the network. Files are downloaded into a
+// cache directory on first open, named after their ETag, so a restart or a
+// redeploy only fetches what changed.
+type originFS struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string // with a trailing slash unless empty
+	cacheDir string
+	name     string // s3://bucket/prefix, for the logs
+
+	mu    sync.RWMutex
+	files map[string]originObject
+	dirs  map[string][]fs.DirEntry
+
+	fetches singleflight.Group
+}
+
+type originObject struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// openOrigin connects to cfg.Origin and lists it.
+func openOrigin(ctx context.Context, cfg config) (*originFS, error) {
+	u, err := url.Parse(cfg.Origin)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newBucketClient(u, cfg.OriginEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("origin: %w", err)
+	}
+	if err := os.MkdirAll(cfg.OriginCache, 0o755); err != nil {
+		return nil, fmt.Errorf("origin: %w", err)
+	}
+	o := &originFS{
+		client:   client,
+		bucket:   u.Host,
+		cacheDir: cfg.OriginCache,
+		name:     cfg.Origin,
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		o.prefix = p + "/"
+	}
+	if _, err := o.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newBucketClient returns a client for the bucket u names, s3://bucket or
+// gs://bucket, at endpoint if that is set. Credentials come from the
+// usual AWS environment variables, credentials file or instance role.
+func newBucketClient(u *url.URL, endpoint string) (*minio.Client, error) {
+	host, secure := "s3.amazonaws.com", true
+	if u.Scheme == "gs" {
+		// Cloud Storage speaks the S3 API to HMAC keys.
+		host = "storage.googleapis.com"
+	}
+	if endpoint != "" {
+		e, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		host, secure = e.Host, e.Scheme == "https"
+	}
+	return minio.New(host, &minio.Options{
+		Creds: s3creds.NewChainCredentials([]s3creds.Provider{
+			&s3creds.EnvAWS{},
+			&s3creds.FileAWSCredentials{},
+			&s3creds.IAM{},
+		}),
+		Secure: secure,
+		Region: os.Getenv("AWS_REGION"),
+	})
+}
+
+// listOrigin is openOrigin for the startup of serve and check, with a
+// bound on how long the first listing may take.
+func listOrigin(cfg config) (*originFS, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return openOrigin(ctx, cfg)
+}
+
+// defaultOriginCache is where -origin downloads go unless -origin-cache
+// says otherwise.
+func defaultOriginCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "loderunner2099", "origin")
+}
+
+// run lists the bucket every interval until ctx is done, so a new build
+// uploaded there is served without a restart. A failed listing keeps the
+// files listed before.
+func (o *originFS) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := o.refresh(ctx)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return
+		case err != nil:
+			if !failing {
+				log.Printf("⚠️  %v; serving the files listed before until it lists again", err)
+			}
+			failing = true
+			continue
+		case failing:
+			log.Printf("🪣 Listing %s again", o.name)
+		}
+		failing = false
+		if changed > 0 {
+			log.Printf("🪣 %d files changed in %s", changed, o.name)
+		}
+	}
+}
+
+// refresh replaces the listing and returns how many files were added,
+// changed or removed. Cached downloads of files no longer listed are
+// deleted.
+func (o *originFS) refresh(ctx context.Context) (int, error) {
+	files := map[string]originObject{}
+	for obj := range o.client.ListObjects(ctx, o.bucket, minio.ListObjectsOptions{Prefix: o.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return 0, fmt.Errorf("origin: listing %s: %w", o.name, obj.Err)
+		}
+		name := strings.TrimPrefix(obj.Key, o.prefix)
+		if !fs.ValidPath(name) || name == "." {
+			continue // folder placeholders and keys no path can reach
+		}
+		files[name] = originObject{size: obj.Size, modTime: obj.LastModified, etag: obj.ETag}
+	}
+	dirs := map[string][]fs.DirEntry{".": nil}
+	var addDir func(dir string)
+	addDir = func(dir string) {
+		if _, ok := dirs[dir]; ok {
+			return
+		}
+		dirs[dir] = nil
+		parent := path.Dir(dir)
+		addDir(parent)
+		dirs[parent] = append(dirs[parent], fs.FileInfoToDirEntry(originDirInfo(dir)))
+	}
+	for name, obj := range files {
+		dir := path.Dir(name)
+		addDir(dir)
+		dirs[dir] = append(dirs[dir], fs.FileInfoToDirEntry(obj.info(name)))
+	}
+	for _, entries := range dirs {
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	}
+
+	o.mu.Lock()
+	changed := 0
+	for name, obj := range files {
+		if old, ok := o.files[name]; !ok || old.etag != obj.etag {
+			changed++
+		}
+	}
+	for name := range o.files {
+		if _, ok := files[name]; !ok {
+			changed++
+		}
+	}
+	o.files, o.dirs = files, dirs
+	o.mu.Unlock()
+
+	o.prune(files)
+	return changed, nil
+}
+
+// prune deletes the downloads and stale partial downloads in the cache
+// directory that files doesn't need.
+func (o *originFS) prune(files map[string]originObject) {
+	keep := make(map[string]bool, len(files))
+	for name, obj := range files {
+		keep[o.cacheName(name, obj)] = true
+	}
+	entries, err := os.ReadDir(o.cacheDir)
+	if err != nil {
+		log.Printf("origin: %v", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case keep[name]:
+			continue
+		case strings.HasPrefix(name, "tmp-"):
+			if fi, err := e.Info(); err != nil || time.Since(fi.ModTime()) < originTempAge {
+				continue
+			}
+		case !isCacheName(name):
+			continue // not ours
+		}
+		os.Remove(filepath.Join(o.cacheDir, name))
+	}
+}
+
+// cacheName is the file in the cache directory holding this version of
+// name.
+func (o *originFS) cacheName(name string, obj originObject) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + obj.etag))
+	return hex.EncodeToString(sum[:])
+}
+
+func isCacheName(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// fetch returns the path of name's download, downloading it unless it is
+// cached already.
+func (o *originFS) fetch(name string, obj originObject) (string, error) {
+	file := filepath.Join(o.cacheDir, o.cacheName(name, obj))
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	_, err, _ := o.fetches.Do(file, func() (any, error) {
+		err := o.download(o.prefix+name, obj, file)
+		if err != nil {
+			originDownloads.inc("error")
+			return nil, fmt.Errorf("origin: downloading %s: %w", name, err)
+		}
+		originDownloads.inc("ok")
+		return nil, nil
+	})
+	return file, err
+}
+
+// download copies key into file, provided it is still the version listed.
+func (o *originFS) download(key string, obj originObject, file string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), originFetchTimeout)
+	defer cancel()
+	var opts minio.GetObjectOptions
+	if err := opts.SetMatchETag(obj.etag); err != nil {
+		return err
+	}
+	r, err := o.client.GetObject(ctx, o.bucket, key, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(o.cacheDir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != obj.size {
+		return fmt.Errorf("got %d bytes of %d", n, obj.size)
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (o *originFS) lookup(name string) (originObject, []fs.DirEntry, bool, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	obj, isFile := o.files[name]
+	entries, isDir := o.dirs[name]
+	return obj, entries, isFile, isDir
+}
+
+// Open returns the cached download of name, fetching it first on a miss.
+func (o *originFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	obj, entries, isFile, isDir := o.lookup(name)
+	switch {
+	case isDir:
+		return &originDir{info: originDirInfo(name), entries: entries}, nil
+	case !isFile:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file, err := o.fetch(name, obj)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &originFile{File: f, info: obj.info(name)}, nil
+}
+
+// Stat answers from the listing.
+func (o *originFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	obj, _, isFile, isDir := o.lookup(name)
+	switch {
+	case isDir:
+		return originDirInfo(name), nil
+	case !isFile:
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return obj.info(name), nil
+}
+
+// ReadDir answers from the listing, so walking the build downloads
+// nothing.
+func (o *originFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	_, entries, _, isDir := o.lookup(name)
+	if !isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return slices.Clone(entries), nil
+}
+
+// info describes name as listed, which is what the ETag manifest and the
+// precompressed siblings compare against to notice a new build.
+func (obj originObject) info(name string) fs.FileInfo {
+	return memFileInfo{name: path.Base(name), size: obj.size, modTime: obj.modTime}
+}
+
+// originFile is a download, described as the bucket listed it.
+type originFile struct {
+	*os.File
+	info fs.FileInfo
+}
+
+func (f *originFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// originDirInfo describes a directory implied by the keys under it.
+type originDirInfo string
+
+func (d originDirInfo) Name() string       { return path.Base(string(d)) }
+func (d originDirInfo) Size() int64        { return 0 }
+func (d originDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d originDirInfo) ModTime() time.Time { return time.Time{} }
+func (d originDirInfo) IsDir() bool        { return true }
+func (d originDirInfo) Sys() any           { return nil }
+
+type originDir struct {
+	info    originDirInfo
+	entries []fs.DirEntry
+}
+
+func (d *originDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *originDir) Close() error               { return nil }
+
+func (d *originDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: string(d.info), Err: errors.New("is a directory")}
+}
+
+func (d *originDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
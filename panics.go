@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var panicsTotal = defaultRegistry.counter("loderunner_http_panics_total",
+	"Handler panics recovered, by route.", "route")
+
+// errorQueue is how many reports may wait to be sent; more are dropped so
+// a panic in a hot path can't pile up goroutines or memory.
+const errorQueue = 32
+
+// panicReport is one recovered panic and the request it happened in.
+type panicReport struct {
+	ID        string
+	RequestID string
+	Time      time.Time
+	Value     string
+	Stack     []runtime.Frame
+	Method    string
+	URL       string
+	Route     string
+	Headers   http.Header
+}
+
+// errorSink receives panic reports: Sentry, or any URL that accepts JSON.
+type errorSink interface {
+	send(ctx context.Context, rep panicReport) error
+	String() string
+}
+
+// errorReporter sends panic reports to the configured sinks in the
+// background.
+type errorReporter struct {
+	sinks []errorSink
+	queue chan panicReport
+	done  chan struct{}
+}
+
+// newErrorReporter returns nil when neither -sentry-dsn nor
+// -error-webhook is set; panics are then only logged.
+func newErrorReporter(cfg config, build buildInfo) (*errorReporter, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var sinks []errorSink
+	if cfg.SentryDSN != "" {
+		s, err := newSentrySink(cfg.SentryDSN, build, client)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.ErrorWebhook != "" {
+		sinks = append(sinks, &webhookSink{url: cfg.ErrorWebhook, build: build, client: client})
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return &errorReporter{sinks: sinks, queue: make(chan panicReport, errorQueue), done: make(chan struct{})}, nil
+}
+
+func (e *errorReporter) run() {
+	defer close(e.done)
+	for rep := range e.queue {
+		for _, s := range e.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			if err := s.send(ctx, rep); err != nil {
+				log.Printf("error report %s to %s: %v", rep.ID, s, err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (e *errorReporter) report(rep panicReport) {
+	select {
+	case e.queue <- rep:
+	default:
+		log.Printf("⚠️  Error report queue full, dropping report %s", rep.ID)
+	}
+}
+
+// close sends the reports still queued, waiting until ctx is done.
+func (e *errorReporter) close(ctx context.Context) {
+	close(e.queue)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+// recoverPanics turns a handler panic into a 500 and a report, instead of
+// net/http's dropped connection and a stack trace on stderr. A panic after
+// the response has started can only abort the connection.
+func recoverPanics(mux router, reporter *errorReporter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // a deliberate abort, e.g. by the reverse proxy
+			}
+			rep := panicReport{
+				ID:        newEventID(),
+				RequestID: requestID(w),
+				Time:      time.Now().UTC(),
+				Value:     fmt.Sprint(v),
+				Stack:     panicStack(),
+				Method:    r.Method,
+				URL:       requestURL(r),
+				Route:     routeLabel(mux, r),
+				Headers:   reportableHeaders(r.Header),
+			}
+			panicsTotal.inc(rep.Route)
+			log.Printf("panic serving %s %s (report %s, request %s): %s\n%s", r.Method, r.URL.Path, rep.ID, rep.RequestID, rep.Value, formatStack(rep.Stack))
+			if reporter != nil {
+				reporter.report(rep)
+			}
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			// The ID lets whoever hit it quote it in a bug report.
+			writeError(w, http.StatusInternalServerError, "internal server error (report "+rep.ID+")")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// panicStack returns the panicking goroutine's frames, innermost first,
+// without the runtime's and recoverPanics' own.
+func panicStack() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []runtime.Frame
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			stack = append(stack, f)
+		}
+		if !more {
+			return stack
+		}
+	}
+}
+
+func formatStack(stack []runtime.Frame) string {
+	var b strings.Builder
+	for _, f := range stack {
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+	}
+	return b.String()
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}).String()
+}
+
+// reportableHeaders drops credentials before headers leave the server.
+func reportableHeaders(h http.Header) http.Header {
+	out := http.Header{}
+	for k, v := range h {
+		lk := strings.ToLower(k)
+		if lk == "cookie" || lk == "authorization" || strings.Contains(lk, "token") || strings.Contains(lk, "secret") {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// sentrySink posts events to Sentry's envelope endpoint, which any
+// Sentry-compatible service (GlitchTip, self-hosted Sentry) accepts.
+type sentrySink struct {
+	endpoint string
+	auth     string
+	dsn      string
+	build    buildInfo
+	client   *http.Client
+}
+
+// newSentrySink parses a DSN of the form https://KEY@HOST/PROJECT.
+func newSentrySink(dsn string, build buildInfo, client *http.Client) (*sentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, errors.New("-sentry-dsn must look like https://KEY@HOST/PROJECT")
+	}
+	// Sentry behind a path prefix puts it before the project ID.
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("-sentry-dsn has no project ID")
+	}
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: prefix + "/api/" + project + "/envelope/"}
+	return &sentrySink{
+		endpoint: endpoint.String(),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=loderunner2099/%s", u.User.Username(), build.Version),
+		dsn:      dsn,
+		build:    build,
+		client:   client,
+	}, nil
+}
+
+func (s *sentrySink) String() string { return "Sentry" }
+
+func (s *sentrySink) send(ctx context.Context, rep panicReport) error {
+	// Sentry lists frames outermost first.
+	type frame struct {
+		Function string `json:"function"`
+		Filename string `json:"filename"`
+		AbsPath  string `json:"abs_path"`
+		Lineno   int    `json:"lineno"`
+		InApp    bool   `json:"in_app"`
+	}
+	frames := make([]frame, 0, len(rep.Stack))
+	for i := len(rep.Stack) - 1; i >= 0; i-- {
+		f := rep.Stack[i]
+		frames = append(frames, frame{
+			Function: f.Function,
+			Filename: f.File[strings.LastIndex(f.File, "/")+1:],
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(f.Function, "main.") || strings.HasPrefix(f.Function, "github.com/jgbrwn/loderunner2099/"),
+		})
+	}
+	host, _ := os.Hostname()
+	event, err := json.Marshal(map[string]any{
+		"event_id":    rep.ID,
+		"timestamp":   rep.Time.Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       "error",
+		"server_name": host,
+		"release":     s.build.Version,
+		"transaction": rep.Route,
+		"exception": map[string]any{"values": []any{map[string]any{
+			"type":       "panic",
+			"value":      rep.Value,
+			"mechanism":  map[string]any{"type": "recover", "handled": false},
+			"stacktrace": map[string]any{"frames": frames},
+		}}},
+		"request": map[string]any{"method": rep.Method, "url": rep.URL, "headers": flatHeaders(rep.Headers)},
+		"tags":    map[string]string{"route": rep.Route, "request_id": rep.RequestID},
+	})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{"event_id": rep.ID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339Nano)})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(event)})
+	body.Write(header)
+	body.WriteByte('\n')
+	body.Write(item)
+	body.WriteByte('\n')
+	body.Write(event)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	return post(s.client, req)
+}
+
+func flatHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// webhookSink posts each report as JSON, for chat integrations or an
+// in-house collector.
+type webhookSink struct {
+	url    string
+	build  buildInfo
+	client *http.Client
+}
+
+func (s *webhookSink) String() string {
+	if u, err := url.Parse(s.url); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+func (s *webhookSink) send(ctx context.Context, rep panicReport) error {
+	host, _ := os.Hostname()
+	body, err := json.Marshal(map[string]any{
+		"id":         rep.ID,
+		"request_id": rep.RequestID,
+		"time":       rep.Time,
+		"message":    "panic: " + rep.Value,
+		"stack":      formatStack(rep.Stack),
+		"method":     rep.Method,
+		"url":        rep.URL,
+		"route":      rep.Route,
+		"host":       host,
+		"version":    s.build.Version,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return post(s.client, req)
+}
+
+func post(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
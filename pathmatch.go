@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// pathPatterns matches request paths against globs. "*" alone matches
+// every path and a trailing "/*" matches a whole subtree; anything else is
+// a path.Match pattern, so "/levels/*" covers /levels/42 but not
+// /levels/42/edit unless written as a subtree.
+type pathPatterns []string
+
+func (ps pathPatterns) match(p string) bool {
+	for _, pattern := range ps {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.HasSuffix(pattern, "/*"):
+			prefix := strings.TrimSuffix(pattern, "*")
+			if strings.HasPrefix(p, prefix) || p+"/" == prefix {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// playTokenSlack forgives a run's reported time for being a little longer
+// than its token's age, for clock skew and the token request's round trip.
+const playTokenSlack = 5 * time.Second
+
+// playTokens issues the signed tokens -score-signing requires of score
+// submissions, and checks them. A client asks for a token when a level
+// starts and signs the finished run's level and score with the key that
+// comes with it. That stops scores made up with curl, not a determined
+// cheat reading the game's code: replays remain the real check.
+type playTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// newPlayTokens signs with a secret generated once and kept in the
+// database, so every instance accepts every instance's tokens.
+func newPlayTokens(db *storage.DB, ttl time.Duration) (*playTokens, error) {
+	secret, err := db.Secret(context.Background(), "play", 32)
+	if err != nil {
+		return nil, err
+	}
+	return &playTokens{secret: secret, ttl: ttl}, nil
+}
+
+func (p *playTokens) mac(purpose, msg string) []byte {
+	m := hmac.New(sha256.New, p.secret)
+	m.Write([]byte(purpose + "\x00" + msg))
+	return m.Sum(nil)
+}
+
+// issue returns a token for a run of level starting at now, and the key
+// to sign its score with. The token is "level.issued.nonce.signature",
+// issued in Unix milliseconds.
+func (p *playTokens) issue(level int, now time.Time) (token, key string) {
+	payload := strconv.Itoa(level) + "." + strconv.FormatInt(now.UnixMilli(), 10) + "." + randomToken(12)
+	token = payload + "." + base64.RawURLEncoding.EncodeToString(p.mac("token", payload)[:18])
+	return token, base64.RawURLEncoding.EncodeToString(p.mac("key", token))
+}
+
+// check verifies sub's token and signature, returning the token's nonce,
+// which may only be used once.
+func (p *playTokens) check(sub scoreSubmission, now time.Time) (string, error) {
+	if sub.PlayToken == "" || sub.Signature == "" {
+		return "", errors.New("play_token and signature are required")
+	}
+	parts := strings.Split(sub.PlayToken, ".")
+	if len(parts) != 4 {
+		return "", errors.New("play_token is invalid")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(sig, p.mac("token", strings.Join(parts[:3], "."))[:18]) {
+		return "", errors.New("play_token is invalid")
+	}
+	if parts[0] != strconv.Itoa(sub.Level) {
+		return "", errors.New("play_token is for another level")
+	}
+	issued, _ := strconv.ParseInt(parts[1], 10, 64)
+	age := now.Sub(time.UnixMilli(issued))
+	if age < 0 || age > p.ttl {
+		return "", errors.New("play_token has expired")
+	}
+	if time.Duration(sub.Time)*time.Millisecond > age+playTokenSlack {
+		return "", errors.New("time is longer than the run's play_token has existed")
+	}
+	want := hmac.New(sha256.New, p.mac("key", sub.PlayToken))
+	want.Write([]byte(sub.PlayToken + "|" + strconv.Itoa(sub.Level) + "|" + strconv.FormatInt(sub.Score, 10)))
+	got, err := hex.DecodeString(sub.Signature)
+	if err != nil || !hmac.Equal(got, want.Sum(nil)) {
+		return "", errors.New("signature does not match")
+	}
+	return parts[2], nil
+}
+
+// playToken starts a run: POST /api/scores/tokens with {"level": N}.
+func (a *scoresAPI) playToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level int `json:"level"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Level < 1 || req.Level > maxLevel {
+		writeError(w, http.StatusUnprocessableEntity, "level must be between 1 and 9999")
+		return
+	}
+	now := time.Now().UTC()
+	token, key := a.tokens.issue(req.Level, now)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":      token,
+		"key":        key,
+		"expires_at": now.Add(a.tokens.ttl).Truncate(time.Millisecond),
+	})
+}
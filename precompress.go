@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// precompressors produce the siblings servePrecompressed looks for, at the
+// slowest and smallest settings since they run once per build.
+var precompressors = []struct {
+	suffix string
+	write  func(w io.Writer) io.WriteCloser
+}{
+	{".br", func(w io.Writer) io.WriteCloser { return brotli.NewWriterLevel(w, brotli.BestCompression) }},
+	{".gz", func(w io.Writer) io.WriteCloser {
+		gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return gz
+	}},
+}
+
+// precompressedFS serves the build with a .br and .gz sibling next to every
+// compressible asset, so requests never pay for compression. A sibling
+// whose source has changed since startup is hidden again, and the
+// response falls back to on-the-fly gzip.
+type precompressedFS struct {
+	fs.FS
+	siblings map[string]*precompressedFile
+}
+
+// precompressedFile is a sibling held in memory, or on disk at path when
+// data is nil.
+type precompressedFile struct {
+	source fs.FileInfo
+	data   []byte
+	path   string
+	info   fs.FileInfo
+}
+
+type precompressReport struct {
+	files, reused int
+	original      int64
+	compressed    map[string]int64
+}
+
+// precompress compresses every compressible file in fsys that the build
+// didn't already compress. With dir set, the results are written there and
+// reused on the next start while their source is unchanged.
+func precompress(fsys fs.FS, dir string) (*precompressedFS, precompressReport, error) {
+	p := &precompressedFS{FS: fsys, siblings: map[string]*precompressedFile{}}
+	report := precompressReport{compressed: map[string]int64{}}
+
+	type job struct {
+		name string
+		info fs.FileInfo
+	}
+	var jobs []job
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() >= minCompressSize && isCompressible(mime.TypeByExtension(path.Ext(name))) {
+			jobs = append(jobs, job{name, info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, report, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+		next = make(chan job)
+	)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				siblings, reused, err := p.compress(j.name, j.info, dir)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", j.name, err))
+				}
+				if len(siblings) > 0 {
+					report.files++
+					report.original += j.info.Size()
+				}
+				if reused {
+					report.reused++
+				}
+				for suffix, f := range siblings {
+					p.siblings[j.name+suffix] = f
+					report.compressed[suffix] += f.info.Size()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, report, errs[0]
+	}
+	return p, report, nil
+}
+
+// compress produces name's missing siblings, skipping any that wouldn't be
+// smaller than the original.
+func (p *precompressedFS) compress(name string, info fs.FileInfo, dir string) (map[string]*precompressedFile, bool, error) {
+	var data []byte
+	siblings := map[string]*precompressedFile{}
+	reused := false
+	for _, c := range precompressors {
+		if _, err := fs.Stat(p.FS, name+c.suffix); err == nil {
+			continue // the build shipped its own
+		}
+		f := &precompressedFile{source: info}
+		if dir != "" {
+			f.path = filepath.Join(dir, filepath.FromSlash(name+c.suffix))
+			if fi, err := os.Stat(f.path); err == nil && fi.ModTime().Equal(info.ModTime()) {
+				f.info = fi
+				siblings[c.suffix] = f
+				reused = true
+				continue
+			}
+		}
+
+		if data == nil {
+			var err error
+			if data, err = fs.ReadFile(p.FS, name); err != nil {
+				return nil, false, err
+			}
+		}
+		var buf bytes.Buffer
+		w := c.write(&buf)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return nil, false, err
+		}
+		if buf.Len() >= len(data) {
+			continue
+		}
+
+		if dir == "" {
+			f.data = buf.Bytes()
+			f.info = memFileInfo{name: path.Base(name + c.suffix), size: int64(buf.Len()), modTime: info.ModTime()}
+		} else {
+			fi, err := writeSibling(f.path, buf.Bytes(), info.ModTime())
+			if err != nil {
+				return nil, false, err
+			}
+			f.info = fi
+		}
+		siblings[c.suffix] = f
+	}
+	return siblings, reused, nil
+}
+
+// writeSibling writes a compressed file atomically, stamped with its
+// source's modification time so the next start can tell it is current.
+func writeSibling(file string, data []byte, modTime time.Time) (fs.FileInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return nil, err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return nil, err
+	}
+	return os.Stat(file)
+}
+
+// current returns name's sibling if its source is unchanged.
+func (p *precompressedFS) current(name string) (*precompressedFile, bool) {
+	f, ok := p.siblings[name]
+	if !ok {
+		return nil, false
+	}
+	src, err := fs.Stat(p.FS, strings.TrimSuffix(name, path.Ext(name)))
+	if err != nil || src.Size() != f.source.Size() || !src.ModTime().Equal(f.source.ModTime()) {
+		return nil, false
+	}
+	return f, true
+}
+
+func (p *precompressedFS) Open(name string) (fs.File, error) {
+	f, ok := p.current(name)
+	if !ok {
+		return p.FS.Open(name)
+	}
+	if f.data == nil {
+		return os.Open(f.path)
+	}
+	return &cachedFile{Reader: bytes.NewReader(f.data), info: f.info}, nil
+}
+
+func (p *precompressedFS) Stat(name string) (fs.FileInfo, error) {
+	if f, ok := p.current(name); ok {
+		return f.info, nil
+	}
+	return fs.Stat(p.FS, name)
+}
+
+// memFileInfo describes a file that only exists in memory.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() any           { return nil }
+
+func (r precompressReport) log(elapsed time.Duration, dir string) {
+	where := "in memory"
+	if dir != "" {
+		where = fmt.Sprintf("in %s, %d reused", dir, r.reused)
+	}
+	log.Printf("🗜️  Precompressed %d files (%s) %s in %s: brotli %s, gzip %s", r.files, megabytes(r.original), where,
+		elapsed.Round(time.Millisecond), megabytes(r.compressed[".br"]), megabytes(r.compressed[".gz"]))
+}
+
+func megabytes(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// preloadLink is one critical asset announced in a Link header.
+type preloadLink struct {
+	path string
+	as   string // script, style, font, image, fetch; module for modulepreload
+}
+
+func (l preloadLink) String() string {
+	if l.as == "module" {
+		return "<" + l.path + ">; rel=modulepreload"
+	}
+	s := "<" + l.path + ">; rel=preload; as=" + l.as
+	if l.as == "font" || l.as == "fetch" {
+		// Fonts and fetches are requested in CORS mode; without this the
+		// preloaded copy doesn't match and is downloaded twice.
+		s += "; crossorigin"
+	}
+	return s
+}
+
+// preloader adds Link: rel=preload headers for the game's critical assets
+// to HTML responses and, with earlyHints, sends them ahead as 103 Early
+// Hints so the browser starts fetching while the page is still on its way.
+// Without a manifest the assets are read from index.html, and re-read
+// whenever it changes.
+type preloader struct {
+	fsys       fs.FS
+	earlyHints bool
+	fixed      []string // from a manifest file
+
+	mu      sync.Mutex
+	modTime time.Time
+	links   []string
+}
+
+// newPreloader returns nil when -preload is off.
+func newPreloader(cfg config, fsys fs.FS) (*preloader, error) {
+	p := &preloader{fsys: fsys, earlyHints: cfg.EarlyHints}
+	switch cfg.Preload {
+	case "off":
+		return nil, nil
+	case "auto":
+		return p, nil
+	}
+	links, err := readPreloadFile(cfg.Preload)
+	if err != nil {
+		return nil, err
+	}
+	p.fixed = []string{}
+	for _, l := range links {
+		p.fixed = append(p.fixed, l.String())
+	}
+	return p, nil
+}
+
+// readPreloadFile reads a manifest with one asset per line, optionally
+// followed by its type:
+//
+//	/assets/index-4f8a.js module
+//	/assets/index-9c1b.css
+//	/fonts/press-start.woff2 font
+func readPreloadFile(file string) ([]preloadLink, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var links []preloadLink
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		l := preloadLink{path: fields[0], as: preloadType(fields[0])}
+		if len(fields) > 1 {
+			l.as = fields[1]
+		}
+		switch {
+		case len(fields) > 2:
+			return nil, fmt.Errorf("%s:%d: want \"path [type]\"", file, n)
+		case !strings.HasPrefix(l.path, "/"):
+			return nil, fmt.Errorf("%s:%d: path %q must start with /", file, n, l.path)
+		case !validPreloadTypes[l.as]:
+			return nil, fmt.Errorf("%s:%d: unknown type %q for %s", file, n, l.as, l.path)
+		}
+		links = append(links, l)
+	}
+	return links, sc.Err()
+}
+
+var validPreloadTypes = map[string]bool{"module": true, "script": true, "style": true, "font": true, "image": true, "fetch": true}
+
+// preloadType guesses the type of an asset from its extension.
+func preloadType(p string) string {
+	switch path.Ext(p) {
+	case ".js", ".mjs":
+		return "module"
+	case ".css":
+		return "style"
+	case ".woff2", ".woff", ".ttf", ".otf":
+		return "font"
+	case ".png", ".jpg", ".jpeg", ".webp", ".avif", ".gif", ".svg":
+		return "image"
+	}
+	return "fetch"
+}
+
+// announce sets the Link headers and, for GET requests, sends 103 Early
+// Hints. It must run before the response status is written.
+func (p *preloader) announce(w http.ResponseWriter, r *http.Request) {
+	links := p.current()
+	if len(links) == 0 {
+		return
+	}
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	// Early hints only help a browser still waiting for the page; after a
+	// 304 it has everything cached already.
+	if p.earlyHints && r.Method == http.MethodGet && r.Header.Get("If-None-Match") == "" {
+		// A 1xx response carries the whole header map; send the links
+		// alone and keep the rest for the page itself.
+		h := w.Header()
+		final := h.Clone()
+		for k := range h {
+			if k != "Link" {
+				delete(h, k)
+			}
+		}
+		w.WriteHeader(http.StatusEarlyHints)
+		for k, v := range final {
+			h[k] = v
+		}
+	}
+}
+
+func (p *preloader) current() []string {
+	if p.fixed != nil {
+		return p.fixed
+	}
+	fi, err := fs.Stat(p.fsys, "index.html")
+	if err != nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !fi.ModTime().Equal(p.modTime) || p.links == nil {
+		page, err := fs.ReadFile(p.fsys, "index.html")
+		if err != nil {
+			return nil
+		}
+		p.links = []string{}
+		for _, l := range criticalAssets(string(page)) {
+			p.links = append(p.links, l.String())
+		}
+		p.modTime = fi.ModTime()
+	}
+	return p.links
+}
+
+// criticalAssets finds what index.html loads before the game can start:
+// module scripts, stylesheets and anything the build already marks for
+// preloading. Relative paths resolve against the site root, since
+// index.html is also served for client-side routes.
+func criticalAssets(page string) []preloadLink {
+	var links []preloadLink
+	seen := map[string]bool{}
+	add := func(ref, as string) {
+		u, err := url.Parse(ref)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || as == "" {
+			return
+		}
+		p := path.Clean("/" + u.Path)
+		if !seen[p] {
+			seen[p] = true
+			links = append(links, preloadLink{path: p, as: as})
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, hasAttr := z.TagName()
+		attrs := map[string]string{}
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			attrs[string(key)] = string(val)
+		}
+		switch string(tag) {
+		case "script":
+			if attrs["type"] == "module" {
+				add(attrs["src"], "module")
+			} else if _, async := attrs["async"]; !async {
+				add(attrs["src"], "script")
+			}
+		case "link":
+			switch strings.ToLower(attrs["rel"]) {
+			case "stylesheet":
+				add(attrs["href"], "style")
+			case "modulepreload":
+				add(attrs["href"], "module")
+			case "preload":
+				if validPreloadTypes[attrs["as"]] {
+					add(attrs["href"], attrs["as"])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// eraseConfirmTTL is how long a player has to confirm deleting their
+// account.
+const eraseConfirmTTL = 10 * time.Minute
+
+// privacyAPI serves /api/me: signed-in players download everything stored
+// about them, or delete their account.
+type privacyAPI struct {
+	db       *storage.DB
+	sessions *sessionCache
+	proxies  trustedProxies
+	secret   []byte
+}
+
+// newPrivacyAPI signs confirmations with a secret generated once and kept
+// in the database, so any instance can finish what another started.
+func newPrivacyAPI(db *storage.DB, sessions *sessionCache, proxies trustedProxies) (*privacyAPI, error) {
+	secret, err := db.Secret(context.Background(), "erase", 32)
+	if err != nil {
+		return nil, err
+	}
+	return &privacyAPI{db: db, sessions: sessions, proxies: proxies, secret: secret}, nil
+}
+
+func (a *privacyAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/me/export", a.export)
+	mux.HandleFunc("POST /api/me/delete", a.erase)
+}
+
+// export downloads the player's data as one JSON document, or with
+// ?format=zip as a zip of one JSON file per section.
+func (a *privacyAPI) export(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	format := cmp.Or(r.URL.Query().Get("format"), "json")
+	if format != "json" && format != "zip" {
+		writeError(w, http.StatusBadRequest, "format must be json or zip")
+		return
+	}
+	e, err := a.db.ExportPlayer(r.Context(), p.ID)
+	if err != nil {
+		a.fail(w, "export", err)
+		return
+	}
+	doc := struct {
+		ExportedAt time.Time `json:"exported_at"`
+		storage.PlayerExport
+		Save json.RawMessage `json:"save"`
+	}{ExportedAt: time.Now().UTC().Truncate(time.Second), PlayerExport: e, Save: json.RawMessage("null")}
+	if e.Save != nil {
+		zr, err := gzip.NewReader(bytes.NewReader(e.Save.Data))
+		if err == nil {
+			doc.Save, err = io.ReadAll(zr)
+		}
+		if err != nil {
+			a.fail(w, "export save", err)
+			return
+		}
+	}
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		a.fail(w, "export", err)
+		return
+	}
+	a.audit(r, p.ID, "player.export", format)
+
+	name := "loderunner2099-player-" + strconv.FormatInt(p.ID, 10)
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		w.Write(append(body, '\n'))
+		return
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(body, &sections); err != nil {
+		a.fail(w, "export", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	delete(sections, "exported_at")
+	zw := zip.NewWriter(w)
+	zw.SetComment("Lode Runner 2099 data export, " + doc.ExportedAt.Format(time.RFC3339))
+	for _, key := range slices.Sorted(maps.Keys(sections)) {
+		var buf bytes.Buffer
+		json.Indent(&buf, sections[key], "", "  ")
+		buf.WriteByte('\n')
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: key + ".json", Method: zip.Deflate, Modified: doc.ExportedAt})
+		if err == nil {
+			_, err = buf.WriteTo(f)
+		}
+		if err != nil {
+			// The headers are gone; all that's left is to stop.
+			log.Printf("privacy: export zip: %v", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("privacy: export zip: %v", err)
+	}
+}
+
+// erase deletes the player's account in two steps. Without a body it
+// returns a confirmation token; posting {"confirm": token} before it
+// expires deletes the account, signs out every session and tombstones the
+// player's scores and levels.
+func (a *privacyAPI) erase(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Confirm string `json:"confirm"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+	now := time.Now()
+	if body.Confirm == "" {
+		expires := now.Add(eraseConfirmTTL).Truncate(time.Second)
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"confirm":    a.confirmToken(p.ID, expires),
+			"expires_at": expires.UTC(),
+		})
+		return
+	}
+	if !a.checkConfirm(body.Confirm, p.ID, now) {
+		writeError(w, http.StatusForbidden, "confirmation is invalid or has expired")
+		return
+	}
+	erased, err := a.db.ErasePlayer(r.Context(), p.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Deleted by a request that got here first.
+		clearSessionCookie(w, r, a.proxies)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		a.fail(w, "erase", err)
+		return
+	}
+	if err := a.sessions.forget(r.Context(), erased.Sessions...); err != nil {
+		log.Printf("privacy: forget sessions: %v", err)
+	}
+	a.audit(r, p.ID, "player.delete",
+		fmt.Sprintf("scores=%d levels=%d saves=%d", erased.Scores, erased.Levels, erased.Saves))
+	log.Printf("🗑️  Deleted player %d at their request", p.ID)
+	clearSessionCookie(w, r, a.proxies)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirmToken is "expires.signature", with expires in Unix seconds,
+// valid only for playerID.
+func (a *privacyAPI) confirmToken(playerID int64, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + base64.RawURLEncoding.EncodeToString(a.mac(playerID, exp))
+}
+
+func (a *privacyAPI) checkConfirm(token string, playerID int64, now time.Time) bool {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, a.mac(playerID, exp)) {
+		return false
+	}
+	expires, _ := strconv.ParseInt(exp, 10, 64)
+	return now.Unix() < expires
+}
+
+func (a *privacyAPI) mac(playerID int64, exp string) []byte {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(strconv.FormatInt(playerID, 10) + "\x00" + exp))
+	return m.Sum(nil)
+}
+
+// audit records a player's own request in the moderators' audit log. It
+// names the player only by ID, which outlives their account.
+func (a *privacyAPI) audit(r *http.Request, playerID int64, action, detail string) {
+	id := strconv.FormatInt(playerID, 10)
+	err := a.db.RecordAudit(r.Context(), storage.AuditEntry{
+		Actor: "player:" + id, Action: action, TargetKind: "player", TargetID: id, Detail: detail,
+	})
+	if err != nil {
+		log.Printf("privacy: audit %s: %v", action, err)
+	}
+}
+
+func (a *privacyAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("privacy: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// proxyRoute forwards a path prefix to an upstream server.
+type proxyRoute struct {
+	prefix string // without trailing slash, e.g. /api
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+func (p proxyRoute) matches(path string) bool {
+	return path == p.prefix || strings.HasPrefix(path, p.prefix+"/")
+}
+
+// parseProxyRoute parses "/prefix=http://host:port[/base]". Request paths
+// are kept, so /api/scores goes to http://host:port/base/api/scores.
+func parseProxyRoute(spec string) (prefix string, target *url.URL, err error) {
+	prefix, rawURL, ok := strings.Cut(spec, "=")
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return "", nil, fmt.Errorf("-proxy %q: want /prefix=http://host:port", spec)
+	}
+	target, err = url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "", nil, fmt.Errorf("-proxy %q: upstream must be an http or https URL", spec)
+	}
+	return prefix, target, nil
+}
+
+// newProxyRoutes builds a reverse proxy per -proxy flag.
+func newProxyRoutes(cfg config, proxies trustedProxies) ([]proxyRoute, error) {
+	transport := proxyTransport(cfg)
+	var routes []proxyRoute
+	for _, spec := range cfg.Proxies {
+		prefix, target, err := parseProxyRoute(spec)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, proxyRoute{prefix: prefix, target: target, proxy: newReverseProxy(target, transport, proxies)})
+	}
+	return routes, nil
+}
+
+// proxyTransport is shared by every proxy so upstream connections are
+// pooled.
+func proxyTransport(cfg config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.ProxyDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = cfg.ProxyTimeout
+	return transport
+}
+
+func newReverseProxy(target *url.URL, transport http.RoundTripper, proxies trustedProxies) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// Keep the chain from proxies in front of us; anyone else
+			// could put anything in it.
+			// A client address given some other way goes first.
+			if proxies.proxied(pr.In) {
+				if proxies.header != "X-Forwarded-For" {
+					pr.Out.Header.Set("X-Forwarded-For", proxies.clientIP(pr.In))
+				} else if xff := pr.In.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+					pr.Out.Header["X-Forwarded-For"] = xff
+				}
+			}
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			// Continue our trace upstream, in place of the caller's.
+			otel.GetTextMapPropagator().Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+		},
+		// The response already carries the request's ID, which the
+		// upstream got in the request and may echo.
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del(requestIDHeader)
+			return nil
+		},
+		Transport:    transport,
+		ErrorHandler: proxyError,
+	}
+}
+
+// proxyError answers for an upstream that failed or took too long.
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return // client went away
+	}
+	code := http.StatusBadGateway
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		code = http.StatusGatewayTimeout
+	}
+	log.Printf("proxy: %s %s: %v (request %s)", r.Method, r.URL.Path, err, requestID(w))
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, code, "upstream unavailable")
+		return
+	}
+	http.Error(w, fmt.Sprintf("%d %s", code, http.StatusText(code)), code)
+}
+
+// withProxies sends requests under a proxied prefix upstream, ahead of any
+// local handler for the same paths. Streaming responses such as
+// Server-Sent Events are flushed as they arrive, and WebSocket upgrades
+// pass straight through.
+func withProxies(routes []proxyRoute, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range routes {
+			if route.matches(r.URL.Path) {
+				// The upstream enforces its own timeouts, and may stream.
+				streaming(w, 0)
+				route.proxy.ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+	"github.com/jgbrwn/loderunner2099/internal/webpush"
+)
+
+const (
+	// pushQueue is how many messages may wait to be sent. The daily
+	// announcement waits for room; record notifications are dropped.
+	pushQueue   = 256
+	pushWorkers = 4
+
+	// pushLateStart still announces the day's challenge when the server
+	// starts this long after midnight, e.g. after a deploy across it.
+	pushLateStart = time.Hour
+)
+
+var pushSent = defaultRegistry.counter("loderunner_push_sent_total",
+	"Web Push messages sent, by kind and result.", "kind", "result")
+
+// pushMessage is the JSON payload the service worker turns into a
+// notification.
+type pushMessage struct {
+	Kind  string `json:"kind"` // daily or record
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+}
+
+type pushDelivery struct {
+	sub storage.PushSubscription
+	msg pushMessage
+}
+
+// pushSender delivers Web Push notifications in the background: the daily
+// challenge going live, and players' level records being beaten.
+type pushSender struct {
+	db      *storage.DB
+	key     *webpush.VAPIDKey
+	subject string
+	client  *http.Client
+	queue   chan pushDelivery
+	workers sync.WaitGroup
+	daily   sync.WaitGroup
+}
+
+// newPushSender uses the -vapid-private-key, or one generated once and
+// kept in the database so subscriptions survive restarts.
+func newPushSender(db *storage.DB, cfg config) (*pushSender, error) {
+	var key *webpush.VAPIDKey
+	var err error
+	if cfg.VAPIDPrivateKey != "" {
+		key, err = webpush.ParseVAPIDKey(cfg.VAPIDPrivateKey)
+	} else {
+		var raw []byte
+		if raw, err = db.Secret(context.Background(), "vapid", 32); err == nil {
+			key, err = webpush.NewVAPIDKey(raw)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	subject := cfg.VAPIDSubject
+	if subject == "" {
+		subject = cfg.PublicURL
+	}
+	return &pushSender{
+		db:      db,
+		key:     key,
+		subject: subject,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		queue:   make(chan pushDelivery, pushQueue),
+	}, nil
+}
+
+// start runs the senders, and announces daily challenges until ctx is
+// done.
+func (p *pushSender) start(ctx context.Context, daily *dailyChallenge) {
+	p.daily.Add(1)
+	go func() {
+		defer p.daily.Done()
+		p.announceDaily(ctx, daily)
+	}()
+	for range pushWorkers {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for d := range p.queue {
+				p.send(d)
+			}
+		}()
+	}
+}
+
+func (p *pushSender) send(d pushDelivery) {
+	payload, _ := json.Marshal(d.msg)
+	opts := webpush.Options{Subject: p.subject, TTL: 12 * time.Hour, Urgency: "low", Topic: d.msg.Kind}
+	if d.msg.Kind == "record" {
+		opts.TTL, opts.Urgency, opts.Topic = 24*time.Hour, "normal", ""
+	}
+	var sub webpush.Subscription
+	sub.Endpoint, sub.Keys.P256dh, sub.Keys.Auth = d.sub.Endpoint, d.sub.P256dh, d.sub.Auth
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	err := p.key.Send(ctx, p.client, sub, payload, opts)
+	switch {
+	case errors.Is(err, webpush.ErrGone):
+		pushSent.inc(d.msg.Kind, "gone")
+		if err := p.db.DropPushEndpoint(ctx, d.sub.Endpoint); err != nil {
+			log.Printf("push: drop subscription: %v", err)
+		}
+	case err != nil:
+		pushSent.inc(d.msg.Kind, "error")
+		log.Printf("push: send %s: %v", d.msg.Kind, err)
+	default:
+		pushSent.inc(d.msg.Kind, "ok")
+	}
+}
+
+// close sends what is still queued, waiting until ctx is done. The
+// context start was given must be done already.
+func (p *pushSender) close(ctx context.Context) {
+	p.daily.Wait()
+	close(p.queue)
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// announceDaily sends each day's challenge to the subscribers who want it
+// as the day starts, until ctx is done.
+func (p *pushSender) announceDaily(ctx context.Context, daily *dailyChallenge) {
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	if now.Sub(today) < pushLateStart {
+		p.announce(ctx, daily, today)
+	}
+	for {
+		next := today.Add(24 * time.Hour)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		today = next
+		p.announce(ctx, daily, today)
+	}
+}
+
+func (p *pushSender) announce(ctx context.Context, daily *dailyChallenge, day time.Time) {
+	date := day.Format(dailyDateLayout)
+	// Another instance, or the process before an upgrade, may have been
+	// first.
+	first, err := p.db.ClaimDailyPush(ctx, date)
+	if err != nil {
+		log.Printf("push: claim daily %s: %v", date, err)
+		return
+	}
+	if !first {
+		return
+	}
+	subs, err := p.db.DailyPushSubscriptions(ctx)
+	if err != nil {
+		log.Printf("push: daily subscribers: %v", err)
+		return
+	}
+	msg := pushMessage{
+		Kind:  "daily",
+		Title: "New daily challenge",
+		Body:  fmt.Sprintf("Seed %s is live. Can you top the board?", daily.seed(date)),
+		URL:   "/",
+	}
+	for _, s := range subs {
+		select {
+		case p.queue <- pushDelivery{s, msg}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Printf("🔔 Announcing the %s daily challenge to %d subscriptions", date, len(subs))
+}
+
+// recordBeaten tells whoever held the record on s's level that s took it.
+// s must be the new best score on its level.
+func (p *pushSender) recordBeaten(ctx context.Context, s storage.Score) {
+	top, _, err := p.db.TopScores(ctx, storage.ScoreFilter{Level: s.Level}, 2, 0)
+	if err != nil {
+		log.Printf("push: previous record: %v", err)
+		return
+	}
+	if len(top) < 2 || top[0].ID != s.ID {
+		return
+	}
+	prev := top[1]
+	owner := scoreOwner(prev)
+	if owner == "" || owner == scoreOwner(s) {
+		return
+	}
+	subs, err := p.db.PushSubscriptions(ctx, owner, "records")
+	if err != nil {
+		log.Printf("push: record subscribers: %v", err)
+		return
+	}
+	msg := pushMessage{
+		Kind:  "record",
+		Title: "Record beaten",
+		Body:  fmt.Sprintf("%s beat your record on level %d with %d points.", s.Player, s.Level, s.Score),
+		URL:   "/",
+	}
+	for _, sub := range subs {
+		select {
+		case p.queue <- pushDelivery{sub, msg}:
+		default:
+			log.Printf("⚠️  Push queue full, dropping a record notification")
+		}
+	}
+}
+
+// scoreOwner is the push owner a score belongs to, or "" if it was sent
+// anonymously.
+func scoreOwner(s storage.Score) string {
+	switch {
+	case s.PlayerID != 0:
+		return "player:" + strconv.FormatInt(s.PlayerID, 10)
+	case s.PlayerTokenHash != "":
+		return "token:" + s.PlayerTokenHash
+	}
+	return ""
+}
+
+// pushAPI serves /api/push, where browsers subscribe to notifications and
+// players choose which ones they get.
+type pushAPI struct {
+	db   *storage.DB
+	push *pushSender
+}
+
+func (a *pushAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/push/key", a.publicKey)
+	mux.HandleFunc("POST /api/push/subscriptions", a.subscribe)
+	mux.HandleFunc("DELETE /api/push/subscriptions", a.unsubscribe)
+	mux.HandleFunc("GET /api/push/preferences", a.preferences)
+	mux.HandleFunc("PUT /api/push/preferences", a.setPreferences)
+}
+
+// owner identifies who a request subscribes for: the signed-in account,
+// or else the X-Player-Token, which is what their scores carry.
+func (a *pushAPI) owner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if p := currentPlayer(r); p != nil {
+		return "player:" + strconv.FormatInt(p.ID, 10), true
+	}
+	hash, ok := playerToken(w, r)
+	return "token:" + hash, ok
+}
+
+// publicKey is the applicationServerKey for PushManager.subscribe.
+func (a *pushAPI) publicKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, map[string]string{"public_key": a.push.key.PublicKey()})
+}
+
+func (a *pushAPI) subscribe(w http.ResponseWriter, r *http.Request) {
+	owner, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	var sub webpush.Subscription
+	if err := decodeJSON(w, r, maxJSONBody, &sub); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(sub.Endpoint) > 1024 {
+		writeError(w, http.StatusUnprocessableEntity, "endpoint must be at most 1024 characters")
+		return
+	}
+	if err := sub.Validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	err := a.db.SavePushSubscription(r.Context(), storage.PushSubscription{
+		Endpoint: sub.Endpoint, P256dh: sub.Keys.P256dh, Auth: sub.Keys.Auth, Owner: owner,
+	})
+	if err != nil {
+		a.fail(w, "save subscription", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *pushAPI) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	owner, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	err := a.db.DeletePushSubscription(r.Context(), req.Endpoint, owner)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "delete subscription", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *pushAPI) preferences(w http.ResponseWriter, r *http.Request) {
+	owner, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	p, err := a.db.PushPreferences(r.Context(), owner)
+	if err != nil {
+		a.fail(w, "get preferences", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// setPreferences changes the fields given and leaves the others.
+func (a *pushAPI) setPreferences(w http.ResponseWriter, r *http.Request) {
+	owner, ok := a.owner(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Daily   *bool `json:"daily"`
+		Records *bool `json:"records"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	p, err := a.db.PushPreferences(r.Context(), owner)
+	if err != nil {
+		a.fail(w, "get preferences", err)
+		return
+	}
+	if req.Daily != nil {
+		p.Daily = *req.Daily
+	}
+	if req.Records != nil {
+		p.Records = *req.Records
+	}
+	if err := a.db.SetPushPreferences(r.Context(), owner, p); err != nil {
+		a.fail(w, "set preferences", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (a *pushAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("push: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
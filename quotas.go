@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Quota headers, sent with every upload a quota applies to. The upload
+// limit headers count the day's uploads of that kind, this one included;
+// the storage ones count bytes of every kind.
+const (
+	quotaLimitHeader     = "X-Quota-Limit"
+	quotaRemainingHeader = "X-Quota-Remaining"
+	quotaResetHeader     = "X-Quota-Reset" // Unix time the day's count starts over
+	storageLimitHeader   = "X-Storage-Limit"
+	storageUsedHeader    = "X-Storage-Used"
+)
+
+// quotaNouns name each kind of upload in errors.
+var quotaNouns = map[string]string{
+	storage.QuotaLevels:  "levels",
+	storage.QuotaReplays: "replays",
+	storage.QuotaSaves:   "new saves",
+}
+
+var quotaExceeded = defaultRegistry.counter("loderunner_quota_exceeded_total",
+	"Uploads refused for being over a quota, by kind.", "kind")
+
+// quotas caps what each client may upload: how many levels, replays and
+// new saves a UTC day, and how many bytes of them it may keep stored.
+// Unlike the rate limiter's buckets, usage is counted from what the
+// database holds, so it survives restarts and is the same on every
+// replica. Signed-in players count by account, anyone else by address.
+type quotas struct {
+	db      *storage.DB
+	proxies trustedProxies
+	daily   map[string]int // by kind; 0 for no limit
+	storage int64          // bytes; 0 for no limit
+}
+
+func newQuotas(db *storage.DB, cfg config, proxies trustedProxies) *quotas {
+	return &quotas{
+		db:      db,
+		proxies: proxies,
+		daily: map[string]int{
+			storage.QuotaLevels:  cfg.QuotaLevelsPerDay,
+			storage.QuotaReplays: cfg.QuotaReplaysPerDay,
+			storage.QuotaSaves:   cfg.QuotaSavesPerDay,
+		},
+		storage: int64(cfg.QuotaStorageMB) << 20,
+	}
+}
+
+// subject returns who r's uploads count against: the signed-in player, or
+// the client's address, hashed like the tokens stored beside it.
+func (q *quotas) subject(r *http.Request) string {
+	if p := currentPlayer(r); p != nil {
+		return "player:" + strconv.FormatInt(p.ID, 10)
+	}
+	return "ip:" + hashToken(q.proxies.clientIP(r))
+}
+
+// quotaUpload is an upload checked against the quotas.
+type quotaUpload struct {
+	kind    string
+	size    int64  // bytes it stores
+	freed   int64  // bytes it replaces, if they count against owner
+	owner   string // subject of the replaced bytes
+	counted bool   // one of the day's uploads
+
+	uploads int64 // the day's uploads of kind, this one included if counted
+	stored  int64 // bytes the subject stores, without this upload
+	reset   time.Time
+}
+
+// check looks up subject's usage for u.
+func (q *quotas) check(ctx context.Context, subject string, u *quotaUpload) error {
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	u.reset = day.AddDate(0, 0, 1)
+	if u.owner != subject {
+		u.freed = 0
+	}
+	if q.daily[u.kind] == 0 && q.storage == 0 {
+		return nil
+	}
+	usage, err := q.db.QuotaUsage(ctx, subject, u.kind, day)
+	if err != nil {
+		return err
+	}
+	u.uploads, u.stored = usage.Uploads, usage.Bytes
+	if u.counted {
+		u.uploads++
+	}
+	return nil
+}
+
+// over returns the status to refuse u with, and why, or 0 if it is within
+// the quotas. An upload that shrinks what the subject stores is let through
+// even over the storage quota.
+func (q *quotas) over(u *quotaUpload) (int, string) {
+	if limit := q.daily[u.kind]; limit > 0 && u.counted && u.uploads > int64(limit) {
+		return http.StatusTooManyRequests, fmt.Sprintf("at most %d %s a day; try again tomorrow (UTC)", limit, quotaNouns[u.kind])
+	}
+	if q.storage > 0 && u.size > u.freed && u.stored-u.freed+u.size > q.storage {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("upload would exceed the storage quota of %d MB; %d bytes already stored", q.storage>>20, u.stored)
+	}
+	return 0, ""
+}
+
+// allow checks r's upload against the quotas, sets the quota headers and,
+// if it is over one, refuses it with 429 or 413. It returns the subject to
+// store the upload under.
+func (q *quotas) allow(w http.ResponseWriter, r *http.Request, u quotaUpload) (string, bool) {
+	subject := q.subject(r)
+	if err := q.check(r.Context(), subject, &u); err != nil {
+		log.Printf("quotas: %s: %v (request %s)", u.kind, err, requestID(w))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return "", false
+	}
+	status, msg := q.over(&u)
+	h := w.Header()
+	if limit := q.daily[u.kind]; limit > 0 && u.counted {
+		h.Set(quotaLimitHeader, strconv.Itoa(limit))
+		h.Set(quotaRemainingHeader, strconv.FormatInt(max(int64(limit)-u.uploads, 0), 10))
+		h.Set(quotaResetHeader, strconv.FormatInt(u.reset.Unix(), 10))
+	}
+	if q.storage > 0 {
+		used := u.stored
+		if status == 0 {
+			used += u.size - u.freed
+		}
+		h.Set(storageLimitHeader, strconv.FormatInt(q.storage, 10))
+		h.Set(storageUsedHeader, strconv.FormatInt(used, 10))
+	}
+	if status == 0 {
+		return subject, true
+	}
+	quotaExceeded.inc(u.kind)
+	if status == http.StatusTooManyRequests {
+		h.Set("Retry-After", strconv.Itoa(int(time.Until(u.reset).Seconds())+1))
+	}
+	writeError(w, status, msg)
+	return "", false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rangeServer serves a directory holding game.js, big enough to gzip,
+// through the static handler, gzipHandler and accessLog, as the server
+// does. It returns the file, the server and the access log.
+func rangeServer(t *testing.T) ([]byte, *httptest.Server, *bytes.Buffer) {
+	t.Helper()
+	dir := t.TempDir()
+	content := []byte(strings.Repeat("function run() { return 2099; }\n", 256))
+	if err := os.WriteFile(filepath.Join(dir, "game.js"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := newCachePolicy(config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	logger, err := newAccessLogger("json", &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(accessLog(logger, true, trustedProxies{}, gzipHandler(newStaticHandler(os.DirFS(dir), policy))))
+	t.Cleanup(srv.Close)
+	return content, srv, &log
+}
+
+// get fetches /game.js as a gzip-capable client, with the given headers,
+// and returns the undecoded response and body.
+func get(t *testing.T, srv *httptest.Server, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/game.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestRangeServesPartialContent(t *testing.T) {
+	content, srv, _ := rangeServer(t)
+	resp, body := get(t, srv, "Range", "bytes=0-9")
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Content-Range"), "bytes 0-9/"+strconv.Itoa(len(content)); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding = %q, want none", ce)
+	}
+	if !bytes.Equal(body, content[:10]) {
+		t.Errorf("body = %q, want %q", body, content[:10])
+	}
+}
+
+func TestRangeUnsatisfiable(t *testing.T) {
+	content, srv, _ := rangeServer(t)
+	resp, _ := get(t, srv, "Range", "bytes="+strconv.Itoa(len(content)+10)+"-")
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("status = %d, want 416", resp.StatusCode)
+	}
+}
+
+func TestRangeMultipart(t *testing.T) {
+	_, srv, _ := rangeServer(t)
+	resp, _ := get(t, srv, "Range", "bytes=0-9,100-109")
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+		t.Errorf("Content-Type = %q, want multipart/byteranges", ct)
+	}
+}
+
+func TestRangeStaleIfRange(t *testing.T) {
+	content, srv, _ := rangeServer(t)
+	resp, body := get(t, srv, "Range", "bytes=0-9", "If-Range", `"stale"`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("got %d bytes, want the whole %d-byte file", len(body), len(content))
+	}
+}
+
+// A client resuming a gzipped download with a date If-Range would get
+// uncompressed bytes spliced onto a gzip prefix, so only responses served
+// as is advertise ranges.
+func TestCompressedResponseDropsAcceptRanges(t *testing.T) {
+	_, srv, _ := rangeServer(t)
+	resp, _ := get(t, srv)
+	if ce := resp.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", ce)
+	}
+	if ar := resp.Header.Get("Accept-Ranges"); ar != "" {
+		t.Errorf("Accept-Ranges = %q, want none", ar)
+	}
+	resp, _ = get(t, srv, "Accept-Encoding", "identity")
+	if ar := resp.Header.Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("uncompressed Accept-Ranges = %q, want bytes", ar)
+	}
+}
+
+func TestAccessLogRecordsPartialContent(t *testing.T) {
+	_, srv, log := rangeServer(t)
+	get(t, srv, "Range", "bytes=0-9")
+	srv.Close() // waits for the handler to log
+	var entry struct {
+		Status int   `json:"status"`
+		Bytes  int64 `json:"bytes"`
+	}
+	if err := json.Unmarshal(log.Bytes(), &entry); err != nil {
+		t.Fatalf("access log %q: %v", log, err)
+	}
+	if entry.Status != http.StatusPartialContent || entry.Bytes != 10 {
+		t.Errorf("logged status %d, %d bytes; want 206, 10 bytes", entry.Status, entry.Bytes)
+	}
+}
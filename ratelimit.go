@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+)
+
+// defaultRateRules apply after any operator-supplied rules. The first rule
+// matching a request decides its limit.
+var defaultRateRules = []string{
+	"/healthz, /readyz, /metrics -> off",
+	"POST /api/scores -> 5/m",
+	"POST /api/events -> 12/m burst 30",
+	"POST /api/auth/login, /api/auth/register, /_gate -> 10/m",
+	"GET /api/me/export -> 10/h burst 3",
+	"POST /api/saves/codes/redeem -> 10/m",
+	"/ws, /api/scores/stream, /auth/* -> 10/m",
+	"POST /api/graphql -> 5/s burst 20",
+	"POST, PUT, DELETE /api/* -> 60/m",
+	"/api/* -> 20/s",
+	"* -> 60/s",
+}
+
+var rateLimited = defaultRegistry.counter("loderunner_rate_limited_total",
+	"Requests refused by the rate limiter, by rule.", "rule")
+
+// rateRule limits requests matching methods and patterns to rate tokens
+// per second with bursts of up to burst. A zero rate means unlimited.
+type rateRule struct {
+	name     string
+	methods  []string // empty matches any method
+	patterns pathPatterns
+	rate     float64
+	burst    float64
+}
+
+func (r rateRule) matches(req *http.Request) bool {
+	if len(r.methods) > 0 {
+		method := req.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if !slices.Contains(r.methods, method) {
+			return false
+		}
+	}
+	return r.patterns.match(req.URL.Path)
+}
+
+// parseRateRule parses "[METHOD...] pattern[, pattern...] -> N/unit [burst B]"
+// or "... -> off". The unit is s, m, h or a duration such as 10s; the burst
+// defaults to N.
+func parseRateRule(line string) (rateRule, error) {
+	lhs, rhs, ok := strings.Cut(line, "->")
+	if !ok {
+		return rateRule{}, fmt.Errorf("rate rule %q: want \"pattern -> N/unit\"", line)
+	}
+	rule := rateRule{name: strings.TrimSpace(lhs)}
+	for _, f := range strings.Fields(strings.ReplaceAll(lhs, ",", " ")) {
+		if f == strings.ToUpper(f) && !strings.ContainsAny(f, "/*") {
+			rule.methods = append(rule.methods, f)
+			continue
+		}
+		if !strings.HasPrefix(f, "/") && f != "*" {
+			return rateRule{}, fmt.Errorf("rate rule %q: pattern %q must start with /", line, f)
+		}
+		rule.patterns = append(rule.patterns, f)
+	}
+	if len(rule.patterns) == 0 {
+		return rateRule{}, fmt.Errorf("rate rule %q: no patterns", line)
+	}
+
+	fields := strings.Fields(rhs)
+	if len(fields) == 1 && fields[0] == "off" {
+		return rule, nil
+	}
+	if len(fields) != 1 && (len(fields) != 3 || fields[1] != "burst") {
+		return rateRule{}, fmt.Errorf("rate rule %q: want \"N/unit [burst B]\" or \"off\"", line)
+	}
+	count, unit, ok := strings.Cut(fields[0], "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return rateRule{}, fmt.Errorf("rate rule %q: bad rate %q", line, fields[0])
+	}
+	per, err := rateUnit(unit)
+	if err != nil {
+		return rateRule{}, fmt.Errorf("rate rule %q: %w", line, err)
+	}
+	rule.rate = float64(n) / per.Seconds()
+	rule.burst = float64(n)
+	if len(fields) == 3 {
+		b, err := strconv.Atoi(fields[2])
+		if err != nil || b < 1 {
+			return rateRule{}, fmt.Errorf("rate rule %q: bad burst %q", line, fields[2])
+		}
+		rule.burst = float64(b)
+	}
+	return rule, nil
+}
+
+func rateUnit(s string) (time.Duration, error) {
+	switch s {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad unit %q", s)
+	}
+	return d, nil
+}
+
+// rateLimiter keeps a token bucket per client IP and rule, in the shared
+// store so that every instance draws on the same buckets.
+type rateLimiter struct {
+	rules   []rateRule
+	proxies trustedProxies
+	store   ephemeral.Store
+
+	failing atomic.Bool // the store was unreachable at the last request
+}
+
+// newRateLimiter builds the limiter from -rate-limit-rule flags, then the
+// defaults.
+func newRateLimiter(cfg config, proxies trustedProxies, store ephemeral.Store) (*rateLimiter, error) {
+	l := &rateLimiter{proxies: proxies, store: store}
+	for _, line := range append(append([]string(nil), cfg.RateLimitRules...), defaultRateRules...) {
+		rule, err := parseRateRule(line)
+		if err != nil {
+			return nil, err
+		}
+		l.rules = append(l.rules, rule)
+	}
+	return l, nil
+}
+
+// allow takes a token for the request and, when there is none, reports how
+// long until there will be. Requests are let through while the store is
+// unreachable: limits are not worth an outage.
+func (l *rateLimiter) allow(r *http.Request) (rule int, ok bool, retry time.Duration) {
+	rule = -1
+	for i, rr := range l.rules {
+		if rr.matches(r) {
+			rule = i
+			break
+		}
+	}
+	if rule < 0 || l.rules[rule].rate == 0 {
+		return rule, true, 0
+	}
+	rr := l.rules[rule]
+	retry, err := l.store.Allow(r.Context(), "ratelimit:"+rr.name+":"+l.proxies.clientIP(r), rr.rate, rr.burst)
+	if err != nil {
+		if !l.failing.Swap(true) {
+			log.Printf("⚠️  Rate limiting suspended: %v", err)
+		}
+		return rule, true, 0
+	}
+	if l.failing.Swap(false) {
+		log.Printf("🚦 Rate limiting resumed")
+	}
+	return rule, retry == 0, retry
+}
+
+// rateLimit refuses requests over their rule's limit with 429 and a
+// Retry-After in whole seconds.
+func rateLimit(l *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok, retry := l.allow(r)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rateLimited.inc(l.rules[rule].name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeError(w, http.StatusTooManyRequests, "rate limited")
+			return
+		}
+		http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+	})
+}
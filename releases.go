@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// A -dist of versioned builds is laid out as
+//
+//	dist/releases/<id>/index.html ...
+//	dist/current -> releases/<id>
+//
+// and a deploy uploads the new release next to the others and then swaps
+// the symlink.
+const (
+	releasesDir = "releases"
+	currentLink = "current"
+)
+
+// releaseCookie pins a page to the release it was loaded from, so that the
+// chunks it loads later come from the same one. Pages set it to the current
+// release each time they load.
+const releaseCookie = "lr_release"
+
+// releaseCheckInterval is how often the current symlink is read.
+const releaseCheckInterval = time.Second
+
+var pinnedResponses = defaultRegistry.counter("loderunner_release_pinned_responses_total",
+	"Files served from a release other than the current one, to pages loaded before a swap.")
+
+// isReleases reports whether dir is laid out as releases.
+func isReleases(dir string) bool {
+	fi, err := os.Lstat(filepath.Join(dir, currentLink))
+	return err == nil && fi.Mode()&fs.ModeSymlink != 0
+}
+
+// distFS returns the build in dir, the current release for a dir of
+// releases.
+func distFS(dir string) fs.FS {
+	if isReleases(dir) {
+		// Opened by path, so every open follows the symlink as it is now.
+		return os.DirFS(filepath.Join(dir, currentLink))
+	}
+	return os.DirFS(dir)
+}
+
+// releaseSet serves a dir of releases: pages from the current release, and
+// the files they load from the release they were loaded from.
+type releaseSet struct {
+	env    siteEnv
+	cfg    config
+	dir    string
+	policy *cachePolicy
+	suffix string // ends log lines, naming the site
+
+	current atomic.Pointer[release]
+	mu      sync.Mutex
+	loaded  map[string]*release
+	group   singleflight.Group
+}
+
+type release struct {
+	id     string
+	static *staticHandler
+	stop   context.CancelFunc
+}
+
+// openReleases loads the current release of the releases in dir.
+func openReleases(env siteEnv, cfg config, dir string, policy *cachePolicy, suffix string) (*releaseSet, error) {
+	s := &releaseSet{env: env, cfg: cfg, dir: dir, policy: policy, suffix: suffix, loaded: map[string]*release{}}
+	id, err := s.currentID()
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(r)
+	log.Printf("📦 Release %s is current%s", id, suffix)
+	return s, nil
+}
+
+// currentID returns the release the current symlink points to.
+func (s *releaseSet) currentID() (string, error) {
+	target, err := filepath.EvalSymlinks(filepath.Join(s.dir, currentLink))
+	if err != nil {
+		return "", err
+	}
+	releases, err := filepath.EvalSymlinks(filepath.Join(s.dir, releasesDir))
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(target) != releases {
+		return "", fmt.Errorf("%s doesn't point into %s", filepath.Join(s.dir, currentLink), filepath.Join(s.dir, releasesDir))
+	}
+	return filepath.Base(target), nil
+}
+
+// load returns the release called id, setting up its handler the first
+// time: ETags, precompression and the rest, as for dist/.
+func (s *releaseSet) load(id string) (*release, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, fs.ErrNotExist
+	}
+	v, err, _ := s.group.Do(id, func() (any, error) {
+		s.mu.Lock()
+		r := s.loaded[id]
+		s.mu.Unlock()
+		if r != nil {
+			return r, nil
+		}
+		dir := filepath.Join(s.dir, releasesDir, id)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, fs.ErrNotExist
+		}
+		ctx, stop := context.WithCancel(s.env.background)
+		env, cfg := s.env, s.cfg
+		env.background = ctx
+		if cfg.PrecompressDir != "" {
+			cfg.PrecompressDir = filepath.Join(cfg.PrecompressDir, releasesDir, id)
+		}
+		static, err := newStatic(env, cfg, os.DirFS(dir), dir, false, s.policy, s.suffix+", release "+id)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		r = &release{id: id, static: static, stop: stop}
+		s.mu.Lock()
+		s.loaded[id] = r
+		s.mu.Unlock()
+		return r, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*release), nil
+}
+
+// watch swaps to a new release once the current symlink points to it and
+// it is ready to serve, and forgets releases removed from disk, until ctx
+// is done.
+func (s *releaseSet) watch(ctx context.Context) {
+	ticker := time.NewTicker(releaseCheckInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := s.check()
+		if err != nil && !failing {
+			log.Printf("releases: %v", err)
+		} else if err == nil && failing {
+			log.Printf("releases: %s is readable again", filepath.Join(s.dir, currentLink))
+		}
+		failing = err != nil
+	}
+}
+
+func (s *releaseSet) check() error {
+	id, err := s.currentID()
+	if err != nil {
+		return err
+	}
+	if old := s.current.Load(); id != old.id {
+		r, err := s.load(id)
+		if err != nil {
+			return fmt.Errorf("release %s: %w", id, err)
+		}
+		s.current.Store(r)
+		log.Printf("📦 Swapped to release %s from %s%s", id, old.id, s.suffix)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, r := range s.loaded {
+		if _, err := os.Stat(filepath.Join(s.dir, releasesDir, id)); !os.IsNotExist(err) || r == s.current.Load() {
+			continue
+		}
+		r.stop()
+		delete(s.loaded, id)
+		if s.cfg.PrecompressDir != "" {
+			os.RemoveAll(filepath.Join(s.cfg.PrecompressDir, releasesDir, id))
+		}
+		log.Printf("🗑️  Release %s was removed%s", id, s.suffix)
+	}
+	return nil
+}
+
+func (s *releaseSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cur := s.current.Load()
+	name := fsName(r.URL.Path)
+	if path.Ext(name) == "" || path.Ext(name) == ".html" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     releaseCookie,
+			Value:    cur.id,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   s.env.proxies.scheme(r) == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		cur.static.ServeHTTP(w, r)
+		return
+	}
+	rel := cur
+	if c, err := r.Cookie(releaseCookie); err == nil && c.Value != cur.id {
+		if pinned, err := s.load(c.Value); err == nil {
+			rel = pinned
+		}
+	}
+	if !rel.static.isFile(name) {
+		// Another tab may have loaded a newer or older release since;
+		// hashed names are the same in whichever has them.
+		rel = s.find(name, cur)
+	}
+	if rel != cur {
+		pinnedResponses.inc()
+	}
+	rel.static.ServeHTTP(w, r)
+}
+
+// find returns the release with name in it, preferring cur.
+func (s *releaseSet) find(name string, cur *release) *release {
+	if cur.static.isFile(name) {
+		return cur
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.loaded {
+		if r.static.isFile(name) {
+			return r
+		}
+	}
+	return cur
+}
+
+func (s *releaseSet) served() (fs.FS, *etagManifest) {
+	return s.current.Load().static.served()
+}
+
+// isFile reports whether name is in the current release.
+func (s *releaseSet) isFile(name string) bool {
+	return s.current.Load().static.isFile(name)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+var configReloads = defaultRegistry.counter("loderunner_config_reloads_total",
+	"Configuration reloads on SIGHUP or from the moderation API, by outcome.", "outcome")
+
+// reloadable are the flags a reload applies. The rest, such as listeners,
+// TLS, the database, -site and CORS, are read once at startup; a reload
+// that changes them says they need a restart and leaves them as they are.
+var reloadable = []string{
+	"log-level", "max-body-bytes",
+	"cache-policy", "cache-rule", "hashed-pattern", "unhashed-max-age", "surrogate-key",
+	"rate-limit", "rate-limit-rule",
+	"challenge", "challenge-rule", "challenge-bits", "challenge-site-key", "challenge-secret",
+	"allow-ips", "deny-ips", "country-header", "allow-countries", "deny-countries",
+	"gate-password", "gate-user", "gate-mode",
+	"cross-origin-isolation", "coep", "security-headers", "csp", "frame-ancestors", "hsts-max-age",
+}
+
+// reloaded returns c with next's values for the reloadable flags.
+func (c config) reloaded(next config) config {
+	c.LogLevel, c.MaxBodyBytes = next.LogLevel, next.MaxBodyBytes
+	c.CachePolicyFile, c.CacheRules, c.SurrogateKeys = next.CachePolicyFile, next.CacheRules, next.SurrogateKeys
+	c.HashedPattern, c.UnhashedMaxAge = next.HashedPattern, next.UnhashedMaxAge
+	c.RateLimit, c.RateLimitRules = next.RateLimit, next.RateLimitRules
+	c.Challenge, c.ChallengeRules, c.ChallengeBits = next.Challenge, next.ChallengeRules, next.ChallengeBits
+	c.ChallengeSiteKey, c.ChallengeSecret = next.ChallengeSiteKey, next.ChallengeSecret
+	c.AllowIPs, c.DenyIPs, c.CountryHeader = next.AllowIPs, next.DenyIPs, next.CountryHeader
+	c.AllowCountries, c.DenyCountries = next.AllowCountries, next.DenyCountries
+	c.GatePassword, c.GateUser, c.GateMode = next.GatePassword, next.GateUser, next.GateMode
+	c.IsolatedPaths, c.COEP = next.IsolatedPaths, next.COEP
+	c.SecurityHeaders, c.CSP, c.FrameAncestors, c.HSTSMaxAge = next.SecurityHeaders, next.CSP, next.FrameAncestors, next.HSTSMaxAge
+	return c
+}
+
+// flagValues returns the value of every flag in fs, by name.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values
+}
+
+// edge is the middleware in front of the sites, built from one
+// configuration.
+type edge struct {
+	handler  http.Handler
+	limiter  *rateLimiter // nil without -rate-limit
+	ipFilter *ipFilter    // nil without an address or country list
+}
+
+// reloader serves requests through the middleware built from the running
+// configuration, and on SIGHUP or POST /api/admin/reload reads the
+// configuration again and swaps in middleware and cache policies built
+// from it. Nothing is swapped unless all of it is valid. Requests in
+// flight finish on the middleware they started with, and WebSocket
+// connections, with the matches on them, are left alone.
+type reloader struct {
+	mu      sync.Mutex        // one reload at a time
+	cfg     config            // running now
+	args    []string          // the command line, parsed again by each reload
+	running map[string]string // the value in effect of every flag
+
+	sites       *siteRouter
+	siteConfigs []siteConfig
+	proxies     trustedProxies
+	shared      ephemeral.Store
+	cors        *corsPolicy // nil without -cors-origins
+	proxyRoutes []proxyRoute
+
+	current atomic.Pointer[edge]
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.current.Load().handler.ServeHTTP(w, r)
+}
+
+// limiter returns the rate limiter in use, nil without -rate-limit.
+func (rl *reloader) limiter() *rateLimiter {
+	return rl.current.Load().limiter
+}
+
+// ipFilter returns the IP filter in use, nil without an address or
+// country list.
+func (rl *reloader) ipFilter() *ipFilter {
+	return rl.current.Load().ipFilter
+}
+
+// start builds the middleware for the configuration the server started
+// with.
+func (rl *reloader) start() error {
+	e, err := rl.build(rl.cfg)
+	if err != nil {
+		return err
+	}
+	rl.current.Store(e)
+	return nil
+}
+
+// build puts together the middleware for cfg, innermost first.
+func (rl *reloader) build(cfg config) (*edge, error) {
+	e := &edge{}
+	var err error
+	if cfg.RateLimit && !cfg.Dev {
+		if e.limiter, err = newRateLimiter(cfg, rl.proxies, rl.shared); err != nil {
+			return nil, err
+		}
+	}
+	var challenges *challengeGate
+	if !cfg.Dev {
+		if challenges, err = newChallengeGate(cfg, rl.proxies, rl.shared); err != nil {
+			return nil, err
+		}
+	}
+	if e.ipFilter, err = newIPFilter(cfg, rl.proxies); err != nil {
+		return nil, err
+	}
+
+	var handler http.Handler = rl.sites
+	if len(rl.proxyRoutes) > 0 {
+		handler = withProxies(rl.proxyRoutes, handler)
+		for _, route := range rl.proxyRoutes {
+			log.Printf("🔀 Proxying %s to %s", route.prefix, route.target)
+		}
+	}
+	if cfg.GatePassword != "" {
+		handler = withGate(newGate(cfg, rl.proxies), handler)
+		log.Printf("🔒 Password gate enabled (%s)", cfg.GateMode)
+	}
+	handler = limitBodies(int64(cfg.MaxBodyBytes), handler)
+	if challenges != nil {
+		handler = requireChallenges(challenges, handler)
+		log.Printf("🧩 Asking clients over %d soft limits to solve a %s challenge", len(challenges.rules), cfg.Challenge)
+	}
+	if e.limiter != nil {
+		handler = rateLimit(e.limiter, handler)
+		log.Printf("🚦 Rate limiting %d rules", len(e.limiter.rules))
+	}
+	if rl.cors != nil {
+		handler = withCORS(rl.cors, handler)
+		log.Printf("🌐 CORS enabled for %s", strings.Join(rl.cors.origins, ", "))
+	}
+	if e.ipFilter != nil {
+		handler = filterIPs(e.ipFilter, handler)
+		log.Printf("🚧 IP filter enabled")
+	}
+	handler = gzipHandler(handler)
+	handler = crossOriginIsolation(cfg.IsolatedPaths, cfg.COEP, handler)
+	if cfg.SecurityHeaders {
+		handler = securityHeaders(newSecurityHeaders(cfg), handler)
+	}
+	e.handler = handler
+	return e, nil
+}
+
+// reload reads the command line, the environment and the -config file
+// again and applies the reloadable flags. The command line and the
+// environment can't have changed, so in practice that means edits to the
+// file and to the files it points at, such as -cache-policy. It returns
+// the flags that changed, and those that changed but need a restart.
+func (rl *reloader) reload() (changed, restart []string, err error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	changed, restart, err = rl.apply()
+	if err != nil {
+		configReloads.inc("invalid")
+		return nil, nil, err
+	}
+	configReloads.inc("applied")
+	log.Printf("♻️  Reloaded the configuration: %s changed", listOrNone(changed))
+	if len(restart) > 0 {
+		log.Printf("⚠️  %s changed but needs a restart; keeping the old values", strings.Join(restart, ", "))
+	}
+	return changed, restart, nil
+}
+
+func (rl *reloader) apply() (changed, restart []string, err error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	next, err := parseConfig(flags, rl.args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := next.validate(); err != nil {
+		return nil, nil, err
+	}
+	values := flagValues(flags)
+	changed, restart = []string{}, []string{}
+	for name, v := range values {
+		if v == rl.running[name] {
+			continue
+		}
+		if slices.Contains(reloadable, name) {
+			changed = append(changed, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	slices.Sort(changed)
+	slices.Sort(restart)
+
+	cfg := rl.cfg.reloaded(next)
+	policies := make([]*cachePolicy, len(rl.sites.all))
+	for i, st := range rl.sites.all {
+		if st.policy == nil {
+			continue
+		}
+		siteCfg := cfg
+		if i > 0 {
+			siteCfg = rl.siteConfigs[i-1].config(cfg)
+		}
+		if policies[i], err = newCachePolicy(siteCfg); err != nil {
+			if st.name != "" {
+				err = fmt.Errorf("%s: %w", st.name, err)
+			}
+			return nil, nil, err
+		}
+	}
+	e, err := rl.build(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything is valid; swap it in.
+	for i, st := range rl.sites.all {
+		if policies[i] != nil {
+			st.policy.replace(policies[i])
+		}
+	}
+	rl.current.Store(e)
+	setLogLevel(os.Stderr, cfg.LogLevel)
+	rl.cfg = cfg
+	for _, name := range changed {
+		rl.running[name] = values[name]
+	}
+	return changed, restart, nil
+}
+
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, ", ")
+}
+
+// reloadConfig is POST /api/admin/reload, the same as sending the server
+// SIGHUP, for deploy scripts and hosts without signals. It answers with
+// the flags that changed and those that need a restart, or 422, keeping
+// the running configuration, if the new one is invalid.
+func (a *adminAPI) reloadConfig(w http.ResponseWriter, r *http.Request, actor string) {
+	changed, restart, err := a.reloads.reload()
+	if err != nil {
+		log.Printf("reload: %v", err)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	e := storage.AuditEntry{Actor: actor, Action: "config.reload", TargetKind: "server", Detail: strings.Join(changed, ", ")}
+	if err := a.db.RecordAudit(r.Context(), e); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: %s %s %s", e.Actor, e.Action, e.TargetKind, e.TargetID)
+	writeJSON(w, http.StatusOK, map[string]any{"changed": changed, "restart": restart})
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyReload does nothing: there is no SIGHUP, so reloads go through
+// POST /api/admin/reload.
+func notifyReload(c chan<- os.Signal) {}
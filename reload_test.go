@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReloadKeepsConfigOnInvalidCacheRule edits the -config file to hold a
+// cache rule that doesn't parse, as an operator's typo would, and checks
+// that the reload is refused with the running middleware and cache policy
+// left in place.
+func TestReloadKeepsConfigOnInvalidCacheRule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "loderunner.toml")
+	writeFile := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(`cache-rule = ["*.js -> 1h"]` + "\n")
+
+	args := []string{"-config", path}
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	cfg, err := parseConfig(flags, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := newCachePolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := &reloader{cfg: cfg, args: args, running: flagValues(flags), sites: &siteRouter{all: []*site{{policy: policy}}}}
+	if err := rl.start(); err != nil {
+		t.Fatal(err)
+	}
+	before := rl.current.Load()
+	invalid := configReloads.snapshot()[labelKey([]string{"invalid"})]
+
+	writeFile(`cache-rule = ["*.js -> cdn:"]` + "\n")
+	if _, _, err := rl.reload(); err == nil {
+		t.Fatal("reload accepted a cache rule without a duration")
+	}
+	if rl.current.Load() != before {
+		t.Error("reload swapped the middleware")
+	}
+	if got, want := policy.lookup("app.js"), "public, max-age=3600"; got != want {
+		t.Errorf("policy for app.js = %q, want %q", got, want)
+	}
+	if got := rl.cfg.CacheRules; len(got) != 1 || got[0] != "*.js -> 1h" {
+		t.Errorf("running cache rules = %q, want the old ones", got)
+	}
+	if got := configReloads.snapshot()[labelKey([]string{"invalid"})]; got != invalid+1 {
+		t.Errorf("invalid reloads = %v, want %v", got, invalid+1)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	// maxConfigBody caps an edit of the remote config.
+	maxConfigBody = 64 << 10
+
+	// configMaxAge is how long clients and caches may keep the config,
+	// so edits reach every player within about this long.
+	configMaxAge = time.Minute
+
+	configSignatureHeader = "X-Config-Signature"
+)
+
+// remoteConfigAPI serves /api/config: feature flags and gameplay
+// parameters the game reads when it starts, so events can be run without
+// a new build. Moderators edit them through the moderation API.
+//
+// Each response body is signed with an Ed25519 key generated once and
+// kept in the database, so a game pinning the public key can tell the
+// config came from the server and wasn't altered on the way.
+type remoteConfigAPI struct {
+	db  *storage.DB
+	key ed25519.PrivateKey
+}
+
+func newRemoteConfigAPI(db *storage.DB) (*remoteConfigAPI, error) {
+	seed, err := db.Secret(context.Background(), "config", ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteConfigAPI{db: db, key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (a *remoteConfigAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/config", a.get)
+	mux.HandleFunc("GET /api/config/key", a.publicKey)
+}
+
+// get returns the live config, signed in the X-Config-Signature header.
+// Before the first edit it is version 0, with no values. A request with an
+// X-Player-Token also gets the client's variant of each running
+// experiment, which makes the response private to it.
+func (a *remoteConfigAPI) get(w http.ResponseWriter, r *http.Request) {
+	c, err := a.db.CurrentConfig(r.Context())
+	if errors.Is(err, storage.ErrNotFound) {
+		c, err = storage.ConfigVersion{Values: json.RawMessage("{}")}, nil
+	}
+	if err != nil {
+		log.Printf("config: get: %v (request %s)", err, requestID(w))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	var assigned map[string]string
+	if unit := optionalPlayerToken(r); unit != "" {
+		if assigned, err = assignVariants(r, a.db, unit); err != nil {
+			log.Printf("config: experiments: %v (request %s)", err, requestID(w))
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	h := w.Header()
+	etag := "v" + strconv.FormatInt(c.Version, 10)
+	cache := "public"
+	if assigned != nil {
+		b, _ := json.Marshal(assigned)
+		sum := sha256.Sum256(b)
+		etag += "-" + hex.EncodeToString(sum[:4])
+		cache = "private"
+	}
+	etag = `"` + etag + `"`
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", cache+", max-age="+strconv.Itoa(int(configMaxAge.Seconds())))
+	h.Add("Vary", playerTokenHeader)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	doc := map[string]any{"version": c.Version, "config": c.Values}
+	if c.Version > 0 {
+		doc["updated_at"] = c.CreatedAt
+	}
+	if assigned != nil {
+		doc["experiments"] = assigned
+	}
+	body, _ := json.Marshal(doc)
+	body = append(body, '\n')
+	h.Set("Content-Type", "application/json")
+	h.Set(configSignatureHeader, base64.RawURLEncoding.EncodeToString(ed25519.Sign(a.key, body)))
+	w.Write(body)
+}
+
+// publicKey is the key X-Config-Signature verifies with, for pinning in
+// the game.
+func (a *remoteConfigAPI) publicKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, map[string]string{
+		"algorithm":  "ed25519",
+		"public_key": base64.RawURLEncoding.EncodeToString(a.key.Public().(ed25519.PublicKey)),
+	})
+}
+
+// config returns the live config with who set it, for moderators: version
+// 0 with no values before the first edit.
+func (a *adminAPI) config(w http.ResponseWriter, r *http.Request, _ string) {
+	c, err := a.db.CurrentConfig(r.Context())
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSON(w, http.StatusOK, map[string]any{"version": 0, "values": json.RawMessage("{}")})
+		return
+	}
+	if err != nil {
+		a.fail(w, "config", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// configVersions lists the config's history, newest first.
+func (a *adminAPI) configVersions(w http.ResponseWriter, r *http.Request, _ string) {
+	limit, err := queryInt(r, "limit", 20, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1_000_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	versions, total, err := a.db.ConfigVersions(r.Context(), limit, offset)
+	if err != nil {
+		a.fail(w, "config versions", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"versions": versions, "total": total, "limit": limit, "offset": offset})
+}
+
+// setConfig replaces the config's values with a body like
+// {"values": {...}, "note": "..."}, as a new version. If-Match with the
+// version the edit started from makes it conditional.
+func (a *adminAPI) setConfig(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Values json.RawMessage `json:"values"`
+		Note   string          `json:"note"`
+	}
+	if err := decodeJSON(w, r, maxConfigBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	var values map[string]json.RawMessage
+	if json.Unmarshal(body.Values, &values) != nil || values == nil {
+		writeError(w, http.StatusUnprocessableEntity, "values must be a JSON object")
+		return
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, body.Values)
+	a.saveConfig(w, r, storage.ConfigVersion{Values: compact.Bytes(), Note: body.Note, CreatedBy: actor}, "config.update")
+}
+
+// rollbackConfig makes an earlier version's values live again, as a new
+// version, from a body like {"version": 3, "note": "..."}.
+func (a *adminAPI) rollbackConfig(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Version int64  `json:"version"`
+		Note    string `json:"note"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	old, err := a.db.GetConfigVersion(r.Context(), body.Version)
+	if !a.act(w, r, err, "config version not found") {
+		return
+	}
+	a.saveConfig(w, r, storage.ConfigVersion{Values: old.Values, Note: body.Note, RestoredFrom: old.Version, CreatedBy: actor}, "config.rollback")
+}
+
+// saveConfig stores c as the version after the live one, honouring
+// If-Match, and audits it as action.
+func (a *adminAPI) saveConfig(w http.ResponseWriter, r *http.Request, c storage.ConfigVersion, action string) {
+	if err := checkText("note", c.Note, maxReasonLength, false); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	current, err := a.db.CurrentConfig(r.Context())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		a.fail(w, "config", err)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" {
+		v, err := parseVersionETag(match)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "If-Match must be a config ETag such as \"v3\"")
+			return
+		}
+		if v != current.Version {
+			writeError(w, http.StatusPreconditionFailed, "config was changed since version "+strconv.FormatInt(v, 10))
+			return
+		}
+	}
+	c.Version = current.Version + 1
+	c, err = a.db.SaveConfig(r.Context(), c)
+	if errors.Is(err, storage.ErrVersionConflict) {
+		writeError(w, http.StatusConflict, "config was changed at the same time; try again")
+		return
+	}
+	if err != nil {
+		a.fail(w, "save config", err)
+		return
+	}
+	id := strconv.FormatInt(c.Version, 10)
+	e := storage.AuditEntry{Actor: c.CreatedBy, Action: action, TargetKind: "config", TargetID: id, Detail: c.Note, After: c.Values}
+	if current.Version > 0 {
+		e.Before = current.Values
+	}
+	if err := a.db.RecordAudit(r.Context(), e); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: %s config %s", c.CreatedBy, action, id)
+	w.Header().Set("ETag", `"v`+id+`"`)
+	writeJSON(w, http.StatusOK, c)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/replay"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Replay limits. maxReplaySize caps the compressed stream as stored;
+// maxReplayInflated guards against compression bombs when checking it.
+const (
+	maxReplaySize     = 256 << 10
+	maxReplayInflated = 8 << 20
+	maxReplayBody     = maxReplaySize*4/3 + 4<<10 // base64 plus metadata
+	replayTokenHeader = "X-Replay-Token"
+)
+
+// replayUpload is a recorded run: Inputs is the compressed input stream
+// (see package replay for the format), base64-encoded by encoding/json.
+type replayUpload struct {
+	Format int    `json:"format"`
+	Ticks  int64  `json:"ticks"`
+	Inputs []byte `json:"inputs"`
+}
+
+func (u *replayUpload) validate() error {
+	if u.Ticks <= 0 {
+		return errors.New("ticks must be positive")
+	}
+	if len(u.Inputs) == 0 {
+		return errors.New("inputs is required")
+	}
+	if len(u.Inputs) > maxReplaySize {
+		return fmt.Errorf("replay larger than %d bytes compressed", maxReplaySize)
+	}
+	return replay.Validate(u.Format, u.Inputs, maxReplayInflated)
+}
+
+// decodeReplayUpload reads an upload as it arrives, refusing an unknown
+// format, a tick count that isn't positive or an oversized stream as soon
+// as that member is read. validate still checks the whole upload.
+func decodeReplayUpload(w http.ResponseWriter, r *http.Request) (replayUpload, error) {
+	var up replayUpload
+	err := decodeFields(w, r, maxReplayBody, map[string]func(*fieldDecoder) error{
+		"format": func(d *fieldDecoder) error {
+			if err := d.decode(&up.Format); err != nil {
+				return err
+			}
+			if up.Format != replay.Format1 {
+				return fmt.Errorf("unsupported replay format %d", up.Format)
+			}
+			return nil
+		},
+		"ticks": func(d *fieldDecoder) error {
+			if err := d.decode(&up.Ticks); err != nil {
+				return err
+			}
+			if up.Ticks <= 0 {
+				return errors.New("ticks must be positive")
+			}
+			return nil
+		},
+		"inputs": func(d *fieldDecoder) error {
+			if err := d.decode(&up.Inputs); err != nil {
+				return err
+			}
+			if len(up.Inputs) > maxReplaySize {
+				return fmt.Errorf("replay larger than %d bytes compressed", maxReplaySize)
+			}
+			return nil
+		},
+	})
+	return up, err
+}
+
+// replayResponse is the playback document.
+type replayResponse struct {
+	Score     storage.Score `json:"score"`
+	Format    int           `json:"format"`
+	Ticks     int64         `json:"ticks"`
+	Inputs    []byte        `json:"inputs"`
+	Size      int           `json:"size"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// replaysAPI serves /api/scores/{id}/replay.
+type replaysAPI struct {
+	db       *storage.DB
+	verifier *replayVerifier
+	quotas   *quotas
+}
+
+func (a *replaysAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /api/scores/{id}/replay", a.upload)
+	mux.HandleFunc("GET /api/scores/{id}/replay", a.show)
+}
+
+// upload attaches a replay to a score. It needs the replay_token returned
+// when the score was submitted, and each score takes one replay.
+func (a *replaysAPI) upload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	token := r.Header.Get(replayTokenHeader)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, replayTokenHeader+" header is required")
+		return
+	}
+	up, err := decodeReplayUpload(w, r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := up.validate(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	subject, ok := a.quotas.allow(w, r, quotaUpload{kind: storage.QuotaReplays, size: int64(len(up.Inputs)), counted: true})
+	if !ok {
+		return
+	}
+
+	rp, err := a.db.CreateReplay(r.Context(), hashToken(token), storage.Replay{
+		ScoreID: id,
+		Format:  up.Format,
+		Ticks:   up.Ticks,
+		Data:    up.Inputs,
+
+		QuotaSubject: subject,
+	})
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "score not found or wrong replay token")
+		return
+	case errors.Is(err, storage.ErrReplayExists):
+		writeError(w, http.StatusConflict, "score already has a replay")
+		return
+	case err != nil:
+		a.fail(w, "create replay", err)
+		return
+	}
+	a.verifier.notify()
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"score_id":   rp.ScoreID,
+		"status":     storage.StatusPending,
+		"size":       rp.Size,
+		"created_at": rp.CreatedAt,
+	})
+}
+
+// show returns the replay with its score entry. Replays never change once
+// uploaded, so they may be cached.
+func (a *replaysAPI) show(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	rp, err := a.db.GetReplay(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no replay for this score")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get replay", err)
+		return
+	}
+	entry, err := a.db.Score(r.Context(), id)
+	if err != nil {
+		a.fail(w, "get score", err)
+		return
+	}
+	if entry.Rank, err = a.db.ScoreRank(r.Context(), entry); err != nil {
+		a.fail(w, "rank score", err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, replayResponse{
+		Score:     entry,
+		Format:    rp.Format,
+		Ticks:     rp.Ticks,
+		Inputs:    rp.Data,
+		Size:      rp.Size,
+		CreatedAt: rp.CreatedAt,
+	})
+}
+
+func (a *replaysAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("replays: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
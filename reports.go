@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// reportsAPI serves POST /api/reports, where players flag cheated scores
+// and offensive levels for the moderation queue.
+type reportsAPI struct {
+	db *storage.DB
+}
+
+func (a *reportsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/reports", a.report)
+}
+
+func (a *reportsAPI) report(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := playerToken(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Kind   string `json:"kind"`
+		ID     int64  `json:"id"`
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Kind != storage.ReportScore && req.Kind != storage.ReportLevel {
+		writeError(w, http.StatusUnprocessableEntity, "kind must be score or level")
+		return
+	}
+	if err := checkText("reason", req.Reason, maxReasonLength, false); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	err := a.db.CreateReport(r.Context(), req.Kind, req.ID, reporter, req.Reason)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, req.Kind+" not found")
+		return
+	}
+	if err != nil {
+		log.Printf("reports: create report: %v (request %s)", err, requestID(w))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// withBans refuses API writes from banned accounts and player tokens.
+// Reads stay open, and so does /api/auth so a banned player can still sign
+// out. It must run inside withSession to see the player.
+func withBans(db *storage.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions,
+			!strings.HasPrefix(r.URL.Path, "/api/"),
+			strings.HasPrefix(r.URL.Path, "/api/admin/"),
+			strings.HasPrefix(r.URL.Path, "/api/auth/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+		var playerID int64
+		if p := currentPlayer(r); p != nil {
+			playerID = p.ID
+		}
+		banned, err := db.Banned(r.Context(), playerID, optionalPlayerToken(r))
+		if err != nil {
+			// Moderation shouldn't take the API down with it.
+			log.Printf("bans: lookup: %v", err)
+		}
+		if banned {
+			writeError(w, http.StatusForbidden, "banned")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader carries the ID that ties a request to its access log
+// line, error logs and upstream calls. Responses echo it, and API errors
+// repeat it in the body, so players can quote it in bug reports.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds an ID passed in by a trusted proxy in front;
+// longer ones are replaced.
+const maxRequestIDLength = 128
+
+// withRequestID keeps the valid X-Request-ID of a request from a trusted
+// proxy, such as one set by a load balancer, and gives any other request a
+// new one, so clients can't choose the IDs in the logs or make requests
+// share one. The ID is set on the request, so -proxy upstreams and panic
+// reports get it too, and on the response.
+func withRequestID(proxies trustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !proxies.proxied(r) || !validRequestID(id) {
+			id = newRequestID()
+		}
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id is short and safe to put in a log
+// line: letters, digits and a little punctuation, as in UUIDs and the IDs
+// load balancers generate.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=' || c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request being answered through w.
+func requestID(w http.ResponseWriter) string {
+	return w.Header().Get(requestIDHeader)
+}
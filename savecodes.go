@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// A save code is saveCodeLength characters of shortCodeAlphabet, the last
+// a check character, shown in groups of four like "bcdf-ghjk-mnp2". The
+// check catches any one mistyped character, and most swapped neighbours,
+// before the lookup, so the player is told to look again rather than
+// that the code doesn't exist.
+const (
+	saveCodeLength = 12
+	saveCodeGroup  = 4
+	saveCodeTTL    = 24 * time.Hour
+)
+
+var (
+	errSaveCodeFormat = errors.New("save codes are 12 letters and digits")
+	errSaveCodeTypo   = errors.New("that save code has a typo; check it and try again")
+)
+
+// createCode stores a copy of the save in the body under a new save code,
+// for the player to type in on another device within saveCodeTTL. Unlike
+// an X-Save-Token, the code doesn't keep the devices in sync; it carries
+// the save over once, without an account.
+func (a *savesAPI) createCode(w http.ResponseWriter, r *http.Request) {
+	data, size, ok := readSaveBody(w, r)
+	if !ok {
+		return
+	}
+	expires := time.Now().Add(saveCodeTTL)
+	for range shortCodeAttempts {
+		code := newSaveCode()
+		err := a.db.CreateSaveCode(r.Context(), hashToken(code), data, size, expires)
+		if errors.Is(err, storage.ErrSaveCodeTaken) {
+			continue
+		}
+		if err != nil {
+			a.fail(w, "create save code", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"code":       formatSaveCode(code),
+			"expires_at": expires.UTC(),
+		})
+		return
+	}
+	a.fail(w, "create save code", errors.New("no free save code"))
+}
+
+// redeemCode returns the save stored under the code in a body like
+// {"code": "bcdf-ghjk-mnp2"}. A code works once.
+func (a *savesAPI) redeemCode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	code, err := parseSaveCode(body.Code)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	save, err := a.db.RedeemSaveCode(r.Context(), hashToken(code))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "save code not found; it may have expired or been used")
+		return
+	}
+	if err != nil {
+		a.fail(w, "redeem save code", err)
+		return
+	}
+	a.write(w, r, save)
+}
+
+// newSaveCode returns a random save code, without dashes, which may be
+// taken.
+func newSaveCode() string {
+	code := randomCode(saveCodeLength - 1)
+	return code + string(saveCodeCheck(code))
+}
+
+// saveCodeCheck returns the check character for code, by the Luhn mod N
+// algorithm over shortCodeAlphabet.
+func saveCodeCheck(code string) byte {
+	n := len(shortCodeAlphabet)
+	sum, factor := 0, 2
+	for i := len(code) - 1; i >= 0; i-- {
+		d := factor * strings.IndexByte(shortCodeAlphabet, code[i])
+		sum += d/n + d%n
+		factor = 3 - factor
+	}
+	return shortCodeAlphabet[(n-sum%n)%n]
+}
+
+// parseSaveCode reads a save code as a player typed it, in any case and
+// with or without dashes and spaces.
+func parseSaveCode(s string) (string, error) {
+	code := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(s))
+	if len(code) != saveCodeLength {
+		return "", errSaveCodeFormat
+	}
+	for i := range len(code) {
+		if strings.IndexByte(shortCodeAlphabet, code[i]) < 0 {
+			return "", errSaveCodeTypo
+		}
+	}
+	if saveCodeCheck(code[:saveCodeLength-1]) != code[saveCodeLength-1] {
+		return "", errSaveCodeTypo
+	}
+	return code, nil
+}
+
+// formatSaveCode splits a save code into dashed groups for display.
+func formatSaveCode(code string) string {
+	var groups []string
+	for len(code) > saveCodeGroup {
+		groups = append(groups, code[:saveCodeGroup])
+		code = code[saveCodeGroup:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+// expireSaveCodes deletes save codes past their expiry every hour, until
+// ctx is done.
+func expireSaveCodes(ctx context.Context, db *storage.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := db.DeleteExpiredSaveCodes(ctx); err != nil {
+				log.Printf("saves: expire codes: %v", err)
+			} else if n > 0 {
+				log.Printf("💾 Expired %d save codes", n)
+			}
+		}
+	}
+}
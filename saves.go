@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maxSaveSize caps the uncompressed size of a cloud save.
+const maxSaveSize = 256 << 10
+
+// saveTokenHeader carries the opaque token that identifies a cloud save.
+// Only its hash is stored, so a leaked database doesn't leak saves.
+const saveTokenHeader = "X-Save-Token"
+
+// savesAPI serves /api/saves: players sync progress across devices with a
+// token the server issues on first upload, or carry it over once with a
+// save code.
+type savesAPI struct {
+	db     *storage.DB
+	quotas *quotas
+}
+
+func (a *savesAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/saves", a.create)
+	mux.HandleFunc("GET /api/saves", a.get)
+	mux.HandleFunc("PUT /api/saves", a.put)
+	mux.HandleFunc("POST /api/saves/codes", a.createCode)
+	mux.HandleFunc("POST /api/saves/codes/redeem", a.redeemCode)
+}
+
+// create stores a new save and returns its token. The token is only ever
+// shown in this response. A signed-in player's save is also tied to their
+// account, so other devices can reach it by signing in.
+func (a *savesAPI) create(w http.ResponseWriter, r *http.Request) {
+	data, size, ok := readSaveBody(w, r)
+	if !ok {
+		return
+	}
+	var playerID int64
+	if p := currentPlayer(r); p != nil {
+		playerID = p.ID
+	}
+	subject, ok := a.quotas.allow(w, r, quotaUpload{kind: storage.QuotaSaves, size: int64(len(data)), counted: true})
+	if !ok {
+		return
+	}
+	token := randomToken(24)
+	save, err := a.db.CreateSave(r.Context(), hashToken(token), playerID, subject, data, size)
+	if errors.Is(err, storage.ErrPlayerHasSave) {
+		writeError(w, http.StatusConflict, "account already has a save; use PUT")
+		return
+	}
+	if err != nil {
+		a.fail(w, "create save", err)
+		return
+	}
+	setSaveHeaders(w, save)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":      token,
+		"version":    save.Version,
+		"updated_at": save.UpdatedAt.UTC(),
+	})
+}
+
+// get returns the save JSON.
+func (a *savesAPI) get(w http.ResponseWriter, r *http.Request) {
+	save, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+	setSaveHeaders(w, save)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == saveETag(save) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	a.write(w, r, save)
+}
+
+// write responds with the save's JSON. Clients that accept gzip get the
+// stored bytes as-is.
+func (a *savesAPI) write(w http.ResponseWriter, r *http.Request, save storage.Save) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "no-store")
+	if acceptsEncoding(r, "gzip") {
+		h.Set("Content-Encoding", "gzip")
+		h.Set("Content-Length", strconv.Itoa(len(save.Data)))
+		w.Write(save.Data)
+		return
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(save.Data))
+	if err != nil {
+		a.fail(w, "decompress save", err)
+		return
+	}
+	h.Set("Content-Length", strconv.FormatInt(save.Size, 10))
+	io.Copy(w, zr)
+}
+
+// put replaces the save. Sending If-Match with the version the client last
+// saw makes the write conditional; a stale version gets 412 along with the
+// current version so the client can merge or ask the player. Without
+// If-Match the last write wins.
+func (a *savesAPI) put(w http.ResponseWriter, r *http.Request) {
+	var ifVersion int64
+	if match := r.Header.Get("If-Match"); match != "" {
+		v, err := parseVersionETag(match)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "If-Match must be a save ETag such as \"v3\"")
+			return
+		}
+		ifVersion = v
+	}
+	key, ok := a.saveKey(w, r)
+	if !ok {
+		return
+	}
+	data, size, ok := readSaveBody(w, r)
+	if !ok {
+		return
+	}
+	up := quotaUpload{kind: storage.QuotaSaves, size: int64(len(data))}
+	if a.quotas.storage > 0 {
+		old, err := a.db.GetSave(r.Context(), key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			a.fail(w, "get save", err)
+			return
+		}
+		up.freed, up.owner = int64(len(old.Data)), old.QuotaSubject
+	}
+	subject, ok := a.quotas.allow(w, r, up)
+	if !ok {
+		return
+	}
+
+	save, err := a.db.PutSave(r.Context(), key, subject, data, size, ifVersion)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "save not found")
+		return
+	case errors.Is(err, storage.ErrVersionConflict):
+		setSaveHeaders(w, save)
+		writeJSON(w, http.StatusPreconditionFailed, map[string]any{
+			"error":      "save was changed on another device",
+			"version":    save.Version,
+			"updated_at": save.UpdatedAt.UTC(),
+		})
+		return
+	case err != nil:
+		a.fail(w, "put save", err)
+		return
+	}
+	// Writing an anonymous save while signed in adopts it into the account.
+	if p := currentPlayer(r); p != nil && r.Header.Get(saveTokenHeader) != "" {
+		if err := a.db.LinkSave(r.Context(), key, p.ID); err != nil {
+			log.Printf("saves: link save: %v", err)
+		}
+	}
+	setSaveHeaders(w, save)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":    save.Version,
+		"updated_at": save.UpdatedAt.UTC(),
+	})
+}
+
+// saveKey returns the stored key of the save a request addresses: the
+// X-Save-Token header if present, otherwise the signed-in player's save.
+func (a *savesAPI) saveKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if token := r.Header.Get(saveTokenHeader); token != "" {
+		return hashToken(token), true
+	}
+	p := currentPlayer(r)
+	if p == nil {
+		writeError(w, http.StatusUnauthorized, saveTokenHeader+" header or sign-in is required")
+		return "", false
+	}
+	key, err := a.db.PlayerSaveToken(r.Context(), p.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "save not found")
+		return "", false
+	}
+	if err != nil {
+		a.fail(w, "get player save", err)
+		return "", false
+	}
+	return key, true
+}
+
+func (a *savesAPI) lookup(w http.ResponseWriter, r *http.Request) (storage.Save, bool) {
+	key, ok := a.saveKey(w, r)
+	if !ok {
+		return storage.Save{}, false
+	}
+	save, err := a.db.GetSave(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "save not found")
+		return storage.Save{}, false
+	}
+	if err != nil {
+		a.fail(w, "get save", err)
+		return storage.Save{}, false
+	}
+	return save, true
+}
+
+func (a *savesAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("saves: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// readSaveBody reads and validates a JSON object body and returns it
+// gzip-compressed along with its uncompressed size.
+func readSaveBody(w http.ResponseWriter, r *http.Request) ([]byte, int64, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSaveSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, errSaveTooLarge.Error())
+		return nil, 0, false
+	}
+	data, err := compressSave(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, 0, false
+	}
+	return data, int64(len(body)), true
+}
+
+var (
+	errSaveTooLarge = errors.New("save larger than " + strconv.Itoa(maxSaveSize) + " bytes")
+	errSaveNotJSON  = errors.New("save must be a JSON object")
+)
+
+// compressSave checks that body is a JSON object and gzips it for
+// storage.
+func compressSave(body []byte) ([]byte, error) {
+	if len(body) > maxSaveSize {
+		return nil, errSaveTooLarge
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, errSaveNotJSON
+	}
+
+	var buf bytes.Buffer
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(&buf)
+	gz.Write(body)
+	gz.Close()
+	gz.Reset(io.Discard)
+	gzipWriterPool.Put(gz)
+	return buf.Bytes(), nil
+}
+
+func setSaveHeaders(w http.ResponseWriter, s storage.Save) {
+	w.Header().Set("ETag", saveETag(s))
+	w.Header().Set("Last-Modified", s.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+func saveETag(s storage.Save) string {
+	return `"v` + strconv.FormatInt(s.Version, 10) + `"`
+}
+
+// parseVersionETag reads the version from an ETag like "v3", as saves and
+// the remote config have.
+func parseVersionETag(tag string) (int64, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	v, err := strconv.ParseInt(strings.TrimPrefix(strings.Trim(tag, `"`), "v"), 10, 64)
+	if err == nil && v <= 0 {
+		err = errors.New("version must be positive")
+	}
+	return v, err
+}
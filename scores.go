@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Limits for submitted scores. They only reject obviously bogus input;
+// nothing here proves a run actually happened.
+const (
+	maxPlayerName = 16
+	maxLevel      = 9999
+	maxScore      = 100_000_000
+	maxRunTime    = 24 * time.Hour
+	maxSeedLength = 32
+	maxPageSize   = 100
+)
+
+var difficulties = map[string]bool{"easy": true, "normal": true, "hard": true, "ninja": true}
+
+type scoreSubmission struct {
+	Player     string `json:"player"`
+	Level      int    `json:"level"`
+	Difficulty string `json:"difficulty"`
+	Seed       string `json:"seed"`
+	Score      int64  `json:"score"`
+	Time       int64  `json:"time"`
+
+	// With -score-signing, from POST /api/scores/tokens.
+	PlayToken string `json:"play_token"`
+	Signature string `json:"signature"`
+}
+
+// normalize trims the submission and reports the first invalid field.
+func (s *scoreSubmission) normalize() error {
+	s.Player = strings.TrimSpace(s.Player)
+	s.Difficulty = strings.ToLower(strings.TrimSpace(s.Difficulty))
+	s.Seed = strings.TrimSpace(s.Seed)
+
+	switch n := utf8.RuneCountInString(s.Player); {
+	case n == 0:
+		return errors.New("player is required")
+	case n > maxPlayerName:
+		return fmt.Errorf("player must be at most %d characters", maxPlayerName)
+	case strings.IndexFunc(s.Player, unicode.IsControl) >= 0:
+		return errors.New("player contains control characters")
+	}
+	if s.Level < 1 || s.Level > maxLevel {
+		return fmt.Errorf("level must be between 1 and %d", maxLevel)
+	}
+	if s.Difficulty != "" && !difficulties[s.Difficulty] {
+		return errors.New("difficulty must be easy, normal, hard or ninja")
+	}
+	if len(s.Seed) > maxSeedLength {
+		return fmt.Errorf("seed must be at most %d characters", maxSeedLength)
+	}
+	if s.Score < 0 || s.Score > maxScore {
+		return fmt.Errorf("score must be between 0 and %d", maxScore)
+	}
+	if s.Time <= 0 || s.Time > maxRunTime.Milliseconds() {
+		return fmt.Errorf("time must be between 1 and %d milliseconds", maxRunTime.Milliseconds())
+	}
+	return nil
+}
+
+// score converts a validated submission to a storage row.
+func (s scoreSubmission) score() storage.Score {
+	return storage.Score{
+		Player:     s.Player,
+		Level:      s.Level,
+		Difficulty: s.Difficulty,
+		Seed:       s.Seed,
+		Score:      s.Score,
+		Time:       s.Time,
+	}
+}
+
+// scoresAPI serves /api/scores.
+type scoresAPI struct {
+	db    *storage.DB
+	daily *dailyChallenge
+	feed  *scoreFeed
+	push  *pushSender
+
+	announce *announcer  // nil without -announce-webhook
+	tokens   *playTokens // nil without -score-signing
+	filter   *contentFilter
+}
+
+func (a *scoresAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/scores", a.submit)
+	mux.HandleFunc("GET /api/scores", a.list)
+	mux.HandleFunc("GET /api/scores/stream", a.stream)
+	mux.HandleFunc("GET /api/scores/{id}", a.show)
+	if a.tokens != nil {
+		mux.HandleFunc("POST /api/scores/tokens", a.playToken)
+	}
+}
+
+func (a *scoresAPI) submit(w http.ResponseWriter, r *http.Request) {
+	var sub scoreSubmission
+	if err := decodeJSON(w, r, maxJSONBody, &sub); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	player := currentPlayer(r)
+	if player != nil {
+		sub.Player = player.DisplayName
+	}
+	if err := sub.normalize(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	// An account's name was checked when it signed up.
+	if player == nil && !a.filter.allow(w, r, "player", moderation.Name, sub.Player) {
+		return
+	}
+	var nonce string
+	if a.tokens != nil {
+		var err error
+		if nonce, err = a.tokens.check(sub, time.Now()); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	// The replay token lets this client, and only this client, attach a
+	// replay to the run. A deduplicated resubmission doesn't get a new one.
+	replayToken := randomToken(24)
+	row := sub.score()
+	row.ReplayTokenHash = hashToken(replayToken)
+	row.Daily = a.daily.bucket(row.Seed, row.Difficulty)
+	row.PlayerTokenHash = optionalPlayerToken(r)
+	row.PlayNonce = nonce
+	if player != nil {
+		row.PlayerID = player.ID
+	}
+
+	entry, created, err := a.db.InsertScore(r.Context(), row)
+	if errors.Is(err, storage.ErrPlayTokenUsed) {
+		writeError(w, http.StatusConflict, "play_token was already used")
+		return
+	}
+	if err != nil {
+		a.fail(w, "insert score", err)
+		return
+	}
+	if entry.Rank, err = a.db.ScoreRank(r.Context(), entry); err != nil {
+		a.fail(w, "rank score", err)
+		return
+	}
+	if entry.SeasonRank, err = a.db.SeasonRank(r.Context(), entry); err != nil {
+		a.fail(w, "rank season score", err)
+		return
+	}
+	if entry.Daily != "" {
+		if entry.DailyRank, err = a.db.DailyRank(r.Context(), entry); err != nil {
+			a.fail(w, "rank daily score", err)
+			return
+		}
+	}
+
+	if !created {
+		writeJSON(w, http.StatusOK, entry)
+		return
+	}
+	levelRank, err := a.db.LevelRank(r.Context(), entry)
+	if err != nil {
+		a.fail(w, "rank level score", err)
+		return
+	}
+	a.feed.announce(entry, levelRank)
+	if levelRank == 1 {
+		a.push.recordBeaten(r.Context(), entry)
+	}
+	if entry.Rank == 1 && a.announce != nil {
+		a.announce.record(entry)
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		storage.Score
+		ReplayToken string `json:"replay_token"`
+	}{entry, replayToken})
+}
+
+// list returns the top scores, of all time or one season, globally or for
+// one level and/or difficulty, optionally only replay-verified ones,
+// paginated with limit and offset.
+func (a *scoresAPI) list(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", 10, 1, maxPageSize)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1<<30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	level, err := queryInt(r, "level", 0, 1, maxLevel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	season, err := querySeason(r, a.db)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f, err := scoreFilter(level, r.URL.Query().Get("difficulty"), r.URL.Query().Get("verified") == "true", season)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := a.db.TopScores(r.Context(), f, limit, offset)
+	if err != nil {
+		a.fail(w, "list scores", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"season": season,
+		"scores": entries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// scoreFilter checks a leaderboard's difficulty, as the JSON and gRPC APIs
+// take it.
+func scoreFilter(level int, difficulty string, verified bool, season int64) (storage.ScoreFilter, error) {
+	f := storage.ScoreFilter{
+		Level:      level,
+		Difficulty: strings.ToLower(difficulty),
+		Verified:   verified,
+		Season:     season,
+	}
+	if f.Difficulty != "" && !difficulties[f.Difficulty] {
+		return f, errors.New("difficulty must be easy, normal, hard or ninja")
+	}
+	return f, nil
+}
+
+func (a *scoresAPI) show(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	entry, err := rankedScore(r.Context(), a.db, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "score not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get score", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// rankedScore returns a score with its global rank.
+func rankedScore(ctx context.Context, db *storage.DB, id int64) (storage.Score, error) {
+	entry, err := db.Score(ctx, id)
+	if err != nil {
+		return entry, err
+	}
+	entry.Rank, err = db.ScoreRank(ctx, entry)
+	return entry, err
+}
+
+func (a *scoresAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("scores: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
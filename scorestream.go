@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	// feedHistory is how many recent events a reconnecting client can
+	// catch up on with Last-Event-ID.
+	feedHistory = 256
+
+	// feedQueue is how far a client may fall behind before it is dropped;
+	// EventSource reconnects and catches up from the history.
+	feedQueue = 32
+
+	maxStreamClients   = 1000
+	streamHeartbeat    = 25 * time.Second
+	streamWriteTimeout = 2 * streamHeartbeat
+	streamRetry        = 3 * time.Second
+
+	// feedTopRanks is how far up a leaderboard a score must place to be
+	// announced.
+	feedTopRanks = 10
+)
+
+// Score stream event types.
+const (
+	eventRecord = "record" // new best score on a level
+	eventTop    = "top10"  // new entry in a level's top ten
+	eventDaily  = "daily"  // new entry in today's daily top ten
+)
+
+type scoreEvent struct {
+	ID    uint64
+	Type  string
+	Level int
+	Data  []byte
+}
+
+// scoreFeed fans leaderboard events out to /api/scores/stream clients and
+// keeps the last few for clients that reconnect.
+type scoreFeed struct {
+	mu      sync.Mutex
+	next    uint64
+	history []scoreEvent
+	subs    map[chan scoreEvent]struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// newScoreFeed numbers events from the current time in milliseconds, so
+// IDs keep growing across restarts and a client's Last-Event-ID from the
+// previous run never hides new events.
+func newScoreFeed() *scoreFeed {
+	return &scoreFeed{
+		next: uint64(time.Now().UnixMilli()),
+		subs: map[chan scoreEvent]struct{}{},
+		done: make(chan struct{}),
+	}
+}
+
+// publish sends an event to every subscriber without blocking. Clients
+// whose queue is full are disconnected.
+func (f *scoreFeed) publish(typ string, level, rank int, s storage.Score) {
+	data, err := json.Marshal(map[string]any{"type": typ, "level": level, "rank": rank, "score": s})
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	ev := scoreEvent{ID: f.next, Type: typ, Level: level, Data: data}
+	f.history = append(f.history, ev)
+	if len(f.history) > feedHistory {
+		f.history = f.history[len(f.history)-feedHistory:]
+	}
+	for ch := range f.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(f.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// announce publishes the events a newly submitted score earns.
+func (f *scoreFeed) announce(s storage.Score, levelRank int) {
+	switch {
+	case levelRank == 1:
+		f.publish(eventRecord, s.Level, levelRank, s)
+	case levelRank <= feedTopRanks:
+		f.publish(eventTop, s.Level, levelRank, s)
+	}
+	if s.Daily != "" && s.DailyRank <= feedTopRanks {
+		f.publish(eventDaily, s.Level, s.DailyRank, s)
+	}
+}
+
+// subscribe registers a client and returns the buffered events after
+// lastID. The channel is closed if the client falls behind or the feed
+// shuts down. ok is false when the feed is full.
+func (f *scoreFeed) subscribe(lastID uint64) (backlog []scoreEvent, ch chan scoreEvent, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed || len(f.subs) >= maxStreamClients {
+		return nil, nil, false
+	}
+	if lastID > 0 {
+		for _, ev := range f.history {
+			if ev.ID > lastID {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	ch = make(chan scoreEvent, feedQueue)
+	f.subs[ch] = struct{}{}
+	return backlog, ch, true
+}
+
+func (f *scoreFeed) unsubscribe(ch chan scoreEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[ch]; ok {
+		delete(f.subs, ch)
+		close(ch)
+	}
+}
+
+// clients returns the number of connected subscribers.
+func (f *scoreFeed) clients() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+// close ends every stream so a graceful shutdown doesn't wait for them.
+func (f *scoreFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+	}
+}
+
+// stream serves GET /api/scores/stream as Server-Sent Events. level
+// (repeatable or comma-separated) limits the stream to those levels, and
+// Last-Event-ID, or last_event_id for clients that can't set headers,
+// replays what was missed while disconnected.
+func (a *scoresAPI) stream(w http.ResponseWriter, r *http.Request) {
+	levels := map[int]bool{}
+	for _, v := range r.URL.Query()["level"] {
+		for _, s := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 1 || n > maxLevel {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("level must be between 1 and %d", maxLevel))
+				return
+			}
+			levels[n] = true
+		}
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(last, 10, 64)
+
+	backlog, ch, ok := a.feed.subscribe(lastID)
+	if !ok {
+		w.Header().Set("Retry-After", "10")
+		writeError(w, http.StatusServiceUnavailable, "too many stream clients")
+		return
+	}
+	defer a.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	// The stream outlives -read-timeout and -write-timeout; each flush only
+	// has to get through in time.
+	rc := streaming(w, streamWriteTimeout)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+
+	send := func(ev scoreEvent) {
+		if len(levels) == 0 || levels[ev.Level] {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data)
+		}
+	}
+	for _, ev := range backlog {
+		send(ev)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			send(ev)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		case <-a.feed.done:
+			return
+		}
+		extendWrite(rc, streamWriteTimeout)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jgbrwn/loderunner2099/internal/cron"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const maxSeasonName = 64
+
+// rollSeasons starts a new season each time schedule fires after the
+// current one began, until ctx is done. A season that should have ended
+// while the server was down ends at startup.
+func rollSeasons(ctx context.Context, db *storage.DB, schedule *cron.Schedule) {
+	for {
+		wait := time.Minute
+		cur, err := db.CurrentSeason(ctx)
+		if err != nil {
+			log.Printf("seasons: current season: %v", err)
+		} else {
+			wait = time.Until(schedule.Next(cur.StartedAt))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if err != nil {
+			continue
+		}
+		// Another instance, or a moderator, may have been first.
+		s, err := db.StartSeason(ctx, cur.ID, "")
+		switch {
+		case errors.Is(err, storage.ErrSeasonEnded):
+		case err != nil:
+			log.Printf("seasons: start season: %v", err)
+		default:
+			log.Printf("🗓️  %s ended, %s started", cur.Name, s.Name)
+			err := db.RecordAudit(ctx, storage.AuditEntry{
+				Actor: "schedule", Action: "season.start", TargetKind: "season", TargetID: strconv.FormatInt(s.ID, 10),
+				Before: auditState(cur), After: auditState(s),
+			})
+			if err != nil {
+				log.Printf("seasons: record audit: %v", err)
+			}
+		}
+	}
+}
+
+// seasonsAPI serves /api/seasons. Scores themselves are listed per season
+// by GET /api/scores?season=.
+type seasonsAPI struct {
+	db *storage.DB
+}
+
+func (a *seasonsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/seasons", a.list)
+	mux.HandleFunc("GET /api/seasons/current", a.current)
+	mux.HandleFunc("GET /api/seasons/{id}", a.show)
+}
+
+func (a *seasonsAPI) list(w http.ResponseWriter, r *http.Request) {
+	seasons, err := a.db.Seasons(r.Context())
+	if err != nil {
+		a.fail(w, "list seasons", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"seasons": seasons})
+}
+
+func (a *seasonsAPI) current(w http.ResponseWriter, r *http.Request) {
+	s, err := a.db.CurrentSeason(r.Context())
+	if err != nil {
+		a.fail(w, "current season", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *seasonsAPI) show(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s, err := a.db.GetSeason(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "season not found")
+		return
+	}
+	if err != nil {
+		a.fail(w, "get season", err)
+		return
+	}
+	if s.EndedAt != nil {
+		// Archived seasons don't change.
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *seasonsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("seasons: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// querySeason parses the season query parameter: "current", a season ID,
+// or absent or "all" for every season (0).
+func querySeason(r *http.Request, db *storage.DB) (int64, error) {
+	switch v := r.URL.Query().Get("season"); v {
+	case "", "all":
+		return 0, nil
+	case "current":
+		s, err := db.CurrentSeason(r.Context())
+		return s.ID, err
+	default:
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return 0, errors.New("season must be current, all or a season ID")
+		}
+		return id, nil
+	}
+}
+
+// startSeason ends the current season now and starts the next, with an
+// optional {"name": "...", "reason": "..."} body.
+func (a *adminAPI) startSeason(w http.ResponseWriter, r *http.Request, actor string) {
+	var body struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if utf8.RuneCountInString(body.Name) > maxSeasonName {
+		writeError(w, http.StatusUnprocessableEntity, "name must be at most 64 characters")
+		return
+	}
+	cur, err := a.db.CurrentSeason(r.Context())
+	if err != nil {
+		a.fail(w, "current season", err)
+		return
+	}
+	s, err := a.db.StartSeason(r.Context(), cur.ID, body.Name)
+	if errors.Is(err, storage.ErrSeasonEnded) {
+		writeError(w, http.StatusConflict, "the season just ended; try again")
+		return
+	}
+	if err != nil {
+		a.fail(w, "start season", err)
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "season.start", TargetKind: "season", TargetID: strconv.FormatInt(s.ID, 10), Detail: body.Reason, Before: auditState(cur), After: auditState(s)})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCSP suits the Phaser build: scripts only from our origin (plus
+// wasm-unsafe-eval for WebAssembly), inline styles for index.html, and
+// data:/blob: URLs for generated textures and audio. %s is replaced with
+// the frame-ancestors value.
+const defaultCSP = "default-src 'self'; " +
+	"script-src 'self' 'wasm-unsafe-eval'; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; " +
+	"media-src 'self' data: blob:; " +
+	"font-src 'self' data:; " +
+	"connect-src 'self' ws: wss:; " +
+	"worker-src 'self' blob:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"frame-ancestors %s"
+
+// newSecurityHeaders returns the headers added to every response.
+func newSecurityHeaders(cfg config) http.Header {
+	h := http.Header{}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+
+	switch cfg.CSP {
+	case "off":
+	case "":
+		h.Set("Content-Security-Policy", strings.Replace(defaultCSP, "%s", cfg.FrameAncestors, 1))
+	default:
+		h.Set("Content-Security-Policy", cfg.CSP)
+	}
+
+	// X-Frame-Options predates frame-ancestors and only understands these
+	// two values; for an explicit embedder allowlist, CSP alone applies.
+	switch cfg.FrameAncestors {
+	case "'none'":
+		h.Set("X-Frame-Options", "DENY")
+	case "'self'":
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+	}
+
+	if cfg.tlsEnabled() && cfg.HSTSMaxAge > 0 {
+		h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(cfg.HSTSMaxAge/time.Second)))
+	}
+	return h
+}
+
+// securityHeaders sets the given headers before calling next, so individual
+// handlers can still override them.
+func securityHeaders(headers http.Header, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dst := w.Header()
+		for k, v := range headers {
+			dst[k] = slices.Clone(v)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
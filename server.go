@@ -1,62 +1,811 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/jgbrwn/loderunner2099/internal/cron"
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
 )
 
 func main() {
-	port := "8000"
-	if p := os.Getenv("PORT"); p != "" {
-		port = p
-	}
-
-	distDir := "./dist"
-	
-	// Check if dist exists
-	if _, err := os.Stat(distDir); os.IsNotExist(err) {
-		log.Fatal("dist/ directory not found. Run 'npm run build' first.")
-	}
-
-	fs := http.FileServer(http.Dir(distDir))
-	
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-		
-		// Determine caching based on file type
-		ext := strings.ToLower(filepath.Ext(path))
-		
-		switch {
-		case path == "/" || path == "/index.html":
-			// HTML: no cache - always fetch latest
-			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-			w.Header().Set("Pragma", "no-cache")
-			w.Header().Set("Expires", "0")
-			
-		case ext == ".js" || ext == ".css":
-			// JS/CSS with hashes: cache for 1 year (immutable)
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			
-		case ext == ".png" || ext == ".jpg" || ext == ".gif" || ext == ".webp" || ext == ".svg" || ext == ".ico":
-			// Images: cache for 1 week
-			w.Header().Set("Cache-Control", "public, max-age=604800")
-			
-		case ext == ".woff" || ext == ".woff2" || ext == ".ttf" || ext == ".eot":
-			// Fonts: cache for 1 year
-			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			
-		default:
-			// Other files: cache for 1 hour
-			w.Header().Set("Cache-Control", "public, max-age=3600")
-		}
-		
-		fs.ServeHTTP(w, r)
-	})
-
-	log.Printf("🎮 Lode Runner 2099 server running on http://localhost:%s", port)
-	log.Printf("📦 Serving from %s with optimized caching", distDir)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	name := "serve"
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		name = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	flag.Usage = usage
+	run()
+}
+
+// serve runs the server until SIGINT or SIGTERM.
+func serve() {
+	cfg := loadConfig()
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	setLogLevel(os.Stderr, cfg.LogLevel)
+	siteConfigs, _ := parseSites(cfg.Sites)
+
+	accessLogger, err := newAccessLogger(cfg.AccessLog, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies, cfg.ClientIPHeader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shared, err := openShared(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shared.Close()
+	cors, err := newCORSPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxyRoutes, err := newProxyRoutes(cfg, proxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Background workers stop when the servers have drained.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	probes := &health{}
+	build := readBuildInfo()
+	flushTraces, err := setupTracing(cfg, build)
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	if cfg.OTLPEndpoint != "" {
+		log.Printf("🔭 Tracing %g of requests to %s", cfg.TraceSampleRatio, cfg.OTLPEndpoint)
+	}
+	reporter, err := newErrorReporter(cfg, build)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if reporter != nil {
+		go reporter.run()
+		log.Printf("🚨 Reporting panics to %v", reporter.sinks)
+	}
+	announce := newAnnouncer(cfg)
+	if announce != nil {
+		announce.start()
+		log.Printf("📣 Announcing world records and featured levels to %d webhooks", len(announce.hooks))
+	}
+	filter, err := newContentFilter(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if filter != nil && cfg.ModerationURL != "" {
+		u, _ := url.Parse(cfg.ModerationURL)
+		log.Printf("🧼 Checking player text with the block list and %s", u.Host)
+	}
+	if cfg.RedisURL != "" {
+		probes.addCheck("redis", shared.Ping)
+	}
+
+	// With only -metrics-addr internal, the public listeners can't hide
+	// /debug/ on the main mux.
+	specs, _ := cfg.listeners()
+	maint := newMaintenance(cfg, shared)
+	go maint.run(background)
+	if cfg.Maintenance {
+		log.Printf("🔧 Starting in maintenance mode")
+	}
+	reloads := &reloader{cfg: cfg, args: os.Args[1:], running: flagValues(flag.CommandLine), siteConfigs: siteConfigs,
+		proxies: proxies, shared: shared, cors: cors, proxyRoutes: proxyRoutes}
+	env := siteEnv{
+		maintenance: maint,
+		reloads:     reloads,
+		background:  background,
+		probes:      probes,
+		build:       build,
+		proxies:     proxies,
+		announce:    announce,
+		filter:      filter,
+		cors:        cors,
+		common: func(mux *http.ServeMux) {
+			mux.HandleFunc("GET /healthz", probes.liveness)
+			mux.HandleFunc("GET /readyz", probes.readiness)
+			if cfg.Metrics && cfg.MetricsAddr == "" {
+				mux.Handle("GET /metrics", defaultRegistry)
+			}
+			if cfg.Debug && slices.ContainsFunc(specs, func(s listenSpec) bool { return s.internal }) {
+				registerDebug(mux)
+			}
+		},
+	}
+	sites := newSiteRouter(newSite(env, cfg, "", shared, nil))
+	for _, sc := range siteConfigs {
+		var api *gameAPI
+		if !sc.ownDB {
+			api = sites.def.api
+		}
+		sites.add(newSite(env, sc.config(cfg), sc.name(), ephemeral.Prefix(shared, "site:"+sc.name()+":"), api), sc.hosts)
+	}
+	sites.registerGauges()
+	reloads.sites = sites
+	if err := reloads.start(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Debug {
+		enableDebug(sites.def.hub)
+		log.Printf("🐞 Debug endpoints enabled under /debug/ on internal listeners")
+	}
+
+	// Middleware the configuration can't change on a reload, outside the
+	// middleware it can.
+	var handler http.Handler = reloads
+	handler = recoverPanics(sites, reporter, handler)
+	handler = instrument(sites, handler)
+	if cfg.OTLPEndpoint != "" {
+		handler = traceRequests(sites, proxies, handler)
+	}
+	handler = accessLog(accessLogger, cfg.LogAssets, proxies, handler)
+	handler = withRequestID(proxies, handler)
+
+	socketMode, _ := cfg.socketMode()
+	sockets := inheritListeners()
+	var servers []shutdowner
+	errc := make(chan error, len(specs)+4)
+
+	if cfg.MetricsAddr != "" {
+		internal := http.NewServeMux()
+		internal.Handle("GET /metrics", defaultRegistry)
+		if cfg.Debug {
+			registerDebug(internal)
+		}
+		ms := newHTTPServer(cfg, cfg.MetricsAddr, internal)
+		ln, err := sockets.listen(cfg.MetricsAddr, socketMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers = append(servers, ms)
+		log.Printf("📈 Metrics available on http://%s/metrics", cfg.MetricsAddr)
+		go func() { errc <- ms.Serve(ln) }()
+	}
+
+	if cfg.Dev {
+		log.Printf("🛠️  Dev mode: proxying the game to %s", cfg.DevURL)
+	} else {
+		for _, st := range sites.all {
+			if st.name == "" {
+				log.Printf("📦 Serving from %s with optimized caching and gzip", st.distDir)
+			} else {
+				log.Printf("📦 Serving %s from %s", st.name, st.distDir)
+			}
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.tlsEnabled() {
+		var redirect http.Handler = redirectToHTTPS(cfg.Port)
+		if cfg.ACME {
+			m := newACMEManager(cfg)
+			tlsConfig = acmeTLSConfig(m)
+			redirect = m.HTTPHandler(redirect)
+			log.Printf("🔐 Using ACME certificates for %v (cache %s)", cfg.ACMEDomains, cfg.ACMECacheDir)
+		} else {
+			tlsConfig = newTLSConfig()
+		}
+		if cfg.RedirectAddr != "" {
+			rs := newHTTPServer(cfg, cfg.RedirectAddr, redirect)
+			ln, err := sockets.listen(cfg.RedirectAddr, socketMode)
+			if err != nil {
+				log.Fatal(err)
+			}
+			servers = append(servers, rs)
+			log.Printf("↪️  Redirecting HTTP on %s to HTTPS", cfg.RedirectAddr)
+			go func() { errc <- rs.Serve(ln) }()
+		}
+	}
+
+	if cfg.GRPCAddr != "" {
+		gs, err := newGRPCServer(&grpcAPI{db: sites.def.api.db, limiter: reloads.limiter, ipFilter: reloads.ipFilter, maintenance: maint, quotas: newQuotas(sites.def.api.db, cfg, proxies)}, cfg, tlsConfig)
+		if err != nil {
+			log.Fatalf("grpc: %v", err)
+		}
+		ln, err := sockets.listen(cfg.GRPCAddr, socketMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers = append(servers, grpcServer{gs})
+		log.Printf("🛰️  gRPC API on %s", cfg.GRPCAddr)
+		go func() { errc <- gs.Serve(ln) }()
+	}
+
+	// Once metrics and admin have a listener of their own, the public ones
+	// pretend they don't exist.
+	public := handler
+	if slices.ContainsFunc(specs, func(s listenSpec) bool { return s.internal }) {
+		public = hideInternal(handler)
+	}
+
+	var h3 *http3.Server
+	for _, spec := range specs {
+		ln, err := sockets.listen(spec.addr, socketMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ln = limitConns(ln, cfg.MaxConns)
+		srv := newHTTPServer(cfg, ln.Addr().String(), public)
+		if spec.internal {
+			srv.Handler = handler
+		}
+		servers = append(servers, srv)
+
+		scheme, what := "http", "🎮 Lode Runner 2099 server running on"
+		if spec.tls {
+			scheme = "https"
+		}
+		if spec.internal {
+			what = "🔒 Internal listener, with metrics and admin, on"
+		}
+		if !spec.tls {
+			log.Printf("%s %s", what, listenDescription(ln, scheme))
+			go func() { errc <- srv.Serve(ln) }()
+			continue
+		}
+
+		srv.TLSConfig = tlsConfig
+		if _, tcp := ln.Addr().(*net.TCPAddr); cfg.HTTP3 && h3 == nil && tcp {
+			h3, err = newHTTP3Server(srv, cfg.TLSCert, cfg.TLSKey)
+			if err != nil {
+				log.Fatalf("http3: %v", err)
+			}
+			srv.Handler = advertiseHTTP3(h3, srv.Handler)
+			pc, err := sockets.listenPacket(srv.Addr)
+			if err != nil {
+				log.Fatalf("http3: %v", err)
+			}
+			servers = append(servers, h3)
+			log.Printf("⚡ HTTP/3 enabled on UDP %s", srv.Addr)
+			go func() { errc <- h3.Serve(pc) }()
+		}
+		log.Printf("%s %s", what, listenDescription(ln, scheme))
+		go func() { errc <- srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey) }()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	upgrades := make(chan os.Signal, 1)
+	notifyUpgrade(upgrades)
+	reloadSignals := make(chan os.Signal, 1)
+	notifyReload(reloadSignals)
+	sockets.ready()
+
+	upgraded := 0
+	for upgraded == 0 && ctx.Err() == nil {
+		select {
+		case err := <-errc:
+			log.Fatal(err)
+		case <-ctx.Done():
+		case <-upgrades:
+			log.Printf("🔁 Upgrading: starting the new binary")
+			pid, err := sockets.upgrade()
+			if err != nil {
+				log.Printf("upgrade: %v", err)
+				continue
+			}
+			upgraded = pid
+		case <-reloadSignals:
+			if _, _, err := reloads.reload(); err != nil {
+				log.Printf("⚠️  Reload failed, keeping the running configuration: %v", err)
+			}
+		}
+	}
+	// A second signal during the drain kills the process immediately.
+	stop()
+	probes.draining.Store(true)
+
+	if upgraded != 0 {
+		log.Printf("🔁 Handed over to pid %d, finishing matches for up to %s", upgraded, cfg.UpgradeTimeout)
+		sockets.stopAccepting(cfg.ReadHeaderTimeout)
+	} else {
+		log.Printf("🛑 Shutting down, draining connections for up to %s", cfg.ShutdownTimeout)
+		// Hijacked WebSocket connections aren't tracked by Shutdown.
+		for _, st := range sites.all {
+			st.hub.KickAll("server shutting down")
+		}
+	}
+	for _, api := range sites.apis() {
+		api.feed.close()
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- shutdown(servers, cfg.ShutdownTimeout) }()
+	if upgraded != 0 {
+		hubs := make([]*lobby.Hub, len(sites.all))
+		for i, st := range sites.all {
+			hubs[i] = st.hub
+		}
+		finishMatches(hubs, cfg.UpgradeTimeout)
+		for _, hub := range hubs {
+			hub.KickAll("server restarting")
+		}
+	}
+	if err := <-drained; err != nil {
+		log.Fatalf("shutdown: %v", err)
+	}
+	stopBackground()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, api := range sites.apis() {
+		api.close(flushCtx)
+	}
+	if announce != nil {
+		announce.close(flushCtx)
+	}
+	if reporter != nil {
+		reporter.close(flushCtx)
+	}
+	if err := flushTraces(flushCtx); err != nil {
+		log.Printf("tracing: %v", err)
+	}
+	log.Printf("👋 Server stopped")
+}
+
+// siteEnv is what every site shares.
+type siteEnv struct {
+	background  context.Context
+	probes      *health
+	build       buildInfo
+	proxies     trustedProxies
+	announce    *announcer     // nil without -announce-webhook
+	filter      *contentFilter // nil with -content-filter=false
+	cors        *corsPolicy    // nil without -cors-origins
+	maintenance *maintenance
+	reloads     *reloader
+
+	// common registers the health, metrics and debug routes.
+	common func(mux *http.ServeMux)
+}
+
+// newSite sets up the site called name, "" for the default site, as cfg
+// describes it. Its lobby, matchmaking and sessions keep their state in
+// store. With api nil, it opens a game API of its own.
+func newSite(env siteEnv, cfg config, name string, store ephemeral.Store, api *gameAPI) *site {
+	// Readiness checks and logs tell sites apart by name.
+	check, forSite := "", ""
+	if name != "" {
+		check, forSite = ":"+name, " for "+name
+	}
+	st := &site{name: name, mux: http.NewServeMux()}
+	mux := st.mux
+	versions := &versionAPI{build: env.build}
+	if cfg.Dev {
+		// Everything the Go server doesn't handle itself, including Vite's
+		// HMR WebSocket, goes to the dev server.
+		devURL, _ := url.Parse(cfg.DevURL)
+		mux.Handle("/", newReverseProxy(devURL, proxyTransport(cfg), env.proxies))
+	} else {
+		var fsys fs.FS
+		fsys, st.distDir, versions.embedded = openDist(cfg, name == "")
+		embedded := versions.embedded
+		if origin, ok := fsys.(*originFS); ok {
+			go origin.run(env.background, cfg.OriginRefresh)
+		}
+		policy, err := newCachePolicy(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		st.policy = policy
+		env.probes.addCheck("dist"+check, distReadable(fsys))
+		var stable buildHandler
+		if isReleases(st.distDir) {
+			releases, err := openReleases(env, cfg, st.distDir, policy, forSite)
+			if err != nil {
+				log.Fatalf("releases: %v", err)
+			}
+			go releases.watch(env.background)
+			stable = releases
+			if cfg.LiveReload {
+				log.Printf("⚠️  -live-reload needs dist/ on disk as one build, not releases; ignoring it")
+			}
+		} else {
+			static, err := newStatic(env, cfg, fsys, st.distDir, embedded, policy, forSite)
+			if err != nil {
+				log.Fatal(err)
+			}
+			stable = static
+			if cfg.LiveReload {
+				if embedded || cfg.Origin != "" {
+					log.Printf("⚠️  -live-reload needs dist/ on disk, not an embedded build or -origin; ignoring it")
+				} else {
+					reload := newLiveReload(st.distDir)
+					reload.register(mux)
+					static.liveReload = true
+					go func() {
+						if err := reload.watch(env.background); err != nil {
+							log.Printf("livereload: %v", err)
+						}
+					}()
+					log.Printf("🔄 Live reload watching %s", st.distDir)
+				}
+			}
+		}
+		if cfg.Canary != "" {
+			if _, err := os.Stat(cfg.Canary); err != nil {
+				log.Fatalf("canary: %v", err)
+			}
+			canaryCfg := cfg
+			canaryCfg.Origin = ""
+			if cfg.PrecompressDir != "" {
+				canaryCfg.PrecompressDir = filepath.Join(cfg.PrecompressDir, "canary")
+			}
+			env.probes.addCheck("canary"+check, distReadable(os.DirFS(cfg.Canary)))
+			next, err := newStatic(env, canaryCfg, os.DirFS(cfg.Canary), cfg.Canary, false, policy, " for the canary")
+			if err != nil {
+				log.Fatal(err)
+			}
+			st.canary = newCanaryRollout(cfg, store, env.proxies, stable, next)
+			go st.canary.run(env.background)
+			mux.Handle("/", st.canary)
+			log.Printf("🐤 Serving %s to %d%% of visitors", cfg.Canary, cfg.CanaryPercent)
+		} else {
+			mux.Handle("/", stable)
+		}
+		st.dist, versions.dist = stable, stable
+		if st.cdn = newCDNPurger(cfg, store, stable); st.cdn != nil {
+			go st.cdn.watch(env.background)
+			log.Printf("🧹 Purging %s when a new build is served%s", cfg.CDNPurge, forSite)
+		}
+	}
+	versions.register(mux)
+	env.common(mux)
+
+	st.hub = lobby.NewHub(store)
+	go func() {
+		if err := st.hub.Run(env.background); err != nil {
+			log.Fatalf("lobby: %v", err)
+		}
+	}()
+	if api == nil {
+		api = openGameAPI(env, cfg, check, forSite, store)
+	}
+	st.api = api
+	(&lobbyAPI{hub: st.hub, origins: env.cors.wsOrigins(), db: api.db, store: store, filter: env.filter}).register(mux)
+	matchmaker := newMatchmakingAPI(store, st.hub, env.filter)
+	matchmaker.register(mux)
+	st.queue = matchmaker.queue
+	go st.queue.Run(env.background, func(err error) { log.Printf("matchmaking: %v", err) })
+	(&iceServersAPI{stun: cfg.STUNURLs, turn: cfg.TURNURLs, secret: cfg.TURNSecret, ttl: cfg.TURNTTL}).register(mux)
+	(&generateAPI{}).register(mux)
+	api.register(mux, cfg, env, st)
+	(&sitemapAPI{db: api.db, dist: st.dist, publicURL: cfg.PublicURL}).register(mux)
+	st.handler = mux
+	if api.db != nil {
+		st.handler = withSession(api.db, api.sessions, withBans(api.db, mux))
+	}
+	st.handler = limitRouteBodies(st.handler)
+	st.handler = withMaintenance(env.maintenance, st.dist, st.handler)
+	if cfg.CanonicalRedirect {
+		st.handler = withCanonicalHost(cfg.PublicURL, env.proxies, st.handler)
+		log.Printf("↪️  Redirecting other hostnames to %s%s", cfg.PublicURL, forSite)
+	}
+	return st
+}
+
+// newStatic serves fsys, the build in distDir, through the memory cache and
+// precompression as cfg asks. suffix ends its log lines.
+func newStatic(env siteEnv, cfg config, fsys fs.FS, distDir string, embedded bool, policy *cachePolicy, suffix string) (*staticHandler, error) {
+	if cfg.MemoryCacheMB > 0 {
+		if embedded {
+			log.Printf("⚠️  -memory-cache-mb has no effect on an embedded build, which is in memory already")
+		} else if cfg.Origin != "" {
+			log.Printf("⚠️  -memory-cache-mb has no effect with -origin; files are served from %s", cfg.OriginCache)
+		} else {
+			cache := newAssetCache(distDir, int64(cfg.MemoryCacheMB)<<20)
+			fsys = cache
+			go func() {
+				if err := cache.watch(env.background); err != nil {
+					log.Printf("asset cache: %v", err)
+				}
+			}()
+			log.Printf("🧠 Caching up to %d MB of %s in memory", cfg.MemoryCacheMB, distDir)
+		}
+	}
+	if cfg.Precompress {
+		start := time.Now()
+		pfs, report, err := precompress(fsys, cfg.PrecompressDir)
+		if err != nil {
+			return nil, fmt.Errorf("precompress: %w", err)
+		}
+		fsys = pfs
+		report.log(time.Since(start), cfg.PrecompressDir)
+	}
+	static := newStaticHandler(fsys, policy)
+	var err error
+	if static.preload, err = newPreloader(cfg, fsys); err != nil {
+		return nil, fmt.Errorf("preload: %w", err)
+	}
+	log.Printf("🏷️  Indexed %d files for ETags%s", static.etags.prime(fsys), suffix)
+	return static, nil
+}
+
+// gameAPI is the API behind the database and analytics: scores, accounts,
+// levels and the rest. Sites without a database of their own share the
+// default site's.
+type gameAPI struct {
+	db       *storage.DB // nil without -db
+	sessions *sessionCache
+	events   eventStore // nil with analytics off
+	feed     *scoreFeed
+	push     *pushSender
+	verifier *replayVerifier
+
+	daily     *dailyChallenge
+	tokens    *playTokens // nil without -score-signing
+	privacy   *privacyAPI
+	config    *remoteConfigAPI
+	providers map[string]*oauthProvider
+}
+
+// openGameAPI opens cfg's database and event store and starts the workers
+// that go with them. check and forSite name the site in readiness checks
+// and logs.
+func openGameAPI(env siteEnv, cfg config, check, forSite string, store ephemeral.Store) *gameAPI {
+	a := &gameAPI{sessions: newSessionCache(cfg, store), feed: newScoreFeed()}
+	var err error
+	if cfg.DBPath != "" {
+		a.db, err = openDatabase(cfg)
+		if err != nil {
+			log.Fatalf("database: %v", err)
+		}
+		env.probes.addCheck("database"+check, a.db.Ping)
+	}
+	if a.events, err = openEventStore(cfg, a.db); err != nil {
+		log.Fatal(err)
+	}
+	if a.events != nil {
+		log.Printf("📊 Analytics events go to %s", a.events)
+	}
+	if a.db == nil {
+		return a
+	}
+	db := a.db
+	if a.daily, err = newDailyChallenge(db, cfg.DailySecret); err != nil {
+		log.Fatalf("daily challenge: %v", err)
+	}
+	if a.push, err = newPushSender(db, cfg); err != nil {
+		log.Fatalf("push: %v", err)
+	}
+	a.push.start(env.background, a.daily)
+	if cfg.ScoreSigning {
+		if a.tokens, err = newPlayTokens(db, cfg.PlayTokenTTL); err != nil {
+			log.Fatalf("play tokens: %v", err)
+		}
+		log.Printf("✍️  Score submissions need a signed play token")
+	}
+	if cfg.SeasonSchedule != "" {
+		schedule, _ := cron.Parse(cfg.SeasonSchedule)
+		go rollSeasons(env.background, db, schedule)
+		log.Printf("🗓️  Seasons roll over on %q", schedule)
+	}
+	if cfg.Backup != "" {
+		b, err := newBackups(db, cfg)
+		if err != nil {
+			log.Fatalf("backups: %v", err)
+		}
+		go b.run(env.background)
+		log.Printf("💾 Backing up the database to %s on %q", b.store, b.schedule)
+	}
+	if a.privacy, err = newPrivacyAPI(db, a.sessions, env.proxies); err != nil {
+		log.Fatalf("privacy: %v", err)
+	}
+	if a.config, err = newRemoteConfigAPI(db); err != nil {
+		log.Fatalf("remote config: %v", err)
+	}
+	a.providers = newOAuthProviders(cfg)
+	if len(a.providers) > 0 {
+		log.Printf("🔑 OAuth sign-in enabled for %d providers", len(a.providers))
+	}
+	if cfg.AdminToken != "" || len(cfg.Admins) > 0 {
+		log.Printf("🛡️  Moderation API enabled%s", forSite)
+	}
+	go expireSessions(env.background, db)
+	go runTournaments(env.background, db)
+	go rotateClans(env.background, db)
+	go pruneChat(env.background, db)
+	go expireSaveCodes(env.background, db)
+	a.verifier = newReplayVerifier(db, cfg.VerifyWorkers)
+	a.verifier.start(env.background)
+	log.Printf("🏆 Game API enabled (database %s)%s", storage.Redact(cfg.DBPath), forSite)
+	return a
+}
+
+// register adds the API's routes to a site's mux. The moderation API
+// reports on and acts on that site's lobby, queue, CDN and canary.
+func (a *gameAPI) register(mux *http.ServeMux, cfg config, env siteEnv, st *site) {
+	announce := env.announce
+	if a.events != nil {
+		(&eventsAPI{store: a.events}).register(mux)
+	}
+	db := a.db
+	if db == nil {
+		return
+	}
+	quotas := newQuotas(db, cfg, env.proxies)
+	mux.HandleFunc("/api/", apiNotFound)
+	(&docsAPI{}).register(mux)
+	newGraphQLAPI(db, cfg).register(mux)
+	(&pushAPI{db: db, push: a.push}).register(mux)
+	(&scoresAPI{db: db, daily: a.daily, feed: a.feed, push: a.push, announce: announce, tokens: a.tokens, filter: env.filter}).register(mux)
+	(&dailyAPI{db: db, daily: a.daily}).register(mux)
+	(&seasonsAPI{db: db}).register(mux)
+	(&tournamentsAPI{db: db}).register(mux)
+	(&clansAPI{db: db, filter: env.filter}).register(mux)
+	(&friendsAPI{db: db}).register(mux)
+	(&economyAPI{db: db}).register(mux)
+	(&inboxAPI{db: db}).register(mux)
+	a.config.register(mux)
+	(&experimentsAPI{db: db}).register(mux)
+	(&savesAPI{db: db, quotas: quotas}).register(mux)
+	(&levelsAPI{db: db, publicURL: cfg.PublicURL, filter: env.filter, quotas: quotas}).register(mux)
+	(&accountsAPI{db: db, sessions: a.sessions, filter: env.filter, proxies: env.proxies}).register(mux)
+	a.privacy.register(mux)
+	(&achievementsAPI{db: db}).register(mux)
+	(&reportsAPI{db: db}).register(mux)
+	if cfg.AdminToken != "" || len(cfg.Admins) > 0 {
+		(&adminAPI{db: db, token: cfg.AdminToken, admins: cfg.Admins, hub: st.hub, queue: st.queue, events: a.events,
+			announce: announce, maintenance: env.maintenance, cdn: st.cdn, canary: st.canary, proxies: env.proxies, reloads: env.reloads}).register(mux)
+	}
+	(&oauthAPI{db: db, providers: a.providers, publicURL: cfg.PublicURL, proxies: env.proxies}).register(mux)
+	(&replaysAPI{db: db, verifier: a.verifier, quotas: quotas}).register(mux)
+	(&ghostsAPI{db: db}).register(mux)
+	(&shareAPI{db: db, publicURL: cfg.PublicURL}).register(mux)
+	(&feedsAPI{db: db, publicURL: cfg.PublicURL}).register(mux)
+	(&shortLinkAPI{db: db}).register(mux)
+}
+
+// close waits for replay verification and flushes pending pushes, then
+// closes the event store and database.
+func (a *gameAPI) close(ctx context.Context) {
+	if a.verifier != nil {
+		a.verifier.wait()
+	}
+	if a.push != nil {
+		a.push.close(ctx)
+	}
+	if a.events != nil {
+		a.events.Close()
+	}
+	if a.db != nil {
+		a.db.Close()
+	}
+}
+
+// openDist returns the built game, from -origin, embedded or from -dist, a
+// name for it in the logs and whether it is embedded. Only the default site
+// may serve the embedded build. With -build, a missing or stale dist/ is
+// built first.
+func openDist(cfg config, embed bool) (fs.FS, string, bool) {
+	distDir := cfg.DistDir
+
+	var fsys fs.FS
+	var embedded bool
+	if embed {
+		fsys, embedded = embeddedFS()
+	}
+	if cfg.Origin != "" {
+		origin, err := listOrigin(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		origin.mu.RLock()
+		log.Printf("🪣 Serving %d files from %s, cached in %s", len(origin.files), cfg.Origin, cfg.OriginCache)
+		origin.mu.RUnlock()
+		fsys, distDir, embedded = origin, cfg.Origin, false
+	} else if embedded {
+		distDir = "embedded dist/"
+	} else {
+		if cfg.Build && isReleases(distDir) {
+			log.Printf("⚠️  -build doesn't build releases; serving %s as it is", distDir)
+		} else if cfg.Build {
+			if err := ensureBuild(distDir, cfg.BuildStale); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// Check if dist exists
+		if _, err := os.Stat(distDir); os.IsNotExist(err) {
+			log.Fatalf("%s directory not found. Run 'npm run build' first, or start with -build.", distDir)
+		}
+		fsys = distFS(distDir)
+	}
+	if _, err := fs.Stat(fsys, "index.html"); err != nil {
+		log.Printf("⚠️  %s has no index.html: %v", distDir, err)
+	}
+	return fsys, distDir, embedded
+}
+
+// shutdowner is a server that can drain gracefully: *http.Server and the
+// HTTP/3 server.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdown stops every server from accepting new connections and waits up
+// to timeout for in-flight requests to finish.
+func shutdown(servers []shutdowner, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// openShared connects to -redis, or returns an in-memory store without it.
+func openShared(cfg config) (ephemeral.Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store, err := ephemeral.Open(ctx, cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(cfg.RedisURL); err == nil && cfg.RedisURL != "" {
+		log.Printf("🧠 Sharing lobby rooms, matchmaking, rate limits and sessions through %s", u.Redacted())
+	}
+	return store, nil
+}
+
+// openDatabase opens the game database and brings its schema up to date.
+func openDatabase(cfg config) (*storage.DB, error) {
+	db, err := storage.Open(cfg.DBPath, cfg.DBDriver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.Migrate(context.Background())
+	for _, m := range applied {
+		log.Printf("🗄️  Applied migration %s", m.Name)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
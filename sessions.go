@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	sessionCookie = "lr_session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+// sessionCacheTTL is how long a session lookup is cached for. Sessions
+// ended by signing out or deleting the account leave the cache at once;
+// ones that just expire may outlive their expiry by this much.
+const sessionCacheTTL = time.Minute
+
+type playerContextKey struct{}
+
+// sessionCache keeps session lookups in the store shared through -redis,
+// sparing the database a query on most API requests. It is only used with
+// -redis, where every instance sees a session leave it: a cache per
+// instance would keep a signed-out session alive on the others. A nil
+// cache caches nothing.
+type sessionCache struct {
+	store ephemeral.Store
+}
+
+func newSessionCache(cfg config, store ephemeral.Store) *sessionCache {
+	if cfg.RedisURL == "" {
+		return nil
+	}
+	return &sessionCache{store: store}
+}
+
+func (c *sessionCache) get(ctx context.Context, tokenHash string) (storage.Player, bool) {
+	if c == nil {
+		return storage.Player{}, false
+	}
+	b, err := c.store.Get(ctx, "session:"+tokenHash)
+	var p storage.Player
+	if err != nil || b == nil || json.Unmarshal(b, &p) != nil {
+		return storage.Player{}, false
+	}
+	return p, true
+}
+
+func (c *sessionCache) put(ctx context.Context, tokenHash string, p storage.Player) {
+	if c == nil {
+		return
+	}
+	b, _ := json.Marshal(p)
+	if err := c.store.Set(ctx, "session:"+tokenHash, b, sessionCacheTTL); err != nil {
+		log.Printf("sessions: cache: %v", err)
+	}
+}
+
+// forget drops ended sessions from the cache.
+func (c *sessionCache) forget(ctx context.Context, tokenHashes ...string) error {
+	if c == nil {
+		return nil
+	}
+	for _, h := range tokenHashes {
+		if err := c.store.Delete(ctx, "session:"+h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentPlayer returns the signed-in player attached by withSession, or
+// nil for anonymous requests.
+func currentPlayer(r *http.Request) *storage.Player {
+	p, _ := r.Context().Value(playerContextKey{}).(*storage.Player)
+	return p
+}
+
+// sessionToken returns the session token from the cookie or an
+// "Authorization: Bearer" header.
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// withSession resolves the session token, if any, and attaches the player
+// to the request context. Invalid or expired tokens are treated as
+// anonymous rather than rejected, so a stale cookie never locks anyone out
+// of public endpoints.
+func withSession(db *storage.DB, cache *sessionCache, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := sessionToken(r)
+		if token == "" || !sessionPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hash := hashToken(token)
+		p, ok := cache.get(r.Context(), hash)
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), playerContextKey{}, &p))
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := db.SessionPlayer(r.Context(), hash)
+		switch {
+		case err == nil:
+			cache.put(r.Context(), hash, p)
+			r = r.WithContext(context.WithValue(r.Context(), playerContextKey{}, &p))
+		case !errors.Is(err, storage.ErrNotFound):
+			log.Printf("sessions: lookup: %v", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionPath reports whether requests to path may need the player. Static
+// files never do, so they skip the session lookup.
+func sessionPath(path string) bool {
+	return strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/auth/") || path == "/ws" || path == "/admin/"
+}
+
+// requirePlayer returns the signed-in player, writing a 401 if there is
+// none.
+func requirePlayer(w http.ResponseWriter, r *http.Request) (*storage.Player, bool) {
+	p := currentPlayer(r)
+	if p == nil {
+		writeError(w, http.StatusUnauthorized, "sign in required")
+		return nil, false
+	}
+	return p, true
+}
+
+// startSession creates a session for p, sets the cookie and returns the
+// token for clients that prefer bearer auth. The cookie is Secure when the
+// request came over HTTPS, to this server or to a trusted proxy in front.
+func startSession(w http.ResponseWriter, r *http.Request, db *storage.DB, proxies trustedProxies, p storage.Player) (string, time.Time, error) {
+	token := randomToken(32)
+	expires := time.Now().Add(sessionTTL).Truncate(time.Second)
+	if err := db.CreateSession(r.Context(), hashToken(token), p.ID, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   proxies.scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, expires, nil
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request, proxies trustedProxies) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   proxies.scheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// expireSessions deletes expired sessions every hour until ctx is done.
+func expireSessions(ctx context.Context, db *storage.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := db.DeleteExpiredSessions(ctx); err != nil {
+				log.Printf("sessions: expire: %v", err)
+			} else if n > 0 {
+				log.Printf("🔑 Expired %d sessions", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/sharecard"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// shareMaxAge is how long unfurlers and CDNs may cache share pages and
+// images. A score hidden by a moderator disappears once it runs out.
+const shareMaxAge = time.Hour
+
+// shareAPI serves /share/score/{id}, a page with OpenGraph and Twitter
+// card tags that sends visitors on to the game, and /share/score/{id}.png,
+// the image those tags point to, so links to a run unfurl with it.
+type shareAPI struct {
+	db        *storage.DB
+	publicURL string
+}
+
+func (a *shareAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /share/score/{id}", a.score)
+}
+
+func (a *shareAPI) score(w http.ResponseWriter, r *http.Request) {
+	raw, image := strings.CutSuffix(r.PathValue("id"), ".png")
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	s, err := a.db.Score(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) || err == nil && (s.Flagged || s.Status == storage.StatusRejected) {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		s.Rank, err = a.db.ScoreRank(r.Context(), s)
+	}
+	if err != nil {
+		log.Printf("share: score %d: %v (request %s)", id, err, requestID(w))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(shareMaxAge.Seconds())))
+	base := siteBase(a.publicURL, r)
+	if image {
+		a.image(w, s, base)
+		return
+	}
+	a.page(w, s, base)
+}
+
+func (a *shareAPI) image(w http.ResponseWriter, s storage.Score, base string) {
+	details := []string{"LEVEL " + strconv.Itoa(s.Level) + "  " + s.Difficulty, "TIME " + formatRunTime(s.Time), "RANK #" + strconv.Itoa(s.Rank)}
+	if s.Daily != "" {
+		details[0] = "DAILY " + s.Daily
+	}
+	var buf bytes.Buffer
+	host := base
+	if u, err := url.Parse(base); err == nil {
+		host = u.Host
+	}
+	if err := sharecard.Render(&buf, sharecard.Card{Player: s.Player, Score: s.Score, Level: s.Level, Details: details, Footer: host}); err != nil {
+		log.Printf("share: render image: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+func (a *shareAPI) page(w http.ResponseWriter, s storage.Score, base string) {
+	// The run's seed and difficulty open the same level, as the game's own
+	// share links do.
+	play := base + "/"
+	if s.Seed != "" {
+		q := url.Values{"seed": {s.Seed}}
+		if s.Difficulty != "" {
+			q.Set("diff", s.Difficulty)
+		}
+		play += "?" + q.Encode()
+	}
+	where := "level " + strconv.Itoa(s.Level)
+	if s.Daily != "" {
+		where = "the " + s.Daily + " daily challenge"
+	}
+	id := strconv.FormatInt(s.ID, 10)
+	var buf bytes.Buffer
+	err := shareTemplate.Execute(&buf, map[string]any{
+		"Title":       fmt.Sprintf("%s scored %d in Lode Runner 2099", s.Player, s.Score),
+		"Description": fmt.Sprintf("Rank #%d on %s in %s. Can you beat it?", s.Rank, where, formatRunTime(s.Time)),
+		"URL":         base + "/share/score/" + id,
+		"Image":       base + "/share/score/" + id + ".png",
+		"Width":       sharecard.Width,
+		"Height":      sharecard.Height,
+		"Play":        play,
+	})
+	if err != nil {
+		log.Printf("share: render page: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+var shareTemplate = template.Must(template.New("share").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+<link rel="canonical" href="{{.URL}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="Lode Runner 2099">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:image:width" content="{{.Width}}">
+<meta property="og:image:height" content="{{.Height}}">
+<meta property="og:image:type" content="image/png">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="twitter:image" content="{{.Image}}">
+<meta http-equiv="refresh" content="0; url={{.Play}}">
+<style>
+body { margin: 0; min-height: 100vh; display: grid; place-items: center; background: #0b0e1a; color: #e8ecff; font: 16px/1.4 system-ui, sans-serif; text-align: center; }
+a { color: #00ffff; }
+img { max-width: 90vw; height: auto; }
+</style>
+</head>
+<body>
+<main>
+<img src="{{.Image}}" width="{{.Width}}" height="{{.Height}}" alt="{{.Title}}">
+<p><a href="{{.Play}}">Play this level</a></p>
+</main>
+</body>
+</html>
+`))
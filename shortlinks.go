@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// Short link codes are lower case letters and digits without vowels, so
+// they don't spell words, or the characters people confuse.
+const (
+	shortCodeLength   = 6
+	shortCodeAlphabet = "23456789bcdfghjkmnpqrstvwxz"
+)
+
+// shortCodeAttempts is how many random codes to try before giving up; at
+// 27^6 codes, a collision is rare and several in a row mean a fault.
+const shortCodeAttempts = 5
+
+// shortLinkAPI serves /l/{code}, which redirects to the level's
+// /levels/{id} page in the game.
+type shortLinkAPI struct {
+	db *storage.DB
+}
+
+func (a *shortLinkAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /l/{code}", a.open)
+}
+
+// open redirects to the code's level. Disabled codes and unpublished
+// levels are not found.
+func (a *shortLinkAPI) open(w http.ResponseWriter, r *http.Request) {
+	s, err := a.db.ShortLink(r.Context(), strings.ToLower(r.PathValue("code")))
+	var l storage.Level
+	if err == nil && s.DisabledAt == nil {
+		l, err = a.db.GetLevel(r.Context(), s.LevelID)
+	}
+	if errors.Is(err, storage.ErrNotFound) || err == nil && (s.DisabledAt != nil || l.Unpublished) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("links: open %q: %v (request %s)", r.PathValue("code"), err, requestID(w))
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, "/levels/"+strconv.FormatInt(l.ID, 10), http.StatusFound)
+}
+
+// levelShortCode returns the level's short link code, giving it one if it
+// has none yet: new levels on upload, older ones the first time they're
+// fetched.
+func levelShortCode(ctx context.Context, db *storage.DB, levelID int64) (string, error) {
+	for range shortCodeAttempts {
+		s, err := db.LevelShortLink(ctx, levelID)
+		if err == nil {
+			return s.Code, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return "", err
+		}
+		// A taken code is retried, and so is losing a race for the level's
+		// first code, which the lookup then finds.
+		s, err = db.CreateShortLink(ctx, newShortCode(), levelID)
+		if !errors.Is(err, storage.ErrShortLinkTaken) {
+			return s.Code, err
+		}
+	}
+	return "", errors.New("no free short link code")
+}
+
+// newShortCode returns a random short link code, which may be taken.
+func newShortCode() string {
+	return randomCode(shortCodeLength)
+}
+
+// randomCode returns n random characters of shortCodeAlphabet, for codes
+// people type in.
+func randomCode(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = shortCodeAlphabet[int(b[i])%len(shortCodeAlphabet)]
+	}
+	return string(b)
+}
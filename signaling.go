@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxSDPSize bounds a session description; real ones are a few KB.
+const maxSDPSize = 12 << 10
+
+// sessionDescription mirrors RTCSessionDescriptionInit.
+type sessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// iceCandidate mirrors RTCIceCandidateInit. A null or empty candidate
+// marks the end of gathering and is passed through.
+type iceCandidate struct {
+	Candidate     string  `json:"candidate"`
+	SDPMid        *string `json:"sdpMid"`
+	SDPMLineIndex *int    `json:"sdpMLineIndex"`
+}
+
+// checkSignal validates a WebRTC signaling payload before it is relayed to
+// a single peer. The server doesn't negotiate anything itself; it only
+// makes sure peers receive well-formed messages from room members.
+func checkSignal(msg clientMessage) error {
+	if msg.To == "" {
+		return fmt.Errorf("%s needs a to player", msg.Type)
+	}
+	switch msg.Type {
+	case "offer", "answer":
+		var sd sessionDescription
+		if err := json.Unmarshal(msg.Data, &sd); err != nil {
+			return fmt.Errorf("%s data must be a session description", msg.Type)
+		}
+		if sd.Type != msg.Type {
+			return fmt.Errorf("%s data has type %q", msg.Type, sd.Type)
+		}
+		if sd.SDP == "" || len(sd.SDP) > maxSDPSize {
+			return fmt.Errorf("sdp must be 1-%d bytes", maxSDPSize)
+		}
+	case "ice":
+		if string(msg.Data) == "null" {
+			return nil
+		}
+		var c iceCandidate
+		if err := json.Unmarshal(msg.Data, &c); err != nil {
+			return errors.New("ice data must be an ICE candidate")
+		}
+		if c.Candidate != "" && c.SDPMid == nil && c.SDPMLineIndex == nil {
+			return errors.New("ice candidate needs sdpMid or sdpMLineIndex")
+		}
+	}
+	return nil
+}
+
+// iceServer mirrors RTCIceServer, so clients can pass the list straight to
+// new RTCPeerConnection({iceServers}).
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// iceServersAPI issues STUN/TURN configuration. TURN credentials use the
+// time-limited "TURN REST API" scheme that coturn supports with
+// use-auth-secret: the username is "<expiry>:<id>" and the password is an
+// HMAC-SHA1 of it under the shared secret, so the TURN server can check
+// them without calling back here.
+type iceServersAPI struct {
+	stun   []string
+	turn   []string
+	secret string
+	ttl    time.Duration
+}
+
+func (a *iceServersAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/rtc/ice-servers", a.show)
+}
+
+func (a *iceServersAPI) show(w http.ResponseWriter, _ *http.Request) {
+	servers := []iceServer{}
+	if len(a.stun) > 0 {
+		servers = append(servers, iceServer{URLs: a.stun})
+	}
+	expires := time.Now().Add(a.ttl).Truncate(time.Second)
+	if len(a.turn) > 0 {
+		username := strconv.FormatInt(expires.Unix(), 10) + ":" + randomToken(6)
+		mac := hmac.New(sha1.New, []byte(a.secret))
+		mac.Write([]byte(username))
+		servers = append(servers, iceServer{
+			URLs:       a.turn,
+			Username:   username,
+			Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ice_servers": servers,
+		"ttl":         int(a.ttl.Seconds()),
+		"expires_at":  expires.UTC(),
+	})
+}
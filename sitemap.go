@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// maxSitemapURLs is the most a sitemap may list; beyond it search engines
+// want an index of several sitemaps, which the newest levels fit without.
+const maxSitemapURLs = 50000
+
+// sitemapMaxAge is how long crawlers and CDNs may cache robots.txt and the
+// sitemap.
+const sitemapMaxAge = time.Hour
+
+// sitemapSkip matches build pages that aren't pages of their own: error
+// pages and the maintenance page.
+var sitemapSkip = regexp.MustCompile(`^([0-9]{3}|maintenance)\.html$`)
+
+// sitemapAPI generates robots.txt and sitemap.xml, unless the build has
+// its own. The sitemap lists the home page, the build's other pages and
+// each published level's /levels/{id} page, which the game opens in its
+// level browser.
+type sitemapAPI struct {
+	db        *storage.DB  // nil without a database
+	dist      buildHandler // nil in -dev
+	publicURL string
+}
+
+func (a *sitemapAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /robots.txt", a.robots)
+	mux.HandleFunc("GET /sitemap.xml", a.sitemap)
+}
+
+// fromBuild serves name from the build if it has it.
+func (a *sitemapAPI) fromBuild(w http.ResponseWriter, r *http.Request, name string) bool {
+	if a.dist == nil || !a.dist.isFile(name) {
+		return false
+	}
+	a.dist.ServeHTTP(w, r)
+	return true
+}
+
+// siteBase returns the site's external base URL: publicURL, or the
+// request's scheme and host without one.
+func siteBase(publicURL string, r *http.Request) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// robots is GET /robots.txt. Crawlers may index the game's pages but not
+// the API, sign-in or the dashboard.
+func (a *sitemapAPI) robots(w http.ResponseWriter, r *http.Request) {
+	if a.fromBuild(w, r, "robots.txt") {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sitemapMaxAge.Seconds())))
+	fmt.Fprintf(w, "User-agent: *\nDisallow: /api/\nDisallow: /auth/\nDisallow: /admin/\nDisallow: /ws\n\nSitemap: %s/sitemap.xml\n", siteBase(a.publicURL, r))
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemap is GET /sitemap.xml.
+func (a *sitemapAPI) sitemap(w http.ResponseWriter, r *http.Request) {
+	if a.fromBuild(w, r, "sitemap.xml") {
+		return
+	}
+	base := siteBase(a.publicURL, r)
+	urls := []sitemapURL{{Loc: base + "/"}}
+	if a.dist != nil {
+		fsys, _ := a.dist.served()
+		fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || path.Ext(name) != ".html" || name == "index.html" || sitemapSkip.MatchString(path.Base(name)) {
+				return nil
+			}
+			loc := "/" + name
+			if path.Base(name) == "index.html" {
+				loc = "/" + path.Dir(name) + "/"
+			}
+			urls = append(urls, sitemapURL{Loc: base + loc})
+			return nil
+		})
+	}
+	if a.db != nil {
+		levels, _, err := a.db.ListLevels(r.Context(), storage.LevelQuery{Sort: storage.SortNewest, Limit: maxSitemapURLs - len(urls)})
+		if err != nil {
+			log.Printf("sitemap: list levels: %v (request %s)", err, requestID(w))
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		for _, l := range levels {
+			urls = append(urls, sitemapURL{Loc: base + "/levels/" + strconv.FormatInt(l.ID, 10), LastMod: l.CreatedAt.Format(time.DateOnly)})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sitemapMaxAge.Seconds())))
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(struct {
+		XMLName xml.Name     `xml:"urlset"`
+		NS      string       `xml:"xmlns,attr"`
+		URLs    []sitemapURL `xml:"url"`
+	}{NS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/matchmaking"
+)
+
+// siteConfig is a -site: hostnames served from a build of their own, such
+// as a beta next to the stable release, written
+//
+//	beta.example.com[,alias...] dist=./dist-beta [cache-policy=FILE] [db=URL] [public-url=URL]
+//
+// with origin=s3://... in place of dist= to serve the build from a bucket.
+// A site without db= shares the default site's game API and database.
+type siteConfig struct {
+	hosts       []string
+	dist        string
+	origin      string
+	cachePolicy string
+	db          string
+	ownDB       bool
+	publicURL   string
+}
+
+func parseSite(spec string) (siteConfig, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return siteConfig{}, errors.New("-site: empty")
+	}
+	var s siteConfig
+	for _, h := range strings.Split(fields[0], ",") {
+		if h = normalizeHost(h); h != "" {
+			s.hosts = append(s.hosts, h)
+		}
+	}
+	if len(s.hosts) == 0 || strings.Contains(fields[0], "=") {
+		return siteConfig{}, fmt.Errorf("-site %q: want hostnames first", spec)
+	}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return siteConfig{}, fmt.Errorf("-site %q: want key=value, not %q", spec, f)
+		}
+		switch key {
+		case "dist":
+			s.dist = value
+		case "origin":
+			s.origin = value
+		case "cache-policy":
+			s.cachePolicy = value
+		case "db":
+			s.db, s.ownDB = value, true
+		case "public-url":
+			s.publicURL = value
+		default:
+			return siteConfig{}, fmt.Errorf("-site %q: unknown setting %q", spec, key)
+		}
+	}
+	if (s.dist == "") == (s.origin == "") {
+		return siteConfig{}, fmt.Errorf("-site %q: needs one of dist= or origin=", spec)
+	}
+	return s, nil
+}
+
+// parseSites parses every -site, refusing a hostname claimed twice.
+func parseSites(specs []string) ([]siteConfig, error) {
+	var sites []siteConfig
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		s, err := parseSite(spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range s.hosts {
+			if seen[h] {
+				return nil, fmt.Errorf("-site: %s is listed twice", h)
+			}
+			seen[h] = true
+		}
+		sites = append(sites, s)
+	}
+	return sites, nil
+}
+
+// name identifies the site in logs, readiness checks and shared state.
+func (s siteConfig) name() string { return s.hosts[0] }
+
+// config returns cfg as the site sees it. Directories the server writes
+// to get a subdirectory per site, so that sites don't prune or overwrite
+// each other's files.
+func (s siteConfig) config(cfg config) config {
+	cfg.DistDir, cfg.Origin = s.dist, s.origin
+	if s.cachePolicy != "" {
+		cfg.CachePolicyFile = s.cachePolicy
+	}
+	if s.ownDB {
+		cfg.DBPath = s.db
+	}
+	if s.publicURL != "" {
+		cfg.PublicURL = s.publicURL
+	} else {
+		// Its hostnames aren't the default site's.
+		cfg.CanonicalRedirect = false
+	}
+	cfg.OriginCache = filepath.Join(cfg.OriginCache, s.name())
+	if cfg.PrecompressDir != "" {
+		cfg.PrecompressDir = filepath.Join(cfg.PrecompressDir, s.name())
+	}
+	if s.ownDB && cfg.Analytics != "off" && cfg.Analytics != "db" {
+		// Two event stores can't append to one file.
+		path := strings.TrimPrefix(cfg.Analytics, "file:")
+		ext := filepath.Ext(path)
+		cfg.Analytics = "file:" + strings.TrimSuffix(path, ext) + "-" + s.name() + ext
+	}
+	cfg.Build = false
+	cfg.Canary, cfg.Sites = "", nil
+	return cfg
+}
+
+// site is everything served under one set of hostnames: the build, and
+// its own lobby, matchmaking queue and game API, which it may share with
+// the default site.
+type site struct {
+	name    string // "" for the default site
+	mux     *http.ServeMux
+	handler http.Handler // mux behind the session and ban middleware
+
+	distDir string
+	dist    buildHandler // nil in -dev
+	policy  *cachePolicy // nil in -dev
+	hub     *lobby.Hub
+	queue   *matchmaking.Queue
+	api     *gameAPI
+	cdn     *cdnPurger     // nil without -cdn-purge
+	canary  *canaryRollout // nil without -canary
+}
+
+// siteRouter picks the site for a request by its Host header, falling
+// back to the default site for hostnames no -site lists.
+type siteRouter struct {
+	def   *site
+	hosts map[string]*site
+	all   []*site // the default site first
+}
+
+func newSiteRouter(def *site) *siteRouter {
+	return &siteRouter{def: def, hosts: map[string]*site{}, all: []*site{def}}
+}
+
+func (s *siteRouter) add(st *site, hosts []string) {
+	for _, h := range hosts {
+		s.hosts[h] = st
+	}
+	s.all = append(s.all, st)
+}
+
+func (s *siteRouter) site(r *http.Request) *site {
+	if st, ok := s.hosts[normalizeHost(r.Host)]; ok {
+		return st
+	}
+	return s.def
+}
+
+// Handler returns the handler for r and the pattern it matched in its
+// site's mux, which is what metrics and traces label requests with.
+func (s *siteRouter) Handler(r *http.Request) (http.Handler, string) {
+	st := s.site(r)
+	_, pattern := st.mux.Handler(r)
+	return st.handler, pattern
+}
+
+func (s *siteRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.site(r).handler.ServeHTTP(w, r)
+}
+
+// registerGauges adds the multiplayer and score stream gauges, summed over
+// every site.
+func (s *siteRouter) registerGauges() {
+	lobbyStat := func(pick func(rooms, inRooms, connected int) int) func() float64 {
+		return func() float64 {
+			n := 0
+			for _, st := range s.all {
+				n += pick(st.hub.Stats())
+			}
+			return float64(n)
+		}
+	}
+	defaultRegistry.gaugeFunc("loderunner_ws_connections", "Open lobby WebSocket connections.",
+		lobbyStat(func(_, _, connected int) int { return connected }))
+	defaultRegistry.gaugeFunc("loderunner_ws_rooms", "Lobby rooms with players on this instance.",
+		lobbyStat(func(rooms, _, _ int) int { return rooms }))
+	defaultRegistry.gaugeFunc("loderunner_ws_spectators", "Spectators watching lobby broadcasts on this instance.", func() float64 {
+		n := 0
+		for _, st := range s.all {
+			n += st.hub.Spectators()
+		}
+		return float64(n)
+	})
+	defaultRegistry.gaugeFunc("loderunner_matchmaking_waiting", "Players waiting in the matchmaking queue.", func() float64 {
+		n := 0
+		for _, st := range s.all {
+			waiting, _ := st.queue.Len(context.Background())
+			n += waiting
+		}
+		return float64(n)
+	})
+	if !slices.ContainsFunc(s.apis(), func(api *gameAPI) bool { return api.db != nil }) {
+		return
+	}
+	defaultRegistry.gaugeFunc("loderunner_score_stream_clients", "Connected score stream clients.", func() float64 {
+		n := 0
+		for _, api := range s.apis() {
+			n += api.feed.clients()
+		}
+		return float64(n)
+	})
+}
+
+// apis returns each game API once; sites without a database of their own
+// share the default site's.
+func (s *siteRouter) apis() []*gameAPI {
+	var apis []*gameAPI
+	for _, st := range s.all {
+		if st.api != nil && !slices.Contains(apis, st.api) {
+			apis = append(apis, st.api)
+		}
+	}
+	return apis
+}
+
+// normalizeHost lowercases a Host header and drops its port and any
+// trailing dot.
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+)
+
+// matches lists the rooms broadcasting a match for spectators to watch.
+func (a *lobbyAPI) matches(w http.ResponseWriter, r *http.Request) {
+	matches, err := a.hub.Live(r.Context())
+	if err != nil {
+		log.Printf("lobby: live matches: %v (request %s)", err, requestID(w))
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"matches": matches})
+}
+
+// watch serves /ws?watch=CODE, a read-only socket on which a spectator
+// receives a room's broadcast. Anything the spectator sends closes it.
+func (a *lobbyAPI) watch(w http.ResponseWriter, r *http.Request, code string) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: a.origins})
+	if err != nil {
+		return // Accept has already written the error response
+	}
+	defer conn.CloseNow()
+
+	// The outbox has room for the catch-up on top of the usual backlog.
+	p := lobby.NewPlayer(randomToken(6), "", lobby.CatchUpMessages+wsOutboxSize)
+	a.hub.Connect(p)
+	defer a.hub.Disconnect(context.WithoutCancel(r.Context()), p)
+
+	ctx := conn.CloseRead(r.Context())
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if err := a.hub.Watch(ctx, code, p); err != nil {
+		if errors.Is(err, lobby.ErrUnavailable) {
+			log.Printf("lobby: watch: %v", err)
+			err = lobby.ErrUnavailable
+		}
+		wsjson.Write(ctx, conn, lobby.Message{Type: "error", Error: err.Error()})
+		conn.Close(websocket.StatusNormalClosure, "")
+		return
+	}
+	defer a.hub.Unwatch(code, p)
+	a.write(ctx, conn, p)
+}
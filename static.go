@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// precompressedEncodings lists the sibling files the build may emit next to
+// an asset, in order of preference.
+var precompressedEncodings = []struct {
+	coding string
+	suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticHandler serves the built game from fsys with caching headers,
+// preferring pre-compressed siblings when the client accepts them. Paths
+// that don't name a file fall back to index.html so client-side routes like
+// /levels/42 survive a refresh.
+type staticHandler struct {
+	fsys   fs.FS
+	policy *cachePolicy
+	etags  *etagManifest
+	errors *errorPages
+
+	// preload announces critical assets on HTML pages; nil disables it.
+	preload *preloader
+
+	// liveReload adds the live reload script to HTML pages.
+	liveReload bool
+}
+
+func newStaticHandler(fsys fs.FS, policy *cachePolicy) *staticHandler {
+	return &staticHandler{
+		fsys:   fsys,
+		policy: policy,
+		etags:  newETagManifest(),
+		errors: &errorPages{fsys: fsys},
+	}
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := fsName(r.URL.Path)
+	if !h.isFile(name) {
+		if path.Ext(name) != "" {
+			// A missing asset is a real 404; serving the game in place of
+			// a script or image only produces confusing client errors.
+			h.errors.serve(w, r, http.StatusNotFound)
+			return
+		}
+		name = "index.html"
+	}
+
+	h.policy.apply(w.Header(), name)
+	if h.preload != nil && path.Ext(name) == ".html" {
+		h.preload.announce(w, r)
+	}
+
+	if h.liveReload && path.Ext(name) == ".html" {
+		h.serveInjected(w, r, name)
+		return
+	}
+	if h.servePrecompressed(w, r, name) {
+		return
+	}
+	h.serveFile(w, r, name)
+}
+
+func (h *staticHandler) served() (fs.FS, *etagManifest) { return h.fsys, h.etags }
+
+// isFile reports whether name exists in the build as a regular file.
+func (h *staticHandler) isFile(name string) bool {
+	fi, err := fs.Stat(h.fsys, name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		h.errors.serve(w, r, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	content, ok := f.(io.ReadSeeker)
+	if err != nil || !ok {
+		h.errors.serve(w, r, http.StatusInternalServerError)
+		return
+	}
+	h.setETag(w, name, fi)
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// serveInjected serves an HTML page with the live reload script added. It
+// skips ETags and pre-compressed siblings, which describe the file as
+// built.
+func (h *staticHandler) serveInjected(w http.ResponseWriter, r *http.Request, name string) {
+	page, err := fs.ReadFile(h.fsys, name)
+	if err != nil {
+		h.errors.serve(w, r, http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(injectLiveReload(page)))
+}
+
+// fsName converts a request path into an fs.FS name, mapping the root to
+// index.html.
+func fsName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return "index.html"
+	}
+	return name
+}
+
+// servePrecompressed serves name.br or name.gz when present and accepted by
+// the client. The Content-Type is that of the original file so browsers
+// treat the decoded body correctly.
+func (h *staticHandler) servePrecompressed(w http.ResponseWriter, r *http.Request, name string) bool {
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		return false
+	}
+
+	for _, enc := range precompressedEncodings {
+		if !acceptsEncoding(r, enc.coding) {
+			continue
+		}
+		f, err := h.fsys.Open(name + enc.suffix)
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
+		content, ok := f.(io.ReadSeeker)
+		if err != nil || fi.IsDir() || !ok {
+			f.Close()
+			continue
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", enc.coding)
+		h.setETag(w, name+enc.suffix, fi)
+		http.ServeContent(w, r, name, fi.ModTime(), content)
+		return true
+	}
+	return false
+}
+
+// setETag sets a strong content-hash ETag. http.ServeContent then answers
+// If-None-Match and If-Modified-Since with 304 Not Modified.
+func (h *staticHandler) setETag(w http.ResponseWriter, name string, fi fs.FileInfo) {
+	if etag := h.etags.get(h.fsys, name, fi); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// newHTTPServer returns a server with the configured timeouts, so a client
+// that sends its request or reads the response a byte at a time can't hold
+// a connection open indefinitely. Long-lived responses lift the limits for
+// themselves with streaming.
+func newHTTPServer(cfg config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ConnState:         unanswered.track,
+	}
+}
+
+// limitConns caps the open connections on ln; further clients wait in the
+// kernel's accept queue. n <= 0 means no limit.
+func limitConns(ln net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return ln
+	}
+	return netutil.LimitListener(ln, n)
+}
+
+// limitBodies caps every request body at max bytes. Endpoints set tighter
+// limits of their own; this is the ceiling for anything that forgets and
+// for proxied routes.
+func limitBodies(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if capBody(w, r, max) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// routeBodyLimit caps the bodies of requests for a route. path is matched
+// with path.Match, or as a prefix if it ends in a slash; method "" matches
+// any method.
+type routeBodyLimit struct {
+	method string
+	path   string
+	max    int64
+}
+
+// routeBodyLimits are the game's own body limits, first match winning.
+// They mirror what each handler reads, so an oversized body is refused
+// from its Content-Length before the handler runs, and one sent without a
+// length is cut off at the limit instead of being read in full.
+var routeBodyLimits = []routeBodyLimit{
+	{"POST", "/api/levels", maxLevelBody},
+	{"PUT", "/api/scores/*/replay", maxReplayBody},
+	{"POST", "/api/saves", maxSaveSize},
+	{"PUT", "/api/saves", maxSaveSize},
+	{"POST", "/api/saves/codes", maxSaveSize},
+	{"POST", "/api/admin/levels/import", maxImportBody},
+	{"PUT", "/api/admin/config", maxConfigBody},
+	{"POST", "/admin/login", maxJSONBody},
+	{"", "/api/", maxJSONBody},
+}
+
+// limitRouteBodies applies routeBodyLimits. Requests for other routes keep
+// the -max-body-bytes ceiling alone.
+func limitRouteBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, l := range routeBodyLimits {
+			if l.matches(r) {
+				if !capBody(w, r, l.max) {
+					return
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l routeBodyLimit) matches(r *http.Request) bool {
+	if l.method != "" && l.method != r.Method {
+		return false
+	}
+	if strings.HasSuffix(l.path, "/") {
+		return strings.HasPrefix(r.URL.Path, l.path)
+	}
+	ok, _ := path.Match(l.path, r.URL.Path)
+	return ok
+}
+
+// capBody refuses r with 413 if it declares a body over max bytes, and
+// otherwise stops its body being read past max. It reports whether r may
+// go on.
+func capBody(w http.ResponseWriter, r *http.Request, max int64) bool {
+	if r.ContentLength > max {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body larger than %d bytes", max))
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+	return true
+}
+
+// streaming lifts the read deadline for a response that stays open, like
+// Server-Sent Events, and gives each write writeTimeout instead of the
+// whole response. With writeTimeout zero, writes have no deadline at all.
+// Without this the server's ReadTimeout cancels the request mid-stream.
+func streaming(w http.ResponseWriter, writeTimeout time.Duration) *http.ResponseController {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	extendWrite(rc, writeTimeout)
+	return rc
+}
+
+// extendWrite moves the write deadline writeTimeout into the future.
+func extendWrite(rc *http.ResponseController, writeTimeout time.Duration) {
+	var deadline time.Time
+	if writeTimeout > 0 {
+		deadline = time.Now().Add(writeTimeout)
+	}
+	rc.SetWriteDeadline(deadline)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	maxTournamentName = 64
+	maxBracketSize    = 64
+	maxRoundHours     = 7 * 24
+)
+
+// runTournaments draws brackets and settles finals rounds as they fall
+// due: at once, for any that did while the server was down, then every
+// minute until ctx is done.
+func runTournaments(ctx context.Context, db *storage.DB) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		advanced, err := db.AdvanceTournaments(ctx, time.Now())
+		if err != nil {
+			log.Printf("tournaments: advance: %v", err)
+		}
+		for _, t := range advanced {
+			switch t.Stage {
+			case storage.StageFinals:
+				log.Printf("🏁 %s: finals round %d started", t.Name, t.Round)
+			case storage.StageFinished:
+				log.Printf("🏁 %s: finished", t.Name)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tournamentsAPI serves /api/tournaments: listing tournaments, entering
+// them, and their qualifying standings and finals bracket. Admins create
+// them through the moderation API.
+type tournamentsAPI struct {
+	db *storage.DB
+}
+
+func (a *tournamentsAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/tournaments", a.list)
+	mux.HandleFunc("GET /api/tournaments/{id}", a.show)
+	mux.HandleFunc("GET /api/tournaments/{id}/standings", a.standings)
+	mux.HandleFunc("GET /api/tournaments/{id}/bracket", a.bracket)
+	mux.HandleFunc("POST /api/tournaments/{id}/entry", a.enter)
+	mux.HandleFunc("DELETE /api/tournaments/{id}/entry", a.withdraw)
+}
+
+func (a *tournamentsAPI) list(w http.ResponseWriter, r *http.Request) {
+	tournaments, err := a.db.Tournaments(r.Context())
+	if err != nil {
+		a.fail(w, "list tournaments", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tournaments": tournaments})
+}
+
+// tournament looks up the {id} tournament, writing an error if there is
+// none.
+func (a *tournamentsAPI) tournament(w http.ResponseWriter, r *http.Request) (storage.Tournament, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return storage.Tournament{}, false
+	}
+	t, err := a.db.GetTournament(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "tournament not found")
+		return storage.Tournament{}, false
+	}
+	if err != nil {
+		a.fail(w, "get tournament", err)
+		return storage.Tournament{}, false
+	}
+	return t, true
+}
+
+// show returns the tournament and, for a signed-in player, whether they
+// have entered it.
+func (a *tournamentsAPI) show(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.tournament(w, r)
+	if !ok {
+		return
+	}
+	resp := struct {
+		storage.Tournament
+		Entered *bool `json:"entered,omitempty"`
+	}{Tournament: t}
+	if p := currentPlayer(r); p != nil {
+		entered, err := a.db.TournamentEntered(r.Context(), t.ID, p.ID)
+		if err != nil {
+			a.fail(w, "check entry", err)
+			return
+		}
+		resp.Entered = &entered
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *tournamentsAPI) standings(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.tournament(w, r)
+	if !ok {
+		return
+	}
+	limit, err := queryInt(r, "limit", 50, 1, 100)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := queryInt(r, "offset", 0, 0, 1_000_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entrants, total, err := a.db.Standings(r.Context(), t, limit, offset)
+	if err != nil {
+		a.fail(w, "standings", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tournament": t, "standings": entrants, "total": total, "limit": limit, "offset": offset})
+}
+
+// bracketRound is a finals round for the bracket UI. Rounds not yet
+// reached have their matches with no players.
+type bracketRound struct {
+	Round    int             `json:"round"`
+	StartsAt time.Time       `json:"starts_at"`
+	EndsAt   time.Time       `json:"ends_at"`
+	Matches  []storage.Match `json:"matches"`
+}
+
+// bracket returns every round of the finals, once qualifying is over.
+func (a *tournamentsAPI) bracket(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.tournament(w, r)
+	if !ok {
+		return
+	}
+	matches, err := a.db.Bracket(r.Context(), t)
+	if err != nil {
+		a.fail(w, "bracket", err)
+		return
+	}
+	rounds := []bracketRound{}
+	first := 0 // matches in round 1
+	for _, m := range matches {
+		if m.Round == 1 {
+			first++
+		}
+	}
+	if first > 0 {
+		for n := 1; n <= bits.Len(uint(first)); n++ {
+			start, end := t.RoundWindow(n)
+			rounds = append(rounds, bracketRound{Round: n, StartsAt: start, EndsAt: end, Matches: []storage.Match{}})
+		}
+	}
+	for _, m := range matches {
+		rounds[m.Round-1].Matches = append(rounds[m.Round-1].Matches, m)
+	}
+	for i := range rounds {
+		want := first >> i
+		for slot := len(rounds[i].Matches); slot < want; slot++ {
+			rounds[i].Matches = append(rounds[i].Matches, storage.Match{Round: i + 1, Slot: slot})
+		}
+	}
+	if t.Stage == storage.StageFinished {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tournament": t, "rounds": rounds})
+}
+
+func (a *tournamentsAPI) enter(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	created, err := a.db.EnterTournament(r.Context(), id, p.ID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "tournament not found")
+	case errors.Is(err, storage.ErrRegistrationClosed):
+		writeError(w, http.StatusConflict, "qualifying is over")
+	case err != nil:
+		a.fail(w, "enter tournament", err)
+	case created:
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *tournamentsAPI) withdraw(w http.ResponseWriter, r *http.Request) {
+	p, ok := requirePlayer(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	switch err := a.db.WithdrawTournament(r.Context(), id, p.ID); {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not entered in this tournament")
+	case errors.Is(err, storage.ErrRegistrationClosed):
+		writeError(w, http.StatusConflict, "qualifying is over")
+	case err != nil:
+		a.fail(w, "withdraw", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *tournamentsAPI) fail(w http.ResponseWriter, op string, err error) {
+	log.Printf("tournaments: %s: %v (request %s)", op, err, requestID(w))
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// createTournament sets up a tournament from a body like
+// {"name": "...", "seed": "...", "starts_at": "...", "qualifying_ends_at": "..."}.
+func (a *adminAPI) createTournament(w http.ResponseWriter, r *http.Request, actor string) {
+	body := struct {
+		Name             string    `json:"name"`
+		Seed             string    `json:"seed"`
+		Difficulty       string    `json:"difficulty"`
+		Format           string    `json:"format"`
+		BracketSize      int       `json:"bracket_size"`
+		RoundHours       int       `json:"round_hours"`
+		StartsAt         time.Time `json:"starts_at"`
+		QualifyingEndsAt time.Time `json:"qualifying_ends_at"`
+	}{Difficulty: "normal", Format: storage.SingleElimination, BracketSize: 8, RoundHours: 24}
+	if err := decodeJSON(w, r, maxJSONBody, &body); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	body.Name, body.Seed = strings.TrimSpace(body.Name), strings.TrimSpace(body.Seed)
+	body.Difficulty = strings.ToLower(body.Difficulty)
+	for _, err := range []error{
+		checkText("name", body.Name, maxTournamentName, true),
+		checkText("seed", body.Seed, maxSeedLength, true),
+	} {
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	msg := ""
+	switch {
+	case !difficulties[body.Difficulty]:
+		msg = "difficulty must be easy, normal, hard or ninja"
+	case body.Format != storage.SingleElimination:
+		msg = "format must be single_elimination"
+	case body.BracketSize < 2 || body.BracketSize > maxBracketSize || bits.OnesCount(uint(body.BracketSize)) != 1:
+		msg = "bracket_size must be a power of two from 2 to 64"
+	case body.RoundHours < 1 || body.RoundHours > maxRoundHours:
+		msg = "round_hours must be between 1 and 168"
+	case body.StartsAt.IsZero() || body.QualifyingEndsAt.IsZero():
+		msg = "starts_at and qualifying_ends_at are required"
+	case !body.QualifyingEndsAt.After(body.StartsAt):
+		msg = "qualifying_ends_at must be after starts_at"
+	case !body.QualifyingEndsAt.After(time.Now()):
+		msg = "qualifying_ends_at must be in the future"
+	}
+	if msg != "" {
+		writeError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+	t, err := a.db.CreateTournament(r.Context(), storage.Tournament{
+		Name: body.Name, Seed: body.Seed, Difficulty: body.Difficulty, Format: body.Format,
+		BracketSize: body.BracketSize, RoundHours: body.RoundHours,
+		StartsAt: body.StartsAt.UTC(), QualifyingEndsAt: body.QualifyingEndsAt.UTC(), CreatedBy: actor,
+	})
+	if err != nil {
+		a.fail(w, "create tournament", err)
+		return
+	}
+	id := strconv.FormatInt(t.ID, 10)
+	if err := a.db.RecordAudit(r.Context(), storage.AuditEntry{Actor: actor, Action: "tournament.create", TargetKind: "tournament", TargetID: id, Detail: t.Name, After: auditState(t)}); err != nil {
+		a.fail(w, "record audit", err)
+		return
+	}
+	log.Printf("🛡️  %s: tournament.create tournament %s", actor, id)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// deleteTournament cancels a tournament, with its entrants and bracket.
+func (a *adminAPI) deleteTournament(w http.ResponseWriter, r *http.Request, actor string) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := readReason(w, r)
+	if !ok {
+		return
+	}
+	if !a.act(w, r, a.db.DeleteTournament(r.Context(), id), "tournament not found") {
+		return
+	}
+	a.done(w, r, storage.AuditEntry{Actor: actor, Action: "tournament.delete", TargetKind: "tournament", TargetID: strconv.FormatInt(id, 10), Detail: reason})
+}
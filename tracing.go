@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the server's own spans; storage has one of its own.
+var tracer = otel.Tracer("github.com/jgbrwn/loderunner2099")
+
+// setupTracing installs an OpenTelemetry tracer provider that batches spans
+// to the OTLP/HTTP collector at -otlp-endpoint. The returned function
+// flushes what is still queued. With no endpoint every tracer is a no-op.
+func setupTracing(cfg config, build buildInfo) (func(context.Context) error, error) {
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, the endpoint is the collector's
+	// base URL unless it names a path itself.
+	u, _ := url.Parse(cfg.OTLPEndpoint)
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	// Headers, such as a collector API key, come from
+	// OTEL_EXPORTER_OTLP_HEADERS.
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName("loderunner2099"), semconv.ServiceVersion(build.Version)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Callers that already trace, like a proxy in front, decide for
+		// their own requests.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request, named after the
+// route it matched, and continues a trace passed in traceparent. Handlers
+// and database queries below it add their spans through the request
+// context.
+func traceRequests(mux router, proxies trustedProxies, next http.Handler) http.Handler {
+	propagator := otel.GetTextMapPropagator()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := routeLabel(mux, r)
+		name := route
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		} else {
+			name = r.Method + " " + route
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(proxies.clientIP(r)),
+				semconv.NetworkPeerAddress(remoteIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				semconv.HTTPRequestHeader("x-request-id", r.Header.Get(requestIDHeader)),
+			))
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		code := sw.statusCode()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		if code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+)
+
+// A process started by an upgrade finds its listeners from fd 3 on, in the
+// order inheritListenersEnv names them, and reports that it is serving by
+// writing to inheritReadyEnv's descriptor.
+const (
+	inheritListenersEnv = "INHERIT_LISTENERS"
+	inheritReadyEnv     = "INHERIT_READY_FD"
+
+	// upgradeStartTimeout is how long the new process gets to migrate,
+	// precompress and start serving.
+	upgradeStartTimeout = 2 * time.Minute
+)
+
+// handoff tracks the sockets the server listens on so that SIGUSR2 can
+// pass them to a freshly started copy of the binary. Neither process
+// closes them in between, so no connection is refused during a deploy.
+type handoff struct {
+	inherited map[string]*os.File
+	sockets   []handedSocket
+}
+
+type handedSocket struct {
+	addr string // as given to listen, the key the new process looks up
+	file interface{ File() (*os.File, error) }
+}
+
+// inheritListeners picks up the sockets passed by the process that started
+// this one, if any.
+func inheritListeners() *handoff {
+	h := &handoff{inherited: map[string]*os.File{}}
+	for i, addr := range splitLines(os.Getenv(inheritListenersEnv)) {
+		// Keep them from leaking into npm run build.
+		closeOnExec(listenFdsStart + i)
+		h.inherited[addr] = os.NewFile(uintptr(listenFdsStart+i), addr)
+	}
+	if fd, err := strconv.Atoi(os.Getenv(inheritReadyEnv)); err == nil {
+		closeOnExec(fd)
+	}
+	return h
+}
+
+// inheritedSystemd reports whether the socket systemd passed to an earlier
+// process is among the inherited ones.
+func inheritedSystemd() bool {
+	return slices.Contains(splitLines(os.Getenv(inheritListenersEnv)), "systemd")
+}
+
+// listen is the package listen, preferring an inherited socket for addr.
+func (h *handoff) listen(addr string, socketMode fs.FileMode) (net.Listener, error) {
+	var ln net.Listener
+	if f, ok := h.inherited[addr]; ok {
+		delete(h.inherited, addr)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listen: inherited %s: %w", addr, err)
+		}
+		ln = l
+		if l.Addr().Network() == "unix" {
+			ln = localListener{l}
+		}
+	} else {
+		l, err := listen(addr, socketMode)
+		if err != nil {
+			return nil, err
+		}
+		ln = l
+	}
+
+	raw := ln
+	if l, ok := ln.(localListener); ok {
+		raw = l.Listener
+	}
+	if f, ok := raw.(interface{ File() (*os.File, error) }); ok {
+		h.sockets = append(h.sockets, handedSocket{addr, f})
+	}
+	return ln, nil
+}
+
+// listenPacket opens the UDP socket for HTTP/3 on addr, or inherits it.
+func (h *handoff) listenPacket(addr string) (net.PacketConn, error) {
+	key := "udp:" + addr
+	var pc net.PacketConn
+	if f, ok := h.inherited[key]; ok {
+		delete(h.inherited, key)
+		c, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listen: inherited %s: %w", key, err)
+		}
+		pc = c
+	} else {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		pc = c
+	}
+	if f, ok := pc.(interface{ File() (*os.File, error) }); ok {
+		h.sockets = append(h.sockets, handedSocket{key, f})
+	}
+	return pc, nil
+}
+
+// ready closes inherited sockets the new configuration no longer uses and
+// tells the process that started this one to step aside.
+func (h *handoff) ready() {
+	for addr, f := range h.inherited {
+		log.Printf("⚠️  Closing inherited listener %s, which is no longer configured", addr)
+		f.Close()
+	}
+	h.inherited = nil
+
+	fd, err := strconv.Atoi(os.Getenv(inheritReadyEnv))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(inheritReadyEnv)
+	f := os.NewFile(uintptr(fd), "upgrade ready")
+	f.Write([]byte{1})
+	f.Close()
+	// systemd would otherwise take the old process exiting for the
+	// service stopping.
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d", os.Getpid())); err != nil {
+		log.Printf("upgrade: notify systemd: %v", err)
+	}
+}
+
+// upgrade starts the binary again with the listening sockets and returns
+// its pid once it is serving. On error the new process is gone and this
+// one carries on as before.
+func (h *handoff) upgrade() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		files []*os.File
+		addrs []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, s := range h.sockets {
+		f, err := s.file.File()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.addr, err)
+		}
+		files = append(files, f)
+		addrs = append(addrs, s.addr)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(withoutEnv(os.Environ(), inheritListenersEnv, inheritReadyEnv),
+		inheritListenersEnv+"="+strings.Join(addrs, "\n"),
+		inheritReadyEnv+"="+strconv.Itoa(listenFdsStart+len(files)))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	readyc := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			err = errors.New("new process exited before it was ready")
+		}
+		readyc <- err
+	}()
+	select {
+	case err = <-readyc:
+	case <-time.After(upgradeStartTimeout):
+		err = fmt.Errorf("new process not ready after %s", upgradeStartTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return 0, err
+	}
+
+	// The listeners now belong to both processes. Closing ours mustn't
+	// delete the socket file the new process is accepting on.
+	for _, s := range h.sockets {
+		if ul, ok := s.file.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return cmd.Process.Pid, nil
+}
+
+// stopAccepting closes the listeners, which the new process accepts on
+// from now on, and waits up to timeout for connections this one already
+// accepted to send their request. Shutdown would hang up on them.
+func (h *handoff) stopAccepting(timeout time.Duration) {
+	for _, s := range h.sockets {
+		if ln, ok := s.file.(net.Listener); ok {
+			ln.Close()
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// Also gives Serve a moment to track a connection accepted just
+		// before its listener closed.
+		time.Sleep(50 * time.Millisecond)
+		if unanswered.count() == 0 {
+			return
+		}
+	}
+}
+
+// unanswered tracks connections that haven't sent a request yet.
+var unanswered = &connTracker{conns: map[net.Conn]struct{}{}}
+
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// track is an http.Server ConnState hook.
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state == http.StateNew {
+		t.conns[c] = struct{}{}
+	} else {
+		delete(t.conns, c)
+	}
+}
+
+func (t *connTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// finishMatches waits up to timeout for the players still in a room here
+// to finish their match, after the listeners have passed to the new
+// process.
+func finishMatches(hubs []*lobby.Hub, timeout time.Duration) {
+	// Players between matches reconnect to the new process.
+	for _, hub := range hubs {
+		hub.KickIdle("server restarting")
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		inRooms := 0
+		for _, hub := range hubs {
+			_, n, _ := hub.Stats()
+			inRooms += n
+		}
+		if inRooms == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("⚠️  Ending %d players' matches after %s", inRooms, timeout)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// sdNotify sends a state change to systemd when it runs the server with
+// NotifyAccess set; otherwise it does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	c, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+func withoutEnv(env []string, keys ...string) []string {
+	var out []string
+outer:
+	for _, kv := range env {
+		for _, k := range keys {
+			if strings.HasPrefix(kv, k+"=") {
+				continue outer
+			}
+		}
+		out = append(out, kv)
+	}
+	return out
+}
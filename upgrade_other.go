@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyUpgrade does nothing: there is no SIGUSR2, so no handoff.
+func notifyUpgrade(c chan<- os.Signal) {}
+
+func closeOnExec(fd int) {}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jgbrwn/loderunner2099/internal/replay"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+// verifyPollInterval is how often the verifier rescans for pending scores
+// when nothing has nudged it, which also picks up work left over from a
+// previous run.
+const verifyPollInterval = 30 * time.Second
+
+var replayVerifications = defaultRegistry.counter("loderunner_replay_verifications_total",
+	"Replay verifications by outcome.", "result")
+
+// replayVerifier checks uploaded replays in the background. The database
+// is the queue: scores with a replay sit in status pending until a worker
+// marks them verified or rejected, so nothing is lost on restart.
+type replayVerifier struct {
+	db      *storage.DB
+	workers int
+	wake    chan struct{}
+	wg      sync.WaitGroup
+
+	mu   sync.Mutex
+	busy map[int64]bool // claimed by a worker
+}
+
+func newReplayVerifier(db *storage.DB, workers int) *replayVerifier {
+	return &replayVerifier{db: db, workers: workers, wake: make(chan struct{}, 1), busy: map[int64]bool{}}
+}
+
+// notify tells the verifier new work is pending without blocking.
+func (v *replayVerifier) notify() {
+	select {
+	case v.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start runs the dispatcher and workers until ctx is done.
+func (v *replayVerifier) start(ctx context.Context) {
+	jobs := make(chan int64)
+	for range v.workers {
+		v.wg.Go(func() {
+			for id := range jobs {
+				v.verify(ctx, id)
+				v.release(id)
+			}
+		})
+	}
+	v.wg.Go(func() {
+		defer close(jobs)
+		ticker := time.NewTicker(verifyPollInterval)
+		defer ticker.Stop()
+		for {
+			v.dispatch(ctx, jobs)
+			select {
+			case <-ctx.Done():
+				return
+			case <-v.wake:
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// wait blocks until every worker has exited.
+func (v *replayVerifier) wait() {
+	v.wg.Wait()
+}
+
+// dispatch hands out every pending score once, skipping any a worker is
+// still busy with.
+func (v *replayVerifier) dispatch(ctx context.Context, jobs chan<- int64) {
+	var after int64
+	for ctx.Err() == nil {
+		ids, err := v.db.PendingScores(ctx, after, 100)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("verify: list pending: %v", err)
+			}
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+		for _, id := range ids {
+			after = id
+			if !v.claim(id) {
+				continue
+			}
+			select {
+			case jobs <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (v *replayVerifier) claim(id int64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.busy[id] {
+		return false
+	}
+	v.busy[id] = true
+	return true
+}
+
+func (v *replayVerifier) release(id int64) {
+	v.mu.Lock()
+	delete(v.busy, id)
+	v.mu.Unlock()
+}
+
+func (v *replayVerifier) verify(ctx context.Context, id int64) {
+	entry, err := v.db.Score(ctx, id)
+	if err != nil {
+		v.fail(ctx, id, "get score", err)
+		return
+	}
+	rp, err := v.db.GetReplay(ctx, id)
+	if err != nil {
+		v.fail(ctx, id, "get replay", err)
+		return
+	}
+
+	status, reason := storage.StatusVerified, ""
+	frames, err := replay.Decode(rp.Format, rp.Data, maxReplayInflated)
+	if err == nil {
+		err = replay.Check(replay.Run{
+			Level:      entry.Level,
+			Difficulty: entry.Difficulty,
+			Score:      entry.Score,
+			Time:       time.Duration(entry.Time) * time.Millisecond,
+			Ticks:      rp.Ticks,
+		}, frames)
+	}
+	if err != nil {
+		status, reason = storage.StatusRejected, err.Error()
+		log.Printf("verify: score %d rejected: %s", id, reason)
+	}
+	if err := v.db.SetScoreStatus(ctx, id, status, reason); err != nil {
+		v.fail(ctx, id, "set status", err)
+		return
+	}
+	replayVerifications.inc(status)
+	if status == storage.StatusVerified && entry.PlayerID != 0 {
+		payRun(ctx, v.db, entry)
+	}
+}
+
+// fail handles storage errors. A vanished score or replay can never be
+// verified, so it is rejected rather than retried forever.
+func (v *replayVerifier) fail(ctx context.Context, id int64, op string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	log.Printf("verify: score %d: %s: %v", id, op, err)
+	if errors.Is(err, storage.ErrNotFound) {
+		v.db.SetScoreStatus(ctx, id, storage.StatusRejected, "replay missing")
+		replayVerifications.inc(storage.StatusRejected)
+		return
+	}
+	replayVerifications.inc("error")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is set at link time: go build -ldflags "-X main.version=v1.2.3".
+var version string
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Commit = s.Value
+			case "vcs.time":
+				b.BuildTime = s.Value
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if b.Version == "" || b.Version == "(devel)" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+// distInfo identifies the game build being served. Hash is the content
+// hash of index.html, which names every hashed bundle, so it changes
+// exactly when a new build goes live.
+type distInfo struct {
+	Hash     string     `json:"hash,omitempty"`
+	BuiltAt  *time.Time `json:"built_at,omitempty"`
+	Embedded bool       `json:"embedded"`
+}
+
+type versionResponse struct {
+	buildInfo
+	Dist *distInfo `json:"dist,omitempty"`
+}
+
+// versionAPI serves /api/version. dist is nil in -dev, where there is no
+// build to describe.
+type versionAPI struct {
+	build    buildInfo
+	dist     buildHandler
+	embedded bool
+}
+
+func (a *versionAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/version", a.show)
+}
+
+func (a *versionAPI) show(w http.ResponseWriter, r *http.Request) {
+	resp := versionResponse{buildInfo: a.build}
+	if a.dist != nil {
+		resp.Dist = &distInfo{Embedded: a.embedded}
+		fsys, etags := a.dist.served()
+		if fi, err := fs.Stat(fsys, "index.html"); err == nil {
+			resp.Dist.Hash = strings.Trim(etags.get(fsys, "index.html", fi), `"`)
+			if !a.embedded {
+				built := fi.ModTime().UTC()
+				resp.Dist.BuiltAt = &built
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
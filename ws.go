@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/jgbrwn/loderunner2099/internal/ephemeral"
+	"github.com/jgbrwn/loderunner2099/internal/lobby"
+	"github.com/jgbrwn/loderunner2099/internal/moderation"
+	"github.com/jgbrwn/loderunner2099/internal/storage"
+)
+
+const (
+	wsPingInterval = 20 * time.Second
+	wsPongTimeout  = 10 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsMaxMessage   = 16 << 10
+	wsOutboxSize   = 64
+)
+
+// clientMessage is what players send over /ws.
+type clientMessage struct {
+	Type  string          `json:"type"`
+	Code  string          `json:"code,omitempty"`
+	Token string          `json:"token,omitempty"`
+	To    string          `json:"to,omitempty"`
+	Text  string          `json:"text,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// lobbyAPI serves the /ws multiplayer lobby: clients create or join rooms
+// by code and relay opaque messages to each other. Game state never passes
+// through the server's hands in any interpreted form. Signed-in players
+// can chat in their room too; see chat.go.
+type lobbyAPI struct {
+	hub     *lobby.Hub
+	origins []string    // other origins pages may connect from
+	db      *storage.DB // nil without a database, and so without chat
+	store   ephemeral.Store
+	filter  *contentFilter
+}
+
+func (a *lobbyAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /ws", a.serve)
+	mux.HandleFunc("GET /api/matches", a.matches)
+}
+
+func (a *lobbyAPI) serve(w http.ResponseWriter, r *http.Request) {
+	if code := r.URL.Query().Get("watch"); code != "" {
+		a.watch(w, r, code)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "PLAYER"
+	}
+	if err := checkText("name", name, maxPlayerName, true); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.filter.check(r.Context(), moderation.Name, name) {
+		writeError(w, http.StatusBadRequest, "name isn't allowed; try something else")
+		return
+	}
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: a.origins})
+	if err != nil {
+		return // Accept has already written the error response
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(wsMaxMessage)
+
+	account := currentPlayer(r)
+	p := lobby.NewPlayer(randomToken(6), name, wsOutboxSize)
+	a.hub.Connect(p)
+	defer func() {
+		// The request's context may be gone, but the room still needs
+		// telling.
+		if err := a.hub.Disconnect(context.WithoutCancel(r.Context()), p); err != nil {
+			log.Printf("lobby: disconnect: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		defer cancel()
+		a.write(ctx, conn, p)
+	}()
+
+	p.Send(lobby.Message{Type: "welcome", Player: &lobby.PlayerInfo{ID: p.ID, Name: p.Name}})
+	for {
+		var msg clientMessage
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			break
+		}
+		if err := a.handle(ctx, p, account, msg); err != nil {
+			if errors.Is(err, lobby.ErrUnavailable) {
+				log.Printf("lobby: %s: %v", msg.Type, err)
+				err = lobby.ErrUnavailable
+			}
+			p.Send(lobby.Message{Type: "error", Error: err.Error()})
+		}
+	}
+	cancel()
+	<-written
+}
+
+// handle applies one client message.
+func (a *lobbyAPI) handle(ctx context.Context, p *lobby.Player, account *storage.Player, msg clientMessage) error {
+	switch msg.Type {
+	case "create":
+		_, err := a.hub.Create(ctx, p)
+		return err
+	case "join":
+		var room *lobby.Room
+		var err error
+		if msg.Token != "" {
+			room, err = a.hub.JoinToken(ctx, msg.Token, p)
+		} else {
+			room, err = a.hub.Join(ctx, strings.ToUpper(strings.TrimSpace(msg.Code)), p)
+		}
+		if err == nil {
+			a.sendChatHistory(ctx, p, room)
+		}
+		return err
+	case "chat":
+		return a.chat(ctx, p, account, msg.Text)
+	case "leave":
+		return a.hub.Leave(ctx, p)
+	case "relay":
+		room := p.Room()
+		if room == nil {
+			return lobby.ErrNotInRoom
+		}
+		return room.Relay(ctx, p, msg.To, "relay", msg.Data)
+	case "broadcast":
+		room := p.Room()
+		if room == nil {
+			return lobby.ErrNotInRoom
+		}
+		if len(msg.Data) == 0 {
+			return errors.New("broadcast needs data")
+		}
+		return room.Broadcast(ctx, p, msg.Data)
+	case "offer", "answer", "ice":
+		room := p.Room()
+		if room == nil {
+			return lobby.ErrNotInRoom
+		}
+		if err := checkSignal(msg); err != nil {
+			return err
+		}
+		return room.Relay(ctx, p, msg.To, msg.Type, msg.Data)
+	case "":
+		return errors.New("message type is required")
+	}
+	return errors.New("unknown message type " + msg.Type)
+}
+
+// write drains the player's outbox to the socket and keeps the connection
+// alive with pings, until the context ends or the player is kicked. A
+// kicked player is sent what was already queued, such as a spectator's
+// "ended", before the socket closes.
+func (a *lobbyAPI) write(ctx context.Context, conn *websocket.Conn, p *lobby.Player) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.Kicked():
+			wctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			for len(p.Outbox()) > 0 && conn.Write(wctx, websocket.MessageText, <-p.Outbox()) == nil {
+			}
+			cancel()
+			conn.Close(websocket.StatusGoingAway, p.Reason())
+			return
+		case b := <-p.Outbox():
+			wctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := conn.Write(wctx, websocket.MessageText, b)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			pctx, cancel := context.WithTimeout(ctx, wsPongTimeout)
+			err := conn.Ping(pctx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}